@@ -0,0 +1,75 @@
+package addresses
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// countryNames maps the lower-cased names people write in addresses to ISO 3166-1 alpha-2 codes.
+// The list covers the countries with known postal formats; other countries are entered by code.
+var countryNames = map[string]string{
+	"australia":                "AU",
+	"brazil":                   "BR",
+	"brasil":                   "BR",
+	"canada":                   "CA",
+	"switzerland":              "CH",
+	"schweiz":                  "CH",
+	"suisse":                   "CH",
+	"germany":                  "DE",
+	"deutschland":              "DE",
+	"spain":                    "ES",
+	"españa":                   "ES",
+	"france":                   "FR",
+	"united kingdom":           "GB",
+	"uk":                       "GB",
+	"great britain":            "GB",
+	"england":                  "GB",
+	"scotland":                 "GB",
+	"wales":                    "GB",
+	"northern ireland":         "GB",
+	"ireland":                  "IE",
+	"india":                    "IN",
+	"italy":                    "IT",
+	"italia":                   "IT",
+	"japan":                    "JP",
+	"netherlands":              "NL",
+	"the netherlands":          "NL",
+	"holland":                  "NL",
+	"nederland":                "NL",
+	"sweden":                   "SE",
+	"sverige":                  "SE",
+	"united states":            "US",
+	"united states of america": "US",
+	"usa":                      "US",
+	"u.s.a.":                   "US",
+	"us":                       "US",
+}
+
+var countryCodePattern = regexp.MustCompile(`^[A-Z]{2}$`)
+
+// NormalizeCountry returns the ISO code of a country given by name or code, e.g. "Deutschland"
+// or "de" become "DE". Unknown names are rejected; any two letter code is accepted.
+func NormalizeCountry(country string) (string, error) {
+	country = strings.TrimSpace(country)
+	if country == "" {
+		return "", nil
+	}
+
+	if code, ok := countryNames[strings.ToLower(country)]; ok {
+		return code, nil
+	}
+
+	if code := strings.ToUpper(country); countryCodePattern.MatchString(code) {
+		return code, nil
+	}
+
+	return "", errors.New("unknown country " + country + ", use its ISO 3166-1 alpha-2 code")
+}
+
+// lookupCountry returns the code of a country written out in an address, or "" when part does
+// not name a country. Bare codes are not recognized: "CA" or "IN" at the end of an address are
+// more likely a state than Canada or India.
+func lookupCountry(part string) string {
+	return countryNames[strings.ToLower(strings.TrimSpace(part))]
+}
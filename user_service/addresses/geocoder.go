@@ -0,0 +1,58 @@
+package addresses
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// ErrNoLocation is returned by a Geocoder that found no location for an address.
+var ErrNoLocation = errors.New("no location found for the address")
+
+// Location is a point on the map in decimal degrees.
+type Location struct {
+	Latitude  float64
+	Longitude float64
+}
+
+// Geocoder finds the location of an address. Implementations calling an external service must be
+// safe for concurrent use and return ErrNoLocation, not an error, for addresses they do not know.
+type Geocoder interface {
+	Geocode(a *repositories.Address) (*Location, error)
+}
+
+// StaticGeocoder is an offline Geocoder looking addresses up in a fixed table, by postal code
+// first and by locality second. It stands in for a geocoding service in development and tests.
+type StaticGeocoder struct {
+	Locations map[string]Location
+}
+
+// NewStaticGeocoder returns an empty StaticGeocoder.
+func NewStaticGeocoder() *StaticGeocoder {
+	return &StaticGeocoder{Locations: map[string]Location{}}
+}
+
+// placeKey returns the key of a postal code or locality of a country in the table.
+func placeKey(countryCode, place string) string {
+	return countryCode + "|" + strings.ToLower(place)
+}
+
+// Add records the location of a postal code or locality of a country.
+func (g *StaticGeocoder) Add(countryCode, place string, location Location) {
+	g.Locations[placeKey(countryCode, place)] = location
+}
+
+// Geocode returns the location recorded for the postal code or, failing that, the locality of an address.
+func (g *StaticGeocoder) Geocode(a *repositories.Address) (*Location, error) {
+	for _, place := range []string{a.PostalCode, a.Locality} {
+		if place == "" {
+			continue
+		}
+		if location, ok := g.Locations[placeKey(a.CountryCode, place)]; ok {
+			return &location, nil
+		}
+	}
+
+	return nil, ErrNoLocation
+}
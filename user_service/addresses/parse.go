@@ -0,0 +1,118 @@
+package addresses
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// regionBeforePostalCode lists the countries writing the region as a code in front of the postal
+// code, as in "Mountain View, CA 94043".
+var regionBeforePostalCode = map[string]bool{"US": true, "CA": true, "AU": true}
+
+var regionCodePattern = regexp.MustCompile(`^[A-Z]{2,3}$`)
+
+var hasDigit = regexp.MustCompile(`\d`)
+
+// Parse splits a free-text address into its parts. Lines and commas separate the parts of an
+// address: the first is the street, a trailing country name sets the country, defaultCountry
+// being used otherwise. The postal code is searched for from the end, using the format of the
+// country; what is left of its part is the locality, or the part before it when nothing is left,
+// and the parts after it are the region. For countries writing the region as a code in front of
+// the postal code, such as "CA 94043", that code is the region. Without a postal code the last
+// part is the locality. Parts between the street and the locality are kept with the street.
+//
+// Parse does not validate the result; see Normalize.
+func Parse(text, defaultCountry string) (*repositories.Address, error) {
+	parts := []string{}
+	for _, line := range strings.Split(text, "\n") {
+		for _, part := range strings.Split(line, ",") {
+			if part = strings.Join(strings.Fields(part), " "); part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+
+	if len(parts) == 0 {
+		return nil, errors.New("address is empty")
+	}
+
+	a := &repositories.Address{CountryCode: defaultCountry}
+	if len(parts) > 1 {
+		if code := lookupCountry(parts[len(parts)-1]); code != "" {
+			a.CountryCode = code
+			parts = parts[:len(parts)-1]
+		}
+	}
+
+	street, rest := []string{parts[0]}, parts[1:]
+
+	postalIndex := -1
+	for i := len(rest) - 1; i >= 0; i-- {
+		code, leftover, ok := findPostalCode(a.CountryCode, rest[i])
+		if ok {
+			a.PostalCode, rest[i], postalIndex = code, leftover, i
+			break
+		}
+	}
+
+	switch {
+	case postalIndex >= 0:
+		locality, regions := rest[postalIndex], rest[postalIndex+1:]
+		if regionBeforePostalCode[a.CountryCode] {
+			fields := strings.Fields(locality)
+			if n := len(fields); n > 0 && regionCodePattern.MatchString(fields[n-1]) {
+				regions = append([]string{fields[n-1]}, regions...)
+				locality = strings.Join(fields[:n-1], " ")
+			}
+		}
+		a.Region = strings.Join(regions, ", ")
+
+		before := rest[:postalIndex]
+		if locality == "" && len(before) > 0 {
+			locality, before = before[len(before)-1], before[:len(before)-1]
+		}
+		a.Locality = locality
+		street = append(street, before...)
+	case len(rest) > 0:
+		a.Locality = rest[len(rest)-1]
+		street = append(street, rest[:len(rest)-1]...)
+	}
+
+	a.Street = strings.Join(street, ", ")
+
+	return a, nil
+}
+
+// findPostalCode looks for a postal code of the country among the words of part, preferring the
+// rightmost match, and returns it normalized together with the remaining words. Codes written as
+// two words, like "SW1A 1AA", are only recognized for countries with a known format.
+func findPostalCode(countryCode, part string) (string, string, bool) {
+	words := strings.Fields(part)
+
+	sizes := []int{1}
+	if _, ok := postalFormats[countryCode]; ok {
+		sizes = []int{2, 1}
+	}
+
+	for _, size := range sizes {
+		for start := len(words) - size; start >= 0; start-- {
+			candidate := strings.Join(words[start:start+size], " ")
+			if !hasDigit.MatchString(candidate) {
+				continue
+			}
+
+			code := NormalizePostalCode(countryCode, candidate)
+			if ValidatePostalCode(countryCode, code) != nil {
+				continue
+			}
+
+			leftover := append(append([]string{}, words[:start]...), words[start+size:]...)
+			return code, strings.Join(leftover, " "), true
+		}
+	}
+
+	return "", part, false
+}
@@ -0,0 +1,91 @@
+// Package addresses turns free-text postal addresses into structured contact addresses: it parses
+// them into their parts, normalizes and validates postal codes per country and geocodes them.
+package addresses
+
+import (
+	"errors"
+	"regexp"
+	"strings"
+)
+
+// postalFormat describes the postal codes of a country. pattern matches a normalized code;
+// normalize brings user input into that form.
+type postalFormat struct {
+	pattern   *regexp.Regexp
+	normalize func(code string) string
+}
+
+// compact removes spaces and dashes.
+func compact(code string) string {
+	return strings.NewReplacer(" ", "", "-", "").Replace(code)
+}
+
+// splitAt inserts sep before the last n characters of a compacted code.
+func splitAt(n int, sep string) func(string) string {
+	return func(code string) string {
+		code = compact(code)
+		if len(code) <= n {
+			return code
+		}
+		return code[:len(code)-n] + sep + code[len(code)-n:]
+	}
+}
+
+// asIs only trims the code.
+func asIs(code string) string {
+	return code
+}
+
+var postalFormats = map[string]*postalFormat{
+	"AU": {regexp.MustCompile(`^\d{4}$`), compact},
+	"BR": {regexp.MustCompile(`^\d{5}-\d{3}$`), splitAt(3, "-")},
+	"CA": {regexp.MustCompile(`^[ABCEGHJ-NPRSTVXY]\d[A-Z] \d[A-Z]\d$`), splitAt(3, " ")},
+	"CH": {regexp.MustCompile(`^\d{4}$`), compact},
+	"DE": {regexp.MustCompile(`^\d{5}$`), compact},
+	"ES": {regexp.MustCompile(`^(0[1-9]|[1-4]\d|5[0-2])\d{3}$`), compact},
+	"FR": {regexp.MustCompile(`^\d{5}$`), compact},
+	"GB": {regexp.MustCompile(`^[A-Z]{1,2}\d[A-Z\d]? \d[A-Z]{2}$`), splitAt(3, " ")},
+	"IE": {regexp.MustCompile(`^[AC-FHKNPRTV-Y]\d[\dW] [AC-FHKNPRTV-Y\d]{4}$`), splitAt(4, " ")},
+	"IN": {regexp.MustCompile(`^[1-9]\d{5}$`), compact},
+	"IT": {regexp.MustCompile(`^\d{5}$`), compact},
+	"JP": {regexp.MustCompile(`^\d{3}-\d{4}$`), splitAt(4, "-")},
+	"NL": {regexp.MustCompile(`^[1-9]\d{3} [A-Z]{2}$`), splitAt(2, " ")},
+	"SE": {regexp.MustCompile(`^\d{3} \d{2}$`), splitAt(2, " ")},
+	"US": {regexp.MustCompile(`^\d{5}(-\d{4})?$`), asIs},
+}
+
+// genericPostalCode accepts the codes of countries without a known format.
+var genericPostalCode = regexp.MustCompile(`^[A-Z0-9][A-Z0-9 -]{1,9}$`)
+
+// NormalizePostalCode brings a postal code into the canonical form of its country, e.g.
+// "sw1a1aa" becomes "SW1A 1AA" for GB. Codes of unknown countries are only trimmed and
+// upper-cased.
+func NormalizePostalCode(countryCode, code string) string {
+	code = strings.ToUpper(strings.Join(strings.Fields(code), " "))
+	if f, ok := postalFormats[countryCode]; ok {
+		return f.normalize(code)
+	}
+	return code
+}
+
+// ValidatePostalCode checks a normalized postal code against the format of its country. Empty
+// codes are valid: not every address has one.
+func ValidatePostalCode(countryCode, code string) error {
+	if code == "" {
+		return nil
+	}
+
+	pattern := genericPostalCode
+	if f, ok := postalFormats[countryCode]; ok {
+		pattern = f.pattern
+	}
+
+	if !pattern.MatchString(code) {
+		if countryCode == "" {
+			return errors.New("postal code " + code + " is invalid")
+		}
+		return errors.New("postal code " + code + " is invalid for " + countryCode)
+	}
+
+	return nil
+}
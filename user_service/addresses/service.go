@@ -0,0 +1,81 @@
+package addresses
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Normalize cleans up an address in place: it trims the parts, resolves the country to its ISO
+// code, brings the postal code into the form of the country and validates it. Addresses without a
+// label are home addresses.
+func Normalize(a *repositories.Address) error {
+	a.Label = strings.ToLower(strings.TrimSpace(a.Label))
+	switch a.Label {
+	case "":
+		a.Label = repositories.AddressHome
+	case repositories.AddressHome, repositories.AddressWork, repositories.AddressOther:
+	default:
+		return errors.New("label must be one of home, work or other")
+	}
+
+	a.Street = strings.TrimSpace(a.Street)
+	a.Locality = strings.TrimSpace(a.Locality)
+	a.Region = strings.TrimSpace(a.Region)
+
+	code, err := NormalizeCountry(a.CountryCode)
+	if err != nil {
+		return err
+	}
+	a.CountryCode = code
+
+	a.PostalCode = NormalizePostalCode(a.CountryCode, a.PostalCode)
+	if err := ValidatePostalCode(a.CountryCode, a.PostalCode); err != nil {
+		return err
+	}
+
+	if a.Street == "" && a.Locality == "" && a.PostalCode == "" {
+		return errors.New("address needs at least a street, locality or postal code")
+	}
+
+	return nil
+}
+
+// Service stores contact addresses, normalized and geocoded.
+type Service struct {
+	Repo     repositories.AddressRepository
+	Geocoder Geocoder
+
+	// OnError is called with geocoding errors, if set. An address is stored without a location
+	// when the geocoder fails.
+	OnError func(error)
+}
+
+// NewService returns a new Service.
+func NewService(repo repositories.AddressRepository, geocoder Geocoder) *Service {
+	return &Service{Repo: repo, Geocoder: geocoder}
+}
+
+// Save normalizes and geocodes an address and creates it, or updates it when it has an ID.
+// Permission checks are left to the caller.
+func (s *Service) Save(ctx context.Context, a *repositories.Address) error {
+	if err := Normalize(a); err != nil {
+		return err
+	}
+
+	a.Latitude, a.Longitude = nil, nil
+	location, err := s.Geocoder.Geocode(a)
+	switch {
+	case err == nil:
+		a.Latitude, a.Longitude = &location.Latitude, &location.Longitude
+	case !errors.Is(err, ErrNoLocation) && s.OnError != nil:
+		s.OnError(err)
+	}
+
+	if a.ID == 0 {
+		return s.Repo.Create(ctx, a)
+	}
+	return s.Repo.Update(ctx, a)
+}
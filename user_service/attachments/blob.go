@@ -0,0 +1,106 @@
+// Package attachments stores contact avatars and documents. File contents live in a BlobStore,
+// their metadata in the attachments table.
+package attachments
+
+import (
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// ErrBlobNotFound is returned by BlobStore.Get for unknown keys.
+var ErrBlobNotFound = errors.New("blob not found")
+
+// BlobStore stores opaque blobs under slash separated keys such as "contacts/42/9f86d081".
+// Implementations must be safe for concurrent use.
+type BlobStore interface {
+	// Put stores the content of r under key, replacing any blob stored under it before.
+	Put(key string, r io.Reader) error
+	// Get opens the blob stored under key. The caller closes it.
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the blob stored under key. Deleting an unknown key is not an error.
+	Delete(key string) error
+}
+
+// validKey matches the keys accepted by FileStore; it keeps keys inside the root directory.
+var validKey = regexp.MustCompile(`^[a-z0-9_-]+(/[a-z0-9_.-]+)*$`)
+
+// FileStore is a BlobStore keeping each blob in a file below Root.
+type FileStore struct {
+	Root string
+}
+
+// NewFileStore returns a FileStore below root, creating the directory if needed.
+func NewFileStore(root string) (*FileStore, error) {
+	if err := os.MkdirAll(root, 0o750); err != nil {
+		return nil, err
+	}
+	return &FileStore{Root: root}, nil
+}
+
+func (s *FileStore) path(key string) (string, error) {
+	if !validKey.MatchString(key) || filepath.Clean(key) != filepath.FromSlash(key) {
+		return "", errors.New("invalid blob key " + key)
+	}
+	return filepath.Join(s.Root, filepath.FromSlash(key)), nil
+}
+
+// Put writes the blob to a temporary file first and renames it into place, so that readers
+// never see a partially written blob.
+func (s *FileStore) Put(key string, r io.Reader) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), path)
+}
+
+// Get opens the file of a blob.
+func (s *FileStore) Get(key string) (io.ReadCloser, error) {
+	path, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrBlobNotFound
+	}
+	return f, err
+}
+
+// Delete removes the file of a blob.
+func (s *FileStore) Delete(key string) error {
+	path, err := s.path(key)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
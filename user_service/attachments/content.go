@@ -0,0 +1,65 @@
+package attachments
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Size limits of uploaded files.
+const (
+	MaxAvatarSize   = 5 << 20
+	MaxDocumentSize = 10 << 20
+)
+
+// avatarTypes are the content types accepted for avatars, all of which can be thumbnailed.
+var avatarTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+// documentTypes are the content types accepted for documents. Office documents sniff as zip
+// archives. Markup is rejected so that a download can never be rendered as a page.
+var documentTypes = map[string]bool{
+	"image/jpeg":               true,
+	"image/png":                true,
+	"image/gif":                true,
+	"image/webp":               true,
+	"application/pdf":          true,
+	"application/zip":          true,
+	"text/plain":               true,
+	"application/octet-stream": true,
+}
+
+// MaxSize returns the size limit of an attachment kind.
+func MaxSize(kind string) int64 {
+	if kind == repositories.AttachmentAvatar {
+		return MaxAvatarSize
+	}
+	return MaxDocumentSize
+}
+
+// DetectContentType sniffs the content type of a file from its first bytes, ignoring whatever
+// the client claimed, and checks that it is accepted for the attachment kind.
+func DetectContentType(kind string, data []byte) (string, error) {
+	contentType := http.DetectContentType(data)
+	mediaType := strings.TrimSpace(strings.Split(contentType, ";")[0])
+
+	switch kind {
+	case repositories.AttachmentAvatar:
+		if !avatarTypes[mediaType] {
+			return "", errors.New("avatars must be JPEG, PNG or GIF images")
+		}
+	case repositories.AttachmentDocument:
+		if !documentTypes[mediaType] {
+			return "", errors.New("unsupported document type " + mediaType)
+		}
+	default:
+		return "", errors.New("kind must be either avatar or document")
+	}
+
+	return contentType, nil
+}
@@ -0,0 +1,181 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Service stores attachments: the content goes to Store, the metadata to Repo.
+type Service struct {
+	Store BlobStore
+	Repo  repositories.AttachmentRepository
+}
+
+// NewService returns a new Service.
+func NewService(store BlobStore, repo repositories.AttachmentRepository) *Service {
+	return &Service{Store: store, Repo: repo}
+}
+
+// Upload reads a file of at most MaxSize(kind) bytes and stores it for a contact. Uploading an
+// avatar replaces the previous one and generates its thumbnail. Permission checks are left to
+// the caller.
+func (s *Service) Upload(ctx context.Context, contactID, userID int, kind, fileName string, r io.Reader) (*repositories.Attachment, error) {
+	limit := MaxSize(kind)
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) == 0 {
+		return nil, errors.New("file is empty")
+	}
+
+	if int64(len(data)) > limit {
+		return nil, errors.New("file exceeds the size limit of " + strconv.FormatInt(limit>>20, 10) + " MB")
+	}
+
+	contentType, err := DetectContentType(kind, data)
+	if err != nil {
+		return nil, err
+	}
+
+	a := &repositories.Attachment{
+		ContactID:   contactID,
+		UserID:      userID,
+		Kind:        kind,
+		FileName:    cleanFileName(fileName),
+		ContentType: contentType,
+		Size:        int64(len(data)),
+	}
+
+	a.BlobKey, err = newBlobKey(contactID)
+	if err != nil {
+		return nil, err
+	}
+
+	var thumbnail []byte
+	if kind == repositories.AttachmentAvatar {
+		if thumbnail, err = Thumbnail(data); err != nil {
+			if errors.Is(err, ErrImageTooLarge) {
+				return nil, err
+			}
+			return nil, errors.New("avatar is not a valid image")
+		}
+		a.ThumbnailKey = a.BlobKey + ".thumb"
+	}
+
+	if err := s.Store.Put(a.BlobKey, bytes.NewReader(data)); err != nil {
+		return nil, err
+	}
+
+	if thumbnail != nil {
+		if err := s.Store.Put(a.ThumbnailKey, bytes.NewReader(thumbnail)); err != nil {
+			s.Store.Delete(a.BlobKey)
+			return nil, err
+		}
+	}
+
+	var previous *repositories.Attachment
+	if kind == repositories.AttachmentAvatar {
+		previous, _ = s.Repo.GetAvatar(ctx, contactID)
+	}
+
+	if err := s.Repo.Create(ctx, a); err != nil {
+		s.deleteBlobs(a)
+		return nil, err
+	}
+
+	if previous != nil {
+		if err := s.Remove(ctx, previous); err != nil {
+			return nil, err
+		}
+	}
+
+	return a, nil
+}
+
+// Open opens the content of an attachment, or its thumbnail. Attachments without a thumbnail
+// return their content either way.
+func (s *Service) Open(a *repositories.Attachment, thumbnail bool) (io.ReadCloser, error) {
+	if thumbnail && a.ThumbnailKey != "" {
+		return s.Store.Get(a.ThumbnailKey)
+	}
+	return s.Store.Get(a.BlobKey)
+}
+
+// Remove deletes an attachment and its blobs.
+func (s *Service) Remove(ctx context.Context, a *repositories.Attachment) error {
+	if err := s.Repo.Delete(ctx, a.ID); err != nil {
+		return err
+	}
+	return s.deleteBlobs(a)
+}
+
+// Purge removes the attachments of contacts that were purged or soft-deleted before the given
+// time and returns how many were removed. Service implements jobs.Purger; add it to the purge
+// job after the contact repository so that attachments go together with their contacts.
+func (s *Service) Purge(ctx context.Context, before time.Time) (int64, error) {
+	purgeable, err := s.Repo.GetPurgeable(ctx, before)
+	if err != nil {
+		return 0, err
+	}
+
+	var removed int64
+	for _, a := range purgeable {
+		if err := s.Remove(ctx, a); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+
+	return removed, nil
+}
+
+func (s *Service) deleteBlobs(a *repositories.Attachment) error {
+	if a.ThumbnailKey != "" {
+		if err := s.Store.Delete(a.ThumbnailKey); err != nil {
+			return err
+		}
+	}
+	return s.Store.Delete(a.BlobKey)
+}
+
+// newBlobKey returns a fresh, unguessable key for a blob of a contact.
+func newBlobKey(contactID int) (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return "contacts/" + strconv.Itoa(contactID) + "/" + hex.EncodeToString(b), nil
+}
+
+// cleanFileName keeps the base name of an uploaded file without control characters or quotes,
+// so that it can be echoed in a Content-Disposition header.
+func cleanFileName(name string) string {
+	name = path.Base(strings.ReplaceAll(name, `\`, "/"))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+
+	if name == "" || name == "." || name == "/" {
+		return "attachment"
+	}
+	for len(name) > 255 || !utf8.ValidString(name) {
+		name = name[:len(name)-1]
+	}
+	return name
+}
@@ -0,0 +1,89 @@
+package attachments
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+
+	// registered for image.Decode
+	_ "image/gif"
+	_ "image/png"
+)
+
+// ThumbnailSize is the width and height of avatar thumbnails in pixels.
+const ThumbnailSize = 128
+
+// MaxImagePixels caps the width times the height of the images Thumbnail decodes. A small file can
+// declare a huge image, and decoding allocates memory for all of its pixels.
+const MaxImagePixels = 40 * 1000 * 1000
+
+// ErrImageTooLarge is returned by Thumbnail for images of more than MaxImagePixels pixels.
+var ErrImageTooLarge = errors.New("image dimensions exceed the limit of 40 megapixels")
+
+// Thumbnail decodes an image and returns a square JPEG thumbnail of it: the largest centered
+// square is cut out and scaled down by averaging, transparent areas become white. Images smaller
+// than the thumbnail are scaled up. The dimensions are checked against MaxImagePixels before the
+// image is decoded.
+func Thumbnail(data []byte) ([]byte, error) {
+	config, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(config.Width)*int64(config.Height) > MaxImagePixels {
+		return nil, ErrImageTooLarge
+	}
+
+	src, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	side := b.Dx()
+	if b.Dy() < side {
+		side = b.Dy()
+	}
+	x0 := b.Min.X + (b.Dx()-side)/2
+	y0 := b.Min.Y + (b.Dy()-side)/2
+
+	dst := image.NewRGBA(image.Rect(0, 0, ThumbnailSize, ThumbnailSize))
+	for y := 0; y < ThumbnailSize; y++ {
+		sy0, sy1 := span(y, side)
+		for x := 0; x < ThumbnailSize; x++ {
+			sx0, sx1 := span(x, side)
+
+			var r, g, bl, n uint64
+			for sy := sy0; sy < sy1; sy++ {
+				for sx := sx0; sx < sx1; sx++ {
+					c := color.NRGBAModel.Convert(src.At(x0+sx, y0+sy)).(color.NRGBA)
+					a := uint64(c.A)
+					r += (uint64(c.R)*a + 255*(255-a)) / 255
+					g += (uint64(c.G)*a + 255*(255-a)) / 255
+					bl += (uint64(c.B)*a + 255*(255-a)) / 255
+					n++
+				}
+			}
+			dst.Set(x, y, color.RGBA{R: uint8(r / n), G: uint8(g / n), B: uint8(bl / n), A: 255})
+		}
+	}
+
+	var out bytes.Buffer
+	if err := jpeg.Encode(&out, dst, &jpeg.Options{Quality: 85}); err != nil {
+		return nil, err
+	}
+
+	return out.Bytes(), nil
+}
+
+// span returns the range of source pixels covered by thumbnail pixel i, never empty.
+func span(i, side int) (int, int) {
+	start := i * side / ThumbnailSize
+	end := (i + 1) * side / ThumbnailSize
+	if end <= start {
+		end = start + 1
+	}
+	return start, end
+}
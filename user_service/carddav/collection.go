@@ -0,0 +1,246 @@
+package carddav
+
+import (
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// collection is an address book collection: either one of the address books the user can read
+// or the user's personal contacts.
+type collection struct {
+	bookID     int
+	ownerID    int
+	name       string
+	permission repositories.Permission
+}
+
+func (c *collection) segment() string {
+	if c.bookID == 0 {
+		return personalCollection
+	}
+	return strconv.Itoa(c.bookID)
+}
+
+// object is a contact served as a vCard resource.
+type object struct {
+	contact    *repositories.Contact
+	addresses  []*repositories.Address
+	name       string
+	uid        string
+	properties string
+}
+
+// data renders the vCard of the object.
+func (o *object) data() string {
+	return encodeCard(o.contact, o.uid, o.addresses, o.properties)
+}
+
+// newObject pairs a contact and its addresses with the resource name, UID and other properties a
+// client stored it under. Contacts never written over CardDAV are named after their ID.
+func newObject(c *repositories.Contact, addresses []*repositories.Address, stored *repositories.CardDAVObject) *object {
+	if stored != nil {
+		return &object{contact: c, addresses: addresses, name: stored.Name, uid: stored.UID, properties: stored.Properties}
+	}
+	return &object{contact: c, addresses: addresses, name: fmt.Sprintf("%d.vcf", c.ID), uid: fmt.Sprintf("contact-%d", c.ID)}
+}
+
+func (h *Handler) personal(user *repositories.User) *collection {
+	return &collection{ownerID: user.ID, name: "Contacts", permission: repositories.PermissionOwner}
+}
+
+// collection resolves a collection path segment for a user.
+func (h *Handler) collection(ctx context.Context, user *repositories.User, segment string) (*collection, error) {
+	if segment == personalCollection {
+		return h.personal(user), nil
+	}
+
+	bookID, err := strconv.Atoi(segment)
+	if err != nil {
+		return nil, errors.New("not found")
+	}
+
+	permission, err := h.BookRepo.GetPermission(ctx, bookID, user.ID)
+	if err != nil {
+		return nil, err
+	}
+	if permission < repositories.PermissionViewer {
+		return nil, errors.New("not found")
+	}
+
+	book, err := h.BookRepo.Get(ctx, bookID)
+	if err != nil {
+		return nil, err
+	}
+
+	return &collection{bookID: book.ID, ownerID: book.UserID, name: book.Name, permission: permission}, nil
+}
+
+// collections returns every collection in a user's address book home.
+func (h *Handler) collections(ctx context.Context, user *repositories.User) ([]*collection, error) {
+	books, err := h.BookRepo.GetAllForUser(ctx, user.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	cols := []*collection{h.personal(user)}
+	for _, b := range books {
+		cols = append(cols, &collection{bookID: b.ID, ownerID: b.UserID, name: b.Name, permission: b.Permission})
+	}
+
+	return cols, nil
+}
+
+// filter returns the contact filter selecting the contacts of the collection.
+func (c *collection) filter(since time.Time) *repositories.ContactFilter {
+	f := &repositories.ContactFilter{AddressBookID: c.bookID, ChangedSince: since}
+	if c.bookID == 0 {
+		f.UserID = c.ownerID
+	}
+	return f
+}
+
+// contains reports whether a contact belongs to the collection. The personal collection holds
+// the contacts of its owner that are outside of any address book.
+func (c *collection) contains(contact *repositories.Contact) bool {
+	if c.bookID == 0 {
+		return contact.AddressBookID == 0 && contact.UserID == c.ownerID
+	}
+	return contact.AddressBookID == c.bookID
+}
+
+// objects returns the objects of a collection changed at or after since, which may be zero.
+func (h *Handler) objects(ctx context.Context, userID int, col *collection, since time.Time) ([]*object, error) {
+	repo := repositories.NewAuthorizedContactRepository(h.ContactRepo, h.BookRepo, userID)
+	all, err := repo.GetAll(ctx, col.filter(since))
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := []*repositories.Contact{}
+	for _, c := range all {
+		if col.contains(c) {
+			contacts = append(contacts, c)
+		}
+	}
+
+	return h.withNames(ctx, contacts)
+}
+
+// removedNames returns the resource names of the objects removed from a collection at or after
+// since.
+func (h *Handler) removedNames(ctx context.Context, col *collection, since time.Time) ([]string, error) {
+	tombstones, err := h.ObjectRepo.GetTombstones(ctx, col.ownerID, col.bookID, since)
+	if err != nil {
+		return nil, err
+	}
+
+	names := []string{}
+	for _, t := range tombstones {
+		name := t.Name
+		if name == "" {
+			name = fmt.Sprintf("%d.vcf", t.ContactID)
+		}
+		names = append(names, name)
+	}
+
+	return names, nil
+}
+
+func (h *Handler) withNames(ctx context.Context, contacts []*repositories.Contact) ([]*object, error) {
+	ids := make([]int, 0, len(contacts))
+	for _, c := range contacts {
+		ids = append(ids, c.ID)
+	}
+
+	stored, err := h.ObjectRepo.GetAll(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	all, err := h.Addresses.Repo.GetForContacts(ctx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	addresses := map[int][]*repositories.Address{}
+	for _, a := range all {
+		addresses[a.ContactID] = append(addresses[a.ContactID], a)
+	}
+
+	objects := make([]*object, 0, len(contacts))
+	for _, c := range contacts {
+		objects = append(objects, newObject(c, addresses[c.ID], stored[c.ID]))
+	}
+
+	return objects, nil
+}
+
+// object finds the object stored under a resource name in a collection. A nil object is
+// returned when there is none.
+func (h *Handler) object(ctx context.Context, userID int, col *collection, name string) (*object, error) {
+	candidates := []int{}
+	stored, err := h.ObjectRepo.GetByName(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	for _, o := range stored {
+		candidates = append(candidates, o.ContactID)
+	}
+
+	var id int
+	if _, err := fmt.Sscanf(name, "%d.vcf", &id); err == nil && fmt.Sprintf("%d.vcf", id) == name {
+		candidates = append(candidates, id)
+	}
+
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+
+	repo := repositories.NewAuthorizedContactRepository(h.ContactRepo, h.BookRepo, userID)
+	contacts, err := repo.GetAll(ctx, &repositories.ContactFilter{ContactIDs: candidates})
+	if err != nil {
+		return nil, err
+	}
+
+	objects, err := h.withNames(ctx, contacts)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range objects {
+		if o.name == name && col.contains(o.contact) {
+			return o, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// objectHref returns the href of an object in a collection.
+func (h *Handler) objectHref(userID int, col *collection, o *object) string {
+	return h.collectionHref(userID, col) + url.PathEscape(o.name)
+}
+
+// ctag returns a tag that changes whenever any object of the collection is added, changed or removed.
+func ctag(objects []*object) string {
+	entries := make([]string, 0, len(objects))
+	for _, o := range objects {
+		entries = append(entries, o.name+" "+etag(o.data()))
+	}
+	sort.Strings(entries)
+
+	hash := sha1.New()
+	for _, e := range entries {
+		hash.Write([]byte(e + "\n"))
+	}
+	return hex.EncodeToString(hash.Sum(nil))
+}
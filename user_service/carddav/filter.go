@@ -0,0 +1,155 @@
+package carddav
+
+import (
+	"errors"
+	"strings"
+)
+
+// matchesFilter evaluates the CARDDAV:filter element of an addressbook-query against an object.
+// A missing filter matches everything.
+func matchesFilter(filter *node, o *object) (bool, error) {
+	if filter == nil {
+		return true, nil
+	}
+
+	props, err := parseProperties(o.data())
+	if err != nil {
+		return false, err
+	}
+
+	propFilters := filter.all(nsCardDAV, "prop-filter")
+	if len(propFilters) == 0 {
+		return true, nil
+	}
+
+	allOf := filter.attr("test") == "allof"
+	for _, pf := range propFilters {
+		ok, err := matchesPropFilter(pf, props)
+		if err != nil {
+			return false, err
+		}
+		if ok && !allOf {
+			return true, nil
+		}
+		if !ok && allOf {
+			return false, nil
+		}
+	}
+
+	return allOf, nil
+}
+
+// matchesPropFilter evaluates a prop-filter against the properties of a vCard.
+func matchesPropFilter(pf *node, props []*property) (bool, error) {
+	name := strings.ToUpper(pf.attr("name"))
+	if name == "" {
+		return false, errors.New("prop-filter requires a name")
+	}
+
+	matching := []*property{}
+	for _, p := range props {
+		if p.Name == name {
+			matching = append(matching, p)
+		}
+	}
+
+	if pf.child(nsCardDAV, "is-not-defined") != nil {
+		return len(matching) == 0, nil
+	}
+
+	textMatches := pf.all(nsCardDAV, "text-match")
+	paramFilters := pf.all(nsCardDAV, "param-filter")
+	if len(textMatches) == 0 && len(paramFilters) == 0 {
+		return len(matching) > 0, nil
+	}
+
+	allOf := pf.attr("test") == "allof"
+	for _, p := range matching {
+		ok, err := matchesProperty(p, textMatches, paramFilters, allOf)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// matchesProperty applies the text-match and param-filter tests of a prop-filter to a single
+// property, combining them with allof or anyof semantics.
+func matchesProperty(p *property, textMatches, paramFilters []*node, allOf bool) (bool, error) {
+	results := []bool{}
+	for _, tm := range textMatches {
+		ok, err := textMatch(tm, unescapeValue(p.Value))
+		if err != nil {
+			return false, err
+		}
+		results = append(results, ok)
+	}
+
+	for _, pf := range paramFilters {
+		name := strings.ToUpper(pf.attr("name"))
+		values, defined := p.Params[name]
+		switch {
+		case pf.child(nsCardDAV, "is-not-defined") != nil:
+			results = append(results, !defined)
+		case pf.child(nsCardDAV, "text-match") != nil:
+			ok := false
+			for _, v := range values {
+				matched, err := textMatch(pf.child(nsCardDAV, "text-match"), v)
+				if err != nil {
+					return false, err
+				}
+				ok = ok || matched
+			}
+			results = append(results, ok)
+		default:
+			results = append(results, defined)
+		}
+	}
+
+	for _, ok := range results {
+		if ok && !allOf {
+			return true, nil
+		}
+		if !ok && allOf {
+			return false, nil
+		}
+	}
+
+	return allOf, nil
+}
+
+// textMatch applies a text-match element to a value. The i;unicode-casemap collation, the
+// default, compares case-insensitively; i;octet compares bytes.
+func textMatch(tm *node, value string) (bool, error) {
+	needle := tm.Text
+	switch tm.attr("collation") {
+	case "", "i;unicode-casemap", "i;ascii-casemap":
+		needle, value = strings.ToLower(needle), strings.ToLower(value)
+	case "i;octet":
+	default:
+		return false, errors.New("unsupported collation " + tm.attr("collation"))
+	}
+
+	var ok bool
+	switch tm.attr("match-type") {
+	case "", "contains":
+		ok = strings.Contains(value, needle)
+	case "equals":
+		ok = value == needle
+	case "starts-with":
+		ok = strings.HasPrefix(value, needle)
+	case "ends-with":
+		ok = strings.HasSuffix(value, needle)
+	default:
+		return false, errors.New("unsupported match-type " + tm.attr("match-type"))
+	}
+
+	if tm.attr("negate-condition") == "yes" {
+		ok = !ok
+	}
+	return ok, nil
+}
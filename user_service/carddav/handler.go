@@ -0,0 +1,462 @@
+// Package carddav serves the contacts store over CardDAV (RFC 6352) so that phones and desktop
+// clients can sync address books without a custom app.
+//
+// Resources are laid out below the handler's prefix as
+//
+//	/principals/{user_id}/                          the user's principal
+//	/addressbooks/{user_id}/                        the address book home
+//	/addressbooks/{user_id}/personal/               the user's contacts outside of any address book
+//	/addressbooks/{user_id}/{address_book_id}/      every address book the user can read
+//	/addressbooks/{user_id}/{collection}/{name}     a single vCard
+//
+// Clients authenticate with HTTP Basic credentials of a user.
+package carddav
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/princeparmar/contact_manager/addresses"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/utils"
+)
+
+// maxResourceSize is the largest vCard accepted by PUT.
+const maxResourceSize = 1 << 20
+
+// personalCollection is the path segment of the collection holding a user's personal contacts.
+const personalCollection = "personal"
+
+// Handler is an http.Handler serving CardDAV.
+type Handler struct {
+	Prefix string
+
+	UserRepo    repositories.UserRepository
+	ContactRepo repositories.ContactStore
+	BookRepo    repositories.AddressBookRepository
+	ObjectRepo  repositories.CardDAVRepository
+	FieldRepo   repositories.CustomFieldRepository
+	Addresses   *addresses.Service
+
+	// Retention is how long removed objects are remembered for sync-collection reports, the
+	// retention of the purge job emptying the trash and purging ObjectRepo. Older sync tokens are
+	// refused. Zero accepts every token.
+	Retention time.Duration
+}
+
+// NewHandler returns a new Handler serving below prefix, e.g. "/carddav". Mount it on the prefix
+// and on /.well-known/carddav for service discovery. Custom fields are exchanged as vCard X-
+// properties, and ADR properties as the contact's addresses through addrs. retention is the
+// retention of the trash.
+func NewHandler(prefix string, users repositories.UserRepository, contacts repositories.ContactStore,
+	books repositories.AddressBookRepository, objects repositories.CardDAVRepository,
+	fields repositories.CustomFieldRepository, addrs *addresses.Service, retention time.Duration) *Handler {
+	return &Handler{
+		Prefix:      strings.TrimSuffix(prefix, "/"),
+		UserRepo:    users,
+		ContactRepo: contacts,
+		BookRepo:    books,
+		ObjectRepo:  objects,
+		FieldRepo:   fields,
+		Addresses:   addrs,
+		Retention:   retention,
+	}
+}
+
+// resource kinds addressed by a request path.
+const (
+	kindRoot = iota
+	kindPrincipal
+	kindHome
+	kindCollection
+	kindObject
+)
+
+// request is an authenticated request resolved against the resource layout.
+type request struct {
+	user       *repositories.User
+	kind       int
+	collection *collection
+	name       string
+}
+
+// ServeHTTP dispatches a CardDAV request.
+func (h *Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(r.URL.Path, "/.well-known/carddav") {
+		http.Redirect(w, r, h.Prefix+"/", http.StatusMovedPermanently)
+		return
+	}
+
+	w.Header().Set("DAV", "1, 3, addressbook")
+	if r.Method == http.MethodOptions {
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	user, err := h.authenticate(r)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", `Basic realm="contacts", charset="UTF-8"`)
+		http.Error(w, err.Error(), http.StatusUnauthorized)
+		return
+	}
+
+	req, status, err := h.resolve(r.Context(), user, r.URL.Path)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	switch r.Method {
+	case "PROPFIND":
+		err = h.propfind(w, r, req)
+	case "REPORT":
+		err = h.report(w, r, req)
+	case http.MethodGet, http.MethodHead:
+		err = h.get(w, r, req)
+	case http.MethodPut:
+		err = h.put(w, r, req)
+	case http.MethodDelete:
+		err = h.delete(w, r, req)
+	default:
+		w.Header().Set("Allow", "OPTIONS, GET, HEAD, PUT, DELETE, PROPFIND, REPORT")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, repositories.ErrPermissionDenied) {
+			status = http.StatusForbidden
+		}
+		http.Error(w, err.Error(), status)
+	}
+}
+
+// authenticate checks the Basic credentials of a request against the stored user passwords.
+func (h *Handler) authenticate(r *http.Request) (*repositories.User, error) {
+	name, password, ok := r.BasicAuth()
+	if !ok {
+		return nil, errors.New("authentication required")
+	}
+
+	user, err := h.UserRepo.GetUserByUserName(r.Context(), name)
+	if err != nil {
+		return nil, errors.New("invalid username or password")
+	}
+
+	stored, err := h.UserRepo.GetPassword(r.Context(), user.ID)
+	if err != nil || stored != utils.MD5Hash(password) {
+		return nil, errors.New("invalid username or password")
+	}
+
+	return user, nil
+}
+
+// resolve maps a request path onto a resource. Users can only reach their own principal and
+// address book home.
+func (h *Handler) resolve(ctx context.Context, user *repositories.User, urlPath string) (*request, int, error) {
+	req := &request{user: user}
+
+	rel := strings.TrimPrefix(urlPath, h.Prefix)
+	segments := []string{}
+	for _, s := range strings.Split(rel, "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+
+	if len(segments) == 0 {
+		req.kind = kindRoot
+		return req, 0, nil
+	}
+
+	if len(segments) < 2 || (segments[0] != "principals" && segments[0] != "addressbooks") {
+		return nil, http.StatusNotFound, errors.New("not found")
+	}
+
+	userID, err := strconv.Atoi(segments[1])
+	if err != nil {
+		return nil, http.StatusNotFound, errors.New("not found")
+	}
+	if userID != user.ID {
+		return nil, http.StatusForbidden, repositories.ErrPermissionDenied
+	}
+
+	if segments[0] == "principals" {
+		if len(segments) != 2 {
+			return nil, http.StatusNotFound, errors.New("not found")
+		}
+		req.kind = kindPrincipal
+		return req, 0, nil
+	}
+
+	switch len(segments) {
+	case 2:
+		req.kind = kindHome
+		return req, 0, nil
+	case 3, 4:
+		col, err := h.collection(ctx, user, segments[2])
+		if err != nil {
+			return nil, http.StatusNotFound, err
+		}
+		req.kind, req.collection = kindCollection, col
+		if len(segments) == 4 {
+			req.kind, req.name = kindObject, segments[3]
+		}
+		return req, 0, nil
+	default:
+		return nil, http.StatusNotFound, errors.New("not found")
+	}
+}
+
+func (h *Handler) principalHref(userID int) string {
+	return fmt.Sprintf("%s/principals/%d/", h.Prefix, userID)
+}
+
+func (h *Handler) homeHref(userID int) string {
+	return fmt.Sprintf("%s/addressbooks/%d/", h.Prefix, userID)
+}
+
+func (h *Handler) collectionHref(userID int, col *collection) string {
+	return h.homeHref(userID) + col.segment() + "/"
+}
+
+// writableContacts returns the contact repository used for writes by a user, recording history
+// and enforcing address book permissions the same way the JSON API does.
+func (h *Handler) writableContacts(userID int) repositories.ContactRepository {
+	return h.ContactRepo.As(userID)
+}
+
+// get serves a single vCard. Collections have no GET representation.
+func (h *Handler) get(w http.ResponseWriter, r *http.Request, req *request) error {
+	if req.kind != kindObject {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	obj, err := h.object(r.Context(), req.user.ID, req.collection, req.name)
+	if err != nil {
+		return err
+	}
+	if obj == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil
+	}
+
+	data := obj.data()
+	w.Header().Set("ETag", etag(data))
+	if match := r.Header.Get("If-None-Match"); match != "" && matchesETag(match, etag(data)) {
+		w.WriteHeader(http.StatusNotModified)
+		return nil
+	}
+
+	w.Header().Set("Content-Type", vCardContentType)
+	w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.WriteString(w, data)
+	}
+	return nil
+}
+
+// put creates or replaces a vCard. If-Match and If-None-Match are honoured so clients never
+// overwrite changes they have not seen.
+func (h *Handler) put(w http.ResponseWriter, r *http.Request, req *request) error {
+	if req.kind != kindObject {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	if req.collection.permission < repositories.PermissionEditor {
+		return repositories.ErrPermissionDenied
+	}
+
+	existing, err := h.object(r.Context(), req.user.ID, req.collection, req.name)
+	if err != nil {
+		return err
+	}
+
+	if !preconditionsHold(r, existing) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return nil
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(r.Body, maxResourceSize+1))
+	if err != nil {
+		return err
+	}
+	if len(body) > maxResourceSize {
+		writeError(w, http.StatusForbidden, propMaxResourceSize)
+		return nil
+	}
+
+	c, err := decodeCard(string(body))
+	if err != nil {
+		writeError(w, http.StatusForbidden, condValidAddressData)
+		return nil
+	}
+
+	repo := h.writableContacts(req.user.ID)
+	contact := &repositories.Contact{AddressBookID: req.collection.bookID}
+	current := []*repositories.Address{}
+	status := http.StatusCreated
+	if existing != nil {
+		contact, current = existing.contact, existing.addresses
+		status = http.StatusNoContent
+	}
+
+	fields, err := h.FieldRepo.GetAll(r.Context(), req.collection.ownerID, req.collection.bookID)
+	if err != nil {
+		return err
+	}
+	c.apply(contact, fields)
+
+	if existing != nil {
+		err = repo.Update(r.Context(), contact)
+	} else {
+		err = repo.Create(r.Context(), contact)
+	}
+	if err != nil {
+		return err
+	}
+
+	rejected, err := h.saveAddresses(r.Context(), contact.ID, current, c.Addresses)
+	if err != nil {
+		return err
+	}
+
+	obj := &repositories.CardDAVObject{ContactID: contact.ID, Name: req.name, UID: c.UID, Properties: c.properties(fields, rejected)}
+	if err := h.ObjectRepo.Save(r.Context(), obj); err != nil {
+		return err
+	}
+
+	w.WriteHeader(status)
+	return nil
+}
+
+// saveAddresses makes the addresses of a contact those of its vCard. Addresses that did not change
+// are kept as they are, with their location; the others are added or removed. ADR properties that
+// are not valid addresses are returned, so that they are kept with the other properties.
+func (h *Handler) saveAddresses(ctx context.Context, contactID int, current []*repositories.Address,
+	cards []*cardAddress) ([]*property, error) {
+	kept := map[int]bool{}
+	rejected := []*property{}
+	for _, ca := range cards {
+		a := ca.Address
+		if err := addresses.Normalize(a); err != nil {
+			rejected = append(rejected, ca.prop)
+			continue
+		}
+
+		if same := sameAddress(current, a, kept); same != nil {
+			kept[same.ID] = true
+			continue
+		}
+
+		a.ContactID = contactID
+		if err := h.Addresses.Save(ctx, a); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, a := range current {
+		if !kept[a.ID] {
+			if err := h.Addresses.Repo.Delete(ctx, a.ID); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return rejected, nil
+}
+
+// sameAddress returns the first address of current that is not yet kept and equals a.
+func sameAddress(current []*repositories.Address, a *repositories.Address, kept map[int]bool) *repositories.Address {
+	for _, c := range current {
+		if !kept[c.ID] && c.Label == a.Label && c.Street == a.Street && c.Locality == a.Locality &&
+			c.Region == a.Region && c.PostalCode == a.PostalCode && c.CountryCode == a.CountryCode {
+			return c
+		}
+	}
+	return nil
+}
+
+// delete moves a contact to the trash.
+func (h *Handler) delete(w http.ResponseWriter, r *http.Request, req *request) error {
+	if req.kind != kindObject {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+
+	if req.collection.permission < repositories.PermissionEditor {
+		return repositories.ErrPermissionDenied
+	}
+
+	existing, err := h.object(r.Context(), req.user.ID, req.collection, req.name)
+	if err != nil {
+		return err
+	}
+	if existing == nil {
+		http.Error(w, "not found", http.StatusNotFound)
+		return nil
+	}
+
+	if !preconditionsHold(r, existing) {
+		w.WriteHeader(http.StatusPreconditionFailed)
+		return nil
+	}
+
+	if err := h.writableContacts(req.user.ID).Delete(r.Context(), existing.contact.ID, req.user.ID); err != nil {
+		return err
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+	return nil
+}
+
+// preconditionsHold evaluates If-Match and If-None-Match against the current state of a resource.
+func preconditionsHold(r *http.Request, existing *object) bool {
+	current := ""
+	if existing != nil {
+		current = etag(existing.data())
+	}
+
+	if match := r.Header.Get("If-Match"); match != "" {
+		if current == "" || !matchesETag(match, current) {
+			return false
+		}
+	}
+
+	if match := r.Header.Get("If-None-Match"); match != "" {
+		if current != "" && matchesETag(match, current) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// matchesETag reports whether an If-Match or If-None-Match header value matches the entity tag.
+func matchesETag(header, tag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || candidate == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// hrefName returns the last segment of an href.
+func hrefName(href string) string {
+	return path.Base(strings.TrimSuffix(href, "/"))
+}
@@ -0,0 +1,183 @@
+package carddav
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/princeparmar/contact_manager/addresses"
+	"github.com/princeparmar/contact_manager/migrations"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/utils"
+)
+
+// testServer is a Handler on a migrated SQLite database with a single user.
+type testServer struct {
+	handler *Handler
+	user    *repositories.User
+}
+
+func newTestServer(t *testing.T) *testServer {
+	dsn := filepath.Join(t.TempDir(), "contacts.db") + "?_foreign_keys=on&_busy_timeout=5000"
+	db, err := repositories.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	runner, err := migrations.NewRunner(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	users := repositories.NewUserRepository(db)
+	user := &repositories.User{UserName: "ada", Mobile: "5550100", EmailID: "ada@example.com"}
+	if err := users.Create(context.Background(), user); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.UpdatePassword(context.Background(), user.ID, utils.MD5Hash("secret")); err != nil {
+		t.Fatal(err)
+	}
+
+	handler := NewHandler("/carddav", users, repositories.NewContactStore(db, nil), repositories.NewAddressBookRepository(db),
+		repositories.NewCardDAVRepository(db), repositories.NewCustomFieldRepository(db),
+		addresses.NewService(repositories.NewAddressRepository(db), addresses.NewStaticGeocoder()), 24*time.Hour)
+
+	return &testServer{handler: handler, user: user}
+}
+
+// do serves a request of the user on a path below their personal collection.
+func (s *testServer) do(method, name, body string, header ...string) *httptest.ResponseRecorder {
+	target := fmt.Sprintf("/carddav/addressbooks/%d/personal/%s", s.user.ID, name)
+	r := httptest.NewRequest(method, target, strings.NewReader(body))
+	r.SetBasicAuth("ada", "secret")
+	for i := 0; i+1 < len(header); i += 2 {
+		r.Header.Set(header[i], header[i+1])
+	}
+
+	w := httptest.NewRecorder()
+	s.handler.ServeHTTP(w, r)
+
+	return w
+}
+
+func vCard(uid, name string) string {
+	return "BEGIN:VCARD\r\nVERSION:3.0\r\nUID:" + uid + "\r\nFN:" + name + "\r\nN:" + name + ";;;;\r\nEND:VCARD\r\n"
+}
+
+func syncBody(token string) string {
+	return `<?xml version="1.0" encoding="utf-8"?>
+<d:sync-collection xmlns:d="DAV:"><d:sync-token>` + token + `</d:sync-token><d:sync-level>1</d:sync-level>
+<d:prop><d:getetag/></d:prop></d:sync-collection>`
+}
+
+// responseFor returns the response element of a multistatus body for href, or "" when there is none.
+func responseFor(body, href string) string {
+	i := strings.Index(body, "<d:href>"+href+"</d:href>")
+	if i < 0 {
+		return ""
+	}
+	body = body[i:]
+	if j := strings.Index(body, "</d:response>"); j >= 0 {
+		body = body[:j]
+	}
+	return body
+}
+
+// tokenOf returns the sync token of a multistatus body.
+func tokenOf(t *testing.T, body string) string {
+	t.Helper()
+	i := strings.Index(body, syncTokenPrefix)
+	if i < 0 {
+		t.Fatalf("no sync token in %s", body)
+	}
+	body = body[i:]
+	return body[:strings.Index(body, "<")]
+}
+
+func expectStatus(t *testing.T, what string, w *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("%s: status %d, want %d: %s", what, w.Code, status, w.Body)
+	}
+}
+
+func TestConditionalWrites(t *testing.T) {
+	s := newTestServer(t)
+
+	expectStatus(t, "create", s.do(http.MethodPut, "a.vcf", vCard("a", "Lovelace"), "If-None-Match", "*"), http.StatusCreated)
+	expectStatus(t, "create existing", s.do(http.MethodPut, "a.vcf", vCard("a", "Byron"), "If-None-Match", "*"), http.StatusPreconditionFailed)
+
+	w := s.do(http.MethodGet, "a.vcf", "")
+	expectStatus(t, "get", w, http.StatusOK)
+	first := w.Header().Get("ETag")
+	if first == "" || !strings.Contains(w.Body.String(), "FN:Lovelace") {
+		t.Fatalf("get: ETag %q, body %s", first, w.Body)
+	}
+	expectStatus(t, "get unchanged", s.do(http.MethodGet, "a.vcf", "", "If-None-Match", first), http.StatusNotModified)
+
+	expectStatus(t, "update with another ETag", s.do(http.MethodPut, "a.vcf", vCard("a", "Byron"), "If-Match", `"other"`), http.StatusPreconditionFailed)
+	expectStatus(t, "update", s.do(http.MethodPut, "a.vcf", vCard("a", "Byron"), "If-Match", first), http.StatusNoContent)
+	expectStatus(t, "update missing", s.do(http.MethodPut, "b.vcf", vCard("b", "Babbage"), "If-Match", first), http.StatusPreconditionFailed)
+
+	w = s.do(http.MethodGet, "a.vcf", "")
+	second := w.Header().Get("ETag")
+	if second == first || !strings.Contains(w.Body.String(), "FN:Byron") {
+		t.Fatalf("updated: ETag %q (was %q), body %s", second, first, w.Body)
+	}
+
+	expectStatus(t, "delete with the old ETag", s.do(http.MethodDelete, "a.vcf", "", "If-Match", first), http.StatusPreconditionFailed)
+	expectStatus(t, "get after a failed delete", s.do(http.MethodGet, "a.vcf", ""), http.StatusOK)
+	expectStatus(t, "delete", s.do(http.MethodDelete, "a.vcf", "", "If-Match", second), http.StatusNoContent)
+	expectStatus(t, "get deleted", s.do(http.MethodGet, "a.vcf", ""), http.StatusNotFound)
+	expectStatus(t, "delete deleted", s.do(http.MethodDelete, "a.vcf", ""), http.StatusNotFound)
+}
+
+func TestSyncCollection(t *testing.T) {
+	s := newTestServer(t)
+	href := func(name string) string {
+		return fmt.Sprintf("/carddav/addressbooks/%d/personal/%s", s.user.ID, name)
+	}
+
+	expectStatus(t, "create a", s.do(http.MethodPut, "a.vcf", vCard("a", "Lovelace")), http.StatusCreated)
+	expectStatus(t, "create b", s.do(http.MethodPut, "b.vcf", vCard("b", "Babbage")), http.StatusCreated)
+
+	w := s.do("REPORT", "", syncBody(""))
+	expectStatus(t, "initial sync", w, http.StatusMultiStatus)
+	body := w.Body.String()
+	for _, name := range []string{"a.vcf", "b.vcf"} {
+		if !strings.Contains(responseFor(body, href(name)), "200 OK") {
+			t.Errorf("initial sync misses %s: %s", name, body)
+		}
+	}
+	token := tokenOf(t, body)
+
+	expectStatus(t, "delete a", s.do(http.MethodDelete, "a.vcf", ""), http.StatusNoContent)
+
+	w = s.do("REPORT", "", syncBody(token))
+	expectStatus(t, "sync", w, http.StatusMultiStatus)
+	body = w.Body.String()
+	if !strings.Contains(responseFor(body, href("a.vcf")), "404 Not Found") {
+		t.Errorf("sync does not report a.vcf as removed: %s", body)
+	}
+	tokenOf(t, body)
+
+	t.Run("InvalidToken", func(t *testing.T) {
+		for _, token := range []string{"http://example.com/sync/1", syncToken(time.Now().Add(-48 * time.Hour))} {
+			w := s.do("REPORT", "", syncBody(token))
+			expectStatus(t, token, w, http.StatusForbidden)
+			if !strings.Contains(w.Body.String(), "valid-sync-token") {
+				t.Errorf("%s: body %s, want the valid-sync-token precondition", token, w.Body)
+			}
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package carddav
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// propfind answers PROPFIND for every resource kind. Depth 1 includes the members of the
+// resource; depth infinity is treated as 1.
+func (h *Handler) propfind(w http.ResponseWriter, r *http.Request, req *request) error {
+	body, err := parseBody(r.Body)
+	if err != nil {
+		http.Error(w, "invalid XML body", http.StatusBadRequest)
+		return nil
+	}
+	props := parsePropRequest(body)
+	depth := r.Header.Get("Depth")
+	userID := req.user.ID
+
+	responses := []*response{}
+	switch req.kind {
+	case kindRoot:
+		responses = append(responses, newResponse(h.Prefix+"/", h.rootProps(req), props))
+		if depth == "1" || depth == "infinity" {
+			responses = append(responses, newResponse(h.principalHref(userID), h.principalProps(req), props))
+			responses = append(responses, newResponse(h.homeHref(userID), h.homeProps(req), props))
+		}
+
+	case kindPrincipal:
+		responses = append(responses, newResponse(h.principalHref(userID), h.principalProps(req), props))
+
+	case kindHome:
+		responses = append(responses, newResponse(h.homeHref(userID), h.homeProps(req), props))
+		if depth == "1" || depth == "infinity" {
+			cols, err := h.collections(r.Context(), req.user)
+			if err != nil {
+				return err
+			}
+			for _, col := range cols {
+				available, err := h.collectionProps(r.Context(), req, col)
+				if err != nil {
+					return err
+				}
+				responses = append(responses, newResponse(h.collectionHref(userID, col), available, props))
+			}
+		}
+
+	case kindCollection:
+		available, err := h.collectionProps(r.Context(), req, req.collection)
+		if err != nil {
+			return err
+		}
+		responses = append(responses, newResponse(h.collectionHref(userID, req.collection), available, props))
+		if depth == "1" || depth == "infinity" {
+			objects, err := h.objects(r.Context(), userID, req.collection, time.Time{})
+			if err != nil {
+				return err
+			}
+			for _, o := range objects {
+				responses = append(responses, newResponse(h.objectHref(userID, req.collection, o), objectProps(o), props))
+			}
+		}
+
+	case kindObject:
+		o, err := h.object(r.Context(), userID, req.collection, req.name)
+		if err != nil {
+			return err
+		}
+		if o == nil {
+			http.Error(w, "not found", http.StatusNotFound)
+			return nil
+		}
+		responses = append(responses, newResponse(h.objectHref(userID, req.collection, o), objectProps(o), props))
+	}
+
+	writeMultistatus(w, responses, "")
+	return nil
+}
+
+func (h *Handler) principalElement(userID int) string {
+	return hrefElement(h.principalHref(userID))
+}
+
+// rootProps lists the properties of the service root, which exists to point clients at the
+// current user's principal.
+func (h *Handler) rootProps(req *request) map[xml.Name]string {
+	return map[xml.Name]string{
+		propResourceType:     element(xml.Name{Space: nsDAV, Local: "collection"}, ""),
+		propCurrentPrincipal: h.principalElement(req.user.ID),
+		propPrincipalCollSet: hrefElement(h.Prefix + "/principals/"),
+	}
+}
+
+func (h *Handler) principalProps(req *request) map[xml.Name]string {
+	return map[xml.Name]string{
+		propResourceType:     element(xml.Name{Space: nsDAV, Local: "principal"}, ""),
+		propDisplayName:      escape(req.user.UserName),
+		propCurrentPrincipal: h.principalElement(req.user.ID),
+		propPrincipalURL:     h.principalElement(req.user.ID),
+		propHomeSet:          hrefElement(h.homeHref(req.user.ID)),
+		propPrincipalCollSet: hrefElement(h.Prefix + "/principals/"),
+	}
+}
+
+func (h *Handler) homeProps(req *request) map[xml.Name]string {
+	return map[xml.Name]string{
+		propResourceType:     element(xml.Name{Space: nsDAV, Local: "collection"}, ""),
+		propDisplayName:      escape(req.user.UserName),
+		propCurrentPrincipal: h.principalElement(req.user.ID),
+		propOwner:            h.principalElement(req.user.ID),
+	}
+}
+
+// collectionProps lists the properties of an address book collection. The ctag is derived from
+// the collection's objects; the sync token is the current time.
+func (h *Handler) collectionProps(ctx context.Context, req *request, col *collection) (map[xml.Name]string, error) {
+	objects, err := h.objects(ctx, req.user.ID, col, time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	privileges := privilege("read")
+	if col.permission >= repositories.PermissionEditor {
+		privileges += privilege("write") + privilege("write-content") + privilege("bind") + privilege("unbind")
+	}
+
+	reports := ""
+	for _, report := range []xml.Name{
+		{Space: nsCardDAV, Local: "addressbook-query"},
+		{Space: nsCardDAV, Local: "addressbook-multiget"},
+		{Space: nsDAV, Local: "sync-collection"},
+	} {
+		reports += element(xml.Name{Space: nsDAV, Local: "supported-report"},
+			element(xml.Name{Space: nsDAV, Local: "report"}, element(report, "")))
+	}
+
+	return map[xml.Name]string{
+		propResourceType: element(xml.Name{Space: nsDAV, Local: "collection"}, "") +
+			element(xml.Name{Space: nsCardDAV, Local: "addressbook"}, ""),
+		propDisplayName:      escape(col.name),
+		propDescription:      escape(col.name),
+		propCurrentPrincipal: h.principalElement(req.user.ID),
+		propOwner:            h.principalElement(col.ownerID),
+		propSupportedData:    `<card:address-data-type content-type="text/vcard" version="3.0"/>`,
+		propMaxResourceSize:  strconv.Itoa(maxResourceSize),
+		propSupportedReports: reports,
+		propPrivilegeSet:     privileges,
+		propSyncToken:        escape(syncToken(time.Now())),
+		propGetCTag:          escape(ctag(objects)),
+	}, nil
+}
+
+func privilege(name string) string {
+	return element(xml.Name{Space: nsDAV, Local: "privilege"}, element(xml.Name{Space: nsDAV, Local: name}, ""))
+}
+
+// objectProps lists the properties of a vCard resource, including its address data for REPORTs.
+func objectProps(o *object) map[xml.Name]string {
+	data := o.data()
+	return map[xml.Name]string{
+		propResourceType:     "",
+		propGetETag:          escape(etag(data)),
+		propGetContentType:   escape(vCardContentType),
+		propGetContentLength: fmt.Sprint(len(data)),
+		propAddressData:      escape(data),
+	}
+}
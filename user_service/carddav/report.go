@@ -0,0 +1,183 @@
+package carddav
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// syncTokenPrefix prefixes the sync tokens handed out by sync-collection reports.
+const syncTokenPrefix = "urn:x-contact-manager:sync:"
+
+// syncOverlap is subtracted from a sync token's time when looking for changes, so that clock
+// skew between the application and the database never hides a change. Changes inside the
+// overlap are reported twice, which clients handle fine.
+const syncOverlap = time.Minute
+
+func syncToken(t time.Time) string {
+	return syncTokenPrefix + strconv.FormatInt(t.Unix(), 10)
+}
+
+func parseSyncToken(token string) (time.Time, bool) {
+	if !strings.HasPrefix(token, syncTokenPrefix) {
+		return time.Time{}, false
+	}
+	seconds, err := strconv.ParseInt(strings.TrimPrefix(token, syncTokenPrefix), 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(seconds, 0), true
+}
+
+// report answers the addressbook-query, addressbook-multiget and sync-collection reports on a
+// collection.
+func (h *Handler) report(w http.ResponseWriter, r *http.Request, req *request) error {
+	if req.kind != kindCollection {
+		http.Error(w, "reports are only supported on address book collections", http.StatusForbidden)
+		return nil
+	}
+
+	body, err := parseBody(r.Body)
+	if err != nil || body == nil {
+		http.Error(w, "invalid XML body", http.StatusBadRequest)
+		return nil
+	}
+
+	switch {
+	case body.is(nsCardDAV, "addressbook-multiget"):
+		return h.multiget(r.Context(), w, req, body)
+	case body.is(nsCardDAV, "addressbook-query"):
+		return h.query(r.Context(), w, req, body)
+	case body.is(nsDAV, "sync-collection"):
+		return h.syncCollection(r.Context(), w, req, body)
+	default:
+		http.Error(w, "unsupported report", http.StatusForbidden)
+		return nil
+	}
+}
+
+// multiget returns the requested objects by href. Unknown hrefs are reported as 404.
+func (h *Handler) multiget(ctx context.Context, w http.ResponseWriter, req *request, body *node) error {
+	props := parsePropRequest(body)
+	userID := req.user.ID
+
+	responses := []*response{}
+	for _, href := range body.all(nsDAV, "href") {
+		raw := strings.TrimSpace(href.Text)
+		if u, err := url.Parse(raw); err == nil {
+			raw = u.Path
+		}
+
+		name, err := url.PathUnescape(hrefName(raw))
+		if err != nil {
+			responses = append(responses, &response{href: raw, status: http.StatusNotFound})
+			continue
+		}
+
+		o, err := h.object(ctx, userID, req.collection, name)
+		if err != nil {
+			return err
+		}
+		if o == nil {
+			responses = append(responses, &response{href: raw, status: http.StatusNotFound})
+			continue
+		}
+
+		responses = append(responses, newResponse(h.objectHref(userID, req.collection, o), objectProps(o), props))
+	}
+
+	writeMultistatus(w, responses, "")
+	return nil
+}
+
+// query returns the objects matching the filter of an addressbook-query report, up to the
+// requested number of results.
+func (h *Handler) query(ctx context.Context, w http.ResponseWriter, req *request, body *node) error {
+	props := parsePropRequest(body)
+	userID := req.user.ID
+
+	objects, err := h.objects(ctx, userID, req.collection, time.Time{})
+	if err != nil {
+		return err
+	}
+
+	limit := -1
+	if nresults := body.child(nsCardDAV, "limit").child(nsCardDAV, "nresults"); nresults != nil {
+		if n, err := strconv.Atoi(strings.TrimSpace(nresults.Text)); err == nil && n >= 0 {
+			limit = n
+		}
+	}
+
+	filter := body.child(nsCardDAV, "filter")
+	responses := []*response{}
+	for _, o := range objects {
+		if limit >= 0 && len(responses) == limit {
+			break
+		}
+
+		ok, err := matchesFilter(filter, o)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return nil
+		}
+		if ok {
+			responses = append(responses, newResponse(h.objectHref(userID, req.collection, o), objectProps(o), props))
+		}
+	}
+
+	writeMultistatus(w, responses, "")
+	return nil
+}
+
+// syncCollection reports the objects changed and removed since the given sync token, or every
+// object for an empty token. Removals are remembered for the handler's Retention, older tokens
+// are refused so that the client runs an initial sync again.
+func (h *Handler) syncCollection(ctx context.Context, w http.ResponseWriter, req *request, body *node) error {
+	props := parsePropRequest(body)
+	userID := req.user.ID
+	now := time.Now()
+
+	var since time.Time
+	if token := strings.TrimSpace(body.child(nsDAV, "sync-token").Text); token != "" {
+		t, ok := parseSyncToken(token)
+		if !ok || (h.Retention > 0 && t.Before(now.Add(-h.Retention))) {
+			writeError(w, http.StatusForbidden, condValidSyncToken)
+			return nil
+		}
+		since = t.Add(-syncOverlap)
+	}
+
+	changed, err := h.objects(ctx, userID, req.collection, since)
+	if err != nil {
+		return err
+	}
+
+	responses := []*response{}
+	present := map[string]bool{}
+	for _, o := range changed {
+		responses = append(responses, newResponse(h.objectHref(userID, req.collection, o), objectProps(o), props))
+		present[o.name] = true
+	}
+
+	if !since.IsZero() {
+		removed, err := h.removedNames(ctx, req.collection, since)
+		if err != nil {
+			return err
+		}
+		for _, name := range removed {
+			// contacts restored or moved back since are reported as changed
+			if present[name] {
+				continue
+			}
+			present[name] = true
+			href := h.collectionHref(userID, req.collection) + url.PathEscape(name)
+			responses = append(responses, &response{href: href, status: http.StatusNotFound})
+		}
+	}
+
+	writeMultistatus(w, responses, syncToken(now))
+	return nil
+}
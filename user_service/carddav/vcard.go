@@ -0,0 +1,510 @@
+package carddav
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// vCardContentType is the media type of the address data served and accepted.
+const vCardContentType = "text/vcard; charset=utf-8"
+
+// card is a vCard split into the fields of a contact and the properties a contact does not model.
+type card struct {
+	UID           string
+	FormattedName string
+	FirstName     string
+	LastName      string
+	Email         string
+	Mobile        string
+	Organization  string
+	Notes         string
+	Birthday      string
+	Anniversary   string
+	Custom        map[string]string
+	Addresses     []*cardAddress
+
+	// Extra holds the properties the contact does not model, in the order they were sent.
+	Extra []*property
+}
+
+// cardAddress is an ADR property mapped onto a contact address.
+type cardAddress struct {
+	*repositories.Address
+	prop *property
+}
+
+// modeledProperties are the properties taken over by the fields of a contact, or rewritten
+// whenever the vCard is served. EMAIL and TEL are only modeled for the one address and number
+// kept on the contact, BDAY and ANNIVERSARY when they hold a date.
+var modeledProperties = map[string]bool{
+	"BEGIN": true, "END": true, "VERSION": true, "PRODID": true, "UID": true,
+	"FN": true, "N": true, "ORG": true, "NOTE": true, "ADR": true,
+}
+
+// encodeCard renders a contact as a vCard 3.0 object with its addresses. extra holds the content
+// lines of the client's vCard the contact does not model, which are written after the contact's
+// fields; extension properties of custom fields the contact has a value for, and dates the
+// contact holds, are left out of them. The anniversary is written as the vCard 4.0 ANNIVERSARY
+// property, which vCard 3.0 clients keep as an unknown property.
+func encodeCard(c *repositories.Contact, uid string, addresses []*repositories.Address, extra string) string {
+	var b strings.Builder
+	writeLine(&b, "BEGIN:VCARD")
+	writeLine(&b, "VERSION:3.0")
+	writeLine(&b, "PRODID:-//contact_manager//CardDAV//EN")
+	writeLine(&b, "UID:"+escapeValue(uid))
+
+	fn := strings.TrimSpace(c.FirstName + " " + c.LastName)
+	if fn == "" {
+		fn = c.Organization
+	}
+	writeLine(&b, "FN:"+escapeValue(fn))
+	writeLine(&b, "N:"+escapeValue(c.LastName)+";"+escapeValue(c.FirstName)+";;;")
+
+	if c.EmailID != "" {
+		writeLine(&b, "EMAIL;TYPE=INTERNET:"+escapeValue(c.EmailID))
+	}
+	if c.Mobile != "" {
+		writeLine(&b, "TEL;TYPE=CELL:"+escapeValue(c.Mobile))
+	}
+	if c.Organization != "" {
+		writeLine(&b, "ORG:"+escapeValue(c.Organization))
+	}
+	if c.Notes != "" {
+		writeLine(&b, "NOTE:"+escapeValue(c.Notes))
+	}
+	if c.Birthday != "" {
+		writeLine(&b, "BDAY:"+c.Birthday)
+	}
+	if c.Anniversary != "" {
+		writeLine(&b, "ANNIVERSARY:"+c.Anniversary)
+	}
+	for _, a := range addresses {
+		writeLine(&b, encodeAddress(a))
+	}
+	for _, key := range repositories.CustomFieldKeys(c.CustomFields) {
+		writeLine(&b, customProperty(key)+":"+escapeValue(c.CustomFields[key]))
+	}
+
+	for _, line := range strings.Split(extra, "\r\n") {
+		if line == "" {
+			continue
+		}
+		name := propertyName(line)
+		if strings.HasPrefix(name, "X-") {
+			if _, ok := c.CustomFields[customKey(name)]; ok {
+				continue
+			}
+		}
+		if (name == "BDAY" && c.Birthday != "") || (name == "ANNIVERSARY" && c.Anniversary != "") {
+			continue
+		}
+		writeLine(&b, line)
+	}
+
+	writeLine(&b, "END:VCARD")
+	return b.String()
+}
+
+// EncodeVCard renders a contact as a standalone vCard 3.0 object for use outside of CardDAV,
+// e.g. downloads. Its UID is derived from the contact ID like that of contacts never written
+// over CardDAV.
+func EncodeVCard(c *repositories.Contact) string {
+	return encodeCard(c, fmt.Sprintf("contact-%d", c.ID), nil, "")
+}
+
+// VCardContentType is the media type of the vCards returned by EncodeVCard.
+const VCardContentType = vCardContentType
+
+// customProperty returns the vCard extension property a custom field is mapped to, e.g.
+// X-CUSTOMER-ID for customer_id.
+func customProperty(key string) string {
+	return "X-" + strings.ToUpper(strings.ReplaceAll(key, "_", "-"))
+}
+
+// encodeAddress renders an ADR property. The street, locality, region, postal code and country
+// code go into their components; post office box and extended address stay empty.
+func encodeAddress(a *repositories.Address) string {
+	line := "ADR"
+	if a.Label != "" {
+		line += ";TYPE=" + strings.ToUpper(a.Label)
+	}
+	parts := []string{"", "", a.Street, a.Locality, a.Region, a.PostalCode, a.CountryCode}
+	for i, part := range parts {
+		parts[i] = escapeValue(part)
+	}
+	return line + ":" + strings.Join(parts, ";")
+}
+
+// customKey reverses customProperty.
+func customKey(property string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimPrefix(property, "X-"), "-", "_"))
+}
+
+// etag returns the strong entity tag of a rendered vCard.
+func etag(data string) string {
+	sum := sha1.Sum([]byte(data))
+	return `"` + hex.EncodeToString(sum[:]) + `"`
+}
+
+// writeLine writes a content line terminated by CRLF, folding it so that no physical line
+// exceeds 75 octets. Folds never split a UTF-8 sequence.
+func writeLine(b *strings.Builder, line string) {
+	limit := 75
+	for len(line) > limit {
+		cut := limit
+		for cut > 0 && line[cut]&0xC0 == 0x80 {
+			cut--
+		}
+		b.WriteString(line[:cut])
+		b.WriteString("\r\n ")
+		line = line[cut:]
+		limit = 74
+	}
+	b.WriteString(line)
+	b.WriteString("\r\n")
+}
+
+var valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, "\r", "", ",", `\,`, ";", `\;`)
+
+func escapeValue(s string) string {
+	return valueEscaper.Replace(s)
+}
+
+// unescapeValue reverses escapeValue.
+func unescapeValue(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 'n', 'N':
+			b.WriteByte('\n')
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+
+	return b.String()
+}
+
+// splitComponents splits a structured value on unescaped semicolons without unescaping it.
+func splitComponents(s string) []string {
+	parts := []string{}
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case ';':
+			parts = append(parts, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(parts, s[start:])
+}
+
+// property is a single unfolded content line. Line is the content line as it was sent.
+type property struct {
+	Name   string
+	Params map[string][]string
+	Value  string
+	Line   string
+}
+
+// parseProperties unfolds a vCard and splits it into properties. Group prefixes such as
+// "item1." are dropped and names are upper-cased.
+func parseProperties(data string) ([]*property, error) {
+	data = strings.ReplaceAll(data, "\r\n", "\n")
+	data = strings.ReplaceAll(data, "\n ", "")
+	data = strings.ReplaceAll(data, "\n\t", "")
+
+	props := []*property{}
+	for _, line := range strings.Split(data, "\n") {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		colon := valueSeparator(line)
+		if colon < 0 {
+			return nil, errors.New("malformed vCard line: " + line)
+		}
+
+		head := strings.Split(line[:colon], ";")
+		p := &property{Name: propertyName(line), Params: map[string][]string{}, Value: line[colon+1:], Line: line}
+		for _, param := range head[1:] {
+			// bare parameters such as TEL;CELL: are vCard 2.1 style types
+			key, value := "TYPE", param
+			if eq := strings.Index(param, "="); eq >= 0 {
+				key, value = param[:eq], param[eq+1:]
+			}
+			key = strings.ToUpper(key)
+			for _, v := range strings.Split(value, ",") {
+				p.Params[key] = append(p.Params[key], strings.ToUpper(strings.Trim(v, `"`)))
+			}
+		}
+
+		props = append(props, p)
+	}
+
+	return props, nil
+}
+
+// propertyName returns the upper-cased name of a content line without its group prefix.
+func propertyName(line string) string {
+	name := line
+	if end := strings.IndexAny(name, ";:"); end >= 0 {
+		name = name[:end]
+	}
+	name = strings.ToUpper(name)
+	if dot := strings.LastIndex(name, "."); dot >= 0 {
+		name = name[dot+1:]
+	}
+	return name
+}
+
+// valueSeparator returns the index of the colon separating a property's name and parameters
+// from its value. Colons inside quoted parameter values are skipped.
+func valueSeparator(line string) int {
+	quoted := false
+	for i := 0; i < len(line); i++ {
+		switch line[i] {
+		case '"':
+			quoted = !quoted
+		case ':':
+			if !quoted {
+				return i
+			}
+		}
+	}
+
+	return -1
+}
+
+func (p *property) hasType(t string) bool {
+	for _, v := range p.Params["TYPE"] {
+		if v == t {
+			return true
+		}
+	}
+	return false
+}
+
+// decodeCard parses a single vCard object. Phone numbers marked as cell phones are preferred
+// over other numbers, the remaining addresses and numbers are kept with the other properties the
+// contact does not model.
+func decodeCard(data string) (*card, error) {
+	props, err := parseProperties(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(props) < 2 || props[0].Name != "BEGIN" || !strings.EqualFold(props[0].Value, "VCARD") ||
+		props[len(props)-1].Name != "END" || !strings.EqualFold(props[len(props)-1].Value, "VCARD") {
+		return nil, errors.New("body is not a single vCard object")
+	}
+
+	c := &card{Custom: map[string]string{}}
+	hasN, cellPhone := false, false
+	var email, tel, bday, anniversary *property
+	for _, p := range props[1 : len(props)-1] {
+		switch p.Name {
+		case "BEGIN":
+			return nil, errors.New("body is not a single vCard object")
+		case "UID":
+			c.UID = unescapeValue(p.Value)
+		case "FN":
+			c.FormattedName = strings.TrimSpace(unescapeValue(p.Value))
+		case "N":
+			parts := splitComponents(p.Value)
+			c.LastName = strings.TrimSpace(unescapeValue(parts[0]))
+			if len(parts) > 1 {
+				c.FirstName = strings.TrimSpace(unescapeValue(parts[1]))
+			}
+			hasN = c.FirstName != "" || c.LastName != ""
+		case "EMAIL":
+			if c.Email == "" || p.hasType("PREF") {
+				c.Email = strings.TrimSpace(unescapeValue(p.Value))
+				email = p
+			}
+		case "TEL":
+			cell := p.hasType("CELL")
+			if c.Mobile == "" || (cell && !cellPhone) {
+				c.Mobile = strings.TrimPrefix(strings.TrimSpace(unescapeValue(p.Value)), "tel:")
+				cellPhone = cell
+				tel = p
+			}
+		case "ORG":
+			c.Organization = strings.TrimSpace(unescapeValue(splitComponents(p.Value)[0]))
+		case "NOTE":
+			c.Notes = unescapeValue(p.Value)
+		case "ADR":
+			c.Addresses = append(c.Addresses, &cardAddress{Address: decodeAddress(p), prop: p})
+		case "BDAY":
+			if date, ok := decodeDate(p); ok {
+				c.Birthday, bday = date, p
+			}
+		case "ANNIVERSARY":
+			if date, ok := decodeDate(p); ok {
+				c.Anniversary, anniversary = date, p
+			}
+		default:
+			if strings.HasPrefix(p.Name, "X-") {
+				c.Custom[customKey(p.Name)] = unescapeValue(p.Value)
+			}
+		}
+	}
+
+	for _, p := range props[1 : len(props)-1] {
+		if !modeledProperties[p.Name] && p != email && p != tel && p != bday && p != anniversary {
+			c.Extra = append(c.Extra, p)
+		}
+	}
+
+	if !hasN {
+		c.FirstName = c.FormattedName
+		if first, last, ok := strings.Cut(c.FormattedName, " "); ok {
+			c.FirstName, c.LastName = first, strings.TrimSpace(last)
+		}
+	}
+
+	if c.FirstName == "" && c.LastName == "" && c.Organization == "" {
+		return nil, errors.New("vCard has neither a name nor an organization")
+	}
+
+	if c.UID == "" {
+		return nil, errors.New("vCard has no UID")
+	}
+
+	return c, nil
+}
+
+// decodeDate maps a BDAY or ANNIVERSARY property onto a contact date. Basic and extended
+// formats are accepted, the time of day is dropped, and so is the year when it is left out, as in
+// --0412, or marked as omitted with X-APPLE-OMIT-YEAR. ok is false for text values and partial
+// dates such as a year alone, which the contact cannot hold.
+func decodeDate(p *property) (date string, ok bool) {
+	value := strings.TrimSpace(p.Value)
+	if t := strings.IndexByte(value, 'T'); t >= 0 {
+		value = value[:t]
+	}
+
+	digits := strings.ReplaceAll(value, "-", "")
+	switch {
+	case strings.HasPrefix(value, "--") && len(digits) == 4:
+		date = "--" + digits[:2] + "-" + digits[2:]
+	case len(digits) == 8:
+		date = digits[:4] + "-" + digits[4:6] + "-" + digits[6:]
+		for _, year := range p.Params["X-APPLE-OMIT-YEAR"] {
+			if year == digits[:4] {
+				date = "--" + digits[4:6] + "-" + digits[6:]
+			}
+		}
+	default:
+		return "", false
+	}
+
+	if _, err := repositories.ParseContactDate(date); err != nil {
+		return "", false
+	}
+
+	return date, true
+}
+
+// decodeAddress maps an ADR property onto an address. The extended address is appended to the
+// street, or the post office box used when there is no street. HOME and WORK types become the
+// label, addresses of other types are labelled other.
+func decodeAddress(p *property) *repositories.Address {
+	parts := splitComponents(p.Value)
+	for len(parts) < 7 {
+		parts = append(parts, "")
+	}
+	for i, part := range parts {
+		parts[i] = strings.TrimSpace(unescapeValue(part))
+	}
+
+	a := &repositories.Address{
+		Street:      parts[2],
+		Locality:    parts[3],
+		Region:      parts[4],
+		PostalCode:  parts[5],
+		CountryCode: parts[6],
+	}
+	switch {
+	case a.Street == "":
+		a.Street = strings.TrimSpace(parts[0] + " " + parts[1])
+	case parts[1] != "":
+		a.Street += ", " + parts[1]
+	}
+
+	switch {
+	case p.hasType("HOME"):
+		a.Label = repositories.AddressHome
+	case p.hasType("WORK"):
+		a.Label = repositories.AddressWork
+	case len(p.Params["TYPE"]) > 0:
+		a.Label = repositories.AddressOther
+	}
+
+	return a
+}
+
+// properties returns the content lines to keep with the object of the card: the properties the
+// contact does not model, except the extension properties mapped onto the given custom fields,
+// followed by the given properties the contact could not take over.
+func (c *card) properties(fields []*repositories.CustomField, rejected []*property) string {
+	mapped := map[string]bool{}
+	for _, f := range fields {
+		mapped[f.Key] = true
+	}
+
+	lines := []string{}
+	for _, p := range append(c.Extra, rejected...) {
+		if strings.HasPrefix(p.Name, "X-") && mapped[customKey(p.Name)] {
+			continue
+		}
+		lines = append(lines, p.Line)
+	}
+
+	return strings.Join(lines, "\r\n")
+}
+
+// apply copies the fields of the card onto a contact. Contacts need a first name, company-only
+// cards use the organization instead. Extension properties are mapped onto the given custom
+// fields, other extensions such as client-specific labels are ignored.
+func (c *card) apply(contact *repositories.Contact, fields []*repositories.CustomField) {
+	contact.FirstName = c.FirstName
+	contact.LastName = c.LastName
+	if contact.FirstName == "" {
+		if c.LastName != "" {
+			contact.FirstName, contact.LastName = c.LastName, ""
+		} else {
+			contact.FirstName = c.Organization
+		}
+	}
+	contact.EmailID = c.Email
+	contact.Mobile = c.Mobile
+	contact.Organization = c.Organization
+	contact.Notes = c.Notes
+	contact.Birthday = c.Birthday
+	contact.Anniversary = c.Anniversary
+
+	contact.CustomFields = map[string]string{}
+	for _, f := range fields {
+		if value, ok := c.Custom[f.Key]; ok {
+			contact.CustomFields[f.Key] = value
+		}
+	}
+}
@@ -0,0 +1,265 @@
+package carddav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// XML namespaces used by WebDAV, CardDAV and the calendarserver extensions.
+const (
+	nsDAV     = "DAV:"
+	nsCardDAV = "urn:ietf:params:xml:ns:carddav"
+	nsCS      = "http://calendarserver.org/ns/"
+)
+
+// prefixes maps the namespaces above to the prefixes used in responses.
+var prefixes = map[string]string{
+	nsDAV:     "d",
+	nsCardDAV: "card",
+	nsCS:      "cs",
+}
+
+// Property names served by the handler.
+var (
+	propResourceType     = xml.Name{Space: nsDAV, Local: "resourcetype"}
+	propDisplayName      = xml.Name{Space: nsDAV, Local: "displayname"}
+	propGetETag          = xml.Name{Space: nsDAV, Local: "getetag"}
+	propGetContentType   = xml.Name{Space: nsDAV, Local: "getcontenttype"}
+	propGetContentLength = xml.Name{Space: nsDAV, Local: "getcontentlength"}
+	propCurrentPrincipal = xml.Name{Space: nsDAV, Local: "current-user-principal"}
+	propPrincipalURL     = xml.Name{Space: nsDAV, Local: "principal-URL"}
+	propOwner            = xml.Name{Space: nsDAV, Local: "owner"}
+	propSyncToken        = xml.Name{Space: nsDAV, Local: "sync-token"}
+	propSupportedReports = xml.Name{Space: nsDAV, Local: "supported-report-set"}
+	propPrivilegeSet     = xml.Name{Space: nsDAV, Local: "current-user-privilege-set"}
+	propHomeSet          = xml.Name{Space: nsCardDAV, Local: "addressbook-home-set"}
+	propDescription      = xml.Name{Space: nsCardDAV, Local: "addressbook-description"}
+	propSupportedData    = xml.Name{Space: nsCardDAV, Local: "supported-address-data"}
+	propMaxResourceSize  = xml.Name{Space: nsCardDAV, Local: "max-resource-size"}
+	propAddressData      = xml.Name{Space: nsCardDAV, Local: "address-data"}
+	propGetCTag          = xml.Name{Space: nsCS, Local: "getctag"}
+	propPrincipalCollSet = xml.Name{Space: nsDAV, Local: "principal-collection-set"}
+)
+
+// Preconditions reported in error bodies.
+var (
+	condValidAddressData = xml.Name{Space: nsCardDAV, Local: "valid-address-data"}
+	condValidSyncToken   = xml.Name{Space: nsDAV, Local: "valid-sync-token"}
+)
+
+// node is a generic XML element of a request body.
+type node struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []*node    `xml:",any"`
+	Text     string     `xml:",chardata"`
+}
+
+// parseBody decodes an XML request body. An empty body yields a nil node.
+func parseBody(r io.Reader) (*node, error) {
+	n := &node{}
+	err := xml.NewDecoder(r).Decode(n)
+	if err == io.EOF {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+func (n *node) is(space, local string) bool {
+	return n != nil && n.XMLName.Space == space && n.XMLName.Local == local
+}
+
+// child returns the first child element with the given name.
+func (n *node) child(space, local string) *node {
+	if n == nil {
+		return nil
+	}
+	for _, c := range n.Children {
+		if c.is(space, local) {
+			return c
+		}
+	}
+	return nil
+}
+
+// all returns every child element with the given name.
+func (n *node) all(space, local string) []*node {
+	nodes := []*node{}
+	if n == nil {
+		return nodes
+	}
+	for _, c := range n.Children {
+		if c.is(space, local) {
+			nodes = append(nodes, c)
+		}
+	}
+	return nodes
+}
+
+func (n *node) attr(local string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// propRequest is the set of properties a PROPFIND or REPORT asks for.
+type propRequest struct {
+	all   bool
+	names bool
+	props []xml.Name
+}
+
+// parsePropRequest reads the allprop, propname or prop element of a request body. A missing
+// element asks for all properties.
+func parsePropRequest(body *node) *propRequest {
+	if body.child(nsDAV, "propname") != nil {
+		return &propRequest{names: true}
+	}
+
+	prop := body.child(nsDAV, "prop")
+	if prop == nil {
+		return &propRequest{all: true}
+	}
+
+	req := &propRequest{}
+	for _, c := range prop.Children {
+		req.props = append(req.props, c.XMLName)
+	}
+	return req
+}
+
+// response is one resource of a multistatus body. A non-zero status reports the resource as a
+// whole, otherwise found and missing properties are reported per propstat.
+type response struct {
+	href    string
+	status  int
+	found   map[xml.Name]string
+	missing []xml.Name
+}
+
+// newResponse selects the requested properties from the ones a resource offers. Property
+// values are inner XML.
+func newResponse(href string, available map[xml.Name]string, req *propRequest) *response {
+	resp := &response{href: href, found: map[xml.Name]string{}}
+	switch {
+	case req.names:
+		for name := range available {
+			resp.found[name] = ""
+		}
+	case req.all:
+		for name, value := range available {
+			if name != propAddressData {
+				resp.found[name] = value
+			}
+		}
+	default:
+		for _, name := range req.props {
+			if value, ok := available[name]; ok {
+				resp.found[name] = value
+			} else {
+				resp.missing = append(resp.missing, name)
+			}
+		}
+	}
+	return resp
+}
+
+// element renders an XML element, declaring its namespace inline when it has no known prefix.
+func element(name xml.Name, inner string) string {
+	tag, decl := name.Local, ""
+	if prefix, ok := prefixes[name.Space]; ok {
+		tag = prefix + ":" + name.Local
+	} else if name.Space != "" {
+		tag, decl = "x:"+name.Local, ` xmlns:x="`+escape(name.Space)+`"`
+	}
+	if inner == "" {
+		return "<" + tag + decl + "/>"
+	}
+	return "<" + tag + decl + ">" + inner + "</" + tag + ">"
+}
+
+func escape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
+
+func hrefElement(href string) string {
+	return element(xml.Name{Space: nsDAV, Local: "href"}, escape(href))
+}
+
+func statusElement(code int) string {
+	return element(xml.Name{Space: nsDAV, Local: "status"}, fmt.Sprintf("HTTP/1.1 %d %s", code, http.StatusText(code)))
+}
+
+// writeMultistatus writes a 207 Multi-Status body. syncToken is only written for sync-collection reports.
+func writeMultistatus(w http.ResponseWriter, responses []*response, syncToken string) {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>`)
+	b.WriteString(`<d:multistatus xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav" xmlns:cs="http://calendarserver.org/ns/">`)
+
+	for _, resp := range responses {
+		b.WriteString("<d:response>")
+		b.WriteString(hrefElement(resp.href))
+
+		if resp.status != 0 {
+			b.WriteString(statusElement(resp.status))
+		} else {
+			if len(resp.found) > 0 {
+				names := make([]xml.Name, 0, len(resp.found))
+				for name := range resp.found {
+					names = append(names, name)
+				}
+				sort.Slice(names, func(i, j int) bool {
+					if names[i].Space != names[j].Space {
+						return names[i].Space < names[j].Space
+					}
+					return names[i].Local < names[j].Local
+				})
+
+				b.WriteString("<d:propstat><d:prop>")
+				for _, name := range names {
+					b.WriteString(element(name, resp.found[name]))
+				}
+				b.WriteString("</d:prop>" + statusElement(http.StatusOK) + "</d:propstat>")
+			}
+
+			if len(resp.missing) > 0 {
+				b.WriteString("<d:propstat><d:prop>")
+				for _, name := range resp.missing {
+					b.WriteString(element(name, ""))
+				}
+				b.WriteString("</d:prop>" + statusElement(http.StatusNotFound) + "</d:propstat>")
+			}
+		}
+
+		b.WriteString("</d:response>")
+	}
+
+	if syncToken != "" {
+		b.WriteString(element(propSyncToken, escape(syncToken)))
+	}
+	b.WriteString("</d:multistatus>")
+
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(http.StatusMultiStatus)
+	io.WriteString(w, b.String())
+}
+
+// writeError writes a WebDAV error body naming the precondition that failed.
+func writeError(w http.ResponseWriter, code int, condition xml.Name) {
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	w.WriteHeader(code)
+	io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>`+
+		`<d:error xmlns:d="DAV:" xmlns:card="urn:ietf:params:xml:ns:carddav">`+element(condition, "")+`</d:error>`)
+}
@@ -0,0 +1,114 @@
+// Package contactcsv maps contacts to and from CSV files. The standard contact columns come
+// first, followed by one column per custom field named after the field's key.
+package contactcsv
+
+import (
+	"encoding/csv"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// standardColumns are the header names of the columns every file has.
+var standardColumns = []string{"first_name", "last_name", "email_id", "mobile", "organization", "notes", "birthday", "anniversary"}
+
+// Header returns the header row for a file carrying the given custom fields.
+func Header(fields []*repositories.CustomField) []string {
+	header := append([]string{}, standardColumns...)
+	for _, f := range fields {
+		header = append(header, f.Key)
+	}
+	return header
+}
+
+// Write writes a header row and one row per contact. Custom field values of fields not in
+// fields are left out.
+func Write(w io.Writer, contacts []*repositories.Contact, fields []*repositories.CustomField) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(Header(fields)); err != nil {
+		return err
+	}
+
+	for _, c := range contacts {
+		row := []string{c.FirstName, c.LastName, c.EmailID, c.Mobile, c.Organization, c.Notes, c.Birthday, c.Anniversary}
+		for _, f := range fields {
+			row = append(row, c.CustomFields[f.Key])
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
+
+// Read parses a file written by Write, or any file with a header row naming a subset of the
+// columns in any order. Columns matching neither a standard column nor one of fields are
+// ignored. Values are returned as found; they are validated when the contacts are stored.
+func Read(r io.Reader, fields []*repositories.CustomField) ([]*repositories.Contact, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+
+	header, err := cr.Read()
+	if err == io.EOF {
+		return nil, errors.New("CSV file has no header row")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	custom := map[string]bool{}
+	for _, f := range fields {
+		custom[f.Key] = true
+	}
+
+	contacts := []*repositories.Contact{}
+	for {
+		row, err := cr.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		c := &repositories.Contact{CustomFields: map[string]string{}}
+		for i, column := range header {
+			if i >= len(row) {
+				break
+			}
+
+			column = strings.ToLower(strings.TrimSpace(column))
+			value := strings.TrimSpace(row[i])
+			switch column {
+			case "first_name":
+				c.FirstName = value
+			case "last_name":
+				c.LastName = value
+			case "email_id":
+				c.EmailID = value
+			case "mobile":
+				c.Mobile = value
+			case "organization":
+				c.Organization = value
+			case "notes":
+				c.Notes = row[i]
+			case "birthday":
+				c.Birthday = value
+			case "anniversary":
+				c.Anniversary = value
+			default:
+				if custom[column] && value != "" {
+					c.CustomFields[column] = value
+				}
+			}
+		}
+
+		contacts = append(contacts, c)
+	}
+
+	return contacts, nil
+}
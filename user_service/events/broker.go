@@ -0,0 +1,73 @@
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"strconv"
+)
+
+// The broker sinks publish through a small interface instead of a client library, so that the
+// service depends on no broker. An adapter of a few lines wraps the client of choice.
+
+// NATSPublisher publishes a message on a NATS subject. *nats.Conn of github.com/nats-io/nats.go
+// implements it; a JetStream context needs an adapter that waits for the acknowledgement, for
+// the publication to survive a server restart.
+type NATSPublisher interface {
+	Publish(subject string, data []byte) error
+}
+
+// NATSSink publishes events on the subject <Prefix>.<aggregate type>.<event type>, e.g.
+// users.user.UserCreated for the prefix "users", so that subscribers can pick events with
+// wildcards.
+type NATSSink struct {
+	Conn   NATSPublisher
+	Prefix string
+}
+
+// NewNATSSink returns a NATSSink publishing on conn under the subject prefix.
+func NewNATSSink(conn NATSPublisher, prefix string) *NATSSink {
+	return &NATSSink{Conn: conn, Prefix: prefix}
+}
+
+// Publish publishes the JSON of the event.
+func (s *NATSSink) Publish(ctx context.Context, e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	return s.Conn.Publish(s.Prefix+"."+e.AggregateType+"."+e.Type, data)
+}
+
+// KafkaProducer writes a message to a Kafka topic and returns once the brokers have acknowledged
+// it. With github.com/segmentio/kafka-go, for instance, it is a kafka.Writer call:
+//
+//	func (p producer) Produce(ctx context.Context, topic string, key, value []byte) error {
+//		return p.writer.WriteMessages(ctx, kafka.Message{Topic: topic, Key: key, Value: value})
+//	}
+type KafkaProducer interface {
+	Produce(ctx context.Context, topic string, key, value []byte) error
+}
+
+// KafkaSink writes events to a topic, keyed by their aggregate: the events of an aggregate land
+// in the same partition, which keeps them in order for consumers.
+type KafkaSink struct {
+	Producer KafkaProducer
+	Topic    string
+}
+
+// NewKafkaSink returns a KafkaSink writing to the topic with the producer.
+func NewKafkaSink(producer KafkaProducer, topic string) *KafkaSink {
+	return &KafkaSink{Producer: producer, Topic: topic}
+}
+
+// Publish writes the JSON of the event, keyed by <aggregate type>:<aggregate id>.
+func (s *KafkaSink) Publish(ctx context.Context, e *Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	key := e.AggregateType + ":" + strconv.Itoa(e.AggregateID)
+	return s.Producer.Produce(ctx, s.Topic, []byte(key), data)
+}
@@ -0,0 +1,137 @@
+package events
+
+import (
+	"context"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// DefaultBatchSize is the number of events a Relay reads from the outbox at a time.
+const DefaultBatchSize = 100
+
+// DefaultMaxAttempts is the number of times a Relay tries to publish an event before marking it
+// failed.
+const DefaultMaxAttempts = 10
+
+// Relay periodically publishes the pending events of the outbox to a Sink and marks them
+// published. A database needs a single relay: two relays would publish the events of an
+// aggregate concurrently, and so possibly out of order.
+type Relay struct {
+	Outbox   repositories.OutboxRepository
+	Sink     Sink
+	Interval time.Duration
+
+	// BatchSize is the number of events read from the outbox at a time, DefaultBatchSize if 0.
+	BatchSize int
+
+	// MaxAttempts is the number of times an event is tried before it is marked failed and set
+	// aside, DefaultMaxAttempts if 0. The later events of its aggregate are then published, with a
+	// gap in their sequence numbers, until the failed event is retried with OutboxRepository.Retry.
+	MaxAttempts int
+
+	// OnError is called with the errors of a run, if set. A failing event does not stop the events
+	// of other aggregates.
+	OnError func(error)
+}
+
+// NewRelay returns a Relay that publishes the events of the outbox to the sink every interval.
+func NewRelay(outbox repositories.OutboxRepository, sink Sink, interval time.Duration) *Relay {
+	return &Relay{
+		Outbox:      outbox,
+		Sink:        sink,
+		Interval:    interval,
+		BatchSize:   DefaultBatchSize,
+		MaxAttempts: DefaultMaxAttempts,
+	}
+}
+
+// RunOnce publishes the pending events and returns how many were published and the first error
+// encountered. When an event cannot be published, its failure is recorded and the later events of
+// its aggregate wait for the next run, so that they are not published before it. The run goes on
+// past them to the end of the outbox, so that a failing aggregate does not hold up the others.
+func (r *Relay) RunOnce(ctx context.Context) (int, error) {
+	published := 0
+	var firstErr error
+	fail := func(err error) {
+		r.report(err)
+		if firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	batchSize := r.BatchSize
+	if batchSize <= 0 {
+		batchSize = DefaultBatchSize
+	}
+	maxAttempts := r.MaxAttempts
+	if maxAttempts <= 0 {
+		maxAttempts = DefaultMaxAttempts
+	}
+
+	blocked := map[aggregate]bool{}
+	for afterID := 0; ; {
+		pending, err := r.Outbox.GetPending(ctx, afterID, batchSize)
+		if err != nil {
+			fail(err)
+			return published, firstErr
+		}
+
+		for _, e := range pending {
+			afterID = e.ID
+			a := aggregate{e.AggregateType, e.AggregateID}
+			if blocked[a] {
+				continue
+			}
+
+			if err := r.Sink.Publish(ctx, newEvent(e)); err != nil {
+				blocked[a] = true
+				fail(err)
+				if err := r.Outbox.MarkFailed(ctx, e.ID, err.Error(), maxAttempts); err != nil {
+					fail(err)
+				}
+				continue
+			}
+
+			if err := r.Outbox.MarkPublished(ctx, e.ID); err != nil {
+				blocked[a] = true
+				fail(err)
+				continue
+			}
+			published++
+		}
+
+		// A full batch may be followed by more events
+		if len(pending) < batchSize {
+			return published, firstErr
+		}
+	}
+}
+
+// aggregate identifies the aggregate of an event.
+type aggregate struct {
+	Type string
+	ID   int
+}
+
+func (r *Relay) report(err error) {
+	if r.OnError != nil {
+		r.OnError(err)
+	}
+}
+
+// Run publishes the pending events once immediately and then every Interval until stop is closed.
+func (r *Relay) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		r.RunOnce(context.Background())
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
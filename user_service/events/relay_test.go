@@ -0,0 +1,158 @@
+package events
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/princeparmar/contact_manager/migrations"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// failingSink is a MemorySink failing the events of one aggregate.
+type failingSink struct {
+	*MemorySink
+	failing aggregate
+}
+
+func (s *failingSink) Publish(ctx context.Context, e *Event) error {
+	if (aggregate{e.AggregateType, e.AggregateID}) == s.failing {
+		return fmt.Errorf("%s %d is unavailable", e.AggregateType, e.AggregateID)
+	}
+	return s.MemorySink.Publish(ctx, e)
+}
+
+func newOutbox(t *testing.T) repositories.OutboxRepository {
+	dsn := filepath.Join(t.TempDir(), "events.db") + "?_foreign_keys=on&_busy_timeout=5000"
+	db, err := repositories.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	runner, err := migrations.NewRunner(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	return repositories.NewOutboxRepository(db)
+}
+
+// appendEvents appends an event to the outbox for each of the aggregates, in order.
+func appendEvents(t *testing.T, outbox repositories.OutboxRepository, aggregates ...aggregate) {
+	t.Helper()
+	for _, a := range aggregates {
+		e := &repositories.OutboxEvent{AggregateType: a.Type, AggregateID: a.ID, Type: "Changed", Payload: []byte(`{}`)}
+		if err := outbox.Append(context.Background(), e); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// expectPublished fails the test unless the sink published the given events, as aggregate and
+// sequence number, in order.
+func expectPublished(t *testing.T, sink *MemorySink, want ...string) {
+	t.Helper()
+	got := []string{}
+	for _, e := range sink.Published() {
+		got = append(got, fmt.Sprintf("%s%d#%d", e.AggregateType, e.AggregateID, e.Sequence))
+	}
+	if fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Fatalf("published %v, want %v", got, want)
+	}
+	sink.Reset()
+}
+
+func TestRelayKeepsAggregateOrder(t *testing.T) {
+	ctx := context.Background()
+	outbox := newOutbox(t)
+	user, role := aggregate{"user", 1}, aggregate{"role", 1}
+	appendEvents(t, outbox, user, role, user, role, user)
+
+	sink := &failingSink{MemorySink: NewMemorySink(), failing: user}
+	relay := NewRelay(outbox, sink, 0)
+	relay.BatchSize = 2
+	reported := 0
+	relay.OnError = func(error) { reported++ }
+
+	// The first user event fails, the later ones wait behind it while the role goes on
+	n, err := relay.RunOnce(ctx)
+	if n != 2 || err == nil {
+		t.Fatalf("published %d with error %v, want 2 and the failure", n, err)
+	}
+	if reported != 1 {
+		t.Errorf("reported %d errors, want 1", reported)
+	}
+	expectPublished(t, sink.MemorySink, "role1#1", "role1#2")
+
+	pending, err := outbox.GetPending(ctx, 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pending) != 3 || pending[0].Attempts != 1 || pending[0].LastError != "user 1 is unavailable" ||
+		pending[1].Attempts != 0 || pending[2].Attempts != 0 {
+		t.Fatalf("pending %+v, want the 3 user events with one attempt at the first", pending)
+	}
+
+	sink.failing = aggregate{}
+	if n, err := relay.RunOnce(ctx); n != 3 || err != nil {
+		t.Fatalf("published %d with error %v, want 3", n, err)
+	}
+	expectPublished(t, sink.MemorySink, "user1#1", "user1#2", "user1#3")
+
+	if n, err := relay.RunOnce(ctx); n != 0 || err != nil {
+		t.Fatalf("published %d with error %v from an empty outbox", n, err)
+	}
+}
+
+func TestRelayMarksFailedAfterMaxAttempts(t *testing.T) {
+	ctx := context.Background()
+	outbox := newOutbox(t)
+	user := aggregate{"user", 2}
+	appendEvents(t, outbox, user, user)
+
+	sink := &failingSink{MemorySink: NewMemorySink(), failing: user}
+	relay := NewRelay(outbox, sink, 0)
+	relay.MaxAttempts = 2
+
+	for run := 1; run <= 2; run++ {
+		if _, err := relay.RunOnce(ctx); err == nil {
+			t.Fatalf("run %d: no error", run)
+		}
+	}
+
+	failed, err := outbox.GetFailed(ctx, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(failed) != 1 || failed[0].Sequence != 1 || failed[0].Attempts != 2 || failed[0].FailedDate.IsZero() {
+		t.Fatalf("failed %+v, want the first event after 2 attempts", failed)
+	}
+
+	// The failed event is set aside, the later one is published with a gap before it
+	sink.failing = aggregate{}
+	if n, err := relay.RunOnce(ctx); n != 1 || err != nil {
+		t.Fatalf("published %d with error %v, want 1", n, err)
+	}
+	expectPublished(t, sink.MemorySink, "user2#2")
+
+	if err := outbox.Retry(ctx, failed[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := relay.RunOnce(ctx); n != 1 || err != nil {
+		t.Fatalf("published %d with error %v, want the retried event", n, err)
+	}
+	expectPublished(t, sink.MemorySink, "user2#1")
+
+	if err := outbox.MarkFailed(ctx, failed[0].ID, "late", 2); err == nil {
+		t.Error("marked a published event failed")
+	}
+	if err := outbox.Retry(ctx, failed[0].ID); err == nil {
+		t.Error("retried a published event")
+	}
+}
@@ -0,0 +1,95 @@
+// Package events publishes the domain events of users, roles, accesses and contacts to other
+// services. The repositories append every event to the outbox in the transaction of its change; a
+// Relay reads the outbox and hands the events to a Sink, such as a webhook, a message broker or
+// the webhook subscriptions of package webhooks.
+//
+// Delivery is at least once: an event is published again when the relay stops between publishing
+// it and recording that it was published, so consumers must ignore the events they have already
+// seen, by ID or by aggregate and sequence number. The events of an aggregate are published in the
+// order of their sequence numbers, which count up from 1 without gaps. An event that fails too
+// many times is set aside as failed, and leaves a gap until it is retried.
+package events
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Event is a domain event as published: the type and payload of the event, and the aggregate it
+// belongs to with its position among the events of that aggregate.
+type Event struct {
+	ID            int             `json:"id"`
+	Type          string          `json:"type"`
+	AggregateType string          `json:"aggregate_type"`
+	AggregateID   int             `json:"aggregate_id"`
+	Sequence      int             `json:"sequence"`
+	OccurredAt    time.Time       `json:"occurred_at"`
+	Payload       json.RawMessage `json:"payload"`
+}
+
+// newEvent returns the published form of an event of the outbox.
+func newEvent(e *repositories.OutboxEvent) *Event {
+	return &Event{
+		ID:            e.ID,
+		Type:          e.Type,
+		AggregateType: e.AggregateType,
+		AggregateID:   e.AggregateID,
+		Sequence:      e.Sequence,
+		OccurredAt:    e.CreatedDate,
+		Payload:       e.Payload,
+	}
+}
+
+// Sink publishes events. An event whose publication fails is retried on the next relay run,
+// before any later event of its aggregate, up to Relay.MaxAttempts times.
+type Sink interface {
+	Publish(context.Context, *Event) error
+}
+
+// MemorySink is an in-process Sink that keeps every event it receives, for tests and local
+// development. It is safe for concurrent use.
+type MemorySink struct {
+	mu        sync.Mutex
+	published []*Event
+
+	// Err, if set, is returned by Publish instead of recording the event.
+	Err error
+}
+
+// NewMemorySink returns an empty MemorySink.
+func NewMemorySink() *MemorySink {
+	return &MemorySink{}
+}
+
+// Publish records the event.
+func (m *MemorySink) Publish(ctx context.Context, e *Event) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+
+	m.published = append(m.published, e)
+	return nil
+}
+
+// Published returns the events recorded so far, oldest first.
+func (m *MemorySink) Published() []*Event {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*Event{}, m.published...)
+}
+
+// Reset forgets the recorded events.
+func (m *MemorySink) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.published = nil
+}
@@ -0,0 +1,74 @@
+package events
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// defaultWebhookTimeout is the longest a WebhookSink waits for a response when it has no client.
+const defaultWebhookTimeout = 10 * time.Second
+
+// WebhookSink publishes events by POSTing their JSON to a URL. Any 2xx response acknowledges the
+// event; other responses and transport errors fail it. The X-Event-ID header carries the ID of the
+// event for receivers that deduplicate before parsing the body.
+type WebhookSink struct {
+	URL string
+
+	// Header holds extra headers sent with every request, e.g. for authentication.
+	Header http.Header
+
+	// Client sends the requests, or a client with a 10 second timeout if nil.
+	Client *http.Client
+}
+
+// NewWebhookSink returns a WebhookSink posting to the URL.
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{URL: url, Header: http.Header{}}
+}
+
+// Publish posts the event to the URL.
+func (s *WebhookSink) Publish(ctx context.Context, e *Event) error {
+	body, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, s.URL, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+
+	for name, values := range s.Header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Event-ID", strconv.Itoa(e.ID))
+	req.Header.Set("X-Event-Type", e.Type)
+
+	client := s.Client
+	if client == nil {
+		client = &http.Client{Timeout: defaultWebhookTimeout}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	// Drain the body so that the connection can be reused.
+	io.Copy(ioutil.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook %s answered event %d with %s", s.URL, e.ID, resp.Status)
+	}
+
+	return nil
+}
@@ -26,6 +26,14 @@ func createAccessModel(a *Access) *repositories.Access {
 	}
 }
 
+// accessDocument maps an Access model to the Access representation that patches apply to.
+func accessDocument(a *repositories.Access) *Access {
+	return &Access{
+		ID:   a.ID,
+		Name: a.Name,
+	}
+}
+
 // ParseRequest parses the HTTP request and extracts any relevant data into the Access object.
 func (a *Access) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
 	if r.Method == http.MethodPost {
@@ -80,7 +88,7 @@ func NewCreateAccessExecutor(repo repositories.AccessRepository) clienthelper.AP
 // and any errors that occur during execution.
 func (e *CreateAccessExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	access := createAccessModel(&e.Access)
-	err := e.AccessRepo.Create(access)
+	err := e.AccessRepo.Create(requestContext(ctx), access)
 	if err != nil {
 		return nil, err
 	}
@@ -89,8 +97,11 @@ func (e *CreateAccessExecutor) Controller(ctx context.IContext) (interface{}, er
 }
 
 // UpdateAccessExecutor defines an APIExecutor for updating an access by ID.
+// The request sends the ETag of the access in the If-Match header; a stale one is answered with
+// 412 Precondition Failed and the current access.
 type UpdateAccessExecutor struct {
 	Access
+	Precondition
 	clienthelper.BaseAPIExecutor
 	AccessRepo repositories.AccessRepository
 }
@@ -102,21 +113,140 @@ func NewUpdateAccessExecutor(repo repositories.AccessRepository) clienthelper.AP
 	}
 }
 
+// ParseRequest parses the access and the If-Match header into the UpdateAccessExecutor object.
+func (e *UpdateAccessExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.Access.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the access and checks that the request names the version it updates.
+func (e *UpdateAccessExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.Access.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.Precondition.ValidateRequest(ctx)
+}
+
 // Controller executes the business logic for updating an access by ID and returns the updated access
 // and any errors that occur during execution.
 func (e *UpdateAccessExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	access := createAccessModel(&e.Access)
-	err := e.AccessRepo.Update(access)
+	access.Version = e.Precondition.IfMatch
+	err := e.AccessRepo.Update(requestContext(ctx), access)
+	if errors.Is(err, repositories.ErrVersionConflict) {
+		current, err := e.AccessRepo.Get(requestContext(ctx), access.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, e.Precondition.failed(current, current.Version)
+	}
 	if err != nil {
 		return nil, err
 	}
 
+	e.Precondition.setETag(access.Version)
+
 	return access, nil
 }
 
-// DeleteAccessExecutor defines an APIExecutor for deleting an access mode by ID.
-type DeleteAccessExecutor struct {
+// PatchAccessExecutor defines an APIExecutor for partially updating an access by ID with a JSON merge patch or
+// a JSON Patch. Only the fields the patch changes are written. Like updates, it requires the ETag of the access
+// in the If-Match header.
+type PatchAccessExecutor struct {
 	Access
+	PatchRequest
+	Precondition
+	clienthelper.BaseAPIExecutor
+	AccessRepo repositories.AccessRepository
+}
+
+// NewPatchAccessExecutor returns a new instance of PatchAccessExecutor.
+func NewPatchAccessExecutor(repo repositories.AccessRepository) clienthelper.APIExecutor {
+	return &PatchAccessExecutor{
+		AccessRepo: repo,
+	}
+}
+
+// ParseRequest parses the id query parameter, the patch and the If-Match header into the PatchAccessExecutor object.
+func (e *PatchAccessExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.Access.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	if err := e.PatchRequest.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest checks the patch and that the request names the version of the access it patches. The
+// patched access is validated once the patch is applied.
+func (e *PatchAccessExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.PatchRequest.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.Precondition.ValidateRequest(ctx)
+}
+
+// Controller executes the business logic for patching an access by ID and returns the patched access
+// and any errors that occur during execution.
+func (e *PatchAccessExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	current, err := e.AccessRepo.Get(requestContext(ctx), e.Access.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if current.Version != e.Precondition.IfMatch {
+		return nil, e.Precondition.failed(current, current.Version)
+	}
+
+	patched := &Access{}
+	if err := e.PatchRequest.apply(accessDocument(current), patched); err != nil {
+		return nil, err
+	}
+
+	if patched.ID != current.ID {
+		return nil, errors.New("id cannot be patched")
+	}
+
+	if err := patched.ValidateRequest(ctx); err != nil {
+		return nil, err
+	}
+
+	access := createAccessModel(patched)
+	access.Version = current.Version
+	if fields := repositories.ChangedAccessFields(current, access); len(fields) > 0 {
+		err := e.AccessRepo.Patch(requestContext(ctx), access, fields)
+		if errors.Is(err, repositories.ErrVersionConflict) {
+			current, err := e.AccessRepo.Get(requestContext(ctx), access.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, e.Precondition.failed(current, current.Version)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e.Precondition.setETag(access.Version)
+
+	return access, nil
+}
+
+// DeleteAccessExecutor defines an APIExecutor for moving an access mode to the trash by ID.
+// Like updates, it requires the ETag of the access in the If-Match header.
+type DeleteAccessExecutor struct {
+	TrashRequest
+	Precondition
 	clienthelper.BaseAPIExecutor
 	AccessRepo repositories.AccessRepository
 }
@@ -128,10 +258,35 @@ func NewDeleteAccessExecutor(repo repositories.AccessRepository) clienthelper.AP
 	}
 }
 
+// ParseRequest parses the id query parameter, the authenticated user and the If-Match header into the DeleteAccessExecutor object.
+func (e *DeleteAccessExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.TrashRequest.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest checks that the request names the version of the access it deletes.
+func (e *DeleteAccessExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.TrashRequest.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.Precondition.ValidateRequest(ctx)
+}
+
 // Controller executes the business logic for deleting an access mode by ID and returns any errors that occur during execution.
 func (e *DeleteAccessExecutor) Controller(ctx context.IContext) (interface{}, error) {
-	id := e.Access.ID
-	err := e.AccessRepo.Delete(id)
+	err := e.AccessRepo.Delete(requestContext(ctx), e.TrashRequest.ID, e.Precondition.IfMatch, e.TrashRequest.UserID)
+	if errors.Is(err, repositories.ErrVersionConflict) {
+		current, err := e.AccessRepo.Get(requestContext(ctx), e.TrashRequest.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, e.Precondition.failed(current, current.Version)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -142,6 +297,7 @@ func (e *DeleteAccessExecutor) Controller(ctx context.IContext) (interface{}, er
 // GetAccessExecutor defines an APIExecutor for getting an access by ID.
 type GetAccessExecutor struct {
 	Access
+	Precondition
 	clienthelper.BaseAPIExecutor
 	AccessRepo repositories.AccessRepository
 }
@@ -153,22 +309,41 @@ func NewGetAccessExecutor(repo repositories.AccessRepository) clienthelper.APIEx
 	}
 }
 
+// ParseRequest parses the id query parameter into the GetAccessExecutor object, and keeps the response writer
+// to set the ETag header on.
+func (e *GetAccessExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.Access.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the data in the GetAccessExecutor object. Reads do not need an If-Match header.
+func (e *GetAccessExecutor) ValidateRequest(ctx context.IContext) error {
+	return e.Access.ValidateRequest(ctx)
+}
+
 // Controller executes the business logic for getting an access by ID and returns the access
 // and any errors that occur during execution.
 func (e *GetAccessExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	id := e.Access.ID
-	access, err := e.AccessRepo.Get(id)
+	access, err := e.AccessRepo.Get(requestContext(ctx), id)
 	if err != nil {
 		return nil, err
 	}
 
+	e.Precondition.setETag(access.Version)
+
 	return access, nil
 }
 
-// GetAllAccessesExecutor defines an APIExecutor for getting all accesses.
+// GetAllAccessesExecutor defines an APIExecutor for getting a page of the accesses.
 type GetAllAccessesExecutor struct {
+	PageQuery
 	clienthelper.BaseAPIExecutor
 	AccessRepo repositories.AccessRepository
+	Filter     repositories.AccessFilter
 }
 
 // NewGetAllAccessesExecutor returns a new instance of GetAllAccessesExecutor.
@@ -178,8 +353,32 @@ func NewGetAllAccessesExecutor(repo repositories.AccessRepository) clienthelper.
 	}
 }
 
-// Controller executes the business logic for getting all accesses and returns the accesses
+// ParseRequest parses the role_id and name_prefix filters and the page of the request into the GetAllAccessesExecutor object.
+func (e *GetAllAccessesExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+	e.Filter = repositories.AccessFilter{NamePrefix: values.Get("name_prefix")}
+	if roleID := values.Get("role_id"); roleID != "" {
+		var err error
+		if e.Filter.RoleID, err = strconv.Atoi(roleID); err != nil {
+			return errors.New("invalid role_id in query")
+		}
+	}
+
+	return e.PageQuery.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest checks that the accesses can be sorted as requested.
+func (e *GetAllAccessesExecutor) ValidateRequest(ctx context.IContext) error {
+	return e.PageQuery.Page.Validate(repositories.AccessSortFields...)
+}
+
+// Controller executes the business logic for getting a page of the accesses and returns the page
 // and any errors that occur during execution.
 func (e *GetAllAccessesExecutor) Controller(ctx context.IContext) (interface{}, error) {
-	return e.AccessRepo.GetAll()
+	accesses, info, err := e.AccessRepo.GetPage(requestContext(ctx), e.Filter, e.PageQuery.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	return newListResponse(accesses, info), nil
 }
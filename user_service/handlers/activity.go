@@ -0,0 +1,338 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// maxTimelineLimit caps the number of activities returned by a single timeline request.
+const maxTimelineLimit = 100
+
+// Activity defines a struct for activity data. user_id is the author, the authenticated user; occurred_date
+// defaults to the time the activity is logged.
+type Activity struct {
+	ID           int       `json:"id"`
+	UserID       int       `json:"user_id"`
+	Type         string    `json:"type"`
+	Subject      string    `json:"subject"`
+	Body         string    `json:"body"`
+	OccurredDate time.Time `json:"occurred_date"`
+	ContactIDs   []int     `json:"contact_ids"`
+}
+
+// createActivityModel maps Activity to Activity model.
+func createActivityModel(a *Activity) *repositories.Activity {
+	occurred := a.OccurredDate
+	if occurred.IsZero() {
+		occurred = time.Now()
+	}
+
+	return &repositories.Activity{
+		ID:           a.ID,
+		UserID:       a.UserID,
+		Type:         a.Type,
+		Subject:      strings.TrimSpace(a.Subject),
+		Body:         a.Body,
+		OccurredDate: occurred,
+		ContactIDs:   a.ContactIDs,
+	}
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Activity object.
+func (a *Activity) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the Activity object
+		err = json.Unmarshal(body, a)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	a.ID = i
+
+	// The acting user is the authenticated user, whatever the body says
+	a.UserID, err = actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Activity object and returns any errors that occur during validation.
+func (a *Activity) ValidateRequest(ctx context.IContext) error {
+	if a.UserID == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// requireActivityContacts checks that the user may write to every contact an activity is linked to.
+func requireActivityContacts(ctx context.IContext, contacts repositories.ContactRepository, books repositories.AddressBookRepository, a *repositories.Activity, userID int) error {
+	if err := repositories.ValidateActivityType(a.Type); err != nil {
+		return err
+	}
+
+	if len(a.ContactIDs) == 0 {
+		return errors.New("contact_ids field is required")
+	}
+
+	for _, contactID := range a.ContactIDs {
+		_, err := requireContactPermission(ctx, contacts, books, contactID, userID, repositories.PermissionEditor)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CreateActivityExecutor defines an APIExecutor for logging an activity on the timeline of one or more
+// contacts. The acting user becomes the author and needs write access to every contact.
+type CreateActivityExecutor struct {
+	Activity
+	clienthelper.BaseAPIExecutor
+	ActivityRepo repositories.ActivityRepository
+	ContactRepo  repositories.ContactRepository
+	BookRepo     repositories.AddressBookRepository
+}
+
+// NewCreateActivityExecutor returns a new instance of CreateActivityExecutor.
+func NewCreateActivityExecutor(repo repositories.ActivityRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &CreateActivityExecutor{
+		ActivityRepo: repo,
+		ContactRepo:  contacts,
+		BookRepo:     books,
+	}
+}
+
+// Controller executes the business logic for logging an activity and returns the created activity
+// and any errors that occur during execution.
+func (e *CreateActivityExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	activity := createActivityModel(&e.Activity)
+	err := requireActivityContacts(ctx, e.ContactRepo, e.BookRepo, activity, e.Activity.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.ActivityRepo.Create(requestContext(ctx), activity)
+	if err != nil {
+		return nil, err
+	}
+
+	return activity, nil
+}
+
+// UpdateActivityExecutor defines an APIExecutor for changing an activity. Only the author can change an
+// activity.
+type UpdateActivityExecutor struct {
+	Activity
+	clienthelper.BaseAPIExecutor
+	ActivityRepo repositories.ActivityRepository
+	ContactRepo  repositories.ContactRepository
+	BookRepo     repositories.AddressBookRepository
+}
+
+// NewUpdateActivityExecutor returns a new instance of UpdateActivityExecutor.
+func NewUpdateActivityExecutor(repo repositories.ActivityRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &UpdateActivityExecutor{
+		ActivityRepo: repo,
+		ContactRepo:  contacts,
+		BookRepo:     books,
+	}
+}
+
+// Controller executes the business logic for updating an activity and returns the updated activity
+// and any errors that occur during execution.
+func (e *UpdateActivityExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	existing, err := e.ActivityRepo.Get(requestContext(ctx), e.Activity.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing.UserID != e.Activity.UserID {
+		return nil, repositories.ErrPermissionDenied
+	}
+
+	activity := createActivityModel(&e.Activity)
+	if e.Activity.OccurredDate.IsZero() {
+		activity.OccurredDate = existing.OccurredDate
+	}
+
+	err = requireActivityContacts(ctx, e.ContactRepo, e.BookRepo, activity, e.Activity.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.ActivityRepo.Update(requestContext(ctx), activity)
+	if err != nil {
+		return nil, err
+	}
+
+	return activity, nil
+}
+
+// DeleteActivityExecutor defines an APIExecutor for deleting an activity by ID. Only the author can delete
+// an activity.
+type DeleteActivityExecutor struct {
+	Activity
+	clienthelper.BaseAPIExecutor
+	ActivityRepo repositories.ActivityRepository
+}
+
+// NewDeleteActivityExecutor returns a new instance of DeleteActivityExecutor.
+func NewDeleteActivityExecutor(repo repositories.ActivityRepository) clienthelper.APIExecutor {
+	return &DeleteActivityExecutor{
+		ActivityRepo: repo,
+	}
+}
+
+// Controller executes the business logic for deleting an activity by ID and returns any errors that occur during execution.
+func (e *DeleteActivityExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	existing, err := e.ActivityRepo.Get(requestContext(ctx), e.Activity.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if existing.UserID != e.Activity.UserID {
+		return nil, repositories.ErrPermissionDenied
+	}
+
+	err = e.ActivityRepo.Delete(requestContext(ctx), existing.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// TimelineQuery defines a struct for timeline requests.
+type TimelineQuery struct {
+	UserID    int
+	ContactID int
+	Types     []string
+	AuthorID  int
+	Limit     int
+	Offset    int
+}
+
+// ParseRequest parses the query parameters of the HTTP request into the TimelineQuery object. type may be
+// repeated or given as a comma separated list and author_id restricts the timeline to a single author.
+func (q *TimelineQuery) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+	q.UserID = userID
+
+	contactID, err := strconv.Atoi(values.Get("contact_id"))
+	if err != nil {
+		return errors.New("invalid contact_id in query")
+	}
+	q.ContactID = contactID
+
+	q.Types = splitQueryValues(values["type"])
+
+	if authorID := values.Get("author_id"); authorID != "" {
+		if q.AuthorID, err = strconv.Atoi(authorID); err != nil {
+			return errors.New("invalid author_id in query")
+		}
+	}
+
+	q.Limit = 20
+	if limit := values.Get("limit"); limit != "" {
+		if q.Limit, err = strconv.Atoi(limit); err != nil {
+			return errors.New("invalid limit in query")
+		}
+	}
+
+	if offset := values.Get("offset"); offset != "" {
+		if q.Offset, err = strconv.Atoi(offset); err != nil {
+			return errors.New("invalid offset in query")
+		}
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the TimelineQuery object and returns any errors that occur during validation.
+func (q *TimelineQuery) ValidateRequest(ctx context.IContext) error {
+	for _, t := range q.Types {
+		if err := repositories.ValidateActivityType(t); err != nil {
+			return err
+		}
+	}
+
+	if q.Limit <= 0 || q.Limit > maxTimelineLimit {
+		return errors.New("limit must be between 1 and 100")
+	}
+
+	if q.Offset < 0 {
+		return errors.New("offset must not be negative")
+	}
+
+	return nil
+}
+
+// GetTimelineExecutor defines an APIExecutor for getting a page of the timeline of a contact, most recent
+// activity first.
+type GetTimelineExecutor struct {
+	TimelineQuery
+	clienthelper.BaseAPIExecutor
+	ActivityRepo repositories.ActivityRepository
+	ContactRepo  repositories.ContactRepository
+	BookRepo     repositories.AddressBookRepository
+}
+
+// NewGetTimelineExecutor returns a new instance of GetTimelineExecutor.
+func NewGetTimelineExecutor(repo repositories.ActivityRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetTimelineExecutor{
+		ActivityRepo: repo,
+		ContactRepo:  contacts,
+		BookRepo:     books,
+	}
+}
+
+// Controller executes the business logic for getting the timeline of a contact and returns the activities
+// and any errors that occur during execution.
+func (e *GetTimelineExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	contacts := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, e.TimelineQuery.UserID)
+	if _, err := contacts.Get(requestContext(ctx), e.TimelineQuery.ContactID); err != nil {
+		return nil, err
+	}
+
+	return e.ActivityRepo.GetTimeline(requestContext(ctx), &repositories.ActivityFilter{
+		ContactID: e.TimelineQuery.ContactID,
+		Types:     e.TimelineQuery.Types,
+		AuthorID:  e.TimelineQuery.AuthorID,
+		Limit:     e.TimelineQuery.Limit,
+		Offset:    e.TimelineQuery.Offset,
+	})
+}
@@ -0,0 +1,327 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/addresses"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// Address defines a struct for contact address data. An address is given either as free text,
+// which is parsed into its parts with country as the default country, or part by part. country
+// accepts an ISO 3166-1 alpha-2 code or a country name.
+type Address struct {
+	ID         int    `json:"id"`
+	UserID     int    `json:"user_id"`
+	ContactID  int    `json:"contact_id"`
+	Label      string `json:"label"`
+	Text       string `json:"text"`
+	Street     string `json:"street"`
+	Locality   string `json:"locality"`
+	Region     string `json:"region"`
+	PostalCode string `json:"postal_code"`
+	Country    string `json:"country"`
+}
+
+// createAddressModel maps Address to Address model, parsing the free text if there is any.
+func createAddressModel(a *Address) (*repositories.Address, error) {
+	if a.Text == "" {
+		return &repositories.Address{
+			ID:          a.ID,
+			ContactID:   a.ContactID,
+			Label:       a.Label,
+			Street:      a.Street,
+			Locality:    a.Locality,
+			Region:      a.Region,
+			PostalCode:  a.PostalCode,
+			CountryCode: a.Country,
+		}, nil
+	}
+
+	country, err := addresses.NormalizeCountry(a.Country)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := addresses.Parse(a.Text, country)
+	if err != nil {
+		return nil, err
+	}
+
+	address.ID, address.ContactID, address.Label = a.ID, a.ContactID, a.Label
+	return address, nil
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Address object.
+func (a *Address) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the Address object
+		err = json.Unmarshal(body, a)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	a.ID = i
+
+	// The acting user is the authenticated user, whatever the body says
+	a.UserID, err = actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Address object and returns any errors that occur during validation.
+func (a *Address) ValidateRequest(ctx context.IContext) error {
+	if a.UserID == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// CreateAddressExecutor defines an APIExecutor for adding an address to a contact. The address is
+// normalized, validated and geocoded before it is stored.
+type CreateAddressExecutor struct {
+	Address
+	clienthelper.BaseAPIExecutor
+	AddressService *addresses.Service
+	ContactRepo    repositories.ContactRepository
+	BookRepo       repositories.AddressBookRepository
+}
+
+// NewCreateAddressExecutor returns a new instance of CreateAddressExecutor.
+func NewCreateAddressExecutor(service *addresses.Service, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &CreateAddressExecutor{
+		AddressService: service,
+		ContactRepo:    contacts,
+		BookRepo:       books,
+	}
+}
+
+// Controller executes the business logic for adding an address and returns the stored address and any
+// errors that occur during execution.
+func (e *CreateAddressExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	_, err := requireContactPermission(ctx, e.ContactRepo, e.BookRepo, e.Address.ContactID, e.Address.UserID, repositories.PermissionEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := createAddressModel(&e.Address)
+	if err != nil {
+		return nil, err
+	}
+	address.ID = 0
+
+	err = e.AddressService.Save(requestContext(ctx), address)
+	if err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
+
+// UpdateAddressExecutor defines an APIExecutor for replacing an address of a contact. The contact of an
+// address is fixed.
+type UpdateAddressExecutor struct {
+	Address
+	clienthelper.BaseAPIExecutor
+	AddressService *addresses.Service
+	ContactRepo    repositories.ContactRepository
+	BookRepo       repositories.AddressBookRepository
+}
+
+// NewUpdateAddressExecutor returns a new instance of UpdateAddressExecutor.
+func NewUpdateAddressExecutor(service *addresses.Service, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &UpdateAddressExecutor{
+		AddressService: service,
+		ContactRepo:    contacts,
+		BookRepo:       books,
+	}
+}
+
+// Controller executes the business logic for updating an address and returns the updated address and any
+// errors that occur during execution.
+func (e *UpdateAddressExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	existing, err := e.AddressService.Repo.Get(requestContext(ctx), e.Address.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = requireContactPermission(ctx, e.ContactRepo, e.BookRepo, existing.ContactID, e.Address.UserID, repositories.PermissionEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	address, err := createAddressModel(&e.Address)
+	if err != nil {
+		return nil, err
+	}
+	address.ContactID = existing.ContactID
+
+	err = e.AddressService.Save(requestContext(ctx), address)
+	if err != nil {
+		return nil, err
+	}
+
+	return address, nil
+}
+
+// DeleteAddressExecutor defines an APIExecutor for deleting an address by ID.
+type DeleteAddressExecutor struct {
+	Address
+	clienthelper.BaseAPIExecutor
+	AddressRepo repositories.AddressRepository
+	ContactRepo repositories.ContactRepository
+	BookRepo    repositories.AddressBookRepository
+}
+
+// NewDeleteAddressExecutor returns a new instance of DeleteAddressExecutor.
+func NewDeleteAddressExecutor(repo repositories.AddressRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &DeleteAddressExecutor{
+		AddressRepo: repo,
+		ContactRepo: contacts,
+		BookRepo:    books,
+	}
+}
+
+// Controller executes the business logic for deleting an address and returns any errors that occur during execution.
+func (e *DeleteAddressExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	address, err := e.AddressRepo.Get(requestContext(ctx), e.Address.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = requireContactPermission(ctx, e.ContactRepo, e.BookRepo, address.ContactID, e.Address.UserID, repositories.PermissionEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.AddressRepo.Delete(requestContext(ctx), address.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// GetContactAddressesExecutor defines an APIExecutor for listing the addresses of a contact.
+type GetContactAddressesExecutor struct {
+	ContactItemsQuery
+	clienthelper.BaseAPIExecutor
+	AddressRepo repositories.AddressRepository
+	ContactRepo repositories.ContactRepository
+	BookRepo    repositories.AddressBookRepository
+}
+
+// NewGetContactAddressesExecutor returns a new instance of GetContactAddressesExecutor.
+func NewGetContactAddressesExecutor(repo repositories.AddressRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetContactAddressesExecutor{
+		AddressRepo: repo,
+		ContactRepo: contacts,
+		BookRepo:    books,
+	}
+}
+
+// Controller executes the business logic for listing the addresses of a contact and returns the addresses
+// and any errors that occur during execution.
+func (e *GetContactAddressesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	q := &e.ContactItemsQuery
+	_, err := requireContactPermission(ctx, e.ContactRepo, e.BookRepo, q.ContactID, q.UserID, repositories.PermissionViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.AddressRepo.GetAll(requestContext(ctx), q.ContactID)
+}
+
+// GetAddressesExecutor defines an APIExecutor for the addresses of the contacts a user can read, for
+// directories sorted by place and for maps. The contacts are selected with the filters of ContactQuery;
+// with a bbox, only the addresses inside it are returned.
+type GetAddressesExecutor struct {
+	ContactQuery
+	clienthelper.BaseAPIExecutor
+	AddressRepo repositories.AddressRepository
+	ContactRepo repositories.ContactRepository
+	BookRepo    repositories.AddressBookRepository
+}
+
+// NewGetAddressesExecutor returns a new instance of GetAddressesExecutor.
+func NewGetAddressesExecutor(repo repositories.AddressRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetAddressesExecutor{
+		AddressRepo: repo,
+		ContactRepo: contacts,
+		BookRepo:    books,
+	}
+}
+
+// Controller executes the business logic for listing addresses, sorted by country, region and locality, and
+// returns the addresses and any errors that occur during execution.
+func (e *GetAddressesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	repo := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, e.ContactQuery.UserID)
+	contacts, err := repo.GetAll(requestContext(ctx), e.ContactQuery.filter())
+	if err != nil {
+		return nil, err
+	}
+
+	ids := []int{}
+	for _, c := range contacts {
+		ids = append(ids, c.ID)
+	}
+
+	all, err := e.AddressRepo.GetForContacts(requestContext(ctx), ids)
+	if err != nil {
+		return nil, err
+	}
+
+	result := []*repositories.Address{}
+	for _, a := range all {
+		if e.ContactQuery.Within == nil || within(e.ContactQuery.Within, a) {
+			result = append(result, a)
+		}
+	}
+
+	return result, nil
+}
+
+// within reports whether an address has been geocoded to a location inside b.
+func within(b *repositories.GeoBounds, a *repositories.Address) bool {
+	if a.Latitude == nil || a.Longitude == nil {
+		return false
+	}
+
+	lat, lng := *a.Latitude, *a.Longitude
+	if lat < b.MinLatitude || lat > b.MaxLatitude {
+		return false
+	}
+
+	if b.MinLongitude <= b.MaxLongitude {
+		return lng >= b.MinLongitude && lng <= b.MaxLongitude
+	}
+	return lng >= b.MinLongitude || lng <= b.MaxLongitude
+}
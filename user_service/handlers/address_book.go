@@ -0,0 +1,463 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// AddressBook defines a struct for address book data.
+type AddressBook struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// createAddressBookModel maps AddressBook to AddressBook model.
+func createAddressBookModel(b *AddressBook) *repositories.AddressBook {
+	return &repositories.AddressBook{
+		ID:     b.ID,
+		UserID: b.UserID,
+		Name:   b.Name,
+	}
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the AddressBook object.
+func (b *AddressBook) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the AddressBook object
+		err = json.Unmarshal(body, b)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	b.ID = i
+
+	// The acting user is the authenticated user, whatever the body says
+	b.UserID, err = actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the AddressBook object and returns any errors that occur during validation.
+func (b *AddressBook) ValidateRequest(ctx context.IContext) error {
+	if b.UserID == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// requireAddressBookPermission returns ErrPermissionDenied unless the user holds at least the
+// given permission on the address book.
+func requireAddressBookPermission(ctx context.IContext, repo repositories.AddressBookRepository, addressBookID, userID int, permission repositories.Permission) error {
+	granted, err := repo.GetPermission(requestContext(ctx), addressBookID, userID)
+	if err != nil {
+		return err
+	}
+
+	if granted < permission {
+		return repositories.ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// requireScopePermission returns ErrPermissionDenied unless the user holds at least the given
+// permission on the scope of an item that lives either in an address book or, when addressBookID
+// is zero, with the personal contacts of ownerID. Personal items are only accessible to their owner.
+func requireScopePermission(ctx context.IContext, repo repositories.AddressBookRepository, addressBookID, ownerID, userID int, permission repositories.Permission) error {
+	if addressBookID == 0 {
+		if ownerID != userID {
+			return repositories.ErrPermissionDenied
+		}
+		return nil
+	}
+
+	return requireAddressBookPermission(ctx, repo, addressBookID, userID, permission)
+}
+
+// ScopeQuery defines a struct for list requests scoped to an address book, or to the acting
+// user's personal contacts when no address_book_id is given.
+type ScopeQuery struct {
+	UserID        int
+	AddressBookID int
+}
+
+// ParseRequest parses the authenticated user and the optional address_book_id query parameter into the ScopeQuery object.
+func (q *ScopeQuery) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+	q.UserID = userID
+
+	if addressBookID := values.Get("address_book_id"); addressBookID != "" {
+		q.AddressBookID, err = strconv.Atoi(addressBookID)
+		if err != nil {
+			return errors.New("invalid address_book_id in query")
+		}
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the ScopeQuery object and returns any errors that occur during validation.
+func (q *ScopeQuery) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// CreateAddressBookExecutor defines an APIExecutor for creating a new address book owned by the acting user.
+type CreateAddressBookExecutor struct {
+	AddressBook
+	clienthelper.BaseAPIExecutor
+	BookRepo repositories.AddressBookRepository
+}
+
+// NewCreateAddressBookExecutor returns a new instance of CreateAddressBookExecutor.
+func NewCreateAddressBookExecutor(repo repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &CreateAddressBookExecutor{
+		BookRepo: repo,
+	}
+}
+
+// Controller executes the business logic for creating a new address book and returns the created address book
+// and any errors that occur during execution.
+func (e *CreateAddressBookExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if e.AddressBook.Name == "" {
+		return nil, errors.New("address book name is required")
+	}
+
+	book := createAddressBookModel(&e.AddressBook)
+	err := e.BookRepo.Create(requestContext(ctx), book)
+	if err != nil {
+		return nil, err
+	}
+
+	return book, nil
+}
+
+// UpdateAddressBookExecutor defines an APIExecutor for renaming an address book. Only owners may rename.
+type UpdateAddressBookExecutor struct {
+	AddressBook
+	clienthelper.BaseAPIExecutor
+	BookRepo repositories.AddressBookRepository
+}
+
+// NewUpdateAddressBookExecutor returns a new instance of UpdateAddressBookExecutor.
+func NewUpdateAddressBookExecutor(repo repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &UpdateAddressBookExecutor{
+		BookRepo: repo,
+	}
+}
+
+// Controller executes the business logic for renaming an address book and returns the updated address book
+// and any errors that occur during execution.
+func (e *UpdateAddressBookExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if e.AddressBook.Name == "" {
+		return nil, errors.New("address book name is required")
+	}
+
+	err := requireAddressBookPermission(ctx, e.BookRepo, e.AddressBook.ID, e.AddressBook.UserID, repositories.PermissionOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	book := createAddressBookModel(&e.AddressBook)
+	err = e.BookRepo.Update(requestContext(ctx), book)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.BookRepo.Get(requestContext(ctx), book.ID)
+}
+
+// DeleteAddressBookExecutor defines an APIExecutor for deleting an empty address book. Only owners
+// may delete, and only once its contacts have been moved or deleted and the trash emptied of them.
+type DeleteAddressBookExecutor struct {
+	AddressBook
+	clienthelper.BaseAPIExecutor
+	BookRepo repositories.AddressBookRepository
+}
+
+// NewDeleteAddressBookExecutor returns a new instance of DeleteAddressBookExecutor.
+func NewDeleteAddressBookExecutor(repo repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &DeleteAddressBookExecutor{
+		BookRepo: repo,
+	}
+}
+
+// Controller executes the business logic for deleting an address book and returns any errors that occur during execution.
+func (e *DeleteAddressBookExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	err := requireAddressBookPermission(ctx, e.BookRepo, e.AddressBook.ID, e.AddressBook.UserID, repositories.PermissionOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.BookRepo.Delete(requestContext(ctx), e.AddressBook.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// GetAddressBookExecutor defines an APIExecutor for getting an address book the acting user can view.
+type GetAddressBookExecutor struct {
+	AddressBook
+	clienthelper.BaseAPIExecutor
+	BookRepo repositories.AddressBookRepository
+}
+
+// NewGetAddressBookExecutor returns a new instance of GetAddressBookExecutor.
+func NewGetAddressBookExecutor(repo repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetAddressBookExecutor{
+		BookRepo: repo,
+	}
+}
+
+// Controller executes the business logic for getting an address book and returns the address book with the
+// acting user's permission and any errors that occur during execution.
+func (e *GetAddressBookExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	permission, err := e.BookRepo.GetPermission(requestContext(ctx), e.AddressBook.ID, e.AddressBook.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if permission < repositories.PermissionViewer {
+		return nil, repositories.ErrPermissionDenied
+	}
+
+	book, err := e.BookRepo.Get(requestContext(ctx), e.AddressBook.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	book.Permission = permission
+
+	return book, nil
+}
+
+// GetAllAddressBooksExecutor defines an APIExecutor for getting a page of the address books a user owns or has been shared.
+type GetAllAddressBooksExecutor struct {
+	OwnerQuery
+	PageQuery
+	clienthelper.BaseAPIExecutor
+	BookRepo repositories.AddressBookRepository
+}
+
+// NewGetAllAddressBooksExecutor returns a new instance of GetAllAddressBooksExecutor.
+func NewGetAllAddressBooksExecutor(repo repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetAllAddressBooksExecutor{
+		BookRepo: repo,
+	}
+}
+
+// ParseRequest parses the authenticated user and the page of the request into the GetAllAddressBooksExecutor object.
+func (e *GetAllAddressBooksExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.OwnerQuery.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.PageQuery.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the OwnerQuery and checks that the address books can be sorted as requested.
+func (e *GetAllAddressBooksExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.OwnerQuery.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.PageQuery.Page.Validate(repositories.AddressBookSortFields...)
+}
+
+// Controller executes the business logic for getting a page of the address books of a user and returns the page
+// and any errors that occur during execution.
+func (e *GetAllAddressBooksExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	books, err := e.BookRepo.GetAllForUser(requestContext(ctx), e.OwnerQuery.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, info := repositories.PageSlice(len(books), func(i int) repositories.SortKey { return books[i].SortKey(e.PageQuery.Page.Sort) }, e.PageQuery.Page)
+	page := make([]*repositories.AddressBook, 0, len(indexes))
+	for _, i := range indexes {
+		page = append(page, books[i])
+	}
+
+	return newListResponse(page, info), nil
+}
+
+// AddressBookShare defines a struct for sharing an address book with a user or a role.
+type AddressBookShare struct {
+	ID           int
+	UserID       int    `json:"user_id"`
+	TargetUserID int    `json:"target_user_id"`
+	RoleID       int    `json:"role_id"`
+	Permission   string `json:"permission"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the AddressBookShare object.
+func (s *AddressBookShare) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	s.ID = i
+
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the AddressBookShare object
+	if err := json.Unmarshal(body, s); err != nil {
+		return err
+	}
+
+	// The acting user is the authenticated user, whatever the body says
+	s.UserID, err = actingUser(r)
+	return err
+}
+
+// ValidateRequest validates the data in the AddressBookShare object and returns any errors that occur during validation.
+func (s *AddressBookShare) ValidateRequest(ctx context.IContext) error {
+	if s.UserID == 0 {
+		return ErrUnauthenticated
+	}
+
+	if (s.TargetUserID == 0) == (s.RoleID == 0) {
+		return errors.New("exactly one of target_user_id and role_id is required")
+	}
+
+	return nil
+}
+
+// ShareAddressBookExecutor defines an APIExecutor for granting a user or role a permission on an address book.
+// Only owners may share.
+type ShareAddressBookExecutor struct {
+	AddressBookShare
+	clienthelper.BaseAPIExecutor
+	BookRepo repositories.AddressBookRepository
+}
+
+// NewShareAddressBookExecutor returns a new instance of ShareAddressBookExecutor.
+func NewShareAddressBookExecutor(repo repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &ShareAddressBookExecutor{
+		BookRepo: repo,
+	}
+}
+
+// Controller executes the business logic for sharing an address book and returns the current shares
+// and any errors that occur during execution.
+func (e *ShareAddressBookExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	permission, err := repositories.ParsePermission(e.AddressBookShare.Permission)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireAddressBookPermission(ctx, e.BookRepo, e.AddressBookShare.ID, e.AddressBookShare.UserID, repositories.PermissionOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.BookRepo.Share(requestContext(ctx), &repositories.AddressBookShare{
+		AddressBookID: e.AddressBookShare.ID,
+		UserID:        e.AddressBookShare.TargetUserID,
+		RoleID:        e.AddressBookShare.RoleID,
+		Permission:    permission,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return e.BookRepo.GetShares(requestContext(ctx), e.AddressBookShare.ID)
+}
+
+// UnshareAddressBookExecutor defines an APIExecutor for revoking the share of a user or role on an address book.
+// Only owners may revoke shares.
+type UnshareAddressBookExecutor struct {
+	AddressBookShare
+	clienthelper.BaseAPIExecutor
+	BookRepo repositories.AddressBookRepository
+}
+
+// NewUnshareAddressBookExecutor returns a new instance of UnshareAddressBookExecutor.
+func NewUnshareAddressBookExecutor(repo repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &UnshareAddressBookExecutor{
+		BookRepo: repo,
+	}
+}
+
+// Controller executes the business logic for revoking an address book share and returns the remaining shares
+// and any errors that occur during execution.
+func (e *UnshareAddressBookExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	err := requireAddressBookPermission(ctx, e.BookRepo, e.AddressBookShare.ID, e.AddressBookShare.UserID, repositories.PermissionOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.BookRepo.Unshare(requestContext(ctx), &repositories.AddressBookShare{
+		AddressBookID: e.AddressBookShare.ID,
+		UserID:        e.AddressBookShare.TargetUserID,
+		RoleID:        e.AddressBookShare.RoleID,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return e.BookRepo.GetShares(requestContext(ctx), e.AddressBookShare.ID)
+}
+
+// GetAddressBookSharesExecutor defines an APIExecutor for listing the shares of an address book.
+type GetAddressBookSharesExecutor struct {
+	AddressBook
+	clienthelper.BaseAPIExecutor
+	BookRepo repositories.AddressBookRepository
+}
+
+// NewGetAddressBookSharesExecutor returns a new instance of GetAddressBookSharesExecutor.
+func NewGetAddressBookSharesExecutor(repo repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetAddressBookSharesExecutor{
+		BookRepo: repo,
+	}
+}
+
+// Controller executes the business logic for listing the shares of an address book and returns the shares
+// and any errors that occur during execution.
+func (e *GetAddressBookSharesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	err := requireAddressBookPermission(ctx, e.BookRepo, e.AddressBook.ID, e.AddressBook.UserID, repositories.PermissionOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.BookRepo.GetShares(requestContext(ctx), e.AddressBook.ID)
+}
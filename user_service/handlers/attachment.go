@@ -0,0 +1,257 @@
+package handlers
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/attachments"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// AttachmentUpload defines a struct for uploading a file on behalf of the authenticated user. The raw file is the
+// request body; contact_id, kind (avatar or document) and file_name are query parameters.
+type AttachmentUpload struct {
+	ContactID int
+	UserID    int
+	Kind      string
+	FileName  string
+	Data      []byte
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the AttachmentUpload object. The body
+// is read up to one byte past the size limit of the kind so that oversized files can be rejected.
+func (u *AttachmentUpload) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	contactID, err := strconv.Atoi(values.Get("contact_id"))
+	if err != nil {
+		return errors.New("invalid contact_id in query")
+	}
+	u.ContactID = contactID
+
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+	u.UserID = userID
+
+	u.Kind = values.Get("kind")
+	if u.Kind == "" {
+		u.Kind = repositories.AttachmentDocument
+	}
+	u.FileName = values.Get("file_name")
+
+	// Read the request body
+	u.Data, err = ioutil.ReadAll(io.LimitReader(r.Body, attachments.MaxSize(u.Kind)+1))
+	return err
+}
+
+// ValidateRequest validates the data in the AttachmentUpload object and returns any errors that occur during validation.
+func (u *AttachmentUpload) ValidateRequest(ctx context.IContext) error {
+	if u.Kind != repositories.AttachmentAvatar && u.Kind != repositories.AttachmentDocument {
+		return errors.New("kind must be either avatar or document")
+	}
+	return nil
+}
+
+// UploadAttachmentExecutor defines an APIExecutor for uploading an avatar or document for a contact. The
+// content type is sniffed from the file itself; avatars get a thumbnail and replace the previous avatar.
+type UploadAttachmentExecutor struct {
+	AttachmentUpload
+	clienthelper.BaseAPIExecutor
+	AttachmentService *attachments.Service
+	ContactRepo       repositories.ContactRepository
+	BookRepo          repositories.AddressBookRepository
+}
+
+// NewUploadAttachmentExecutor returns a new instance of UploadAttachmentExecutor.
+func NewUploadAttachmentExecutor(service *attachments.Service, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &UploadAttachmentExecutor{
+		AttachmentService: service,
+		ContactRepo:       contacts,
+		BookRepo:          books,
+	}
+}
+
+// Controller executes the business logic for uploading an attachment and returns the stored attachment
+// and any errors that occur during execution.
+func (e *UploadAttachmentExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	u := &e.AttachmentUpload
+	_, err := requireContactPermission(ctx, e.ContactRepo, e.BookRepo, u.ContactID, u.UserID, repositories.PermissionEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.AttachmentService.Upload(requestContext(ctx), u.ContactID, u.UserID, u.Kind, u.FileName, bytes.NewReader(u.Data))
+}
+
+// AttachmentRequest defines a struct for requests on a single attachment.
+type AttachmentRequest struct {
+	ID        int
+	UserID    int
+	Thumbnail bool
+
+	writer http.ResponseWriter
+}
+
+// ParseRequest parses the id and optional thumbnail query parameters and the authenticated user into the AttachmentRequest object.
+func (a *AttachmentRequest) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	id, err := strconv.Atoi(values.Get("id"))
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+	a.ID = id
+
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+	a.UserID = userID
+
+	a.Thumbnail = values.Get("thumbnail") == "true"
+	a.writer = w
+
+	return nil
+}
+
+// ValidateRequest validates the data in the AttachmentRequest object and returns any errors that occur during validation.
+func (a *AttachmentRequest) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// DownloadAttachmentExecutor defines an APIExecutor for downloading an attachment, or the thumbnail of an avatar.
+// The file is written to the response directly and always served as a download.
+type DownloadAttachmentExecutor struct {
+	AttachmentRequest
+	clienthelper.BaseAPIExecutor
+	AttachmentService *attachments.Service
+	ContactRepo       repositories.ContactRepository
+	BookRepo          repositories.AddressBookRepository
+}
+
+// NewDownloadAttachmentExecutor returns a new instance of DownloadAttachmentExecutor.
+func NewDownloadAttachmentExecutor(service *attachments.Service, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &DownloadAttachmentExecutor{
+		AttachmentService: service,
+		ContactRepo:       contacts,
+		BookRepo:          books,
+	}
+}
+
+// Controller executes the business logic for downloading an attachment, writing the file to the response, and
+// returns any errors that occur before the file is written.
+func (e *DownloadAttachmentExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	a, err := e.AttachmentService.Repo.Get(requestContext(ctx), e.AttachmentRequest.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = requireContactPermission(ctx, e.ContactRepo, e.BookRepo, a.ContactID, e.AttachmentRequest.UserID, repositories.PermissionViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := e.AttachmentService.Open(a, e.AttachmentRequest.Thumbnail)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	w := e.AttachmentRequest.writer
+	contentType, size := a.ContentType, strconv.FormatInt(a.Size, 10)
+	if e.AttachmentRequest.Thumbnail && a.ThumbnailKey != "" {
+		contentType, size = "image/jpeg", ""
+	}
+
+	w.Header().Set("Content-Type", contentType)
+	if size != "" {
+		w.Header().Set("Content-Length", size)
+	}
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": a.FileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(w, content)
+	return nil, err
+}
+
+// DeleteAttachmentExecutor defines an APIExecutor for deleting an attachment together with its file.
+type DeleteAttachmentExecutor struct {
+	AttachmentRequest
+	clienthelper.BaseAPIExecutor
+	AttachmentService *attachments.Service
+	ContactRepo       repositories.ContactRepository
+	BookRepo          repositories.AddressBookRepository
+}
+
+// NewDeleteAttachmentExecutor returns a new instance of DeleteAttachmentExecutor.
+func NewDeleteAttachmentExecutor(service *attachments.Service, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &DeleteAttachmentExecutor{
+		AttachmentService: service,
+		ContactRepo:       contacts,
+		BookRepo:          books,
+	}
+}
+
+// Controller executes the business logic for deleting an attachment and returns any errors that occur during execution.
+func (e *DeleteAttachmentExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	a, err := e.AttachmentService.Repo.Get(requestContext(ctx), e.AttachmentRequest.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = requireContactPermission(ctx, e.ContactRepo, e.BookRepo, a.ContactID, e.AttachmentRequest.UserID, repositories.PermissionEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.AttachmentService.Remove(requestContext(ctx), a)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// GetAttachmentsExecutor defines an APIExecutor for listing the attachments of a contact.
+type GetAttachmentsExecutor struct {
+	ContactItemsQuery
+	clienthelper.BaseAPIExecutor
+	AttachmentService *attachments.Service
+	ContactRepo       repositories.ContactRepository
+	BookRepo          repositories.AddressBookRepository
+}
+
+// NewGetAttachmentsExecutor returns a new instance of GetAttachmentsExecutor.
+func NewGetAttachmentsExecutor(service *attachments.Service, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetAttachmentsExecutor{
+		AttachmentService: service,
+		ContactRepo:       contacts,
+		BookRepo:          books,
+	}
+}
+
+// Controller executes the business logic for listing the attachments of a contact and returns the attachments
+// and any errors that occur during execution.
+func (e *GetAttachmentsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	q := &e.ContactItemsQuery
+	_, err := requireContactPermission(ctx, e.ContactRepo, e.BookRepo, q.ContactID, q.UserID, repositories.PermissionViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.AttachmentService.Repo.GetAll(requestContext(ctx), q.ContactID)
+}
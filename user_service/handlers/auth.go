@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	stdcontext "context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dgrijalva/jwt-go"
+)
+
+// ErrUnauthenticated is returned for requests that do not carry the user they act for.
+var ErrUnauthenticated = errors.New("authentication required")
+
+// userIDKey is the key of the authenticated user in a request context.
+type userIDKey struct{}
+
+// WithUserID returns a copy of ctx carrying the ID of the authenticated user.
+func WithUserID(ctx stdcontext.Context, userID int) stdcontext.Context {
+	return stdcontext.WithValue(ctx, userIDKey{}, userID)
+}
+
+// UserIDFromContext returns the ID of the authenticated user carried by ctx.
+func UserIDFromContext(ctx stdcontext.Context) (int, bool) {
+	userID, ok := ctx.Value(userIDKey{}).(int)
+	return userID, ok && userID > 0
+}
+
+// Authenticate returns a handler that checks the bearer token of every request, a JWT issued by
+// the LoginExecutor and signed with secretKey, and serves it with next with the user of the token
+// in the request context. Requests without a valid token are rejected with 401 Unauthorized.
+func Authenticate(secretKey string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		userID, err := parseToken(secretKey, r.Header.Get("Authorization"))
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="contacts"`)
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithUserID(r.Context(), userID)))
+	})
+}
+
+// parseToken returns the user of the bearer token in an Authorization header.
+func parseToken(secretKey, header string) (int, error) {
+	raw := strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	if raw == "" || raw == header {
+		return 0, ErrUnauthenticated
+	}
+
+	token, err := jwt.Parse(raw, func(t *jwt.Token) (interface{}, error) {
+		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
+			return nil, fmt.Errorf("unexpected signing method %v", t.Header["alg"])
+		}
+		return []byte(secretKey), nil
+	})
+	if err != nil || !token.Valid {
+		return 0, errors.New("invalid token")
+	}
+
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return 0, errors.New("invalid token")
+	}
+
+	// numbers of the claims are decoded as float64
+	userID, ok := claims["user_id"].(float64)
+	if !ok || userID < 1 {
+		return 0, errors.New("invalid token")
+	}
+
+	return int(userID), nil
+}
+
+// actingUser returns the authenticated user a request acts for. It is never taken from the query
+// or the body, which the client controls.
+func actingUser(r *http.Request) (int, error) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		return 0, ErrUnauthenticated
+	}
+
+	return userID, nil
+}
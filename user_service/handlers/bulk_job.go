@@ -0,0 +1,341 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/attachments"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// maxBulkJobItems caps the number of contacts of a single bulk job.
+const maxBulkJobItems = 100000
+
+// maxBulkJobFailures caps the number of failed items returned with a job's status.
+const maxBulkJobFailures = 100
+
+// BulkJob defines a struct for queueing a bulk operation: tag or untag (with tag), move (with
+// address_book_id, zero for the personal contacts), delete or export of the given contacts.
+type BulkJob struct {
+	UserID        int    `json:"user_id"`
+	Operation     string `json:"operation"`
+	Tag           string `json:"tag"`
+	AddressBookID int    `json:"address_book_id"`
+	ContactIDs    []int  `json:"contact_ids"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the BulkJob object.
+func (b *BulkJob) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the BulkJob object
+	if err := json.Unmarshal(body, b); err != nil {
+		return err
+	}
+
+	// The acting user is the authenticated user, whatever the body says
+	b.UserID, err = actingUser(r)
+	return err
+}
+
+// ValidateRequest validates the data in the BulkJob object and returns any errors that occur during validation.
+func (b *BulkJob) ValidateRequest(ctx context.IContext) error {
+	if b.UserID == 0 {
+		return ErrUnauthenticated
+	}
+
+	switch b.Operation {
+	case repositories.BulkTag, repositories.BulkUntag:
+		if strings.TrimSpace(b.Tag) == "" {
+			return errors.New("tag is required for tag and untag jobs")
+		}
+	case repositories.BulkMove, repositories.BulkDelete, repositories.BulkExport:
+	default:
+		return errors.New("operation must be one of tag, untag, move, delete or export")
+	}
+
+	if len(b.ContactIDs) == 0 {
+		return errors.New("contact_ids is required")
+	}
+
+	if len(b.ContactIDs) > maxBulkJobItems {
+		return errors.New("a bulk job can contain at most " + strconv.Itoa(maxBulkJobItems) + " contacts")
+	}
+
+	return nil
+}
+
+// CreateBulkJobExecutor defines an APIExecutor for queueing a bulk operation. The job is processed
+// by a jobs.BulkWorker; permissions are checked per contact while it runs and contacts the user
+// may not change are reported as failed items.
+type CreateBulkJobExecutor struct {
+	BulkJob
+	clienthelper.BaseAPIExecutor
+	BulkJobRepo repositories.BulkJobRepository
+	BookRepo    repositories.AddressBookRepository
+}
+
+// NewCreateBulkJobExecutor returns a new instance of CreateBulkJobExecutor.
+func NewCreateBulkJobExecutor(repo repositories.BulkJobRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &CreateBulkJobExecutor{
+		BulkJobRepo: repo,
+		BookRepo:    books,
+	}
+}
+
+// Controller executes the business logic for queueing a bulk operation and returns the queued job
+// and any errors that occur during execution.
+func (e *CreateBulkJobExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	b := &e.BulkJob
+	if b.Operation == repositories.BulkMove {
+		err := requireScopePermission(ctx, e.BookRepo, b.AddressBookID, b.UserID, b.UserID, repositories.PermissionEditor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// duplicates would be processed twice and skew the progress
+	seen := map[int]bool{}
+	ids := []int{}
+	for _, id := range b.ContactIDs {
+		if !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	job := &repositories.BulkJob{
+		UserID:        b.UserID,
+		Operation:     b.Operation,
+		Tag:           strings.ToLower(strings.TrimSpace(b.Tag)),
+		AddressBookID: b.AddressBookID,
+		ContactIDs:    ids,
+	}
+
+	err := e.BulkJobRepo.Create(requestContext(ctx), job)
+	if err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// BulkJobRequest defines a struct for requests on a single bulk job of a user.
+type BulkJobRequest struct {
+	ID     int
+	UserID int
+
+	writer http.ResponseWriter
+}
+
+// ParseRequest parses the id query parameter and the authenticated user into the BulkJobRequest object.
+func (b *BulkJobRequest) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	id, err := strconv.Atoi(values.Get("id"))
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+	b.ID = id
+
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+	b.UserID = userID
+
+	b.writer = w
+
+	return nil
+}
+
+// ValidateRequest validates the data in the BulkJobRequest object and returns any errors that occur during validation.
+func (b *BulkJobRequest) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// getOwnBulkJob returns a bulk job, or ErrPermissionDenied unless it belongs to userID.
+func getOwnBulkJob(ctx context.IContext, repo repositories.BulkJobRepository, id, userID int) (*repositories.BulkJob, error) {
+	job, err := repo.Get(requestContext(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.UserID != userID {
+		return nil, repositories.ErrPermissionDenied
+	}
+
+	return job, nil
+}
+
+// GetBulkJobExecutor defines an APIExecutor for the status of a bulk job: its state, progress and
+// the first failed items.
+type GetBulkJobExecutor struct {
+	BulkJobRequest
+	clienthelper.BaseAPIExecutor
+	BulkJobRepo repositories.BulkJobRepository
+}
+
+// NewGetBulkJobExecutor returns a new instance of GetBulkJobExecutor.
+func NewGetBulkJobExecutor(repo repositories.BulkJobRepository) clienthelper.APIExecutor {
+	return &GetBulkJobExecutor{
+		BulkJobRepo: repo,
+	}
+}
+
+// Controller executes the business logic for getting the status of a bulk job and returns the job
+// and any errors that occur during execution.
+func (e *GetBulkJobExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	job, err := getOwnBulkJob(ctx, e.BulkJobRepo, e.BulkJobRequest.ID, e.BulkJobRequest.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Failed > 0 {
+		job.Failures, err = e.BulkJobRepo.GetFailures(requestContext(ctx), job.ID, maxBulkJobFailures)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return job, nil
+}
+
+// GetAllBulkJobsExecutor defines an APIExecutor for listing a page of the bulk jobs of a user.
+type GetAllBulkJobsExecutor struct {
+	OwnerQuery
+	PageQuery
+	clienthelper.BaseAPIExecutor
+	BulkJobRepo repositories.BulkJobRepository
+}
+
+// NewGetAllBulkJobsExecutor returns a new instance of GetAllBulkJobsExecutor.
+func NewGetAllBulkJobsExecutor(repo repositories.BulkJobRepository) clienthelper.APIExecutor {
+	return &GetAllBulkJobsExecutor{
+		BulkJobRepo: repo,
+	}
+}
+
+// ParseRequest parses the authenticated user and the page of the request into the GetAllBulkJobsExecutor object.
+func (e *GetAllBulkJobsExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.OwnerQuery.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.PageQuery.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the OwnerQuery and checks that the bulk jobs can be sorted as requested.
+func (e *GetAllBulkJobsExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.OwnerQuery.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.PageQuery.Page.Validate(repositories.BulkJobSortFields...)
+}
+
+// Controller executes the business logic for listing a page of the bulk jobs of a user and returns the page
+// and any errors that occur during execution.
+func (e *GetAllBulkJobsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	jobs, err := e.BulkJobRepo.GetAll(requestContext(ctx), e.OwnerQuery.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, info := repositories.PageSlice(len(jobs), func(i int) repositories.SortKey { return jobs[i].SortKey(e.PageQuery.Page.Sort) }, e.PageQuery.Page)
+	page := make([]*repositories.BulkJob, 0, len(indexes))
+	for _, i := range indexes {
+		page = append(page, jobs[i])
+	}
+
+	return newListResponse(page, info), nil
+}
+
+// CancelBulkJobExecutor defines an APIExecutor for cancelling a bulk job. A queued job is cancelled
+// right away; a running job stops after the batch in flight, keeping the work done so far.
+type CancelBulkJobExecutor struct {
+	BulkJobRequest
+	clienthelper.BaseAPIExecutor
+	BulkJobRepo repositories.BulkJobRepository
+}
+
+// NewCancelBulkJobExecutor returns a new instance of CancelBulkJobExecutor.
+func NewCancelBulkJobExecutor(repo repositories.BulkJobRepository) clienthelper.APIExecutor {
+	return &CancelBulkJobExecutor{
+		BulkJobRepo: repo,
+	}
+}
+
+// Controller executes the business logic for cancelling a bulk job and returns the job and any
+// errors that occur during execution.
+func (e *CancelBulkJobExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	job, err := getOwnBulkJob(ctx, e.BulkJobRepo, e.BulkJobRequest.ID, e.BulkJobRequest.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.BulkJobRepo.Cancel(requestContext(ctx), job.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.BulkJobRepo.Get(requestContext(ctx), job.ID)
+}
+
+// DownloadBulkExportExecutor defines an APIExecutor for downloading the CSV file of a completed
+// export job. The file is written to the response directly.
+type DownloadBulkExportExecutor struct {
+	BulkJobRequest
+	clienthelper.BaseAPIExecutor
+	BulkJobRepo repositories.BulkJobRepository
+	Store       attachments.BlobStore
+}
+
+// NewDownloadBulkExportExecutor returns a new instance of DownloadBulkExportExecutor.
+func NewDownloadBulkExportExecutor(repo repositories.BulkJobRepository, store attachments.BlobStore) clienthelper.APIExecutor {
+	return &DownloadBulkExportExecutor{
+		BulkJobRepo: repo,
+		Store:       store,
+	}
+}
+
+// Controller executes the business logic for downloading an export, writing the file to the response,
+// and returns any errors that occur before the file is written.
+func (e *DownloadBulkExportExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	job, err := getOwnBulkJob(ctx, e.BulkJobRepo, e.BulkJobRequest.ID, e.BulkJobRequest.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if job.Operation != repositories.BulkExport || job.Status != repositories.BulkJobCompleted {
+		return nil, errors.New("only completed export jobs can be downloaded")
+	}
+
+	content, err := e.Store.Get(job.Result)
+	if err != nil {
+		return nil, err
+	}
+	defer content.Close()
+
+	fileName := "contacts-export-" + strconv.Itoa(job.ID) + ".csv"
+	w := e.BulkJobRequest.writer
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": fileName}))
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = io.Copy(w, content)
+	return nil, err
+}
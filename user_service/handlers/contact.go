@@ -0,0 +1,582 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/addresses"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+	"github.com/princeparmar/go-helpers/utils"
+)
+
+// Contact defines a struct for contact data. birthday and anniversary are dates formatted as
+// 2006-01-02, or as --01-02 when the year is unknown; each gets a yearly reminder for the owner.
+type Contact struct {
+	ID            int               `json:"id"`
+	UserID        int               `json:"user_id"`
+	AddressBookID int               `json:"address_book_id"`
+	FirstName     string            `json:"first_name"`
+	LastName      string            `json:"last_name"`
+	Email         string            `json:"email"`
+	Mobile        string            `json:"mobile"`
+	Organization  string            `json:"organization"`
+	Notes         string            `json:"notes"`
+	Birthday      string            `json:"birthday"`
+	Anniversary   string            `json:"anniversary"`
+	CustomFields  map[string]string `json:"custom_fields"`
+}
+
+// createContactModel maps Contact to Contact model.
+func createContactModel(c *Contact) *repositories.Contact {
+	return &repositories.Contact{
+		ID:            c.ID,
+		UserID:        c.UserID,
+		AddressBookID: c.AddressBookID,
+		FirstName:     c.FirstName,
+		LastName:      c.LastName,
+		EmailID:       c.Email,
+		Mobile:        c.Mobile,
+		Organization:  c.Organization,
+		Notes:         c.Notes,
+		Birthday:      c.Birthday,
+		Anniversary:   c.Anniversary,
+		CustomFields:  c.CustomFields,
+	}
+}
+
+// contactDocument maps a Contact model to the Contact representation that patches apply to. Its
+// user_id is the owner of the contact.
+func contactDocument(c *repositories.Contact) *Contact {
+	return &Contact{
+		ID:            c.ID,
+		UserID:        c.UserID,
+		AddressBookID: c.AddressBookID,
+		FirstName:     c.FirstName,
+		LastName:      c.LastName,
+		Email:         c.EmailID,
+		Mobile:        c.Mobile,
+		Organization:  c.Organization,
+		Notes:         c.Notes,
+		Birthday:      c.Birthday,
+		Anniversary:   c.Anniversary,
+		CustomFields:  c.CustomFields,
+	}
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Contact object.
+func (c *Contact) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the Contact object
+		err = json.Unmarshal(body, c)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	c.ID = i
+
+	// The acting user is the authenticated user, whatever the body says
+	c.UserID, err = actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Contact object and returns any errors that occur during validation.
+func (c *Contact) ValidateRequest(ctx context.IContext) error {
+	if c.UserID == 0 {
+		return ErrUnauthenticated
+	}
+
+	if c.FirstName == "" {
+		return errors.New("first_name is required")
+	}
+
+	// Validate email format
+	if c.Email != "" && !utils.ValidateEmail(c.Email) {
+		return errors.New("email format is invalid")
+	}
+
+	// Validate mobile format
+	if c.Mobile != "" && !utils.ValidateMobile(c.Mobile) {
+		return errors.New("mobile format is invalid")
+	}
+
+	// Validate the dates
+	if _, err := repositories.ParseContactDate(c.Birthday); c.Birthday != "" && err != nil {
+		return errors.New("birthday: " + err.Error())
+	}
+	if _, err := repositories.ParseContactDate(c.Anniversary); c.Anniversary != "" && err != nil {
+		return errors.New("anniversary: " + err.Error())
+	}
+
+	return nil
+}
+
+// CreateContactExecutor defines an APIExecutor for creating a new contact.
+type CreateContactExecutor struct {
+	Contact
+	clienthelper.BaseAPIExecutor
+	ContactRepo repositories.ContactStore
+}
+
+// NewCreateContactExecutor returns a new instance of CreateContactExecutor.
+func NewCreateContactExecutor(repo repositories.ContactStore) clienthelper.APIExecutor {
+	return &CreateContactExecutor{
+		ContactRepo: repo,
+	}
+}
+
+// Controller executes the business logic for creating a new contact and returns the created contact
+// and any errors that occur during execution.
+func (e *CreateContactExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	contact := createContactModel(&e.Contact)
+	repo := e.ContactRepo.As(e.Contact.UserID)
+	err := repo.Create(requestContext(ctx), contact)
+	if err != nil {
+		return nil, err
+	}
+
+	return contact, nil
+}
+
+// UpdateContactExecutor defines an APIExecutor for updating a contact by ID.
+type UpdateContactExecutor struct {
+	Contact
+	clienthelper.BaseAPIExecutor
+	ContactRepo repositories.ContactStore
+}
+
+// NewUpdateContactExecutor returns a new instance of UpdateContactExecutor.
+func NewUpdateContactExecutor(repo repositories.ContactStore) clienthelper.APIExecutor {
+	return &UpdateContactExecutor{
+		ContactRepo: repo,
+	}
+}
+
+// Controller executes the business logic for updating a contact by ID and returns the updated contact
+// and any errors that occur during execution.
+func (e *UpdateContactExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	contact := createContactModel(&e.Contact)
+	repo := e.ContactRepo.As(e.Contact.UserID)
+	err := repo.Update(requestContext(ctx), contact)
+	if err != nil {
+		return nil, err
+	}
+
+	return contact, nil
+}
+
+// PatchContactExecutor defines an APIExecutor for partially updating a contact by ID with a JSON merge patch or
+// a JSON Patch. Only the fields the patch changes are written, and recorded in the contact history.
+type PatchContactExecutor struct {
+	Contact
+	PatchRequest
+	clienthelper.BaseAPIExecutor
+	ContactRepo repositories.ContactStore
+}
+
+// NewPatchContactExecutor returns a new instance of PatchContactExecutor.
+func NewPatchContactExecutor(repo repositories.ContactStore) clienthelper.APIExecutor {
+	return &PatchContactExecutor{
+		ContactRepo: repo,
+	}
+}
+
+// ParseRequest parses the id query parameter, the authenticated user and the patch into the PatchContactExecutor object.
+func (e *PatchContactExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.Contact.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.PatchRequest.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest checks the acting user and the patch. The patched contact is validated once the patch is applied.
+func (e *PatchContactExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Contact.UserID == 0 {
+		return ErrUnauthenticated
+	}
+
+	return e.PatchRequest.ValidateRequest(ctx)
+}
+
+// Controller executes the business logic for patching a contact by ID and returns the patched contact
+// and any errors that occur during execution.
+func (e *PatchContactExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	repo := e.ContactRepo.As(e.Contact.UserID)
+	current, err := repo.Get(requestContext(ctx), e.Contact.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	patched := &Contact{}
+	if err := e.PatchRequest.apply(contactDocument(current), patched); err != nil {
+		return nil, err
+	}
+
+	if patched.ID != current.ID || patched.UserID != current.UserID {
+		return nil, errors.New("id and user_id cannot be patched")
+	}
+
+	// the patched contact is written on behalf of the acting user, like an update
+	patched.UserID = e.Contact.UserID
+	if err := patched.ValidateRequest(ctx); err != nil {
+		return nil, err
+	}
+
+	contact := createContactModel(patched)
+	fields := repositories.ChangedContactFields(current, contact)
+	if len(fields) == 0 {
+		return current, nil
+	}
+
+	if err := repo.Patch(requestContext(ctx), contact, fields); err != nil {
+		return nil, err
+	}
+
+	return contact, nil
+}
+
+// DeleteContactExecutor defines an APIExecutor for moving a contact to the trash by ID.
+type DeleteContactExecutor struct {
+	Contact
+	clienthelper.BaseAPIExecutor
+	ContactRepo repositories.ContactStore
+}
+
+// NewDeleteContactExecutor returns a new instance of DeleteContactExecutor.
+func NewDeleteContactExecutor(repo repositories.ContactStore) clienthelper.APIExecutor {
+	return &DeleteContactExecutor{
+		ContactRepo: repo,
+	}
+}
+
+// ValidateRequest checks the id of the contact to delete. Deletes have no body, so the contact itself is not validated.
+func (e *DeleteContactExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Contact.ID < 1 {
+		return errors.New("invalid id in query")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for deleting a contact by ID and returns any errors that occur during execution.
+func (e *DeleteContactExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	repo := e.ContactRepo.As(e.Contact.UserID)
+	err := repo.Delete(requestContext(ctx), e.Contact.ID, e.Contact.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// GetContactExecutor defines an APIExecutor for getting a contact by ID. The view is recorded in
+// the acting user's recently viewed contacts.
+type GetContactExecutor struct {
+	Contact
+	clienthelper.BaseAPIExecutor
+	ContactRepo     repositories.ContactRepository
+	BookRepo        repositories.AddressBookRepository
+	QuickAccessRepo repositories.QuickAccessRepository
+}
+
+// NewGetContactExecutor returns a new instance of GetContactExecutor.
+func NewGetContactExecutor(repo repositories.ContactRepository, books repositories.AddressBookRepository,
+	quickAccess repositories.QuickAccessRepository) clienthelper.APIExecutor {
+	return &GetContactExecutor{
+		ContactRepo:     repo,
+		BookRepo:        books,
+		QuickAccessRepo: quickAccess,
+	}
+}
+
+// ValidateRequest checks the id of the contact to get. Gets have no body, so the contact itself is not validated.
+func (e *GetContactExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Contact.ID < 1 {
+		return errors.New("invalid id in query")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for getting a contact by ID and returns the contact
+// and any errors that occur during execution.
+func (e *GetContactExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	repo := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, e.Contact.UserID)
+	c, err := repo.Get(requestContext(ctx), e.Contact.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.QuickAccessRepo.RecordView(requestContext(ctx), e.Contact.UserID, c.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// ContactQuery defines a struct for the filters accepted when listing contacts.
+type ContactQuery struct {
+	UserID        int
+	AddressBookID int
+	GroupIDs      []int
+	Tags          []string
+	MatchAnyTag   bool
+	CountryCode   string
+	Locality      string
+	Within        *repositories.GeoBounds
+}
+
+// filter returns the contact filter matching the query.
+func (q *ContactQuery) filter() *repositories.ContactFilter {
+	return &repositories.ContactFilter{
+		AddressBookID: q.AddressBookID,
+		GroupIDs:      q.GroupIDs,
+		Tags:          q.Tags,
+		MatchAnyTag:   q.MatchAnyTag,
+		CountryCode:   q.CountryCode,
+		Locality:      q.Locality,
+		Within:        q.Within,
+	}
+}
+
+// ParseRequest parses the authenticated user and the query parameters of the HTTP request into the ContactQuery object.
+// group_id and tag may be repeated or given as comma separated lists; tag_mode=any switches
+// tag matching from all of the tags to any of them. country, city and bbox (min_lat,min_lng,max_lat,max_lng) match
+// contacts by their addresses.
+func (q *ContactQuery) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+	q.UserID = userID
+
+	if addressBookID := values.Get("address_book_id"); addressBookID != "" {
+		q.AddressBookID, err = strconv.Atoi(addressBookID)
+		if err != nil {
+			return errors.New("invalid address_book_id in query")
+		}
+	}
+
+	for _, id := range splitQueryValues(values["group_id"]) {
+		i, err := strconv.Atoi(id)
+		if err != nil {
+			return errors.New("invalid group_id in query")
+		}
+		q.GroupIDs = append(q.GroupIDs, i)
+	}
+
+	q.Tags = splitQueryValues(values["tag"])
+
+	switch values.Get("tag_mode") {
+	case "", "all":
+		q.MatchAnyTag = false
+	case "any":
+		q.MatchAnyTag = true
+	default:
+		return errors.New("tag_mode must be either all or any")
+	}
+
+	if country := values.Get("country"); country != "" {
+		q.CountryCode, err = addresses.NormalizeCountry(country)
+		if err != nil {
+			return err
+		}
+	}
+
+	q.Locality = strings.TrimSpace(values.Get("city"))
+
+	if bbox := values.Get("bbox"); bbox != "" {
+		q.Within, err = parseGeoBounds(bbox)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// parseGeoBounds parses a bounding box given as min_lat,min_lng,max_lat,max_lng.
+func parseGeoBounds(bbox string) (*repositories.GeoBounds, error) {
+	parts := strings.Split(bbox, ",")
+	if len(parts) != 4 {
+		return nil, errors.New("bbox must be min_lat,min_lng,max_lat,max_lng")
+	}
+
+	values := make([]float64, 4)
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return nil, errors.New("bbox must be min_lat,min_lng,max_lat,max_lng")
+		}
+		values[i] = v
+	}
+
+	b := &repositories.GeoBounds{MinLatitude: values[0], MinLongitude: values[1], MaxLatitude: values[2], MaxLongitude: values[3]}
+	if b.MinLatitude < -90 || b.MaxLatitude > 90 || b.MinLatitude > b.MaxLatitude {
+		return nil, errors.New("bbox latitudes must be between -90 and 90, the minimum first")
+	}
+
+	if b.MinLongitude < -180 || b.MaxLongitude > 180 {
+		return nil, errors.New("bbox longitudes must be between -180 and 180")
+	}
+
+	return b, nil
+}
+
+// ValidateRequest validates the data in the ContactQuery object and returns any errors that occur during validation.
+func (q *ContactQuery) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// splitQueryValues flattens repeated and comma separated query values, dropping empty entries.
+func splitQueryValues(values []string) []string {
+	result := []string{}
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+
+	return result
+}
+
+// GetAllContactsExecutor defines an APIExecutor for getting a page of the contacts readable by a user matching the
+// address book, group, tag and address filters.
+type GetAllContactsExecutor struct {
+	ContactQuery
+	PageQuery
+	clienthelper.BaseAPIExecutor
+	ContactRepo repositories.ContactRepository
+	BookRepo    repositories.AddressBookRepository
+}
+
+// NewGetAllContactsExecutor returns a new instance of GetAllContactsExecutor.
+func NewGetAllContactsExecutor(repo repositories.ContactRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetAllContactsExecutor{
+		ContactRepo: repo,
+		BookRepo:    books,
+	}
+}
+
+// ParseRequest parses the filters and the page of the request into the GetAllContactsExecutor object.
+func (e *GetAllContactsExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.ContactQuery.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.PageQuery.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the ContactQuery and checks that the contacts can be sorted as requested.
+func (e *GetAllContactsExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.ContactQuery.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.PageQuery.Page.Validate(repositories.ContactSortFields...)
+}
+
+// Controller executes the business logic for getting a page of the filtered contacts and returns the page
+// and any errors that occur during execution.
+func (e *GetAllContactsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	repo := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, e.ContactQuery.UserID)
+	contacts, err := repo.GetAll(requestContext(ctx), e.ContactQuery.filter())
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, info := repositories.PageSlice(len(contacts), func(i int) repositories.SortKey { return contacts[i].SortKey(e.PageQuery.Page.Sort) }, e.PageQuery.Page)
+	page := make([]*repositories.Contact, 0, len(indexes))
+	for _, i := range indexes {
+		page = append(page, contacts[i])
+	}
+
+	return newListResponse(page, info), nil
+}
+
+// OwnerQuery defines a struct for requests that are scoped to the authenticated user.
+type OwnerQuery struct {
+	UserID int
+}
+
+// ParseRequest parses the authenticated user into the OwnerQuery object.
+func (q *OwnerQuery) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	q.UserID = userID
+
+	return nil
+}
+
+// ValidateRequest validates the data in the OwnerQuery object and returns any errors that occur during validation.
+func (q *OwnerQuery) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// ContactIDs defines a struct for bulk operations on a set of contacts.
+type ContactIDs struct {
+	ID         int
+	ContactIDs []int `json:"contact_ids"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the ContactIDs object.
+func (c *ContactIDs) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	c.ID = i
+
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the ContactIDs object
+	return json.Unmarshal(body, c)
+}
+
+// ValidateRequest validates the data in the ContactIDs object and returns any errors that occur during validation.
+func (c *ContactIDs) ValidateRequest(ctx context.IContext) error {
+	if len(c.ContactIDs) == 0 {
+		return errors.New("contact_ids field is required")
+	}
+
+	return nil
+}
@@ -0,0 +1,120 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// GetContactHistoryExecutor defines an APIExecutor for getting the version timeline of a contact.
+type GetContactHistoryExecutor struct {
+	Contact
+	clienthelper.BaseAPIExecutor
+	ContactRepo repositories.ContactRepository
+	BookRepo    repositories.AddressBookRepository
+	HistoryRepo repositories.ContactHistoryRepository
+}
+
+// NewGetContactHistoryExecutor returns a new instance of GetContactHistoryExecutor.
+func NewGetContactHistoryExecutor(repo repositories.ContactRepository, books repositories.AddressBookRepository, history repositories.ContactHistoryRepository) clienthelper.APIExecutor {
+	return &GetContactHistoryExecutor{
+		ContactRepo: repo,
+		BookRepo:    books,
+		HistoryRepo: history,
+	}
+}
+
+// ValidateRequest validates the data in the GetContactHistoryExecutor object. Only the contact id and
+// the acting user are needed to read the history.
+func (e *GetContactHistoryExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Contact.UserID == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// Controller executes the business logic for getting the history of a contact and returns the versions,
+// newest first, and any errors that occur during execution.
+func (e *GetContactHistoryExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	repo := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, e.Contact.UserID)
+	if _, err := repo.Get(requestContext(ctx), e.Contact.ID); err != nil {
+		return nil, err
+	}
+
+	return e.HistoryRepo.GetAll(requestContext(ctx), e.Contact.ID)
+}
+
+// ContactRestore defines a struct for restoring a contact to an earlier version.
+type ContactRestore struct {
+	ID      int
+	UserID  int `json:"user_id"`
+	Version int `json:"version"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the ContactRestore object.
+func (c *ContactRestore) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	c.ID = i
+
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the ContactRestore object
+	if err := json.Unmarshal(body, c); err != nil {
+		return err
+	}
+
+	// The acting user is the authenticated user, whatever the body says
+	c.UserID, err = actingUser(r)
+	return err
+}
+
+// ValidateRequest validates the data in the ContactRestore object and returns any errors that occur during validation.
+func (c *ContactRestore) ValidateRequest(ctx context.IContext) error {
+	if c.UserID == 0 {
+		return ErrUnauthenticated
+	}
+
+	if c.Version <= 0 {
+		return errors.New("version field is required")
+	}
+
+	return nil
+}
+
+// RestoreContactExecutor defines an APIExecutor for restoring a contact to an earlier version.
+// The restore is itself recorded as a new version.
+type RestoreContactExecutor struct {
+	ContactRestore
+	clienthelper.BaseAPIExecutor
+	ContactRepo repositories.ContactStore
+}
+
+// NewRestoreContactExecutor returns a new instance of RestoreContactExecutor.
+func NewRestoreContactExecutor(repo repositories.ContactStore) clienthelper.APIExecutor {
+	return &RestoreContactExecutor{
+		ContactRepo: repo,
+	}
+}
+
+// Controller executes the business logic for restoring a contact and returns the version recording the restore
+// and any errors that occur during execution.
+func (e *RestoreContactExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	repo := e.ContactRepo.As(e.ContactRestore.UserID)
+	return repo.RestoreVersion(requestContext(ctx), e.ContactRestore.ID, e.ContactRestore.Version)
+}
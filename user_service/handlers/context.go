@@ -0,0 +1,19 @@
+package handlers
+
+import (
+	stdcontext "context"
+
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// requestContext returns the context the repositories run the queries of a request with, so that
+// they are cancelled with the request and carry its deadline and values. Requests whose context
+// is not a context.Context run their queries with the background context, cut off only by the
+// Timeout of the database.
+func requestContext(ctx context.IContext) stdcontext.Context {
+	if c, ok := ctx.(stdcontext.Context); ok {
+		return c
+	}
+
+	return stdcontext.Background()
+}
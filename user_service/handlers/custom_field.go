@@ -0,0 +1,272 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// CustomField defines a struct for custom field definition data. Fields with an address_book_id are
+// shared by everyone working in that address book, fields without one apply to the acting user's
+// personal contacts.
+type CustomField struct {
+	ID            int      `json:"id"`
+	UserID        int      `json:"user_id"`
+	AddressBookID int      `json:"address_book_id"`
+	Key           string   `json:"key"`
+	Label         string   `json:"label"`
+	Type          string   `json:"type"`
+	Required      bool     `json:"required"`
+	Options       []string `json:"options"`
+	Pattern       string   `json:"pattern"`
+}
+
+// createCustomFieldModel maps CustomField to CustomField model.
+func createCustomFieldModel(f *CustomField) *repositories.CustomField {
+	return &repositories.CustomField{
+		ID:            f.ID,
+		UserID:        f.UserID,
+		AddressBookID: f.AddressBookID,
+		Key:           f.Key,
+		Label:         f.Label,
+		Type:          f.Type,
+		Required:      f.Required,
+		Options:       f.Options,
+		Pattern:       f.Pattern,
+	}
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the CustomField object.
+func (f *CustomField) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the CustomField object
+		err = json.Unmarshal(body, f)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	f.ID = i
+
+	// The acting user is the authenticated user, whatever the body says
+	f.UserID, err = actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the CustomField object and returns any errors that occur during validation.
+func (f *CustomField) ValidateRequest(ctx context.IContext) error {
+	if f.UserID == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// CreateCustomFieldExecutor defines an APIExecutor for defining a new custom field. Fields of an address
+// book can only be defined by its owners.
+type CreateCustomFieldExecutor struct {
+	CustomField
+	clienthelper.BaseAPIExecutor
+	FieldRepo repositories.CustomFieldRepository
+	BookRepo  repositories.AddressBookRepository
+}
+
+// NewCreateCustomFieldExecutor returns a new instance of CreateCustomFieldExecutor.
+func NewCreateCustomFieldExecutor(repo repositories.CustomFieldRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &CreateCustomFieldExecutor{
+		FieldRepo: repo,
+		BookRepo:  books,
+	}
+}
+
+// Controller executes the business logic for creating a custom field and returns the created field
+// and any errors that occur during execution.
+func (e *CreateCustomFieldExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	field := createCustomFieldModel(&e.CustomField)
+	if field.Label == "" {
+		field.Label = field.Key
+	}
+
+	if err := field.Validate(); err != nil {
+		return nil, err
+	}
+
+	err := requireScopePermission(ctx, e.BookRepo, field.AddressBookID, field.UserID, e.CustomField.UserID, repositories.PermissionOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.FieldRepo.Create(requestContext(ctx), field)
+	if err != nil {
+		return nil, err
+	}
+
+	return field, nil
+}
+
+// UpdateCustomFieldExecutor defines an APIExecutor for changing the label, required flag, options and
+// pattern of a custom field. The key, type and scope of a field are fixed.
+type UpdateCustomFieldExecutor struct {
+	CustomField
+	clienthelper.BaseAPIExecutor
+	FieldRepo repositories.CustomFieldRepository
+	BookRepo  repositories.AddressBookRepository
+}
+
+// NewUpdateCustomFieldExecutor returns a new instance of UpdateCustomFieldExecutor.
+func NewUpdateCustomFieldExecutor(repo repositories.CustomFieldRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &UpdateCustomFieldExecutor{
+		FieldRepo: repo,
+		BookRepo:  books,
+	}
+}
+
+// Controller executes the business logic for updating a custom field and returns the updated field
+// and any errors that occur during execution.
+func (e *UpdateCustomFieldExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	field, err := e.FieldRepo.Get(requestContext(ctx), e.CustomField.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireScopePermission(ctx, e.BookRepo, field.AddressBookID, field.UserID, e.CustomField.UserID, repositories.PermissionOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.CustomField.Label != "" {
+		field.Label = e.CustomField.Label
+	}
+	field.Required = e.CustomField.Required
+	field.Options = e.CustomField.Options
+	field.Pattern = e.CustomField.Pattern
+
+	if err := field.Validate(); err != nil {
+		return nil, err
+	}
+
+	err = e.FieldRepo.Update(requestContext(ctx), field)
+	if err != nil {
+		return nil, err
+	}
+
+	return field, nil
+}
+
+// DeleteCustomFieldExecutor defines an APIExecutor for deleting a custom field together with its values.
+type DeleteCustomFieldExecutor struct {
+	CustomField
+	clienthelper.BaseAPIExecutor
+	FieldRepo repositories.CustomFieldRepository
+	BookRepo  repositories.AddressBookRepository
+}
+
+// NewDeleteCustomFieldExecutor returns a new instance of DeleteCustomFieldExecutor.
+func NewDeleteCustomFieldExecutor(repo repositories.CustomFieldRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &DeleteCustomFieldExecutor{
+		FieldRepo: repo,
+		BookRepo:  books,
+	}
+}
+
+// Controller executes the business logic for deleting a custom field and returns any errors that occur during execution.
+func (e *DeleteCustomFieldExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	field, err := e.FieldRepo.Get(requestContext(ctx), e.CustomField.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireScopePermission(ctx, e.BookRepo, field.AddressBookID, field.UserID, e.CustomField.UserID, repositories.PermissionOwner)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.FieldRepo.Delete(requestContext(ctx), field.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// GetAllCustomFieldsExecutor defines an APIExecutor for listing a page of the custom fields of an address book, or
+// the acting user's personal custom fields.
+type GetAllCustomFieldsExecutor struct {
+	ScopeQuery
+	PageQuery
+	clienthelper.BaseAPIExecutor
+	FieldRepo repositories.CustomFieldRepository
+	BookRepo  repositories.AddressBookRepository
+}
+
+// NewGetAllCustomFieldsExecutor returns a new instance of GetAllCustomFieldsExecutor.
+func NewGetAllCustomFieldsExecutor(repo repositories.CustomFieldRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetAllCustomFieldsExecutor{
+		FieldRepo: repo,
+		BookRepo:  books,
+	}
+}
+
+// ParseRequest parses the scope and the page of the request into the GetAllCustomFieldsExecutor object.
+func (e *GetAllCustomFieldsExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.ScopeQuery.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.PageQuery.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the ScopeQuery and checks that the custom fields can be sorted as requested.
+func (e *GetAllCustomFieldsExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.ScopeQuery.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.PageQuery.Page.Validate(repositories.CustomFieldSortFields...)
+}
+
+// Controller executes the business logic for listing a page of the custom fields and returns the page
+// and any errors that occur during execution.
+func (e *GetAllCustomFieldsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if e.ScopeQuery.AddressBookID != 0 {
+		err := requireAddressBookPermission(ctx, e.BookRepo, e.ScopeQuery.AddressBookID, e.ScopeQuery.UserID, repositories.PermissionViewer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	fields, err := e.FieldRepo.GetAll(requestContext(ctx), e.ScopeQuery.UserID, e.ScopeQuery.AddressBookID)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, info := repositories.PageSlice(len(fields), func(i int) repositories.SortKey { return fields[i].SortKey(e.PageQuery.Page.Sort) }, e.PageQuery.Page)
+	page := make([]*repositories.CustomField, 0, len(indexes))
+	for _, i := range indexes {
+		page = append(page, fields[i])
+	}
+
+	return newListResponse(page, info), nil
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// Precondition defines a struct for the versions of users, roles and accesses exchanged in HTTP
+// headers. Responses carrying one of them set the ETag header to its version, and updates and
+// deletes send that ETag back in the If-Match header, so that they fail instead of overwriting a
+// change made since the record was read.
+type Precondition struct {
+	// IfMatch is the version named by the If-Match header, or zero when the header is missing.
+	IfMatch int
+
+	writer http.ResponseWriter
+}
+
+// ParseRequest parses the If-Match header into the Precondition object. Its value is a single
+// strong ETag, such as "3".
+func (p *Precondition) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	p.writer = w
+
+	match := strings.TrimSpace(r.Header.Get("If-Match"))
+	if match == "" {
+		return nil
+	}
+
+	invalid := errors.New("invalid If-Match header, it must be the ETag of the record")
+	if len(match) < 2 || match[0] != '"' || match[len(match)-1] != '"' {
+		return invalid
+	}
+
+	version, err := strconv.Atoi(match[1 : len(match)-1])
+	if err != nil || version < 1 {
+		return invalid
+	}
+
+	p.IfMatch = version
+
+	return nil
+}
+
+// ValidateRequest checks that the request names the version of the record it changes.
+func (p *Precondition) ValidateRequest(ctx context.IContext) error {
+	if p.IfMatch == 0 {
+		return errors.New("If-Match header is required")
+	}
+
+	return nil
+}
+
+// etag returns the ETag of a record at the given version.
+func etag(version int) string {
+	return `"` + strconv.Itoa(version) + `"`
+}
+
+// setETag sets the ETag header of the response to the version of the record it returns.
+func (p *Precondition) setETag(version int) {
+	p.writer.Header().Set("ETag", etag(version))
+}
+
+// failed returns the error answering a request naming a stale version, which carries the current
+// record and its ETag so that the client can merge its change and retry.
+func (p *Precondition) failed(current interface{}, version int) error {
+	return &PreconditionFailedError{Current: current, ETag: etag(version)}
+}
+
+// PreconditionFailedError is returned by the executors when the If-Match header names a version
+// of the record that is no longer current. It renders as 412 Precondition Failed with the ETag
+// header and the current record as the body, and matches repositories.ErrVersionConflict.
+type PreconditionFailedError struct {
+	// Current is the record as it is now.
+	Current interface{}
+
+	// ETag is the ETag of the current record.
+	ETag string
+}
+
+func (e *PreconditionFailedError) Error() string {
+	return "the record has changed, its current ETag is " + e.ETag
+}
+
+// Unwrap returns repositories.ErrVersionConflict.
+func (e *PreconditionFailedError) Unwrap() error {
+	return repositories.ErrVersionConflict
+}
+
+// StatusCode returns 412 Precondition Failed.
+func (e *PreconditionFailedError) StatusCode() int {
+	return http.StatusPreconditionFailed
+}
+
+// Header returns the headers of the response, which set the ETag of the current record.
+func (e *PreconditionFailedError) Header() http.Header {
+	header := http.Header{}
+	header.Set("ETag", e.ETag)
+
+	return header
+}
+
+// MarshalJSON encodes the current record as the body of the response.
+func (e *PreconditionFailedError) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.Current)
+}
@@ -0,0 +1,172 @@
+package handlers
+
+import (
+	stdcontext "context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/contact_manager/repositories/memory"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// executor is the part of an APIExecutor a request runs through.
+type executor interface {
+	ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error
+	ValidateRequest(ctx context.IContext) error
+	Controller(ctx context.IContext) (interface{}, error)
+}
+
+// serve runs r through e and renders the result the way the service does: errors carrying a
+// status code, headers and a JSON body are written as they are, other errors as 400 Bad Request.
+func serve(e executor, r *http.Request) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	ctx := r.Context()
+
+	fail := func(err error) *httptest.ResponseRecorder {
+		var rendered interface {
+			StatusCode() int
+			Header() http.Header
+			json.Marshaler
+		}
+		if !errors.As(err, &rendered) {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return w
+		}
+
+		for name, values := range rendered.Header() {
+			w.Header()[name] = values
+		}
+		body, _ := rendered.MarshalJSON()
+		w.WriteHeader(rendered.StatusCode())
+		w.Write(body)
+
+		return w
+	}
+
+	if err := e.ParseRequest(ctx, w, r); err != nil {
+		return fail(err)
+	}
+	if err := e.ValidateRequest(ctx); err != nil {
+		return fail(err)
+	}
+
+	out, err := e.Controller(ctx)
+	if err != nil {
+		return fail(err)
+	}
+
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(out)
+
+	return w
+}
+
+func newRoleRequest(method, body, ifMatch string) *http.Request {
+	r := httptest.NewRequest(method, "/roles?id=1", strings.NewReader(body))
+	if ifMatch != "" {
+		r.Header.Set("If-Match", ifMatch)
+	}
+
+	return r
+}
+
+func TestUpdateRoleETag(t *testing.T) {
+	roles := memory.NewRoleRepository()
+	if err := roles.Create(stdcontext.Background(), &repositories.Role{Name: "admin"}); err != nil {
+		t.Fatal(err)
+	}
+
+	w := serve(NewGetRoleExecutor(roles).(executor), newRoleRequest(http.MethodGet, "", ""))
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"1"` {
+		t.Fatalf("get: status %d, ETag %s, want 200 and \"1\"", w.Code, w.Header().Get("ETag"))
+	}
+
+	w = serve(NewUpdateRoleExecutor(roles).(executor), newRoleRequest(http.MethodPost, `{"name":"owner"}`, `"1"`))
+	if w.Code != http.StatusOK || w.Header().Get("ETag") != `"2"` {
+		t.Fatalf("update: status %d, ETag %s, want 200 and \"2\": %s", w.Code, w.Header().Get("ETag"), w.Body)
+	}
+
+	t.Run("Stale", func(t *testing.T) {
+		w := serve(NewUpdateRoleExecutor(roles).(executor), newRoleRequest(http.MethodPost, `{"name":"editor"}`, `"1"`))
+		if w.Code != http.StatusPreconditionFailed {
+			t.Fatalf("status %d, want %d: %s", w.Code, http.StatusPreconditionFailed, w.Body)
+		}
+		if etag := w.Header().Get("ETag"); etag != `"2"` {
+			t.Errorf("ETag %s, want \"2\"", etag)
+		}
+
+		current := &repositories.Role{}
+		if err := json.Unmarshal(w.Body.Bytes(), current); err != nil {
+			t.Fatal(err)
+		}
+		if current.ID != 1 || current.Name != "owner" || current.Version != 2 {
+			t.Errorf("body %+v, want the current role", current)
+		}
+	})
+
+	t.Run("StaleError", func(t *testing.T) {
+		e := NewUpdateRoleExecutor(roles).(executor)
+		r := newRoleRequest(http.MethodPost, `{"name":"editor"}`, `"1"`)
+		if err := e.ParseRequest(r.Context(), httptest.NewRecorder(), r); err != nil {
+			t.Fatal(err)
+		}
+
+		_, err := e.Controller(r.Context())
+		var failed *PreconditionFailedError
+		if !errors.As(err, &failed) {
+			t.Fatalf("got %v, want a PreconditionFailedError", err)
+		}
+		if !errors.Is(err, repositories.ErrVersionConflict) {
+			t.Errorf("%v does not match ErrVersionConflict", err)
+		}
+		if role, ok := failed.Current.(*repositories.Role); !ok || role.Name != "owner" || failed.ETag != `"2"` {
+			t.Errorf("got %+v with ETag %s, want the current role with ETag \"2\"", failed.Current, failed.ETag)
+		}
+	})
+
+	t.Run("MissingIfMatch", func(t *testing.T) {
+		w := serve(NewUpdateRoleExecutor(roles).(executor), newRoleRequest(http.MethodPost, `{"name":"editor"}`, ""))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "If-Match header is required") {
+			t.Errorf("update: status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+		}
+
+		w = serve(NewDeleteRoleExecutor(roles).(executor), newRoleRequest(http.MethodDelete, "", ""))
+		if w.Code != http.StatusBadRequest || !strings.Contains(w.Body.String(), "If-Match header is required") {
+			t.Errorf("delete: status %d, want %d: %s", w.Code, http.StatusBadRequest, w.Body)
+		}
+
+		role, err := roles.Get(stdcontext.Background(), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if role.Name != "owner" || role.Version != 2 {
+			t.Errorf("role %+v changed without If-Match", role)
+		}
+	})
+
+	t.Run("InvalidIfMatch", func(t *testing.T) {
+		for _, match := range []string{"2", `W/"2"`, `"x"`, `"0"`} {
+			w := serve(NewUpdateRoleExecutor(roles).(executor), newRoleRequest(http.MethodPost, `{"name":"editor"}`, match))
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("If-Match %s: status %d, want %d", match, w.Code, http.StatusBadRequest)
+			}
+		}
+	})
+
+	t.Run("StaleDelete", func(t *testing.T) {
+		w := serve(NewDeleteRoleExecutor(roles).(executor), newRoleRequest(http.MethodDelete, "", `"1"`))
+		if w.Code != http.StatusPreconditionFailed || w.Header().Get("ETag") != `"2"` {
+			t.Errorf("status %d, ETag %s, want %d and \"2\"", w.Code, w.Header().Get("ETag"), http.StatusPreconditionFailed)
+		}
+
+		w = serve(NewDeleteRoleExecutor(roles).(executor), newRoleRequest(http.MethodDelete, "", `"2"`))
+		if w.Code != http.StatusOK {
+			t.Errorf("delete with the current ETag: status %d, want 200: %s", w.Code, w.Body)
+		}
+	})
+}
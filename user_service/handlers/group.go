@@ -0,0 +1,379 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// Group defines a struct for contact group data.
+type Group struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// createGroupModel maps Group to Group model.
+func createGroupModel(g *Group) *repositories.Group {
+	return &repositories.Group{
+		ID:     g.ID,
+		UserID: g.UserID,
+		Name:   g.Name,
+	}
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Group object.
+func (g *Group) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the Group object
+		err = json.Unmarshal(body, g)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	g.ID = i
+
+	// The owner is the authenticated user, whatever the body says
+	g.UserID, err = actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Group object and returns any errors that occur during validation.
+func (g *Group) ValidateRequest(ctx context.IContext) error {
+	if g.Name == "" {
+		return errors.New("group name is required")
+	}
+	return nil
+}
+
+// CreateGroupExecutor defines an APIExecutor for creating a new group.
+type CreateGroupExecutor struct {
+	Group
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewCreateGroupExecutor returns a new instance of CreateGroupExecutor.
+func NewCreateGroupExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &CreateGroupExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// Controller executes the business logic for creating a new group and returns the created group
+// and any errors that occur during execution.
+func (e *CreateGroupExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	group := createGroupModel(&e.Group)
+	err := e.GroupRepo.Create(requestContext(ctx), group)
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// UpdateGroupExecutor defines an APIExecutor for renaming a group by ID.
+type UpdateGroupExecutor struct {
+	Group
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewUpdateGroupExecutor returns a new instance of UpdateGroupExecutor.
+func NewUpdateGroupExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &UpdateGroupExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// Controller executes the business logic for renaming a group by ID and returns the updated group
+// and any errors that occur during execution.
+func (e *UpdateGroupExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if _, err := requireGroupOwner(ctx, e.GroupRepo, e.Group.ID, e.Group.UserID); err != nil {
+		return nil, err
+	}
+
+	group := createGroupModel(&e.Group)
+	err := e.GroupRepo.Update(requestContext(ctx), group)
+	if err != nil {
+		return nil, err
+	}
+
+	return group, nil
+}
+
+// DeleteGroupExecutor defines an APIExecutor for deleting a group by ID.
+type DeleteGroupExecutor struct {
+	Group
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewDeleteGroupExecutor returns a new instance of DeleteGroupExecutor.
+func NewDeleteGroupExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &DeleteGroupExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// ValidateRequest checks the id of the group to delete. Deletes have no body, so the group itself is not validated.
+func (e *DeleteGroupExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Group.ID < 1 {
+		return errors.New("invalid id in query")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for deleting a group by ID and returns any errors that occur during execution.
+func (e *DeleteGroupExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if _, err := requireGroupOwner(ctx, e.GroupRepo, e.Group.ID, e.Group.UserID); err != nil {
+		return nil, err
+	}
+
+	err := e.GroupRepo.Delete(requestContext(ctx), e.Group.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// GetGroupExecutor defines an APIExecutor for getting a group and its member count by ID.
+type GetGroupExecutor struct {
+	Group
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewGetGroupExecutor returns a new instance of GetGroupExecutor.
+func NewGetGroupExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &GetGroupExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// ValidateRequest checks the id of the group to get. Gets have no body, so the group itself is not validated.
+func (e *GetGroupExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Group.ID < 1 {
+		return errors.New("invalid id in query")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for getting a group by ID and returns the group
+// and any errors that occur during execution.
+func (e *GetGroupExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return requireGroupOwner(ctx, e.GroupRepo, e.Group.ID, e.Group.UserID)
+}
+
+// GetAllGroupsExecutor defines an APIExecutor for getting a page of the groups of a user with their member counts.
+type GetAllGroupsExecutor struct {
+	OwnerQuery
+	PageQuery
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewGetAllGroupsExecutor returns a new instance of GetAllGroupsExecutor.
+func NewGetAllGroupsExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &GetAllGroupsExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// ParseRequest parses the authenticated user and the page of the request into the GetAllGroupsExecutor object.
+func (e *GetAllGroupsExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.OwnerQuery.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.PageQuery.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the OwnerQuery and checks that the groups can be sorted as requested.
+func (e *GetAllGroupsExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.OwnerQuery.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.PageQuery.Page.Validate(repositories.GroupSortFields...)
+}
+
+// Controller executes the business logic for getting a page of the groups of a user and returns the page
+// and any errors that occur during execution.
+func (e *GetAllGroupsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	groups, err := e.GroupRepo.GetAll(requestContext(ctx), e.OwnerQuery.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, info := repositories.PageSlice(len(groups), func(i int) repositories.SortKey { return groups[i].SortKey(e.PageQuery.Page.Sort) }, e.PageQuery.Page)
+	page := make([]*repositories.Group, 0, len(indexes))
+	for _, i := range indexes {
+		page = append(page, groups[i])
+	}
+
+	return newListResponse(page, info), nil
+}
+
+// GroupContacts defines a struct for bulk membership changes of a group on behalf of the authenticated user, who
+// must own the group and, when adding, the contacts.
+type GroupContacts struct {
+	OwnerQuery
+	ContactIDs
+}
+
+// ParseRequest parses the authenticated user, the id query parameter and the contact_ids in the body into the
+// GroupContacts object.
+func (g *GroupContacts) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := g.OwnerQuery.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return g.ContactIDs.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the data in the GroupContacts object and returns any errors that occur during validation.
+func (g *GroupContacts) ValidateRequest(ctx context.IContext) error {
+	if err := g.OwnerQuery.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return g.ContactIDs.ValidateRequest(ctx)
+}
+
+// requireGroupOwner returns the group with the given ID, or ErrPermissionDenied unless it belongs to the user.
+func requireGroupOwner(ctx context.IContext, repo repositories.GroupRepository, groupID, userID int) (*repositories.Group, error) {
+	group, err := repo.Get(requestContext(ctx), groupID)
+	if err != nil {
+		return nil, err
+	}
+
+	if group.UserID != userID {
+		return nil, repositories.ErrPermissionDenied
+	}
+
+	return group, nil
+}
+
+// requireContactsOwner returns an error unless every contact exists and belongs to the user: the error of getting a
+// missing contact, or ErrPermissionDenied for a contact of another user.
+func requireContactsOwner(ctx context.IContext, repo repositories.ContactRepository, contactIDs []int, userID int) error {
+	owned, err := repo.GetAll(requestContext(ctx), &repositories.ContactFilter{UserID: userID, ContactIDs: contactIDs})
+	if err != nil {
+		return err
+	}
+
+	ownedIDs := make(map[int]bool, len(owned))
+	for _, c := range owned {
+		ownedIDs[c.ID] = true
+	}
+
+	for _, id := range contactIDs {
+		if ownedIDs[id] {
+			continue
+		}
+
+		if _, err := repo.Get(requestContext(ctx), id); err != nil {
+			return err
+		}
+
+		return repositories.ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// AddGroupContactsExecutor defines an APIExecutor for adding contacts to a group in bulk. The acting user must own
+// the group and the contacts.
+type AddGroupContactsExecutor struct {
+	GroupContacts
+	clienthelper.BaseAPIExecutor
+	GroupRepo   repositories.GroupRepository
+	ContactRepo repositories.ContactRepository
+}
+
+// NewAddGroupContactsExecutor returns a new instance of AddGroupContactsExecutor.
+func NewAddGroupContactsExecutor(repo repositories.GroupRepository, contacts repositories.ContactRepository) clienthelper.APIExecutor {
+	return &AddGroupContactsExecutor{
+		GroupRepo:   repo,
+		ContactRepo: contacts,
+	}
+}
+
+// Controller executes the business logic for adding contacts to a group and returns the updated group
+// and any errors that occur during execution.
+func (e *AddGroupContactsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	group, err := requireGroupOwner(ctx, e.GroupRepo, e.ContactIDs.ID, e.OwnerQuery.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireContactsOwner(ctx, e.ContactRepo, e.ContactIDs.ContactIDs, group.UserID); err != nil {
+		return nil, err
+	}
+
+	err = e.GroupRepo.AddContacts(requestContext(ctx), group.ID, e.ContactIDs.ContactIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.GroupRepo.Get(requestContext(ctx), group.ID)
+}
+
+// RemoveGroupContactsExecutor defines an APIExecutor for removing contacts from a group in bulk. The acting user
+// must own the group.
+type RemoveGroupContactsExecutor struct {
+	GroupContacts
+	clienthelper.BaseAPIExecutor
+	GroupRepo repositories.GroupRepository
+}
+
+// NewRemoveGroupContactsExecutor returns a new instance of RemoveGroupContactsExecutor.
+func NewRemoveGroupContactsExecutor(repo repositories.GroupRepository) clienthelper.APIExecutor {
+	return &RemoveGroupContactsExecutor{
+		GroupRepo: repo,
+	}
+}
+
+// Controller executes the business logic for removing contacts from a group and returns the updated group
+// and any errors that occur during execution.
+func (e *RemoveGroupContactsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	group, err := requireGroupOwner(ctx, e.GroupRepo, e.ContactIDs.ID, e.OwnerQuery.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.GroupRepo.RemoveContacts(requestContext(ctx), group.ID, e.ContactIDs.ContactIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.GroupRepo.Get(requestContext(ctx), group.ID)
+}
@@ -0,0 +1,69 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// PageQuery defines a struct for the page of a list request.
+type PageQuery struct {
+	Page repositories.PageRequest
+}
+
+// ParseRequest parses the limit, sort and cursor query parameters into the PageQuery object. limit defaults to 20.
+// sort names the field to sort by, prefixed with - for descending order, and defaults to id, or to the sort order
+// of the cursor. cursor is the next_cursor or prev_cursor of the previous response.
+func (q *PageQuery) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	q.Page = repositories.PageRequest{Limit: repositories.DefaultPageLimit, Sort: "id"}
+	if limit := values.Get("limit"); limit != "" {
+		var err error
+		if q.Page.Limit, err = strconv.Atoi(limit); err != nil {
+			return errors.New("invalid limit in query")
+		}
+	}
+
+	if cursor := values.Get("cursor"); cursor != "" {
+		c, err := repositories.DecodeCursor(cursor)
+		if err != nil {
+			return err
+		}
+		q.Page.Cursor = c
+		q.Page.Sort, q.Page.Desc = c.Sort, c.Desc
+	}
+
+	if sort := values.Get("sort"); sort != "" {
+		q.Page.Desc = strings.HasPrefix(sort, "-")
+		q.Page.Sort = strings.TrimPrefix(sort, "-")
+	}
+
+	return nil
+}
+
+// ListResponse defines the response of list requests: a page of items, the number of items on all pages, and the
+// cursors of the pages after and before it, which are left out on the last and first page.
+type ListResponse struct {
+	Items      interface{} `json:"items"`
+	Total      int         `json:"total"`
+	NextCursor string      `json:"next_cursor,omitempty"`
+	PrevCursor string      `json:"prev_cursor,omitempty"`
+}
+
+// newListResponse returns the response listing the items of a page.
+func newListResponse(items interface{}, info *repositories.PageInfo) *ListResponse {
+	response := &ListResponse{Items: items, Total: info.Total}
+	if info.Next != nil {
+		response.NextCursor = info.Next.Encode()
+	}
+	if info.Prev != nil {
+		response.PrevCursor = info.Prev.Encode()
+	}
+
+	return response
+}
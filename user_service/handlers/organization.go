@@ -0,0 +1,496 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// Organization defines a struct for organization data. Organizations with an address_book_id are
+// shared by everyone working in that address book, organizations without one belong to the acting
+// user's personal contacts.
+type Organization struct {
+	ID            int    `json:"id"`
+	UserID        int    `json:"user_id"`
+	AddressBookID int    `json:"address_book_id"`
+	ParentID      int    `json:"parent_id"`
+	Name          string `json:"name"`
+	Domain        string `json:"domain"`
+}
+
+// createOrganizationModel maps Organization to Organization model.
+func createOrganizationModel(o *Organization) *repositories.Organization {
+	return &repositories.Organization{
+		ID:            o.ID,
+		UserID:        o.UserID,
+		AddressBookID: o.AddressBookID,
+		ParentID:      o.ParentID,
+		Name:          strings.TrimSpace(o.Name),
+		Domain:        strings.ToLower(strings.TrimSpace(o.Domain)),
+	}
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Organization object.
+func (o *Organization) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the Organization object
+		err = json.Unmarshal(body, o)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	o.ID = i
+
+	// The acting user is the authenticated user, whatever the body says
+	o.UserID, err = actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Organization object and returns any errors that occur during validation.
+func (o *Organization) ValidateRequest(ctx context.IContext) error {
+	if o.UserID == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// CreateOrganizationExecutor defines an APIExecutor for creating a new organization. Organizations of an
+// address book can be created by its editors.
+type CreateOrganizationExecutor struct {
+	Organization
+	clienthelper.BaseAPIExecutor
+	OrganizationRepo repositories.OrganizationRepository
+	BookRepo         repositories.AddressBookRepository
+}
+
+// NewCreateOrganizationExecutor returns a new instance of CreateOrganizationExecutor.
+func NewCreateOrganizationExecutor(repo repositories.OrganizationRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &CreateOrganizationExecutor{
+		OrganizationRepo: repo,
+		BookRepo:         books,
+	}
+}
+
+// Controller executes the business logic for creating an organization and returns the created organization
+// and any errors that occur during execution.
+func (e *CreateOrganizationExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	organization := createOrganizationModel(&e.Organization)
+	if organization.Name == "" {
+		return nil, errors.New("name is required")
+	}
+
+	err := requireScopePermission(ctx, e.BookRepo, organization.AddressBookID, organization.UserID, e.Organization.UserID, repositories.PermissionEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.OrganizationRepo.Create(requestContext(ctx), organization)
+	if err != nil {
+		return nil, err
+	}
+
+	return organization, nil
+}
+
+// UpdateOrganizationExecutor defines an APIExecutor for renaming an organization or moving it within the
+// hierarchy. The address book of an organization is fixed.
+type UpdateOrganizationExecutor struct {
+	Organization
+	clienthelper.BaseAPIExecutor
+	OrganizationRepo repositories.OrganizationRepository
+	BookRepo         repositories.AddressBookRepository
+}
+
+// NewUpdateOrganizationExecutor returns a new instance of UpdateOrganizationExecutor.
+func NewUpdateOrganizationExecutor(repo repositories.OrganizationRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &UpdateOrganizationExecutor{
+		OrganizationRepo: repo,
+		BookRepo:         books,
+	}
+}
+
+// Controller executes the business logic for updating an organization and returns the updated organization
+// and any errors that occur during execution.
+func (e *UpdateOrganizationExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	organization, err := e.OrganizationRepo.Get(requestContext(ctx), e.Organization.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireScopePermission(ctx, e.BookRepo, organization.AddressBookID, organization.UserID, e.Organization.UserID, repositories.PermissionEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	changes := createOrganizationModel(&e.Organization)
+	if changes.Name != "" {
+		organization.Name = changes.Name
+	}
+	organization.Domain = changes.Domain
+	organization.ParentID = changes.ParentID
+
+	err = e.OrganizationRepo.Update(requestContext(ctx), organization)
+	if err != nil {
+		return nil, err
+	}
+
+	return organization, nil
+}
+
+// DeleteOrganizationExecutor defines an APIExecutor for deleting an organization by ID. Its contacts are
+// kept, only their links to the organization are removed.
+type DeleteOrganizationExecutor struct {
+	Organization
+	clienthelper.BaseAPIExecutor
+	OrganizationRepo repositories.OrganizationRepository
+	BookRepo         repositories.AddressBookRepository
+}
+
+// NewDeleteOrganizationExecutor returns a new instance of DeleteOrganizationExecutor.
+func NewDeleteOrganizationExecutor(repo repositories.OrganizationRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &DeleteOrganizationExecutor{
+		OrganizationRepo: repo,
+		BookRepo:         books,
+	}
+}
+
+// Controller executes the business logic for deleting an organization by ID and returns any errors that occur during execution.
+func (e *DeleteOrganizationExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	organization, err := e.OrganizationRepo.Get(requestContext(ctx), e.Organization.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireScopePermission(ctx, e.BookRepo, organization.AddressBookID, organization.UserID, e.Organization.UserID, repositories.PermissionEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.OrganizationRepo.Delete(requestContext(ctx), organization.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// GetAllOrganizationsExecutor defines an APIExecutor for listing a page of the organizations of an address book, or
+// the acting user's personal organizations.
+type GetAllOrganizationsExecutor struct {
+	ScopeQuery
+	PageQuery
+	clienthelper.BaseAPIExecutor
+	OrganizationRepo repositories.OrganizationRepository
+	BookRepo         repositories.AddressBookRepository
+}
+
+// NewGetAllOrganizationsExecutor returns a new instance of GetAllOrganizationsExecutor.
+func NewGetAllOrganizationsExecutor(repo repositories.OrganizationRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetAllOrganizationsExecutor{
+		OrganizationRepo: repo,
+		BookRepo:         books,
+	}
+}
+
+// ParseRequest parses the scope and the page of the request into the GetAllOrganizationsExecutor object.
+func (e *GetAllOrganizationsExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.ScopeQuery.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.PageQuery.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the ScopeQuery and checks that the organizations can be sorted as requested.
+func (e *GetAllOrganizationsExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.ScopeQuery.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.PageQuery.Page.Validate(repositories.OrganizationSortFields...)
+}
+
+// Controller executes the business logic for listing a page of the organizations and returns the page
+// and any errors that occur during execution.
+func (e *GetAllOrganizationsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if e.ScopeQuery.AddressBookID != 0 {
+		err := requireAddressBookPermission(ctx, e.BookRepo, e.ScopeQuery.AddressBookID, e.ScopeQuery.UserID, repositories.PermissionViewer)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	organizations, err := e.OrganizationRepo.GetAll(requestContext(ctx), e.ScopeQuery.UserID, e.ScopeQuery.AddressBookID)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, info := repositories.PageSlice(len(organizations), func(i int) repositories.SortKey { return organizations[i].SortKey(e.PageQuery.Page.Sort) }, e.PageQuery.Page)
+	page := make([]*repositories.Organization, 0, len(indexes))
+	for _, i := range indexes {
+		page = append(page, organizations[i])
+	}
+
+	return newListResponse(page, info), nil
+}
+
+// OrganizationMember defines a struct for linking a contact to an organization.
+type OrganizationMember struct {
+	OrganizationID int
+	UserID         int    `json:"user_id"`
+	ContactID      int    `json:"contact_id"`
+	Title          string `json:"title"`
+	Department     string `json:"department"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the OrganizationMember object.
+// The organization is taken from the id query parameter.
+func (m *OrganizationMember) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	m.OrganizationID = i
+
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the OrganizationMember object
+	if err := json.Unmarshal(body, m); err != nil {
+		return err
+	}
+
+	// The acting user is the authenticated user, whatever the body says
+	m.UserID, err = actingUser(r)
+	return err
+}
+
+// ValidateRequest validates the data in the OrganizationMember object and returns any errors that occur during validation.
+func (m *OrganizationMember) ValidateRequest(ctx context.IContext) error {
+	if m.UserID == 0 {
+		return ErrUnauthenticated
+	}
+
+	if m.ContactID == 0 {
+		return errors.New("contact_id field is required")
+	}
+
+	return nil
+}
+
+// AddOrganizationMemberExecutor defines an APIExecutor for linking a contact to an organization, or changing
+// the title and department of an existing link.
+type AddOrganizationMemberExecutor struct {
+	OrganizationMember
+	clienthelper.BaseAPIExecutor
+	OrganizationRepo repositories.OrganizationRepository
+	ContactRepo      repositories.ContactRepository
+	BookRepo         repositories.AddressBookRepository
+}
+
+// NewAddOrganizationMemberExecutor returns a new instance of AddOrganizationMemberExecutor.
+func NewAddOrganizationMemberExecutor(repo repositories.OrganizationRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &AddOrganizationMemberExecutor{
+		OrganizationRepo: repo,
+		ContactRepo:      contacts,
+		BookRepo:         books,
+	}
+}
+
+// Controller executes the business logic for linking a contact to an organization and returns the link
+// and any errors that occur during execution.
+func (e *AddOrganizationMemberExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	organization, err := e.OrganizationRepo.Get(requestContext(ctx), e.OrganizationMember.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireScopePermission(ctx, e.BookRepo, organization.AddressBookID, organization.UserID, e.OrganizationMember.UserID, repositories.PermissionEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = requireContactPermission(ctx, e.ContactRepo, e.BookRepo, e.OrganizationMember.ContactID, e.OrganizationMember.UserID, repositories.PermissionViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	member := &repositories.OrganizationMember{
+		OrganizationID: organization.ID,
+		ContactID:      e.OrganizationMember.ContactID,
+		Title:          strings.TrimSpace(e.OrganizationMember.Title),
+		Department:     strings.TrimSpace(e.OrganizationMember.Department),
+	}
+
+	err = e.OrganizationRepo.AddMember(requestContext(ctx), member)
+	if err != nil {
+		return nil, err
+	}
+
+	return member, nil
+}
+
+// RemoveOrganizationMemberExecutor defines an APIExecutor for unlinking a contact from an organization.
+type RemoveOrganizationMemberExecutor struct {
+	OrganizationMember
+	clienthelper.BaseAPIExecutor
+	OrganizationRepo repositories.OrganizationRepository
+	BookRepo         repositories.AddressBookRepository
+}
+
+// NewRemoveOrganizationMemberExecutor returns a new instance of RemoveOrganizationMemberExecutor.
+func NewRemoveOrganizationMemberExecutor(repo repositories.OrganizationRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &RemoveOrganizationMemberExecutor{
+		OrganizationRepo: repo,
+		BookRepo:         books,
+	}
+}
+
+// Controller executes the business logic for unlinking a contact from an organization and returns any errors
+// that occur during execution.
+func (e *RemoveOrganizationMemberExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	organization, err := e.OrganizationRepo.Get(requestContext(ctx), e.OrganizationMember.OrganizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireScopePermission(ctx, e.BookRepo, organization.AddressBookID, organization.UserID, e.OrganizationMember.UserID, repositories.PermissionEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.OrganizationRepo.RemoveMember(requestContext(ctx), organization.ID, e.OrganizationMember.ContactID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// GetOrganizationGraphExecutor defines an APIExecutor for getting the people graph of an organization: the
+// contacts linked to it with their positions, the relationships among them and the organizations directly
+// below it. Contacts the acting user cannot read are left out.
+type GetOrganizationGraphExecutor struct {
+	Organization
+	clienthelper.BaseAPIExecutor
+	OrganizationRepo repositories.OrganizationRepository
+	RelationshipRepo repositories.RelationshipRepository
+	ContactRepo      repositories.ContactRepository
+	BookRepo         repositories.AddressBookRepository
+}
+
+// NewGetOrganizationGraphExecutor returns a new instance of GetOrganizationGraphExecutor.
+func NewGetOrganizationGraphExecutor(repo repositories.OrganizationRepository, relationships repositories.RelationshipRepository,
+	contacts repositories.ContactRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetOrganizationGraphExecutor{
+		OrganizationRepo: repo,
+		RelationshipRepo: relationships,
+		ContactRepo:      contacts,
+		BookRepo:         books,
+	}
+}
+
+// Controller executes the business logic for building the people graph of an organization and returns the graph
+// and any errors that occur during execution.
+func (e *GetOrganizationGraphExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	organization, err := e.OrganizationRepo.Get(requestContext(ctx), e.Organization.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireScopePermission(ctx, e.BookRepo, organization.AddressBookID, organization.UserID, e.Organization.UserID, repositories.PermissionViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	children, err := e.OrganizationRepo.GetChildren(requestContext(ctx), organization.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	graph := &repositories.OrganizationGraph{
+		Organization:  organization,
+		Children:      children,
+		People:        []*repositories.OrganizationPerson{},
+		Relationships: []*repositories.Relationship{},
+	}
+
+	members, err := e.OrganizationRepo.GetMembers(requestContext(ctx), organization.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(members) == 0 {
+		return graph, nil
+	}
+
+	ids := []int{}
+	for _, m := range members {
+		ids = append(ids, m.ContactID)
+	}
+
+	contacts := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, e.Organization.UserID)
+	readable, err := contacts.GetAll(requestContext(ctx), &repositories.ContactFilter{ContactIDs: ids})
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[int]*repositories.Contact{}
+	for _, c := range readable {
+		byID[c.ID] = c
+	}
+
+	people := []int{}
+	for _, m := range members {
+		if c, ok := byID[m.ContactID]; ok {
+			graph.People = append(graph.People, &repositories.OrganizationPerson{Contact: c, Title: m.Title, Department: m.Department})
+			people = append(people, c.ID)
+		}
+	}
+
+	relationships, err := e.RelationshipRepo.GetForContacts(requestContext(ctx), people)
+	if err != nil {
+		return nil, err
+	}
+
+	// only edges between members belong to the graph
+	for _, rel := range relationships {
+		if _, ok := byID[rel.RelatedContactID]; ok {
+			graph.Relationships = append(graph.Relationships, rel)
+		}
+	}
+
+	return graph, nil
+}
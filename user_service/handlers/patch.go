@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"mime"
+	"net/http"
+
+	"github.com/princeparmar/contact_manager/jsonpatch"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// PatchRequest defines a struct for the body of a PATCH request: a JSON merge patch sent as
+// application/merge-patch+json, or a JSON Patch sent as application/json-patch+json. Either one
+// is applied to the JSON representation the record has in create and update requests.
+type PatchRequest struct {
+	ContentType string
+	Body        []byte
+}
+
+// ParseRequest reads the patch and its media type into the PatchRequest object.
+func (p *PatchRequest) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return errors.New("invalid Content-Type header")
+	}
+	p.ContentType = mediaType
+
+	p.Body, err = ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRequest checks that the request carries a patch in one of the supported formats.
+func (p *PatchRequest) ValidateRequest(ctx context.IContext) error {
+	if p.ContentType != jsonpatch.MergePatchType && p.ContentType != jsonpatch.JSONPatchType {
+		return fmt.Errorf("patches must be sent as %s or %s", jsonpatch.MergePatchType, jsonpatch.JSONPatchType)
+	}
+
+	if len(bytes.TrimSpace(p.Body)) == 0 {
+		return errors.New("patch is required")
+	}
+
+	return nil
+}
+
+// apply patches the JSON representation of doc and decodes the patched document into out, which
+// must be a pointer to a zero value of the type of doc. A patch adding members that the
+// representation does not have is an error.
+func (p *PatchRequest) apply(doc, out interface{}) error {
+	data, err := json.Marshal(doc)
+	if err != nil {
+		return err
+	}
+
+	if p.ContentType == jsonpatch.MergePatchType {
+		data, err = jsonpatch.MergePatch(data, p.Body)
+	} else {
+		data, err = jsonpatch.Apply(data, p.Body)
+	}
+	if err != nil {
+		return err
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if err := decoder.Decode(out); err != nil {
+		return fmt.Errorf("invalid patched document: %v", err)
+	}
+
+	return nil
+}
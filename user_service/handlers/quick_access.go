@@ -0,0 +1,322 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// maxQuickAccessLimit caps the length of a quick-access list.
+const maxQuickAccessLimit = 50
+
+// QuickAccessContact is a contact in a quick-access list. Score and Date are described on
+// repositories.RankedContact.
+type QuickAccessContact struct {
+	*repositories.Contact
+	Favorite bool
+	Score    float64
+	Date     time.Time
+}
+
+// AddFavoriteExecutor defines an APIExecutor for adding a contact to the acting user's favorites.
+// The user needs read access to the contact.
+type AddFavoriteExecutor struct {
+	ContactItemsQuery
+	clienthelper.BaseAPIExecutor
+	QuickAccessRepo repositories.QuickAccessRepository
+	ContactRepo     repositories.ContactRepository
+	BookRepo        repositories.AddressBookRepository
+}
+
+// NewAddFavoriteExecutor returns a new instance of AddFavoriteExecutor.
+func NewAddFavoriteExecutor(repo repositories.QuickAccessRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &AddFavoriteExecutor{
+		QuickAccessRepo: repo,
+		ContactRepo:     contacts,
+		BookRepo:        books,
+	}
+}
+
+// Controller executes the business logic for adding a favorite and returns any errors that occur during execution.
+func (e *AddFavoriteExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	q := &e.ContactItemsQuery
+	_, err := requireContactPermission(ctx, e.ContactRepo, e.BookRepo, q.ContactID, q.UserID, repositories.PermissionViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.QuickAccessRepo.AddFavorite(requestContext(ctx), q.UserID, q.ContactID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// RemoveFavoriteExecutor defines an APIExecutor for removing a contact from the acting user's favorites.
+type RemoveFavoriteExecutor struct {
+	ContactItemsQuery
+	clienthelper.BaseAPIExecutor
+	QuickAccessRepo repositories.QuickAccessRepository
+}
+
+// NewRemoveFavoriteExecutor returns a new instance of RemoveFavoriteExecutor.
+func NewRemoveFavoriteExecutor(repo repositories.QuickAccessRepository) clienthelper.APIExecutor {
+	return &RemoveFavoriteExecutor{
+		QuickAccessRepo: repo,
+	}
+}
+
+// Controller executes the business logic for removing a favorite and returns any errors that occur during execution.
+func (e *RemoveFavoriteExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	err := e.QuickAccessRepo.RemoveFavorite(requestContext(ctx), e.ContactItemsQuery.UserID, e.ContactItemsQuery.ContactID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// QuickAccessQuery defines a struct for the quick-access lists of a user.
+type QuickAccessQuery struct {
+	UserID int
+	Limit  int
+}
+
+// ParseRequest parses the authenticated user and the optional limit query parameter into the QuickAccessQuery object.
+// limit defaults to 10.
+func (q *QuickAccessQuery) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+	q.UserID = userID
+
+	q.Limit = 10
+	if limit := values.Get("limit"); limit != "" {
+		q.Limit, err = strconv.Atoi(limit)
+		if err != nil {
+			return errors.New("invalid limit in query")
+		}
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the QuickAccessQuery object and returns any errors that occur during validation.
+func (q *QuickAccessQuery) ValidateRequest(ctx context.IContext) error {
+	if q.Limit < 1 || q.Limit > maxQuickAccessLimit {
+		return errors.New("limit must be between 1 and " + strconv.Itoa(maxQuickAccessLimit))
+	}
+	return nil
+}
+
+// quickAccessList resolves ranked entries to the contacts the user can still read, keeping the
+// ranking and dropping duplicates, and stops after limit contacts. Entries of deleted contacts and
+// of contacts the user lost access to are left out; favorites are the user's favorites.
+func quickAccessList(ctx context.IContext, contacts repositories.ContactRepository, favorites, entries []*repositories.RankedContact,
+	limit int) ([]*QuickAccessContact, error) {
+	ids := []int{}
+	for _, e := range entries {
+		ids = append(ids, e.ContactID)
+	}
+
+	list := []*QuickAccessContact{}
+	if len(ids) == 0 {
+		return list, nil
+	}
+
+	readable, err := contacts.GetAll(requestContext(ctx), &repositories.ContactFilter{ContactIDs: ids})
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[int]*repositories.Contact{}
+	for _, c := range readable {
+		byID[c.ID] = c
+	}
+
+	favorite := map[int]bool{}
+	for _, f := range favorites {
+		favorite[f.ContactID] = true
+	}
+
+	for _, e := range entries {
+		c, ok := byID[e.ContactID]
+		if !ok {
+			continue
+		}
+		delete(byID, e.ContactID)
+
+		list = append(list, &QuickAccessContact{Contact: c, Favorite: favorite[c.ID], Score: e.Score, Date: e.Date})
+		if len(list) == limit {
+			break
+		}
+	}
+
+	return list, nil
+}
+
+// GetFavoritesExecutor defines an APIExecutor for the favorites of a user, the most frequently
+// contacted first.
+type GetFavoritesExecutor struct {
+	QuickAccessQuery
+	clienthelper.BaseAPIExecutor
+	QuickAccessRepo repositories.QuickAccessRepository
+	ContactRepo     repositories.ContactRepository
+	BookRepo        repositories.AddressBookRepository
+}
+
+// NewGetFavoritesExecutor returns a new instance of GetFavoritesExecutor.
+func NewGetFavoritesExecutor(repo repositories.QuickAccessRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetFavoritesExecutor{
+		QuickAccessRepo: repo,
+		ContactRepo:     contacts,
+		BookRepo:        books,
+	}
+}
+
+// Controller executes the business logic for listing the favorites of a user and returns the contacts
+// and any errors that occur during execution.
+func (e *GetFavoritesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	q, now := &e.QuickAccessQuery, time.Now()
+	entries, err := e.QuickAccessRepo.GetFavorites(requestContext(ctx), q.UserID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, q.UserID)
+	return quickAccessList(ctx, contacts, entries, entries, q.Limit)
+}
+
+// GetRecentContactsExecutor defines an APIExecutor for the contacts a user viewed recently, the most
+// recent first.
+type GetRecentContactsExecutor struct {
+	QuickAccessQuery
+	clienthelper.BaseAPIExecutor
+	QuickAccessRepo repositories.QuickAccessRepository
+	ContactRepo     repositories.ContactRepository
+	BookRepo        repositories.AddressBookRepository
+}
+
+// NewGetRecentContactsExecutor returns a new instance of GetRecentContactsExecutor.
+func NewGetRecentContactsExecutor(repo repositories.QuickAccessRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetRecentContactsExecutor{
+		QuickAccessRepo: repo,
+		ContactRepo:     contacts,
+		BookRepo:        books,
+	}
+}
+
+// Controller executes the business logic for listing the recently viewed contacts of a user and returns
+// the contacts and any errors that occur during execution.
+func (e *GetRecentContactsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	q := &e.QuickAccessQuery
+	entries, err := e.QuickAccessRepo.GetRecent(requestContext(ctx), q.UserID, repositories.MaxRecentContacts)
+	if err != nil {
+		return nil, err
+	}
+
+	favorites, err := e.QuickAccessRepo.GetFavorites(requestContext(ctx), q.UserID, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, q.UserID)
+	return quickAccessList(ctx, contacts, favorites, entries, q.Limit)
+}
+
+// GetFrequentContactsExecutor defines an APIExecutor for the contacts a user interacts with most,
+// ranked by frequency score.
+type GetFrequentContactsExecutor struct {
+	QuickAccessQuery
+	clienthelper.BaseAPIExecutor
+	QuickAccessRepo repositories.QuickAccessRepository
+	ContactRepo     repositories.ContactRepository
+	BookRepo        repositories.AddressBookRepository
+}
+
+// NewGetFrequentContactsExecutor returns a new instance of GetFrequentContactsExecutor.
+func NewGetFrequentContactsExecutor(repo repositories.QuickAccessRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetFrequentContactsExecutor{
+		QuickAccessRepo: repo,
+		ContactRepo:     contacts,
+		BookRepo:        books,
+	}
+}
+
+// Controller executes the business logic for listing the frequently contacted contacts of a user and
+// returns the contacts and any errors that occur during execution.
+func (e *GetFrequentContactsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	q, now := &e.QuickAccessQuery, time.Now()
+
+	// fetch extra entries so that contacts the user can no longer read do not shorten the list
+	entries, err := e.QuickAccessRepo.GetFrequent(requestContext(ctx), q.UserID, now, 2*q.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	favorites, err := e.QuickAccessRepo.GetFavorites(requestContext(ctx), q.UserID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, q.UserID)
+	return quickAccessList(ctx, contacts, favorites, entries, q.Limit)
+}
+
+// GetQuickAccessExecutor defines an APIExecutor for a single quick-access list of a user: the favorites,
+// followed by the most frequently contacted and then the recently viewed contacts, each contact listed once.
+type GetQuickAccessExecutor struct {
+	QuickAccessQuery
+	clienthelper.BaseAPIExecutor
+	QuickAccessRepo repositories.QuickAccessRepository
+	ContactRepo     repositories.ContactRepository
+	BookRepo        repositories.AddressBookRepository
+}
+
+// NewGetQuickAccessExecutor returns a new instance of GetQuickAccessExecutor.
+func NewGetQuickAccessExecutor(repo repositories.QuickAccessRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetQuickAccessExecutor{
+		QuickAccessRepo: repo,
+		ContactRepo:     contacts,
+		BookRepo:        books,
+	}
+}
+
+// Controller executes the business logic for building the quick-access list of a user and returns the
+// contacts and any errors that occur during execution.
+func (e *GetQuickAccessExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	q, now := &e.QuickAccessQuery, time.Now()
+
+	favorites, err := e.QuickAccessRepo.GetFavorites(requestContext(ctx), q.UserID, now)
+	if err != nil {
+		return nil, err
+	}
+
+	frequent, err := e.QuickAccessRepo.GetFrequent(requestContext(ctx), q.UserID, now, 2*q.Limit)
+	if err != nil {
+		return nil, err
+	}
+
+	recent, err := e.QuickAccessRepo.GetRecent(requestContext(ctx), q.UserID, repositories.MaxRecentContacts)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := append(append(favorites, frequent...), recent...)
+	contacts := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, q.UserID)
+	return quickAccessList(ctx, contacts, favorites, entries, q.Limit)
+}
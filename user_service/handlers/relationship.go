@@ -0,0 +1,289 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// Relationship defines a struct for relationship data. related_contact_id is the type of
+// contact_id, e.g. their manager; bidirectional relationships also record the inverse.
+type Relationship struct {
+	ID               int    `json:"id"`
+	UserID           int    `json:"user_id"`
+	ContactID        int    `json:"contact_id"`
+	RelatedContactID int    `json:"related_contact_id"`
+	Type             string `json:"type"`
+	Bidirectional    bool   `json:"bidirectional"`
+}
+
+// createRelationshipModel maps Relationship to Relationship model.
+func createRelationshipModel(r *Relationship) *repositories.Relationship {
+	return &repositories.Relationship{
+		ID:               r.ID,
+		ContactID:        r.ContactID,
+		RelatedContactID: r.RelatedContactID,
+		Type:             r.Type,
+		Bidirectional:    r.Bidirectional,
+	}
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Relationship object.
+func (rel *Relationship) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the Relationship object
+		err = json.Unmarshal(body, rel)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	rel.ID = i
+
+	// The acting user is the authenticated user, whatever the body says
+	rel.UserID, err = actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Relationship object and returns any errors that occur during validation.
+func (rel *Relationship) ValidateRequest(ctx context.IContext) error {
+	if rel.UserID == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// requireContactPermission returns the contact with the given ID, or ErrPermissionDenied unless the
+// user holds at least the given permission on it.
+func requireContactPermission(ctx context.IContext, contacts repositories.ContactRepository, books repositories.AddressBookRepository,
+	contactID, userID int, permission repositories.Permission) (*repositories.Contact, error) {
+	c, err := contacts.Get(requestContext(ctx), contactID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = requireScopePermission(ctx, books, c.AddressBookID, c.UserID, userID, permission)
+	if err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// CreateRelationshipExecutor defines an APIExecutor for relating two contacts. The acting user needs
+// write access to the contact, and to the related contact as well for bidirectional relationships.
+type CreateRelationshipExecutor struct {
+	Relationship
+	clienthelper.BaseAPIExecutor
+	RelationshipRepo repositories.RelationshipRepository
+	ContactRepo      repositories.ContactRepository
+	BookRepo         repositories.AddressBookRepository
+}
+
+// NewCreateRelationshipExecutor returns a new instance of CreateRelationshipExecutor.
+func NewCreateRelationshipExecutor(repo repositories.RelationshipRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &CreateRelationshipExecutor{
+		RelationshipRepo: repo,
+		ContactRepo:      contacts,
+		BookRepo:         books,
+	}
+}
+
+// Controller executes the business logic for relating two contacts and returns the created relationship
+// and any errors that occur during execution.
+func (e *CreateRelationshipExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	rel := createRelationshipModel(&e.Relationship)
+	if _, err := repositories.InverseRelationshipType(rel.Type); err != nil {
+		return nil, err
+	}
+
+	_, err := requireContactPermission(ctx, e.ContactRepo, e.BookRepo, rel.ContactID, e.Relationship.UserID, repositories.PermissionEditor)
+	if err != nil {
+		return nil, err
+	}
+
+	related := repositories.PermissionViewer
+	if rel.Bidirectional {
+		related = repositories.PermissionEditor
+	}
+
+	_, err = requireContactPermission(ctx, e.ContactRepo, e.BookRepo, rel.RelatedContactID, e.Relationship.UserID, related)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.RelationshipRepo.Create(requestContext(ctx), rel)
+	if err != nil {
+		return nil, err
+	}
+
+	return rel, nil
+}
+
+// DeleteRelationshipExecutor defines an APIExecutor for deleting a relationship by ID together with its inverse.
+type DeleteRelationshipExecutor struct {
+	Relationship
+	clienthelper.BaseAPIExecutor
+	RelationshipRepo repositories.RelationshipRepository
+	ContactRepo      repositories.ContactRepository
+	BookRepo         repositories.AddressBookRepository
+}
+
+// NewDeleteRelationshipExecutor returns a new instance of DeleteRelationshipExecutor.
+func NewDeleteRelationshipExecutor(repo repositories.RelationshipRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &DeleteRelationshipExecutor{
+		RelationshipRepo: repo,
+		ContactRepo:      contacts,
+		BookRepo:         books,
+	}
+}
+
+// Controller executes the business logic for deleting a relationship by ID and returns any errors that occur during execution.
+func (e *DeleteRelationshipExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	rel, err := e.RelationshipRepo.Get(requestContext(ctx), e.Relationship.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	contactIDs := []int{rel.ContactID}
+	if rel.Bidirectional {
+		contactIDs = append(contactIDs, rel.RelatedContactID)
+	}
+
+	for _, contactID := range contactIDs {
+		_, err := requireContactPermission(ctx, e.ContactRepo, e.BookRepo, contactID, e.Relationship.UserID, repositories.PermissionEditor)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = e.RelationshipRepo.Delete(requestContext(ctx), rel.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// ContactItemsQuery defines a struct for listing what belongs to a single contact, such as its
+// relationships or attachments.
+type ContactItemsQuery struct {
+	UserID    int
+	ContactID int
+}
+
+// ParseRequest parses the authenticated user and the contact_id query parameter into the ContactItemsQuery object.
+func (q *ContactItemsQuery) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+	q.UserID = userID
+
+	contactID, err := strconv.Atoi(values.Get("contact_id"))
+	if err != nil {
+		return errors.New("invalid contact_id in query")
+	}
+	q.ContactID = contactID
+
+	return nil
+}
+
+// ValidateRequest validates the data in the ContactItemsQuery object and returns any errors that occur during validation.
+func (q *ContactItemsQuery) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// GetContactRelationshipsExecutor defines an APIExecutor for listing the relationships of a contact.
+// Relationships to contacts the acting user cannot read are left out.
+type GetContactRelationshipsExecutor struct {
+	ContactItemsQuery
+	clienthelper.BaseAPIExecutor
+	RelationshipRepo repositories.RelationshipRepository
+	ContactRepo      repositories.ContactRepository
+	BookRepo         repositories.AddressBookRepository
+}
+
+// NewGetContactRelationshipsExecutor returns a new instance of GetContactRelationshipsExecutor.
+func NewGetContactRelationshipsExecutor(repo repositories.RelationshipRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetContactRelationshipsExecutor{
+		RelationshipRepo: repo,
+		ContactRepo:      contacts,
+		BookRepo:         books,
+	}
+}
+
+// Controller executes the business logic for listing the relationships of a contact and returns the relationships
+// and any errors that occur during execution.
+func (e *GetContactRelationshipsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	contacts := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, e.ContactItemsQuery.UserID)
+	if _, err := contacts.Get(requestContext(ctx), e.ContactItemsQuery.ContactID); err != nil {
+		return nil, err
+	}
+
+	relationships, err := e.RelationshipRepo.GetForContacts(requestContext(ctx), []int{e.ContactItemsQuery.ContactID})
+	if err != nil {
+		return nil, err
+	}
+
+	return readableRelationships(ctx, contacts, relationships)
+}
+
+// readableRelationships drops the relationships whose related contact cannot be read through contacts.
+func readableRelationships(ctx context.IContext, contacts repositories.ContactRepository, relationships []*repositories.Relationship) ([]*repositories.Relationship, error) {
+	ids := []int{}
+	for _, rel := range relationships {
+		ids = append(ids, rel.RelatedContactID)
+	}
+
+	if len(ids) == 0 {
+		return relationships, nil
+	}
+
+	readable, err := contacts.GetAll(requestContext(ctx), &repositories.ContactFilter{ContactIDs: ids})
+	if err != nil {
+		return nil, err
+	}
+
+	visible := map[int]bool{}
+	for _, c := range readable {
+		visible[c.ID] = true
+	}
+
+	result := []*repositories.Relationship{}
+	for _, rel := range relationships {
+		if visible[rel.RelatedContactID] {
+			result = append(result, rel)
+		}
+	}
+
+	return result, nil
+}
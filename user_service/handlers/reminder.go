@@ -0,0 +1,391 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/princeparmar/contact_manager/reminders"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// maxUpcomingDays caps how far ahead upcoming reminders can be listed.
+const maxUpcomingDays = 366
+
+// Reminder defines a struct for reminder data. start_date is the wall clock date, optionally with a time
+// (2006-01-02 or 2006-01-02T15:04), of the first occurrence in time_zone; date-only reminders fire at 09:00.
+// Birthdays and anniversaries always recur yearly, follow-ups default to firing once.
+type Reminder struct {
+	ID         int    `json:"id"`
+	UserID     int    `json:"user_id"`
+	ContactID  int    `json:"contact_id"`
+	Kind       string `json:"kind"`
+	Note       string `json:"note"`
+	StartDate  string `json:"start_date"`
+	Recurrence string `json:"recurrence"`
+	TimeZone   string `json:"time_zone"`
+}
+
+// createReminderModel maps Reminder to Reminder model, filling in the defaults of its kind.
+func createReminderModel(r *Reminder) (*repositories.Reminder, error) {
+	rem := &repositories.Reminder{
+		ID:         r.ID,
+		UserID:     r.UserID,
+		ContactID:  r.ContactID,
+		Kind:       r.Kind,
+		Note:       strings.TrimSpace(r.Note),
+		Recurrence: r.Recurrence,
+		TimeZone:   r.TimeZone,
+	}
+
+	switch rem.Kind {
+	case repositories.ReminderBirthday, repositories.ReminderAnniversary:
+		rem.Recurrence = repositories.RecurrenceYearly
+	case repositories.ReminderFollowUp:
+		if rem.Recurrence == "" {
+			rem.Recurrence = repositories.RecurrenceNone
+		}
+	default:
+		return nil, errors.New("kind must be one of birthday, anniversary or follow_up")
+	}
+
+	if rem.TimeZone == "" {
+		rem.TimeZone = "UTC"
+	}
+
+	start, err := time.Parse("2006-01-02T15:04", r.StartDate)
+	if err != nil {
+		start, err = time.Parse("2006-01-02", r.StartDate)
+		if err != nil {
+			return nil, errors.New("start_date must be formatted as 2006-01-02 or 2006-01-02T15:04")
+		}
+		start = start.Add(9 * time.Hour)
+	}
+	rem.StartDate = start
+
+	return rem, nil
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Reminder object.
+func (rem *Reminder) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the Reminder object
+		err = json.Unmarshal(body, rem)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	rem.ID = i
+
+	// The acting user is the authenticated user, whatever the body says
+	rem.UserID, err = actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Reminder object and returns any errors that occur during validation.
+func (rem *Reminder) ValidateRequest(ctx context.IContext) error {
+	if rem.UserID == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// requireReminderOwner returns the reminder with the given ID, or ErrPermissionDenied unless it belongs to the user.
+func requireReminderOwner(ctx context.IContext, repo repositories.ReminderRepository, id, userID int) (*repositories.Reminder, error) {
+	rem, err := repo.Get(requestContext(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if rem.UserID != userID {
+		return nil, repositories.ErrPermissionDenied
+	}
+
+	return rem, nil
+}
+
+// CreateReminderExecutor defines an APIExecutor for creating a reminder about a contact for the acting user.
+type CreateReminderExecutor struct {
+	Reminder
+	clienthelper.BaseAPIExecutor
+	ReminderRepo repositories.ReminderRepository
+	ContactRepo  repositories.ContactRepository
+	BookRepo     repositories.AddressBookRepository
+}
+
+// NewCreateReminderExecutor returns a new instance of CreateReminderExecutor.
+func NewCreateReminderExecutor(repo repositories.ReminderRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &CreateReminderExecutor{
+		ReminderRepo: repo,
+		ContactRepo:  contacts,
+		BookRepo:     books,
+	}
+}
+
+// Controller executes the business logic for creating a reminder and returns the created reminder
+// and any errors that occur during execution.
+func (e *CreateReminderExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	rem, err := createReminderModel(&e.Reminder)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = requireContactPermission(ctx, e.ContactRepo, e.BookRepo, rem.ContactID, e.Reminder.UserID, repositories.PermissionViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	next, ok, err := rem.Next(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, errors.New("start_date must be in the future")
+	}
+
+	rem.NextDate = next
+	err = e.ReminderRepo.Create(requestContext(ctx), rem)
+	if err != nil {
+		return nil, err
+	}
+
+	return rem, nil
+}
+
+// DeleteReminderExecutor defines an APIExecutor for deleting a reminder by ID. The reminders generated for the
+// birthday and anniversary of a contact go away with the date and cannot be deleted on their own.
+type DeleteReminderExecutor struct {
+	Reminder
+	clienthelper.BaseAPIExecutor
+	ReminderRepo repositories.ReminderRepository
+}
+
+// NewDeleteReminderExecutor returns a new instance of DeleteReminderExecutor.
+func NewDeleteReminderExecutor(repo repositories.ReminderRepository) clienthelper.APIExecutor {
+	return &DeleteReminderExecutor{
+		ReminderRepo: repo,
+	}
+}
+
+// Controller executes the business logic for deleting a reminder by ID and returns any errors that occur during execution.
+func (e *DeleteReminderExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	rem, err := requireReminderOwner(ctx, e.ReminderRepo, e.Reminder.ID, e.Reminder.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if rem.Generated {
+		return nil, errors.New("the reminder belongs to a date of the contact, remove the date from the contact instead")
+	}
+
+	err = e.ReminderRepo.Delete(requestContext(ctx), rem.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// UpcomingQuery defines a struct for listing the reminders of a user firing within the next days.
+type UpcomingQuery struct {
+	UserID int
+	Days   int
+}
+
+// ParseRequest parses the authenticated user and the optional days query parameter into the UpcomingQuery object.
+func (q *UpcomingQuery) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+	q.UserID = userID
+
+	q.Days = 30
+	if days := values.Get("days"); days != "" {
+		if q.Days, err = strconv.Atoi(days); err != nil {
+			return errors.New("invalid days in query")
+		}
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the UpcomingQuery object and returns any errors that occur during validation.
+func (q *UpcomingQuery) ValidateRequest(ctx context.IContext) error {
+	if q.Days <= 0 || q.Days > maxUpcomingDays {
+		return errors.New("days must be between 1 and 366")
+	}
+	return nil
+}
+
+// GetUpcomingRemindersExecutor defines an APIExecutor for listing the birthdays, anniversaries and follow-ups
+// of a user coming up in the next days, soonest first.
+type GetUpcomingRemindersExecutor struct {
+	UpcomingQuery
+	clienthelper.BaseAPIExecutor
+	ReminderRepo repositories.ReminderRepository
+}
+
+// NewGetUpcomingRemindersExecutor returns a new instance of GetUpcomingRemindersExecutor.
+func NewGetUpcomingRemindersExecutor(repo repositories.ReminderRepository) clienthelper.APIExecutor {
+	return &GetUpcomingRemindersExecutor{
+		ReminderRepo: repo,
+	}
+}
+
+// Controller executes the business logic for listing upcoming reminders and returns the reminders
+// and any errors that occur during execution.
+func (e *GetUpcomingRemindersExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	now := time.Now()
+	return e.ReminderRepo.GetUpcoming(requestContext(ctx), e.UpcomingQuery.UserID, now, now.AddDate(0, 0, e.UpcomingQuery.Days))
+}
+
+// ReminderAction defines a struct for snoozing and dismissing a reminder.
+type ReminderAction struct {
+	ID     int
+	UserID int       `json:"user_id"`
+	Until  time.Time `json:"until"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the ReminderAction object.
+func (a *ReminderAction) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	a.ID = i
+
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the ReminderAction object
+	if err := json.Unmarshal(body, a); err != nil {
+		return err
+	}
+
+	// The acting user is the authenticated user, whatever the body says
+	a.UserID, err = actingUser(r)
+	return err
+}
+
+// ValidateRequest validates the data in the ReminderAction object and returns any errors that occur during validation.
+func (a *ReminderAction) ValidateRequest(ctx context.IContext) error {
+	if a.UserID == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// SnoozeReminderExecutor defines an APIExecutor for postponing the current occurrence of a reminder. Later
+// occurrences of a recurring reminder are not affected.
+type SnoozeReminderExecutor struct {
+	ReminderAction
+	clienthelper.BaseAPIExecutor
+	ReminderRepo repositories.ReminderRepository
+}
+
+// NewSnoozeReminderExecutor returns a new instance of SnoozeReminderExecutor.
+func NewSnoozeReminderExecutor(repo repositories.ReminderRepository) clienthelper.APIExecutor {
+	return &SnoozeReminderExecutor{
+		ReminderRepo: repo,
+	}
+}
+
+// Controller executes the business logic for snoozing a reminder and returns the snoozed reminder
+// and any errors that occur during execution.
+func (e *SnoozeReminderExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if !e.ReminderAction.Until.After(time.Now()) {
+		return nil, errors.New("until must be in the future")
+	}
+
+	rem, err := requireReminderOwner(ctx, e.ReminderRepo, e.ReminderAction.ID, e.ReminderAction.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if rem.Dismissed {
+		return nil, errors.New("reminder has been dismissed")
+	}
+
+	err = e.ReminderRepo.Snooze(requestContext(ctx), rem.ID, e.ReminderAction.Until)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.ReminderRepo.Get(requestContext(ctx), rem.ID)
+}
+
+// DismissReminderExecutor defines an APIExecutor for dismissing the current occurrence of a reminder, e.g.
+// after the follow-up has happened. Recurring reminders move on to their next occurrence, one-off reminders
+// stop.
+type DismissReminderExecutor struct {
+	ReminderAction
+	clienthelper.BaseAPIExecutor
+	ReminderRepo repositories.ReminderRepository
+}
+
+// NewDismissReminderExecutor returns a new instance of DismissReminderExecutor.
+func NewDismissReminderExecutor(repo repositories.ReminderRepository) clienthelper.APIExecutor {
+	return &DismissReminderExecutor{
+		ReminderRepo: repo,
+	}
+}
+
+// Controller executes the business logic for dismissing a reminder and returns the reminder
+// and any errors that occur during execution.
+func (e *DismissReminderExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	rem, err := requireReminderOwner(ctx, e.ReminderRepo, e.ReminderAction.ID, e.ReminderAction.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if rem.Dismissed {
+		return rem, nil
+	}
+
+	after := time.Now()
+	if rem.NextDate.After(after) {
+		after = rem.NextDate
+	}
+
+	err = reminders.Advance(requestContext(ctx), e.ReminderRepo, rem, after)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.ReminderRepo.Get(requestContext(ctx), rem.ID)
+}
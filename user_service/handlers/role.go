@@ -26,6 +26,14 @@ func createRoleModel(r *Role) *repositories.Role {
 	}
 }
 
+// roleDocument maps a Role model to the Role representation that patches apply to.
+func roleDocument(r *repositories.Role) *Role {
+	return &Role{
+		ID:   r.ID,
+		Name: r.Name,
+	}
+}
+
 // ParseRequest parses the HTTP request and extracts any relevant data into the Role object.
 func (r *Role) ParseRequest(ctx context.IContext, w http.ResponseWriter, req *http.Request) error {
 	if req.Method == http.MethodPost {
@@ -78,7 +86,7 @@ func NewCreateRoleExecutor(repo repositories.RoleRepository) clienthelper.APIExe
 // and any errors that occur during execution.
 func (e *CreateRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	role := createRoleModel(&e.Role)
-	err := e.RoleRepo.Create(role)
+	err := e.RoleRepo.Create(requestContext(ctx), role)
 	if err != nil {
 		return nil, err
 	}
@@ -86,9 +94,11 @@ func (e *CreateRoleExecutor) Controller(ctx context.IContext) (interface{}, erro
 	return role, nil
 }
 
-// DeleteRoleExecutor defines an APIExecutor for deleting a role by ID.
+// DeleteRoleExecutor defines an APIExecutor for moving a role to the trash by ID.
+// Like updates, it requires the ETag of the role in the If-Match header.
 type DeleteRoleExecutor struct {
-	Role
+	TrashRequest
+	Precondition
 	clienthelper.BaseAPIExecutor
 	RoleRepo repositories.RoleRepository
 }
@@ -100,10 +110,35 @@ func NewDeleteRoleExecutor(repo repositories.RoleRepository) clienthelper.APIExe
 	}
 }
 
+// ParseRequest parses the id query parameter, the authenticated user and the If-Match header into the DeleteRoleExecutor object.
+func (e *DeleteRoleExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.TrashRequest.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest checks that the request names the version of the role it deletes.
+func (e *DeleteRoleExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.TrashRequest.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.Precondition.ValidateRequest(ctx)
+}
+
 // Controller executes the business logic for deleting a role by ID and returns any errors that occur during execution.
 func (e *DeleteRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
-	id := e.Role.ID
-	err := e.RoleRepo.Delete(id)
+	err := e.RoleRepo.Delete(requestContext(ctx), e.TrashRequest.ID, e.Precondition.IfMatch, e.TrashRequest.UserID)
+	if errors.Is(err, repositories.ErrVersionConflict) {
+		current, err := e.RoleRepo.Get(requestContext(ctx), e.TrashRequest.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, e.Precondition.failed(current, current.Version)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -112,8 +147,11 @@ func (e *DeleteRoleExecutor) Controller(ctx context.IContext) (interface{}, erro
 }
 
 // UpdateRoleExecutor defines an APIExecutor for updating a role by ID.
+// The request sends the ETag of the role in the If-Match header; a stale one is answered with
+// 412 Precondition Failed and the current role.
 type UpdateRoleExecutor struct {
 	Role
+	Precondition
 	clienthelper.BaseAPIExecutor
 	RoleRepo repositories.RoleRepository
 }
@@ -125,21 +163,139 @@ func NewUpdateRoleExecutor(repo repositories.RoleRepository) clienthelper.APIExe
 	}
 }
 
+// ParseRequest parses the role and the If-Match header into the UpdateRoleExecutor object.
+func (e *UpdateRoleExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.Role.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the role and checks that the request names the version it updates.
+func (e *UpdateRoleExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.Role.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.Precondition.ValidateRequest(ctx)
+}
+
 // Controller executes the business logic for updating a role by ID and returns the updated role
 // and any errors that occur during execution.
 func (e *UpdateRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	role := createRoleModel(&e.Role)
-	err := e.RoleRepo.Update(role)
+	role.Version = e.Precondition.IfMatch
+	err := e.RoleRepo.Update(requestContext(ctx), role)
+	if errors.Is(err, repositories.ErrVersionConflict) {
+		current, err := e.RoleRepo.Get(requestContext(ctx), role.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, e.Precondition.failed(current, current.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e.Precondition.setETag(role.Version)
+
+	return role, nil
+}
+
+// PatchRoleExecutor defines an APIExecutor for partially updating a role by ID with a JSON merge patch or
+// a JSON Patch. Only the fields the patch changes are written. Like updates, it requires the ETag of the role
+// in the If-Match header.
+type PatchRoleExecutor struct {
+	Role
+	PatchRequest
+	Precondition
+	clienthelper.BaseAPIExecutor
+	RoleRepo repositories.RoleRepository
+}
+
+// NewPatchRoleExecutor returns a new instance of PatchRoleExecutor.
+func NewPatchRoleExecutor(repo repositories.RoleRepository) clienthelper.APIExecutor {
+	return &PatchRoleExecutor{
+		RoleRepo: repo,
+	}
+}
+
+// ParseRequest parses the id query parameter, the patch and the If-Match header into the PatchRoleExecutor object.
+func (e *PatchRoleExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.Role.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	if err := e.PatchRequest.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest checks the patch and that the request names the version of the role it patches. The
+// patched role is validated once the patch is applied.
+func (e *PatchRoleExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.PatchRequest.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.Precondition.ValidateRequest(ctx)
+}
+
+// Controller executes the business logic for patching a role by ID and returns the patched role
+// and any errors that occur during execution.
+func (e *PatchRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	current, err := e.RoleRepo.Get(requestContext(ctx), e.Role.ID)
 	if err != nil {
 		return nil, err
 	}
 
+	if current.Version != e.Precondition.IfMatch {
+		return nil, e.Precondition.failed(current, current.Version)
+	}
+
+	patched := &Role{}
+	if err := e.PatchRequest.apply(roleDocument(current), patched); err != nil {
+		return nil, err
+	}
+
+	if patched.ID != current.ID {
+		return nil, errors.New("id cannot be patched")
+	}
+
+	if err := patched.ValidateRequest(ctx); err != nil {
+		return nil, err
+	}
+
+	role := createRoleModel(patched)
+	role.Version = current.Version
+	if fields := repositories.ChangedRoleFields(current, role); len(fields) > 0 {
+		err := e.RoleRepo.Patch(requestContext(ctx), role, fields)
+		if errors.Is(err, repositories.ErrVersionConflict) {
+			current, err := e.RoleRepo.Get(requestContext(ctx), role.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, e.Precondition.failed(current, current.Version)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e.Precondition.setETag(role.Version)
+
 	return role, nil
 }
 
 // GetRoleExecutor defines an APIExecutor for getting a role by ID.
 type GetRoleExecutor struct {
 	Role
+	Precondition
 	clienthelper.BaseAPIExecutor
 	RoleRepo repositories.RoleRepository
 }
@@ -151,22 +307,41 @@ func NewGetRoleExecutor(repo repositories.RoleRepository) clienthelper.APIExecut
 	}
 }
 
+// ParseRequest parses the id query parameter into the GetRoleExecutor object, and keeps the response writer
+// to set the ETag header on.
+func (e *GetRoleExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.Role.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the data in the GetRoleExecutor object. Reads do not need an If-Match header.
+func (e *GetRoleExecutor) ValidateRequest(ctx context.IContext) error {
+	return e.Role.ValidateRequest(ctx)
+}
+
 // Controller executes the business logic for getting a role by ID and returns the role
 // and any errors that occur during execution.
 func (e *GetRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	id := e.Role.ID
-	role, err := e.RoleRepo.Get(id)
+	role, err := e.RoleRepo.Get(requestContext(ctx), id)
 	if err != nil {
 		return nil, err
 	}
 
+	e.Precondition.setETag(role.Version)
+
 	return role, nil
 }
 
-// GetAllRolesExecutor defines an APIExecutor for getting all roles.
+// GetAllRolesExecutor defines an APIExecutor for getting a page of the roles.
 type GetAllRolesExecutor struct {
+	PageQuery
 	clienthelper.BaseAPIExecutor
 	RoleRepo repositories.RoleRepository
+	Filter   repositories.RoleFilter
 }
 
 // NewGetAllRolesExecutor returns a new instance of GetAllRolesExecutor.
@@ -176,8 +351,25 @@ func NewGetAllRolesExecutor(repo repositories.RoleRepository) clienthelper.APIEx
 	}
 }
 
-// Controller executes the business logic for getting all roles and returns the roles
+// ParseRequest parses the name_prefix filter and the page of the request into the GetAllRolesExecutor object.
+func (e *GetAllRolesExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	e.Filter = repositories.RoleFilter{NamePrefix: r.URL.Query().Get("name_prefix")}
+
+	return e.PageQuery.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest checks that the roles can be sorted as requested.
+func (e *GetAllRolesExecutor) ValidateRequest(ctx context.IContext) error {
+	return e.PageQuery.Page.Validate(repositories.RoleSortFields...)
+}
+
+// Controller executes the business logic for getting a page of the roles and returns the page
 // and any errors that occur during execution.
 func (e *GetAllRolesExecutor) Controller(ctx context.IContext) (interface{}, error) {
-	return e.RoleRepo.GetAll()
+	roles, info, err := e.RoleRepo.GetPage(requestContext(ctx), e.Filter, e.PageQuery.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	return newListResponse(roles, info), nil
 }
@@ -0,0 +1,164 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/contact_manager/search"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// maxSearchLimit caps the number of hits returned by a single search request.
+const maxSearchLimit = 100
+
+// SearchQuery defines a struct for contact search requests.
+type SearchQuery struct {
+	UserID int
+	Text   string
+	Fuzzy  bool
+	Facets map[string][]string
+	Limit  int
+	Offset int
+}
+
+// ParseRequest parses the query parameters of the HTTP request into the SearchQuery object.
+// q holds the search text, fuzzy=true enables typo tolerance and tag, group and company
+// restrict the result to the given facet values.
+func (s *SearchQuery) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+	s.UserID = userID
+
+	s.Text = values.Get("q")
+	s.Fuzzy = values.Get("fuzzy") == "true"
+
+	s.Facets = map[string][]string{}
+	for _, facet := range []string{search.FacetTag, search.FacetGroup, search.FacetCompany} {
+		if v := splitQueryValues(values[facet]); len(v) > 0 {
+			s.Facets[facet] = v
+		}
+	}
+
+	s.Limit = 20
+	if limit := values.Get("limit"); limit != "" {
+		if s.Limit, err = strconv.Atoi(limit); err != nil {
+			return errors.New("invalid limit in query")
+		}
+	}
+
+	if offset := values.Get("offset"); offset != "" {
+		if s.Offset, err = strconv.Atoi(offset); err != nil {
+			return errors.New("invalid offset in query")
+		}
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the SearchQuery object and returns any errors that occur during validation.
+func (s *SearchQuery) ValidateRequest(ctx context.IContext) error {
+	if s.Limit <= 0 || s.Limit > maxSearchLimit {
+		return errors.New("limit must be between 1 and 100")
+	}
+
+	if s.Offset < 0 {
+		return errors.New("offset must not be negative")
+	}
+
+	return nil
+}
+
+// SearchHit defines a struct for a single contact found by a search.
+type SearchHit struct {
+	Contact    *repositories.Contact `json:"contact"`
+	Score      float64               `json:"score"`
+	Highlights map[string]string     `json:"highlights"`
+}
+
+// SearchResult defines a struct for the response of a contact search.
+type SearchResult struct {
+	Total  int                       `json:"total"`
+	Hits   []*SearchHit              `json:"hits"`
+	Facets map[string]map[string]int `json:"facets"`
+}
+
+// SearchContactsExecutor defines an APIExecutor for searching contacts.
+type SearchContactsExecutor struct {
+	SearchQuery
+	clienthelper.BaseAPIExecutor
+	Index       search.Index
+	ContactRepo repositories.ContactRepository
+	BookRepo    repositories.AddressBookRepository
+}
+
+// NewSearchContactsExecutor returns a new instance of SearchContactsExecutor.
+func NewSearchContactsExecutor(index search.Index, repo repositories.ContactRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &SearchContactsExecutor{
+		Index:       index,
+		ContactRepo: repo,
+		BookRepo:    books,
+	}
+}
+
+// Controller executes the business logic for searching contacts and returns the ranked hits with
+// highlights and facet counts and any errors that occur during execution.
+func (e *SearchContactsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	books, err := e.BookRepo.GetAllForUser(requestContext(ctx), e.SearchQuery.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	bookIDs := make([]int, 0, len(books))
+	for _, b := range books {
+		bookIDs = append(bookIDs, b.ID)
+	}
+
+	result, err := e.Index.Search(&search.Query{
+		UserID:         e.SearchQuery.UserID,
+		AddressBookIDs: bookIDs,
+		Text:           e.SearchQuery.Text,
+		Fuzzy:          e.SearchQuery.Fuzzy,
+		Facets:         e.SearchQuery.Facets,
+		Limit:          e.SearchQuery.Limit,
+		Offset:         e.SearchQuery.Offset,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := &SearchResult{Total: result.Total, Hits: []*SearchHit{}, Facets: result.Facets}
+	if len(result.Hits) == 0 {
+		return response, nil
+	}
+
+	ids := make([]int, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		ids = append(ids, hit.ContactID)
+	}
+
+	repo := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, e.SearchQuery.UserID)
+	contacts, err := repo.GetAll(requestContext(ctx), &repositories.ContactFilter{ContactIDs: ids})
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[int]*repositories.Contact{}
+	for _, c := range contacts {
+		byID[c.ID] = c
+	}
+
+	for _, hit := range result.Hits {
+		if c, ok := byID[hit.ContactID]; ok {
+			response.Hits = append(response.Hits, &SearchHit{Contact: c, Score: hit.Score, Highlights: hit.Highlights})
+		}
+	}
+
+	return response, nil
+}
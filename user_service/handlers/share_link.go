@@ -0,0 +1,334 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/princeparmar/contact_manager/carddav"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/contact_manager/sharing"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// Lifetime of share links in hours: the default and the longest allowed.
+const (
+	defaultShareLinkHours = 7 * 24
+	maxShareLinkHours     = 30 * 24
+)
+
+// ShareLink defines a struct for creating a share link to a contact. expires_in_hours defaults to
+// a week; max_views of zero means unlimited and an empty password leaves the link unprotected.
+type ShareLink struct {
+	UserID         int    `json:"user_id"`
+	ContactID      int    `json:"contact_id"`
+	Password       string `json:"password"`
+	ExpiresInHours int    `json:"expires_in_hours"`
+	MaxViews       int    `json:"max_views"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the ShareLink object.
+func (l *ShareLink) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the ShareLink object
+	err = json.Unmarshal(body, l)
+	if err != nil {
+		return err
+	}
+
+	// The acting user is the authenticated user, whatever the body says
+	l.UserID, err = actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	if l.ExpiresInHours == 0 {
+		l.ExpiresInHours = defaultShareLinkHours
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the ShareLink object and returns any errors that occur during validation.
+func (l *ShareLink) ValidateRequest(ctx context.IContext) error {
+	if l.UserID == 0 {
+		return ErrUnauthenticated
+	}
+
+	if l.ContactID == 0 {
+		return errors.New("contact_id is required")
+	}
+
+	if l.ExpiresInHours < 1 || l.ExpiresInHours > maxShareLinkHours {
+		return errors.New("expires_in_hours must be between 1 and " + strconv.Itoa(maxShareLinkHours))
+	}
+
+	if l.MaxViews < 0 {
+		return errors.New("max_views must not be negative")
+	}
+
+	return nil
+}
+
+// CreatedShareLink is the response to creating a share link. Token is only returned once; the
+// link is opened by passing it to OpenShareLinkExecutor.
+type CreatedShareLink struct {
+	*repositories.ShareLink
+	Token string
+}
+
+// CreateShareLinkExecutor defines an APIExecutor for sharing a contact with someone without an
+// account. The acting user needs read access to the contact.
+type CreateShareLinkExecutor struct {
+	ShareLink
+	clienthelper.BaseAPIExecutor
+	SharingService *sharing.Service
+}
+
+// NewCreateShareLinkExecutor returns a new instance of CreateShareLinkExecutor.
+func NewCreateShareLinkExecutor(service *sharing.Service) clienthelper.APIExecutor {
+	return &CreateShareLinkExecutor{
+		SharingService: service,
+	}
+}
+
+// Controller executes the business logic for creating a share link and returns the link with its token
+// and any errors that occur during execution.
+func (e *CreateShareLinkExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	l := &e.ShareLink
+	s := e.SharingService
+	_, err := requireContactPermission(ctx, s.Contacts, s.Books, l.ContactID, l.UserID, repositories.PermissionViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	link := &repositories.ShareLink{
+		ContactID:   l.ContactID,
+		UserID:      l.UserID,
+		ExpiresDate: time.Now().Add(time.Duration(l.ExpiresInHours) * time.Hour),
+		MaxViews:    l.MaxViews,
+	}
+
+	token, err := s.Create(requestContext(ctx), link, l.Password)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CreatedShareLink{ShareLink: link, Token: token}, nil
+}
+
+// ShareLinkRequest defines a struct for requests on a single share link by the user who created it.
+type ShareLinkRequest struct {
+	ID     int
+	UserID int
+}
+
+// ParseRequest parses the id query parameter and the authenticated user into the ShareLinkRequest object.
+func (l *ShareLinkRequest) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	id, err := strconv.Atoi(values.Get("id"))
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+	l.ID = id
+
+	userID, err := actingUser(r)
+	if err != nil {
+		return err
+	}
+	l.UserID = userID
+
+	return nil
+}
+
+// ValidateRequest validates the data in the ShareLinkRequest object and returns any errors that occur during validation.
+func (l *ShareLinkRequest) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// getOwnShareLink returns a share link, or ErrPermissionDenied unless userID created it.
+func getOwnShareLink(ctx context.IContext, repo repositories.ShareLinkRepository, id, userID int) (*repositories.ShareLink, error) {
+	link, err := repo.Get(requestContext(ctx), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if link.UserID != userID {
+		return nil, repositories.ErrPermissionDenied
+	}
+
+	return link, nil
+}
+
+// RevokeShareLinkExecutor defines an APIExecutor for revoking a share link. Only its creator can revoke it.
+type RevokeShareLinkExecutor struct {
+	ShareLinkRequest
+	clienthelper.BaseAPIExecutor
+	ShareLinkRepo repositories.ShareLinkRepository
+}
+
+// NewRevokeShareLinkExecutor returns a new instance of RevokeShareLinkExecutor.
+func NewRevokeShareLinkExecutor(repo repositories.ShareLinkRepository) clienthelper.APIExecutor {
+	return &RevokeShareLinkExecutor{
+		ShareLinkRepo: repo,
+	}
+}
+
+// Controller executes the business logic for revoking a share link and returns any errors that occur during execution.
+func (e *RevokeShareLinkExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	link, err := getOwnShareLink(ctx, e.ShareLinkRepo, e.ShareLinkRequest.ID, e.ShareLinkRequest.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.ShareLinkRepo.Revoke(requestContext(ctx), link.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// GetShareLinkAccessLogExecutor defines an APIExecutor for the access log of a share link. Only its
+// creator can read it.
+type GetShareLinkAccessLogExecutor struct {
+	ShareLinkRequest
+	clienthelper.BaseAPIExecutor
+	ShareLinkRepo repositories.ShareLinkRepository
+}
+
+// NewGetShareLinkAccessLogExecutor returns a new instance of GetShareLinkAccessLogExecutor.
+func NewGetShareLinkAccessLogExecutor(repo repositories.ShareLinkRepository) clienthelper.APIExecutor {
+	return &GetShareLinkAccessLogExecutor{
+		ShareLinkRepo: repo,
+	}
+}
+
+// Controller executes the business logic for reading the access log of a share link and returns the entries
+// and any errors that occur during execution.
+func (e *GetShareLinkAccessLogExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	link, err := getOwnShareLink(ctx, e.ShareLinkRepo, e.ShareLinkRequest.ID, e.ShareLinkRequest.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.ShareLinkRepo.GetAccessLog(requestContext(ctx), link.ID)
+}
+
+// GetContactShareLinksExecutor defines an APIExecutor for listing the share links of a contact. Tokens
+// are not listed.
+type GetContactShareLinksExecutor struct {
+	ContactItemsQuery
+	clienthelper.BaseAPIExecutor
+	SharingService *sharing.Service
+}
+
+// NewGetContactShareLinksExecutor returns a new instance of GetContactShareLinksExecutor.
+func NewGetContactShareLinksExecutor(service *sharing.Service) clienthelper.APIExecutor {
+	return &GetContactShareLinksExecutor{
+		SharingService: service,
+	}
+}
+
+// Controller executes the business logic for listing the share links of a contact and returns the links
+// and any errors that occur during execution.
+func (e *GetContactShareLinksExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	q := &e.ContactItemsQuery
+	s := e.SharingService
+	_, err := requireContactPermission(ctx, s.Contacts, s.Books, q.ContactID, q.UserID, repositories.PermissionViewer)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.Links.GetAll(requestContext(ctx), q.ContactID)
+}
+
+// OpenShareLink defines a struct for opening a share link. It is the only request made without a
+// user: token and format (vcard or json) are query parameters, the password is sent in the
+// X-Share-Password header so that it does not end up in access logs.
+type OpenShareLink struct {
+	Token    string
+	Format   string
+	Password string
+	Visitor  sharing.Visitor
+
+	writer http.ResponseWriter
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the OpenShareLink object.
+func (o *OpenShareLink) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	o.Token = values.Get("token")
+	o.Format = values.Get("format")
+	if o.Format == "" {
+		o.Format = "vcard"
+	}
+	o.Password = r.Header.Get("X-Share-Password")
+	o.Visitor = sharing.Visitor{RemoteAddr: r.RemoteAddr, UserAgent: r.UserAgent()}
+	o.writer = w
+
+	return nil
+}
+
+// ValidateRequest validates the data in the OpenShareLink object and returns any errors that occur during validation.
+func (o *OpenShareLink) ValidateRequest(ctx context.IContext) error {
+	if o.Token == "" {
+		return errors.New("token is required")
+	}
+
+	if o.Format != "vcard" && o.Format != "json" {
+		return errors.New("format must be either vcard or json")
+	}
+
+	return nil
+}
+
+// OpenShareLinkExecutor defines an APIExecutor for opening a share link. The vCard format is written to
+// the response directly as a download; the JSON format is returned as the result.
+type OpenShareLinkExecutor struct {
+	OpenShareLink
+	clienthelper.BaseAPIExecutor
+	SharingService *sharing.Service
+}
+
+// NewOpenShareLinkExecutor returns a new instance of OpenShareLinkExecutor.
+func NewOpenShareLinkExecutor(service *sharing.Service) clienthelper.APIExecutor {
+	return &OpenShareLinkExecutor{
+		SharingService: service,
+	}
+}
+
+// Controller executes the business logic for opening a share link and returns the shared contact and
+// any errors that occur during execution.
+func (e *OpenShareLinkExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	o := &e.OpenShareLink
+	card, err := e.SharingService.Open(requestContext(ctx), o.Token, o.Password, o.Visitor, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Format == "json" {
+		return card, nil
+	}
+
+	w := o.writer
+	w.Header().Set("Content-Type", carddav.VCardContentType)
+	w.Header().Set("Content-Disposition", `attachment; filename="contact.vcf"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(http.StatusOK)
+
+	_, err = w.Write([]byte(card.VCard()))
+	return nil, err
+}
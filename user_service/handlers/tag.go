@@ -0,0 +1,316 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// Tag defines a struct for tag data.
+type Tag struct {
+	ID     int    `json:"id"`
+	UserID int    `json:"user_id"`
+	Name   string `json:"name"`
+}
+
+// createTagModel maps Tag to Tag model.
+func createTagModel(t *Tag) *repositories.Tag {
+	return &repositories.Tag{
+		ID:     t.ID,
+		UserID: t.UserID,
+		Name:   t.Name,
+	}
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the Tag object.
+func (t *Tag) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if r.Method == http.MethodPost {
+		// Read the request body
+		body, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			return err
+		}
+
+		// Unmarshal the request body into the Tag object
+		err = json.Unmarshal(body, t)
+		if err != nil {
+			return err
+		}
+	}
+
+	// Parse ID from the query parameter
+	id := r.URL.Query().Get("id")
+	i, err := strconv.Atoi(id)
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	t.ID = i
+
+	// The owner is the authenticated user, whatever the body says
+	t.UserID, err = actingUser(r)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ValidateRequest validates the data in the Tag object and returns any errors that occur during validation.
+func (t *Tag) ValidateRequest(ctx context.IContext) error {
+	if strings.TrimSpace(t.Name) == "" {
+		return errors.New("tag name is required")
+	}
+	return nil
+}
+
+// requireTagOwner returns ErrPermissionDenied unless the tag with the given ID belongs to the user.
+func requireTagOwner(ctx context.IContext, repo repositories.TagRepository, tagID, userID int) error {
+	tag, err := repo.Get(requestContext(ctx), tagID)
+	if err != nil {
+		return err
+	}
+
+	if tag.UserID != userID {
+		return repositories.ErrPermissionDenied
+	}
+
+	return nil
+}
+
+// UpdateTagExecutor defines an APIExecutor for renaming a tag by ID.
+type UpdateTagExecutor struct {
+	Tag
+	clienthelper.BaseAPIExecutor
+	TagRepo repositories.TagRepository
+}
+
+// NewUpdateTagExecutor returns a new instance of UpdateTagExecutor.
+func NewUpdateTagExecutor(repo repositories.TagRepository) clienthelper.APIExecutor {
+	return &UpdateTagExecutor{
+		TagRepo: repo,
+	}
+}
+
+// Controller executes the business logic for renaming a tag by ID and returns the updated tag
+// and any errors that occur during execution.
+func (e *UpdateTagExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if err := requireTagOwner(ctx, e.TagRepo, e.Tag.ID, e.Tag.UserID); err != nil {
+		return nil, err
+	}
+
+	tag := createTagModel(&e.Tag)
+	err := e.TagRepo.Update(requestContext(ctx), tag)
+	if err != nil {
+		return nil, err
+	}
+
+	return tag, nil
+}
+
+// DeleteTagExecutor defines an APIExecutor for deleting a tag by ID.
+type DeleteTagExecutor struct {
+	Tag
+	clienthelper.BaseAPIExecutor
+	TagRepo repositories.TagRepository
+}
+
+// NewDeleteTagExecutor returns a new instance of DeleteTagExecutor.
+func NewDeleteTagExecutor(repo repositories.TagRepository) clienthelper.APIExecutor {
+	return &DeleteTagExecutor{
+		TagRepo: repo,
+	}
+}
+
+// ValidateRequest checks the id of the tag to delete. Deletes have no body, so the tag itself is not validated.
+func (e *DeleteTagExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.Tag.ID < 1 {
+		return errors.New("invalid id in query")
+	}
+
+	return nil
+}
+
+// Controller executes the business logic for deleting a tag by ID and returns any errors that occur during execution.
+func (e *DeleteTagExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if err := requireTagOwner(ctx, e.TagRepo, e.Tag.ID, e.Tag.UserID); err != nil {
+		return nil, err
+	}
+
+	err := e.TagRepo.Delete(requestContext(ctx), e.Tag.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return nil, nil
+}
+
+// GetAllTagsExecutor defines an APIExecutor for getting a page of the tags of a user with their usage counts.
+type GetAllTagsExecutor struct {
+	OwnerQuery
+	PageQuery
+	clienthelper.BaseAPIExecutor
+	TagRepo repositories.TagRepository
+}
+
+// NewGetAllTagsExecutor returns a new instance of GetAllTagsExecutor.
+func NewGetAllTagsExecutor(repo repositories.TagRepository) clienthelper.APIExecutor {
+	return &GetAllTagsExecutor{
+		TagRepo: repo,
+	}
+}
+
+// ParseRequest parses the authenticated user and the page of the request into the GetAllTagsExecutor object.
+func (e *GetAllTagsExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.OwnerQuery.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.PageQuery.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the OwnerQuery and checks that the tags can be sorted as requested.
+func (e *GetAllTagsExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.OwnerQuery.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.PageQuery.Page.Validate(repositories.TagSortFields...)
+}
+
+// Controller executes the business logic for getting a page of the tags of a user and returns the page
+// and any errors that occur during execution.
+func (e *GetAllTagsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	tags, err := e.TagRepo.GetAll(requestContext(ctx), e.OwnerQuery.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, info := repositories.PageSlice(len(tags), func(i int) repositories.SortKey { return tags[i].SortKey(e.PageQuery.Page.Sort) }, e.PageQuery.Page)
+	page := make([]*repositories.Tag, 0, len(indexes))
+	for _, i := range indexes {
+		page = append(page, tags[i])
+	}
+
+	return newListResponse(page, info), nil
+}
+
+// TagContacts defines a struct for tagging and untagging contacts in bulk.
+type TagContacts struct {
+	UserID     int    `json:"user_id"`
+	Tag        string `json:"tag"`
+	ContactIDs []int  `json:"contact_ids"`
+}
+
+// ParseRequest parses the HTTP request and extracts any relevant data into the TagContacts object.
+func (t *TagContacts) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	// Read the request body
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	// Unmarshal the request body into the TagContacts object
+	if err := json.Unmarshal(body, t); err != nil {
+		return err
+	}
+
+	// The acting user is the authenticated user, whatever the body says
+	t.UserID, err = actingUser(r)
+	return err
+}
+
+// ValidateRequest validates the data in the TagContacts object and returns any errors that occur during validation.
+func (t *TagContacts) ValidateRequest(ctx context.IContext) error {
+	if t.UserID == 0 {
+		return ErrUnauthenticated
+	}
+
+	if strings.TrimSpace(t.Tag) == "" {
+		return errors.New("tag field is required")
+	}
+
+	if len(t.ContactIDs) == 0 {
+		return errors.New("contact_ids field is required")
+	}
+
+	return nil
+}
+
+// AddTagContactsExecutor defines an APIExecutor for tagging contacts in bulk.
+// The tag is created for the user when it does not exist yet. The user must own the contacts.
+type AddTagContactsExecutor struct {
+	TagContacts
+	clienthelper.BaseAPIExecutor
+	TagRepo     repositories.TagRepository
+	ContactRepo repositories.ContactRepository
+}
+
+// NewAddTagContactsExecutor returns a new instance of AddTagContactsExecutor.
+func NewAddTagContactsExecutor(repo repositories.TagRepository, contacts repositories.ContactRepository) clienthelper.APIExecutor {
+	return &AddTagContactsExecutor{
+		TagRepo:     repo,
+		ContactRepo: contacts,
+	}
+}
+
+// Controller executes the business logic for tagging contacts and returns the tag
+// and any errors that occur during execution.
+func (e *AddTagContactsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if err := requireContactsOwner(ctx, e.ContactRepo, e.TagContacts.ContactIDs, e.TagContacts.UserID); err != nil {
+		return nil, err
+	}
+
+	tag, err := e.TagRepo.GetByName(requestContext(ctx), e.TagContacts.UserID, e.TagContacts.Tag)
+	if errors.Is(err, repositories.ErrTagNotFound) {
+		tag = &repositories.Tag{UserID: e.TagContacts.UserID, Name: e.TagContacts.Tag}
+		err = e.TagRepo.Create(requestContext(ctx), tag)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.TagRepo.AddContacts(requestContext(ctx), tag.ID, e.TagContacts.ContactIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.TagRepo.Get(requestContext(ctx), tag.ID)
+}
+
+// RemoveTagContactsExecutor defines an APIExecutor for untagging contacts in bulk.
+type RemoveTagContactsExecutor struct {
+	TagContacts
+	clienthelper.BaseAPIExecutor
+	TagRepo repositories.TagRepository
+}
+
+// NewRemoveTagContactsExecutor returns a new instance of RemoveTagContactsExecutor.
+func NewRemoveTagContactsExecutor(repo repositories.TagRepository) clienthelper.APIExecutor {
+	return &RemoveTagContactsExecutor{
+		TagRepo: repo,
+	}
+}
+
+// Controller executes the business logic for untagging contacts and returns the tag
+// and any errors that occur during execution.
+func (e *RemoveTagContactsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	tag, err := e.TagRepo.GetByName(requestContext(ctx), e.TagContacts.UserID, e.TagContacts.Tag)
+	if err != nil {
+		return nil, err
+	}
+
+	err = e.TagRepo.RemoveContacts(requestContext(ctx), tag.ID, e.TagContacts.ContactIDs)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.TagRepo.Get(requestContext(ctx), tag.ID)
+}
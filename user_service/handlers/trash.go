@@ -0,0 +1,233 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// TrashRequest defines a struct for moving a record to the trash or restoring it from there.
+type TrashRequest struct {
+	ID     int
+	UserID int
+}
+
+// ParseRequest parses the id query parameter and the authenticated user into the TrashRequest object. The
+// acting user is optional for users, roles and accesses.
+func (t *TrashRequest) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	// Parse ID from the query parameter
+	i, err := strconv.Atoi(values.Get("id"))
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+
+	t.ID = i
+
+	// The acting user is the authenticated user, if any
+	t.UserID, _ = UserIDFromContext(r.Context())
+
+	return nil
+}
+
+// ValidateRequest validates the data in the TrashRequest object and returns any errors that occur during validation.
+func (t *TrashRequest) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// GetDeletedUsersExecutor defines an APIExecutor for listing the users in the trash.
+type GetDeletedUsersExecutor struct {
+	clienthelper.BaseAPIExecutor
+	UserRepo repositories.UserRepository
+}
+
+// NewGetDeletedUsersExecutor returns a new instance of GetDeletedUsersExecutor.
+func NewGetDeletedUsersExecutor(repo repositories.UserRepository) clienthelper.APIExecutor {
+	return &GetDeletedUsersExecutor{
+		UserRepo: repo,
+	}
+}
+
+// Controller executes the business logic for listing the deleted users and returns the users
+// and any errors that occur during execution.
+func (e *GetDeletedUsersExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.UserRepo.GetDeleted(requestContext(ctx))
+}
+
+// RestoreUserExecutor defines an APIExecutor for restoring a user from the trash by ID.
+type RestoreUserExecutor struct {
+	TrashRequest
+	clienthelper.BaseAPIExecutor
+	UserRepo repositories.UserRepository
+}
+
+// NewRestoreUserExecutor returns a new instance of RestoreUserExecutor.
+func NewRestoreUserExecutor(repo repositories.UserRepository) clienthelper.APIExecutor {
+	return &RestoreUserExecutor{
+		UserRepo: repo,
+	}
+}
+
+// Controller executes the business logic for restoring a user and returns the restored user
+// and any errors that occur during execution.
+func (e *RestoreUserExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	err := e.UserRepo.Restore(requestContext(ctx), e.TrashRequest.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.UserRepo.Get(requestContext(ctx), e.TrashRequest.ID)
+}
+
+// GetDeletedRolesExecutor defines an APIExecutor for listing the roles in the trash.
+type GetDeletedRolesExecutor struct {
+	clienthelper.BaseAPIExecutor
+	RoleRepo repositories.RoleRepository
+}
+
+// NewGetDeletedRolesExecutor returns a new instance of GetDeletedRolesExecutor.
+func NewGetDeletedRolesExecutor(repo repositories.RoleRepository) clienthelper.APIExecutor {
+	return &GetDeletedRolesExecutor{
+		RoleRepo: repo,
+	}
+}
+
+// Controller executes the business logic for listing the deleted roles and returns the roles
+// and any errors that occur during execution.
+func (e *GetDeletedRolesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.RoleRepo.GetDeleted(requestContext(ctx))
+}
+
+// RestoreRoleExecutor defines an APIExecutor for restoring a role from the trash by ID.
+type RestoreRoleExecutor struct {
+	TrashRequest
+	clienthelper.BaseAPIExecutor
+	RoleRepo repositories.RoleRepository
+}
+
+// NewRestoreRoleExecutor returns a new instance of RestoreRoleExecutor.
+func NewRestoreRoleExecutor(repo repositories.RoleRepository) clienthelper.APIExecutor {
+	return &RestoreRoleExecutor{
+		RoleRepo: repo,
+	}
+}
+
+// Controller executes the business logic for restoring a role and returns the restored role
+// and any errors that occur during execution.
+func (e *RestoreRoleExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	err := e.RoleRepo.Restore(requestContext(ctx), e.TrashRequest.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.RoleRepo.Get(requestContext(ctx), e.TrashRequest.ID)
+}
+
+// GetDeletedAccessesExecutor defines an APIExecutor for listing the access modes in the trash.
+type GetDeletedAccessesExecutor struct {
+	clienthelper.BaseAPIExecutor
+	AccessRepo repositories.AccessRepository
+}
+
+// NewGetDeletedAccessesExecutor returns a new instance of GetDeletedAccessesExecutor.
+func NewGetDeletedAccessesExecutor(repo repositories.AccessRepository) clienthelper.APIExecutor {
+	return &GetDeletedAccessesExecutor{
+		AccessRepo: repo,
+	}
+}
+
+// Controller executes the business logic for listing the deleted access modes and returns the access modes
+// and any errors that occur during execution.
+func (e *GetDeletedAccessesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.AccessRepo.GetDeleted(requestContext(ctx))
+}
+
+// RestoreAccessExecutor defines an APIExecutor for restoring an access mode from the trash by ID.
+type RestoreAccessExecutor struct {
+	TrashRequest
+	clienthelper.BaseAPIExecutor
+	AccessRepo repositories.AccessRepository
+}
+
+// NewRestoreAccessExecutor returns a new instance of RestoreAccessExecutor.
+func NewRestoreAccessExecutor(repo repositories.AccessRepository) clienthelper.APIExecutor {
+	return &RestoreAccessExecutor{
+		AccessRepo: repo,
+	}
+}
+
+// Controller executes the business logic for restoring an access mode and returns the restored access mode
+// and any errors that occur during execution.
+func (e *RestoreAccessExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	err := e.AccessRepo.Restore(requestContext(ctx), e.TrashRequest.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return e.AccessRepo.Get(requestContext(ctx), e.TrashRequest.ID)
+}
+
+// GetDeletedContactsExecutor defines an APIExecutor for listing the contacts in the trash that a user
+// can read, matching the address book, group, tag and address filters.
+type GetDeletedContactsExecutor struct {
+	ContactQuery
+	clienthelper.BaseAPIExecutor
+	ContactRepo repositories.ContactRepository
+	BookRepo    repositories.AddressBookRepository
+}
+
+// NewGetDeletedContactsExecutor returns a new instance of GetDeletedContactsExecutor.
+func NewGetDeletedContactsExecutor(repo repositories.ContactRepository, books repositories.AddressBookRepository) clienthelper.APIExecutor {
+	return &GetDeletedContactsExecutor{
+		ContactRepo: repo,
+		BookRepo:    books,
+	}
+}
+
+// Controller executes the business logic for listing the deleted contacts and returns the contacts
+// and any errors that occur during execution.
+func (e *GetDeletedContactsExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	repo := repositories.NewAuthorizedContactRepository(e.ContactRepo, e.BookRepo, e.ContactQuery.UserID)
+	return repo.GetDeleted(requestContext(ctx), e.ContactQuery.filter())
+}
+
+// RestoreDeletedContactExecutor defines an APIExecutor for restoring a contact from the trash by ID.
+// The restore is recorded in the contact history.
+type RestoreDeletedContactExecutor struct {
+	TrashRequest
+	clienthelper.BaseAPIExecutor
+	ContactRepo repositories.ContactStore
+}
+
+// NewRestoreDeletedContactExecutor returns a new instance of RestoreDeletedContactExecutor.
+func NewRestoreDeletedContactExecutor(repo repositories.ContactStore) clienthelper.APIExecutor {
+	return &RestoreDeletedContactExecutor{
+		ContactRepo: repo,
+	}
+}
+
+// ValidateRequest validates the data in the RestoreDeletedContactExecutor object. Contacts are always
+// restored on behalf of a user.
+func (e *RestoreDeletedContactExecutor) ValidateRequest(ctx context.IContext) error {
+	if e.TrashRequest.UserID == 0 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// Controller executes the business logic for restoring a contact and returns the restored contact
+// and any errors that occur during execution.
+func (e *RestoreDeletedContactExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	repo := e.ContactRepo.As(e.TrashRequest.UserID)
+	err := repo.Restore(requestContext(ctx), e.TrashRequest.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return repo.Get(requestContext(ctx), e.TrashRequest.ID)
+}
@@ -6,6 +6,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/dgrijalva/jwt-go"
@@ -33,6 +34,16 @@ func createUserModel(u *User) *repositories.User {
 	}
 }
 
+// userDocument maps a User model to the User representation that patches apply to.
+func userDocument(u *repositories.User) *User {
+	return &User{
+		ID:     u.ID,
+		Name:   u.UserName,
+		Email:  u.EmailID,
+		Mobile: u.Mobile,
+	}
+}
+
 // ParseRequest parses the HTTP request and extracts any relevant data into the User object.
 func (u *User) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
 
@@ -79,25 +90,41 @@ func (u *User) ValidateRequest(ctx context.IContext) error {
 	return nil
 }
 
-// CreateUserExecutor defines an APIExecutor for creating a new user.
+// CreateUserExecutor defines an APIExecutor for creating a new user with the default roles.
 type CreateUserExecutor struct {
 	User
 	clienthelper.BaseAPIExecutor
-	UserRepo repositories.UserRepository
+	Work           repositories.UnitOfWork
+	DefaultRoleIDs []int
 }
 
-// NewCreateUserExecutor returns a new instance of CreateUserExecutor.
-func NewCreateUserExecutor(repo repositories.UserRepository) clienthelper.APIExecutor {
+// NewCreateUserExecutor returns a new instance of CreateUserExecutor. New users are given the
+// roles with the given IDs, without an expiry date.
+func NewCreateUserExecutor(work repositories.UnitOfWork, defaultRoleIDs []int) clienthelper.APIExecutor {
 	return &CreateUserExecutor{
-		UserRepo: repo,
+		Work:           work,
+		DefaultRoleIDs: defaultRoleIDs,
 	}
 }
 
 // Controller executes the business logic for creating a new user and returns the created user
-// and any errors that occur during execution.
+// and any errors that occur during execution. The user and its roles are stored in one unit of
+// work, so a user is never left without its default roles.
 func (e *CreateUserExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	user := createUserModel(&e.User)
-	err := e.UserRepo.Create(user)
+	err := e.Work.Do(requestContext(ctx), func(repos *repositories.Repositories) error {
+		if err := repos.Users.Create(requestContext(ctx), user); err != nil {
+			return err
+		}
+
+		for _, roleID := range e.DefaultRoleIDs {
+			if err := repos.UserRoles.Create(requestContext(ctx), &repositories.UserRole{UserID: user.ID, RoleID: roleID}); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -105,9 +132,11 @@ func (e *CreateUserExecutor) Controller(ctx context.IContext) (interface{}, erro
 	return user, nil
 }
 
-// DeleteUserExecutor defines an APIExecutor for deleting a user by ID.
+// DeleteUserExecutor defines an APIExecutor for moving a user to the trash by ID.
+// Like updates, it requires the ETag of the user in the If-Match header.
 type DeleteUserExecutor struct {
-	User
+	TrashRequest
+	Precondition
 	clienthelper.BaseAPIExecutor
 	UserRepo repositories.UserRepository
 }
@@ -119,10 +148,35 @@ func NewDeleteUserExecutor(repo repositories.UserRepository) clienthelper.APIExe
 	}
 }
 
+// ParseRequest parses the id query parameter, the authenticated user and the If-Match header into the DeleteUserExecutor object.
+func (e *DeleteUserExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.TrashRequest.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest checks that the request names the version of the user it deletes.
+func (e *DeleteUserExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.TrashRequest.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.Precondition.ValidateRequest(ctx)
+}
+
 // Controller executes the business logic for deleting a user by ID and returns any errors that occur during execution.
 func (e *DeleteUserExecutor) Controller(ctx context.IContext) (interface{}, error) {
-	id := e.User.ID
-	err := e.UserRepo.Delete(id)
+	err := e.UserRepo.Delete(requestContext(ctx), e.TrashRequest.ID, e.Precondition.IfMatch, e.TrashRequest.UserID)
+	if errors.Is(err, repositories.ErrVersionConflict) {
+		current, err := e.UserRepo.Get(requestContext(ctx), e.TrashRequest.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, e.Precondition.failed(current, current.Version)
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -131,8 +185,11 @@ func (e *DeleteUserExecutor) Controller(ctx context.IContext) (interface{}, erro
 }
 
 // UpdateUserExecutor defines an APIExecutor for updating a user by ID.
+// The request sends the ETag of the user in the If-Match header; a stale one is answered with
+// 412 Precondition Failed and the current user.
 type UpdateUserExecutor struct {
 	User
+	Precondition
 	clienthelper.BaseAPIExecutor
 	UserRepo repositories.UserRepository
 }
@@ -144,21 +201,139 @@ func NewUpdateUserExecutor(repo repositories.UserRepository) clienthelper.APIExe
 	}
 }
 
+// ParseRequest parses the user and the If-Match header into the UpdateUserExecutor object.
+func (e *UpdateUserExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.User.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the user and checks that the request names the version it updates.
+func (e *UpdateUserExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.User.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.Precondition.ValidateRequest(ctx)
+}
+
 // Controller executes the business logic for updating a user by ID and returns the updated user
 // and any errors that occur during execution.
 func (e *UpdateUserExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	user := createUserModel(&e.User)
-	err := e.UserRepo.Update(user)
+	user.Version = e.Precondition.IfMatch
+	err := e.UserRepo.Update(requestContext(ctx), user)
+	if errors.Is(err, repositories.ErrVersionConflict) {
+		current, err := e.UserRepo.Get(requestContext(ctx), user.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		return nil, e.Precondition.failed(current, current.Version)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	e.Precondition.setETag(user.Version)
+
+	return user, nil
+}
+
+// PatchUserExecutor defines an APIExecutor for partially updating a user by ID with a JSON merge patch or
+// a JSON Patch. Only the fields the patch changes are written. Like updates, it requires the ETag of the user
+// in the If-Match header.
+type PatchUserExecutor struct {
+	User
+	PatchRequest
+	Precondition
+	clienthelper.BaseAPIExecutor
+	UserRepo repositories.UserRepository
+}
+
+// NewPatchUserExecutor returns a new instance of PatchUserExecutor.
+func NewPatchUserExecutor(repo repositories.UserRepository) clienthelper.APIExecutor {
+	return &PatchUserExecutor{
+		UserRepo: repo,
+	}
+}
+
+// ParseRequest parses the id query parameter, the patch and the If-Match header into the PatchUserExecutor object.
+func (e *PatchUserExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.User.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	if err := e.PatchRequest.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest checks the patch and that the request names the version of the user it patches. The
+// patched user is validated once the patch is applied.
+func (e *PatchUserExecutor) ValidateRequest(ctx context.IContext) error {
+	if err := e.PatchRequest.ValidateRequest(ctx); err != nil {
+		return err
+	}
+
+	return e.Precondition.ValidateRequest(ctx)
+}
+
+// Controller executes the business logic for patching a user by ID and returns the patched user
+// and any errors that occur during execution.
+func (e *PatchUserExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	current, err := e.UserRepo.Get(requestContext(ctx), e.User.ID)
 	if err != nil {
 		return nil, err
 	}
 
+	if current.Version != e.Precondition.IfMatch {
+		return nil, e.Precondition.failed(current, current.Version)
+	}
+
+	patched := &User{}
+	if err := e.PatchRequest.apply(userDocument(current), patched); err != nil {
+		return nil, err
+	}
+
+	if patched.ID != current.ID {
+		return nil, errors.New("id cannot be patched")
+	}
+
+	if err := patched.ValidateRequest(ctx); err != nil {
+		return nil, err
+	}
+
+	user := createUserModel(patched)
+	user.Version = current.Version
+	if fields := repositories.ChangedUserFields(current, user); len(fields) > 0 {
+		err := e.UserRepo.Patch(requestContext(ctx), user, fields)
+		if errors.Is(err, repositories.ErrVersionConflict) {
+			current, err := e.UserRepo.Get(requestContext(ctx), user.ID)
+			if err != nil {
+				return nil, err
+			}
+
+			return nil, e.Precondition.failed(current, current.Version)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	e.Precondition.setETag(user.Version)
+
 	return user, nil
 }
 
 // GetUserExecutor defines an APIExecutor for getting a user by ID.
 type GetUserExecutor struct {
 	User
+	Precondition
 	clienthelper.BaseAPIExecutor
 	UserRepo repositories.UserRepository
 }
@@ -170,22 +345,41 @@ func NewGetUserExecutor(repo repositories.UserRepository) clienthelper.APIExecut
 	}
 }
 
+// ParseRequest parses the id query parameter into the GetUserExecutor object, and keeps the response writer
+// to set the ETag header on.
+func (e *GetUserExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.User.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	return e.Precondition.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest validates the data in the GetUserExecutor object. Reads do not need an If-Match header.
+func (e *GetUserExecutor) ValidateRequest(ctx context.IContext) error {
+	return e.User.ValidateRequest(ctx)
+}
+
 // Controller executes the business logic for getting a user by ID and returns the user
 // and any errors that occur during execution.
 func (e *GetUserExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	id := e.User.ID
-	user, err := e.UserRepo.Get(id)
+	user, err := e.UserRepo.Get(requestContext(ctx), id)
 	if err != nil {
 		return nil, err
 	}
 
+	e.Precondition.setETag(user.Version)
+
 	return user, nil
 }
 
-// GetAllUsersExecutor defines an APIExecutor for getting all users.
+// GetAllUsersExecutor defines an APIExecutor for getting a page of the users.
 type GetAllUsersExecutor struct {
+	PageQuery
 	clienthelper.BaseAPIExecutor
 	UserRepo repositories.UserRepository
+	Filter   repositories.UserFilter
 }
 
 // NewGetAllUsersExecutor returns a new instance of GetAllUsersExecutor.
@@ -195,10 +389,31 @@ func NewGetAllUsersExecutor(repo repositories.UserRepository) clienthelper.APIEx
 	}
 }
 
-// Controller executes the business logic for getting all users and returns the users
+// ParseRequest parses the email_domain and name_prefix filters and the page of the request into the GetAllUsersExecutor object.
+func (e *GetAllUsersExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+	e.Filter = repositories.UserFilter{
+		EmailDomain: strings.TrimPrefix(strings.TrimSpace(values.Get("email_domain")), "@"),
+		NamePrefix:  values.Get("name_prefix"),
+	}
+
+	return e.PageQuery.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest checks that the users can be sorted as requested.
+func (e *GetAllUsersExecutor) ValidateRequest(ctx context.IContext) error {
+	return e.PageQuery.Page.Validate(repositories.UserSortFields...)
+}
+
+// Controller executes the business logic for getting a page of the users and returns the page
 // and any errors that occur during execution.
 func (e *GetAllUsersExecutor) Controller(ctx context.IContext) (interface{}, error) {
-	return e.UserRepo.GetAll()
+	users, info, err := e.UserRepo.GetPage(requestContext(ctx), e.Filter, e.PageQuery.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	return newListResponse(users, info), nil
 }
 
 // UserAccessExecutor defines an APIExecutor for getting a list of accesses based on the user ID.
@@ -218,7 +433,7 @@ func NewUserAccessExecutor(repo repositories.UserRoleRepository) clienthelper.AP
 // Controller executes the business logic for getting a list of accesses based on the user ID and returns the accesses
 // and any errors that occur during execution.
 func (e *UserAccessExecutor) Controller(ctx context.IContext) (interface{}, error) {
-	accesses, err := e.userRoleRepository.GetAllAccess(e.User.ID)
+	accesses, err := e.userRoleRepository.GetAllAccess(requestContext(ctx), e.User.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -289,7 +504,7 @@ func NewUpdateUserPasswordExecutor(repo repositories.UserRepository) clienthelpe
 // Controller executes the business logic for updating a user's password by ID and returns the updated user
 // and any errors that occur during execution.
 func (e *UpdateUserPasswordExecutor) Controller(ctx context.IContext) (interface{}, error) {
-	password, err := e.UserRepo.GetPassword(e.UserPassword.ID)
+	password, err := e.UserRepo.GetPassword(requestContext(ctx), e.UserPassword.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -300,7 +515,7 @@ func (e *UpdateUserPasswordExecutor) Controller(ctx context.IContext) (interface
 	}
 
 	newPasswordHash := utils.MD5Hash(e.UserPassword.Password)
-	err = e.UserRepo.UpdatePassword(e.UserPassword.ID, newPasswordHash)
+	err = e.UserRepo.UpdatePassword(requestContext(ctx), e.UserPassword.ID, newPasswordHash)
 
 	return nil, err
 }
@@ -363,13 +578,13 @@ func NewLoginExecutor(userRepo repositories.UserRepository, userRoleRepo reposit
 // and any errors that occur during execution.
 func (e *LoginExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	// Get the user from the database
-	user, err := e.UserRepo.GetUserByUserName(e.UserName)
+	user, err := e.UserRepo.GetUserByUserName(requestContext(ctx), e.UserName)
 	if err != nil {
 		return nil, err
 	}
 
 	// Get the user from the database
-	password, err := e.UserRepo.GetPassword(user.ID)
+	password, err := e.UserRepo.GetPassword(requestContext(ctx), user.ID)
 	if err != nil {
 		return nil, err
 	}
@@ -380,7 +595,7 @@ func (e *LoginExecutor) Controller(ctx context.IContext) (interface{}, error) {
 	}
 
 	// Get the user's access from the database
-	access, _ := e.UserRoleRepo.GetAllAccess(user.ID)
+	access, _ := e.UserRoleRepo.GetAllAccess(requestContext(ctx), user.ID)
 
 	// Sign the token with the secret key
 	tokenString, err := utils.CreateJWT(e.SecretKey, jwt.MapClaims{
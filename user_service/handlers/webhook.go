@@ -0,0 +1,542 @@
+package handlers
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/contact_manager/webhooks"
+	"github.com/princeparmar/go-helpers/clienthelper"
+	"github.com/princeparmar/go-helpers/context"
+)
+
+// minWebhookSecretLength is the shortest secret a webhook can be given.
+const minWebhookSecretLength = 16
+
+// Webhook defines a struct for creating and updating a webhook of the authenticated user.
+// event_types lists the events delivered, by type such as UserCreated or by aggregate such as
+// contact.*, and subscribes to every event of the user and their contacts when empty. Users holding
+// the admin access can also subscribe to the events of roles and accesses by naming them. A webhook
+// created without a secret gets a random one, returned only once; an update without a secret keeps
+// the current one, and an update without active keeps the state.
+type Webhook struct {
+	ID         int
+	UserID     int      `json:"-"`
+	URL        string   `json:"url"`
+	EventTypes []string `json:"event_types"`
+	Secret     string   `json:"secret"`
+	Active     *bool    `json:"active"`
+}
+
+// ParseRequest parses the webhook in the body, and for updates the id query parameter, into the
+// Webhook object.
+func (wh *Webhook) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	body, err := ioutil.ReadAll(r.Body)
+	if err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(body, wh); err != nil {
+		return err
+	}
+
+	if id := r.URL.Query().Get("id"); id != "" {
+		if wh.ID, err = strconv.Atoi(id); err != nil {
+			return errors.New("invalid id in query")
+		}
+	}
+
+	// The owner is the authenticated user
+	wh.UserID, err = actingUser(r)
+
+	return err
+}
+
+// ValidateRequest checks the URL, event types and secret of the webhook. The host of the URL must
+// resolve to public addresses only.
+func (wh *Webhook) ValidateRequest(ctx context.IContext) error {
+	if err := webhooks.CheckURL(requestContext(ctx), wh.URL); err != nil {
+		return err
+	}
+
+	for _, t := range wh.EventTypes {
+		if !validEventType(t) {
+			return errors.New("unknown event type " + strconv.Quote(t))
+		}
+	}
+
+	if wh.Secret != "" && len(wh.Secret) < minWebhookSecretLength {
+		return errors.New("secret must be at least " + strconv.Itoa(minWebhookSecretLength) + " characters long")
+	}
+
+	return nil
+}
+
+// validEventType reports whether t is the type of a domain event or <aggregate type>.*, of an
+// aggregate webhooks can subscribe to. Whether the user may subscribe to it is checked by
+// requireSubscribable.
+func validEventType(t string) bool {
+	aggregate := eventAggregate(t)
+	return aggregate != "" && webhooks.Subscribable(aggregate, true)
+}
+
+// eventAggregate returns the aggregate type of an event type or <aggregate type>.*, or an empty
+// string when there is no such type.
+func eventAggregate(t string) string {
+	if aggregate := strings.TrimSuffix(t, ".*"); aggregate != t {
+		if _, ok := repositories.EventTypes[aggregate]; ok {
+			return aggregate
+		}
+		return ""
+	}
+
+	for aggregate, types := range repositories.EventTypes {
+		for _, eventType := range types {
+			if eventType == t {
+				return aggregate
+			}
+		}
+	}
+
+	return ""
+}
+
+// requireSubscribable returns ErrPermissionDenied when the event types name events of roles or
+// accesses and the user does not hold webhooks.AdminAccess.
+func requireSubscribable(ctx context.IContext, repo repositories.UserRoleRepository, userID int, eventTypes []string) error {
+	adminOnly := false
+	for _, t := range eventTypes {
+		adminOnly = adminOnly || webhooks.AdminOnly(eventAggregate(t))
+	}
+	if !adminOnly {
+		return nil
+	}
+
+	accesses, err := repo.GetAllAccess(requestContext(ctx), userID)
+	if err != nil && !errors.Is(err, repositories.ErrNoAccess) {
+		return err
+	}
+
+	for _, a := range accesses {
+		if a.Name == webhooks.AdminAccess {
+			return nil
+		}
+	}
+
+	return repositories.ErrPermissionDenied
+}
+
+// requireWebhookOwner returns the webhook with the given ID, or ErrPermissionDenied unless it belongs to the user.
+func requireWebhookOwner(ctx context.IContext, repo repositories.WebhookRepository, webhookID, userID int) (*repositories.Webhook, error) {
+	webhook, err := repo.Get(requestContext(ctx), webhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	if webhook.UserID != userID {
+		return nil, repositories.ErrPermissionDenied
+	}
+
+	return webhook, nil
+}
+
+// newWebhookSecret returns a random secret for a webhook created without one.
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(b), nil
+}
+
+// CreatedWebhook is the response to creating a webhook. Secret is only returned once; receivers
+// verify the signatures of the deliveries with it.
+type CreatedWebhook struct {
+	*repositories.Webhook
+	Secret string
+}
+
+// CreateWebhookExecutor defines an APIExecutor for subscribing an HTTP endpoint to domain events.
+type CreateWebhookExecutor struct {
+	Webhook
+	clienthelper.BaseAPIExecutor
+	WebhookRepo  repositories.WebhookRepository
+	UserRoleRepo repositories.UserRoleRepository
+}
+
+// NewCreateWebhookExecutor returns a new instance of CreateWebhookExecutor.
+func NewCreateWebhookExecutor(repo repositories.WebhookRepository, userRoles repositories.UserRoleRepository) clienthelper.APIExecutor {
+	return &CreateWebhookExecutor{
+		WebhookRepo:  repo,
+		UserRoleRepo: userRoles,
+	}
+}
+
+// Controller executes the business logic for creating a webhook and returns the webhook with its
+// secret and any errors that occur during execution.
+func (e *CreateWebhookExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if err := requireSubscribable(ctx, e.UserRoleRepo, e.Webhook.UserID, e.Webhook.EventTypes); err != nil {
+		return nil, err
+	}
+
+	secret := e.Webhook.Secret
+	if secret == "" {
+		var err error
+		if secret, err = newWebhookSecret(); err != nil {
+			return nil, err
+		}
+	}
+
+	webhook := &repositories.Webhook{
+		UserID:     e.Webhook.UserID,
+		URL:        e.Webhook.URL,
+		EventTypes: e.Webhook.EventTypes,
+		Secret:     secret,
+		Active:     e.Webhook.Active == nil || *e.Webhook.Active,
+	}
+
+	if err := e.WebhookRepo.Create(requestContext(ctx), webhook); err != nil {
+		return nil, err
+	}
+
+	return &CreatedWebhook{Webhook: webhook, Secret: secret}, nil
+}
+
+// UpdateWebhookExecutor defines an APIExecutor for updating a webhook by ID. Enabling a webhook
+// that was disabled for its failures resumes its pending deliveries.
+type UpdateWebhookExecutor struct {
+	Webhook
+	clienthelper.BaseAPIExecutor
+	WebhookRepo  repositories.WebhookRepository
+	UserRoleRepo repositories.UserRoleRepository
+}
+
+// NewUpdateWebhookExecutor returns a new instance of UpdateWebhookExecutor.
+func NewUpdateWebhookExecutor(repo repositories.WebhookRepository, userRoles repositories.UserRoleRepository) clienthelper.APIExecutor {
+	return &UpdateWebhookExecutor{
+		WebhookRepo:  repo,
+		UserRoleRepo: userRoles,
+	}
+}
+
+// Controller executes the business logic for updating a webhook and returns the updated webhook
+// and any errors that occur during execution.
+func (e *UpdateWebhookExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	webhook, err := requireWebhookOwner(ctx, e.WebhookRepo, e.Webhook.ID, e.Webhook.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := requireSubscribable(ctx, e.UserRoleRepo, e.Webhook.UserID, e.Webhook.EventTypes); err != nil {
+		return nil, err
+	}
+
+	webhook.URL = e.Webhook.URL
+	webhook.EventTypes = e.Webhook.EventTypes
+	if e.Webhook.Secret != "" {
+		webhook.Secret = e.Webhook.Secret
+	}
+	if e.Webhook.Active != nil {
+		webhook.Active = *e.Webhook.Active
+	}
+
+	if err := e.WebhookRepo.Update(requestContext(ctx), webhook); err != nil {
+		return nil, err
+	}
+
+	return e.WebhookRepo.Get(requestContext(ctx), webhook.ID)
+}
+
+// WebhookRequest defines a struct for requests on a single webhook of the authenticated user.
+type WebhookRequest struct {
+	ID     int
+	UserID int
+}
+
+// ParseRequest parses the id query parameter and the authenticated user into the WebhookRequest object.
+func (wr *WebhookRequest) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+	wr.ID = id
+
+	wr.UserID, err = actingUser(r)
+
+	return err
+}
+
+// ValidateRequest validates the data in the WebhookRequest object and returns any errors that occur during validation.
+func (wr *WebhookRequest) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// GetWebhookExecutor defines an APIExecutor for getting a webhook by ID.
+type GetWebhookExecutor struct {
+	WebhookRequest
+	clienthelper.BaseAPIExecutor
+	WebhookRepo repositories.WebhookRepository
+}
+
+// NewGetWebhookExecutor returns a new instance of GetWebhookExecutor.
+func NewGetWebhookExecutor(repo repositories.WebhookRepository) clienthelper.APIExecutor {
+	return &GetWebhookExecutor{
+		WebhookRepo: repo,
+	}
+}
+
+// Controller executes the business logic for getting a webhook by ID and returns the webhook and
+// any errors that occur during execution.
+func (e *GetWebhookExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return requireWebhookOwner(ctx, e.WebhookRepo, e.WebhookRequest.ID, e.WebhookRequest.UserID)
+}
+
+// GetAllWebhooksExecutor defines an APIExecutor for listing the webhooks of the authenticated user.
+type GetAllWebhooksExecutor struct {
+	clienthelper.BaseAPIExecutor
+	WebhookRepo repositories.WebhookRepository
+	UserID      int
+}
+
+// NewGetAllWebhooksExecutor returns a new instance of GetAllWebhooksExecutor.
+func NewGetAllWebhooksExecutor(repo repositories.WebhookRepository) clienthelper.APIExecutor {
+	return &GetAllWebhooksExecutor{
+		WebhookRepo: repo,
+	}
+}
+
+// ParseRequest parses the authenticated user; listing the webhooks takes no parameters.
+func (e *GetAllWebhooksExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	var err error
+	e.UserID, err = actingUser(r)
+
+	return err
+}
+
+// ValidateRequest validates the request; listing the webhooks takes no parameters.
+func (e *GetAllWebhooksExecutor) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// Controller executes the business logic for listing the webhooks and returns them and any errors
+// that occur during execution.
+func (e *GetAllWebhooksExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return e.WebhookRepo.GetAll(requestContext(ctx), e.UserID)
+}
+
+// DeleteWebhookExecutor defines an APIExecutor for deleting a webhook by ID together with its
+// delivery log.
+type DeleteWebhookExecutor struct {
+	WebhookRequest
+	clienthelper.BaseAPIExecutor
+	WebhookRepo repositories.WebhookRepository
+}
+
+// NewDeleteWebhookExecutor returns a new instance of DeleteWebhookExecutor.
+func NewDeleteWebhookExecutor(repo repositories.WebhookRepository) clienthelper.APIExecutor {
+	return &DeleteWebhookExecutor{
+		WebhookRepo: repo,
+	}
+}
+
+// Controller executes the business logic for deleting a webhook and returns any errors that occur
+// during execution.
+func (e *DeleteWebhookExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if _, err := requireWebhookOwner(ctx, e.WebhookRepo, e.WebhookRequest.ID, e.WebhookRequest.UserID); err != nil {
+		return nil, err
+	}
+
+	return nil, e.WebhookRepo.Delete(requestContext(ctx), e.WebhookRequest.ID)
+}
+
+// GetWebhookDeliveriesExecutor defines an APIExecutor for the delivery log of a webhook, a page of
+// its deliveries with the snapshot of their last attempt. sort=-id lists the newest first, and the
+// status query parameter selects the pending, succeeded or failed ones.
+type GetWebhookDeliveriesExecutor struct {
+	WebhookRequest
+	PageQuery
+	clienthelper.BaseAPIExecutor
+	WebhookRepo repositories.WebhookRepository
+	Status      string
+}
+
+// NewGetWebhookDeliveriesExecutor returns a new instance of GetWebhookDeliveriesExecutor.
+func NewGetWebhookDeliveriesExecutor(repo repositories.WebhookRepository) clienthelper.APIExecutor {
+	return &GetWebhookDeliveriesExecutor{
+		WebhookRepo: repo,
+	}
+}
+
+// ParseRequest parses the id and status query parameters and the page of the request into the
+// GetWebhookDeliveriesExecutor object.
+func (e *GetWebhookDeliveriesExecutor) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	if err := e.WebhookRequest.ParseRequest(ctx, w, r); err != nil {
+		return err
+	}
+
+	e.Status = r.URL.Query().Get("status")
+
+	return e.PageQuery.ParseRequest(ctx, w, r)
+}
+
+// ValidateRequest checks the status filter and that the deliveries can be sorted as requested.
+func (e *GetWebhookDeliveriesExecutor) ValidateRequest(ctx context.IContext) error {
+	switch e.Status {
+	case "", repositories.WebhookDeliveryPending, repositories.WebhookDeliverySucceeded, repositories.WebhookDeliveryFailed:
+	default:
+		return errors.New("status must be pending, succeeded or failed")
+	}
+
+	return e.PageQuery.Page.Validate(repositories.WebhookDeliverySortFields...)
+}
+
+// Controller executes the business logic for listing the deliveries of a webhook and returns the
+// page and any errors that occur during execution.
+func (e *GetWebhookDeliveriesExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	if _, err := requireWebhookOwner(ctx, e.WebhookRepo, e.WebhookRequest.ID, e.WebhookRequest.UserID); err != nil {
+		return nil, err
+	}
+
+	deliveries, info, err := e.WebhookRepo.GetDeliveries(requestContext(ctx), e.WebhookRequest.ID, e.Status, e.PageQuery.Page)
+	if err != nil {
+		return nil, err
+	}
+
+	return newListResponse(deliveries, info), nil
+}
+
+// WebhookDeliveryRequest defines a struct for requests on a single delivery of a webhook of the
+// authenticated user.
+type WebhookDeliveryRequest struct {
+	WebhookID  int
+	DeliveryID int
+	UserID     int
+}
+
+// ParseRequest parses the id and delivery_id query parameters into the WebhookDeliveryRequest object.
+func (d *WebhookDeliveryRequest) ParseRequest(ctx context.IContext, w http.ResponseWriter, r *http.Request) error {
+	values := r.URL.Query()
+
+	webhookID, err := strconv.Atoi(values.Get("id"))
+	if err != nil {
+		return errors.New("invalid id in query")
+	}
+	d.WebhookID = webhookID
+
+	deliveryID, err := strconv.Atoi(values.Get("delivery_id"))
+	if err != nil {
+		return errors.New("invalid delivery_id in query")
+	}
+	d.DeliveryID = deliveryID
+
+	d.UserID, err = actingUser(r)
+
+	return err
+}
+
+// ValidateRequest validates the data in the WebhookDeliveryRequest object and returns any errors that occur during validation.
+func (d *WebhookDeliveryRequest) ValidateRequest(ctx context.IContext) error {
+	return nil
+}
+
+// getWebhookDelivery returns a delivery of the webhook, or an error when it is one of another or
+// the webhook does not belong to the user.
+func getWebhookDelivery(ctx context.IContext, repo repositories.WebhookRepository, webhookID, deliveryID, userID int) (*repositories.WebhookDelivery, error) {
+	if _, err := requireWebhookOwner(ctx, repo, webhookID, userID); err != nil {
+		return nil, err
+	}
+
+	delivery, err := repo.GetDelivery(requestContext(ctx), deliveryID)
+	if err != nil {
+		return nil, err
+	}
+
+	if delivery.WebhookID != webhookID {
+		return nil, errors.New("invalid webhook delivery id")
+	}
+
+	return delivery, nil
+}
+
+// GetWebhookDeliveryExecutor defines an APIExecutor for getting a delivery of a webhook with the
+// snapshot of its last attempt.
+type GetWebhookDeliveryExecutor struct {
+	WebhookDeliveryRequest
+	clienthelper.BaseAPIExecutor
+	WebhookRepo repositories.WebhookRepository
+}
+
+// NewGetWebhookDeliveryExecutor returns a new instance of GetWebhookDeliveryExecutor.
+func NewGetWebhookDeliveryExecutor(repo repositories.WebhookRepository) clienthelper.APIExecutor {
+	return &GetWebhookDeliveryExecutor{
+		WebhookRepo: repo,
+	}
+}
+
+// Controller executes the business logic for getting a delivery and returns it and any errors that
+// occur during execution.
+func (e *GetWebhookDeliveryExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	return getWebhookDelivery(ctx, e.WebhookRepo, e.WebhookDeliveryRequest.WebhookID, e.WebhookDeliveryRequest.DeliveryID, e.WebhookDeliveryRequest.UserID)
+}
+
+// RedeliverWebhookExecutor defines an APIExecutor for sending a delivery of a webhook again, e.g.
+// once the endpoint has been fixed. The redelivery is a new delivery of the same payload, attempted
+// right away and then retried like the others; the response is the redelivery after its first
+// attempt. The webhook must be enabled.
+type RedeliverWebhookExecutor struct {
+	WebhookDeliveryRequest
+	clienthelper.BaseAPIExecutor
+	WebhookRepo repositories.WebhookRepository
+	Deliverer   *webhooks.Deliverer
+}
+
+// NewRedeliverWebhookExecutor returns a new instance of RedeliverWebhookExecutor.
+func NewRedeliverWebhookExecutor(repo repositories.WebhookRepository, deliverer *webhooks.Deliverer) clienthelper.APIExecutor {
+	return &RedeliverWebhookExecutor{
+		WebhookRepo: repo,
+		Deliverer:   deliverer,
+	}
+}
+
+// Controller executes the business logic for redelivering a delivery and returns the redelivery
+// and any errors that occur during execution.
+func (e *RedeliverWebhookExecutor) Controller(ctx context.IContext) (interface{}, error) {
+	original, err := getWebhookDelivery(ctx, e.WebhookRepo, e.WebhookDeliveryRequest.WebhookID, e.WebhookDeliveryRequest.DeliveryID, e.WebhookDeliveryRequest.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	webhook, err := e.WebhookRepo.Get(requestContext(ctx), original.WebhookID)
+	if err != nil {
+		return nil, err
+	}
+
+	if !webhook.Active {
+		return nil, errors.New("the webhook is disabled, enable it before redelivering")
+	}
+
+	delivery := &repositories.WebhookDelivery{
+		WebhookID:    original.WebhookID,
+		EventID:      original.EventID,
+		EventType:    original.EventType,
+		Payload:      original.Payload,
+		RedeliveryOf: original.ID,
+	}
+
+	if err := e.WebhookRepo.CreateDelivery(requestContext(ctx), delivery); err != nil {
+		return nil, err
+	}
+
+	if err := e.Deliverer.Deliver(requestContext(ctx), delivery); err != nil {
+		return nil, err
+	}
+
+	return delivery, nil
+}
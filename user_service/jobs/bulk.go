@@ -0,0 +1,321 @@
+package jobs
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/princeparmar/contact_manager/attachments"
+	"github.com/princeparmar/contact_manager/contactcsv"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// errNotReadable is recorded for items whose contact is missing or not readable by the job's user.
+var errNotReadable = errors.New("contact not found or not readable")
+
+// BulkWorker processes queued bulk jobs. Items are handled in batches of BatchSize; after every
+// batch the progress is checkpointed and cancellation is checked, so a cancelled job stops after
+// the batch in flight and a job whose worker died resumes at its last checkpoint once its
+// heartbeat is older than StaleAfter. Several workers may run against the same repository.
+//
+// Every item is processed on behalf of the job's user: moves and deletes go through the user's
+// ContactWriter, tags and exports only reach contacts the user can read. Items that fail are
+// recorded with their error and do not stop the job.
+type BulkWorker struct {
+	Jobs       repositories.BulkJobRepository
+	Contacts   repositories.ContactStore
+	Tags       repositories.TagRepository
+	Store      attachments.BlobStore
+	BatchSize  int
+	Interval   time.Duration
+	StaleAfter time.Duration
+
+	// OnError is called with the errors of a run, if set.
+	OnError func(error)
+}
+
+// NewBulkWorker returns a BulkWorker polling for jobs every interval, with batches of 100 items
+// and jobs considered abandoned after ten minutes without a checkpoint.
+func NewBulkWorker(jobs repositories.BulkJobRepository, contacts repositories.ContactStore,
+	tags repositories.TagRepository, store attachments.BlobStore, interval time.Duration) *BulkWorker {
+	return &BulkWorker{
+		Jobs:       jobs,
+		Contacts:   contacts,
+		Tags:       tags,
+		Store:      store,
+		BatchSize:  100,
+		Interval:   interval,
+		StaleAfter: 10 * time.Minute,
+	}
+}
+
+// ExportKey returns the blob key of the CSV file written by an export job.
+func ExportKey(jobID int) string {
+	return "exports/" + strconv.Itoa(jobID) + ".csv"
+}
+
+// exportPartKey returns the blob key of the part of an export starting at item position from.
+func exportPartKey(jobID, from int) string {
+	return "exports/" + strconv.Itoa(jobID) + "/part-" + strconv.Itoa(from) + ".csv"
+}
+
+// RunOnce claims a single job and processes it until it finishes or is cancelled. It reports
+// whether a job was claimed.
+func (w *BulkWorker) RunOnce(ctx context.Context, now time.Time) (bool, error) {
+	job, err := w.Jobs.Claim(ctx, now.Add(-w.StaleAfter))
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	status, result, processErr := w.process(ctx, job)
+	message := ""
+	if processErr != nil {
+		status, message = repositories.BulkJobFailed, processErr.Error()
+	}
+
+	if err := w.Jobs.Finish(ctx, job.ID, status, result, message); err != nil {
+		return true, err
+	}
+
+	return true, processErr
+}
+
+// process runs a claimed job from its checkpoint and returns its final status and result.
+func (w *BulkWorker) process(ctx context.Context, job *repositories.BulkJob) (string, string, error) {
+	contacts := w.Contacts.As(job.UserID)
+
+	var batch func(from int, ids []int) (map[int]string, error)
+
+	switch job.Operation {
+	case repositories.BulkTag, repositories.BulkUntag:
+		tag, err := w.Tags.GetByName(ctx, job.UserID, job.Tag)
+		if errors.Is(err, repositories.ErrTagNotFound) && job.Operation == repositories.BulkTag {
+			tag = &repositories.Tag{UserID: job.UserID, Name: job.Tag}
+			err = w.Tags.Create(ctx, tag)
+		}
+		if err != nil {
+			return "", "", err
+		}
+
+		batch = func(from int, ids []int) (map[int]string, error) {
+			readable, failures, err := readableItems(ctx, contacts, from, ids)
+			if err != nil || len(readable) == 0 {
+				return failures, err
+			}
+			if job.Operation == repositories.BulkTag {
+				return failures, w.Tags.AddContacts(ctx, tag.ID, readable)
+			}
+			return failures, w.Tags.RemoveContacts(ctx, tag.ID, readable)
+		}
+	case repositories.BulkMove:
+		batch = func(from int, ids []int) (map[int]string, error) {
+			return eachItem(from, ids, func(id int) error {
+				c, err := contacts.Get(ctx, id)
+				if err != nil {
+					return err
+				}
+				c.AddressBookID = job.AddressBookID
+				return contacts.Update(ctx, c)
+			}), nil
+		}
+	case repositories.BulkDelete:
+		batch = func(from int, ids []int) (map[int]string, error) {
+			return eachItem(from, ids, func(id int) error {
+				return contacts.Delete(ctx, id, job.UserID)
+			}), nil
+		}
+	case repositories.BulkExport:
+		batch = func(from int, ids []int) (map[int]string, error) {
+			return w.exportPart(ctx, contacts, job.ID, from, ids)
+		}
+	default:
+		return "", "", fmt.Errorf("unknown bulk operation %q", job.Operation)
+	}
+
+	processed, failed := job.Processed, job.Failed
+	for processed < job.Total {
+		ids, err := w.Jobs.GetItems(ctx, job.ID, processed, w.BatchSize)
+		if err != nil {
+			return "", "", err
+		}
+
+		if len(ids) == 0 {
+			break
+		}
+
+		failures, err := batch(processed, ids)
+		if err != nil {
+			return "", "", err
+		}
+
+		if err := w.Jobs.RecordFailures(ctx, job.ID, failures); err != nil {
+			return "", "", err
+		}
+
+		processed += len(ids)
+		failed += len(failures)
+
+		cancelled, err := w.Jobs.Checkpoint(ctx, job.ID, processed, failed)
+		if err != nil {
+			return "", "", err
+		}
+
+		if cancelled && processed < job.Total {
+			return repositories.BulkJobCancelled, "", nil
+		}
+	}
+
+	if job.Operation == repositories.BulkExport {
+		if err := w.assembleExport(ctx, job.ID, processed); err != nil {
+			return "", "", err
+		}
+		return repositories.BulkJobCompleted, ExportKey(job.ID), nil
+	}
+
+	return repositories.BulkJobCompleted, "", nil
+}
+
+// eachItem applies fn to every contact of a batch and returns the errors keyed by item position.
+func eachItem(from int, ids []int, fn func(id int) error) map[int]string {
+	failures := map[int]string{}
+	for i, id := range ids {
+		if err := fn(id); err != nil {
+			failures[from+i] = err.Error()
+		}
+	}
+	return failures
+}
+
+// readableItems splits a batch into the contacts readable through contacts and failures for the
+// others, keyed by item position.
+func readableItems(ctx context.Context, contacts repositories.ContactRepository, from int, ids []int) ([]int, map[int]string, error) {
+	found, err := contacts.GetAll(ctx, &repositories.ContactFilter{ContactIDs: ids})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	visible := map[int]bool{}
+	for _, c := range found {
+		visible[c.ID] = true
+	}
+
+	readable := []int{}
+	failures := map[int]string{}
+	for i, id := range ids {
+		if visible[id] {
+			readable = append(readable, id)
+		} else {
+			failures[from+i] = errNotReadable.Error()
+		}
+	}
+
+	return readable, failures, nil
+}
+
+// exportPart writes the readable contacts of a batch, in item order, to their own part so that
+// a resumed export does not have to redo the batches before its checkpoint.
+func (w *BulkWorker) exportPart(ctx context.Context, contacts repositories.ContactRepository, jobID, from int, ids []int) (map[int]string, error) {
+	found, err := contacts.GetAll(ctx, &repositories.ContactFilter{ContactIDs: ids})
+	if err != nil {
+		return nil, err
+	}
+
+	byID := map[int]*repositories.Contact{}
+	for _, c := range found {
+		byID[c.ID] = c
+	}
+
+	rows := []*repositories.Contact{}
+	failures := map[int]string{}
+	for i, id := range ids {
+		if c, ok := byID[id]; ok {
+			rows = append(rows, c)
+		} else {
+			failures[from+i] = errNotReadable.Error()
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := contactcsv.Write(&buf, rows, nil); err != nil {
+		return nil, err
+	}
+
+	return failures, w.Store.Put(exportPartKey(jobID, from), &buf)
+}
+
+// assembleExport concatenates the parts of an export into its final file, keeping the header row
+// of the first part only, and removes the parts. The parts are found by stepping through the
+// items in batches, so BatchSize must not change while an export is in progress.
+func (w *BulkWorker) assembleExport(ctx context.Context, jobID, total int) error {
+	var buf bytes.Buffer
+	for from := 0; from < total; from += w.BatchSize {
+		part, err := w.Store.Get(exportPartKey(jobID, from))
+		if err != nil {
+			return err
+		}
+
+		r := bufio.NewReader(part)
+		if from > 0 {
+			_, err = r.ReadString('\n')
+		}
+		if err == nil {
+			_, err = io.Copy(&buf, r)
+		}
+		part.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := w.Store.Put(ExportKey(jobID), &buf); err != nil {
+		return err
+	}
+
+	for from := 0; from < total; from += w.BatchSize {
+		if err := w.Store.Delete(exportPartKey(jobID, from)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Run processes jobs until none is left, then polls for new ones every Interval until stop is
+// closed. A job in progress is finished, or checkpointed, before Run returns.
+func (w *BulkWorker) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(w.Interval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			claimed, err := w.RunOnce(context.Background(), time.Now())
+			if err != nil && w.OnError != nil {
+				w.OnError(err)
+			}
+			if !claimed || isClosed(stop) {
+				break
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// isClosed reports whether stop has been closed.
+func isClosed(stop <-chan struct{}) bool {
+	select {
+	case <-stop:
+		return true
+	default:
+		return false
+	}
+}
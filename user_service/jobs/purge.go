@@ -0,0 +1,73 @@
+package jobs
+
+import (
+	"context"
+	"time"
+)
+
+// Purger permanently removes records that were soft-deleted before a point in time and
+// returns how many were removed.
+type Purger interface {
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// PurgeJob periodically empties the trash of its Purgers, removing every record that has been
+// soft-deleted for longer than Retention.
+type PurgeJob struct {
+	Retention time.Duration
+	Interval  time.Duration
+	Purgers   []Purger
+
+	// OnError is called with the errors of a run, if set. A failing purger does not stop the others.
+	OnError func(error)
+}
+
+// NewPurgeJob returns a PurgeJob that runs every interval and purges records deleted more than
+// retention ago from the given purgers.
+func NewPurgeJob(retention, interval time.Duration, purgers ...Purger) *PurgeJob {
+	return &PurgeJob{
+		Retention: retention,
+		Interval:  interval,
+		Purgers:   purgers,
+	}
+}
+
+// RunOnce purges the records deleted before now minus the retention and returns the total number
+// of records removed and the first error encountered.
+func (j *PurgeJob) RunOnce(ctx context.Context, now time.Time) (int64, error) {
+	before := now.Add(-j.Retention)
+
+	var total int64
+	var firstErr error
+	for _, p := range j.Purgers {
+		n, err := p.Purge(ctx, before)
+		if err != nil {
+			if j.OnError != nil {
+				j.OnError(err)
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		total += n
+	}
+
+	return total, firstErr
+}
+
+// Run purges once immediately and then every Interval until stop is closed.
+func (j *PurgeJob) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(j.Interval)
+	defer ticker.Stop()
+
+	for {
+		j.RunOnce(context.Background(), time.Now())
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
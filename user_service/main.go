@@ -0,0 +1,94 @@
+// Command contact_manager runs the maintenance commands of the service. The database is given by
+// the -dialect and -dsn flags, which default to the DB_DIALECT and DB_DSN environment variables:
+//
+//	contact_manager migrate up          applies the pending migrations
+//	contact_manager migrate down [n]    rolls back the n most recently applied migrations (1)
+//	contact_manager migrate status      lists every migration and its state
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+
+	"github.com/princeparmar/contact_manager/migrations"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+const usage = `usage: contact_manager migrate [-dialect mysql|postgres|sqlite] [-dsn dsn] up|down [n]|status`
+
+func main() {
+	if len(os.Args) < 2 || os.Args[1] != "migrate" {
+		fmt.Fprintln(os.Stderr, usage)
+		os.Exit(2)
+	}
+
+	if err := migrate(os.Args[2:]); err != nil {
+		fmt.Fprintln(os.Stderr, "migrate:", err)
+		os.Exit(1)
+	}
+}
+
+func migrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	flags.Usage = func() { fmt.Fprintln(flags.Output(), usage) }
+	dialect := flags.String("dialect", os.Getenv("DB_DIALECT"), "SQL dialect of the database")
+	dsn := flags.String("dsn", os.Getenv("DB_DSN"), "data source name of the database")
+	flags.Parse(args)
+
+	if *dsn == "" {
+		return errors.New("no database given, set -dsn or DB_DSN")
+	}
+
+	db, err := repositories.Open(*dialect, *dsn)
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+
+	runner, err := migrations.NewRunner(db)
+	if err != nil {
+		return err
+	}
+
+	switch flags.Arg(0) {
+	case "up":
+		done, err := runner.Up()
+		report("applied", done, err)
+		return err
+	case "down":
+		steps := 1
+		if flags.NArg() > 1 {
+			steps, err = strconv.Atoi(flags.Arg(1))
+			if err != nil || steps < 1 {
+				return fmt.Errorf("invalid number of migrations %q", flags.Arg(1))
+			}
+		}
+		done, err := runner.Down(steps)
+		report("rolled back", done, err)
+		return err
+	case "status":
+		return runner.WriteStatus(os.Stdout)
+	default:
+		flags.Usage()
+		os.Exit(2)
+		return nil
+	}
+}
+
+// report prints the migrations an up or down got through, including those before a failure.
+func report(verb string, done []*migrations.Migration, err error) {
+	if len(done) == 0 && err == nil {
+		fmt.Println("nothing to do")
+		return
+	}
+	for _, m := range done {
+		fmt.Printf("%s %d_%s\n", verb, m.Version, m.Name)
+	}
+}
@@ -0,0 +1,161 @@
+// Package migrations versions the database schema. Each migration is a pair of SQL scripts named
+// <version>_<name>.up.sql and <version>_<name>.down.sql in the directory of every dialect under
+// sql; the up script moves the schema to the version and the down script moves it back. A Runner
+// applies the pending migrations in version order and records them in the schema_migrations table.
+//
+// A migration must never change once it has been applied anywhere: the Runner refuses to run
+// when the checksum of an applied up script no longer matches. Schema changes are made by adding
+// a migration with the next version, with the same version and name for every dialect.
+package migrations
+
+import (
+	"crypto/sha256"
+	"embed"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+//go:embed sql/*/*.sql
+var scripts embed.FS
+
+// Migration is one version of the schema.
+type Migration struct {
+	Version  int
+	Name     string
+	Up       string
+	Down     string
+	Checksum string
+}
+
+var scriptName = regexp.MustCompile(`^(\d+)_([a-z0-9_]+)\.(up|down)\.sql$`)
+
+// All returns the migrations shipped with the service for a dialect, ordered by version.
+func All(dialect repositories.Dialect) ([]*Migration, error) {
+	sub, err := fs.Sub(scripts, "sql/"+dialect.Name())
+	if err != nil {
+		return nil, err
+	}
+	return Load(sub)
+}
+
+// Load reads the migrations from the scripts at the root of fsys and returns them ordered by
+// version. Every migration needs an up script; the down script is optional, but a migration
+// without one cannot be rolled back.
+func Load(fsys fs.FS) ([]*Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := map[int]*Migration{}
+	for _, entry := range entries {
+		if entry.IsDir() {
+			continue
+		}
+
+		m := scriptName.FindStringSubmatch(entry.Name())
+		if m == nil {
+			return nil, fmt.Errorf("migration script %s is not named <version>_<name>.up.sql or .down.sql", entry.Name())
+		}
+
+		version, err := strconv.Atoi(m[1])
+		if err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration script %s has an invalid version", entry.Name())
+		}
+
+		content, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+
+		migration, ok := byVersion[version]
+		if !ok {
+			migration = &Migration{Version: version, Name: m[2]}
+			byVersion[version] = migration
+		}
+		if migration.Name != m[2] {
+			return nil, fmt.Errorf("migration %d is named both %s and %s", version, migration.Name, m[2])
+		}
+
+		if m[3] == "up" {
+			migration.Up = string(content)
+		} else {
+			migration.Down = string(content)
+		}
+	}
+
+	migrations := []*Migration{}
+	for _, migration := range byVersion {
+		if strings.TrimSpace(migration.Up) == "" {
+			return nil, fmt.Errorf("migration %d_%s has no up script", migration.Version, migration.Name)
+		}
+		migration.Checksum = checksum(migration.Up)
+		migrations = append(migrations, migration)
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+
+	return migrations, nil
+}
+
+// checksum returns the hex-encoded SHA-256 of a script.
+func checksum(script string) string {
+	sum := sha256.Sum256([]byte(script))
+	return hex.EncodeToString(sum[:])
+}
+
+// statements splits a script into its statements. Statements end with a semicolon outside of
+// quotes; "--" comments run to the end of the line and are dropped.
+func statements(script string) ([]string, error) {
+	result := []string{}
+	var current strings.Builder
+	var quote rune
+
+	runes := []rune(script)
+	for i := 0; i < len(runes); i++ {
+		c := runes[i]
+		switch {
+		case quote != 0:
+			current.WriteRune(c)
+			if c == '\\' && i+1 < len(runes) {
+				i++
+				current.WriteRune(runes[i])
+			} else if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"' || c == '`':
+			quote = c
+			current.WriteRune(c)
+		case c == '-' && i+1 < len(runes) && runes[i+1] == '-':
+			for i < len(runes) && runes[i] != '\n' {
+				i++
+			}
+			current.WriteRune('\n')
+		case c == ';':
+			if stmt := strings.TrimSpace(current.String()); stmt != "" {
+				result = append(result, stmt)
+			}
+			current.Reset()
+		default:
+			current.WriteRune(c)
+		}
+	}
+
+	if quote != 0 {
+		return nil, errors.New("script ends inside a quoted string")
+	}
+
+	if stmt := strings.TrimSpace(current.String()); stmt != "" {
+		result = append(result, stmt)
+	}
+
+	return result, nil
+}
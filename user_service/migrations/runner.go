@@ -0,0 +1,414 @@
+package migrations
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// LockName is the name of the advisory lock held while migrating, so that instances starting
+// at the same time do not apply a migration twice. PostgreSQL locks a number instead, the
+// FNV-1a hash of the name.
+const LockName = "contact_manager.schema_migrations"
+
+// States of a migration in a Status.
+const (
+	StateApplied  = "applied"
+	StatePending  = "pending"
+	StateModified = "modified"
+	StateMissing  = "missing"
+)
+
+var (
+	// ErrLocked is returned when the lock could not be taken within the LockTimeout.
+	ErrLocked = errors.New("another instance is migrating the database")
+
+	// ErrModified is returned when an applied migration no longer has the checksum it was applied with.
+	ErrModified = errors.New("migration was modified after it was applied")
+
+	// ErrMissing is returned when the database has a migration applied that the service does not know.
+	ErrMissing = errors.New("migration is applied but unknown to this version of the service")
+
+	// ErrOutOfOrder is returned when a pending migration has a lower version than an applied one.
+	ErrOutOfOrder = errors.New("migration is older than the latest applied one")
+)
+
+// Status describes a migration known to the service or applied to the database. AppliedDate is
+// nil for pending migrations.
+type Status struct {
+	Version     int
+	Name        string
+	State       string
+	AppliedDate *time.Time
+}
+
+// appliedMigration is a row of the schema_migrations table.
+type appliedMigration struct {
+	Version     int
+	Name        string
+	Checksum    string
+	AppliedDate time.Time
+}
+
+// queryer is implemented by *sql.DB and *sql.Conn.
+type queryer interface {
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+// execer is implemented by *sql.Conn and *sql.Tx.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// Runner applies and rolls back migrations on a MySQL, PostgreSQL or SQLite database.
+type Runner struct {
+	DB         *repositories.DB
+	Migrations []*Migration
+
+	// LockTimeout is how long Up and Down wait for another instance to finish migrating.
+	LockTimeout time.Duration
+}
+
+// NewRunner returns a Runner for the migrations shipped with the service for the dialect of db.
+func NewRunner(db *repositories.DB) (*Runner, error) {
+	migrations, err := All(db.Dialect)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Runner{DB: db, Migrations: migrations, LockTimeout: time.Minute}, nil
+}
+
+// Up applies the pending migrations in version order and returns the ones it applied. It stops
+// at the first failing migration; the migrations before it stay applied. PostgreSQL and SQLite
+// roll back the failing migration as a whole. MySQL commits schema changes immediately, so there
+// a migration failing halfway is not rolled back and its scripts should be safe to run again.
+func (r *Runner) Up() ([]*Migration, error) {
+	done := []*Migration{}
+	err := r.locked(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := r.verified(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		latest := 0
+		for version := range applied {
+			if version > latest {
+				latest = version
+			}
+		}
+
+		for _, m := range r.Migrations {
+			if _, ok := applied[m.Version]; ok {
+				continue
+			}
+			if m.Version < latest {
+				return fmt.Errorf("%d_%s: %w", m.Version, m.Name, ErrOutOfOrder)
+			}
+
+			err := r.step(ctx, conn, func(e execer) error {
+				if err := run(ctx, e, m.Up); err != nil {
+					return fmt.Errorf("applying migration %d_%s: %w", m.Version, m.Name, err)
+				}
+
+				query := "INSERT INTO schema_migrations (version, name, checksum, applied_date) VALUES (?, ?, ?, CURRENT_TIMESTAMP)"
+				return r.exec(ctx, e, query, m.Version, m.Name, m.Checksum)
+			})
+			if err != nil {
+				return err
+			}
+
+			done = append(done, m)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Down rolls back the given number of most recently applied migrations and returns the ones it
+// rolled back, newest first.
+func (r *Runner) Down(steps int) ([]*Migration, error) {
+	done := []*Migration{}
+	err := r.locked(func(ctx context.Context, conn *sql.Conn) error {
+		applied, err := r.verified(ctx, conn)
+		if err != nil {
+			return err
+		}
+
+		for i := len(r.Migrations) - 1; i >= 0 && len(done) < steps; i-- {
+			m := r.Migrations[i]
+			if _, ok := applied[m.Version]; !ok {
+				continue
+			}
+
+			if m.Down == "" {
+				return fmt.Errorf("migration %d_%s has no down script", m.Version, m.Name)
+			}
+
+			err := r.step(ctx, conn, func(e execer) error {
+				if err := run(ctx, e, m.Down); err != nil {
+					return fmt.Errorf("rolling back migration %d_%s: %w", m.Version, m.Name, err)
+				}
+
+				return r.exec(ctx, e, "DELETE FROM schema_migrations WHERE version = ?", m.Version)
+			})
+			if err != nil {
+				return err
+			}
+
+			done = append(done, m)
+		}
+
+		return nil
+	})
+
+	return done, err
+}
+
+// Status returns the state of every migration known to the service or applied to the database,
+// ordered by version. It takes no lock and changes nothing.
+func (r *Runner) Status() ([]*Status, error) {
+	ctx := context.Background()
+
+	query := "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = DATABASE() AND table_name = 'schema_migrations'"
+	switch r.DB.Dialect {
+	case repositories.PostgreSQL:
+		query = "SELECT COUNT(*) FROM information_schema.tables WHERE table_schema = CURRENT_SCHEMA() AND table_name = 'schema_migrations'"
+	case repositories.SQLite:
+		query = "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'"
+	}
+
+	var tables int
+	if err := r.DB.QueryRowContext(ctx, query).Scan(&tables); err != nil {
+		return nil, err
+	}
+
+	applied := map[int]*appliedMigration{}
+	if tables > 0 {
+		var err error
+		applied, err = loadApplied(ctx, r.DB)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	statuses := []*Status{}
+	for _, m := range r.Migrations {
+		status := &Status{Version: m.Version, Name: m.Name, State: StatePending}
+		if a, ok := applied[m.Version]; ok {
+			status.State = StateApplied
+			if a.Checksum != m.Checksum {
+				status.State = StateModified
+			}
+			status.AppliedDate = &a.AppliedDate
+			delete(applied, m.Version)
+		}
+		statuses = append(statuses, status)
+	}
+
+	for _, a := range applied {
+		a := a
+		statuses = append(statuses, &Status{Version: a.Version, Name: a.Name, State: StateMissing, AppliedDate: &a.AppliedDate})
+	}
+
+	sort.Slice(statuses, func(i, j int) bool { return statuses[i].Version < statuses[j].Version })
+
+	return statuses, nil
+}
+
+// WriteStatus writes the Status of the migrations to w as a table, for the status command.
+func (r *Runner) WriteStatus(w io.Writer) error {
+	statuses, err := r.Status()
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "VERSION\tNAME\tSTATE\tAPPLIED")
+	for _, s := range statuses {
+		applied := "-"
+		if s.AppliedDate != nil {
+			applied = s.AppliedDate.Format(time.RFC3339)
+		}
+		fmt.Fprintf(tw, "%d\t%s\t%s\t%s\n", s.Version, s.Name, s.State, applied)
+	}
+
+	return tw.Flush()
+}
+
+// locked runs fn on a connection holding the migration lock. The lock belongs to the connection,
+// so it is released even when the process dies halfway. SQLite databases are files without
+// advisory locks, so on SQLite nothing keeps two processes from migrating at the same time.
+func (r *Runner) locked(fn func(ctx context.Context, conn *sql.Conn) error) error {
+	ctx := context.Background()
+	conn, err := r.DB.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	appliedDate := "DATETIME"
+	switch r.DB.Dialect {
+	case repositories.MySQL:
+		var acquired sql.NullInt64
+		err = conn.QueryRowContext(ctx, "SELECT GET_LOCK(?, ?)", LockName, int(r.LockTimeout/time.Second)).Scan(&acquired)
+		if err != nil {
+			return err
+		}
+
+		if acquired.Int64 != 1 {
+			return ErrLocked
+		}
+		defer conn.ExecContext(ctx, "DO RELEASE_LOCK(?)", LockName)
+	case repositories.PostgreSQL:
+		if err := advisoryLock(ctx, conn, r.LockTimeout); err != nil {
+			return err
+		}
+		defer conn.ExecContext(ctx, "SELECT PG_ADVISORY_UNLOCK($1)", advisoryLockKey())
+
+		appliedDate = "TIMESTAMP"
+	}
+
+	query := `
+	CREATE TABLE IF NOT EXISTS schema_migrations (
+		version BIGINT NOT NULL PRIMARY KEY,
+		name VARCHAR(255) NOT NULL,
+		checksum CHAR(64) NOT NULL,
+		applied_date ` + appliedDate + ` NOT NULL
+	)`
+	if _, err := conn.ExecContext(ctx, query); err != nil {
+		return err
+	}
+
+	return fn(ctx, conn)
+}
+
+// advisoryLock takes the PostgreSQL advisory lock on conn, waiting up to timeout for the instance
+// holding it.
+func advisoryLock(ctx context.Context, conn *sql.Conn, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	for {
+		var acquired bool
+		if err := conn.QueryRowContext(ctx, "SELECT PG_TRY_ADVISORY_LOCK($1)", advisoryLockKey()).Scan(&acquired); err != nil {
+			return err
+		}
+
+		if acquired {
+			return nil
+		}
+
+		if time.Now().After(deadline) {
+			return ErrLocked
+		}
+
+		time.Sleep(250 * time.Millisecond)
+	}
+}
+
+// advisoryLockKey is the key of the PostgreSQL advisory lock, see LockName.
+func advisoryLockKey() int64 {
+	h := fnv.New64a()
+	h.Write([]byte(LockName))
+	return int64(h.Sum64())
+}
+
+// step runs one migration step on conn, in a transaction on the databases that can roll back
+// schema changes.
+func (r *Runner) step(ctx context.Context, conn *sql.Conn, fn func(e execer) error) error {
+	if r.DB.Dialect == repositories.MySQL {
+		return fn(conn)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err := fn(tx); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// exec executes a query with ? placeholders on e.
+func (r *Runner) exec(ctx context.Context, e execer, query string, args ...interface{}) error {
+	query, args = r.DB.Dialect.Bind(query, args)
+	_, err := e.ExecContext(ctx, query, args...)
+	return err
+}
+
+// verified returns the applied migrations after checking that each is known to the service
+// with an unchanged checksum.
+func (r *Runner) verified(ctx context.Context, q queryer) (map[int]*appliedMigration, error) {
+	applied, err := loadApplied(ctx, q)
+	if err != nil {
+		return nil, err
+	}
+
+	known := map[int]*Migration{}
+	for _, m := range r.Migrations {
+		known[m.Version] = m
+	}
+
+	for _, a := range applied {
+		m, ok := known[a.Version]
+		if !ok {
+			return nil, fmt.Errorf("%d_%s: %w", a.Version, a.Name, ErrMissing)
+		}
+		if m.Checksum != a.Checksum {
+			return nil, fmt.Errorf("%d_%s: %w", m.Version, m.Name, ErrModified)
+		}
+	}
+
+	return applied, nil
+}
+
+// loadApplied reads the schema_migrations table.
+func loadApplied(ctx context.Context, q queryer) (map[int]*appliedMigration, error) {
+	rows, err := q.QueryContext(ctx, "SELECT version, name, checksum, applied_date FROM schema_migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	applied := map[int]*appliedMigration{}
+	for rows.Next() {
+		a := &appliedMigration{}
+		if err := rows.Scan(&a.Version, &a.Name, &a.Checksum, &a.AppliedDate); err != nil {
+			return nil, err
+		}
+		applied[a.Version] = a
+	}
+
+	return applied, rows.Err()
+}
+
+// run executes the statements of a script one by one.
+func run(ctx context.Context, e execer, script string) error {
+	stmts, err := statements(script)
+	if err != nil {
+		return err
+	}
+
+	for _, stmt := range stmts {
+		if _, err := e.ExecContext(ctx, stmt); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
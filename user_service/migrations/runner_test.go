@@ -0,0 +1,288 @@
+package migrations
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// testScripts are the scripts of the test migrations. 0002 has no down script and the second
+// statement of 0004 fails.
+var testScripts = fstest.MapFS{
+	"0001_notes.up.sql":     {Data: []byte("CREATE TABLE notes (id INTEGER PRIMARY KEY, body TEXT); -- the notes\n")},
+	"0001_notes.down.sql":   {Data: []byte("DROP TABLE notes;")},
+	"0002_labels.up.sql":    {Data: []byte("CREATE TABLE labels (id INTEGER PRIMARY KEY);\nINSERT INTO labels (id) VALUES (1);")},
+	"0003_archive.up.sql":   {Data: []byte("CREATE TABLE archive (id INTEGER PRIMARY KEY);")},
+	"0003_archive.down.sql": {Data: []byte("DROP TABLE archive;")},
+	"0004_failing.up.sql":   {Data: []byte("CREATE TABLE failing (id INTEGER);\nINSERT INTO missing (id) VALUES (1);")},
+}
+
+func newTestRunner(t *testing.T, names ...string) *Runner {
+	db, err := repositories.Open("sqlite", filepath.Join(t.TempDir(), "migrations.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	return &Runner{DB: db, Migrations: load(t, names...), LockTimeout: time.Second}
+}
+
+// load loads the migrations of testScripts with the given names.
+func load(t *testing.T, names ...string) []*Migration {
+	fsys := fstest.MapFS{}
+	for _, name := range names {
+		fsys[name+".up.sql"] = testScripts[name+".up.sql"]
+		if down, ok := testScripts[name+".down.sql"]; ok {
+			fsys[name+".down.sql"] = down
+		}
+	}
+
+	migrations, err := Load(fsys)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrations
+}
+
+func versions(migrations []*Migration) []int {
+	result := []int{}
+	for _, m := range migrations {
+		result = append(result, m.Version)
+	}
+	return result
+}
+
+func tableExists(t *testing.T, r *Runner, table string) bool {
+	t.Helper()
+	var n int
+	err := r.DB.QueryRow(context.Background(), "SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = ?", table).Scan(&n)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return n > 0
+}
+
+func TestLoad(t *testing.T) {
+	migrations := load(t, "0003_archive", "0001_notes", "0002_labels")
+	if got := versions(migrations); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("versions %v, want [1 2 3]", got)
+	}
+	if m := migrations[0]; m.Name != "notes" || m.Down != "DROP TABLE notes;" || m.Checksum != checksum(m.Up) {
+		t.Errorf("migration 1: %+v", m)
+	}
+
+	invalid := map[string]fstest.MapFS{
+		"name":         {"notes.up.sql": {Data: []byte("SELECT 1;")}},
+		"version zero": {"0000_notes.up.sql": {Data: []byte("SELECT 1;")}},
+		"no up script": {"0001_notes.down.sql": {Data: []byte("SELECT 1;")}},
+		"two names": {
+			"0001_notes.up.sql":   {Data: []byte("SELECT 1;")},
+			"0001_labels.up.sql":  {Data: []byte("SELECT 1;")},
+			"0001_notes.down.sql": {Data: []byte("SELECT 1;")},
+		},
+	}
+	for name, fsys := range invalid {
+		if _, err := Load(fsys); err == nil {
+			t.Errorf("%s: loaded", name)
+		}
+	}
+}
+
+func TestStatements(t *testing.T) {
+	script := "CREATE TABLE a (s TEXT DEFAULT 'x;y'); -- one; two\nINSERT INTO a VALUES ('it''s'), (\"q;\");\n\n  "
+	got, err := statements(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"CREATE TABLE a (s TEXT DEFAULT 'x;y')", "INSERT INTO a VALUES ('it''s'), (\"q;\")"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("statements %q, want %q", got, want)
+	}
+
+	if _, err := statements("SELECT 'open;"); err == nil {
+		t.Error("unterminated quote accepted")
+	}
+}
+
+func TestUpAndDown(t *testing.T) {
+	r := newTestRunner(t, "0001_notes", "0002_labels", "0003_archive")
+
+	done, err := r.Up()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(done); !reflect.DeepEqual(got, []int{1, 2, 3}) {
+		t.Errorf("applied %v, want [1 2 3]", got)
+	}
+
+	if done, err := r.Up(); err != nil || len(done) != 0 {
+		t.Errorf("second Up applied %v: %v", versions(done), err)
+	}
+
+	statuses, err := r.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range statuses {
+		if s.State != StateApplied || s.AppliedDate == nil {
+			t.Errorf("status %+v, want applied", s)
+		}
+	}
+
+	done, err = r.Down(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := versions(done); !reflect.DeepEqual(got, []int{3}) {
+		t.Errorf("rolled back %v, want [3]", got)
+	}
+	if tableExists(t, r, "archive") || !tableExists(t, r, "labels") {
+		t.Error("Down(1) did not roll back only the archive")
+	}
+
+	// 0002 has no down script, so rolling back stops before it
+	if _, err := r.Down(2); err == nil {
+		t.Error("rolled back a migration without a down script")
+	}
+	if !tableExists(t, r, "labels") || !tableExists(t, r, "notes") {
+		t.Error("failed Down changed the schema")
+	}
+
+	statuses, err = r.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := statuses[2]; s.Version != 3 || s.State != StatePending || s.AppliedDate != nil {
+		t.Errorf("status %+v, want pending", s)
+	}
+}
+
+func TestUpRollsBackFailingMigration(t *testing.T) {
+	r := newTestRunner(t, "0001_notes", "0004_failing")
+
+	done, err := r.Up()
+	if err == nil {
+		t.Fatal("failing migration applied")
+	}
+	if got := versions(done); !reflect.DeepEqual(got, []int{1}) {
+		t.Errorf("applied %v, want [1]", got)
+	}
+	if tableExists(t, r, "failing") {
+		t.Error("failing migration left its first statement applied")
+	}
+
+	statuses, err := r.Status()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := statuses[1]; s.Version != 4 || s.State != StatePending {
+		t.Errorf("status %+v, want pending", s)
+	}
+}
+
+func TestVerified(t *testing.T) {
+	t.Run("Modified", func(t *testing.T) {
+		r := newTestRunner(t, "0001_notes", "0002_labels")
+		if _, err := r.Up(); err != nil {
+			t.Fatal(err)
+		}
+
+		r.Migrations[0].Up = "CREATE TABLE notes (id INTEGER PRIMARY KEY);"
+		r.Migrations[0].Checksum = checksum(r.Migrations[0].Up)
+		if _, err := r.Up(); !errors.Is(err, ErrModified) {
+			t.Errorf("Up: got %v, want %v", err, ErrModified)
+		}
+		if _, err := r.Down(1); !errors.Is(err, ErrModified) {
+			t.Errorf("Down: got %v, want %v", err, ErrModified)
+		}
+
+		statuses, err := r.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if statuses[0].State != StateModified || statuses[1].State != StateApplied {
+			t.Errorf("states %s and %s, want modified and applied", statuses[0].State, statuses[1].State)
+		}
+	})
+
+	t.Run("OutOfOrder", func(t *testing.T) {
+		r := newTestRunner(t, "0001_notes", "0003_archive")
+		if _, err := r.Up(); err != nil {
+			t.Fatal(err)
+		}
+
+		r.Migrations = load(t, "0001_notes", "0002_labels", "0003_archive")
+		if _, err := r.Up(); !errors.Is(err, ErrOutOfOrder) {
+			t.Errorf("got %v, want %v", err, ErrOutOfOrder)
+		}
+		if tableExists(t, r, "labels") {
+			t.Error("out of order migration applied")
+		}
+	})
+
+	t.Run("Missing", func(t *testing.T) {
+		r := newTestRunner(t, "0001_notes", "0003_archive")
+		if _, err := r.Up(); err != nil {
+			t.Fatal(err)
+		}
+
+		r.Migrations = load(t, "0001_notes")
+		if _, err := r.Up(); !errors.Is(err, ErrMissing) {
+			t.Errorf("got %v, want %v", err, ErrMissing)
+		}
+
+		statuses, err := r.Status()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(statuses) != 2 || statuses[1].Version != 3 || statuses[1].State != StateMissing {
+			t.Errorf("statuses %+v, want 0003 missing", statuses)
+		}
+	})
+}
+
+// TestShippedMigrations checks that every dialect ships the same migrations, each of which can
+// be rolled back, and that the SQLite ones apply and roll back cleanly.
+func TestShippedMigrations(t *testing.T) {
+	sqlite, err := All(repositories.SQLite)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, dialect := range []repositories.Dialect{repositories.MySQL, repositories.PostgreSQL} {
+		migrations, err := All(dialect)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(migrations) != len(sqlite) {
+			t.Fatalf("%s ships %d migrations, sqlite %d", dialect.Name(), len(migrations), len(sqlite))
+		}
+		for i, m := range migrations {
+			if m.Version != sqlite[i].Version || m.Name != sqlite[i].Name {
+				t.Errorf("%s ships %d_%s where sqlite has %d_%s", dialect.Name(), m.Version, m.Name, sqlite[i].Version, sqlite[i].Name)
+			}
+			if m.Down == "" {
+				t.Errorf("%s: %d_%s has no down script", dialect.Name(), m.Version, m.Name)
+			}
+		}
+	}
+
+	r := newTestRunner(t)
+	r.Migrations = sqlite
+	if _, err := r.Up(); err != nil {
+		t.Fatal(err)
+	}
+	if done, err := r.Down(len(sqlite)); err != nil || len(done) != len(sqlite) {
+		t.Fatalf("rolled back %d of %d migrations: %v", len(done), len(sqlite), err)
+	}
+	if done, err := r.Up(); err != nil || len(done) != len(sqlite) {
+		t.Fatalf("reapplied %d of %d migrations: %v", len(done), len(sqlite), err)
+	}
+}
@@ -0,0 +1,368 @@
+-- The schema the repositories used to create one table at a time. Every table is created only if
+-- it does not exist, so databases set up before migrations adopt this version with their tables
+-- as they are. Columns added to existing tables since then come in later migrations, with
+-- ALTER TABLE, so that those databases get them too.
+
+CREATE TABLE IF NOT EXISTS users (
+	user_id INT AUTO_INCREMENT PRIMARY KEY,
+	user_name VARCHAR(255) NOT NULL UNIQUE,
+	mobile VARCHAR(10) NOT NULL,
+	email_id VARCHAR(255) NOT NULL,
+	password VARCHAR(255) NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS roles (
+	role_id INT AUTO_INCREMENT PRIMARY KEY,
+	role_name VARCHAR(255) NOT NULL UNIQUE,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS access (
+	access_id INT AUTO_INCREMENT PRIMARY KEY,
+	access_name VARCHAR(255) NOT NULL UNIQUE,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW()
+);
+
+CREATE TABLE IF NOT EXISTS access_role (
+	role_id INT NOT NULL,
+	access_id INT NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (role_id, access_id),
+	FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE,
+	FOREIGN KEY (access_id) REFERENCES access(access_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS user_roles (
+	user_id INT NOT NULL,
+	role_id INT NOT NULL,
+	expiry_date DATETIME,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (user_id, role_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id),
+	FOREIGN KEY (role_id) REFERENCES roles(role_id)
+);
+
+CREATE TABLE IF NOT EXISTS address_books (
+	address_book_id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	book_name VARCHAR(255) NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS address_book_shares (
+	share_id INT AUTO_INCREMENT PRIMARY KEY,
+	address_book_id INT NOT NULL,
+	user_id INT NULL,
+	role_id INT NULL,
+	permission TINYINT NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	UNIQUE (address_book_id, user_id),
+	UNIQUE (address_book_id, role_id),
+	FOREIGN KEY (address_book_id) REFERENCES address_books(address_book_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (role_id) REFERENCES roles(role_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS contacts (
+	contact_id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	address_book_id INT NULL,
+	first_name VARCHAR(255) NOT NULL,
+	last_name VARCHAR(255) NOT NULL DEFAULT '',
+	email_id VARCHAR(255) NOT NULL DEFAULT '',
+	mobile VARCHAR(20) NOT NULL DEFAULT '',
+	organization VARCHAR(255) NOT NULL DEFAULT '',
+	notes TEXT NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	last_contacted_date DATETIME NULL,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (address_book_id) REFERENCES address_books(address_book_id) ON DELETE CASCADE
+);
+
+-- History outlives the contact, so there is no foreign key on contact_id.
+CREATE TABLE IF NOT EXISTS contact_history (
+	contact_id INT NOT NULL,
+	version INT NOT NULL,
+	user_id INT NOT NULL,
+	action VARCHAR(20) NOT NULL,
+	restored_version INT NOT NULL DEFAULT 0,
+	changes TEXT NOT NULL,
+	snapshot TEXT NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (contact_id, version)
+);
+
+CREATE TABLE IF NOT EXISTS carddav_objects (
+	contact_id INT PRIMARY KEY,
+	resource_name VARCHAR(255) NOT NULL,
+	uid VARCHAR(255) NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	INDEX (resource_name),
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS contact_groups (
+	group_id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	group_name VARCHAR(255) NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	UNIQUE (user_id, group_name),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS contact_group_members (
+	group_id INT NOT NULL,
+	contact_id INT NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (group_id, contact_id),
+	FOREIGN KEY (group_id) REFERENCES contact_groups(group_id) ON DELETE CASCADE,
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS tags (
+	tag_id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	tag_name VARCHAR(100) NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	UNIQUE (user_id, tag_name),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS contact_tags (
+	tag_id INT NOT NULL,
+	contact_id INT NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (tag_id, contact_id),
+	FOREIGN KEY (tag_id) REFERENCES tags(tag_id) ON DELETE CASCADE,
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS organizations (
+	organization_id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	address_book_id INT NULL,
+	parent_id INT NULL,
+	organization_name VARCHAR(255) NOT NULL,
+	domain VARCHAR(255) NOT NULL DEFAULT '',
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (address_book_id) REFERENCES address_books(address_book_id) ON DELETE CASCADE,
+	FOREIGN KEY (parent_id) REFERENCES organizations(organization_id) ON DELETE SET NULL
+);
+
+CREATE TABLE IF NOT EXISTS organization_contacts (
+	organization_id INT NOT NULL,
+	contact_id INT NOT NULL,
+	title VARCHAR(255) NOT NULL DEFAULT '',
+	department VARCHAR(255) NOT NULL DEFAULT '',
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (organization_id, contact_id),
+	FOREIGN KEY (organization_id) REFERENCES organizations(organization_id) ON DELETE CASCADE,
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS custom_fields (
+	field_id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	address_book_id INT NULL,
+	field_key VARCHAR(63) NOT NULL,
+	label VARCHAR(255) NOT NULL,
+	field_type VARCHAR(20) NOT NULL,
+	required BOOLEAN NOT NULL DEFAULT FALSE,
+	options TEXT NOT NULL,
+	pattern VARCHAR(255) NOT NULL DEFAULT '',
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (address_book_id) REFERENCES address_books(address_book_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS custom_field_values (
+	contact_id INT NOT NULL,
+	field_id INT NOT NULL,
+	field_value TEXT NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (contact_id, field_id),
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE,
+	FOREIGN KEY (field_id) REFERENCES custom_fields(field_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS activities (
+	activity_id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	activity_type VARCHAR(20) NOT NULL,
+	subject VARCHAR(255) NOT NULL DEFAULT '',
+	body TEXT NOT NULL,
+	occurred_date DATETIME NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	INDEX (user_id, occurred_date),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS activity_contacts (
+	activity_id INT NOT NULL,
+	contact_id INT NOT NULL,
+	PRIMARY KEY (activity_id, contact_id),
+	INDEX (contact_id),
+	FOREIGN KEY (activity_id) REFERENCES activities(activity_id) ON DELETE CASCADE,
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS contact_relationships (
+	relationship_id INT AUTO_INCREMENT PRIMARY KEY,
+	contact_id INT NOT NULL,
+	related_contact_id INT NOT NULL,
+	relationship_type VARCHAR(32) NOT NULL,
+	inverse_id INT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	UNIQUE (contact_id, related_contact_id, relationship_type),
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE,
+	FOREIGN KEY (related_contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS reminders (
+	reminder_id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	contact_id INT NOT NULL,
+	reminder_kind VARCHAR(20) NOT NULL,
+	note VARCHAR(255) NOT NULL DEFAULT '',
+	start_date DATETIME NOT NULL,
+	recurrence VARCHAR(20) NOT NULL,
+	time_zone VARCHAR(64) NOT NULL,
+	next_date DATETIME NOT NULL,
+	snoozed_until DATETIME NULL,
+	dismissed BOOLEAN NOT NULL DEFAULT FALSE,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	INDEX (dismissed, next_date),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE
+);
+
+-- Attachments outlive a purged contact until their blobs have been removed, so there is no
+-- foreign key on contact_id.
+CREATE TABLE IF NOT EXISTS attachments (
+	attachment_id INT AUTO_INCREMENT PRIMARY KEY,
+	contact_id INT NOT NULL,
+	user_id INT NOT NULL,
+	attachment_kind VARCHAR(20) NOT NULL,
+	file_name VARCHAR(255) NOT NULL,
+	content_type VARCHAR(100) NOT NULL,
+	size BIGINT NOT NULL,
+	blob_key VARCHAR(255) NOT NULL,
+	thumbnail_key VARCHAR(255) NOT NULL DEFAULT '',
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	INDEX (contact_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS bulk_jobs (
+	job_id INT AUTO_INCREMENT PRIMARY KEY,
+	user_id INT NOT NULL,
+	operation VARCHAR(20) NOT NULL,
+	tag_name VARCHAR(255) NOT NULL DEFAULT '',
+	address_book_id INT NULL,
+	status VARCHAR(20) NOT NULL,
+	total INT NOT NULL,
+	processed INT NOT NULL DEFAULT 0,
+	failed INT NOT NULL DEFAULT 0,
+	result VARCHAR(255) NOT NULL DEFAULT '',
+	error_message VARCHAR(1024) NOT NULL DEFAULT '',
+	cancel_requested BOOLEAN NOT NULL DEFAULT FALSE,
+	claim_token CHAR(32) NULL,
+	heartbeat_date DATETIME NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	updated_date DATETIME NOT NULL DEFAULT NOW(),
+	INDEX (status, job_id),
+	UNIQUE (claim_token),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS bulk_job_items (
+	job_id INT NOT NULL,
+	position INT NOT NULL,
+	contact_id INT NOT NULL,
+	error_message VARCHAR(1024) NULL,
+	PRIMARY KEY (job_id, position),
+	FOREIGN KEY (job_id) REFERENCES bulk_jobs(job_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS contact_favorites (
+	user_id INT NOT NULL,
+	contact_id INT NOT NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	PRIMARY KEY (user_id, contact_id),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS contact_views (
+	user_id INT NOT NULL,
+	contact_id INT NOT NULL,
+	viewed_date DATETIME(6) NOT NULL,
+	PRIMARY KEY (user_id, contact_id),
+	INDEX (user_id, viewed_date),
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE,
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS share_links (
+	link_id INT AUTO_INCREMENT PRIMARY KEY,
+	contact_id INT NOT NULL,
+	user_id INT NOT NULL,
+	password_hash VARCHAR(255) NOT NULL DEFAULT '',
+	expires_date DATETIME NOT NULL,
+	max_views INT NOT NULL DEFAULT 0,
+	views INT NOT NULL DEFAULT 0,
+	revoked_date DATETIME NULL,
+	created_date DATETIME NOT NULL DEFAULT NOW(),
+	INDEX (contact_id),
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE,
+	FOREIGN KEY (user_id) REFERENCES users(user_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS share_link_accesses (
+	access_id INT AUTO_INCREMENT PRIMARY KEY,
+	link_id INT NOT NULL,
+	outcome VARCHAR(20) NOT NULL,
+	remote_addr VARCHAR(64) NOT NULL DEFAULT '',
+	user_agent VARCHAR(255) NOT NULL DEFAULT '',
+	accessed_date DATETIME NOT NULL DEFAULT NOW(),
+	INDEX (link_id, outcome, accessed_date),
+	FOREIGN KEY (link_id) REFERENCES share_links(link_id) ON DELETE CASCADE
+);
+
+CREATE TABLE IF NOT EXISTS contact_addresses (
+	address_id INT AUTO_INCREMENT PRIMARY KEY,
+	contact_id INT NOT NULL,
+	label VARCHAR(20) NOT NULL,
+	street VARCHAR(255) NOT NULL DEFAULT '',
+	locality VARCHAR(255) NOT NULL DEFAULT '',
+	region VARCHAR(255) NOT NULL DEFAULT '',
+	postal_code VARCHAR(20) NOT NULL DEFAULT '',
+	country_code CHAR(2) NOT NULL DEFAULT '',
+	latitude DOUBLE NULL,
+	longitude DOUBLE NULL,
+	INDEX (contact_id),
+	INDEX (country_code, locality),
+	INDEX (latitude, longitude),
+	FOREIGN KEY (contact_id) REFERENCES contacts(contact_id) ON DELETE CASCADE
+);
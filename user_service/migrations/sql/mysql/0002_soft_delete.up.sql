@@ -0,0 +1,10 @@
+-- Users, roles, accesses and contacts are moved to the trash before they are purged.
+
+ALTER TABLE users ADD COLUMN deleted_date DATETIME NULL;
+ALTER TABLE users ADD COLUMN deleted_by INT NULL;
+ALTER TABLE roles ADD COLUMN deleted_date DATETIME NULL;
+ALTER TABLE roles ADD COLUMN deleted_by INT NULL;
+ALTER TABLE access ADD COLUMN deleted_date DATETIME NULL;
+ALTER TABLE access ADD COLUMN deleted_by INT NULL;
+ALTER TABLE contacts ADD COLUMN deleted_date DATETIME NULL;
+ALTER TABLE contacts ADD COLUMN deleted_by INT NULL;
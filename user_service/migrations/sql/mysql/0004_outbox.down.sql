@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS outbox_sequences;
+DROP TABLE IF EXISTS outbox_events;
@@ -0,0 +1,4 @@
+-- Events that failed to publish too many times are set aside as failed instead of being retried
+-- forever, until they are retried by hand.
+
+ALTER TABLE outbox_events ADD COLUMN failed_date DATETIME NULL;
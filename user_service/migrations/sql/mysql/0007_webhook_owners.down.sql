@@ -0,0 +1,2 @@
+DROP INDEX webhooks_user_id_idx ON webhooks;
+ALTER TABLE webhooks DROP COLUMN user_id;
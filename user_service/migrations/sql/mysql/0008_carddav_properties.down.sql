@@ -0,0 +1 @@
+ALTER TABLE carddav_objects DROP COLUMN properties;
@@ -0,0 +1,7 @@
+-- The birthday and anniversary of a contact, formatted as 2006-01-02 or as --01-02 when the year
+-- is unknown. Each date a contact has gets a yearly reminder for its owner, marked as generated so
+-- that it follows the date when it changes.
+
+ALTER TABLE contacts ADD COLUMN birthday VARCHAR(10) NULL;
+ALTER TABLE contacts ADD COLUMN anniversary VARCHAR(10) NULL;
+ALTER TABLE reminders ADD COLUMN generated BOOLEAN NOT NULL DEFAULT FALSE;
@@ -0,0 +1,8 @@
+ALTER TABLE contacts DROP COLUMN deleted_by;
+ALTER TABLE contacts DROP COLUMN deleted_date;
+ALTER TABLE access DROP COLUMN deleted_by;
+ALTER TABLE access DROP COLUMN deleted_date;
+ALTER TABLE roles DROP COLUMN deleted_by;
+ALTER TABLE roles DROP COLUMN deleted_date;
+ALTER TABLE users DROP COLUMN deleted_by;
+ALTER TABLE users DROP COLUMN deleted_date;
@@ -0,0 +1,3 @@
+ALTER TABLE access DROP COLUMN version;
+ALTER TABLE roles DROP COLUMN version;
+ALTER TABLE users DROP COLUMN version;
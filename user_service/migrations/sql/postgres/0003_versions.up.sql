@@ -0,0 +1,5 @@
+-- The version of users, roles and access rows, raised by every update for optimistic concurrency.
+
+ALTER TABLE users ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE roles ADD COLUMN version INT NOT NULL DEFAULT 1;
+ALTER TABLE access ADD COLUMN version INT NOT NULL DEFAULT 1;
@@ -0,0 +1,5 @@
+-- The content lines of a vCard written over CardDAV that the contact does not model, such as
+-- photos, URLs and additional phone numbers, are kept with its object and written back whenever
+-- the vCard is served.
+
+ALTER TABLE carddav_objects ADD COLUMN properties TEXT NULL;
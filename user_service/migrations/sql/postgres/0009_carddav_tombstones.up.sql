@@ -0,0 +1,21 @@
+-- CardDAV resources removed from a collection: contacts moved to the trash or into another
+-- collection, and contacts of purged users. Sync reports answer them with 404 until they are
+-- purged along with the trash. Contacts already in the trash are recorded as removed when they
+-- were deleted.
+
+CREATE TABLE IF NOT EXISTS carddav_tombstones (
+	tombstone_id SERIAL PRIMARY KEY,
+	user_id INT NOT NULL,
+	address_book_id INT NULL,
+	contact_id INT NOT NULL,
+	resource_name VARCHAR(255) NULL,
+	deleted_date TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS carddav_tombstones_address_book_id_deleted_date_idx ON carddav_tombstones (address_book_id, deleted_date);
+CREATE INDEX IF NOT EXISTS carddav_tombstones_user_id_deleted_date_idx ON carddav_tombstones (user_id, deleted_date);
+CREATE INDEX IF NOT EXISTS carddav_tombstones_deleted_date_idx ON carddav_tombstones (deleted_date);
+
+INSERT INTO carddav_tombstones (user_id, address_book_id, contact_id, resource_name, deleted_date)
+SELECT c.user_id, c.address_book_id, c.contact_id, o.resource_name, c.deleted_date
+FROM contacts c LEFT JOIN carddav_objects o ON o.contact_id = c.contact_id
+WHERE c.deleted_date IS NOT NULL;
@@ -0,0 +1,30 @@
+DROP TABLE IF EXISTS contact_addresses;
+DROP TABLE IF EXISTS share_link_accesses;
+DROP TABLE IF EXISTS share_links;
+DROP TABLE IF EXISTS contact_views;
+DROP TABLE IF EXISTS contact_favorites;
+DROP TABLE IF EXISTS bulk_job_items;
+DROP TABLE IF EXISTS bulk_jobs;
+DROP TABLE IF EXISTS attachments;
+DROP TABLE IF EXISTS reminders;
+DROP TABLE IF EXISTS contact_relationships;
+DROP TABLE IF EXISTS activity_contacts;
+DROP TABLE IF EXISTS activities;
+DROP TABLE IF EXISTS custom_field_values;
+DROP TABLE IF EXISTS custom_fields;
+DROP TABLE IF EXISTS organization_contacts;
+DROP TABLE IF EXISTS organizations;
+DROP TABLE IF EXISTS contact_tags;
+DROP TABLE IF EXISTS tags;
+DROP TABLE IF EXISTS contact_group_members;
+DROP TABLE IF EXISTS contact_groups;
+DROP TABLE IF EXISTS carddav_objects;
+DROP TABLE IF EXISTS contact_history;
+DROP TABLE IF EXISTS contacts;
+DROP TABLE IF EXISTS address_book_shares;
+DROP TABLE IF EXISTS address_books;
+DROP TABLE IF EXISTS user_roles;
+DROP TABLE IF EXISTS access_role;
+DROP TABLE IF EXISTS access;
+DROP TABLE IF EXISTS roles;
+DROP TABLE IF EXISTS users;
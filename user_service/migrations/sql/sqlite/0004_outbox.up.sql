@@ -0,0 +1,24 @@
+-- The domain events of users, roles and accesses, stored in the transaction of the change that
+-- raised them until the relay publishes them, and the last sequence number of each aggregate.
+
+CREATE TABLE IF NOT EXISTS outbox_events (
+	event_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	aggregate_type VARCHAR(50) NOT NULL,
+	aggregate_id INT NOT NULL,
+	sequence_number INT NOT NULL,
+	event_type VARCHAR(50) NOT NULL,
+	payload TEXT NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	last_error VARCHAR(1024) NOT NULL DEFAULT '',
+	created_date DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	published_date DATETIME NULL,
+	UNIQUE (aggregate_type, aggregate_id, sequence_number)
+);
+CREATE INDEX IF NOT EXISTS outbox_events_published_date_event_id_idx ON outbox_events (published_date, event_id);
+
+CREATE TABLE IF NOT EXISTS outbox_sequences (
+	aggregate_type VARCHAR(50) NOT NULL,
+	aggregate_id INT NOT NULL,
+	last_sequence INT NOT NULL DEFAULT 0,
+	PRIMARY KEY (aggregate_type, aggregate_id)
+);
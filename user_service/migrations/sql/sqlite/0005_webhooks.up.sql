@@ -0,0 +1,39 @@
+-- Webhook subscriptions to domain events, and their deliveries with a snapshot of the request and
+-- response of the last attempt.
+
+CREATE TABLE IF NOT EXISTS webhooks (
+	webhook_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	url VARCHAR(2048) NOT NULL,
+	event_types TEXT NOT NULL,
+	secret VARCHAR(255) NOT NULL,
+	active BOOLEAN NOT NULL DEFAULT TRUE,
+	consecutive_failures INT NOT NULL DEFAULT 0,
+	disabled_date DATETIME NULL,
+	disabled_reason VARCHAR(1024) NOT NULL DEFAULT '',
+	created_date DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	updated_date DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS webhook_deliveries (
+	delivery_id INTEGER PRIMARY KEY AUTOINCREMENT,
+	webhook_id INT NOT NULL,
+	event_id INT NOT NULL,
+	event_type VARCHAR(50) NOT NULL,
+	payload TEXT NOT NULL,
+	redelivery_of INT NULL,
+	status VARCHAR(20) NOT NULL,
+	attempts INT NOT NULL DEFAULT 0,
+	next_attempt_date DATETIME NULL,
+	last_attempt_date DATETIME NULL,
+	request_url VARCHAR(2048) NOT NULL DEFAULT '',
+	request_headers TEXT NOT NULL,
+	response_status INT NOT NULL DEFAULT 0,
+	response_headers TEXT NOT NULL,
+	response_body TEXT NOT NULL,
+	error_message VARCHAR(1024) NOT NULL DEFAULT '',
+	duration_ms INT NOT NULL DEFAULT 0,
+	created_date DATETIME NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	FOREIGN KEY (webhook_id) REFERENCES webhooks(webhook_id) ON DELETE CASCADE
+);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_status_next_attempt_date_idx ON webhook_deliveries (status, next_attempt_date);
+CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_id_event_id_idx ON webhook_deliveries (webhook_id, event_id);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS webhooks_user_id_idx;
+ALTER TABLE webhooks DROP COLUMN user_id;
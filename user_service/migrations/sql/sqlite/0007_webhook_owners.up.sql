@@ -0,0 +1,6 @@
+-- Webhooks belong to the user who created them and only receive the events of that user and of
+-- their contacts. Webhooks created before have no owner and receive no events; they are recreated
+-- by their users.
+
+ALTER TABLE webhooks ADD COLUMN user_id INT NULL;
+CREATE INDEX webhooks_user_id_idx ON webhooks (user_id);
@@ -0,0 +1,3 @@
+ALTER TABLE reminders DROP COLUMN generated;
+ALTER TABLE contacts DROP COLUMN anniversary;
+ALTER TABLE contacts DROP COLUMN birthday;
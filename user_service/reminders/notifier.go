@@ -0,0 +1,65 @@
+package reminders
+
+import (
+	"sync"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Notification is a single reminder occurrence delivered to the reminder's user.
+type Notification struct {
+	Reminder *repositories.Reminder
+	Contact  *repositories.Contact
+	DueDate  time.Time
+}
+
+// Notifier delivers notifications, e.g. by email or push message. A failed delivery is retried
+// on the next scheduler run.
+type Notifier interface {
+	Notify(*Notification) error
+}
+
+// MemoryNotifier is an in-process Notifier that keeps every notification it receives, for tests
+// and local development. It is safe for concurrent use.
+type MemoryNotifier struct {
+	mu   sync.Mutex
+	sent []*Notification
+
+	// Err, if set, is returned by Notify instead of recording the notification.
+	Err error
+}
+
+// NewMemoryNotifier returns an empty MemoryNotifier.
+func NewMemoryNotifier() *MemoryNotifier {
+	return &MemoryNotifier{}
+}
+
+// Notify records the notification.
+func (m *MemoryNotifier) Notify(n *Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.Err != nil {
+		return m.Err
+	}
+
+	m.sent = append(m.sent, n)
+	return nil
+}
+
+// Sent returns the notifications recorded so far, oldest first.
+func (m *MemoryNotifier) Sent() []*Notification {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return append([]*Notification{}, m.sent...)
+}
+
+// Reset forgets the recorded notifications.
+func (m *MemoryNotifier) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sent = nil
+}
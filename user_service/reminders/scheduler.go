@@ -0,0 +1,115 @@
+// Package reminders computes when reminders about contacts are due and delivers them through a
+// pluggable Notifier.
+package reminders
+
+import (
+	"context"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Scheduler periodically delivers the reminders that are due and moves them on to their next
+// occurrence.
+type Scheduler struct {
+	Reminders repositories.ReminderRepository
+	Contacts  repositories.ContactRepository
+	Notifier  Notifier
+	Interval  time.Duration
+
+	// OnError is called with the errors of a run, if set. A failing reminder does not stop the others.
+	OnError func(error)
+}
+
+// NewScheduler returns a Scheduler that checks for due reminders every interval.
+func NewScheduler(reminders repositories.ReminderRepository, contacts repositories.ContactRepository,
+	notifier Notifier, interval time.Duration) *Scheduler {
+	return &Scheduler{
+		Reminders: reminders,
+		Contacts:  contacts,
+		Notifier:  notifier,
+		Interval:  interval,
+	}
+}
+
+// Advance moves a reminder past its current occurrence: recurring reminders are rescheduled to
+// their first occurrence after the given time, one-off reminders are dismissed. Occurrences
+// missed while the scheduler was not running are skipped.
+func Advance(ctx context.Context, repo repositories.ReminderRepository, rem *repositories.Reminder, after time.Time) error {
+	next, ok, err := rem.Next(after)
+	if err != nil {
+		return err
+	}
+
+	if !ok {
+		return repo.Dismiss(ctx, rem.ID)
+	}
+
+	return repo.Reschedule(ctx, rem.ID, next)
+}
+
+// RunOnce delivers the reminders due at now and returns how many were delivered and the first
+// error encountered. Reminders whose delivery fails stay due and are retried on the next run.
+func (s *Scheduler) RunOnce(ctx context.Context, now time.Time) (int, error) {
+	due, err := s.Reminders.GetDue(ctx, now)
+	if err != nil {
+		s.report(err)
+		return 0, err
+	}
+
+	delivered := 0
+	var firstErr error
+	for _, rem := range due {
+		if err := s.deliver(ctx, rem, now); err != nil {
+			s.report(err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		delivered++
+	}
+
+	return delivered, firstErr
+}
+
+func (s *Scheduler) deliver(ctx context.Context, rem *repositories.Reminder, now time.Time) error {
+	contact, err := s.Contacts.Get(ctx, rem.ContactID)
+	if err != nil {
+		return err
+	}
+
+	dueDate := rem.NextDate
+	if rem.SnoozedUntil != nil {
+		dueDate = *rem.SnoozedUntil
+	}
+
+	err = s.Notifier.Notify(&Notification{Reminder: rem, Contact: contact, DueDate: dueDate})
+	if err != nil {
+		return err
+	}
+
+	return Advance(ctx, s.Reminders, rem, now)
+}
+
+func (s *Scheduler) report(err error) {
+	if s.OnError != nil {
+		s.OnError(err)
+	}
+}
+
+// Run delivers due reminders once immediately and then every Interval until stop is closed.
+func (s *Scheduler) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(s.Interval)
+	defer ticker.Stop()
+
+	for {
+		s.RunOnce(context.Background(), time.Now())
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -1,81 +1,200 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"time"
 )
 
 type Access struct {
 	ID   int
 	Name string
+	// Version starts at 1 and is raised by every update. Updates and deletes must name the
+	// version they were read at, and fail with ErrVersionConflict once it has changed.
+	Version int
 }
 
-// AccessRepository provides access to the access store
-type AccessRepository struct {
-	db *sql.DB
+// AccessSortFields are the fields a page of access objects can be sorted by.
+var AccessSortFields = []string{"id", "name"}
+
+// SortKey returns the position of the access object in a list sorted by the given field.
+func (a *Access) SortKey(field string) SortKey {
+	if field == "name" {
+		return SortKey{Value: a.Name, ID: a.ID}
+	}
+
+	return SortKey{ID: a.ID}
+}
+
+// AccessFilter selects the access objects listed on a page. The zero value selects every access
+// object.
+type AccessFilter struct {
+	// RoleID selects the access objects granted to the role.
+	RoleID int
+	// NamePrefix selects the access objects whose name starts with it, ignoring case.
+	NamePrefix string
+}
+
+// DeletedAccess is a soft-deleted Access together with its deletion details.
+type DeletedAccess struct {
+	Access
+	DeletionInfo
 }
 
-// NewAccessRepository returns a new instance of AccessRepository
-func NewAccessRepository(db *sql.DB) *AccessRepository {
-	return &AccessRepository{db: db}
+// AccessRepository provides access to the access store. Access names are unique among all access
+// objects, including the ones in the trash
+type AccessRepository interface {
+	Create(context.Context, *Access) error
+	Get(context.Context, int) (*Access, error)
+	Update(context.Context, *Access) error
+	Patch(ctx context.Context, access *Access, fields []string) error
+	Delete(ctx context.Context, id, version, deletedBy int) error
+	GetAll(ctx context.Context) ([]*Access, error)
+	GetPage(ctx context.Context, filter AccessFilter, page PageRequest) ([]*Access, *PageInfo, error)
+	GetDeleted(ctx context.Context) ([]*DeletedAccess, error)
+	Restore(context.Context, int) error
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+// ErrAccessNameTaken is returned when an access object is created or renamed with the name of another one
+var ErrAccessNameTaken = errors.New("an access with this name already exists")
+
+// accessRepository implements AccessRepository on a SQL database
+type accessRepository struct {
+	db *DB
+}
+
+// NewAccessRepository returns a new instance of AccessRepository. Its writes append their domain
+// events to the outbox, see OutboxRepository.
+func NewAccessRepository(db *DB) AccessRepository {
+	return &eventAccessRepository{AccessRepository: &accessRepository{db: db}, db: db}
 }
 
 // Create creates a new access in the database and sets its ID
-func (r *AccessRepository) Create(access *Access) error {
-	// Prepare the query to insert a new access object
-	query := "INSERT INTO access (access_name) VALUES (?)"
+func (r *accessRepository) Create(ctx context.Context, access *Access) error {
+	// Prepare the query to insert a new access object unless its name is taken
+	query := `INSERT INTO access (access_name)
+		SELECT ? ` + r.db.Dialect.FromDual() + `
+		WHERE NOT EXISTS (SELECT 1 FROM access WHERE access_name = ?)`
 	// Execute the query with the access name parameter
-	result, err := r.db.Exec(query, access.Name)
+	id, err := r.db.Insert(ctx, "access_id", query, access.Name, access.Name)
 	if err != nil {
 		return err
 	}
 
-	// Get the ID of the newly inserted access object
-	id, err := result.LastInsertId()
-	if err != nil {
-		return err
+	if id == 0 {
+		return ErrAccessNameTaken
 	}
 
 	// Set the ID of the access object
-	access.ID = int(id)
+	access.ID = id
+	access.Version = 1
 
 	return nil
 }
 
 // Get retrieves an access object with the given ID from the database
-func (r *AccessRepository) Get(id int) (*Access, error) {
+func (r *accessRepository) Get(ctx context.Context, id int) (*Access, error) {
 	// Prepare the query to select an access object by ID
-	query := "SELECT access_id, access_name FROM access WHERE access_id = ?"
+	query := "SELECT access_id, access_name, version FROM access WHERE access_id = ? AND deleted_date IS NULL"
 	// Execute the query with the ID parameter
-	row := r.db.QueryRow(query, id)
+	row := r.db.QueryRow(ctx, query, id)
 	access := &Access{}
-	err := row.Scan(&access.ID, &access.Name)
-	return access, err
+	err := row.Scan(&access.ID, &access.Name, &access.Version)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid access id")
+		}
+		return nil, err
+	}
+	return access, nil
 }
 
-// Update updates an access object in the database with the new data
-func (r *AccessRepository) Update(access *Access) error {
-	// Prepare the query to update an access object by ID
-	query := "UPDATE access SET access_name = ? WHERE access_id = ?"
-	// Execute the query with the access name and ID parameters
-	_, err := r.db.Exec(query, access.Name, access.ID)
-	return err
+// Update updates an access object in the database with the new data, and raises its version
+func (r *accessRepository) Update(ctx context.Context, access *Access) error {
+	// Check that no other access object has the new name
+	var count int
+	err := r.db.QueryRow(ctx, "SELECT COUNT(*) FROM access WHERE access_name = ? AND access_id <> ?", access.Name, access.ID).Scan(&count)
+	if err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrAccessNameTaken
+	}
+
+	// Prepare the query to update an access object by ID, only at the version it was read at
+	query := "UPDATE access SET access_name = ?, updated_date = CURRENT_TIMESTAMP, version = version + 1 WHERE access_id = ? AND version = ? AND deleted_date IS NULL"
+	// Execute the query with the access name, ID and version parameters
+	result, err := r.db.Exec(ctx, query, access.Name, access.ID, access.Version)
+	if err != nil {
+		return err
+	}
+
+	if err := r.db.versionedResult(ctx, result, "access", "access_id", access.ID, "update"); err != nil {
+		return err
+	}
+
+	access.Version++
+
+	return nil
 }
 
-// Delete deletes an access object with the given ID from the database
-func (r *AccessRepository) Delete(id int) error {
-	// Prepare the query to delete an access object by ID
-	query := "DELETE FROM access WHERE access_id = ?"
-	// Execute the query with the ID parameter
-	_, err := r.db.Exec(query, id)
-	return err
+// Patch writes the named fields of an access object, as returned by ChangedAccessFields, at the version
+// it was read at, and raises its version.
+func (r *accessRepository) Patch(ctx context.Context, access *Access, fields []string) error {
+	set, args, err := setFields("access", fields, access.patchFields())
+	if err != nil {
+		return err
+	}
+
+	if hasField(fields, "name") {
+		var count int
+		err := r.db.QueryRow(ctx, "SELECT COUNT(*) FROM access WHERE access_name = ? AND access_id <> ?", access.Name, access.ID).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return ErrAccessNameTaken
+		}
+	}
+
+	query := "UPDATE access SET " + set + "updated_date = CURRENT_TIMESTAMP, version = version + 1 WHERE access_id = ? AND version = ? AND deleted_date IS NULL"
+	result, err := r.db.Exec(ctx, query, append(args, access.ID, access.Version)...)
+	if err != nil {
+		return err
+	}
+
+	if err := r.db.versionedResult(ctx, result, "access", "access_id", access.ID, "update"); err != nil {
+		return err
+	}
+
+	access.Version++
+
+	return nil
+}
+
+// Delete soft-deletes an access object with the given ID and version, keeping it in the trash
+func (r *accessRepository) Delete(ctx context.Context, id, version, deletedBy int) error {
+	// Prepare the query to mark an access object as deleted by ID, only at the given version
+	query := "UPDATE access SET deleted_date = CURRENT_TIMESTAMP, deleted_by = ? WHERE access_id = ? AND version = ? AND deleted_date IS NULL"
+	// Execute the query with the deleting user, ID and version parameters
+	result, err := r.db.Exec(ctx, query, nullableID(deletedBy), id, version)
+	if err != nil {
+		return err
+	}
+
+	return r.db.versionedResult(ctx, result, "access", "access_id", id, "delete")
 }
 
 // GetAll retrieves all access objects from the database
-func (r *AccessRepository) GetAll() ([]*Access, error) {
+func (r *accessRepository) GetAll(ctx context.Context) ([]*Access, error) {
 	// Prepare the query to select all access objects
-	query := "SELECT access_id, access_name FROM access"
+	query := "SELECT access_id, access_name, version FROM access WHERE deleted_date IS NULL"
 	// Execute the query
-	rows, err := r.db.Query(query)
+	rows, err := r.db.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -86,7 +205,7 @@ func (r *AccessRepository) GetAll() ([]*Access, error) {
 
 	for rows.Next() {
 		access := &Access{}
-		err := rows.Scan(&access.ID, &access.Name)
+		err := rows.Scan(&access.ID, &access.Name, &access.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -96,15 +215,104 @@ func (r *AccessRepository) GetAll() ([]*Access, error) {
 	return accesses, rows.Err()
 }
 
-// CreateTable creates the access table in the database
-func (r *AccessRepository) CreateTable() error {
-	query := `
-        CREATE TABLE IF NOT EXISTS access (
-            access_id INT AUTO_INCREMENT PRIMARY KEY,
-            access_name VARCHAR(255) NOT NULL UNIQUE,
-			created_date DATETIME NOT NULL DEFAULT NOW(),
-			updated_date DATETIME NOT NULL DEFAULT NOW()
-        )`
-	_, err := r.db.Exec(query)
-	return err
+// GetPage retrieves a page of the access objects outside the trash selected by the filter
+func (r *accessRepository) GetPage(ctx context.Context, filter AccessFilter, page PageRequest) ([]*Access, *PageInfo, error) {
+	if err := page.Validate(AccessSortFields...); err != nil {
+		return nil, nil, err
+	}
+
+	q := &pageQuery{
+		columns: "access_id, access_name, version",
+		from:    "FROM access WHERE deleted_date IS NULL",
+		id:      "access_id",
+		sorts:   map[string]string{"id": "access_id", "name": "access_name"},
+	}
+
+	if filter.RoleID != 0 {
+		q.from += " AND access_id IN (SELECT access_id FROM access_role WHERE role_id = ?)"
+		q.args = append(q.args, filter.RoleID)
+	}
+
+	if filter.NamePrefix != "" {
+		q.from += " AND LOWER(access_name) LIKE ? ESCAPE '!'"
+		q.args = append(q.args, likePrefix(filter.NamePrefix))
+	}
+
+	accesses := []*Access{}
+	info, err := q.run(ctx, r.db, page, func(rows *Rows) (SortKey, error) {
+		access := &Access{}
+		if err := rows.Scan(&access.ID, &access.Name, &access.Version); err != nil {
+			return SortKey{}, err
+		}
+		accesses = append(accesses, access)
+		return access.SortKey(page.Sort), nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return accesses, info, nil
+}
+
+// GetDeleted retrieves all soft-deleted access objects, most recently deleted first
+func (r *accessRepository) GetDeleted(ctx context.Context) ([]*DeletedAccess, error) {
+	// Prepare the query to select all deleted access objects
+	query := "SELECT access_id, access_name, version, deleted_date, deleted_by FROM access WHERE deleted_date IS NOT NULL ORDER BY deleted_date DESC"
+	// Execute the query
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	accesses := []*DeletedAccess{}
+
+	for rows.Next() {
+		access := &DeletedAccess{}
+		var deletedBy sql.NullInt64
+		err := rows.Scan(&access.ID, &access.Name, &access.Version, &access.DeletedDate, &deletedBy)
+		if err != nil {
+			return nil, err
+		}
+		access.DeletedBy = int(deletedBy.Int64)
+		accesses = append(accesses, access)
+	}
+
+	return accesses, rows.Err()
+}
+
+// Restore moves a soft-deleted access object out of the trash
+func (r *accessRepository) Restore(ctx context.Context, id int) error {
+	// Prepare the query to clear the deletion mark of an access object by ID
+	query := "UPDATE access SET deleted_date = NULL, deleted_by = NULL, updated_date = CURRENT_TIMESTAMP WHERE access_id = ? AND deleted_date IS NOT NULL"
+	// Execute the query with the ID parameter
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no deleted access found with the given id")
+	}
+
+	return nil
+}
+
+// Purge permanently removes the access objects soft-deleted before the given time
+func (r *accessRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	// Prepare the query to delete the expired access objects
+	query := "DELETE FROM access WHERE deleted_date IS NOT NULL AND deleted_date < ?"
+	// Execute the query with the cutoff parameter
+	result, err := r.db.Exec(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
 }
@@ -0,0 +1,344 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Types of activity that can be logged on a timeline. Notes do not count as contacting someone.
+const (
+	ActivityNote    = "note"
+	ActivityCall    = "call"
+	ActivityEmail   = "email"
+	ActivityMeeting = "meeting"
+)
+
+// ValidateActivityType returns an error unless t is one of the activity types.
+func ValidateActivityType(t string) error {
+	switch t {
+	case ActivityNote, ActivityCall, ActivityEmail, ActivityMeeting:
+		return nil
+	default:
+		return errors.New("activity type must be one of note, call, email or meeting")
+	}
+}
+
+// Activity is an entry on the timeline of one or more contacts, authored by UserID.
+// OccurredDate is when the interaction took place, which may be before it was logged.
+type Activity struct {
+	ID           int
+	UserID       int
+	Type         string
+	Subject      string
+	Body         string
+	OccurredDate time.Time
+	ContactIDs   []int
+}
+
+// ActivityFilter selects the entries of a timeline. ContactID restricts the result to the
+// activities linked to that contact, Types to the given types and AuthorID to the activities
+// of that user. Limit and Offset page through the result, newest first; a zero Limit returns
+// every match.
+type ActivityFilter struct {
+	ContactID int
+	Types     []string
+	AuthorID  int
+	Limit     int
+	Offset    int
+}
+
+type ActivityRepository interface {
+	Create(context.Context, *Activity) error
+	Get(context.Context, int) (*Activity, error)
+	Update(context.Context, *Activity) error
+	Delete(context.Context, int) error
+	GetTimeline(context.Context, *ActivityFilter) ([]*Activity, error)
+}
+
+// lastContactedQuery recomputes contacts.last_contacted_date for the contacts whose IDs are
+// appended as a parenthesized list. updated_date is left alone so that logging an activity
+// does not count as a change to the contact itself.
+const lastContactedQuery = `UPDATE contacts SET last_contacted_date = (
+		SELECT MAX(a.occurred_date) FROM activity_contacts ac
+		JOIN activities a ON ac.activity_id = a.activity_id
+		WHERE ac.contact_id = contacts.contact_id AND a.activity_type <> 'note'
+	) WHERE contact_id IN `
+
+type activityRepository struct {
+	db *DB
+}
+
+// NewActivityRepository creates a new ActivityRepository using the provided database connection.
+func NewActivityRepository(db *DB) ActivityRepository {
+	return &activityRepository{db: db}
+}
+
+// Create inserts a new activity together with its contact links and sets its ID. The last
+// contacted time of the linked contacts is updated.
+func (r *activityRepository) Create(ctx context.Context, a *Activity) error {
+	if err := ValidateActivityType(a.Type); err != nil {
+		return err
+	}
+
+	if len(a.ContactIDs) == 0 {
+		return errors.New("an activity must be linked to at least one contact")
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `INSERT INTO activities (user_id, activity_type, subject, body, occurred_date, created_date, updated_date)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+	id, err := tx.Insert(ctx, "activity_id", query, a.UserID, a.Type, a.Subject, a.Body, a.OccurredDate)
+	if err != nil {
+		return err
+	}
+
+	if err := linkActivityContacts(ctx, tx, id, a.ContactIDs); err != nil {
+		return err
+	}
+
+	if err := updateLastContacted(ctx, tx, a.ContactIDs); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	a.ID = id
+
+	return nil
+}
+
+// Get retrieves an activity together with its contact links by ID.
+func (r *activityRepository) Get(ctx context.Context, id int) (*Activity, error) {
+	query := "SELECT activity_id, user_id, activity_type, subject, body, occurred_date FROM activities WHERE activity_id = ?"
+	a := &Activity{}
+	err := r.db.QueryRow(ctx, query, id).Scan(&a.ID, &a.UserID, &a.Type, &a.Subject, &a.Body, &a.OccurredDate)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid activity id")
+		}
+		return nil, err
+	}
+
+	if err := r.attachContacts(ctx, []*Activity{a}); err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Update changes the type, subject, body, time and contact links of an existing activity. The
+// author never changes. The last contacted time of both the old and the new contacts is updated.
+func (r *activityRepository) Update(ctx context.Context, a *Activity) error {
+	if err := ValidateActivityType(a.Type); err != nil {
+		return err
+	}
+
+	if len(a.ContactIDs) == 0 {
+		return errors.New("an activity must be linked to at least one contact")
+	}
+
+	existing, err := r.Get(ctx, a.ID)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := "UPDATE activities SET activity_type = ?, subject = ?, body = ?, occurred_date = ?, updated_date = CURRENT_TIMESTAMP WHERE activity_id = ?"
+	result, err := tx.Exec(ctx, query, a.Type, a.Subject, a.Body, a.OccurredDate, a.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM activity_contacts WHERE activity_id = ?", a.ID); err != nil {
+		return err
+	}
+
+	if err := linkActivityContacts(ctx, tx, a.ID, a.ContactIDs); err != nil {
+		return err
+	}
+
+	if err := updateLastContacted(ctx, tx, append(append([]int{}, existing.ContactIDs...), a.ContactIDs...)); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete removes an activity by ID and updates the last contacted time of its contacts.
+func (r *activityRepository) Delete(ctx context.Context, id int) error {
+	existing, err := r.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	result, err := tx.Exec(ctx, "DELETE FROM activities WHERE activity_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	if err := updateLastContacted(ctx, tx, existing.ContactIDs); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetTimeline retrieves the activities matching the filter, most recent first.
+func (r *activityRepository) GetTimeline(ctx context.Context, filter *ActivityFilter) ([]*Activity, error) {
+	if filter == nil {
+		filter = &ActivityFilter{}
+	}
+
+	query := "SELECT a.activity_id, a.user_id, a.activity_type, a.subject, a.body, a.occurred_date FROM activities a WHERE 1 = 1"
+	args := []interface{}{}
+
+	if filter.ContactID != 0 {
+		query += " AND a.activity_id IN (SELECT ac.activity_id FROM activity_contacts ac WHERE ac.contact_id = ?)"
+		args = append(args, filter.ContactID)
+	}
+
+	if len(filter.Types) > 0 {
+		query += fmt.Sprintf(" AND a.activity_type IN (%s)", placeholders(len(filter.Types)))
+		for _, t := range filter.Types {
+			args = append(args, t)
+		}
+	}
+
+	if filter.AuthorID != 0 {
+		query += " AND a.user_id = ?"
+		args = append(args, filter.AuthorID)
+	}
+
+	query += " ORDER BY a.occurred_date DESC, a.activity_id DESC"
+
+	if filter.Limit > 0 {
+		query += " LIMIT ? OFFSET ?"
+		args = append(args, filter.Limit, filter.Offset)
+	}
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	activities := []*Activity{}
+
+	for rows.Next() {
+		a := &Activity{}
+		err := rows.Scan(&a.ID, &a.UserID, &a.Type, &a.Subject, &a.Body, &a.OccurredDate)
+		if err != nil {
+			return nil, err
+		}
+		activities = append(activities, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := r.attachContacts(ctx, activities); err != nil {
+		return nil, err
+	}
+
+	return activities, nil
+}
+
+// attachContacts loads the contact links of the given activities.
+func (r *activityRepository) attachContacts(ctx context.Context, activities []*Activity) error {
+	if len(activities) == 0 {
+		return nil
+	}
+
+	byID := map[int]*Activity{}
+	ids := []int{}
+	for _, a := range activities {
+		a.ContactIDs = []int{}
+		byID[a.ID] = a
+		ids = append(ids, a.ID)
+	}
+
+	query := fmt.Sprintf("SELECT activity_id, contact_id FROM activity_contacts WHERE activity_id IN (%s) ORDER BY contact_id",
+		placeholders(len(ids)))
+	rows, err := r.db.Query(ctx, query, intArgs(ids)...)
+	if err != nil {
+		return err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var activityID, contactID int
+		if err := rows.Scan(&activityID, &contactID); err != nil {
+			return err
+		}
+		byID[activityID].ContactIDs = append(byID[activityID].ContactIDs, contactID)
+	}
+
+	return rows.Err()
+}
+
+// linkActivityContacts links an activity to the given contacts. Duplicate IDs are ignored.
+func linkActivityContacts(ctx context.Context, tx *Tx, activityID int, contactIDs []int) error {
+	query := tx.Dialect.InsertIgnore("INSERT INTO activity_contacts (activity_id, contact_id) VALUES (?, ?)")
+	for _, contactID := range contactIDs {
+		if _, err := tx.Exec(ctx, query, activityID, contactID); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// updateLastContacted recomputes the last contacted time of the given contacts.
+func updateLastContacted(ctx context.Context, tx *Tx, contactIDs []int) error {
+	if len(contactIDs) == 0 {
+		return nil
+	}
+
+	_, err := tx.Exec(ctx, lastContactedQuery+"("+placeholders(len(contactIDs))+")", intArgs(contactIDs)...)
+	return err
+}
@@ -0,0 +1,223 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Labels of a contact address.
+const (
+	AddressHome  = "home"
+	AddressWork  = "work"
+	AddressOther = "other"
+)
+
+// Address is a postal address of a contact. CountryCode is an ISO 3166-1 alpha-2 code. Latitude
+// and Longitude are set once the address has been geocoded and nil before or when geocoding
+// found nothing.
+type Address struct {
+	ID          int
+	ContactID   int
+	Label       string
+	Street      string
+	Locality    string
+	Region      string
+	PostalCode  string
+	CountryCode string
+	Latitude    *float64
+	Longitude   *float64
+}
+
+// GeoBounds is a rectangle on the map. MinLongitude is greater than MaxLongitude for areas
+// crossing the antimeridian.
+type GeoBounds struct {
+	MinLatitude  float64
+	MaxLatitude  float64
+	MinLongitude float64
+	MaxLongitude float64
+}
+
+type AddressRepository interface {
+	Create(context.Context, *Address) error
+	Get(context.Context, int) (*Address, error)
+	Update(context.Context, *Address) error
+	Delete(context.Context, int) error
+	GetAll(ctx context.Context, contactID int) ([]*Address, error)
+	GetForContacts(ctx context.Context, contactIDs []int) ([]*Address, error)
+}
+
+const addressColumns = `address_id, contact_id, label, street, locality, region, postal_code, country_code, latitude, longitude`
+
+type addressRepository struct {
+	db *DB
+}
+
+// NewAddressRepository creates a new AddressRepository using the provided database connection.
+func NewAddressRepository(db *DB) AddressRepository {
+	return &addressRepository{db: db}
+}
+
+// Create inserts a new address and sets its ID.
+func (r *addressRepository) Create(ctx context.Context, a *Address) error {
+	query := `INSERT INTO contact_addresses (contact_id, label, street, locality, region, postal_code, country_code, latitude, longitude)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	id, err := r.db.Insert(ctx, "address_id", query, a.ContactID, a.Label, a.Street, a.Locality, a.Region, a.PostalCode, a.CountryCode,
+		a.Latitude, a.Longitude)
+	if err != nil {
+		return err
+	}
+
+	a.ID = id
+
+	return nil
+}
+
+// Get retrieves an address by ID.
+func (r *addressRepository) Get(ctx context.Context, id int) (*Address, error) {
+	query := "SELECT " + addressColumns + " FROM contact_addresses WHERE address_id = ?"
+	a, err := scanAddress(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid address id")
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+// Update updates an address. The contact of an address is fixed.
+func (r *addressRepository) Update(ctx context.Context, a *Address) error {
+	query := `UPDATE contact_addresses SET label = ?, street = ?, locality = ?, region = ?, postal_code = ?, country_code = ?,
+		latitude = ?, longitude = ? WHERE address_id = ?`
+	result, err := r.db.Exec(ctx, query, a.Label, a.Street, a.Locality, a.Region, a.PostalCode, a.CountryCode,
+		a.Latitude, a.Longitude, a.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return nil
+}
+
+// Delete removes an address by ID.
+func (r *addressRepository) Delete(ctx context.Context, id int) error {
+	query := "DELETE FROM contact_addresses WHERE address_id = ?"
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return nil
+}
+
+// GetAll retrieves the addresses of a contact in the order they were added.
+func (r *addressRepository) GetAll(ctx context.Context, contactID int) ([]*Address, error) {
+	query := "SELECT " + addressColumns + " FROM contact_addresses WHERE contact_id = ? ORDER BY address_id"
+	return r.query(ctx, query, contactID)
+}
+
+// GetForContacts retrieves the addresses of several contacts, sorted by country, region, locality
+// and street.
+func (r *addressRepository) GetForContacts(ctx context.Context, contactIDs []int) ([]*Address, error) {
+	if len(contactIDs) == 0 {
+		return []*Address{}, nil
+	}
+
+	query := fmt.Sprintf(`SELECT %s FROM contact_addresses WHERE contact_id IN (%s)
+		ORDER BY country_code, region, locality, street, address_id`, addressColumns, placeholders(len(contactIDs)))
+	return r.query(ctx, query, intArgs(contactIDs)...)
+}
+
+func (r *addressRepository) query(ctx context.Context, query string, args ...interface{}) ([]*Address, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	addresses := []*Address{}
+
+	for rows.Next() {
+		a, err := scanAddress(rows)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return addresses, nil
+}
+
+func scanAddress(row rowScanner) (*Address, error) {
+	a := &Address{}
+	var latitude, longitude sql.NullFloat64
+	err := row.Scan(&a.ID, &a.ContactID, &a.Label, &a.Street, &a.Locality, &a.Region, &a.PostalCode, &a.CountryCode,
+		&latitude, &longitude)
+	if err != nil {
+		return nil, err
+	}
+	if latitude.Valid && longitude.Valid {
+		a.Latitude, a.Longitude = &latitude.Float64, &longitude.Float64
+	}
+	return a, nil
+}
+
+// filterAddresses builds the condition selecting the contacts aliased as c that have an address
+// matching the country, locality and bounds of the filter, or an empty string when the filter
+// has none of them.
+func filterAddresses(filter *ContactFilter) (string, []interface{}) {
+	query := ""
+	args := []interface{}{}
+
+	if filter.CountryCode != "" {
+		query += " AND ca.country_code = ?"
+		args = append(args, filter.CountryCode)
+	}
+
+	if filter.Locality != "" {
+		query += " AND ca.locality = ?"
+		args = append(args, filter.Locality)
+	}
+
+	if b := filter.Within; b != nil {
+		query += " AND ca.latitude BETWEEN ? AND ?"
+		args = append(args, b.MinLatitude, b.MaxLatitude)
+
+		if b.MinLongitude <= b.MaxLongitude {
+			query += " AND ca.longitude BETWEEN ? AND ?"
+		} else {
+			query += " AND (ca.longitude >= ? OR ca.longitude <= ?)"
+		}
+		args = append(args, b.MinLongitude, b.MaxLongitude)
+	}
+
+	if query == "" {
+		return "", args
+	}
+
+	return " AND c.contact_id IN (SELECT ca.contact_id FROM contact_addresses ca WHERE 1 = 1" + query + ")", args
+}
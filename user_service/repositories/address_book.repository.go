@@ -0,0 +1,320 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Permission is the level of access a user has on an address book. Higher levels include
+// everything the lower ones allow.
+type Permission int
+
+const (
+	PermissionNone Permission = iota
+	PermissionViewer
+	PermissionEditor
+	PermissionOwner
+)
+
+// ErrPermissionDenied is returned when a user lacks the permission an operation requires.
+var ErrPermissionDenied = errors.New("permission denied")
+
+// ErrAddressBookNotEmpty is returned when an address book holding contacts, live or in the trash,
+// is deleted. Its contacts must be moved or deleted first, so that they go through the trash.
+var ErrAddressBookNotEmpty = errors.New("the address book still holds contacts, move or delete them and let the trash be emptied first")
+
+// String returns the name of the permission level.
+func (p Permission) String() string {
+	switch p {
+	case PermissionViewer:
+		return "viewer"
+	case PermissionEditor:
+		return "editor"
+	case PermissionOwner:
+		return "owner"
+	default:
+		return "none"
+	}
+}
+
+// ParsePermission returns the permission level with the given name.
+func ParsePermission(name string) (Permission, error) {
+	switch name {
+	case "viewer":
+		return PermissionViewer, nil
+	case "editor":
+		return PermissionEditor, nil
+	case "owner":
+		return PermissionOwner, nil
+	default:
+		return PermissionNone, errors.New("permission must be one of viewer, editor or owner")
+	}
+}
+
+// MarshalText encodes the permission as its name.
+func (p Permission) MarshalText() ([]byte, error) {
+	return []byte(p.String()), nil
+}
+
+type AddressBook struct {
+	ID         int
+	UserID     int
+	Name       string
+	Permission Permission
+}
+
+// AddressBookSortFields are the fields a page of address books can be sorted by.
+var AddressBookSortFields = []string{"id", "name"}
+
+// SortKey returns the position of the address book in a list sorted by the given field.
+func (b *AddressBook) SortKey(field string) SortKey {
+	if field == "name" {
+		return SortKey{Value: b.Name, ID: b.ID}
+	}
+
+	return SortKey{ID: b.ID}
+}
+
+// AddressBookShare grants a user, or every user holding a role, a permission on an address book.
+// Exactly one of UserID and RoleID is set.
+type AddressBookShare struct {
+	AddressBookID int
+	UserID        int
+	RoleID        int
+	Permission    Permission
+}
+
+type AddressBookRepository interface {
+	Create(context.Context, *AddressBook) error
+	Get(context.Context, int) (*AddressBook, error)
+	Update(context.Context, *AddressBook) error
+	Delete(context.Context, int) error
+	GetAllForUser(ctx context.Context, userID int) ([]*AddressBook, error)
+	GetPermission(ctx context.Context, addressBookID, userID int) (Permission, error)
+	Share(context.Context, *AddressBookShare) error
+	Unshare(context.Context, *AddressBookShare) error
+	GetShares(ctx context.Context, addressBookID int) ([]*AddressBookShare, error)
+}
+
+// addressBookPermissionsQuery selects (address_book_id, permission) pairs granted to a user,
+// either by owning the book, by a direct share or by a share to one of the user's unexpired,
+// not deleted roles. It takes the user id three times.
+const addressBookPermissionsQuery = `(
+		SELECT address_book_id, 3 AS permission FROM address_books WHERE user_id = ?
+		UNION ALL
+		SELECT address_book_id, permission FROM address_book_shares WHERE user_id = ?
+		UNION ALL
+		SELECT s.address_book_id, s.permission FROM address_book_shares s
+		JOIN user_roles ur ON s.role_id = ur.role_id
+		JOIN roles r ON ur.role_id = r.role_id AND r.deleted_date IS NULL
+		WHERE ur.user_id = ? AND (ur.expiry_date IS NULL OR ur.expiry_date > CURRENT_TIMESTAMP)
+	)`
+
+type addressBookRepository struct {
+	db *DB
+}
+
+// NewAddressBookRepository creates a new AddressBookRepository using the provided database connection.
+func NewAddressBookRepository(db *DB) AddressBookRepository {
+	return &addressBookRepository{db: db}
+}
+
+// Create inserts a new address book owned by b.UserID and sets its ID.
+func (r *addressBookRepository) Create(ctx context.Context, b *AddressBook) error {
+	query := "INSERT INTO address_books (user_id, book_name, created_date, updated_date) VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)"
+	id, err := r.db.Insert(ctx, "address_book_id", query, b.UserID, b.Name)
+	if err != nil {
+		return err
+	}
+
+	b.ID = id
+	b.Permission = PermissionOwner
+
+	return nil
+}
+
+// Get retrieves an address book by ID. The returned book carries no permission.
+func (r *addressBookRepository) Get(ctx context.Context, id int) (*AddressBook, error) {
+	query := "SELECT address_book_id, user_id, book_name FROM address_books WHERE address_book_id = ?"
+	row := r.db.QueryRow(ctx, query, id)
+	b := &AddressBook{}
+	err := row.Scan(&b.ID, &b.UserID, &b.Name)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid address book id")
+		}
+		return nil, err
+	}
+	return b, nil
+}
+
+// Update renames an existing address book.
+func (r *addressBookRepository) Update(ctx context.Context, b *AddressBook) error {
+	query := "UPDATE address_books SET book_name = ?, updated_date = CURRENT_TIMESTAMP WHERE address_book_id = ?"
+	result, err := r.db.Exec(ctx, query, b.Name, b.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return nil
+}
+
+// Delete removes an address book by ID together with its shares. It fails with
+// ErrAddressBookNotEmpty while the book holds contacts, which the database would otherwise delete
+// with it, bypassing the trash, the history and the sync of their removal.
+func (r *addressBookRepository) Delete(ctx context.Context, id int) error {
+	// the condition is repeated in the delete so that a contact added meanwhile is not cascaded
+	query := `DELETE FROM address_books WHERE address_book_id = ?
+		AND NOT EXISTS (SELECT 1 FROM contacts WHERE address_book_id = ?)`
+	result, err := r.db.Exec(ctx, query, id, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		var exists bool
+		query = "SELECT EXISTS (SELECT 1 FROM address_books WHERE address_book_id = ?)"
+		if err := r.db.QueryRow(ctx, query, id).Scan(&exists); err != nil {
+			return err
+		}
+		if exists {
+			return ErrAddressBookNotEmpty
+		}
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return nil
+}
+
+// GetAllForUser retrieves every address book a user can see, each with the user's effective permission.
+func (r *addressBookRepository) GetAllForUser(ctx context.Context, userID int) ([]*AddressBook, error) {
+	query := `SELECT b.address_book_id, b.user_id, b.book_name, MAX(p.permission)
+		FROM address_books b
+		JOIN ` + addressBookPermissionsQuery + ` p ON b.address_book_id = p.address_book_id
+		GROUP BY b.address_book_id, b.user_id, b.book_name
+		ORDER BY b.book_name`
+	rows, err := r.db.Query(ctx, query, userID, userID, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	books := []*AddressBook{}
+
+	for rows.Next() {
+		b := &AddressBook{}
+		err := rows.Scan(&b.ID, &b.UserID, &b.Name, &b.Permission)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, b)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return books, nil
+}
+
+// GetPermission returns the highest permission a user holds on an address book.
+func (r *addressBookRepository) GetPermission(ctx context.Context, addressBookID, userID int) (Permission, error) {
+	query := "SELECT COALESCE(MAX(p.permission), 0) FROM " + addressBookPermissionsQuery + " p WHERE p.address_book_id = ?"
+	row := r.db.QueryRow(ctx, query, userID, userID, userID, addressBookID)
+	var permission Permission
+	err := row.Scan(&permission)
+	if err != nil {
+		return PermissionNone, err
+	}
+	return permission, nil
+}
+
+// Share grants or changes the permission of a user or role on an address book.
+func (r *addressBookRepository) Share(ctx context.Context, s *AddressBookShare) error {
+	if (s.UserID == 0) == (s.RoleID == 0) {
+		return errors.New("exactly one of user id and role id must be set")
+	}
+
+	query := `INSERT INTO address_book_shares (address_book_id, user_id, role_id, permission, created_date, updated_date)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+	key := []string{"address_book_id", "user_id"}
+	if s.RoleID != 0 {
+		key = []string{"address_book_id", "role_id"}
+	}
+	query = r.db.Dialect.Upsert(query, key, "permission", "updated_date")
+	_, err := r.db.Exec(ctx, query, s.AddressBookID, nullableID(s.UserID), nullableID(s.RoleID), s.Permission)
+	return err
+}
+
+// Unshare revokes the share of a user or role on an address book.
+func (r *addressBookRepository) Unshare(ctx context.Context, s *AddressBookShare) error {
+	query := "DELETE FROM address_book_shares WHERE address_book_id = ? AND user_id = ?"
+	id := s.UserID
+	if s.RoleID != 0 {
+		query = "DELETE FROM address_book_shares WHERE address_book_id = ? AND role_id = ?"
+		id = s.RoleID
+	}
+
+	result, err := r.db.Exec(ctx, query, s.AddressBookID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return nil
+}
+
+// GetShares retrieves all shares of an address book.
+func (r *addressBookRepository) GetShares(ctx context.Context, addressBookID int) ([]*AddressBookShare, error) {
+	query := "SELECT address_book_id, user_id, role_id, permission FROM address_book_shares WHERE address_book_id = ?"
+	rows, err := r.db.Query(ctx, query, addressBookID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	shares := []*AddressBookShare{}
+
+	for rows.Next() {
+		s := &AddressBookShare{}
+		var userID, roleID sql.NullInt64
+		err := rows.Scan(&s.AddressBookID, &userID, &roleID, &s.Permission)
+		if err != nil {
+			return nil, err
+		}
+		s.UserID = int(userID.Int64)
+		s.RoleID = int(roleID.Int64)
+		shares = append(shares, s)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return shares, nil
+}
@@ -0,0 +1,162 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Kinds of attachment. A contact has at most one avatar.
+const (
+	AttachmentAvatar   = "avatar"
+	AttachmentDocument = "document"
+)
+
+// Attachment is a file stored for a contact. The content lives in a blob store under BlobKey;
+// avatars also have a thumbnail under ThumbnailKey. UserID is the user who uploaded it.
+type Attachment struct {
+	ID           int
+	ContactID    int
+	UserID       int
+	Kind         string
+	FileName     string
+	ContentType  string
+	Size         int64
+	BlobKey      string
+	ThumbnailKey string
+	CreatedDate  time.Time
+}
+
+type AttachmentRepository interface {
+	Create(context.Context, *Attachment) error
+	Get(context.Context, int) (*Attachment, error)
+	Delete(context.Context, int) error
+	GetAll(ctx context.Context, contactID int) ([]*Attachment, error)
+	GetAvatar(ctx context.Context, contactID int) (*Attachment, error)
+	GetPurgeable(ctx context.Context, before time.Time) ([]*Attachment, error)
+}
+
+const attachmentColumns = `a.attachment_id, a.contact_id, a.user_id, a.attachment_kind, a.file_name, a.content_type, a.size,
+		a.blob_key, a.thumbnail_key, a.created_date`
+
+type attachmentRepository struct {
+	db *DB
+}
+
+// NewAttachmentRepository creates a new AttachmentRepository using the provided database connection.
+func NewAttachmentRepository(db *DB) AttachmentRepository {
+	return &attachmentRepository{db: db}
+}
+
+// Create inserts a new attachment and sets its ID and creation date.
+func (r *attachmentRepository) Create(ctx context.Context, a *Attachment) error {
+	query := `INSERT INTO attachments (contact_id, user_id, attachment_kind, file_name, content_type, size, blob_key, thumbnail_key, created_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	id, err := r.db.Insert(ctx, "attachment_id", query, a.ContactID, a.UserID, a.Kind, a.FileName, a.ContentType, a.Size, a.BlobKey, a.ThumbnailKey)
+	if err != nil {
+		return err
+	}
+
+	a.ID = id
+	a.CreatedDate = time.Now()
+
+	return nil
+}
+
+// Get retrieves an attachment by ID.
+func (r *attachmentRepository) Get(ctx context.Context, id int) (*Attachment, error) {
+	query := "SELECT " + attachmentColumns + " FROM attachments a WHERE a.attachment_id = ?"
+	a, err := scanAttachment(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid attachment id")
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+// Delete removes an attachment by ID. The blobs are left to the caller.
+func (r *attachmentRepository) Delete(ctx context.Context, id int) error {
+	query := "DELETE FROM attachments WHERE attachment_id = ?"
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return nil
+}
+
+// GetAll retrieves the attachments of a contact, newest first.
+func (r *attachmentRepository) GetAll(ctx context.Context, contactID int) ([]*Attachment, error) {
+	query := "SELECT " + attachmentColumns + " FROM attachments a WHERE a.contact_id = ? ORDER BY a.created_date DESC, a.attachment_id DESC"
+	return r.query(ctx, query, contactID)
+}
+
+// GetAvatar retrieves the avatar of a contact.
+func (r *attachmentRepository) GetAvatar(ctx context.Context, contactID int) (*Attachment, error) {
+	query := "SELECT " + attachmentColumns + " FROM attachments a WHERE a.contact_id = ? AND a.attachment_kind = ? ORDER BY a.attachment_id DESC LIMIT 1"
+	a, err := scanAttachment(r.db.QueryRow(ctx, query, contactID, AttachmentAvatar))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("contact has no avatar")
+		}
+		return nil, err
+	}
+	return a, nil
+}
+
+// GetPurgeable retrieves the attachments of contacts that no longer exist or were soft-deleted
+// before the given time.
+func (r *attachmentRepository) GetPurgeable(ctx context.Context, before time.Time) ([]*Attachment, error) {
+	query := "SELECT " + attachmentColumns + ` FROM attachments a
+		LEFT JOIN contacts c ON a.contact_id = c.contact_id
+		WHERE c.contact_id IS NULL OR c.deleted_date < ?
+		ORDER BY a.attachment_id`
+	return r.query(ctx, query, before)
+}
+
+func (r *attachmentRepository) query(ctx context.Context, query string, args ...interface{}) ([]*Attachment, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	attachments := []*Attachment{}
+
+	for rows.Next() {
+		a, err := scanAttachment(rows)
+		if err != nil {
+			return nil, err
+		}
+		attachments = append(attachments, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return attachments, nil
+}
+
+func scanAttachment(row rowScanner) (*Attachment, error) {
+	a := &Attachment{}
+	err := row.Scan(&a.ID, &a.ContactID, &a.UserID, &a.Kind, &a.FileName, &a.ContentType, &a.Size,
+		&a.BlobKey, &a.ThumbnailKey, &a.CreatedDate)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
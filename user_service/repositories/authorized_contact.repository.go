@@ -0,0 +1,150 @@
+package repositories
+
+import (
+	"context"
+	"errors"
+)
+
+// authorizedContactRepository is a ContactRepository acting on behalf of a single user.
+// Personal contacts, those outside of any address book, are only visible to their owner;
+// contacts in an address book require viewer permission to read and editor permission to
+// write.
+type authorizedContactRepository struct {
+	ContactRepository
+	books  AddressBookRepository
+	userID int
+}
+
+// NewAuthorizedContactRepository wraps repo so that every read and write is checked against
+// the address book permissions of userID. Operations that are not allowed fail with
+// ErrPermissionDenied.
+func NewAuthorizedContactRepository(repo ContactRepository, books AddressBookRepository, userID int) ContactRepository {
+	return &authorizedContactRepository{ContactRepository: repo, books: books, userID: userID}
+}
+
+// require checks that the user holds at least the given permission on a contact.
+func (r *authorizedContactRepository) require(ctx context.Context, c *Contact, permission Permission) error {
+	if c.AddressBookID == 0 {
+		if c.UserID != r.userID {
+			return ErrPermissionDenied
+		}
+		return nil
+	}
+
+	granted, err := r.books.GetPermission(ctx, c.AddressBookID, r.userID)
+	if err != nil {
+		return err
+	}
+
+	if granted < permission {
+		return ErrPermissionDenied
+	}
+
+	return nil
+}
+
+func (r *authorizedContactRepository) Create(ctx context.Context, c *Contact) error {
+	c.UserID = r.userID
+	if err := r.require(ctx, c, PermissionEditor); err != nil {
+		return err
+	}
+
+	return r.ContactRepository.Create(ctx, c)
+}
+
+func (r *authorizedContactRepository) Get(ctx context.Context, id int) (*Contact, error) {
+	c, err := r.ContactRepository.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.require(ctx, c, PermissionViewer); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (r *authorizedContactRepository) Update(ctx context.Context, c *Contact) error {
+	return r.update(ctx, c, r.ContactRepository.Update)
+}
+
+func (r *authorizedContactRepository) Patch(ctx context.Context, c *Contact, fields []string) error {
+	return r.update(ctx, c, func(ctx context.Context, c *Contact) error {
+		return r.ContactRepository.Patch(ctx, c, fields)
+	})
+}
+
+// update writes c with write once the user may edit it, and may move it into its address book.
+func (r *authorizedContactRepository) update(ctx context.Context, c *Contact, write func(context.Context, *Contact) error) error {
+	existing, err := r.ContactRepository.Get(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := r.require(ctx, existing, PermissionEditor); err != nil {
+		return err
+	}
+
+	// moving a contact into another address book needs write access on the target too. The
+	// contact keeps its owner, so only the owner may move it into the personal contacts, which
+	// are the owner's
+	c.UserID = existing.UserID
+	if c.AddressBookID != existing.AddressBookID {
+		if err := r.require(ctx, &Contact{UserID: existing.UserID, AddressBookID: c.AddressBookID}, PermissionEditor); err != nil {
+			return err
+		}
+	}
+
+	return write(ctx, c)
+}
+
+func (r *authorizedContactRepository) Delete(ctx context.Context, id, deletedBy int) error {
+	existing, err := r.ContactRepository.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.require(ctx, existing, PermissionEditor); err != nil {
+		return err
+	}
+
+	return r.ContactRepository.Delete(ctx, id, deletedBy)
+}
+
+func (r *authorizedContactRepository) Restore(ctx context.Context, id int) error {
+	deleted, err := r.ContactRepository.GetDeleted(ctx, &ContactFilter{ContactIDs: []int{id}})
+	if err != nil {
+		return err
+	}
+
+	if len(deleted) == 0 {
+		return errors.New("no deleted contact found with the given id")
+	}
+
+	if err := r.require(ctx, &deleted[0].Contact, PermissionEditor); err != nil {
+		return err
+	}
+
+	return r.ContactRepository.Restore(ctx, id)
+}
+
+func (r *authorizedContactRepository) GetAll(ctx context.Context, filter *ContactFilter) ([]*Contact, error) {
+	f := ContactFilter{}
+	if filter != nil {
+		f = *filter
+	}
+	f.ReadableBy = r.userID
+
+	return r.ContactRepository.GetAll(ctx, &f)
+}
+
+func (r *authorizedContactRepository) GetDeleted(ctx context.Context, filter *ContactFilter) ([]*DeletedContact, error) {
+	f := ContactFilter{}
+	if filter != nil {
+		f = *filter
+	}
+	f.ReadableBy = r.userID
+
+	return r.ContactRepository.GetDeleted(ctx, &f)
+}
@@ -0,0 +1,353 @@
+package repositories
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Bulk operations on contacts.
+const (
+	BulkTag    = "tag"
+	BulkUntag  = "untag"
+	BulkMove   = "move"
+	BulkDelete = "delete"
+	BulkExport = "export"
+)
+
+// States of a bulk job. Queued and running jobs are active, the others are final.
+const (
+	BulkJobQueued    = "queued"
+	BulkJobRunning   = "running"
+	BulkJobCompleted = "completed"
+	BulkJobCancelled = "cancelled"
+	BulkJobFailed    = "failed"
+)
+
+// bulkJobInsertBatch is the number of items inserted per statement when a job is created.
+const bulkJobInsertBatch = 500
+
+// BulkJob is a bulk operation on a list of contacts, processed in the background on behalf of
+// UserID. Tag is the tag of tag and untag jobs, AddressBookID the target of move jobs, zero
+// meaning the user's personal contacts. Processed is the checkpoint: the items before it have
+// been handled, Failed of them unsuccessfully. Result is the blob key of an export's file.
+type BulkJob struct {
+	ID              int
+	UserID          int
+	Operation       string
+	Tag             string
+	AddressBookID   int
+	Status          string
+	Total           int
+	Processed       int
+	Failed          int
+	Result          string
+	Error           string
+	CancelRequested bool
+	CreatedDate     time.Time
+	UpdatedDate     time.Time
+	ContactIDs      []int             `json:"-"`
+	Failures        []*BulkJobFailure `json:",omitempty"`
+}
+
+// BulkJobSortFields are the fields a page of bulk jobs can be sorted by.
+var BulkJobSortFields = []string{"id", "operation", "status"}
+
+// SortKey returns the position of the bulk job in a list sorted by the given field.
+func (j *BulkJob) SortKey(field string) SortKey {
+	switch field {
+	case "operation":
+		return SortKey{Value: j.Operation, ID: j.ID}
+	case "status":
+		return SortKey{Value: j.Status, ID: j.ID}
+	}
+
+	return SortKey{ID: j.ID}
+}
+
+// BulkJobFailure is an item of a bulk job that could not be processed.
+type BulkJobFailure struct {
+	ContactID int
+	Error     string
+}
+
+type BulkJobRepository interface {
+	Create(context.Context, *BulkJob) error
+	Get(context.Context, int) (*BulkJob, error)
+	GetAll(ctx context.Context, userID int) ([]*BulkJob, error)
+	Claim(ctx context.Context, staleBefore time.Time) (*BulkJob, error)
+	GetItems(ctx context.Context, jobID, from, limit int) ([]int, error)
+	RecordFailures(ctx context.Context, jobID int, failures map[int]string) error
+	GetFailures(ctx context.Context, jobID, limit int) ([]*BulkJobFailure, error)
+	Checkpoint(ctx context.Context, jobID, processed, failed int) (cancelRequested bool, err error)
+	Finish(ctx context.Context, jobID int, status, result, message string) error
+	Cancel(ctx context.Context, jobID int) error
+}
+
+const bulkJobColumns = `job_id, user_id, operation, tag_name, address_book_id, status, total, processed, failed, result,
+		error_message, cancel_requested, created_date, updated_date`
+
+type bulkJobRepository struct {
+	db *DB
+}
+
+// NewBulkJobRepository creates a new BulkJobRepository using the provided database connection.
+func NewBulkJobRepository(db *DB) BulkJobRepository {
+	return &bulkJobRepository{db: db}
+}
+
+// Create queues a new job for j.ContactIDs and sets its ID, status and total.
+func (r *bulkJobRepository) Create(ctx context.Context, j *BulkJob) error {
+	if len(j.ContactIDs) == 0 {
+		return errors.New("a bulk job needs at least one contact")
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `INSERT INTO bulk_jobs (user_id, operation, tag_name, address_book_id, status, total, created_date, updated_date)
+		VALUES (?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+	id, err := tx.Insert(ctx, "job_id", query, j.UserID, j.Operation, j.Tag, nullableID(j.AddressBookID), BulkJobQueued, len(j.ContactIDs))
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(j.ContactIDs); start += bulkJobInsertBatch {
+		end := start + bulkJobInsertBatch
+		if end > len(j.ContactIDs) {
+			end = len(j.ContactIDs)
+		}
+
+		values := ""
+		args := []interface{}{}
+		for position := start; position < end; position++ {
+			if values != "" {
+				values += ", "
+			}
+			values += "(?, ?, ?)"
+			args = append(args, id, position, j.ContactIDs[position])
+		}
+
+		if _, err := tx.Exec(ctx, "INSERT INTO bulk_job_items (job_id, position, contact_id) VALUES "+values, args...); err != nil {
+			return err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	j.ID = id
+	j.Status = BulkJobQueued
+	j.Total = len(j.ContactIDs)
+
+	return nil
+}
+
+// Get retrieves a job by ID, without its items.
+func (r *bulkJobRepository) Get(ctx context.Context, id int) (*BulkJob, error) {
+	query := "SELECT " + bulkJobColumns + " FROM bulk_jobs WHERE job_id = ?"
+	j, err := scanBulkJob(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid bulk job id")
+		}
+		return nil, err
+	}
+	return j, nil
+}
+
+// GetAll retrieves the jobs of a user, newest first.
+func (r *bulkJobRepository) GetAll(ctx context.Context, userID int) ([]*BulkJob, error) {
+	query := "SELECT " + bulkJobColumns + " FROM bulk_jobs WHERE user_id = ? ORDER BY job_id DESC"
+	rows, err := r.db.Query(ctx, query, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	jobs := []*BulkJob{}
+
+	for rows.Next() {
+		j, err := scanBulkJob(rows)
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return jobs, nil
+}
+
+// Claim marks the oldest queued job as running and returns it, or nil when there is none. Running
+// jobs whose last checkpoint is older than staleBefore belong to a worker that died; they are
+// claimed again and resume from their checkpoint. The claim is a single UPDATE so that concurrent
+// workers never claim the same job; the UPDATE checks the status again, so a worker that loses
+// the race for the oldest job claims nothing this time.
+func (r *bulkJobRepository) Claim(ctx context.Context, staleBefore time.Time) (*BulkJob, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return nil, err
+	}
+	token := hex.EncodeToString(b)
+
+	// MySQL does not allow a subquery on the updated table, hence the derived table
+	claimable := "(status = ? OR (status = ? AND heartbeat_date < ?))"
+	query := `UPDATE bulk_jobs SET status = ?, claim_token = ?, heartbeat_date = CURRENT_TIMESTAMP, updated_date = CURRENT_TIMESTAMP
+		WHERE job_id = (
+			SELECT job_id FROM (
+				SELECT job_id FROM bulk_jobs WHERE ` + claimable + ` ORDER BY job_id LIMIT 1
+			) next_job
+		) AND ` + claimable
+	result, err := r.db.Exec(ctx, query, BulkJobRunning, token, BulkJobQueued, BulkJobRunning, staleBefore,
+		BulkJobQueued, BulkJobRunning, staleBefore)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+
+	if rowsAffected == 0 {
+		return nil, nil
+	}
+
+	return scanBulkJob(r.db.QueryRow(ctx, "SELECT "+bulkJobColumns+" FROM bulk_jobs WHERE claim_token = ?", token))
+}
+
+// GetItems retrieves the contact IDs of up to limit items of a job, starting at position from.
+func (r *bulkJobRepository) GetItems(ctx context.Context, jobID, from, limit int) ([]int, error) {
+	query := "SELECT contact_id FROM bulk_job_items WHERE job_id = ? AND position >= ? ORDER BY position LIMIT ?"
+	rows, err := r.db.Query(ctx, query, jobID, from, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	ids := []int{}
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return ids, nil
+}
+
+// RecordFailures stores the errors of failed items, keyed by item position.
+func (r *bulkJobRepository) RecordFailures(ctx context.Context, jobID int, failures map[int]string) error {
+	query := "UPDATE bulk_job_items SET error_message = ? WHERE job_id = ? AND position = ?"
+	for position, message := range failures {
+		if _, err := r.db.Exec(ctx, query, message, jobID, position); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// GetFailures retrieves up to limit failed items of a job in item order.
+func (r *bulkJobRepository) GetFailures(ctx context.Context, jobID, limit int) ([]*BulkJobFailure, error) {
+	query := `SELECT contact_id, error_message FROM bulk_job_items
+		WHERE job_id = ? AND error_message IS NOT NULL ORDER BY position LIMIT ?`
+	rows, err := r.db.Query(ctx, query, jobID, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	failures := []*BulkJobFailure{}
+
+	for rows.Next() {
+		f := &BulkJobFailure{}
+		if err := rows.Scan(&f.ContactID, &f.Error); err != nil {
+			return nil, err
+		}
+		failures = append(failures, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return failures, nil
+}
+
+// Checkpoint records the progress of a running job, refreshes its heartbeat and reports whether
+// its cancellation was requested.
+func (r *bulkJobRepository) Checkpoint(ctx context.Context, jobID, processed, failed int) (bool, error) {
+	query := "UPDATE bulk_jobs SET processed = ?, failed = ?, heartbeat_date = CURRENT_TIMESTAMP, updated_date = CURRENT_TIMESTAMP WHERE job_id = ?"
+	if _, err := r.db.Exec(ctx, query, processed, failed, jobID); err != nil {
+		return false, err
+	}
+
+	var cancelRequested bool
+	err := r.db.QueryRow(ctx, "SELECT cancel_requested FROM bulk_jobs WHERE job_id = ?", jobID).Scan(&cancelRequested)
+	return cancelRequested, err
+}
+
+// Finish moves a job into a final state, recording the result of an export or the error of a
+// failed job.
+func (r *bulkJobRepository) Finish(ctx context.Context, jobID int, status, result, message string) error {
+	query := "UPDATE bulk_jobs SET status = ?, result = ?, error_message = ?, claim_token = NULL, updated_date = CURRENT_TIMESTAMP WHERE job_id = ?"
+	_, err := r.db.Exec(ctx, query, status, result, message, jobID)
+	return err
+}
+
+// Cancel requests the cancellation of an active job. Queued jobs are cancelled right away,
+// running jobs stop at their next checkpoint.
+func (r *bulkJobRepository) Cancel(ctx context.Context, jobID int) error {
+	query := fmt.Sprintf(`UPDATE bulk_jobs SET cancel_requested = TRUE, status = CASE WHEN status = '%s' THEN '%s' ELSE status END, updated_date = CURRENT_TIMESTAMP
+		WHERE job_id = ? AND status IN ('%s', '%s')`, BulkJobQueued, BulkJobCancelled, BulkJobQueued, BulkJobRunning)
+	result, err := r.db.Exec(ctx, query, jobID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("only queued or running jobs can be cancelled")
+	}
+
+	return nil
+}
+
+func scanBulkJob(row rowScanner) (*BulkJob, error) {
+	j := &BulkJob{}
+	var addressBookID sql.NullInt64
+	err := row.Scan(&j.ID, &j.UserID, &j.Operation, &j.Tag, &addressBookID, &j.Status, &j.Total, &j.Processed, &j.Failed,
+		&j.Result, &j.Error, &j.CancelRequested, &j.CreatedDate, &j.UpdatedDate)
+	if err != nil {
+		return nil, err
+	}
+	j.AddressBookID = int(addressBookID.Int64)
+	return j, nil
+}
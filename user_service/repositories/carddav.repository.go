@@ -0,0 +1,199 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// CardDAVObject maps a contact to the resource name and vCard UID a CardDAV client stored it
+// under. Contacts created outside of CardDAV have no object until a client writes them.
+// Properties holds the unfolded content lines of the client's vCard that the contact does not
+// model, separated by CRLF, so that they are served back with the contact's fields.
+type CardDAVObject struct {
+	ContactID  int
+	Name       string
+	UID        string
+	Properties string
+}
+
+// CardDAVTombstone records that a contact was removed from a collection: the address book of
+// AddressBookID, or the personal contacts of UserID when AddressBookID is 0. Name is the resource
+// name of the contact's object, or empty when it had none.
+type CardDAVTombstone struct {
+	UserID        int
+	AddressBookID int
+	ContactID     int
+	Name          string
+	DeletedDate   time.Time
+}
+
+type CardDAVRepository interface {
+	Save(context.Context, *CardDAVObject) error
+	Get(ctx context.Context, contactID int) (*CardDAVObject, error)
+	GetAll(ctx context.Context, contactIDs []int) (map[int]*CardDAVObject, error)
+	GetByName(ctx context.Context, name string) ([]*CardDAVObject, error)
+	GetTombstones(ctx context.Context, userID, addressBookID int, since time.Time) ([]*CardDAVTombstone, error)
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+const cardDAVColumns = `contact_id, resource_name, uid, properties`
+
+type cardDAVRepository struct {
+	db *DB
+}
+
+// NewCardDAVRepository creates a new CardDAVRepository using the provided database connection.
+// Tombstones are recorded by the contact and user repositories and kept until the repository
+// is purged, which is done along with the trash.
+func NewCardDAVRepository(db *DB) CardDAVRepository {
+	return &cardDAVRepository{db: db}
+}
+
+// Save inserts or replaces the object of o.ContactID.
+func (r *cardDAVRepository) Save(ctx context.Context, o *CardDAVObject) error {
+	query := `INSERT INTO carddav_objects (contact_id, resource_name, uid, properties, created_date, updated_date)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+	query = r.db.Dialect.Upsert(query, []string{"contact_id"}, "resource_name", "uid", "properties", "updated_date")
+	_, err := r.db.Exec(ctx, query, o.ContactID, o.Name, o.UID, o.Properties)
+	return err
+}
+
+// Get retrieves the object of a contact.
+func (r *cardDAVRepository) Get(ctx context.Context, contactID int) (*CardDAVObject, error) {
+	query := "SELECT " + cardDAVColumns + " FROM carddav_objects WHERE contact_id = ?"
+	o, err := scanCardDAVObject(r.db.QueryRow(ctx, query, contactID))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid contact id")
+		}
+		return nil, err
+	}
+	return o, nil
+}
+
+// GetAll retrieves the objects of the given contacts keyed by contact ID. Contacts without an
+// object are missing from the result.
+func (r *cardDAVRepository) GetAll(ctx context.Context, contactIDs []int) (map[int]*CardDAVObject, error) {
+	objects := map[int]*CardDAVObject{}
+	if len(contactIDs) == 0 {
+		return objects, nil
+	}
+
+	query := fmt.Sprintf("SELECT %s FROM carddav_objects WHERE contact_id IN (%s)", cardDAVColumns, placeholders(len(contactIDs)))
+	all, err := r.query(ctx, query, intArgs(contactIDs)...)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, o := range all {
+		objects[o.ContactID] = o
+	}
+
+	return objects, nil
+}
+
+// GetByName retrieves the objects stored under a resource name. Names are only unique within an
+// address book, so several objects may be returned.
+func (r *cardDAVRepository) GetByName(ctx context.Context, name string) ([]*CardDAVObject, error) {
+	query := "SELECT " + cardDAVColumns + " FROM carddav_objects WHERE resource_name = ?"
+	return r.query(ctx, query, name)
+}
+
+// GetTombstones retrieves the contacts removed at or after since from the address book of
+// addressBookID, or from the personal contacts of userID when addressBookID is 0.
+func (r *cardDAVRepository) GetTombstones(ctx context.Context, userID, addressBookID int, since time.Time) ([]*CardDAVTombstone, error) {
+	query := `SELECT user_id, address_book_id, contact_id, resource_name, deleted_date FROM carddav_tombstones
+		WHERE address_book_id = ? AND deleted_date >= ? ORDER BY deleted_date, tombstone_id`
+	args := []interface{}{addressBookID, since}
+	if addressBookID == 0 {
+		query = `SELECT user_id, address_book_id, contact_id, resource_name, deleted_date FROM carddav_tombstones
+			WHERE address_book_id IS NULL AND user_id = ? AND deleted_date >= ? ORDER BY deleted_date, tombstone_id`
+		args = []interface{}{userID, since}
+	}
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tombstones := []*CardDAVTombstone{}
+
+	for rows.Next() {
+		t := &CardDAVTombstone{}
+		var addressBookID sql.NullInt64
+		var name sql.NullString
+		if err := rows.Scan(&t.UserID, &addressBookID, &t.ContactID, &name, &t.DeletedDate); err != nil {
+			return nil, err
+		}
+		t.AddressBookID, t.Name = int(addressBookID.Int64), name.String
+		tombstones = append(tombstones, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tombstones, nil
+}
+
+// Purge permanently removes the tombstones recorded before the given time and returns how many
+// were removed.
+func (r *cardDAVRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	result, err := r.db.Exec(ctx, "DELETE FROM carddav_tombstones WHERE deleted_date < ?", before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// recordTombstones records the live contacts aliased as c that match where, a condition starting
+// with " AND ", as removed from their collection now. It runs before the change removing them.
+func recordTombstones(ctx context.Context, db *DB, where string, args ...interface{}) error {
+	query := `INSERT INTO carddav_tombstones (user_id, address_book_id, contact_id, resource_name, deleted_date)
+		SELECT c.user_id, c.address_book_id, c.contact_id, o.resource_name, CURRENT_TIMESTAMP
+		FROM contacts c LEFT JOIN carddav_objects o ON o.contact_id = c.contact_id
+		WHERE c.deleted_date IS NULL` + where
+	_, err := db.Exec(ctx, query, args...)
+	return err
+}
+
+func (r *cardDAVRepository) query(ctx context.Context, query string, args ...interface{}) ([]*CardDAVObject, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	objects := []*CardDAVObject{}
+
+	for rows.Next() {
+		o, err := scanCardDAVObject(rows)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return objects, nil
+}
+
+func scanCardDAVObject(row rowScanner) (*CardDAVObject, error) {
+	o := &CardDAVObject{}
+	var properties sql.NullString
+	if err := row.Scan(&o.ContactID, &o.Name, &o.UID, &properties); err != nil {
+		return nil, err
+	}
+	o.Properties = properties.String
+	return o, nil
+}
@@ -0,0 +1,459 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Contact is a person in a user's personal contacts or in an address book. Birthday and
+// Anniversary are dates formatted as 2006-01-02, or as --01-02 when the year is unknown, and
+// empty when not known; see ParseContactDate. LastContacted is the time of the latest call, email
+// or meeting logged for the contact, nil when there was none.
+type Contact struct {
+	ID            int
+	UserID        int
+	AddressBookID int
+	FirstName     string
+	LastName      string
+	EmailID       string
+	Mobile        string
+	Organization  string
+	Notes         string
+	Birthday      string
+	Anniversary   string
+	CustomFields  map[string]string
+	LastContacted *time.Time
+}
+
+// contactDateYear is the year of the contact dates without a year. It is a leap year, so that
+// February 29th is a valid date.
+const contactDateYear = 2000
+
+// ParseContactDate parses the birthday or anniversary of a contact, formatted as 2006-01-02 or
+// as --01-02 when the year is unknown, into the date it was first celebrated at midnight UTC.
+// Dates without a year are placed in a leap year.
+func ParseContactDate(date string) (time.Time, error) {
+	if strings.HasPrefix(date, "--") {
+		date = fmt.Sprintf("%d-%s", contactDateYear, date[2:])
+	}
+
+	t, err := time.Parse("2006-01-02", date)
+	if err != nil {
+		return time.Time{}, errors.New("dates must be formatted as 2006-01-02, or as --01-02 when the year is unknown")
+	}
+
+	return t, nil
+}
+
+// ContactSortFields are the fields a page of contacts can be sorted by.
+var ContactSortFields = []string{"id", "first_name", "last_name", "email_id"}
+
+// SortKey returns the position of the contact in a list sorted by the given field.
+func (c *Contact) SortKey(field string) SortKey {
+	switch field {
+	case "first_name":
+		return SortKey{Value: c.FirstName, ID: c.ID}
+	case "last_name":
+		return SortKey{Value: c.LastName, ID: c.ID}
+	case "email_id":
+		return SortKey{Value: c.EmailID, ID: c.ID}
+	}
+
+	return SortKey{ID: c.ID}
+}
+
+// DeletedContact is a soft-deleted Contact together with its deletion details.
+type DeletedContact struct {
+	Contact
+	DeletionInfo
+}
+
+// ContactFilter narrows down the contacts returned by ContactRepository.GetAll.
+//
+// ContactIDs restricts the result to the given contacts. ReadableBy restricts the result
+// to the personal contacts of that user and the contacts of the address books the user can
+// read. A contact matches GroupIDs when it is a member of at least one of the groups. A
+// contact matches Tags when it carries all of the tags, or any of them when MatchAnyTag is
+// set; the tags are those of ReadableBy, or of UserID when ReadableBy is not set. ChangedSince restricts the result to contacts created, updated, deleted or restored
+// at or after that time. CountryCode, Locality and Within match contacts with an address
+// meeting all three of them. Empty filters match everything.
+type ContactFilter struct {
+	UserID        int
+	ReadableBy    int
+	AddressBookID int
+	ContactIDs    []int
+	GroupIDs      []int
+	Tags          []string
+	MatchAnyTag   bool
+	ChangedSince  time.Time
+	CountryCode   string
+	Locality      string
+	Within        *GeoBounds
+}
+
+type ContactRepository interface {
+	Create(context.Context, *Contact) error
+	Get(context.Context, int) (*Contact, error)
+	Update(context.Context, *Contact) error
+	Patch(ctx context.Context, c *Contact, fields []string) error
+	Delete(ctx context.Context, id, deletedBy int) error
+	GetAll(context.Context, *ContactFilter) ([]*Contact, error)
+	GetDeleted(context.Context, *ContactFilter) ([]*DeletedContact, error)
+	Restore(context.Context, int) error
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+type contactRepository struct {
+	db *DB
+}
+
+// NewContactRepository creates a new ContactRepository using the provided database connection.
+func NewContactRepository(db *DB) ContactRepository {
+	return &contactRepository{db: db}
+}
+
+// Create inserts a new contact into the database and sets its ID.
+func (r *contactRepository) Create(ctx context.Context, c *Contact) error {
+	query := `INSERT INTO contacts (user_id, address_book_id, first_name, last_name, email_id, mobile, organization, notes, birthday, anniversary,
+		created_date, updated_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+	id, err := r.db.Insert(ctx, "contact_id", query, c.UserID, nullableID(c.AddressBookID), c.FirstName, c.LastName, c.EmailID, c.Mobile, c.Organization, c.Notes,
+		nullableText(c.Birthday), nullableText(c.Anniversary))
+	if err != nil {
+		return err
+	}
+
+	c.ID = id
+
+	return nil
+}
+
+// Get retrieves a contact from the database by ID.
+func (r *contactRepository) Get(ctx context.Context, id int) (*Contact, error) {
+	query := `SELECT contact_id, user_id, address_book_id, first_name, last_name, email_id, mobile, organization, notes, birthday, anniversary,
+		last_contacted_date
+		FROM contacts WHERE contact_id = ? AND deleted_date IS NULL`
+	c, err := scanContact(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid contact id")
+		}
+		return nil, err
+	}
+	return c, nil
+}
+
+// Update updates an existing contact in the database. A contact moved into another address book
+// is recorded as removed from the CardDAV collection it leaves.
+func (r *contactRepository) Update(ctx context.Context, c *Contact) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err := recordTombstones(ctx, r.db.in(tx), " AND c.contact_id = ? AND COALESCE(c.address_book_id, 0) <> ?", c.ID, c.AddressBookID); err != nil {
+		return err
+	}
+
+	query := `UPDATE contacts SET address_book_id = ?, first_name = ?, last_name = ?, email_id = ?, mobile = ?, organization = ?, notes = ?,
+		birthday = ?, anniversary = ?, updated_date = CURRENT_TIMESTAMP
+		WHERE contact_id = ? AND deleted_date IS NULL`
+	result, err := tx.Exec(ctx, query, nullableID(c.AddressBookID), c.FirstName, c.LastName, c.EmailID, c.Mobile, c.Organization, c.Notes,
+		nullableText(c.Birthday), nullableText(c.Anniversary), c.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return tx.Commit()
+}
+
+// Patch writes the named fields of a contact, as returned by ChangedContactFields, and leaves the
+// other columns as they are. Custom fields are stored by the repository returned by
+// NewContactStore and skipped here. A contact moved into another address book is
+// recorded as removed from the CardDAV collection it leaves.
+func (r *contactRepository) Patch(ctx context.Context, c *Contact, fields []string) error {
+	standard, _ := splitCustomFields(fields)
+	set, args, err := setFields("contact", standard, c.patchFields())
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	for _, f := range standard {
+		if f == "address_book_id" {
+			if err := recordTombstones(ctx, r.db.in(tx), " AND c.contact_id = ? AND COALESCE(c.address_book_id, 0) <> ?", c.ID, c.AddressBookID); err != nil {
+				return err
+			}
+		}
+	}
+
+	query := "UPDATE contacts SET " + set + "updated_date = CURRENT_TIMESTAMP WHERE contact_id = ? AND deleted_date IS NULL"
+	result, err := tx.Exec(ctx, query, append(args, c.ID)...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return tx.Commit()
+}
+
+// Delete soft-deletes a contact by ID. The contact is kept in the trash until it is restored or
+// purged, and recorded as removed from its CardDAV collection.
+func (r *contactRepository) Delete(ctx context.Context, id, deletedBy int) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	if err := recordTombstones(ctx, r.db.in(tx), " AND c.contact_id = ?", id); err != nil {
+		return err
+	}
+
+	query := "UPDATE contacts SET deleted_date = CURRENT_TIMESTAMP, deleted_by = ?, updated_date = CURRENT_TIMESTAMP WHERE contact_id = ? AND deleted_date IS NULL"
+	result, err := tx.Exec(ctx, query, nullableID(deletedBy), id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return tx.Commit()
+}
+
+// GetAll retrieves the contacts matching the given filter.
+func (r *contactRepository) GetAll(ctx context.Context, filter *ContactFilter) ([]*Contact, error) {
+	where, args := filterContacts(filter)
+	query := `SELECT c.contact_id, c.user_id, c.address_book_id, c.first_name, c.last_name, c.email_id, c.mobile, c.organization, c.notes,
+		c.birthday, c.anniversary, c.last_contacted_date
+		FROM contacts c WHERE c.deleted_date IS NULL` + where + " ORDER BY c.first_name, c.last_name, c.contact_id"
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	contacts := []*Contact{}
+
+	for rows.Next() {
+		c, err := scanContact(rows)
+		if err != nil {
+			return nil, err
+		}
+		contacts = append(contacts, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// GetDeleted retrieves the soft-deleted contacts matching the given filter, most recently deleted first.
+func (r *contactRepository) GetDeleted(ctx context.Context, filter *ContactFilter) ([]*DeletedContact, error) {
+	where, args := filterContacts(filter)
+	query := `SELECT c.contact_id, c.user_id, c.address_book_id, c.first_name, c.last_name, c.email_id, c.mobile, c.organization, c.notes,
+		c.birthday, c.anniversary, c.deleted_date, c.deleted_by
+		FROM contacts c WHERE c.deleted_date IS NOT NULL` + where + " ORDER BY c.deleted_date DESC"
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	contacts := []*DeletedContact{}
+
+	for rows.Next() {
+		c := &DeletedContact{}
+		var addressBookID, deletedBy sql.NullInt64
+		var birthday, anniversary sql.NullString
+		err := rows.Scan(&c.ID, &c.UserID, &addressBookID, &c.FirstName, &c.LastName, &c.EmailID, &c.Mobile, &c.Organization, &c.Notes,
+			&birthday, &anniversary, &c.DeletedDate, &deletedBy)
+		if err != nil {
+			return nil, err
+		}
+		c.AddressBookID = int(addressBookID.Int64)
+		c.Birthday, c.Anniversary = birthday.String, anniversary.String
+		c.DeletedBy = int(deletedBy.Int64)
+		contacts = append(contacts, c)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// Restore moves a soft-deleted contact out of the trash.
+func (r *contactRepository) Restore(ctx context.Context, id int) error {
+	query := "UPDATE contacts SET deleted_date = NULL, deleted_by = NULL, updated_date = CURRENT_TIMESTAMP WHERE contact_id = ? AND deleted_date IS NOT NULL"
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no deleted contact found with the given id")
+	}
+
+	return nil
+}
+
+// Purge permanently removes the contacts soft-deleted before the given time and returns how many were removed.
+func (r *contactRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	query := "DELETE FROM contacts WHERE deleted_date IS NOT NULL AND deleted_date < ?"
+	result, err := r.db.Exec(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// tagOwner returns the user whose tags the filter names: ReadableBy, or UserID when it is not set.
+func (f *ContactFilter) tagOwner() int {
+	if f.ReadableBy != 0 {
+		return f.ReadableBy
+	}
+
+	return f.UserID
+}
+
+// filterContacts builds the conditions and arguments selecting the contacts aliased as c that
+// match the filter. The conditions start with " AND " so they can be appended to a WHERE clause.
+func filterContacts(filter *ContactFilter) (string, []interface{}) {
+	if filter == nil {
+		filter = &ContactFilter{}
+	}
+
+	query := ""
+	args := []interface{}{}
+
+	if filter.UserID != 0 {
+		query += " AND c.user_id = ?"
+		args = append(args, filter.UserID)
+	}
+
+	if filter.ReadableBy != 0 {
+		query += " AND ((c.address_book_id IS NULL AND c.user_id = ?) OR c.address_book_id IN (SELECT p.address_book_id FROM " + addressBookPermissionsQuery + " p))"
+		args = append(args, filter.ReadableBy, filter.ReadableBy, filter.ReadableBy, filter.ReadableBy)
+	}
+
+	if filter.AddressBookID != 0 {
+		query += " AND c.address_book_id = ?"
+		args = append(args, filter.AddressBookID)
+	}
+
+	if len(filter.ContactIDs) > 0 {
+		query += fmt.Sprintf(" AND c.contact_id IN (%s)", placeholders(len(filter.ContactIDs)))
+		args = append(args, intArgs(filter.ContactIDs)...)
+	}
+
+	if !filter.ChangedSince.IsZero() {
+		query += " AND c.updated_date >= ?"
+		args = append(args, filter.ChangedSince)
+	}
+
+	if len(filter.GroupIDs) > 0 {
+		query += fmt.Sprintf(" AND c.contact_id IN (SELECT gm.contact_id FROM contact_group_members gm WHERE gm.group_id IN (%s))",
+			placeholders(len(filter.GroupIDs)))
+		args = append(args, intArgs(filter.GroupIDs)...)
+	}
+
+	if tags := normalizeTagNames(filter.Tags); len(tags) > 0 {
+		sub := fmt.Sprintf(`SELECT ct.contact_id FROM contact_tags ct
+			JOIN tags t ON ct.tag_id = t.tag_id
+			WHERE t.tag_name IN (%s)`, placeholders(len(tags)))
+		for _, tag := range tags {
+			args = append(args, tag)
+		}
+
+		// tags are named per user, so only the tags of the user filtering are matched
+		if owner := filter.tagOwner(); owner != 0 {
+			sub += " AND t.user_id = ?"
+			args = append(args, owner)
+		}
+
+		if !filter.MatchAnyTag {
+			sub += " GROUP BY ct.contact_id HAVING COUNT(DISTINCT t.tag_id) = ?"
+			args = append(args, len(tags))
+		}
+
+		query += " AND c.contact_id IN (" + sub + ")"
+	}
+
+	addressQuery, addressArgs := filterAddresses(filter)
+	query += addressQuery
+	args = append(args, addressArgs...)
+
+	return query, args
+}
+
+// scanContact scans a row selected with the contact columns into a Contact.
+func scanContact(row rowScanner) (*Contact, error) {
+	c := &Contact{}
+	var addressBookID sql.NullInt64
+	var birthday, anniversary sql.NullString
+	var lastContacted sql.NullTime
+	err := row.Scan(&c.ID, &c.UserID, &addressBookID, &c.FirstName, &c.LastName, &c.EmailID, &c.Mobile, &c.Organization, &c.Notes,
+		&birthday, &anniversary, &lastContacted)
+	if err != nil {
+		return nil, err
+	}
+
+	c.AddressBookID = int(addressBookID.Int64)
+	c.Birthday, c.Anniversary = birthday.String, anniversary.String
+	if lastContacted.Valid {
+		c.LastContacted = &lastContacted.Time
+	}
+
+	return c, nil
+}
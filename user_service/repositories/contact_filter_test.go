@@ -0,0 +1,85 @@
+package repositories_test
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+
+	"github.com/princeparmar/contact_manager/migrations"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// TestContactFilterTags checks that a tag filter only matches the tags of the user filtering,
+// not the tags of the same name other users put on the contact.
+func TestContactFilterTags(t *testing.T) {
+	ctx := context.Background()
+	db, err := repositories.Open("sqlite", filepath.Join(t.TempDir(), "contacts.db")+"?_foreign_keys=on")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	db.SetMaxOpenConns(1)
+
+	runner, err := migrations.NewRunner(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	users := repositories.NewUserRepository(db)
+	ada := &repositories.User{UserName: "ada", Mobile: "5550100", EmailID: "ada@example.com"}
+	bob := &repositories.User{UserName: "bob", Mobile: "5550101", EmailID: "bob@example.com"}
+	for _, u := range []*repositories.User{ada, bob} {
+		if err := users.Create(ctx, u); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	contacts := repositories.NewContactRepository(db)
+	tagged, untagged := &repositories.Contact{UserID: ada.ID, FirstName: "Tagged"}, &repositories.Contact{UserID: ada.ID, FirstName: "Untagged"}
+	for _, c := range []*repositories.Contact{tagged, untagged} {
+		if err := contacts.Create(ctx, c); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// bob's "vip" tag is on both contacts, ada's only on the first
+	tags := repositories.NewTagRepository(db)
+	adaVIP, bobVIP := &repositories.Tag{UserID: ada.ID, Name: "vip"}, &repositories.Tag{UserID: bob.ID, Name: "vip"}
+	for _, tag := range []*repositories.Tag{adaVIP, bobVIP} {
+		if err := tags.Create(ctx, tag); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tags.AddContacts(ctx, adaVIP.ID, []int{tagged.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if err := tags.AddContacts(ctx, bobVIP.ID, []int{tagged.ID, untagged.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, filter := range map[string]*repositories.ContactFilter{
+		"UserID":           {UserID: ada.ID, Tags: []string{"vip"}},
+		"ReadableBy":       {ReadableBy: ada.ID, Tags: []string{"vip"}},
+		"MatchAnyTag":      {ReadableBy: ada.ID, Tags: []string{"vip", "other"}, MatchAnyTag: true},
+		"OwnerAndReadable": {UserID: ada.ID, ReadableBy: ada.ID, Tags: []string{"VIP "}},
+	} {
+		got, err := contacts.GetAll(ctx, filter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != 1 || got[0].ID != tagged.ID {
+			t.Errorf("%s: got %d contacts, want only the one ada tagged", name, len(got))
+		}
+	}
+
+	got, err := contacts.GetAll(ctx, &repositories.ContactFilter{ReadableBy: ada.ID, Tags: []string{"vip", "other"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 0 {
+		t.Errorf("got %d contacts carrying a tag ada does not have", len(got))
+	}
+}
@@ -0,0 +1,382 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// Actions recorded in the contact history.
+const (
+	ContactActionCreate   = "create"
+	ContactActionUpdate   = "update"
+	ContactActionDelete   = "delete"
+	ContactActionRestore  = "restore"
+	ContactActionUndelete = "undelete"
+)
+
+// FieldChange is the change of a single contact field between two versions.
+type FieldChange struct {
+	Field string `json:"field"`
+	Old   string `json:"old"`
+	New   string `json:"new"`
+}
+
+// ContactVersion is an entry of a contact's history. Snapshot holds the contact as it was
+// after the change, or before it for deletes.
+type ContactVersion struct {
+	ContactID       int
+	Version         int
+	ActorID         int
+	Action          string
+	RestoredVersion int
+	Changes         []*FieldChange
+	Snapshot        *Contact
+	CreatedDate     time.Time
+}
+
+type ContactHistoryRepository interface {
+	Record(context.Context, *ContactVersion) error
+	Get(ctx context.Context, contactID, version int) (*ContactVersion, error)
+	GetAll(ctx context.Context, contactID int) ([]*ContactVersion, error)
+}
+
+// customFieldPrefix prefixes the names of custom fields in the history.
+const customFieldPrefix = "custom."
+
+// contactFields returns the tracked fields of a contact by name. Custom fields are tracked as
+// "custom.<key>".
+func contactFields(c *Contact) map[string]string {
+	fields := map[string]string{
+		"address_book_id": strconv.Itoa(c.AddressBookID),
+		"first_name":      c.FirstName,
+		"last_name":       c.LastName,
+		"email":           c.EmailID,
+		"mobile":          c.Mobile,
+		"organization":    c.Organization,
+		"notes":           c.Notes,
+		"birthday":        c.Birthday,
+		"anniversary":     c.Anniversary,
+	}
+	for key, value := range c.CustomFields {
+		fields[customFieldPrefix+key] = value
+	}
+	return fields
+}
+
+// contactFieldOrder is the order field changes are reported in.
+var contactFieldOrder = []string{"address_book_id", "first_name", "last_name", "email", "mobile", "organization", "notes", "birthday",
+	"anniversary"}
+
+// DiffContacts returns the fields that differ between two versions of a contact. A nil
+// old or new contact is treated as a contact with all fields empty.
+func DiffContacts(old, new *Contact) []*FieldChange {
+	if old == nil {
+		old = &Contact{}
+	}
+	if new == nil {
+		new = &Contact{}
+	}
+
+	before, after := contactFields(old), contactFields(new)
+
+	custom := map[string]string{}
+	for key := range old.CustomFields {
+		custom[customFieldPrefix+key] = ""
+	}
+	for key := range new.CustomFields {
+		custom[customFieldPrefix+key] = ""
+	}
+
+	order := append(append([]string{}, contactFieldOrder...), CustomFieldKeys(custom)...)
+	changes := []*FieldChange{}
+	for _, field := range order {
+		if before[field] != after[field] {
+			changes = append(changes, &FieldChange{Field: field, Old: before[field], New: after[field]})
+		}
+	}
+
+	return changes
+}
+
+type contactHistoryRepository struct {
+	db *DB
+}
+
+// NewContactHistoryRepository creates a new ContactHistoryRepository using the provided database connection.
+// Every version it records appends a domain event of the contact to the outbox, see OutboxRepository.
+func NewContactHistoryRepository(db *DB) ContactHistoryRepository {
+	return &eventContactHistoryRepository{ContactHistoryRepository: &contactHistoryRepository{db: db}, db: db}
+}
+
+// Record appends a version to the history of v.ContactID and sets v.Version to its number. The
+// number is computed and inserted under a lock on the contact's row, so that versions recorded
+// concurrently get successive numbers; run Record in the transaction of the write it records.
+func (r *contactHistoryRepository) Record(ctx context.Context, v *ContactVersion) error {
+	changes, err := json.Marshal(v.Changes)
+	if err != nil {
+		return err
+	}
+
+	snapshot, err := json.Marshal(v.Snapshot)
+	if err != nil {
+		return err
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	lock := r.db.Dialect.ForUpdate()
+	var contactID int
+	if err := tx.QueryRow(ctx, "SELECT contact_id FROM contacts WHERE contact_id = ?"+lock, v.ContactID).Scan(&contactID); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return errors.New("invalid contact id")
+		}
+		return err
+	}
+
+	// a locking read sees the latest version even where plain reads see the transaction's snapshot
+	var latest int
+	query := "SELECT version FROM contact_history WHERE contact_id = ? ORDER BY version DESC LIMIT 1" + lock
+	if err := tx.QueryRow(ctx, query, v.ContactID).Scan(&latest); err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return err
+	}
+
+	query = `INSERT INTO contact_history (contact_id, version, user_id, action, restored_version, changes, snapshot, created_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	_, err = tx.Exec(ctx, query, v.ContactID, latest+1, v.ActorID, v.Action, v.RestoredVersion, string(changes), string(snapshot))
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	v.Version = latest + 1
+	return nil
+}
+
+// Get retrieves a single version of a contact.
+func (r *contactHistoryRepository) Get(ctx context.Context, contactID, version int) (*ContactVersion, error) {
+	query := `SELECT contact_id, version, user_id, action, restored_version, changes, snapshot, created_date
+		FROM contact_history WHERE contact_id = ? AND version = ?`
+	v, err := scanContactVersion(r.db.QueryRow(ctx, query, contactID, version))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid contact version")
+		}
+		return nil, err
+	}
+	return v, nil
+}
+
+// GetAll retrieves the history of a contact, newest version first.
+func (r *contactHistoryRepository) GetAll(ctx context.Context, contactID int) ([]*ContactVersion, error) {
+	query := `SELECT contact_id, version, user_id, action, restored_version, changes, snapshot, created_date
+		FROM contact_history WHERE contact_id = ? ORDER BY version DESC`
+	rows, err := r.db.Query(ctx, query, contactID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	versions := []*ContactVersion{}
+
+	for rows.Next() {
+		v, err := scanContactVersion(rows)
+		if err != nil {
+			return nil, err
+		}
+		versions = append(versions, v)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return versions, nil
+}
+
+func scanContactVersion(row rowScanner) (*ContactVersion, error) {
+	v := &ContactVersion{}
+	var changes, snapshot string
+	err := row.Scan(&v.ContactID, &v.Version, &v.ActorID, &v.Action, &v.RestoredVersion, &changes, &snapshot, &v.CreatedDate)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(changes), &v.Changes); err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal([]byte(snapshot), &v.Snapshot); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// historyContactRepository is a ContactRepository that records a history version for every
+// write it performs on behalf of an actor.
+type historyContactRepository struct {
+	ContactRepository
+	history ContactHistoryRepository
+	actorID int
+}
+
+// newHistoryContactRepository wraps repo so that every contact write performed by actorID is
+// recorded in history. repo and history query the same transaction, see ContactStore.As.
+func newHistoryContactRepository(repo ContactRepository, history ContactHistoryRepository, actorID int) ContactRepository {
+	return &historyContactRepository{ContactRepository: repo, history: history, actorID: actorID}
+}
+
+func (r *historyContactRepository) Create(ctx context.Context, c *Contact) error {
+	if err := r.ContactRepository.Create(ctx, c); err != nil {
+		return err
+	}
+
+	snapshot := *c
+	return r.history.Record(ctx, &ContactVersion{
+		ContactID: c.ID,
+		ActorID:   r.actorID,
+		Action:    ContactActionCreate,
+		Changes:   DiffContacts(nil, c),
+		Snapshot:  &snapshot,
+	})
+}
+
+func (r *historyContactRepository) Update(ctx context.Context, c *Contact) error {
+	return r.update(ctx, c, r.ContactRepository.Update)
+}
+
+func (r *historyContactRepository) Patch(ctx context.Context, c *Contact, fields []string) error {
+	return r.update(ctx, c, func(ctx context.Context, c *Contact) error {
+		if err := r.ContactRepository.Patch(ctx, c, fields); err != nil {
+			return err
+		}
+
+		// the fields left out of the patch may have changed since c was read, so the version
+		// records the stored contact
+		stored, err := r.ContactRepository.Get(ctx, c.ID)
+		if err != nil {
+			return err
+		}
+
+		*c = *stored
+		return nil
+	})
+}
+
+// update writes c with write and records the fields it changed as a new version.
+func (r *historyContactRepository) update(ctx context.Context, c *Contact, write func(context.Context, *Contact) error) error {
+	old, err := r.ContactRepository.Get(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+
+	if err := write(ctx, c); err != nil {
+		return err
+	}
+
+	changes := DiffContacts(old, c)
+	if len(changes) == 0 {
+		return nil
+	}
+
+	snapshot := *c
+	snapshot.UserID = old.UserID
+	return r.history.Record(ctx, &ContactVersion{
+		ContactID: c.ID,
+		ActorID:   r.actorID,
+		Action:    ContactActionUpdate,
+		Changes:   changes,
+		Snapshot:  &snapshot,
+	})
+}
+
+func (r *historyContactRepository) Delete(ctx context.Context, id, deletedBy int) error {
+	old, err := r.ContactRepository.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.ContactRepository.Delete(ctx, id, deletedBy); err != nil {
+		return err
+	}
+
+	return r.history.Record(ctx, &ContactVersion{
+		ContactID: id,
+		ActorID:   r.actorID,
+		Action:    ContactActionDelete,
+		Changes:   DiffContacts(old, nil),
+		Snapshot:  old,
+	})
+}
+
+func (r *historyContactRepository) Restore(ctx context.Context, id int) error {
+	if err := r.ContactRepository.Restore(ctx, id); err != nil {
+		return err
+	}
+
+	c, err := r.ContactRepository.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return r.history.Record(ctx, &ContactVersion{
+		ContactID: id,
+		ActorID:   r.actorID,
+		Action:    ContactActionUndelete,
+		Changes:   DiffContacts(nil, c),
+		Snapshot:  c,
+	})
+}
+
+// restoreContactVersion resets a contact to the state it had at the given version and records
+// the restore as a new version. contacts must not record history itself, pass the
+// authorization-checked repository instead of the history-recording one.
+func restoreContactVersion(ctx context.Context, contacts ContactRepository, history ContactHistoryRepository, contactID, version, actorID int) (*ContactVersion, error) {
+	target, err := history.Get(ctx, contactID, version)
+	if err != nil {
+		return nil, err
+	}
+
+	if target.Action == ContactActionDelete {
+		return nil, errors.New("cannot restore a delete version")
+	}
+
+	current, err := contacts.Get(ctx, contactID)
+	if err != nil {
+		return nil, err
+	}
+
+	restored := *target.Snapshot
+	restored.ID = current.ID
+	restored.UserID = current.UserID
+	if err := contacts.Update(ctx, &restored); err != nil {
+		return nil, err
+	}
+
+	v := &ContactVersion{
+		ContactID:       contactID,
+		ActorID:         actorID,
+		Action:          ContactActionRestore,
+		RestoredVersion: version,
+		Changes:         DiffContacts(current, &restored),
+		Snapshot:        &restored,
+	}
+	if err := history.Record(ctx, v); err != nil {
+		return nil, err
+	}
+
+	return v, nil
+}
@@ -0,0 +1,268 @@
+package repositories
+
+import (
+	"context"
+	"strings"
+
+	"github.com/princeparmar/contact_manager/search"
+)
+
+// ContactIndexer keeps a search.Index in sync with the contacts store.
+type ContactIndexer struct {
+	contacts ContactRepository
+	groups   GroupRepository
+	tags     TagRepository
+	index    search.Index
+}
+
+// NewContactIndexer creates a new ContactIndexer reading the contacts, with their custom field
+// values, their groups and their tags from db to build search documents.
+func NewContactIndexer(db *DB, index search.Index) *ContactIndexer {
+	return &ContactIndexer{
+		contacts: newCustomFieldContactRepository(NewContactRepository(db), NewCustomFieldRepository(db)),
+		groups:   NewGroupRepository(db),
+		tags:     NewTagRepository(db),
+		index:    index,
+	}
+}
+
+// Index returns the index the indexer writes to.
+func (i *ContactIndexer) Index() search.Index {
+	return i.index
+}
+
+// Rebuild indexes every contact in the store.
+func (i *ContactIndexer) Rebuild(ctx context.Context) error {
+	contacts, err := i.contacts.GetAll(ctx, nil)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range contacts {
+		if err := i.indexContact(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Reindex refreshes the documents of the given contacts. Contacts that no longer exist
+// are removed from the index.
+func (i *ContactIndexer) Reindex(ctx context.Context, contactIDs ...int) error {
+	if len(contactIDs) == 0 {
+		return nil
+	}
+
+	contacts, err := i.contacts.GetAll(ctx, &ContactFilter{ContactIDs: contactIDs})
+	if err != nil {
+		return err
+	}
+
+	found := map[int]bool{}
+	for _, c := range contacts {
+		found[c.ID] = true
+		if err := i.indexContact(ctx, c); err != nil {
+			return err
+		}
+	}
+
+	for _, id := range contactIDs {
+		if !found[id] {
+			if err := i.index.Remove(id); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (i *ContactIndexer) indexContact(ctx context.Context, c *Contact) error {
+	groups, err := i.groups.GetGroupsForContact(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+
+	tags, err := i.tags.GetTagsForContact(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+
+	doc := &search.Document{
+		ContactID:     c.ID,
+		UserID:        c.UserID,
+		AddressBookID: c.AddressBookID,
+		Fields: map[string]string{
+			search.FieldName:         c.FirstName + " " + c.LastName,
+			search.FieldEmail:        c.EmailID,
+			search.FieldPhone:        c.Mobile,
+			search.FieldOrganization: c.Organization,
+			search.FieldNotes:        c.Notes,
+			search.FieldCustom:       customFieldText(c.CustomFields),
+		},
+		Facets: map[string][]string{},
+	}
+
+	if c.Organization != "" {
+		doc.Facets[search.FacetCompany] = []string{c.Organization}
+	}
+	for _, g := range groups {
+		doc.Facets[search.FacetGroup] = append(doc.Facets[search.FacetGroup], g.Name)
+	}
+	for _, t := range tags {
+		doc.Facets[search.FacetTag] = append(doc.Facets[search.FacetTag], t.Name)
+	}
+
+	return i.index.Index(doc)
+}
+
+// customFieldText joins the custom field values of a contact into searchable text.
+func customFieldText(values map[string]string) string {
+	text := make([]string, 0, len(values))
+	for _, key := range CustomFieldKeys(values) {
+		text = append(text, values[key])
+	}
+	return strings.Join(text, " ")
+}
+
+// indexedGroupRepository is a GroupRepository that reindexes the affected contacts whenever
+// group membership or group names change.
+type indexedGroupRepository struct {
+	GroupRepository
+	indexer *ContactIndexer
+}
+
+// NewIndexedGroupRepository wraps repo so that group changes are reflected in the indexer's index.
+func NewIndexedGroupRepository(repo GroupRepository, indexer *ContactIndexer) GroupRepository {
+	return &indexedGroupRepository{GroupRepository: repo, indexer: indexer}
+}
+
+func (r *indexedGroupRepository) members(ctx context.Context, groupID int) ([]int, error) {
+	contacts, err := r.indexer.contacts.GetAll(ctx, &ContactFilter{GroupIDs: []int{groupID}})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(contacts))
+	for _, c := range contacts {
+		ids = append(ids, c.ID)
+	}
+
+	return ids, nil
+}
+
+func (r *indexedGroupRepository) Update(ctx context.Context, g *Group) error {
+	if err := r.GroupRepository.Update(ctx, g); err != nil {
+		return err
+	}
+
+	ids, err := r.members(ctx, g.ID)
+	if err != nil {
+		return err
+	}
+
+	return r.indexer.Reindex(ctx, ids...)
+}
+
+func (r *indexedGroupRepository) Delete(ctx context.Context, id int) error {
+	ids, err := r.members(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.GroupRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	return r.indexer.Reindex(ctx, ids...)
+}
+
+func (r *indexedGroupRepository) AddContacts(ctx context.Context, groupID int, contactIDs []int) error {
+	if err := r.GroupRepository.AddContacts(ctx, groupID, contactIDs); err != nil {
+		return err
+	}
+
+	return r.indexer.Reindex(ctx, contactIDs...)
+}
+
+func (r *indexedGroupRepository) RemoveContacts(ctx context.Context, groupID int, contactIDs []int) error {
+	if err := r.GroupRepository.RemoveContacts(ctx, groupID, contactIDs); err != nil {
+		return err
+	}
+
+	return r.indexer.Reindex(ctx, contactIDs...)
+}
+
+// indexedTagRepository is a TagRepository that reindexes the affected contacts whenever
+// tags are attached, detached, renamed or deleted.
+type indexedTagRepository struct {
+	TagRepository
+	indexer *ContactIndexer
+}
+
+// NewIndexedTagRepository wraps repo so that tag changes are reflected in the indexer's index.
+func NewIndexedTagRepository(repo TagRepository, indexer *ContactIndexer) TagRepository {
+	return &indexedTagRepository{TagRepository: repo, indexer: indexer}
+}
+
+func (r *indexedTagRepository) members(ctx context.Context, tagID int) ([]int, error) {
+	tag, err := r.TagRepository.Get(ctx, tagID)
+	if err != nil {
+		return nil, err
+	}
+
+	contacts, err := r.indexer.contacts.GetAll(ctx, &ContactFilter{UserID: tag.UserID, Tags: []string{tag.Name}})
+	if err != nil {
+		return nil, err
+	}
+
+	ids := make([]int, 0, len(contacts))
+	for _, c := range contacts {
+		ids = append(ids, c.ID)
+	}
+
+	return ids, nil
+}
+
+func (r *indexedTagRepository) Update(ctx context.Context, t *Tag) error {
+	if err := r.TagRepository.Update(ctx, t); err != nil {
+		return err
+	}
+
+	ids, err := r.members(ctx, t.ID)
+	if err != nil {
+		return err
+	}
+
+	return r.indexer.Reindex(ctx, ids...)
+}
+
+func (r *indexedTagRepository) Delete(ctx context.Context, id int) error {
+	ids, err := r.members(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	if err := r.TagRepository.Delete(ctx, id); err != nil {
+		return err
+	}
+
+	return r.indexer.Reindex(ctx, ids...)
+}
+
+func (r *indexedTagRepository) AddContacts(ctx context.Context, tagID int, contactIDs []int) error {
+	if err := r.TagRepository.AddContacts(ctx, tagID, contactIDs); err != nil {
+		return err
+	}
+
+	return r.indexer.Reindex(ctx, contactIDs...)
+}
+
+func (r *indexedTagRepository) RemoveContacts(ctx context.Context, tagID int, contactIDs []int) error {
+	if err := r.TagRepository.RemoveContacts(ctx, tagID, contactIDs); err != nil {
+		return err
+	}
+
+	return r.indexer.Reindex(ctx, contactIDs...)
+}
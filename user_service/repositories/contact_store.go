@@ -0,0 +1,211 @@
+package repositories
+
+import (
+	"context"
+	"time"
+)
+
+// ContactStore is the repository contacts are read and written through.
+type ContactStore interface {
+	ContactRepository
+
+	// As returns the repository writing contacts on behalf of actorID.
+	As(actorID int) ContactWriter
+}
+
+// ContactWriter reads and writes contacts on behalf of an actor. Every operation is checked
+// against the actor's address book permissions, and every write stores the contact, its custom
+// field values, the history version recording it and the event of that version in one
+// transaction.
+type ContactWriter interface {
+	ContactRepository
+
+	// RestoreVersion resets a contact to the state it had at the given version and returns the
+	// version recording the restore.
+	RestoreVersion(ctx context.Context, contactID, version int) (*ContactVersion, error)
+}
+
+// contactWrites performs contact writes, each in a unit of work on the contact repository compose
+// builds from the repositories of the unit, and refreshes the search index of indexer, which may
+// be nil, once the unit committed.
+type contactWrites struct {
+	work    UnitOfWork
+	compose func(repos *Repositories) ContactRepository
+	indexer *ContactIndexer
+}
+
+// write runs fn with the contact repository of a new unit of work.
+func (w *contactWrites) write(ctx context.Context, fn func(contacts ContactRepository) error) error {
+	return w.work.Do(ctx, func(repos *Repositories) error {
+		return fn(w.compose(repos))
+	})
+}
+
+// reindex refreshes the search documents of the given contacts.
+func (w *contactWrites) reindex(ctx context.Context, contactIDs ...int) error {
+	if w.indexer == nil {
+		return nil
+	}
+
+	return w.indexer.Reindex(ctx, contactIDs...)
+}
+
+func (w *contactWrites) Create(ctx context.Context, c *Contact) error {
+	err := w.write(ctx, func(contacts ContactRepository) error {
+		return contacts.Create(ctx, c)
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.reindex(ctx, c.ID)
+}
+
+func (w *contactWrites) Update(ctx context.Context, c *Contact) error {
+	err := w.write(ctx, func(contacts ContactRepository) error {
+		return contacts.Update(ctx, c)
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.reindex(ctx, c.ID)
+}
+
+func (w *contactWrites) Patch(ctx context.Context, c *Contact, fields []string) error {
+	err := w.write(ctx, func(contacts ContactRepository) error {
+		return contacts.Patch(ctx, c, fields)
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.reindex(ctx, c.ID)
+}
+
+func (w *contactWrites) Delete(ctx context.Context, id, deletedBy int) error {
+	err := w.write(ctx, func(contacts ContactRepository) error {
+		return contacts.Delete(ctx, id, deletedBy)
+	})
+	if err != nil {
+		return err
+	}
+
+	if w.indexer == nil {
+		return nil
+	}
+
+	return w.indexer.index.Remove(id)
+}
+
+func (w *contactWrites) Restore(ctx context.Context, id int) error {
+	err := w.write(ctx, func(contacts ContactRepository) error {
+		return contacts.Restore(ctx, id)
+	})
+	if err != nil {
+		return err
+	}
+
+	return w.reindex(ctx, id)
+}
+
+// unitContacts returns the contact repository of a unit of work storing contacts together with
+// their custom field values and the reminders of their dates.
+func unitContacts(repos *Repositories) ContactRepository {
+	return newContactDateRepository(newCustomFieldContactRepository(repos.Contacts, repos.CustomFields), repos.Reminders)
+}
+
+type contactStore struct {
+	contactWrites
+	reads ContactRepository
+	books AddressBookRepository
+}
+
+// NewContactStore returns the ContactStore of db, composing the contact repositories in the one
+// order that keeps them consistent: every write stores the contact, its custom field values and
+// the reminders of its dates in one unit of work, and only once it committed refreshes the search
+// index of indexer, which may be nil. Contacts read carry their custom field values; a nil
+// CustomFields map on Update leaves the stored values untouched. Writes of the store itself are
+// not recorded in the contact history, those on behalf of a user go through As.
+func NewContactStore(db *DB, indexer *ContactIndexer) ContactStore {
+	return &contactStore{
+		contactWrites: contactWrites{
+			work:    NewUnitOfWork(db),
+			compose: unitContacts,
+			indexer: indexer,
+		},
+		reads: newCustomFieldContactRepository(NewContactRepository(db), NewCustomFieldRepository(db)),
+		books: NewAddressBookRepository(db),
+	}
+}
+
+func (s *contactStore) Get(ctx context.Context, id int) (*Contact, error) {
+	return s.reads.Get(ctx, id)
+}
+
+func (s *contactStore) GetAll(ctx context.Context, filter *ContactFilter) ([]*Contact, error) {
+	return s.reads.GetAll(ctx, filter)
+}
+
+func (s *contactStore) GetDeleted(ctx context.Context, filter *ContactFilter) ([]*DeletedContact, error) {
+	return s.reads.GetDeleted(ctx, filter)
+}
+
+func (s *contactStore) Purge(ctx context.Context, before time.Time) (int64, error) {
+	return s.reads.Purge(ctx, before)
+}
+
+// As returns a ContactWriter whose writes check the permissions of actorID on the address books
+// of the unit of work, record the history version and its event there, and store the contact.
+func (s *contactStore) As(actorID int) ContactWriter {
+	return &contactWriter{
+		contactWrites: contactWrites{
+			work: s.work,
+			compose: func(repos *Repositories) ContactRepository {
+				history := newHistoryContactRepository(unitContacts(repos), repos.ContactHistory, actorID)
+				return NewAuthorizedContactRepository(history, repos.AddressBooks, actorID)
+			},
+			indexer: s.indexer,
+		},
+		reads:   NewAuthorizedContactRepository(s.reads, s.books, actorID),
+		actorID: actorID,
+	}
+}
+
+type contactWriter struct {
+	contactWrites
+	reads   ContactRepository
+	actorID int
+}
+
+func (w *contactWriter) Get(ctx context.Context, id int) (*Contact, error) {
+	return w.reads.Get(ctx, id)
+}
+
+func (w *contactWriter) GetAll(ctx context.Context, filter *ContactFilter) ([]*Contact, error) {
+	return w.reads.GetAll(ctx, filter)
+}
+
+func (w *contactWriter) GetDeleted(ctx context.Context, filter *ContactFilter) ([]*DeletedContact, error) {
+	return w.reads.GetDeleted(ctx, filter)
+}
+
+func (w *contactWriter) Purge(ctx context.Context, before time.Time) (int64, error) {
+	return w.reads.Purge(ctx, before)
+}
+
+func (w *contactWriter) RestoreVersion(ctx context.Context, contactID, version int) (*ContactVersion, error) {
+	var v *ContactVersion
+	err := w.work.Do(ctx, func(repos *Repositories) error {
+		contacts := NewAuthorizedContactRepository(unitContacts(repos), repos.AddressBooks, w.actorID)
+
+		var err error
+		v, err = restoreContactVersion(ctx, contacts, repos.ContactHistory, contactID, version, w.actorID)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return v, w.reindex(ctx, contactID)
+}
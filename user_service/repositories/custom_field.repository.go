@@ -0,0 +1,553 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Types a custom field value can have.
+const (
+	FieldTypeText   = "text"
+	FieldTypeNumber = "number"
+	FieldTypeDate   = "date"
+	FieldTypeEnum   = "enum"
+	FieldTypeURL    = "url"
+)
+
+// customFieldDateLayout is the format date values are accepted and stored in.
+const customFieldDateLayout = "2006-01-02"
+
+var customFieldKeyPattern = regexp.MustCompile(`^[a-z][a-z0-9_]{0,62}$`)
+
+// CustomField defines an extra field for contacts. Fields with an AddressBookID apply to the
+// contacts of that address book and are shared by everyone working in it; fields without one
+// apply to the personal contacts of UserID.
+//
+// Key is the stable machine name used in the API, in CSV headers and, upper-cased with
+// hyphens, as the X- property of vCards. Options lists the allowed values of enum fields and
+// Pattern, when set, is a regular expression text values must match.
+type CustomField struct {
+	ID            int
+	UserID        int
+	AddressBookID int
+	Key           string
+	Label         string
+	Type          string
+	Required      bool
+	Options       []string
+	Pattern       string
+}
+
+// CustomFieldSortFields are the fields a page of custom fields can be sorted by.
+var CustomFieldSortFields = []string{"id", "key", "label"}
+
+// SortKey returns the position of the custom field in a list sorted by the given field.
+func (f *CustomField) SortKey(field string) SortKey {
+	switch field {
+	case "key":
+		return SortKey{Value: f.Key, ID: f.ID}
+	case "label":
+		return SortKey{Value: f.Label, ID: f.ID}
+	}
+
+	return SortKey{ID: f.ID}
+}
+
+// Validate checks the definition itself.
+func (f *CustomField) Validate() error {
+	if !customFieldKeyPattern.MatchString(f.Key) {
+		return errors.New("key must start with a lower-case letter and contain only lower-case letters, digits and underscores")
+	}
+
+	switch f.Type {
+	case FieldTypeText, FieldTypeNumber, FieldTypeDate, FieldTypeURL:
+		if len(f.Options) > 0 {
+			return errors.New("options are only allowed for enum fields")
+		}
+	case FieldTypeEnum:
+		if len(f.Options) == 0 {
+			return errors.New("enum fields need at least one option")
+		}
+	default:
+		return errors.New("type must be one of text, number, date, enum or url")
+	}
+
+	if f.Pattern != "" {
+		if f.Type != FieldTypeText {
+			return errors.New("pattern is only allowed for text fields")
+		}
+		if _, err := regexp.Compile(f.Pattern); err != nil {
+			return errors.New("pattern is not a valid regular expression")
+		}
+	}
+
+	return nil
+}
+
+// Normalize checks a value against the field definition and returns it in its canonical form:
+// numbers without superfluous digits, dates as YYYY-MM-DD and everything else trimmed.
+func (f *CustomField) Normalize(value string) (string, error) {
+	value = strings.TrimSpace(value)
+
+	switch f.Type {
+	case FieldTypeNumber:
+		n, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return "", fmt.Errorf("%s must be a number", f.Key)
+		}
+		return strconv.FormatFloat(n, 'f', -1, 64), nil
+	case FieldTypeDate:
+		d, err := time.Parse(customFieldDateLayout, value)
+		if err != nil {
+			return "", fmt.Errorf("%s must be a date formatted as YYYY-MM-DD", f.Key)
+		}
+		return d.Format(customFieldDateLayout), nil
+	case FieldTypeEnum:
+		for _, option := range f.Options {
+			if option == value {
+				return value, nil
+			}
+		}
+		return "", fmt.Errorf("%s must be one of %s", f.Key, strings.Join(f.Options, ", "))
+	case FieldTypeURL:
+		u, err := url.ParseRequestURI(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return "", fmt.Errorf("%s must be an http or https URL", f.Key)
+		}
+		return value, nil
+	default:
+		if f.Pattern != "" && !regexp.MustCompile(f.Pattern).MatchString(value) {
+			return "", fmt.Errorf("%s does not match the required format", f.Key)
+		}
+		return value, nil
+	}
+}
+
+// NormalizeCustomFields validates the custom field values of a contact against the fields in
+// its scope and returns the normalized values. Empty values are dropped, unknown keys and
+// missing required fields are errors.
+func NormalizeCustomFields(fields []*CustomField, values map[string]string) (map[string]string, error) {
+	byKey := map[string]*CustomField{}
+	for _, f := range fields {
+		byKey[f.Key] = f
+	}
+
+	normalized := map[string]string{}
+	for key, value := range values {
+		f, ok := byKey[key]
+		if !ok {
+			return nil, fmt.Errorf("unknown custom field %s", key)
+		}
+		if strings.TrimSpace(value) == "" {
+			continue
+		}
+
+		v, err := f.Normalize(value)
+		if err != nil {
+			return nil, err
+		}
+		normalized[key] = v
+	}
+
+	for _, f := range fields {
+		if _, ok := normalized[f.Key]; f.Required && !ok {
+			return nil, fmt.Errorf("custom field %s is required", f.Key)
+		}
+	}
+
+	return normalized, nil
+}
+
+// CustomFieldKeys returns the keys of a set of custom field values in sorted order.
+func CustomFieldKeys(values map[string]string) []string {
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+type CustomFieldRepository interface {
+	Create(context.Context, *CustomField) error
+	Get(context.Context, int) (*CustomField, error)
+	Update(context.Context, *CustomField) error
+	Delete(context.Context, int) error
+	GetAll(ctx context.Context, userID, addressBookID int) ([]*CustomField, error)
+	GetValues(ctx context.Context, contactIDs []int) (map[int]map[string]string, error)
+	SetValues(ctx context.Context, contactID int, values map[string]string) error
+}
+
+type customFieldRepository struct {
+	db *DB
+}
+
+// NewCustomFieldRepository creates a new CustomFieldRepository using the provided database connection.
+func NewCustomFieldRepository(db *DB) CustomFieldRepository {
+	return &customFieldRepository{db: db}
+}
+
+// Create inserts a new field definition and sets its ID. Keys are unique within the address
+// book, or within the personal fields of a user.
+func (r *customFieldRepository) Create(ctx context.Context, f *CustomField) error {
+	options, err := json.Marshal(f.Options)
+	if err != nil {
+		return err
+	}
+
+	query := `INSERT INTO custom_fields (user_id, address_book_id, field_key, label, field_type, required, options, pattern, created_date, updated_date)
+		SELECT ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP ` + r.db.Dialect.FromDual() + `
+		WHERE NOT EXISTS (
+			SELECT 1 FROM custom_fields
+			WHERE field_key = ? AND address_book_id ` + r.db.Dialect.NullSafeEqual() + ` ? AND (address_book_id IS NOT NULL OR user_id = ?)
+		)`
+	bookID := nullableID(f.AddressBookID)
+	id, err := r.db.Insert(ctx, "field_id", query, f.UserID, bookID, f.Key, f.Label, f.Type, f.Required, string(options), f.Pattern,
+		f.Key, bookID, f.UserID)
+	if err != nil {
+		return err
+	}
+
+	if id == 0 {
+		return errors.New("a custom field with this key already exists")
+	}
+
+	f.ID = id
+
+	return nil
+}
+
+// Get retrieves a field definition by ID.
+func (r *customFieldRepository) Get(ctx context.Context, id int) (*CustomField, error) {
+	query := `SELECT field_id, user_id, address_book_id, field_key, label, field_type, required, options, pattern
+		FROM custom_fields WHERE field_id = ?`
+	f, err := scanCustomField(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid custom field id")
+		}
+		return nil, err
+	}
+	return f, nil
+}
+
+// Update changes the label, required flag, options and pattern of a field definition. The key,
+// type and scope of a field never change so that stored values stay valid.
+func (r *customFieldRepository) Update(ctx context.Context, f *CustomField) error {
+	options, err := json.Marshal(f.Options)
+	if err != nil {
+		return err
+	}
+
+	query := "UPDATE custom_fields SET label = ?, required = ?, options = ?, pattern = ?, updated_date = CURRENT_TIMESTAMP WHERE field_id = ?"
+	result, err := r.db.Exec(ctx, query, f.Label, f.Required, string(options), f.Pattern, f.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return nil
+}
+
+// Delete removes a field definition by ID together with its values.
+func (r *customFieldRepository) Delete(ctx context.Context, id int) error {
+	query := "DELETE FROM custom_fields WHERE field_id = ?"
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return nil
+}
+
+// GetAll retrieves the field definitions of an address book, or the personal field definitions
+// of a user when addressBookID is zero.
+func (r *customFieldRepository) GetAll(ctx context.Context, userID, addressBookID int) ([]*CustomField, error) {
+	query := `SELECT field_id, user_id, address_book_id, field_key, label, field_type, required, options, pattern
+		FROM custom_fields WHERE address_book_id = ? ORDER BY field_key`
+	args := []interface{}{addressBookID}
+	if addressBookID == 0 {
+		query = `SELECT field_id, user_id, address_book_id, field_key, label, field_type, required, options, pattern
+			FROM custom_fields WHERE address_book_id IS NULL AND user_id = ? ORDER BY field_key`
+		args = []interface{}{userID}
+	}
+
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	fields := []*CustomField{}
+
+	for rows.Next() {
+		f, err := scanCustomField(rows)
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, f)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return fields, nil
+}
+
+// GetValues retrieves the custom field values of the given contacts keyed by contact ID and field key.
+func (r *customFieldRepository) GetValues(ctx context.Context, contactIDs []int) (map[int]map[string]string, error) {
+	values := map[int]map[string]string{}
+	if len(contactIDs) == 0 {
+		return values, nil
+	}
+
+	query := fmt.Sprintf(`SELECT v.contact_id, f.field_key, v.field_value
+		FROM custom_field_values v
+		JOIN custom_fields f ON v.field_id = f.field_id
+		WHERE v.contact_id IN (%s)`, placeholders(len(contactIDs)))
+	rows, err := r.db.Query(ctx, query, intArgs(contactIDs)...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		var contactID int
+		var key, value string
+		if err := rows.Scan(&contactID, &key, &value); err != nil {
+			return nil, err
+		}
+		if values[contactID] == nil {
+			values[contactID] = map[string]string{}
+		}
+		values[contactID][key] = value
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return values, nil
+}
+
+// SetValues replaces the custom field values of a contact. Values are stored for the fields in
+// the contact's scope; callers validate them with NormalizeCustomFields first.
+func (r *customFieldRepository) SetValues(ctx context.Context, contactID int, values map[string]string) error {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(ctx, "DELETE FROM custom_field_values WHERE contact_id = ?", contactID); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	for _, key := range CustomFieldKeys(values) {
+		query := `INSERT INTO custom_field_values (contact_id, field_id, field_value, created_date, updated_date)
+			SELECT c.contact_id, f.field_id, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP
+			FROM contacts c
+			JOIN custom_fields f ON f.field_key = ? AND (f.address_book_id = c.address_book_id
+				OR (c.address_book_id IS NULL AND f.address_book_id IS NULL AND f.user_id = c.user_id))
+			WHERE c.contact_id = ?`
+		if _, err := tx.Exec(ctx, query, values[key], key, contactID); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+func scanCustomField(row rowScanner) (*CustomField, error) {
+	f := &CustomField{}
+	var addressBookID sql.NullInt64
+	var options string
+	err := row.Scan(&f.ID, &f.UserID, &addressBookID, &f.Key, &f.Label, &f.Type, &f.Required, &options, &f.Pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	f.AddressBookID = int(addressBookID.Int64)
+	if err := json.Unmarshal([]byte(options), &f.Options); err != nil {
+		return nil, err
+	}
+
+	return f, nil
+}
+
+// customFieldContactRepository is a ContactRepository that loads and stores the custom field
+// values of contacts alongside them.
+type customFieldContactRepository struct {
+	ContactRepository
+	fields CustomFieldRepository
+}
+
+// newCustomFieldContactRepository wraps repo so that contacts carry their custom field values.
+// Writes validate the values against the fields in the contact's scope and store them with
+// fields, so repo and fields query the same transaction; a nil CustomFields map on Update leaves
+// the stored values untouched.
+func newCustomFieldContactRepository(repo ContactRepository, fields CustomFieldRepository) ContactRepository {
+	return &customFieldContactRepository{ContactRepository: repo, fields: fields}
+}
+
+// normalize validates the custom values of a contact in its scope.
+func (r *customFieldContactRepository) normalize(ctx context.Context, c *Contact) (map[string]string, error) {
+	fields, err := r.fields.GetAll(ctx, c.UserID, c.AddressBookID)
+	if err != nil {
+		return nil, err
+	}
+
+	return NormalizeCustomFields(fields, c.CustomFields)
+}
+
+func (r *customFieldContactRepository) Create(ctx context.Context, c *Contact) error {
+	values, err := r.normalize(ctx, c)
+	if err != nil {
+		return err
+	}
+
+	if err := r.ContactRepository.Create(ctx, c); err != nil {
+		return err
+	}
+
+	c.CustomFields = values
+	return r.fields.SetValues(ctx, c.ID, values)
+}
+
+func (r *customFieldContactRepository) Update(ctx context.Context, c *Contact) error {
+	return r.update(ctx, c, r.ContactRepository.Update)
+}
+
+// Patch stores the custom field values of c when the fields include custom fields, and otherwise
+// leaves them untouched like Update with a nil CustomFields map.
+func (r *customFieldContactRepository) Patch(ctx context.Context, c *Contact, fields []string) error {
+	if _, custom := splitCustomFields(fields); !custom {
+		c.CustomFields = nil
+	}
+
+	return r.update(ctx, c, func(ctx context.Context, c *Contact) error {
+		return r.ContactRepository.Patch(ctx, c, fields)
+	})
+}
+
+// update writes c with write, together with its custom field values.
+func (r *customFieldContactRepository) update(ctx context.Context, c *Contact, write func(context.Context, *Contact) error) error {
+	existing, err := r.Get(ctx, c.ID)
+	if err != nil {
+		return err
+	}
+
+	if c.CustomFields == nil && c.AddressBookID == existing.AddressBookID {
+		if err := write(ctx, c); err != nil {
+			return err
+		}
+		c.CustomFields = existing.CustomFields
+		return nil
+	}
+
+	fields, err := r.fields.GetAll(ctx, existing.UserID, c.AddressBookID)
+	if err != nil {
+		return err
+	}
+
+	// a contact moved into another scope keeps the values of the fields that exist there too
+	values := c.CustomFields
+	if values == nil {
+		values = map[string]string{}
+		for _, f := range fields {
+			if v, ok := existing.CustomFields[f.Key]; ok {
+				values[f.Key] = v
+			}
+		}
+	}
+
+	normalized, err := NormalizeCustomFields(fields, values)
+	if err != nil {
+		return err
+	}
+
+	if err := write(ctx, c); err != nil {
+		return err
+	}
+
+	c.CustomFields = normalized
+	return r.fields.SetValues(ctx, c.ID, normalized)
+}
+
+func (r *customFieldContactRepository) Get(ctx context.Context, id int) (*Contact, error) {
+	c, err := r.ContactRepository.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.attach(ctx, []*Contact{c}); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (r *customFieldContactRepository) GetAll(ctx context.Context, filter *ContactFilter) ([]*Contact, error) {
+	contacts, err := r.ContactRepository.GetAll(ctx, filter)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := r.attach(ctx, contacts); err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// attach loads the custom field values of the given contacts.
+func (r *customFieldContactRepository) attach(ctx context.Context, contacts []*Contact) error {
+	ids := make([]int, 0, len(contacts))
+	for _, c := range contacts {
+		ids = append(ids, c.ID)
+	}
+
+	values, err := r.fields.GetValues(ctx, ids)
+	if err != nil {
+		return err
+	}
+
+	for _, c := range contacts {
+		c.CustomFields = values[c.ID]
+		if c.CustomFields == nil {
+			c.CustomFields = map[string]string{}
+		}
+	}
+
+	return nil
+}
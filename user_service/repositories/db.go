@@ -0,0 +1,300 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"time"
+)
+
+// DB is a database connection pool that binds the queries of the repositories for its Dialect.
+// A DB handed out by a UnitOfWork runs its queries in the transaction of the unit of work instead.
+//
+// Every query runs with the context of the repository operation, so it is cancelled with the
+// request it serves, and is cut off after the Timeout of the DB when the context has no earlier
+// deadline.
+type DB struct {
+	*sql.DB
+	Dialect Dialect
+
+	// Timeout is the longest a query may run, or 0 for no limit.
+	Timeout time.Duration
+
+	// tx is the transaction the queries run in, or nil to run them on the pool.
+	tx *Tx
+}
+
+// NewDB wraps a connection pool to a database of the given dialect.
+func NewDB(db *sql.DB, dialect Dialect) *DB {
+	return &DB{DB: db, Dialect: dialect}
+}
+
+// Open opens a database of the dialect with the given name, using the driver registered under the
+// DriverName of the dialect. Drivers registered under other names are wrapped with NewDB.
+func Open(dialectName, dataSourceName string) (*DB, error) {
+	dialect, err := LookupDialect(dialectName)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open(dialect.DriverName(), dataSourceName)
+	if err != nil {
+		return nil, err
+	}
+
+	return NewDB(db, dialect), nil
+}
+
+// WithTimeout returns a DB sharing the connection pool of db whose queries are cut off after the
+// given timeout, for the repositories whose queries are expected to be faster or slower than the
+// others, e.g. NewBulkJobRepository(db.WithTimeout(time.Minute)).
+func (db *DB) WithTimeout(timeout time.Duration) *DB {
+	return &DB{DB: db.DB, Dialect: db.Dialect, Timeout: timeout, tx: db.tx}
+}
+
+// in returns a DB that runs its queries in the given transaction.
+func (db *DB) in(tx *Tx) *DB {
+	return &DB{DB: db.DB, Dialect: db.Dialect, Timeout: db.Timeout, tx: tx}
+}
+
+// Exec executes a query without returning any rows.
+func (db *DB) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if db.tx != nil {
+		return db.tx.exec(ctx, db.Timeout, query, args)
+	}
+
+	ctx, cancel := withTimeout(ctx, db.Timeout)
+	defer cancel()
+
+	query, args = db.Dialect.Bind(query, args)
+	return db.DB.ExecContext(ctx, query, args...)
+}
+
+// Query executes a query that returns rows.
+func (db *DB) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	if db.tx != nil {
+		return db.tx.query(ctx, db.Timeout, query, args)
+	}
+
+	ctx, cancel := withTimeout(ctx, db.Timeout)
+	query, args = db.Dialect.Bind(query, args)
+	rows, err := db.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+// QueryRow executes a query that is expected to return at most one row.
+func (db *DB) QueryRow(ctx context.Context, query string, args ...interface{}) *Row {
+	if db.tx != nil {
+		return db.tx.queryRow(ctx, db.Timeout, query, args)
+	}
+
+	ctx, cancel := withTimeout(ctx, db.Timeout)
+	query, args = db.Dialect.Bind(query, args)
+	return &Row{Row: db.DB.QueryRowContext(ctx, query, args...), cancel: cancel}
+}
+
+// Insert executes an INSERT and returns the generated value of the given column, or 0 when the
+// statement inserted nothing.
+func (db *DB) Insert(ctx context.Context, column, query string, args ...interface{}) (int, error) {
+	return insert(ctx, db, db.Dialect, column, query, args)
+}
+
+// Begin starts a transaction, or a nested one in the transaction the DB runs its queries in. The
+// transaction is rolled back when ctx is cancelled before it is committed.
+func (db *DB) Begin(ctx context.Context) (*Tx, error) {
+	if db.tx != nil {
+		return db.tx.Begin(ctx)
+	}
+
+	tx, err := db.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Tx{Tx: tx, Dialect: db.Dialect, Timeout: db.Timeout}, nil
+}
+
+// Tx is a transaction that binds its queries like the DB it was started on. A Tx started on
+// another Tx is nested: it is a savepoint of the same database transaction, whose Commit keeps
+// its changes for the outer Tx to commit and whose Rollback undoes only them.
+type Tx struct {
+	*sql.Tx
+	Dialect Dialect
+
+	// Timeout is the longest a query may run, or 0 for no limit.
+	Timeout time.Duration
+
+	// savepoint is the name of the savepoint of a nested Tx, and "" for the database transaction.
+	savepoint string
+	depth     int
+	done      bool
+}
+
+// Begin starts a nested transaction. Nested transactions must end before the Tx they were
+// started on.
+func (tx *Tx) Begin(ctx context.Context) (*Tx, error) {
+	savepoint := "sp" + strconv.Itoa(tx.depth+1)
+	if _, err := tx.Tx.ExecContext(ctx, "SAVEPOINT "+savepoint); err != nil {
+		return nil, err
+	}
+
+	return &Tx{Tx: tx.Tx, Dialect: tx.Dialect, Timeout: tx.Timeout, savepoint: savepoint, depth: tx.depth + 1}, nil
+}
+
+// Commit commits the transaction, or releases the savepoint of a nested one.
+func (tx *Tx) Commit() error {
+	if tx.savepoint == "" {
+		return tx.Tx.Commit()
+	}
+	if tx.done {
+		return sql.ErrTxDone
+	}
+
+	tx.done = true
+	_, err := tx.Tx.Exec("RELEASE SAVEPOINT " + tx.savepoint)
+	return err
+}
+
+// Rollback aborts the transaction, or undoes the changes made since the savepoint of a nested one.
+// Like for sql.Tx it returns sql.ErrTxDone once the Tx has ended, so it can be deferred.
+func (tx *Tx) Rollback() error {
+	if tx.savepoint == "" {
+		return tx.Tx.Rollback()
+	}
+	if tx.done {
+		return sql.ErrTxDone
+	}
+
+	tx.done = true
+	if _, err := tx.Tx.Exec("ROLLBACK TO SAVEPOINT " + tx.savepoint); err != nil {
+		return err
+	}
+	_, err := tx.Tx.Exec("RELEASE SAVEPOINT " + tx.savepoint)
+	return err
+}
+
+// Exec executes a query without returning any rows.
+func (tx *Tx) Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	return tx.exec(ctx, tx.Timeout, query, args)
+}
+
+// Query executes a query that returns rows.
+func (tx *Tx) Query(ctx context.Context, query string, args ...interface{}) (*Rows, error) {
+	return tx.query(ctx, tx.Timeout, query, args)
+}
+
+// QueryRow executes a query that is expected to return at most one row.
+func (tx *Tx) QueryRow(ctx context.Context, query string, args ...interface{}) *Row {
+	return tx.queryRow(ctx, tx.Timeout, query, args)
+}
+
+// Insert executes an INSERT and returns the generated value of the given column, or 0 when the
+// statement inserted nothing.
+func (tx *Tx) Insert(ctx context.Context, column, query string, args ...interface{}) (int, error) {
+	return insert(ctx, tx, tx.Dialect, column, query, args)
+}
+
+// exec, query and queryRow run a query in the transaction, cut off after the timeout of the DB or
+// Tx it was issued on.
+func (tx *Tx) exec(ctx context.Context, timeout time.Duration, query string, args []interface{}) (sql.Result, error) {
+	ctx, cancel := withTimeout(ctx, timeout)
+	defer cancel()
+
+	query, args = tx.Dialect.Bind(query, args)
+	return tx.Tx.ExecContext(ctx, query, args...)
+}
+
+func (tx *Tx) query(ctx context.Context, timeout time.Duration, query string, args []interface{}) (*Rows, error) {
+	ctx, cancel := withTimeout(ctx, timeout)
+	query, args = tx.Dialect.Bind(query, args)
+	rows, err := tx.Tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+
+	return &Rows{Rows: rows, cancel: cancel}, nil
+}
+
+func (tx *Tx) queryRow(ctx context.Context, timeout time.Duration, query string, args []interface{}) *Row {
+	ctx, cancel := withTimeout(ctx, timeout)
+	query, args = tx.Dialect.Bind(query, args)
+	return &Row{Row: tx.Tx.QueryRowContext(ctx, query, args...), cancel: cancel}
+}
+
+// Rows are the result of a query. Closing them ends the deadline of the query.
+type Rows struct {
+	*sql.Rows
+	cancel context.CancelFunc
+}
+
+// Close closes the rows.
+func (r *Rows) Close() error {
+	defer r.cancel()
+	return r.Rows.Close()
+}
+
+// Row is the result of a query for at most one row. Scanning it ends the deadline of the query.
+type Row struct {
+	*sql.Row
+	cancel context.CancelFunc
+}
+
+// Scan copies the columns of the row into dest, see sql.Row.
+func (r *Row) Scan(dest ...interface{}) error {
+	defer r.cancel()
+	return r.Row.Scan(dest...)
+}
+
+// withTimeout returns ctx cut off after the timeout, unless the timeout is 0 or ctx ends earlier.
+func withTimeout(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if timeout <= 0 {
+		return ctx, func() {}
+	}
+
+	return context.WithTimeout(ctx, timeout)
+}
+
+// execer is implemented by *DB and *Tx.
+type execer interface {
+	Exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+	QueryRow(ctx context.Context, query string, args ...interface{}) *Row
+}
+
+func insert(ctx context.Context, e execer, dialect Dialect, column, query string, args []interface{}) (int, error) {
+	if returning := dialect.Returning(column); returning != "" {
+		var id int
+		err := e.QueryRow(ctx, query+returning, args...).Scan(&id)
+		if errors.Is(err, sql.ErrNoRows) {
+			return 0, nil
+		}
+		return id, err
+	}
+
+	result, err := e.Exec(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	if rowsAffected == 0 {
+		return 0, nil
+	}
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	return int(id), nil
+}
@@ -0,0 +1,264 @@
+package repositories
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/mattn/go-sqlite3"
+)
+
+// Dialect is the SQL of one kind of database. Repositories write their queries with ? placeholders
+// and the SQL that MySQL, PostgreSQL and SQLite share, and ask the dialect for the rest.
+type Dialect interface {
+	// Name is the name the dialect is selected by, see LookupDialect.
+	Name() string
+
+	// DriverName is the name of the database/sql driver Open uses for the dialect.
+	DriverName() string
+
+	// Bind rewrites the placeholders of a query, and the arguments the driver would store in a
+	// form the queries of the dialect cannot compare, for the database.
+	Bind(query string, args []interface{}) (string, []interface{})
+
+	// Returning is the clause that makes an INSERT return the generated value of a column, or ""
+	// when the driver reports it through LastInsertId instead.
+	Returning(column string) string
+
+	// FromDual is the FROM clause of a SELECT that reads no table, or "" when none is needed.
+	FromDual() string
+
+	// NullSafeEqual is the comparison operator for which two NULLs are equal.
+	NullSafeEqual() string
+
+	// InsertIgnore turns an INSERT into one that skips the rows that would duplicate a key.
+	InsertIgnore(insert string) string
+
+	// Upsert turns an INSERT of one row into one that, when the row would duplicate the unique key
+	// made of the key columns, sets the given columns of the existing row to the inserted values.
+	Upsert(insert string, key []string, columns ...string) string
+
+	// PreciseNow is an expression for the current time with sub-second precision, for columns
+	// that order events happening within the same second.
+	PreciseNow() string
+
+	// MinutesBetween is an expression for the number of minutes from one time to another.
+	MinutesBetween(from, to string) string
+
+	// ForUpdate is the clause ending a SELECT that locks the rows it reads until the transaction
+	// ends, or "" when the database locks for the first write of a transaction instead.
+	ForUpdate() string
+
+	// Retryable reports whether err is a deadlock, serialization failure or lock timeout, after
+	// which the transaction that failed can be run again.
+	Retryable(err error) bool
+}
+
+var (
+	// MySQL is the dialect of MySQL 5.7 and later.
+	MySQL Dialect = mysqlDialect{}
+
+	// PostgreSQL is the dialect of PostgreSQL 10 and later. Times are passed in UTC, as the MySQL
+	// driver does by default, so sessions should use UTC too.
+	PostgreSQL Dialect = postgresDialect{}
+
+	// SQLite is the dialect of SQLite 3.35 and later, which stores times as UTC text. Its
+	// connections need foreign keys enabled, e.g. with _foreign_keys=on in the DSN of
+	// github.com/mattn/go-sqlite3, and the math functions.
+	SQLite Dialect = sqliteDialect{}
+)
+
+// LookupDialect returns the dialect with the given name: mysql, postgres or sqlite.
+func LookupDialect(name string) (Dialect, error) {
+	for _, d := range []Dialect{MySQL, PostgreSQL, SQLite} {
+		if d.Name() == name {
+			return d, nil
+		}
+	}
+
+	return nil, fmt.Errorf("unknown SQL dialect %q", name)
+}
+
+type mysqlDialect struct{}
+
+func (mysqlDialect) Name() string       { return "mysql" }
+func (mysqlDialect) DriverName() string { return "mysql" }
+
+func (mysqlDialect) Bind(query string, args []interface{}) (string, []interface{}) {
+	return query, args
+}
+
+func (mysqlDialect) Returning(column string) string { return "" }
+func (mysqlDialect) FromDual() string               { return "FROM DUAL" }
+func (mysqlDialect) NullSafeEqual() string          { return "<=>" }
+
+func (mysqlDialect) InsertIgnore(insert string) string {
+	return strings.Replace(insert, "INSERT INTO", "INSERT IGNORE INTO", 1)
+}
+
+func (mysqlDialect) Upsert(insert string, key []string, columns ...string) string {
+	set := make([]string, 0, len(columns))
+	for _, c := range columns {
+		set = append(set, c+" = VALUES("+c+")")
+	}
+
+	return insert + " ON DUPLICATE KEY UPDATE " + strings.Join(set, ", ")
+}
+
+func (mysqlDialect) PreciseNow() string { return "NOW(6)" }
+func (mysqlDialect) ForUpdate() string  { return " FOR UPDATE" }
+
+func (mysqlDialect) MinutesBetween(from, to string) string {
+	return "TIMESTAMPDIFF(MINUTE, " + from + ", " + to + ")"
+}
+
+// Retryable matches MySQL errors 1213, a deadlock, and 1205, a lock wait timeout.
+func (mysqlDialect) Retryable(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+
+	return mysqlErr.Number == 1213 || mysqlErr.Number == 1205
+}
+
+type postgresDialect struct{}
+
+func (postgresDialect) Name() string       { return "postgres" }
+func (postgresDialect) DriverName() string { return "postgres" }
+
+// Bind numbers the placeholders $1, $2 and so on, and passes times in UTC.
+func (postgresDialect) Bind(query string, args []interface{}) (string, []interface{}) {
+	var b strings.Builder
+	var quote rune
+	n := 0
+
+	for _, c := range query {
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+
+	return b.String(), convertTimes(args, func(t time.Time) interface{} { return t.UTC() })
+}
+
+func (postgresDialect) Returning(column string) string { return " RETURNING " + column }
+func (postgresDialect) FromDual() string               { return "" }
+func (postgresDialect) NullSafeEqual() string          { return "IS NOT DISTINCT FROM" }
+
+func (postgresDialect) InsertIgnore(insert string) string {
+	return insert + " ON CONFLICT DO NOTHING"
+}
+
+func (postgresDialect) Upsert(insert string, key []string, columns ...string) string {
+	return onConflictUpdate(insert, key, columns)
+}
+
+// PreciseNow is the time of the statement rather than of the transaction, like NOW(6) on MySQL.
+func (postgresDialect) PreciseNow() string { return "CLOCK_TIMESTAMP()" }
+
+func (postgresDialect) ForUpdate() string { return " FOR UPDATE" }
+
+func (postgresDialect) MinutesBetween(from, to string) string {
+	return "EXTRACT(EPOCH FROM (" + to + " - " + from + ")) / 60"
+}
+
+// Retryable matches the SQLSTATE codes of serialization failures and deadlocks, which both
+// github.com/lib/pq and github.com/jackc/pgx report through a SQLState method.
+func (postgresDialect) Retryable(err error) bool {
+	var state interface{ SQLState() string }
+	if !errors.As(err, &state) {
+		return false
+	}
+
+	return state.SQLState() == "40001" || state.SQLState() == "40P01"
+}
+
+type sqliteDialect struct{}
+
+func (sqliteDialect) Name() string       { return "sqlite" }
+func (sqliteDialect) DriverName() string { return "sqlite3" }
+
+// sqliteTimeFormat is the format of the times SQLite stores for CURRENT_TIMESTAMP, with the
+// fraction of a second added. Times in it compare as text in time order.
+const sqliteTimeFormat = "2006-01-02 15:04:05.999999999"
+
+// Bind passes times in UTC, in the format of CURRENT_TIMESTAMP, since SQLite compares them as text.
+func (sqliteDialect) Bind(query string, args []interface{}) (string, []interface{}) {
+	return query, convertTimes(args, func(t time.Time) interface{} { return t.UTC().Format(sqliteTimeFormat) })
+}
+
+func (sqliteDialect) Returning(column string) string { return "" }
+func (sqliteDialect) FromDual() string               { return "" }
+func (sqliteDialect) NullSafeEqual() string          { return "IS" }
+
+func (sqliteDialect) InsertIgnore(insert string) string {
+	return insert + " ON CONFLICT DO NOTHING"
+}
+
+func (sqliteDialect) Upsert(insert string, key []string, columns ...string) string {
+	return onConflictUpdate(insert, key, columns)
+}
+
+func (sqliteDialect) PreciseNow() string { return "STRFTIME('%Y-%m-%d %H:%M:%f', 'now')" }
+
+// ForUpdate is empty: a transaction takes the lock of the whole database with its first write,
+// and SELECT cannot lock rows.
+func (sqliteDialect) ForUpdate() string { return "" }
+
+func (sqliteDialect) MinutesBetween(from, to string) string {
+	return "(JULIANDAY(" + to + ") - JULIANDAY(" + from + ")) * 1440"
+}
+
+// Retryable matches SQLITE_BUSY, returned when another connection holds the write lock for longer
+// than the busy timeout, and SQLITE_LOCKED, returned on a conflict within a shared cache.
+func (sqliteDialect) Retryable(err error) bool {
+	var sqliteErr sqlite3.Error
+	if !errors.As(err, &sqliteErr) {
+		return false
+	}
+
+	return sqliteErr.Code == sqlite3.ErrBusy || sqliteErr.Code == sqlite3.ErrLocked
+}
+
+// convertTimes returns args with the times, and the times pointed to, replaced by convert.
+func convertTimes(args []interface{}, convert func(t time.Time) interface{}) []interface{} {
+	converted := make([]interface{}, len(args))
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			converted[i] = convert(v)
+		case *time.Time:
+			if v != nil {
+				converted[i] = convert(*v)
+			}
+		default:
+			converted[i] = arg
+		}
+	}
+
+	return converted
+}
+
+// onConflictUpdate is Upsert in the syntax PostgreSQL and SQLite share.
+func onConflictUpdate(insert string, key []string, columns []string) string {
+	set := make([]string, 0, len(columns))
+	for _, c := range columns {
+		set = append(set, c+" = excluded."+c)
+	}
+
+	return insert + " ON CONFLICT (" + strings.Join(key, ", ") + ") DO UPDATE SET " + strings.Join(set, ", ")
+}
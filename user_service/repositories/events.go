@@ -0,0 +1,259 @@
+package repositories
+
+import "context"
+
+// The repositories returned by NewUserRepository, NewRoleRepository, NewAccessRepository,
+// NewUserRoleRepository, NewRoleAccessRepository and NewContactHistoryRepository wrap their SQL implementations so that every
+// write appends its domain event to the outbox in the same transaction. Reads go straight to the
+// implementation, and purges append nothing: the records were announced as deleted already.
+
+type eventUserRepository struct {
+	UserRepository
+	db *DB
+}
+
+func (r *eventUserRepository) Create(ctx context.Context, user *User) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&userRepository{db: db}).Create(ctx, user); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateUser, user.ID, EventUserCreated, user)
+	})
+}
+
+func (r *eventUserRepository) Update(ctx context.Context, user *User) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&userRepository{db: db}).Update(ctx, user); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateUser, user.ID, EventUserUpdated, user)
+	})
+}
+
+func (r *eventUserRepository) Patch(ctx context.Context, user *User, fields []string) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&userRepository{db: db}).Patch(ctx, user, fields); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateUser, user.ID, EventUserUpdated, user)
+	})
+}
+
+func (r *eventUserRepository) Delete(ctx context.Context, id, version, deletedBy int) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&userRepository{db: db}).Delete(ctx, id, version, deletedBy); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateUser, id, EventUserDeleted, &deletedPayload{ID: id, Version: version, DeletedBy: deletedBy})
+	})
+}
+
+// UpdatePassword appends a PasswordChanged event naming the user; the password stays out of it.
+func (r *eventUserRepository) UpdatePassword(ctx context.Context, userID int, password string) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&userRepository{db: db}).UpdatePassword(ctx, userID, password); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateUser, userID, EventPasswordChanged, &referencePayload{ID: userID})
+	})
+}
+
+func (r *eventUserRepository) Restore(ctx context.Context, id int) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&userRepository{db: db}).Restore(ctx, id); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateUser, id, EventUserRestored, &referencePayload{ID: id})
+	})
+}
+
+type eventRoleRepository struct {
+	RoleRepository
+	db *DB
+}
+
+func (r *eventRoleRepository) Create(ctx context.Context, role *Role) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&roleRepository{db: db}).Create(ctx, role); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateRole, role.ID, EventRoleCreated, role)
+	})
+}
+
+func (r *eventRoleRepository) Update(ctx context.Context, role *Role) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&roleRepository{db: db}).Update(ctx, role); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateRole, role.ID, EventRoleUpdated, role)
+	})
+}
+
+func (r *eventRoleRepository) Patch(ctx context.Context, role *Role, fields []string) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&roleRepository{db: db}).Patch(ctx, role, fields); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateRole, role.ID, EventRoleUpdated, role)
+	})
+}
+
+func (r *eventRoleRepository) Delete(ctx context.Context, id, version, deletedBy int) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&roleRepository{db: db}).Delete(ctx, id, version, deletedBy); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateRole, id, EventRoleDeleted, &deletedPayload{ID: id, Version: version, DeletedBy: deletedBy})
+	})
+}
+
+func (r *eventRoleRepository) Restore(ctx context.Context, id int) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&roleRepository{db: db}).Restore(ctx, id); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateRole, id, EventRoleRestored, &referencePayload{ID: id})
+	})
+}
+
+type eventAccessRepository struct {
+	AccessRepository
+	db *DB
+}
+
+func (r *eventAccessRepository) Create(ctx context.Context, access *Access) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&accessRepository{db: db}).Create(ctx, access); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateAccess, access.ID, EventAccessCreated, access)
+	})
+}
+
+func (r *eventAccessRepository) Update(ctx context.Context, access *Access) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&accessRepository{db: db}).Update(ctx, access); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateAccess, access.ID, EventAccessUpdated, access)
+	})
+}
+
+func (r *eventAccessRepository) Patch(ctx context.Context, access *Access, fields []string) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&accessRepository{db: db}).Patch(ctx, access, fields); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateAccess, access.ID, EventAccessUpdated, access)
+	})
+}
+
+func (r *eventAccessRepository) Delete(ctx context.Context, id, version, deletedBy int) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&accessRepository{db: db}).Delete(ctx, id, version, deletedBy); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateAccess, id, EventAccessDeleted, &deletedPayload{ID: id, Version: version, DeletedBy: deletedBy})
+	})
+}
+
+func (r *eventAccessRepository) Restore(ctx context.Context, id int) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&accessRepository{db: db}).Restore(ctx, id); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateAccess, id, EventAccessRestored, &referencePayload{ID: id})
+	})
+}
+
+// The roles of a user belong to the user aggregate, so that a consumer sees them in order with
+// the other changes of the user, and the accesses of a role to the role aggregate.
+
+type eventUserRoleRepository struct {
+	UserRoleRepository
+	db *DB
+}
+
+func (r *eventUserRoleRepository) Create(ctx context.Context, ur *UserRole) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&userRoleRepository{db: db}).Create(ctx, ur); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateUser, ur.UserID, EventRoleAssigned, ur)
+	})
+}
+
+func (r *eventUserRoleRepository) Update(ctx context.Context, ur *UserRole) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&userRoleRepository{db: db}).Update(ctx, ur); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateUser, ur.UserID, EventRoleExpiryChanged, ur)
+	})
+}
+
+func (r *eventUserRoleRepository) Delete(ctx context.Context, userID, roleID int) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&userRoleRepository{db: db}).Delete(ctx, userID, roleID); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateUser, userID, EventRoleUnassigned, &userRolePayload{UserID: userID, RoleID: roleID})
+	})
+}
+
+type eventRoleAccessRepository struct {
+	RoleAccessRepository
+	db *DB
+}
+
+func (r *eventRoleAccessRepository) Create(ctx context.Context, ra *RoleAccess) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&roleAccessRepository{db: db}).Create(ctx, ra); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateRole, ra.RoleID, EventAccessGranted, ra)
+	})
+}
+
+func (r *eventRoleAccessRepository) Delete(ctx context.Context, roleID, accessID int) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		if err := (&roleAccessRepository{db: db}).Delete(ctx, roleID, accessID); err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateRole, roleID, EventAccessRevoked, &RoleAccess{RoleID: roleID, AccessID: accessID})
+	})
+}
+
+// contactEventTypes are the types of the contact events by the action of the history version.
+var contactEventTypes = map[string]string{
+	ContactActionCreate:   EventContactCreated,
+	ContactActionUpdate:   EventContactUpdated,
+	ContactActionDelete:   EventContactDeleted,
+	ContactActionUndelete: EventContactRestored,
+	ContactActionRestore:  EventContactReverted,
+}
+
+// The writes of a ContactWriter record a history version in the transaction of the contact write.
+// The event of the contact is appended with that version, so there is an event for every version
+// and neither is stored without the write.
+
+type eventContactHistoryRepository struct {
+	ContactHistoryRepository
+	db *DB
+}
+
+func (r *eventContactHistoryRepository) Record(ctx context.Context, v *ContactVersion) error {
+	return withEvent(ctx, r.db, func(db *DB) (*OutboxEvent, error) {
+		history := &contactHistoryRepository{db: db}
+		if err := history.Record(ctx, v); err != nil {
+			return nil, err
+		}
+		// read the version back for its created date, set by the database
+		recorded, err := history.Get(ctx, v.ContactID, v.Version)
+		if err != nil {
+			return nil, err
+		}
+		return newEvent(AggregateContact, v.ContactID, contactEventTypes[v.Action], recorded)
+	})
+}
@@ -0,0 +1,199 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+type Group struct {
+	ID           int
+	UserID       int
+	Name         string
+	ContactCount int
+}
+
+// GroupSortFields are the fields a page of groups can be sorted by.
+var GroupSortFields = []string{"id", "name"}
+
+// SortKey returns the position of the group in a list sorted by the given field.
+func (g *Group) SortKey(field string) SortKey {
+	if field == "name" {
+		return SortKey{Value: g.Name, ID: g.ID}
+	}
+
+	return SortKey{ID: g.ID}
+}
+
+type GroupRepository interface {
+	Create(context.Context, *Group) error
+	Get(context.Context, int) (*Group, error)
+	Update(context.Context, *Group) error
+	Delete(context.Context, int) error
+	GetAll(ctx context.Context, userID int) ([]*Group, error)
+	AddContacts(ctx context.Context, groupID int, contactIDs []int) error
+	RemoveContacts(ctx context.Context, groupID int, contactIDs []int) error
+	GetGroupsForContact(ctx context.Context, contactID int) ([]*Group, error)
+}
+
+type groupRepository struct {
+	db *DB
+}
+
+// NewGroupRepository creates a new GroupRepository using the provided database connection.
+func NewGroupRepository(db *DB) GroupRepository {
+	return &groupRepository{db: db}
+}
+
+// Create inserts a new group into the database and sets its ID.
+func (r *groupRepository) Create(ctx context.Context, g *Group) error {
+	query := "INSERT INTO contact_groups (user_id, group_name, created_date, updated_date) VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)"
+	id, err := r.db.Insert(ctx, "group_id", query, g.UserID, g.Name)
+	if err != nil {
+		return err
+	}
+
+	g.ID = id
+
+	return nil
+}
+
+// Get retrieves a group together with its member count by ID.
+func (r *groupRepository) Get(ctx context.Context, id int) (*Group, error) {
+	query := `SELECT g.group_id, g.user_id, g.group_name, COUNT(gm.contact_id)
+		FROM contact_groups g
+		LEFT JOIN (contact_group_members gm JOIN contacts c ON gm.contact_id = c.contact_id AND c.deleted_date IS NULL) ON g.group_id = gm.group_id
+		WHERE g.group_id = ?
+		GROUP BY g.group_id, g.user_id, g.group_name`
+	row := r.db.QueryRow(ctx, query, id)
+	g := &Group{}
+	err := row.Scan(&g.ID, &g.UserID, &g.Name, &g.ContactCount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid group id")
+		}
+		return nil, err
+	}
+	return g, nil
+}
+
+// Update renames an existing group.
+func (r *groupRepository) Update(ctx context.Context, g *Group) error {
+	query := "UPDATE contact_groups SET group_name = ?, updated_date = CURRENT_TIMESTAMP WHERE group_id = ?"
+	result, err := r.db.Exec(ctx, query, g.Name, g.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return nil
+}
+
+// Delete removes a group by ID. Memberships are removed with it, the contacts are kept.
+func (r *groupRepository) Delete(ctx context.Context, id int) error {
+	query := "DELETE FROM contact_groups WHERE group_id = ?"
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return nil
+}
+
+// GetAll retrieves all groups of a user together with their member counts.
+func (r *groupRepository) GetAll(ctx context.Context, userID int) ([]*Group, error) {
+	query := `SELECT g.group_id, g.user_id, g.group_name, COUNT(gm.contact_id)
+		FROM contact_groups g
+		LEFT JOIN (contact_group_members gm JOIN contacts c ON gm.contact_id = c.contact_id AND c.deleted_date IS NULL) ON g.group_id = gm.group_id
+		WHERE g.user_id = ?
+		GROUP BY g.group_id, g.user_id, g.group_name
+		ORDER BY g.group_name`
+	return r.query(ctx, query, userID)
+}
+
+// AddContacts adds the given contacts to a group. Contacts that are already members are ignored.
+func (r *groupRepository) AddContacts(ctx context.Context, groupID int, contactIDs []int) error {
+	if len(contactIDs) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO contact_group_members (group_id, contact_id, created_date) VALUES "
+	args := make([]interface{}, 0, len(contactIDs)*2)
+	for i, contactID := range contactIDs {
+		if i > 0 {
+			query += ", "
+		}
+		query += "(?, ?, CURRENT_TIMESTAMP)"
+		args = append(args, groupID, contactID)
+	}
+
+	_, err := r.db.Exec(ctx, r.db.Dialect.InsertIgnore(query), args...)
+	return err
+}
+
+// RemoveContacts removes the given contacts from a group.
+func (r *groupRepository) RemoveContacts(ctx context.Context, groupID int, contactIDs []int) error {
+	if len(contactIDs) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf("DELETE FROM contact_group_members WHERE group_id = ? AND contact_id IN (%s)", placeholders(len(contactIDs)))
+	args := append([]interface{}{groupID}, intArgs(contactIDs)...)
+	_, err := r.db.Exec(ctx, query, args...)
+	return err
+}
+
+// GetGroupsForContact retrieves all groups a contact is a member of.
+func (r *groupRepository) GetGroupsForContact(ctx context.Context, contactID int) ([]*Group, error) {
+	query := `SELECT g.group_id, g.user_id, g.group_name, COUNT(all_gm.contact_id)
+		FROM contact_groups g
+		JOIN contact_group_members gm ON g.group_id = gm.group_id AND gm.contact_id = ?
+		LEFT JOIN (contact_group_members all_gm JOIN contacts c ON all_gm.contact_id = c.contact_id AND c.deleted_date IS NULL) ON g.group_id = all_gm.group_id
+		GROUP BY g.group_id, g.user_id, g.group_name
+		ORDER BY g.group_name`
+	return r.query(ctx, query, contactID)
+}
+
+func (r *groupRepository) query(ctx context.Context, query string, args ...interface{}) ([]*Group, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	groups := []*Group{}
+
+	for rows.Next() {
+		g := &Group{}
+		err := rows.Scan(&g.ID, &g.UserID, &g.Name, &g.ContactCount)
+		if err != nil {
+			return nil, err
+		}
+		groups = append(groups, g)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return groups, nil
+}
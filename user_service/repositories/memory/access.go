@@ -0,0 +1,315 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// accessRecord is a stored access object and, once soft-deleted, its deletion details.
+type accessRecord struct {
+	access  repositories.Access
+	deleted *repositories.DeletionInfo
+}
+
+// accessRepository implements repositories.AccessRepository in memory.
+type accessRepository struct {
+	mu       sync.Mutex
+	lastID   int
+	accesses map[int]*accessRecord
+
+	// grants are the grants of the role access repository created on the access repository, for
+	// the RoleID of AccessFilter. NewRoleAccessRepository sets them.
+	grants *roleAccessRepository
+}
+
+// NewAccessRepository returns an empty in-memory AccessRepository.
+func NewAccessRepository() repositories.AccessRepository {
+	return &accessRepository{accesses: map[int]*accessRecord{}}
+}
+
+// nameTaken reports whether an access object other than the one with the given ID, live or
+// deleted, has the given name. The caller holds the lock.
+func (r *accessRepository) nameTaken(name string, id int) bool {
+	for _, rec := range r.accesses {
+		if rec.access.Name == name && rec.access.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// live returns the access object with the given ID unless it is missing or in the trash. The
+// caller holds the lock.
+func (r *accessRepository) live(id int) *accessRecord {
+	rec, ok := r.accesses[id]
+	if !ok || rec.deleted != nil {
+		return nil
+	}
+	return rec
+}
+
+// Create stores a new access object and sets its ID.
+func (r *accessRepository) Create(ctx context.Context, access *repositories.Access) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameTaken(access.Name, 0) {
+		return repositories.ErrAccessNameTaken
+	}
+
+	r.lastID++
+	access.ID = r.lastID
+	access.Version = 1
+	r.accesses[access.ID] = &accessRecord{access: *access}
+
+	return nil
+}
+
+// Get returns the access object with the given ID.
+func (r *accessRepository) Get(ctx context.Context, id int) (*repositories.Access, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec := r.live(id)
+	if rec == nil {
+		return nil, errors.New("invalid access id")
+	}
+
+	access := rec.access
+	return &access, nil
+}
+
+// GetPage returns a page of the access objects outside the trash selected by the filter. Access
+// objects are granted to roles in the RoleAccessRepository created on r.
+func (r *accessRepository) GetPage(ctx context.Context, filter repositories.AccessFilter, page repositories.PageRequest) ([]*repositories.Access, *repositories.PageInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := page.Validate(repositories.AccessSortFields...); err != nil {
+		return nil, nil, err
+	}
+
+	// The grants are read before taking the lock, as the role access repository takes its lock
+	// before the one of r
+	var granted map[int]bool
+	if filter.RoleID != 0 {
+		granted = map[int]bool{}
+		if r.grants != nil {
+			granted = r.grants.accessIDs(filter.RoleID)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	selected := []*repositories.Access{}
+	for _, rec := range r.accesses {
+		if rec.deleted == nil && (granted == nil || granted[rec.access.ID]) &&
+			strings.HasPrefix(strings.ToLower(rec.access.Name), strings.ToLower(filter.NamePrefix)) {
+			access := rec.access
+			selected = append(selected, &access)
+		}
+	}
+
+	indexes, info := repositories.PageSlice(len(selected), func(i int) repositories.SortKey { return selected[i].SortKey(page.Sort) }, page)
+	accesses := make([]*repositories.Access, 0, len(indexes))
+	for _, i := range indexes {
+		accesses = append(accesses, selected[i])
+	}
+
+	return accesses, info, nil
+}
+
+// Update renames an access object at the version it was read at.
+func (r *accessRepository) Update(ctx context.Context, access *repositories.Access) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameTaken(access.Name, access.ID) {
+		return repositories.ErrAccessNameTaken
+	}
+
+	rec := r.live(access.ID)
+	if rec == nil {
+		return errors.New("no rows were affected during the update")
+	}
+
+	if rec.access.Version != access.Version {
+		return repositories.ErrVersionConflict
+	}
+
+	access.Version++
+	rec.access = *access
+
+	return nil
+}
+
+// Patch replaces the named fields of an access object at the version it was read at.
+func (r *accessRepository) Patch(ctx context.Context, access *repositories.Access, fields []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		switch field {
+		case "name":
+		default:
+			return fmt.Errorf("cannot patch the %s field of a access", field)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if hasField(fields, "name") && r.nameTaken(access.Name, access.ID) {
+		return repositories.ErrAccessNameTaken
+	}
+
+	rec := r.live(access.ID)
+	if rec == nil {
+		return errors.New("no rows were affected during the update")
+	}
+
+	if rec.access.Version != access.Version {
+		return repositories.ErrVersionConflict
+	}
+
+	for _, field := range fields {
+		switch field {
+		case "name":
+			rec.access.Name = access.Name
+		}
+	}
+
+	rec.access.Version++
+	access.Version = rec.access.Version
+
+	return nil
+}
+
+// Delete moves an access object at the given version to the trash.
+func (r *accessRepository) Delete(ctx context.Context, id, version, deletedBy int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec := r.live(id)
+	if rec == nil {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	if rec.access.Version != version {
+		return repositories.ErrVersionConflict
+	}
+
+	rec.deleted = &repositories.DeletionInfo{DeletedDate: time.Now(), DeletedBy: deletedBy}
+
+	return nil
+}
+
+// GetAll returns all access objects outside the trash, ordered by ID.
+func (r *accessRepository) GetAll(ctx context.Context) ([]*repositories.Access, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	accesses := []*repositories.Access{}
+	for _, rec := range r.accesses {
+		if rec.deleted == nil {
+			access := rec.access
+			accesses = append(accesses, &access)
+		}
+	}
+
+	sort.Slice(accesses, func(i, j int) bool { return accesses[i].ID < accesses[j].ID })
+
+	return accesses, nil
+}
+
+// GetDeleted returns the access objects in the trash, most recently deleted first.
+func (r *accessRepository) GetDeleted(ctx context.Context) ([]*repositories.DeletedAccess, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	accesses := []*repositories.DeletedAccess{}
+	for _, rec := range r.accesses {
+		if rec.deleted != nil {
+			accesses = append(accesses, &repositories.DeletedAccess{Access: rec.access, DeletionInfo: *rec.deleted})
+		}
+	}
+
+	sort.Slice(accesses, func(i, j int) bool {
+		return deletedLater(accesses[i].DeletionInfo, accesses[i].ID, accesses[j].DeletionInfo, accesses[j].ID)
+	})
+
+	return accesses, nil
+}
+
+// Restore moves an access object out of the trash.
+func (r *accessRepository) Restore(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec, ok := r.accesses[id]
+	if !ok || rec.deleted == nil {
+		return errors.New("no deleted access found with the given id")
+	}
+
+	rec.deleted = nil
+
+	return nil
+}
+
+// Purge removes the access objects deleted before the given time and returns how many were removed.
+func (r *accessRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var n int64
+	for id, rec := range r.accesses {
+		if rec.deleted != nil && rec.deleted.DeletedDate.Before(before) {
+			delete(r.accesses, id)
+			n++
+		}
+	}
+
+	return n, nil
+}
@@ -0,0 +1,66 @@
+// Package memory implements the user, role and access repositories in memory, for unit tests and
+// local development without a database. The implementations are safe for concurrent use and behave
+// like their SQL counterparts: they enforce the same unique constraints, report missing records
+// with the same errors, keep soft-deleted records in the trash until they are purged and fail with
+// the error of the context once it is cancelled. The repotest package checks both against the same
+// expectations. Unlike their SQL counterparts, they append no domain events: the outbox is a table.
+package memory
+
+import (
+	"sync"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// purgeHooks are the functions a repository calls with the ID of every record it purges, so that
+// the repositories of records referring to it drop them, as the foreign keys of the database do.
+type purgeHooks struct {
+	mu    sync.Mutex
+	hooks []func(id int)
+}
+
+// onPurge registers fn to be called with the ID of every purged record.
+func (h *purgeHooks) onPurge(fn func(id int)) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	h.hooks = append(h.hooks, fn)
+}
+
+// purged calls the hooks with the IDs of the purged records. The caller must not hold the lock of
+// its repository, as the hooks take the locks of others.
+func (h *purgeHooks) purged(ids []int) {
+	h.mu.Lock()
+	hooks := h.hooks
+	h.mu.Unlock()
+
+	for _, id := range ids {
+		for _, fn := range hooks {
+			fn(id)
+		}
+	}
+}
+
+// purgeNotifier is implemented by the repositories calling purgeHooks.
+type purgeNotifier interface {
+	onPurge(fn func(id int))
+}
+
+// deletedLater reports whether record a was deleted after record b, for listing the trash most
+// recently deleted first. Records deleted at the same time are ordered by descending ID.
+func deletedLater(a repositories.DeletionInfo, aID int, b repositories.DeletionInfo, bID int) bool {
+	if !a.DeletedDate.Equal(b.DeletedDate) {
+		return a.DeletedDate.After(b.DeletedDate)
+	}
+	return aID > bID
+}
+
+// hasField reports whether the named field is among the fields of a patch.
+func hasField(fields []string, name string) bool {
+	for _, f := range fields {
+		if f == name {
+			return true
+		}
+	}
+	return false
+}
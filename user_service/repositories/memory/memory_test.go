@@ -0,0 +1,22 @@
+package memory_test
+
+import (
+	"testing"
+
+	"github.com/princeparmar/contact_manager/repositories/memory"
+	"github.com/princeparmar/contact_manager/repositories/repotest"
+)
+
+func TestMemoryRepositories(t *testing.T) {
+	repotest.Run(t, func(t *testing.T) *repotest.Stores {
+		users, roles, accesses := memory.NewUserRepository(), memory.NewRoleRepository(), memory.NewAccessRepository()
+		roleAccesses := memory.NewRoleAccessRepository(accesses)
+		return &repotest.Stores{
+			Users:        users,
+			Roles:        roles,
+			Accesses:     accesses,
+			RoleAccesses: roleAccesses,
+			UserRoles:    memory.NewUserRoleRepository(users, roles, roleAccesses),
+		}
+	})
+}
@@ -0,0 +1,302 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// roleRecord is a stored role and, once soft-deleted, its deletion details.
+type roleRecord struct {
+	role    repositories.Role
+	deleted *repositories.DeletionInfo
+}
+
+// roleRepository implements repositories.RoleRepository in memory.
+type roleRepository struct {
+	purgeHooks
+
+	mu     sync.Mutex
+	lastID int
+	roles  map[int]*roleRecord
+}
+
+// NewRoleRepository returns an empty in-memory RoleRepository.
+func NewRoleRepository() repositories.RoleRepository {
+	return &roleRepository{roles: map[int]*roleRecord{}}
+}
+
+// nameTaken reports whether a role other than the one with the given ID, live or deleted, has the
+// given name. The caller holds the lock.
+func (r *roleRepository) nameTaken(name string, id int) bool {
+	for _, rec := range r.roles {
+		if rec.role.Name == name && rec.role.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// live returns the role with the given ID unless it is missing or in the trash. The caller holds
+// the lock.
+func (r *roleRepository) live(id int) *roleRecord {
+	rec, ok := r.roles[id]
+	if !ok || rec.deleted != nil {
+		return nil
+	}
+	return rec
+}
+
+// Create stores a new role and sets its ID.
+func (r *roleRepository) Create(ctx context.Context, role *repositories.Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameTaken(role.Name, 0) {
+		return repositories.ErrRoleNameTaken
+	}
+
+	r.lastID++
+	role.ID = r.lastID
+	role.Version = 1
+	r.roles[role.ID] = &roleRecord{role: *role}
+
+	return nil
+}
+
+// Get returns the role with the given ID.
+func (r *roleRepository) Get(ctx context.Context, id int) (*repositories.Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec := r.live(id)
+	if rec == nil {
+		return nil, errors.New("invalid role id")
+	}
+
+	role := rec.role
+	return &role, nil
+}
+
+// GetPage returns a page of the roles outside the trash selected by the filter.
+func (r *roleRepository) GetPage(ctx context.Context, filter repositories.RoleFilter, page repositories.PageRequest) ([]*repositories.Role, *repositories.PageInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := page.Validate(repositories.RoleSortFields...); err != nil {
+		return nil, nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	selected := []*repositories.Role{}
+	for _, rec := range r.roles {
+		if rec.deleted == nil && strings.HasPrefix(strings.ToLower(rec.role.Name), strings.ToLower(filter.NamePrefix)) {
+			role := rec.role
+			selected = append(selected, &role)
+		}
+	}
+
+	indexes, info := repositories.PageSlice(len(selected), func(i int) repositories.SortKey { return selected[i].SortKey(page.Sort) }, page)
+	roles := make([]*repositories.Role, 0, len(indexes))
+	for _, i := range indexes {
+		roles = append(roles, selected[i])
+	}
+
+	return roles, info, nil
+}
+
+// Update renames a role at the version it was read at.
+func (r *roleRepository) Update(ctx context.Context, role *repositories.Role) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameTaken(role.Name, role.ID) {
+		return repositories.ErrRoleNameTaken
+	}
+
+	rec := r.live(role.ID)
+	if rec == nil {
+		return errors.New("no rows were affected during the update")
+	}
+
+	if rec.role.Version != role.Version {
+		return repositories.ErrVersionConflict
+	}
+
+	role.Version++
+	rec.role = *role
+
+	return nil
+}
+
+// Patch replaces the named fields of a role at the version it was read at.
+func (r *roleRepository) Patch(ctx context.Context, role *repositories.Role, fields []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		switch field {
+		case "name":
+		default:
+			return fmt.Errorf("cannot patch the %s field of a role", field)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if hasField(fields, "name") && r.nameTaken(role.Name, role.ID) {
+		return repositories.ErrRoleNameTaken
+	}
+
+	rec := r.live(role.ID)
+	if rec == nil {
+		return errors.New("no rows were affected during the update")
+	}
+
+	if rec.role.Version != role.Version {
+		return repositories.ErrVersionConflict
+	}
+
+	for _, field := range fields {
+		switch field {
+		case "name":
+			rec.role.Name = role.Name
+		}
+	}
+
+	rec.role.Version++
+	role.Version = rec.role.Version
+
+	return nil
+}
+
+// Delete moves a role at the given version to the trash.
+func (r *roleRepository) Delete(ctx context.Context, id, version, deletedBy int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec := r.live(id)
+	if rec == nil {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	if rec.role.Version != version {
+		return repositories.ErrVersionConflict
+	}
+
+	rec.deleted = &repositories.DeletionInfo{DeletedDate: time.Now(), DeletedBy: deletedBy}
+
+	return nil
+}
+
+// GetAll returns all roles outside the trash, ordered by ID.
+func (r *roleRepository) GetAll(ctx context.Context) ([]*repositories.Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	roles := []*repositories.Role{}
+	for _, rec := range r.roles {
+		if rec.deleted == nil {
+			role := rec.role
+			roles = append(roles, &role)
+		}
+	}
+
+	sort.Slice(roles, func(i, j int) bool { return roles[i].ID < roles[j].ID })
+
+	return roles, nil
+}
+
+// GetDeleted returns the roles in the trash, most recently deleted first.
+func (r *roleRepository) GetDeleted(ctx context.Context) ([]*repositories.DeletedRole, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	roles := []*repositories.DeletedRole{}
+	for _, rec := range r.roles {
+		if rec.deleted != nil {
+			roles = append(roles, &repositories.DeletedRole{Role: rec.role, DeletionInfo: *rec.deleted})
+		}
+	}
+
+	sort.Slice(roles, func(i, j int) bool {
+		return deletedLater(roles[i].DeletionInfo, roles[i].ID, roles[j].DeletionInfo, roles[j].ID)
+	})
+
+	return roles, nil
+}
+
+// Restore moves a role out of the trash.
+func (r *roleRepository) Restore(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec, ok := r.roles[id]
+	if !ok || rec.deleted == nil {
+		return errors.New("no deleted role found with the given id")
+	}
+
+	rec.deleted = nil
+
+	return nil
+}
+
+// Purge removes the roles deleted before the given time and returns how many were removed.
+func (r *roleRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	var ids []int
+	for id, rec := range r.roles {
+		if rec.deleted != nil && rec.deleted.DeletedDate.Before(before) {
+			delete(r.roles, id)
+			ids = append(ids, id)
+		}
+	}
+	r.mu.Unlock()
+
+	r.purged(ids)
+
+	return int64(len(ids)), nil
+}
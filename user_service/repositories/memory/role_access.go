@@ -0,0 +1,148 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// roleAccessRepository implements repositories.RoleAccessRepository in memory.
+type roleAccessRepository struct {
+	mu       sync.Mutex
+	grants   map[repositories.RoleAccess]bool
+	accesses repositories.AccessRepository
+}
+
+// NewRoleAccessRepository returns an empty in-memory RoleAccessRepository. The access objects of
+// a role are looked up in accesses, which lists the access objects of a role from its grants when
+// it is an in-memory AccessRepository.
+func NewRoleAccessRepository(accesses repositories.AccessRepository) repositories.RoleAccessRepository {
+	r := &roleAccessRepository{grants: map[repositories.RoleAccess]bool{}, accesses: accesses}
+	if a, ok := accesses.(*accessRepository); ok {
+		a.grants = r
+	}
+
+	return r
+}
+
+// accessIDs returns the IDs of the access objects granted to a role.
+func (r *roleAccessRepository) accessIDs(roleID int) map[int]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ids := map[int]bool{}
+	for ra := range r.grants {
+		if ra.RoleID == roleID {
+			ids[ra.AccessID] = true
+		}
+	}
+
+	return ids
+}
+
+// Create grants an access to a role.
+func (r *roleAccessRepository) Create(ctx context.Context, ra *repositories.RoleAccess) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.grants[*ra] {
+		return repositories.ErrRoleAccessExists
+	}
+
+	r.grants[*ra] = true
+
+	return nil
+}
+
+// Get returns the grant of an access to a role.
+func (r *roleAccessRepository) Get(ctx context.Context, roleID, accessID int) (*repositories.RoleAccess, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ra := repositories.RoleAccess{RoleID: roleID, AccessID: accessID}
+	if !r.grants[ra] {
+		return nil, errors.New("the role does not have this access")
+	}
+
+	return &ra, nil
+}
+
+// Delete revokes an access from a role.
+func (r *roleAccessRepository) Delete(ctx context.Context, roleID, accessID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ra := repositories.RoleAccess{RoleID: roleID, AccessID: accessID}
+	if !r.grants[ra] {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	delete(r.grants, ra)
+
+	return nil
+}
+
+// GetAll returns all grants, ordered by role and access ID.
+func (r *roleAccessRepository) GetAll(ctx context.Context) ([]*repositories.RoleAccess, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	roleAccesses := []*repositories.RoleAccess{}
+	for ra := range r.grants {
+		ra := ra
+		roleAccesses = append(roleAccesses, &ra)
+	}
+
+	sort.Slice(roleAccesses, func(i, j int) bool {
+		if roleAccesses[i].RoleID != roleAccesses[j].RoleID {
+			return roleAccesses[i].RoleID < roleAccesses[j].RoleID
+		}
+		return roleAccesses[i].AccessID < roleAccesses[j].AccessID
+	})
+
+	return roleAccesses, nil
+}
+
+// GetAccessesForRole returns the access objects granted to a role, ordered by ID and leaving out
+// the ones in the trash.
+func (r *roleAccessRepository) GetAccessesForRole(ctx context.Context, roleID int) ([]*repositories.Access, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	all, err := r.accesses.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	accesses := []*repositories.Access{}
+	for _, access := range all {
+		if r.grants[repositories.RoleAccess{RoleID: roleID, AccessID: access.ID}] {
+			accesses = append(accesses, access)
+		}
+	}
+
+	return accesses, nil
+}
@@ -0,0 +1,374 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// userRecord is a stored user with its password and, once soft-deleted, its deletion details.
+type userRecord struct {
+	user     repositories.User
+	password string
+	deleted  *repositories.DeletionInfo
+}
+
+// userRepository implements repositories.UserRepository in memory.
+type userRepository struct {
+	purgeHooks
+
+	mu     sync.Mutex
+	lastID int
+	users  map[int]*userRecord
+}
+
+// NewUserRepository returns an empty in-memory UserRepository.
+func NewUserRepository() repositories.UserRepository {
+	return &userRepository{users: map[int]*userRecord{}}
+}
+
+// nameTaken reports whether a user other than the one with the given ID, live or deleted, has the
+// given name. The caller holds the lock.
+func (r *userRepository) nameTaken(userName string, id int) bool {
+	for _, rec := range r.users {
+		if rec.user.UserName == userName && rec.user.ID != id {
+			return true
+		}
+	}
+	return false
+}
+
+// live returns the user with the given ID unless it is missing or in the trash. The caller holds
+// the lock.
+func (r *userRepository) live(id int) *userRecord {
+	rec, ok := r.users[id]
+	if !ok || rec.deleted != nil {
+		return nil
+	}
+	return rec
+}
+
+// Create stores a new user without a password and sets its ID.
+func (r *userRepository) Create(ctx context.Context, user *repositories.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameTaken(user.UserName, 0) {
+		return repositories.ErrUserNameTaken
+	}
+
+	r.lastID++
+	user.ID = r.lastID
+	user.Version = 1
+	r.users[user.ID] = &userRecord{user: *user}
+
+	return nil
+}
+
+// Get returns the user with the given ID.
+func (r *userRepository) Get(ctx context.Context, id int) (*repositories.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec := r.live(id)
+	if rec == nil {
+		return nil, errors.New("invalid user id")
+	}
+
+	user := rec.user
+	return &user, nil
+}
+
+// GetAll returns all users outside the trash, ordered by ID.
+func (r *userRepository) GetAll(ctx context.Context) ([]*repositories.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := []*repositories.User{}
+	for _, rec := range r.users {
+		if rec.deleted == nil {
+			user := rec.user
+			users = append(users, &user)
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool { return users[i].ID < users[j].ID })
+
+	return users, nil
+}
+
+// GetPage returns a page of the users outside the trash selected by the filter.
+func (r *userRepository) GetPage(ctx context.Context, filter repositories.UserFilter, page repositories.PageRequest) ([]*repositories.User, *repositories.PageInfo, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := page.Validate(repositories.UserSortFields...); err != nil {
+		return nil, nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	selected := []*repositories.User{}
+	for _, rec := range r.users {
+		email, name := strings.ToLower(rec.user.EmailID), strings.ToLower(rec.user.UserName)
+		if rec.deleted == nil &&
+			(filter.EmailDomain == "" || strings.HasSuffix(email, "@"+strings.ToLower(filter.EmailDomain))) &&
+			strings.HasPrefix(name, strings.ToLower(filter.NamePrefix)) {
+			user := rec.user
+			selected = append(selected, &user)
+		}
+	}
+
+	indexes, info := repositories.PageSlice(len(selected), func(i int) repositories.SortKey { return selected[i].SortKey(page.Sort) }, page)
+	users := make([]*repositories.User, 0, len(indexes))
+	for _, i := range indexes {
+		users = append(users, selected[i])
+	}
+
+	return users, info, nil
+}
+
+// Update replaces the name, mobile and email of a user at the version it was read at.
+func (r *userRepository) Update(ctx context.Context, user *repositories.User) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.nameTaken(user.UserName, user.ID) {
+		return repositories.ErrUserNameTaken
+	}
+
+	rec := r.live(user.ID)
+	if rec == nil {
+		return errors.New("no rows were affected during the update")
+	}
+
+	if rec.user.Version != user.Version {
+		return repositories.ErrVersionConflict
+	}
+
+	user.Version++
+	rec.user = *user
+
+	return nil
+}
+
+// Patch replaces the named fields of a user at the version it was read at.
+func (r *userRepository) Patch(ctx context.Context, user *repositories.User, fields []string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	for _, field := range fields {
+		switch field {
+		case "user_name", "mobile", "email_id":
+		default:
+			return fmt.Errorf("cannot patch the %s field of a user", field)
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if hasField(fields, "user_name") && r.nameTaken(user.UserName, user.ID) {
+		return repositories.ErrUserNameTaken
+	}
+
+	rec := r.live(user.ID)
+	if rec == nil {
+		return errors.New("no rows were affected during the update")
+	}
+
+	if rec.user.Version != user.Version {
+		return repositories.ErrVersionConflict
+	}
+
+	for _, field := range fields {
+		switch field {
+		case "user_name":
+			rec.user.UserName = user.UserName
+		case "mobile":
+			rec.user.Mobile = user.Mobile
+		case "email_id":
+			rec.user.EmailID = user.EmailID
+		}
+	}
+
+	rec.user.Version++
+	user.Version = rec.user.Version
+
+	return nil
+}
+
+// Delete moves a user at the given version to the trash.
+func (r *userRepository) Delete(ctx context.Context, id, version, deletedBy int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec := r.live(id)
+	if rec == nil {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	if rec.user.Version != version {
+		return repositories.ErrVersionConflict
+	}
+
+	rec.deleted = &repositories.DeletionInfo{DeletedDate: time.Now(), DeletedBy: deletedBy}
+
+	return nil
+}
+
+// List returns all users outside the trash, ordered by ID.
+func (r *userRepository) List(ctx context.Context) ([]*repositories.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	return r.GetAll(ctx)
+}
+
+// GetPassword returns the password hash of a user.
+func (r *userRepository) GetPassword(ctx context.Context, id int) (string, error) {
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec := r.live(id)
+	if rec == nil {
+		return "", errors.New("invalid user id")
+	}
+
+	return rec.password, nil
+}
+
+// GetUserByUserName returns the user with the given name.
+func (r *userRepository) GetUserByUserName(ctx context.Context, userName string) (*repositories.User, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rec := range r.users {
+		if rec.deleted == nil && rec.user.UserName == userName {
+			user := rec.user
+			return &user, nil
+		}
+	}
+
+	return nil, errors.New("invalid user name")
+}
+
+// UpdatePassword replaces the password hash of a user.
+func (r *userRepository) UpdatePassword(ctx context.Context, userID int, password string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec := r.live(userID)
+	if rec == nil {
+		return errors.New("no rows were affected during the update")
+	}
+
+	rec.password = password
+
+	return nil
+}
+
+// GetDeleted returns the users in the trash, most recently deleted first.
+func (r *userRepository) GetDeleted(ctx context.Context) ([]*repositories.DeletedUser, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	users := []*repositories.DeletedUser{}
+	for _, rec := range r.users {
+		if rec.deleted != nil {
+			users = append(users, &repositories.DeletedUser{User: rec.user, DeletionInfo: *rec.deleted})
+		}
+	}
+
+	sort.Slice(users, func(i, j int) bool {
+		return deletedLater(users[i].DeletionInfo, users[i].ID, users[j].DeletionInfo, users[j].ID)
+	})
+
+	return users, nil
+}
+
+// Restore moves a user out of the trash.
+func (r *userRepository) Restore(ctx context.Context, id int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	rec, ok := r.users[id]
+	if !ok || rec.deleted == nil {
+		return errors.New("no deleted user found with the given id")
+	}
+
+	rec.deleted = nil
+
+	return nil
+}
+
+// Purge removes the users deleted before the given time and returns how many were removed.
+func (r *userRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, err
+	}
+
+	r.mu.Lock()
+	var ids []int
+	for id, rec := range r.users {
+		if rec.deleted != nil && rec.deleted.DeletedDate.Before(before) {
+			delete(r.users, id)
+			ids = append(ids, id)
+		}
+	}
+	r.mu.Unlock()
+
+	r.purged(ids)
+
+	return int64(len(ids)), nil
+}
@@ -0,0 +1,213 @@
+package memory
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// userRoleKey identifies the assignment of a role to a user.
+type userRoleKey struct {
+	userID int
+	roleID int
+}
+
+// userRoleRepository implements repositories.UserRoleRepository in memory.
+type userRoleRepository struct {
+	mu           sync.Mutex
+	userRoles    map[userRoleKey]repositories.UserRole
+	roles        repositories.RoleRepository
+	roleAccesses repositories.RoleAccessRepository
+}
+
+// NewUserRoleRepository returns an empty in-memory UserRoleRepository. The roles of a user are
+// looked up in roles and their access objects in roleAccesses. The assignments of the users and
+// roles purged from the in-memory users and roles are dropped.
+func NewUserRoleRepository(users repositories.UserRepository, roles repositories.RoleRepository, roleAccesses repositories.RoleAccessRepository) repositories.UserRoleRepository {
+	r := &userRoleRepository{
+		userRoles:    map[userRoleKey]repositories.UserRole{},
+		roles:        roles,
+		roleAccesses: roleAccesses,
+	}
+
+	if n, ok := users.(purgeNotifier); ok {
+		n.onPurge(func(id int) { r.drop(func(key userRoleKey) bool { return key.userID == id }) })
+	}
+	if n, ok := roles.(purgeNotifier); ok {
+		n.onPurge(func(id int) { r.drop(func(key userRoleKey) bool { return key.roleID == id }) })
+	}
+
+	return r
+}
+
+// drop deletes the assignments matching the condition.
+func (r *userRoleRepository) drop(match func(key userRoleKey) bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.userRoles {
+		if match(key) {
+			delete(r.userRoles, key)
+		}
+	}
+}
+
+// Create assigns a role to a user.
+func (r *userRoleRepository) Create(ctx context.Context, ur *repositories.UserRole) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := userRoleKey{ur.UserID, ur.RoleID}
+	if _, ok := r.userRoles[key]; ok {
+		return repositories.ErrUserRoleExists
+	}
+
+	r.userRoles[key] = *ur
+
+	return nil
+}
+
+// Get returns the assignment of a role to a user.
+func (r *userRoleRepository) Get(ctx context.Context, userID, roleID int) (*repositories.UserRole, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ur, ok := r.userRoles[userRoleKey{userID, roleID}]
+	if !ok {
+		return nil, errors.New("the user does not have this role")
+	}
+
+	return &ur, nil
+}
+
+// Update changes the expiry date of the assignment of a role to a user.
+func (r *userRoleRepository) Update(ctx context.Context, ur *repositories.UserRole) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := userRoleKey{ur.UserID, ur.RoleID}
+	if _, ok := r.userRoles[key]; !ok {
+		return errors.New("no rows were affected during the update")
+	}
+
+	r.userRoles[key] = *ur
+
+	return nil
+}
+
+// Delete takes a role away from a user.
+func (r *userRoleRepository) Delete(ctx context.Context, userID, roleID int) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := userRoleKey{userID, roleID}
+	if _, ok := r.userRoles[key]; !ok {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	delete(r.userRoles, key)
+
+	return nil
+}
+
+// GetAll returns all assignments, ordered by user and role ID.
+func (r *userRoleRepository) GetAll(ctx context.Context) ([]*repositories.UserRole, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	userRoles := []*repositories.UserRole{}
+	for _, ur := range r.userRoles {
+		ur := ur
+		userRoles = append(userRoles, &ur)
+	}
+
+	sort.Slice(userRoles, func(i, j int) bool {
+		if userRoles[i].UserID != userRoles[j].UserID {
+			return userRoles[i].UserID < userRoles[j].UserID
+		}
+		return userRoles[i].RoleID < userRoles[j].RoleID
+	})
+
+	return userRoles, nil
+}
+
+// GetRolesForUser returns the roles of a user, ordered by ID and leaving out the ones in the trash.
+func (r *userRoleRepository) GetRolesForUser(ctx context.Context, userID int) ([]*repositories.Role, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	all, err := r.roles.GetAll(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	roles := []*repositories.Role{}
+	for _, role := range all {
+		if _, ok := r.userRoles[userRoleKey{userID, role.ID}]; ok {
+			roles = append(roles, role)
+		}
+	}
+
+	return roles, nil
+}
+
+// GetAllAccess returns the access objects granted to a user through any of their roles, each once.
+func (r *userRoleRepository) GetAllAccess(ctx context.Context, userID int) ([]*repositories.Access, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	roles, err := r.GetRolesForUser(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	seen := map[int]bool{}
+	accesses := []*repositories.Access{}
+	for _, role := range roles {
+		granted, err := r.roleAccesses.GetAccessesForRole(ctx, role.ID)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, access := range granted {
+			if !seen[access.ID] {
+				seen[access.ID] = true
+				accesses = append(accesses, access)
+			}
+		}
+	}
+
+	if len(accesses) == 0 {
+		return nil, repositories.ErrNoAccess
+	}
+
+	return accesses, nil
+}
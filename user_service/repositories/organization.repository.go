@@ -0,0 +1,314 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// Organization is a company or other body contacts work for. Like custom fields, organizations
+// with an AddressBookID are shared by everyone working in that address book and organizations
+// without one belong to the personal contacts of UserID. ParentID links a subsidiary or
+// department to the organization above it.
+type Organization struct {
+	ID            int
+	UserID        int
+	AddressBookID int
+	ParentID      int
+	Name          string
+	Domain        string
+}
+
+// OrganizationSortFields are the fields a page of organizations can be sorted by.
+var OrganizationSortFields = []string{"id", "name", "domain"}
+
+// SortKey returns the position of the organization in a list sorted by the given field.
+func (o *Organization) SortKey(field string) SortKey {
+	switch field {
+	case "name":
+		return SortKey{Value: o.Name, ID: o.ID}
+	case "domain":
+		return SortKey{Value: o.Domain, ID: o.ID}
+	}
+
+	return SortKey{ID: o.ID}
+}
+
+// OrganizationMember links a contact to an organization with the contact's position there.
+type OrganizationMember struct {
+	OrganizationID int
+	ContactID      int
+	Title          string
+	Department     string
+}
+
+// OrganizationPerson is a member of an organization in an OrganizationGraph.
+type OrganizationPerson struct {
+	Contact    *Contact
+	Title      string
+	Department string
+}
+
+// OrganizationGraph is the people graph of an organization: its members, the relationships
+// between them and the organizations directly below it.
+type OrganizationGraph struct {
+	Organization  *Organization
+	Children      []*Organization
+	People        []*OrganizationPerson
+	Relationships []*Relationship
+}
+
+type OrganizationRepository interface {
+	Create(context.Context, *Organization) error
+	Get(context.Context, int) (*Organization, error)
+	Update(context.Context, *Organization) error
+	Delete(context.Context, int) error
+	GetAll(ctx context.Context, userID, addressBookID int) ([]*Organization, error)
+	GetChildren(ctx context.Context, parentID int) ([]*Organization, error)
+	AddMember(context.Context, *OrganizationMember) error
+	RemoveMember(ctx context.Context, organizationID, contactID int) error
+	GetMembers(ctx context.Context, organizationID int) ([]*OrganizationMember, error)
+}
+
+// maxOrganizationDepth bounds the walk up the hierarchy when checking a new parent, so that a
+// cycle already present in the data cannot loop forever.
+const maxOrganizationDepth = 64
+
+type organizationRepository struct {
+	db *DB
+}
+
+// NewOrganizationRepository creates a new OrganizationRepository using the provided database connection.
+func NewOrganizationRepository(db *DB) OrganizationRepository {
+	return &organizationRepository{db: db}
+}
+
+// checkParent verifies that the parent of an organization exists in the same scope and that the
+// organization is not one of its own ancestors.
+func (r *organizationRepository) checkParent(ctx context.Context, o *Organization) error {
+	if o.ParentID == 0 {
+		return nil
+	}
+
+	parent, err := r.Get(ctx, o.ParentID)
+	if err != nil {
+		return errors.New("invalid parent organization id")
+	}
+
+	if parent.AddressBookID != o.AddressBookID || (o.AddressBookID == 0 && parent.UserID != o.UserID) {
+		return errors.New("parent organization belongs to another address book")
+	}
+
+	for depth := 0; parent != nil && depth < maxOrganizationDepth; depth++ {
+		if o.ID != 0 && parent.ID == o.ID {
+			return errors.New("an organization cannot be placed below itself")
+		}
+		if parent.ParentID == 0 {
+			return nil
+		}
+		if parent, err = r.Get(ctx, parent.ParentID); err != nil {
+			return err
+		}
+	}
+
+	return errors.New("organization hierarchy is too deep")
+}
+
+// Create inserts a new organization and sets its ID.
+func (r *organizationRepository) Create(ctx context.Context, o *Organization) error {
+	if err := r.checkParent(ctx, o); err != nil {
+		return err
+	}
+
+	query := `INSERT INTO organizations (user_id, address_book_id, parent_id, organization_name, domain, created_date, updated_date)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+	id, err := r.db.Insert(ctx, "organization_id", query, o.UserID, nullableID(o.AddressBookID), nullableID(o.ParentID), o.Name, o.Domain)
+	if err != nil {
+		return err
+	}
+
+	o.ID = id
+
+	return nil
+}
+
+// Get retrieves an organization by ID.
+func (r *organizationRepository) Get(ctx context.Context, id int) (*Organization, error) {
+	query := `SELECT organization_id, user_id, address_book_id, parent_id, organization_name, domain
+		FROM organizations WHERE organization_id = ?`
+	o, err := scanOrganization(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid organization id")
+		}
+		return nil, err
+	}
+	return o, nil
+}
+
+// Update changes the name, domain and parent of an organization. The scope of an organization
+// never changes.
+func (r *organizationRepository) Update(ctx context.Context, o *Organization) error {
+	if err := r.checkParent(ctx, o); err != nil {
+		return err
+	}
+
+	query := "UPDATE organizations SET parent_id = ?, organization_name = ?, domain = ?, updated_date = CURRENT_TIMESTAMP WHERE organization_id = ?"
+	result, err := r.db.Exec(ctx, query, nullableID(o.ParentID), o.Name, o.Domain, o.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return nil
+}
+
+// Delete removes an organization by ID together with its member links. Organizations below it
+// move to the top of the hierarchy.
+func (r *organizationRepository) Delete(ctx context.Context, id int) error {
+	query := "DELETE FROM organizations WHERE organization_id = ?"
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return nil
+}
+
+// GetAll retrieves the organizations of an address book, or the personal organizations of a
+// user when addressBookID is zero.
+func (r *organizationRepository) GetAll(ctx context.Context, userID, addressBookID int) ([]*Organization, error) {
+	query := `SELECT organization_id, user_id, address_book_id, parent_id, organization_name, domain
+		FROM organizations WHERE address_book_id = ? ORDER BY organization_name`
+	args := []interface{}{addressBookID}
+	if addressBookID == 0 {
+		query = `SELECT organization_id, user_id, address_book_id, parent_id, organization_name, domain
+			FROM organizations WHERE address_book_id IS NULL AND user_id = ? ORDER BY organization_name`
+		args = []interface{}{userID}
+	}
+
+	return r.query(ctx, query, args...)
+}
+
+// GetChildren retrieves the organizations directly below an organization.
+func (r *organizationRepository) GetChildren(ctx context.Context, parentID int) ([]*Organization, error) {
+	query := `SELECT organization_id, user_id, address_book_id, parent_id, organization_name, domain
+		FROM organizations WHERE parent_id = ? ORDER BY organization_name`
+	return r.query(ctx, query, parentID)
+}
+
+func (r *organizationRepository) query(ctx context.Context, query string, args ...interface{}) ([]*Organization, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	organizations := []*Organization{}
+
+	for rows.Next() {
+		o, err := scanOrganization(rows)
+		if err != nil {
+			return nil, err
+		}
+		organizations = append(organizations, o)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return organizations, nil
+}
+
+// AddMember links a contact to an organization, or updates the title and department of an
+// existing link.
+func (r *organizationRepository) AddMember(ctx context.Context, m *OrganizationMember) error {
+	query := `INSERT INTO organization_contacts (organization_id, contact_id, title, department, created_date, updated_date)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+	query = r.db.Dialect.Upsert(query, []string{"organization_id", "contact_id"}, "title", "department", "updated_date")
+	_, err := r.db.Exec(ctx, query, m.OrganizationID, m.ContactID, m.Title, m.Department)
+	return err
+}
+
+// RemoveMember unlinks a contact from an organization.
+func (r *organizationRepository) RemoveMember(ctx context.Context, organizationID, contactID int) error {
+	query := "DELETE FROM organization_contacts WHERE organization_id = ? AND contact_id = ?"
+	result, err := r.db.Exec(ctx, query, organizationID, contactID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return nil
+}
+
+// GetMembers retrieves the member links of an organization. Links to deleted contacts are left out.
+func (r *organizationRepository) GetMembers(ctx context.Context, organizationID int) ([]*OrganizationMember, error) {
+	query := `SELECT oc.organization_id, oc.contact_id, oc.title, oc.department
+		FROM organization_contacts oc
+		JOIN contacts c ON oc.contact_id = c.contact_id AND c.deleted_date IS NULL
+		WHERE oc.organization_id = ?
+		ORDER BY oc.contact_id`
+	rows, err := r.db.Query(ctx, query, organizationID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	members := []*OrganizationMember{}
+
+	for rows.Next() {
+		m := &OrganizationMember{}
+		err := rows.Scan(&m.OrganizationID, &m.ContactID, &m.Title, &m.Department)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, m)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return members, nil
+}
+
+func scanOrganization(row rowScanner) (*Organization, error) {
+	o := &Organization{}
+	var bookID, parentID sql.NullInt64
+	err := row.Scan(&o.ID, &o.UserID, &bookID, &parentID, &o.Name, &o.Domain)
+	if err != nil {
+		return nil, err
+	}
+	o.AddressBookID = int(bookID.Int64)
+	o.ParentID = int(parentID.Int64)
+	return o, nil
+}
@@ -0,0 +1,313 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// Types of the aggregates whose domain events are appended to the outbox.
+const (
+	AggregateUser    = "user"
+	AggregateRole    = "role"
+	AggregateAccess  = "access"
+	AggregateContact = "contact"
+)
+
+// Types of the domain events appended to the outbox. The payload of the created, updated and
+// assignment events is the record as stored by the change, the payload of the others names it.
+// The payload of a contact event is the version it added to the history of the contact.
+const (
+	EventUserCreated       = "UserCreated"
+	EventUserUpdated       = "UserUpdated"
+	EventUserDeleted       = "UserDeleted"
+	EventUserRestored      = "UserRestored"
+	EventPasswordChanged   = "PasswordChanged"
+	EventRoleAssigned      = "RoleAssigned"
+	EventRoleExpiryChanged = "RoleExpiryChanged"
+	EventRoleUnassigned    = "RoleUnassigned"
+
+	EventRoleCreated   = "RoleCreated"
+	EventRoleUpdated   = "RoleUpdated"
+	EventRoleDeleted   = "RoleDeleted"
+	EventRoleRestored  = "RoleRestored"
+	EventAccessGranted = "AccessGranted"
+	EventAccessRevoked = "AccessRevoked"
+
+	EventAccessCreated  = "AccessCreated"
+	EventAccessUpdated  = "AccessUpdated"
+	EventAccessDeleted  = "AccessDeleted"
+	EventAccessRestored = "AccessRestored"
+
+	EventContactCreated  = "ContactCreated"
+	EventContactUpdated  = "ContactUpdated"
+	EventContactDeleted  = "ContactDeleted"
+	EventContactRestored = "ContactRestored"
+	EventContactReverted = "ContactReverted"
+)
+
+// EventTypes are the types of all the domain events, by aggregate.
+var EventTypes = map[string][]string{
+	AggregateUser: {EventUserCreated, EventUserUpdated, EventUserDeleted, EventUserRestored, EventPasswordChanged,
+		EventRoleAssigned, EventRoleExpiryChanged, EventRoleUnassigned},
+	AggregateRole: {EventRoleCreated, EventRoleUpdated, EventRoleDeleted, EventRoleRestored, EventAccessGranted,
+		EventAccessRevoked},
+	AggregateAccess:  {EventAccessCreated, EventAccessUpdated, EventAccessDeleted, EventAccessRestored},
+	AggregateContact: {EventContactCreated, EventContactUpdated, EventContactDeleted, EventContactRestored, EventContactReverted},
+}
+
+// outboxErrorLength is the longest delivery error kept with an event.
+const outboxErrorLength = 1024
+
+// OutboxEvent is a domain event of an aggregate: a user, role, access or contact and the records
+// it owns. The events of an aggregate are numbered from 1 in the order their changes were
+// committed. PublishedDate is zero until the event has been published; Attempts and LastError
+// count and describe the failed attempts before that. FailedDate is set when the event has failed
+// too many times to be retried.
+type OutboxEvent struct {
+	ID            int
+	AggregateType string
+	AggregateID   int
+	Sequence      int
+	Type          string
+	Payload       json.RawMessage
+	Attempts      int
+	LastError     string
+	CreatedDate   time.Time
+	PublishedDate time.Time
+	FailedDate    time.Time
+}
+
+// OutboxRepository stores domain events until they are published. The user, role, access, user
+// role and role access repositories append the events of their writes in the transaction of the
+// write, so an event is stored if and only if its change is. The contact history repository
+// appends the events of contacts with the versions it records.
+type OutboxRepository interface {
+	// Append stores an event with the next sequence number of its aggregate. The number is taken
+	// under a lock on the aggregate that is held until the transaction ends, so concurrent
+	// changes of one aggregate are numbered in the order they commit.
+	Append(context.Context, *OutboxEvent) error
+	// GetPending returns up to limit events appended after the event with the given ID that are
+	// neither published nor failed, in the order they were appended.
+	GetPending(ctx context.Context, afterID, limit int) ([]*OutboxEvent, error)
+	MarkPublished(ctx context.Context, id int) error
+	// MarkFailed counts a failed attempt to publish an event and keeps its error. The event is
+	// marked failed once it has been attempted maxAttempts times, and is no longer pending.
+	MarkFailed(ctx context.Context, id int, message string, maxAttempts int) error
+	// GetFailed returns up to limit failed events, in the order they were appended.
+	GetFailed(ctx context.Context, limit int) ([]*OutboxEvent, error)
+	// Retry makes a failed event pending again, with its attempts reset.
+	Retry(ctx context.Context, id int) error
+	// Purge removes the events published before the given time and returns how many were removed.
+	Purge(ctx context.Context, before time.Time) (int64, error)
+}
+
+type outboxRepository struct {
+	db *DB
+}
+
+// NewOutboxRepository creates a new OutboxRepository using the provided database connection.
+func NewOutboxRepository(db *DB) OutboxRepository {
+	return &outboxRepository{db: db}
+}
+
+func (r *outboxRepository) Append(ctx context.Context, event *OutboxEvent) error {
+	insert := "INSERT INTO outbox_sequences (aggregate_type, aggregate_id, last_sequence) VALUES (?, ?, 0)"
+	if _, err := r.db.Exec(ctx, r.db.Dialect.InsertIgnore(insert), event.AggregateType, event.AggregateID); err != nil {
+		return err
+	}
+
+	query := "UPDATE outbox_sequences SET last_sequence = last_sequence + 1 WHERE aggregate_type = ? AND aggregate_id = ?"
+	if _, err := r.db.Exec(ctx, query, event.AggregateType, event.AggregateID); err != nil {
+		return err
+	}
+
+	var sequence int
+	query = "SELECT last_sequence FROM outbox_sequences WHERE aggregate_type = ? AND aggregate_id = ?"
+	if err := r.db.QueryRow(ctx, query, event.AggregateType, event.AggregateID).Scan(&sequence); err != nil {
+		return err
+	}
+
+	query = `INSERT INTO outbox_events (aggregate_type, aggregate_id, sequence_number, event_type, payload, created_date)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	id, err := r.db.Insert(ctx, "event_id", query, event.AggregateType, event.AggregateID, sequence, event.Type, string(event.Payload))
+	if err != nil {
+		return err
+	}
+
+	event.ID = id
+	event.Sequence = sequence
+
+	return nil
+}
+
+func (r *outboxRepository) GetPending(ctx context.Context, afterID, limit int) ([]*OutboxEvent, error) {
+	query := `SELECT event_id, aggregate_type, aggregate_id, sequence_number, event_type, payload, attempts, last_error, created_date, failed_date
+		FROM outbox_events WHERE published_date IS NULL AND failed_date IS NULL AND event_id > ? ORDER BY event_id LIMIT ?`
+	return r.getAll(ctx, query, afterID, limit)
+}
+
+func (r *outboxRepository) GetFailed(ctx context.Context, limit int) ([]*OutboxEvent, error) {
+	query := `SELECT event_id, aggregate_type, aggregate_id, sequence_number, event_type, payload, attempts, last_error, created_date, failed_date
+		FROM outbox_events WHERE published_date IS NULL AND failed_date IS NOT NULL ORDER BY event_id LIMIT ?`
+	return r.getAll(ctx, query, limit)
+}
+
+func (r *outboxRepository) getAll(ctx context.Context, query string, args ...interface{}) ([]*OutboxEvent, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	events := []*OutboxEvent{}
+
+	for rows.Next() {
+		event := &OutboxEvent{}
+		var payload string
+		var failedDate sql.NullTime
+		err := rows.Scan(&event.ID, &event.AggregateType, &event.AggregateID, &event.Sequence, &event.Type,
+			&payload, &event.Attempts, &event.LastError, &event.CreatedDate, &failedDate)
+		if err != nil {
+			return nil, err
+		}
+		event.Payload = json.RawMessage(payload)
+		event.FailedDate = failedDate.Time
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+func (r *outboxRepository) MarkPublished(ctx context.Context, id int) error {
+	query := "UPDATE outbox_events SET published_date = CURRENT_TIMESTAMP WHERE event_id = ? AND published_date IS NULL"
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no pending event found with the given id")
+	}
+
+	return nil
+}
+
+func (r *outboxRepository) MarkFailed(ctx context.Context, id int, message string, maxAttempts int) error {
+	if len(message) > outboxErrorLength {
+		message = message[:outboxErrorLength]
+	}
+
+	// failed_date comes first: MySQL assigns the columns in order, and would otherwise compare the
+	// attempts already counted
+	query := `UPDATE outbox_events
+		SET failed_date = CASE WHEN attempts + 1 >= ? THEN CURRENT_TIMESTAMP ELSE NULL END, attempts = attempts + 1, last_error = ?
+		WHERE event_id = ? AND published_date IS NULL AND failed_date IS NULL`
+	result, err := r.db.Exec(ctx, query, maxAttempts, message, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no pending event found with the given id")
+	}
+
+	return nil
+}
+
+func (r *outboxRepository) Retry(ctx context.Context, id int) error {
+	query := "UPDATE outbox_events SET failed_date = NULL, attempts = 0 WHERE event_id = ? AND published_date IS NULL AND failed_date IS NOT NULL"
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no failed event found with the given id")
+	}
+
+	return nil
+}
+
+func (r *outboxRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	query := "DELETE FROM outbox_events WHERE published_date IS NOT NULL AND published_date < ?"
+	result, err := r.db.Exec(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// withEvent runs write with a DB bound to a new transaction, nested in the one db runs its
+// queries in if any, and appends the event write returns to the outbox before committing it.
+// The event is only appended when write succeeds.
+func withEvent(ctx context.Context, db *DB, write func(db *DB) (*OutboxEvent, error)) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	txDB := db.in(tx)
+	event, err := write(txDB)
+	if err != nil {
+		return err
+	}
+
+	if err := NewOutboxRepository(txDB).Append(ctx, event); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// newEvent returns an event of the aggregate with the JSON encoding of payload.
+func newEvent(aggregateType string, aggregateID int, eventType string, payload interface{}) (*OutboxEvent, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &OutboxEvent{AggregateType: aggregateType, AggregateID: aggregateID, Type: eventType, Payload: data}, nil
+}
+
+// The payloads of the events that name a record rather than carry it.
+type (
+	deletedPayload struct {
+		ID        int
+		Version   int
+		DeletedBy int
+	}
+
+	referencePayload struct {
+		ID int
+	}
+
+	userRolePayload struct {
+		UserID int
+		RoleID int
+	}
+)
@@ -0,0 +1,306 @@
+package repositories
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DefaultPageLimit is the number of records on a page when the request does not ask for another.
+const DefaultPageLimit = 20
+
+// MaxPageLimit caps the number of records on a page.
+const MaxPageLimit = 100
+
+// ErrInvalidCursor is returned for a cursor that was not issued for the sort order of the request.
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// SortKey is the position of a record in a list: the value of the field the list is sorted by,
+// and the ID that orders the records with equal values. Lists sorted by ID leave Value empty.
+type SortKey struct {
+	Value string
+	ID    int
+}
+
+// Cursor is the position a page of a list starts after, or ends before when Before is set. It
+// holds the sort order it was issued for, so a page keeps its place when records are added or
+// removed on the pages before it.
+type Cursor struct {
+	Sort   string
+	Desc   bool
+	Key    SortKey
+	Before bool
+}
+
+// cursorJSON is the encoding of a Cursor.
+type cursorJSON struct {
+	Sort   string `json:"s"`
+	Desc   bool   `json:"d,omitempty"`
+	Value  string `json:"v,omitempty"`
+	ID     int    `json:"i"`
+	Before bool   `json:"b,omitempty"`
+}
+
+// Encode returns the cursor as an opaque string for clients to pass back.
+func (c *Cursor) Encode() string {
+	data, _ := json.Marshal(cursorJSON{Sort: c.Sort, Desc: c.Desc, Value: c.Key.Value, ID: c.Key.ID, Before: c.Before})
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor returned by Encode.
+func DecodeCursor(s string) (*Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var c cursorJSON
+	if err := json.Unmarshal(data, &c); err != nil || c.Sort == "" {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{Sort: c.Sort, Desc: c.Desc, Key: SortKey{Value: c.Value, ID: c.ID}, Before: c.Before}, nil
+}
+
+// PageRequest asks for a page of a list sorted by one of its sort fields, ascending unless Desc is
+// set, and then by ID in the same direction. Without a cursor the page is the first one.
+type PageRequest struct {
+	Limit  int
+	Sort   string
+	Desc   bool
+	Cursor *Cursor
+}
+
+// Validate checks the limit of the page, that the list can be sorted by its field, one of the given
+// ones, and that its cursor was issued for that sort order.
+func (p *PageRequest) Validate(sortFields ...string) error {
+	if p.Limit < 1 || p.Limit > MaxPageLimit {
+		return fmt.Errorf("limit must be between 1 and %d", MaxPageLimit)
+	}
+
+	for _, f := range sortFields {
+		if f != p.Sort {
+			continue
+		}
+
+		if p.Cursor != nil && (p.Cursor.Sort != p.Sort || p.Cursor.Desc != p.Desc) {
+			return ErrInvalidCursor
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("cannot sort by %q, sort must be one of %s", p.Sort, strings.Join(sortFields, ", "))
+}
+
+// PageInfo describes a page of a list: how many records the whole list holds, and the cursors of
+// the pages after and before it, nil on the last and first page.
+type PageInfo struct {
+	Total int
+	Next  *Cursor
+	Prev  *Cursor
+}
+
+// before reports whether the record at a comes before the one at b in the order of the page.
+func (p *PageRequest) before(a, b SortKey) bool {
+	if a.Value != b.Value {
+		return (a.Value < b.Value) != p.Desc
+	}
+
+	return a.ID != b.ID && (a.ID < b.ID) != p.Desc
+}
+
+// window returns the positions the page starts and ends at in a list of total records, of which
+// preceding are ordered before the cursor: up to and including it when the page starts after it,
+// and only strictly before it when the page ends before it.
+func (p *PageRequest) window(total, preceding int) (start, end int) {
+	if p.Cursor != nil && p.Cursor.Before {
+		start = preceding - p.Limit
+		if start < 0 {
+			start = 0
+		}
+		return start, preceding
+	}
+
+	end = preceding + p.Limit
+	if end > total {
+		end = total
+	}
+	return preceding, end
+}
+
+// info returns the PageInfo of the page spanning start to end, whose first and last records have
+// the given keys.
+func (p *PageRequest) info(total, start, end int, first, last SortKey) *PageInfo {
+	info := &PageInfo{Total: total}
+	if end < total && end > start {
+		info.Next = &Cursor{Sort: p.Sort, Desc: p.Desc, Key: last}
+	}
+	if start > 0 && end > start {
+		info.Prev = &Cursor{Sort: p.Sort, Desc: p.Desc, Key: first, Before: true}
+	}
+
+	return info
+}
+
+// PageSlice returns a page of a list of n records held in memory, as the indexes of its records in
+// the order of the page, with its PageInfo. key returns the sort key of record i for the sort
+// field of the page.
+func PageSlice(n int, key func(i int) SortKey, page PageRequest) ([]int, *PageInfo) {
+	order := make([]int, n)
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.Slice(order, func(i, j int) bool { return page.before(key(order[i]), key(order[j])) })
+
+	preceding := 0
+	if c := page.Cursor; c != nil {
+		for _, i := range order {
+			if page.before(key(i), c.Key) || (!c.Before && key(i) == c.Key) {
+				preceding++
+			}
+		}
+	}
+
+	start, end := page.window(n, preceding)
+	if start == end {
+		return []int{}, page.info(n, start, end, SortKey{}, SortKey{})
+	}
+
+	return order[start:end], page.info(n, start, end, key(order[start]), key(order[end-1]))
+}
+
+// pageQuery selects a page of a list stored in a table, sorted by one of the allow-listed columns
+// and then by the ID column.
+type pageQuery struct {
+	// columns are the selected columns, which must include the sort and ID columns unqualified.
+	columns string
+	// from is the FROM clause, and the WHERE clause selecting the records of the list.
+	from string
+	args []interface{}
+	id   string
+	// sorts maps the sort fields of the list to their columns. The ID field maps to id.
+	sorts map[string]string
+}
+
+// run counts the records of the list and selects the page, calling scan for each of its rows in
+// order. scan returns the sort key of the record it read.
+func (q *pageQuery) run(ctx context.Context, db *DB, page PageRequest, scan func(rows *Rows) (SortKey, error)) (*PageInfo, error) {
+	column, ok := q.sorts[page.Sort]
+	if !ok {
+		return nil, fmt.Errorf("cannot sort by %q", page.Sort)
+	}
+
+	var total, preceding int
+	if page.Cursor == nil {
+		if err := db.QueryRow(ctx, "SELECT COUNT(*) "+q.from, q.args...).Scan(&total); err != nil {
+			return nil, err
+		}
+	} else {
+		cond, condArgs := q.ordered(column, page.Desc, page.Cursor.Key, !page.Cursor.Before)
+		query := "SELECT COUNT(*), COALESCE(SUM(CASE WHEN " + cond + " THEN 1 ELSE 0 END), 0) " + q.from
+		if err := db.QueryRow(ctx, query, append(condArgs, q.args...)...).Scan(&total, &preceding); err != nil {
+			return nil, err
+		}
+	}
+
+	start, end := page.window(total, preceding)
+	if start == end {
+		return page.info(total, start, end, SortKey{}, SortKey{}), nil
+	}
+
+	// The page is read from the cursor on, backwards when it ends before it, and put back in order
+	query := "SELECT " + q.columns + " " + q.from
+	args := append([]interface{}{}, q.args...)
+	desc := page.Desc
+	if c := page.Cursor; c != nil {
+		if c.Before {
+			desc = !desc
+		}
+		cond, condArgs := q.ordered(column, !desc, c.Key, false)
+		query += " AND " + cond
+		args = append(args, condArgs...)
+	}
+
+	query += " ORDER BY " + q.orderBy(column, desc) + fmt.Sprintf(" LIMIT %d", end-start)
+	if desc != page.Desc {
+		query = "SELECT * FROM (" + query + ") page_rows ORDER BY " + q.orderBy(column, page.Desc)
+	}
+
+	rows, err := db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	keys := []SortKey{}
+	for rows.Next() {
+		key, err := scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(keys) == 0 {
+		return page.info(total, start, start, SortKey{}, SortKey{}), nil
+	}
+
+	return page.info(total, start, start+len(keys), keys[0], keys[len(keys)-1]), nil
+}
+
+// ordered returns the condition selecting the records ordered before key, or at it when inclusive
+// is set, in the given direction.
+func (q *pageQuery) ordered(column string, desc bool, key SortKey, inclusive bool) (string, []interface{}) {
+	less, idLess := "<", "<"
+	if desc {
+		less, idLess = ">", ">"
+	}
+	if inclusive {
+		idLess += "="
+	}
+
+	if column == q.id {
+		return q.id + " " + idLess + " ?", []interface{}{key.ID}
+	}
+
+	return "(" + column + " " + less + " ? OR (" + column + " = ? AND " + q.id + " " + idLess + " ?))",
+		[]interface{}{key.Value, key.Value, key.ID}
+}
+
+// orderBy returns the ORDER BY list of the sort column in the given direction.
+func (q *pageQuery) orderBy(column string, desc bool) string {
+	dir := " ASC"
+	if desc {
+		dir = " DESC"
+	}
+
+	if column == q.id {
+		return q.id + dir
+	}
+
+	return column + dir + ", " + q.id + dir
+}
+
+// likePrefix returns the pattern of a case-insensitive LIKE matching the values starting with
+// prefix, for a condition of the form LOWER(column) LIKE ? ESCAPE '!'.
+func likePrefix(prefix string) string {
+	return likeEscaper.Replace(strings.ToLower(prefix)) + "%"
+}
+
+// likeSuffix is likePrefix for the values ending with suffix.
+func likeSuffix(suffix string) string {
+	return "%" + likeEscaper.Replace(strings.ToLower(suffix))
+}
+
+var likeEscaper = strings.NewReplacer("!", "!!", "%", "!%", "_", "!_")
@@ -0,0 +1,157 @@
+package repositories_test
+
+import (
+	"encoding/base64"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	for _, c := range []*repositories.Cursor{
+		{Sort: "id", Key: repositories.SortKey{ID: 7}},
+		{Sort: "name", Desc: true, Key: repositories.SortKey{Value: "O'Brien, ünïcode & more", ID: 3}, Before: true},
+		{Sort: "user_name", Key: repositories.SortKey{Value: "", ID: 0}},
+	} {
+		got, err := repositories.DecodeCursor(c.Encode())
+		if err != nil {
+			t.Fatalf("%+v: %v", c, err)
+		}
+		if !reflect.DeepEqual(got, c) {
+			t.Errorf("decoded %+v, want %+v", got, c)
+		}
+	}
+
+	for _, s := range []string{"", "not base64!", base64.RawURLEncoding.EncodeToString([]byte("[1]")),
+		base64.RawURLEncoding.EncodeToString([]byte(`{"i":3}`))} {
+		if _, err := repositories.DecodeCursor(s); !errors.Is(err, repositories.ErrInvalidCursor) {
+			t.Errorf("DecodeCursor(%q): got %v, want %v", s, err, repositories.ErrInvalidCursor)
+		}
+	}
+}
+
+func TestPageRequestValidate(t *testing.T) {
+	cursor := &repositories.Cursor{Sort: "name", Key: repositories.SortKey{Value: "b", ID: 2}}
+	tests := []struct {
+		page    repositories.PageRequest
+		invalid bool
+	}{
+		{repositories.PageRequest{Limit: 1, Sort: "id"}, false},
+		{repositories.PageRequest{Limit: repositories.MaxPageLimit, Sort: "name", Cursor: cursor}, false},
+		{repositories.PageRequest{Limit: 0, Sort: "id"}, true},
+		{repositories.PageRequest{Limit: repositories.MaxPageLimit + 1, Sort: "id"}, true},
+		{repositories.PageRequest{Limit: 10, Sort: "password"}, true},
+		{repositories.PageRequest{Limit: 10, Sort: "id", Cursor: cursor}, true},
+		{repositories.PageRequest{Limit: 10, Sort: "name", Desc: true, Cursor: cursor}, true},
+	}
+
+	for _, tt := range tests {
+		if err := tt.page.Validate("id", "name"); (err != nil) != tt.invalid {
+			t.Errorf("Validate(%+v) = %v, want invalid %v", tt.page, err, tt.invalid)
+		}
+	}
+}
+
+// pageNames returns the names of a page of names, sorted by name and then by their index as ID.
+func pageNames(names []string, page repositories.PageRequest) ([]string, *repositories.PageInfo) {
+	key := func(i int) repositories.SortKey { return repositories.SortKey{Value: names[i], ID: i + 1} }
+	indexes, info := repositories.PageSlice(len(names), key, page)
+
+	result := []string{}
+	for _, i := range indexes {
+		result = append(result, names[i])
+	}
+	return result, info
+}
+
+func TestPageSlice(t *testing.T) {
+	// "bob" is there twice, the pages keep the two apart by ID
+	names := []string{"dave", "bob", "alice", "erin", "bob"}
+
+	t.Run("Forward", func(t *testing.T) {
+		page := repositories.PageRequest{Limit: 2, Sort: "name"}
+		want := [][]string{{"alice", "bob"}, {"bob", "dave"}, {"erin"}}
+		for i, w := range want {
+			got, info := pageNames(names, page)
+			if !reflect.DeepEqual(got, w) {
+				t.Fatalf("page %d: %v, want %v", i, got, w)
+			}
+			if info.Total != len(names) {
+				t.Errorf("page %d: total %d, want %d", i, info.Total, len(names))
+			}
+			if (info.Prev == nil) != (i == 0) || (info.Next == nil) != (i == len(want)-1) {
+				t.Fatalf("page %d: prev %+v, next %+v", i, info.Prev, info.Next)
+			}
+			page.Cursor = info.Next
+		}
+	})
+
+	t.Run("Backward", func(t *testing.T) {
+		page := repositories.PageRequest{Limit: 2, Sort: "name", Desc: true}
+		last, info := pageNames(names, page)
+		if !reflect.DeepEqual(last, []string{"erin", "dave"}) {
+			t.Fatalf("first page %v", last)
+		}
+		page.Cursor = info.Next
+		_, info = pageNames(names, page)
+		page.Cursor = info.Next
+		end, info := pageNames(names, page)
+		if !reflect.DeepEqual(end, []string{"alice"}) || info.Next != nil {
+			t.Fatalf("last page %v, next %+v", end, info.Next)
+		}
+
+		// Walking back from the last page gives the pages in the same order again
+		want := [][]string{{"bob", "bob"}, {"erin", "dave"}}
+		for i, w := range want {
+			page.Cursor = info.Prev
+			got, next := pageNames(names, page)
+			if !reflect.DeepEqual(got, w) {
+				t.Fatalf("page %d back: %v, want %v", i+1, got, w)
+			}
+			if next.Next == nil || (next.Prev == nil) != (i == len(want)-1) {
+				t.Fatalf("page %d back: prev %+v, next %+v", i+1, next.Prev, next.Next)
+			}
+			info = next
+		}
+	})
+
+	t.Run("ExactLastPage", func(t *testing.T) {
+		page := repositories.PageRequest{Limit: 2, Sort: "name"}
+		_, info := pageNames(names[:4], page)
+		page.Cursor = info.Next
+		got, info := pageNames(names[:4], page)
+		if !reflect.DeepEqual(got, []string{"dave", "erin"}) || info.Next != nil || info.Prev == nil {
+			t.Errorf("last page %v with %+v, want [dave erin] without a next page", got, info)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		got, info := pageNames(nil, repositories.PageRequest{Limit: 2, Sort: "name"})
+		if len(got) != 0 || info.Total != 0 || info.Next != nil || info.Prev != nil {
+			t.Errorf("empty list: %v with %+v", got, info)
+		}
+	})
+
+	t.Run("CursorOfRemovedRecord", func(t *testing.T) {
+		// the page after "carol" starts at the next name, whether or not carol is still there
+		page := repositories.PageRequest{Limit: 2, Sort: "name", Cursor: &repositories.Cursor{
+			Sort: "name", Key: repositories.SortKey{Value: "carol", ID: 9},
+		}}
+		got, info := pageNames(names, page)
+		if !reflect.DeepEqual(got, []string{"dave", "erin"}) || info.Next != nil || info.Prev == nil {
+			t.Errorf("page after carol %v with %+v", got, info)
+		}
+	})
+
+	t.Run("BeforeFirst", func(t *testing.T) {
+		page := repositories.PageRequest{Limit: 2, Sort: "name", Cursor: &repositories.Cursor{
+			Sort: "name", Key: repositories.SortKey{Value: "alice", ID: 3}, Before: true,
+		}}
+		got, info := pageNames(names, page)
+		if len(got) != 0 || info.Next != nil || info.Prev != nil {
+			t.Errorf("page before the first record %v with %+v", got, info)
+		}
+	})
+}
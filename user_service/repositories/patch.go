@@ -0,0 +1,135 @@
+package repositories
+
+import (
+	"fmt"
+	"strings"
+)
+
+// patchField is a field the Patch method of a repository can change: its name, the column
+// storing it and its value in the record.
+type patchField struct {
+	name   string
+	column string
+	value  interface{}
+}
+
+// setFields returns the assignments of the SET clause writing the named fields, each followed by
+// a comma, and their arguments. kind names the record in the error for an unknown field.
+func setFields(kind string, names []string, fields []patchField) (string, []interface{}, error) {
+	set := ""
+	args := []interface{}{}
+	for _, name := range names {
+		found := false
+		for _, f := range fields {
+			if f.name == name {
+				set += f.column + " = ?, "
+				args = append(args, f.value)
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return "", nil, fmt.Errorf("cannot patch the %s field of a %s", name, kind)
+		}
+	}
+
+	return set, args, nil
+}
+
+// changedFields returns the names of the fields whose values differ between two versions of a
+// record, in the order of the fields.
+func changedFields(old, new []patchField) []string {
+	names := []string{}
+	for i := range old {
+		if old[i].value != new[i].value {
+			names = append(names, old[i].name)
+		}
+	}
+
+	return names
+}
+
+func (u *User) patchFields() []patchField {
+	return []patchField{
+		{name: "user_name", column: "user_name", value: u.UserName},
+		{name: "mobile", column: "mobile", value: u.Mobile},
+		{name: "email_id", column: "email_id", value: u.EmailID},
+	}
+}
+
+// ChangedUserFields returns the fields UserRepository.Patch writes to change old into new:
+// user_name, mobile and email_id.
+func ChangedUserFields(old, new *User) []string {
+	return changedFields(old.patchFields(), new.patchFields())
+}
+
+func (r *Role) patchFields() []patchField {
+	return []patchField{{name: "name", column: "role_name", value: r.Name}}
+}
+
+// ChangedRoleFields returns the fields RoleRepository.Patch writes to change old into new: name.
+func ChangedRoleFields(old, new *Role) []string {
+	return changedFields(old.patchFields(), new.patchFields())
+}
+
+func (a *Access) patchFields() []patchField {
+	return []patchField{{name: "name", column: "access_name", value: a.Name}}
+}
+
+// ChangedAccessFields returns the fields AccessRepository.Patch writes to change old into new:
+// name.
+func ChangedAccessFields(old, new *Access) []string {
+	return changedFields(old.patchFields(), new.patchFields())
+}
+
+func (c *Contact) patchFields() []patchField {
+	return []patchField{
+		{name: "address_book_id", column: "address_book_id", value: nullableID(c.AddressBookID)},
+		{name: "first_name", column: "first_name", value: c.FirstName},
+		{name: "last_name", column: "last_name", value: c.LastName},
+		{name: "email", column: "email_id", value: c.EmailID},
+		{name: "mobile", column: "mobile", value: c.Mobile},
+		{name: "organization", column: "organization", value: c.Organization},
+		{name: "notes", column: "notes", value: c.Notes},
+		{name: "birthday", column: "birthday", value: nullableText(c.Birthday)},
+		{name: "anniversary", column: "anniversary", value: nullableText(c.Anniversary)},
+	}
+}
+
+// ChangedContactFields returns the fields ContactRepository.Patch writes to change old into new,
+// named as in the contact history: address_book_id, first_name, last_name, email, mobile,
+// organization, notes and custom.<key> for each custom field.
+func ChangedContactFields(old, new *Contact) []string {
+	names := []string{}
+	for _, change := range DiffContacts(old, new) {
+		names = append(names, change.Field)
+	}
+
+	return names
+}
+
+// hasField reports whether the named field is among the fields of a patch.
+func hasField(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// splitCustomFields separates the custom.<key> fields of a contact patch from the others.
+func splitCustomFields(names []string) (standard []string, custom bool) {
+	standard = []string{}
+	for _, name := range names {
+		if strings.HasPrefix(name, customFieldPrefix) {
+			custom = true
+			continue
+		}
+		standard = append(standard, name)
+	}
+
+	return standard, custom
+}
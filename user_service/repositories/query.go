@@ -0,0 +1,84 @@
+package repositories
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// placeholders returns a comma separated list of n bind parameters, e.g. "?, ?, ?".
+func placeholders(n int) string {
+	if n <= 0 {
+		return ""
+	}
+
+	return strings.TrimSuffix(strings.Repeat("?, ", n), ", ")
+}
+
+// intArgs converts a slice of ints into query arguments.
+func intArgs(values []int) []interface{} {
+	args := make([]interface{}, 0, len(values))
+	for _, v := range values {
+		args = append(args, v)
+	}
+
+	return args
+}
+
+// rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// nullableID maps the zero ID to NULL for optional foreign keys.
+func nullableID(id int) interface{} {
+	if id == 0 {
+		return nil
+	}
+
+	return id
+}
+
+// nullableText maps the empty string to NULL for optional values.
+func nullableText(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+
+	return s
+}
+
+// nullableTime maps the zero time to NULL for optional times.
+func nullableTime(t time.Time) interface{} {
+	if t.IsZero() {
+		return nil
+	}
+
+	return t
+}
+
+// computedTime scans a time computed by a query, such as the MAX of a column, into T. SQLite
+// returns those as text in the format it stores times in, which database/sql does not convert.
+type computedTime struct {
+	T *time.Time
+}
+
+// Scan implements sql.Scanner.
+func (c computedTime) Scan(src interface{}) error {
+	switch v := src.(type) {
+	case time.Time:
+		*c.T = v
+		return nil
+	case []byte:
+		return c.Scan(string(v))
+	case string:
+		t, err := time.Parse(sqliteTimeFormat, v)
+		if err != nil {
+			return err
+		}
+		*c.T = t
+		return nil
+	}
+
+	return fmt.Errorf("cannot scan %T into a time", src)
+}
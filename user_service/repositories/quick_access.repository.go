@@ -0,0 +1,146 @@
+package repositories
+
+import (
+	"context"
+	"time"
+)
+
+// MaxRecentContacts is the number of recently viewed contacts kept per user; older views are
+// dropped as new ones are recorded.
+const MaxRecentContacts = 20
+
+// FrequencyHalfLife is the age at which an interaction counts half as much towards the frequency
+// score of a contact as one that happens now.
+const FrequencyHalfLife = 30 * 24 * time.Hour
+
+// RankedContact is an entry of a quick-access list. Score is the frequency score of the contact:
+// every interaction the user logged with it counts 1, halved for every FrequencyHalfLife it lies
+// in the past. Date is when the contact was favorited, last viewed or last contacted, depending
+// on the list.
+type RankedContact struct {
+	ContactID int
+	Score     float64
+	Date      time.Time
+}
+
+type QuickAccessRepository interface {
+	AddFavorite(ctx context.Context, userID, contactID int) error
+	RemoveFavorite(ctx context.Context, userID, contactID int) error
+	GetFavorites(ctx context.Context, userID int, now time.Time) ([]*RankedContact, error)
+	RecordView(ctx context.Context, userID, contactID int) error
+	GetRecent(ctx context.Context, userID, limit int) ([]*RankedContact, error)
+	GetFrequent(ctx context.Context, userID int, now time.Time, limit int) ([]*RankedContact, error)
+}
+
+// frequencyQuery returns the query computing the frequency scores of the contacts a user
+// interacted with. Notes are not interactions and activities in the future do not count yet. Its
+// arguments are returned by frequencyArgs.
+func frequencyQuery(d Dialect) string {
+	return `SELECT ac.contact_id, SUM(POWER(0.5, ` + d.MinutesBetween("a.occurred_date", "?") + ` / 60 / ?)) AS score,
+		MAX(a.occurred_date) AS last_date
+	FROM activities a
+	JOIN activity_contacts ac ON ac.activity_id = a.activity_id
+	WHERE a.user_id = ? AND a.activity_type <> 'note' AND a.occurred_date <= ?
+	GROUP BY ac.contact_id`
+}
+
+type quickAccessRepository struct {
+	db *DB
+}
+
+// NewQuickAccessRepository creates a new QuickAccessRepository using the provided database connection.
+func NewQuickAccessRepository(db *DB) QuickAccessRepository {
+	return &quickAccessRepository{db: db}
+}
+
+// frequencyArgs returns the arguments of frequencyQuery: the current time, the half-life in hours,
+// the user ID and the current time again.
+func frequencyArgs(userID int, now time.Time) []interface{} {
+	return []interface{}{now, FrequencyHalfLife.Hours(), userID, now}
+}
+
+// AddFavorite marks a contact as a favorite of a user. Adding a favorite twice is not an error.
+func (r *quickAccessRepository) AddFavorite(ctx context.Context, userID, contactID int) error {
+	query := "INSERT INTO contact_favorites (user_id, contact_id, created_date) VALUES (?, ?, CURRENT_TIMESTAMP)"
+	_, err := r.db.Exec(ctx, r.db.Dialect.InsertIgnore(query), userID, contactID)
+	return err
+}
+
+// RemoveFavorite removes a contact from the favorites of a user.
+func (r *quickAccessRepository) RemoveFavorite(ctx context.Context, userID, contactID int) error {
+	query := "DELETE FROM contact_favorites WHERE user_id = ? AND contact_id = ?"
+	_, err := r.db.Exec(ctx, query, userID, contactID)
+	return err
+}
+
+// GetFavorites retrieves the favorites of a user, the most frequently contacted first and then in
+// the order they were added.
+func (r *quickAccessRepository) GetFavorites(ctx context.Context, userID int, now time.Time) ([]*RankedContact, error) {
+	query := `SELECT f.contact_id, COALESCE(s.score, 0), f.created_date FROM contact_favorites f
+		LEFT JOIN (` + frequencyQuery(r.db.Dialect) + `) s ON s.contact_id = f.contact_id
+		WHERE f.user_id = ?
+		ORDER BY COALESCE(s.score, 0) DESC, f.created_date, f.contact_id`
+	args := append(frequencyArgs(userID, now), userID)
+	return r.query(ctx, query, args...)
+}
+
+// RecordView records that a user viewed a contact and drops the user's views beyond the most
+// recent MaxRecentContacts.
+func (r *quickAccessRepository) RecordView(ctx context.Context, userID, contactID int) error {
+	query := "INSERT INTO contact_views (user_id, contact_id, viewed_date) VALUES (?, ?, " + r.db.Dialect.PreciseNow() + ")"
+	query = r.db.Dialect.Upsert(query, []string{"user_id", "contact_id"}, "viewed_date")
+	if _, err := r.db.Exec(ctx, query, userID, contactID); err != nil {
+		return err
+	}
+
+	// MySQL does not allow LIMIT in an IN subquery, hence the derived table
+	query = `DELETE FROM contact_views WHERE user_id = ? AND viewed_date < (
+			SELECT cutoff FROM (
+				SELECT viewed_date AS cutoff FROM contact_views WHERE user_id = ?
+				ORDER BY viewed_date DESC LIMIT 1 OFFSET ?
+			) oldest
+		)`
+	_, err := r.db.Exec(ctx, query, userID, userID, MaxRecentContacts-1)
+	return err
+}
+
+// GetRecent retrieves up to limit contacts a user viewed, the most recent first.
+func (r *quickAccessRepository) GetRecent(ctx context.Context, userID, limit int) ([]*RankedContact, error) {
+	query := `SELECT contact_id, 0, viewed_date FROM contact_views WHERE user_id = ?
+		ORDER BY viewed_date DESC, contact_id LIMIT ?`
+	return r.query(ctx, query, userID, limit)
+}
+
+// GetFrequent retrieves up to limit contacts a user interacted with, the highest frequency score
+// first.
+func (r *quickAccessRepository) GetFrequent(ctx context.Context, userID int, now time.Time, limit int) ([]*RankedContact, error) {
+	query := "SELECT s.contact_id, s.score, s.last_date FROM (" + frequencyQuery(r.db.Dialect) + `) s
+		ORDER BY s.score DESC, s.last_date DESC, s.contact_id LIMIT ?`
+	args := append(frequencyArgs(userID, now), limit)
+	return r.query(ctx, query, args...)
+}
+
+func (r *quickAccessRepository) query(ctx context.Context, query string, args ...interface{}) ([]*RankedContact, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	entries := []*RankedContact{}
+
+	for rows.Next() {
+		e := &RankedContact{}
+		if err := rows.Scan(&e.ContactID, &e.Score, computedTime{&e.Date}); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,94 @@
+package repositories
+
+import (
+	"errors"
+	"time"
+)
+
+// Ways a reminder can recur. Occurrences follow the wall clock of the reminder's time zone, so
+// a reminder at 09:00 stays at 09:00 across daylight saving changes. Monthly and yearly
+// reminders starting on a day a month lacks, such as the 31st or February 29th, fall on the
+// last day of shorter months.
+const (
+	RecurrenceNone    = "none"
+	RecurrenceWeekly  = "weekly"
+	RecurrenceMonthly = "monthly"
+	RecurrenceYearly  = "yearly"
+)
+
+// ValidateRecurrence returns an error unless recurrence is one of the supported recurrences.
+func ValidateRecurrence(recurrence string) error {
+	switch recurrence {
+	case RecurrenceNone, RecurrenceWeekly, RecurrenceMonthly, RecurrenceYearly:
+		return nil
+	default:
+		return errors.New("recurrence must be one of none, weekly, monthly or yearly")
+	}
+}
+
+// Next returns the first occurrence of the reminder strictly after the given time. ok is false
+// when a one-off reminder has no occurrence left.
+func (rem *Reminder) Next(after time.Time) (next time.Time, ok bool, err error) {
+	if err := ValidateRecurrence(rem.Recurrence); err != nil {
+		return time.Time{}, false, err
+	}
+
+	loc, err := time.LoadLocation(rem.TimeZone)
+	if err != nil {
+		return time.Time{}, false, errors.New("invalid time zone " + rem.TimeZone)
+	}
+
+	s := rem.StartDate
+	occurrence := func(n int) time.Time {
+		switch rem.Recurrence {
+		case RecurrenceWeekly:
+			return time.Date(s.Year(), s.Month(), s.Day()+7*n, s.Hour(), s.Minute(), 0, 0, loc)
+		case RecurrenceMonthly:
+			return clampedDate(s.Year(), s.Month()+time.Month(n), s.Day(), s.Hour(), s.Minute(), loc)
+		case RecurrenceYearly:
+			return clampedDate(s.Year()+n, s.Month(), s.Day(), s.Hour(), s.Minute(), loc)
+		default:
+			return time.Date(s.Year(), s.Month(), s.Day(), s.Hour(), s.Minute(), 0, 0, loc)
+		}
+	}
+
+	first := occurrence(0)
+	if first.After(after) {
+		return first, true, nil
+	}
+	if rem.Recurrence == RecurrenceNone {
+		return time.Time{}, false, nil
+	}
+
+	// start just before the answer and step forward, the estimate is off by at most one
+	local := after.In(loc)
+	var n int
+	switch rem.Recurrence {
+	case RecurrenceWeekly:
+		n = int(after.Sub(first).Hours()/(24*7)) - 1
+	case RecurrenceMonthly:
+		n = (local.Year()-s.Year())*12 + int(local.Month()-s.Month()) - 1
+	case RecurrenceYearly:
+		n = local.Year() - s.Year() - 1
+	}
+	if n < 1 {
+		n = 1
+	}
+
+	for {
+		if t := occurrence(n); t.After(after) {
+			return t, true, nil
+		}
+		n++
+	}
+}
+
+// clampedDate returns the given date, moved back to the last day of the month when the month is
+// shorter than day. month may be out of range and is normalized first.
+func clampedDate(year int, month time.Month, day, hour, minute int, loc *time.Location) time.Time {
+	first := time.Date(year, month, 1, 0, 0, 0, 0, loc)
+	if last := first.AddDate(0, 1, -1).Day(); day > last {
+		day = last
+	}
+	return time.Date(first.Year(), first.Month(), day, hour, minute, 0, 0, loc)
+}
@@ -0,0 +1,201 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+// Types of relationship between two contacts. A Relationship of type RelationshipManager says
+// that the related contact is the manager of the contact.
+const (
+	RelationshipManager   = "manager"
+	RelationshipReport    = "report"
+	RelationshipAssistant = "assistant"
+	RelationshipExecutive = "executive"
+	RelationshipSpouse    = "spouse"
+	RelationshipColleague = "colleague"
+)
+
+// relationshipInverses maps every relationship type to the type seen from the other contact.
+var relationshipInverses = map[string]string{
+	RelationshipManager:   RelationshipReport,
+	RelationshipReport:    RelationshipManager,
+	RelationshipAssistant: RelationshipExecutive,
+	RelationshipExecutive: RelationshipAssistant,
+	RelationshipSpouse:    RelationshipSpouse,
+	RelationshipColleague: RelationshipColleague,
+}
+
+// InverseRelationshipType returns the type of the relationship seen from the related contact,
+// e.g. report for manager.
+func InverseRelationshipType(relationshipType string) (string, error) {
+	inverse, ok := relationshipInverses[relationshipType]
+	if !ok {
+		return "", errors.New("relationship type must be one of manager, report, assistant, executive, spouse or colleague")
+	}
+	return inverse, nil
+}
+
+// Relationship says that RelatedContactID is the Type of ContactID, e.g. their manager.
+// Bidirectional relationships are stored together with their inverse, whose ID is InverseID;
+// deleting either side deletes both.
+type Relationship struct {
+	ID               int
+	ContactID        int
+	RelatedContactID int
+	Type             string
+	Bidirectional    bool
+	InverseID        int
+}
+
+type RelationshipRepository interface {
+	Create(context.Context, *Relationship) error
+	Get(context.Context, int) (*Relationship, error)
+	Delete(context.Context, int) error
+	GetForContacts(ctx context.Context, contactIDs []int) ([]*Relationship, error)
+}
+
+type relationshipRepository struct {
+	db *DB
+}
+
+// NewRelationshipRepository creates a new RelationshipRepository using the provided database connection.
+func NewRelationshipRepository(db *DB) RelationshipRepository {
+	return &relationshipRepository{db: db}
+}
+
+// Create inserts a new relationship and sets its ID. A bidirectional relationship inserts its
+// inverse as well and sets InverseID.
+func (r *relationshipRepository) Create(ctx context.Context, rel *Relationship) error {
+	inverse, err := InverseRelationshipType(rel.Type)
+	if err != nil {
+		return err
+	}
+
+	if rel.ContactID == rel.RelatedContactID {
+		return errors.New("a contact cannot be related to itself")
+	}
+
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+
+	defer tx.Rollback()
+
+	query := `INSERT INTO contact_relationships (contact_id, related_contact_id, relationship_type, created_date, updated_date)
+		VALUES (?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+	id, err := tx.Insert(ctx, "relationship_id", query, rel.ContactID, rel.RelatedContactID, rel.Type)
+	if err != nil {
+		return err
+	}
+
+	if rel.Bidirectional {
+		inverseID, err := tx.Insert(ctx, "relationship_id", query, rel.RelatedContactID, rel.ContactID, inverse)
+		if err != nil {
+			return err
+		}
+
+		link := "UPDATE contact_relationships SET inverse_id = ? WHERE relationship_id = ?"
+		if _, err := tx.Exec(ctx, link, inverseID, id); err != nil {
+			return err
+		}
+		if _, err := tx.Exec(ctx, link, id, inverseID); err != nil {
+			return err
+		}
+
+		rel.InverseID = inverseID
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	rel.ID = id
+
+	return nil
+}
+
+// Get retrieves a relationship by ID.
+func (r *relationshipRepository) Get(ctx context.Context, id int) (*Relationship, error) {
+	query := `SELECT relationship_id, contact_id, related_contact_id, relationship_type, inverse_id
+		FROM contact_relationships WHERE relationship_id = ?`
+	rel, err := scanRelationship(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid relationship id")
+		}
+		return nil, err
+	}
+	return rel, nil
+}
+
+// Delete removes a relationship by ID together with its inverse.
+func (r *relationshipRepository) Delete(ctx context.Context, id int) error {
+	query := "DELETE FROM contact_relationships WHERE relationship_id = ? OR inverse_id = ?"
+	result, err := r.db.Exec(ctx, query, id, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return nil
+}
+
+// GetForContacts retrieves the relationships of the given contacts, including the ones pointing
+// at contacts outside the set. Relationships with a deleted contact on either side are left out.
+func (r *relationshipRepository) GetForContacts(ctx context.Context, contactIDs []int) ([]*Relationship, error) {
+	relationships := []*Relationship{}
+	if len(contactIDs) == 0 {
+		return relationships, nil
+	}
+
+	query := fmt.Sprintf(`SELECT cr.relationship_id, cr.contact_id, cr.related_contact_id, cr.relationship_type, cr.inverse_id
+		FROM contact_relationships cr
+		JOIN contacts c ON cr.contact_id = c.contact_id AND c.deleted_date IS NULL
+		JOIN contacts rc ON cr.related_contact_id = rc.contact_id AND rc.deleted_date IS NULL
+		WHERE cr.contact_id IN (%s)
+		ORDER BY cr.relationship_id`, placeholders(len(contactIDs)))
+	rows, err := r.db.Query(ctx, query, intArgs(contactIDs)...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	for rows.Next() {
+		rel, err := scanRelationship(rows)
+		if err != nil {
+			return nil, err
+		}
+		relationships = append(relationships, rel)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return relationships, nil
+}
+
+func scanRelationship(row rowScanner) (*Relationship, error) {
+	rel := &Relationship{}
+	var inverseID sql.NullInt64
+	err := row.Scan(&rel.ID, &rel.ContactID, &rel.RelatedContactID, &rel.Type, &inverseID)
+	if err != nil {
+		return nil, err
+	}
+	rel.InverseID = int(inverseID.Int64)
+	rel.Bidirectional = inverseID.Valid
+	return rel, nil
+}
@@ -0,0 +1,340 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Kinds of reminder. Birthdays and anniversaries always recur yearly.
+const (
+	ReminderBirthday    = "birthday"
+	ReminderAnniversary = "anniversary"
+	ReminderFollowUp    = "follow_up"
+)
+
+// Reminder nudges UserID about ContactID. StartDate holds the wall clock date and time of the
+// first occurrence in TimeZone, an IANA zone name; the location of StartDate itself is ignored.
+// NextDate is the next occurrence in UTC, SnoozedUntil postpones it. A dismissed reminder never
+// fires again. Generated reminders are those of the birthday and anniversary of a contact, kept
+// by the contact writes.
+type Reminder struct {
+	ID           int
+	UserID       int
+	ContactID    int
+	Kind         string
+	Note         string
+	StartDate    time.Time
+	Recurrence   string
+	TimeZone     string
+	NextDate     time.Time
+	SnoozedUntil *time.Time
+	Dismissed    bool
+	Generated    bool
+}
+
+type ReminderRepository interface {
+	Create(context.Context, *Reminder) error
+	Get(context.Context, int) (*Reminder, error)
+	Delete(context.Context, int) error
+	GetAll(ctx context.Context, userID, contactID int) ([]*Reminder, error)
+	GetDue(ctx context.Context, now time.Time) ([]*Reminder, error)
+	GetUpcoming(ctx context.Context, userID int, from, to time.Time) ([]*Reminder, error)
+	Reschedule(ctx context.Context, id int, next time.Time) error
+	Snooze(ctx context.Context, id int, until time.Time) error
+	Dismiss(ctx context.Context, id int) error
+}
+
+// reminderColumns are the columns scanned by scanReminder, for the reminders table aliased as r.
+const reminderColumns = `r.reminder_id, r.user_id, r.contact_id, r.reminder_kind, r.note, r.start_date, r.recurrence,
+		r.time_zone, r.next_date, r.snoozed_until, r.dismissed, r.generated`
+
+type reminderRepository struct {
+	db *DB
+}
+
+// NewReminderRepository creates a new ReminderRepository using the provided database connection.
+func NewReminderRepository(db *DB) ReminderRepository {
+	return &reminderRepository{db: db}
+}
+
+// Create inserts a new reminder and sets its ID.
+func (r *reminderRepository) Create(ctx context.Context, rem *Reminder) error {
+	query := `INSERT INTO reminders (user_id, contact_id, reminder_kind, note, start_date, recurrence, time_zone, next_date, generated,
+		created_date, updated_date)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)`
+	id, err := r.db.Insert(ctx, "reminder_id", query, rem.UserID, rem.ContactID, rem.Kind, rem.Note, wallClock(rem.StartDate), rem.Recurrence,
+		rem.TimeZone, rem.NextDate.UTC(), rem.Generated)
+	if err != nil {
+		return err
+	}
+
+	rem.ID = id
+
+	return nil
+}
+
+// Get retrieves a reminder by ID.
+func (r *reminderRepository) Get(ctx context.Context, id int) (*Reminder, error) {
+	query := "SELECT " + reminderColumns + " FROM reminders r WHERE r.reminder_id = ?"
+	rem, err := scanReminder(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid reminder id")
+		}
+		return nil, err
+	}
+	return rem, nil
+}
+
+// Delete removes a reminder by ID.
+func (r *reminderRepository) Delete(ctx context.Context, id int) error {
+	return r.exec(ctx, "no rows were affected during the delete", "DELETE FROM reminders WHERE reminder_id = ?", id)
+}
+
+// GetAll retrieves the reminders of a user, optionally only those about one contact.
+func (r *reminderRepository) GetAll(ctx context.Context, userID, contactID int) ([]*Reminder, error) {
+	query := "SELECT " + reminderColumns + " FROM reminders r WHERE r.user_id = ?"
+	args := []interface{}{userID}
+	if contactID != 0 {
+		query += " AND r.contact_id = ?"
+		args = append(args, contactID)
+	}
+
+	return r.query(ctx, query+" ORDER BY r.next_date, r.reminder_id", args...)
+}
+
+// GetDue retrieves the reminders that should fire at now: not dismissed, due and not snoozed
+// past now. Reminders about deleted contacts are left out.
+func (r *reminderRepository) GetDue(ctx context.Context, now time.Time) ([]*Reminder, error) {
+	query := "SELECT " + reminderColumns + ` FROM reminders r
+		JOIN contacts c ON r.contact_id = c.contact_id AND c.deleted_date IS NULL
+		WHERE r.dismissed = FALSE AND COALESCE(r.snoozed_until, r.next_date) <= ?
+		ORDER BY r.next_date, r.reminder_id`
+	return r.query(ctx, query, now.UTC())
+}
+
+// GetUpcoming retrieves the reminders of a user that fire between from and to, in the order
+// they fire.
+func (r *reminderRepository) GetUpcoming(ctx context.Context, userID int, from, to time.Time) ([]*Reminder, error) {
+	query := "SELECT " + reminderColumns + ` FROM reminders r
+		JOIN contacts c ON r.contact_id = c.contact_id AND c.deleted_date IS NULL
+		WHERE r.user_id = ? AND r.dismissed = FALSE AND COALESCE(r.snoozed_until, r.next_date) BETWEEN ? AND ?
+		ORDER BY COALESCE(r.snoozed_until, r.next_date), r.reminder_id`
+	return r.query(ctx, query, userID, from.UTC(), to.UTC())
+}
+
+// Reschedule moves a reminder to its next occurrence and clears any snooze.
+func (r *reminderRepository) Reschedule(ctx context.Context, id int, next time.Time) error {
+	query := "UPDATE reminders SET next_date = ?, snoozed_until = NULL, updated_date = CURRENT_TIMESTAMP WHERE reminder_id = ?"
+	return r.exec(ctx, "no rows were affected during the update", query, next.UTC(), id)
+}
+
+// Snooze postpones the current occurrence of a reminder until the given time.
+func (r *reminderRepository) Snooze(ctx context.Context, id int, until time.Time) error {
+	query := "UPDATE reminders SET snoozed_until = ?, updated_date = CURRENT_TIMESTAMP WHERE reminder_id = ?"
+	return r.exec(ctx, "no rows were affected during the update", query, until.UTC(), id)
+}
+
+// Dismiss stops a reminder from firing again.
+func (r *reminderRepository) Dismiss(ctx context.Context, id int) error {
+	query := "UPDATE reminders SET dismissed = TRUE, snoozed_until = NULL, updated_date = CURRENT_TIMESTAMP WHERE reminder_id = ?"
+	return r.exec(ctx, "no rows were affected during the update", query, id)
+}
+
+func (r *reminderRepository) exec(ctx context.Context, noRows, query string, args ...interface{}) error {
+	result, err := r.db.Exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New(noRows)
+	}
+
+	return nil
+}
+
+func (r *reminderRepository) query(ctx context.Context, query string, args ...interface{}) ([]*Reminder, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	reminders := []*Reminder{}
+
+	for rows.Next() {
+		rem, err := scanReminder(rows)
+		if err != nil {
+			return nil, err
+		}
+		reminders = append(reminders, rem)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return reminders, nil
+}
+
+// wallClock formats the date and time of t as read on a wall clock, dropping its location.
+func wallClock(t time.Time) string {
+	return t.Format("2006-01-02 15:04:05")
+}
+
+func scanReminder(row rowScanner) (*Reminder, error) {
+	rem := &Reminder{}
+	var snoozedUntil sql.NullTime
+	err := row.Scan(&rem.ID, &rem.UserID, &rem.ContactID, &rem.Kind, &rem.Note, &rem.StartDate, &rem.Recurrence,
+		&rem.TimeZone, &rem.NextDate, &snoozedUntil, &rem.Dismissed, &rem.Generated)
+	if err != nil {
+		return nil, err
+	}
+	if snoozedUntil.Valid {
+		rem.SnoozedUntil = &snoozedUntil.Time
+	}
+	return rem, nil
+}
+
+// contactDateTime is the wall clock time the reminders of contact dates fire at, like the
+// date-only reminders created by users.
+const contactDateTime = 9 * time.Hour
+
+// contactDates returns the generated reminders a contact should have, by kind: one yearly
+// reminder for each of its dates, starting on the date in UTC.
+func contactDates(c *Contact) (map[string]*Reminder, error) {
+	reminders := map[string]*Reminder{}
+	for kind, date := range map[string]string{ReminderBirthday: c.Birthday, ReminderAnniversary: c.Anniversary} {
+		if date == "" {
+			continue
+		}
+
+		start, err := ParseContactDate(date)
+		if err != nil {
+			return nil, err
+		}
+
+		reminders[kind] = &Reminder{
+			UserID:     c.UserID,
+			ContactID:  c.ID,
+			Kind:       kind,
+			StartDate:  start.Add(contactDateTime),
+			Recurrence: RecurrenceYearly,
+			TimeZone:   "UTC",
+			Generated:  true,
+		}
+	}
+
+	return reminders, nil
+}
+
+// contactDateRepository is a ContactRepository that keeps the generated reminders of contacts
+// in line with their birthday and anniversary.
+type contactDateRepository struct {
+	ContactRepository
+	reminders ReminderRepository
+}
+
+// newContactDateRepository wraps repo so that every contact written gets a yearly reminder for
+// its owner of each of its dates. A reminder is replaced when its date changes and removed with
+// the date; while the date stays the same, it keeps its next occurrence and snooze. repo and
+// reminders query the same transaction, see ContactStore.
+func newContactDateRepository(repo ContactRepository, reminders ReminderRepository) ContactRepository {
+	return &contactDateRepository{ContactRepository: repo, reminders: reminders}
+}
+
+func (r *contactDateRepository) Create(ctx context.Context, c *Contact) error {
+	if _, err := contactDates(c); err != nil {
+		return err
+	}
+
+	if err := r.ContactRepository.Create(ctx, c); err != nil {
+		return err
+	}
+
+	return r.sync(ctx, c.ID)
+}
+
+func (r *contactDateRepository) Update(ctx context.Context, c *Contact) error {
+	if _, err := contactDates(c); err != nil {
+		return err
+	}
+
+	if err := r.ContactRepository.Update(ctx, c); err != nil {
+		return err
+	}
+
+	return r.sync(ctx, c.ID)
+}
+
+func (r *contactDateRepository) Patch(ctx context.Context, c *Contact, fields []string) error {
+	if _, err := contactDates(c); err != nil {
+		return err
+	}
+
+	if err := r.ContactRepository.Patch(ctx, c, fields); err != nil {
+		return err
+	}
+
+	return r.sync(ctx, c.ID)
+}
+
+// sync brings the generated reminders of the stored contact in line with its dates.
+func (r *contactDateRepository) sync(ctx context.Context, contactID int) error {
+	c, err := r.ContactRepository.Get(ctx, contactID)
+	if err != nil {
+		return err
+	}
+
+	wanted, err := contactDates(c)
+	if err != nil {
+		return err
+	}
+
+	existing, err := r.reminders.GetAll(ctx, c.UserID, c.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, rem := range existing {
+		if !rem.Generated {
+			continue
+		}
+
+		if want, ok := wanted[rem.Kind]; ok && wallClock(want.StartDate) == wallClock(rem.StartDate) {
+			delete(wanted, rem.Kind)
+			continue
+		}
+
+		if err := r.reminders.Delete(ctx, rem.ID); err != nil {
+			return err
+		}
+	}
+
+	for _, kind := range []string{ReminderBirthday, ReminderAnniversary} {
+		rem, ok := wanted[kind]
+		if !ok {
+			continue
+		}
+
+		rem.NextDate, _, err = rem.Next(time.Now())
+		if err != nil {
+			return err
+		}
+
+		if err := r.reminders.Create(ctx, rem); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
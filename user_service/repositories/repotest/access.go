@@ -0,0 +1,286 @@
+package repotest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// TestAccessRepository checks the Accesses store.
+func TestAccessRepository(t *testing.T, newStores func(t *testing.T) *Stores) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		accesses := newStores(t).Accesses
+		ctx := context.Background()
+
+		read := &repositories.Access{Name: "read"}
+		must(t, accesses.Create(ctx, read))
+		write := &repositories.Access{Name: "write"}
+		must(t, accesses.Create(ctx, write))
+
+		if read.ID == 0 || write.ID == 0 || read.ID == write.ID {
+			t.Fatalf("expected distinct ids, got %d and %d", read.ID, write.ID)
+		}
+
+		got, err := accesses.Get(ctx, read.ID)
+		must(t, err)
+		if *got != *read {
+			t.Fatalf("expected %+v, got %+v", read, got)
+		}
+
+		all, err := accesses.GetAll(ctx)
+		must(t, err)
+		if len(all) != 2 {
+			t.Fatalf("expected 2 accesses, got %d", len(all))
+		}
+	})
+
+	t.Run("UniqueName", func(t *testing.T) {
+		accesses := newStores(t).Accesses
+		ctx := context.Background()
+
+		read := &repositories.Access{Name: "read"}
+		must(t, accesses.Create(ctx, read))
+		write := &repositories.Access{Name: "write"}
+		must(t, accesses.Create(ctx, write))
+
+		expectIs(t, accesses.Create(ctx, &repositories.Access{Name: "read"}), repositories.ErrAccessNameTaken)
+
+		write.Name = "read"
+		expectIs(t, accesses.Update(ctx, write), repositories.ErrAccessNameTaken)
+
+		// The name of an access object in the trash stays taken until it is purged
+		must(t, accesses.Delete(ctx, read.ID, read.Version, 0))
+		expectIs(t, accesses.Create(ctx, &repositories.Access{Name: "read"}), repositories.ErrAccessNameTaken)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		accesses := newStores(t).Accesses
+		ctx := context.Background()
+
+		read := &repositories.Access{Name: "read"}
+		must(t, accesses.Create(ctx, read))
+
+		read.Name = "read_all"
+		must(t, accesses.Update(ctx, read))
+
+		got, err := accesses.Get(ctx, read.ID)
+		must(t, err)
+		if *got != *read {
+			t.Fatalf("expected %+v, got %+v", read, got)
+		}
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		accesses := newStores(t).Accesses
+		ctx := context.Background()
+
+		read := &repositories.Access{Name: "read"}
+		must(t, accesses.Create(ctx, read))
+		if read.Version != 1 {
+			t.Fatalf("expected a new access object at version 1, got %d", read.Version)
+		}
+
+		stale := *read
+		read.Name = "view"
+		must(t, accesses.Update(ctx, read))
+		if read.Version != 2 {
+			t.Fatalf("expected the update to raise the version to 2, got %d", read.Version)
+		}
+
+		// Updates and deletes at the version read before the update conflict and change nothing
+		stale.Name = "list"
+		expectIs(t, accesses.Update(ctx, &stale), repositories.ErrVersionConflict)
+		expectIs(t, accesses.Delete(ctx, read.ID, stale.Version, 0), repositories.ErrVersionConflict)
+
+		got, err := accesses.Get(ctx, read.ID)
+		must(t, err)
+		if *got != *read {
+			t.Fatalf("expected %+v, got %+v", read, got)
+		}
+
+		must(t, accesses.Delete(ctx, read.ID, read.Version, 0))
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		accesses := newStores(t).Accesses
+		ctx := context.Background()
+
+		read := &repositories.Access{Name: "read"}
+		must(t, accesses.Create(ctx, read))
+		write := &repositories.Access{Name: "write"}
+		must(t, accesses.Create(ctx, write))
+
+		// Only the named fields are written
+		patch := *read
+		patch.Name = "view"
+		must(t, accesses.Patch(ctx, &patch, []string{"name"}))
+		if patch.Version != 2 {
+			t.Fatalf("expected the patch to raise the version to 2, got %d", patch.Version)
+		}
+
+		got, err := accesses.Get(ctx, read.ID)
+		must(t, err)
+		want := *read
+		want.Name = "view"
+		want.Version = 2
+		if *got != want {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+
+		if fields := repositories.ChangedAccessFields(read, got); len(fields) != 1 || fields[0] != "name" {
+			t.Fatalf("expected only name to have changed, got %v", fields)
+		}
+
+		renamed := *got
+		renamed.Name = write.Name
+		expectIs(t, accesses.Patch(ctx, &renamed, []string{"name"}), repositories.ErrAccessNameTaken)
+		expectIs(t, accesses.Patch(ctx, read, []string{"name"}), repositories.ErrVersionConflict)
+		expectError(t, accesses.Patch(ctx, got, []string{"password"}), "cannot patch the password field of a access")
+		expectError(t, accesses.Patch(ctx, &repositories.Access{ID: 404, Version: 1}, []string{"name"}), "no rows were affected during the update")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		accesses := newStores(t).Accesses
+		ctx := context.Background()
+
+		_, err := accesses.Get(ctx, 404)
+		expectError(t, err, "invalid access id")
+		expectError(t, accesses.Update(ctx, &repositories.Access{ID: 404, Name: "nobody"}), "no rows were affected during the update")
+		expectError(t, accesses.Delete(ctx, 404, 1, 0), "no rows were affected during the delete")
+		expectError(t, accesses.Restore(ctx, 404), "no deleted access found with the given id")
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		accesses := newStores(t).Accesses
+		ctx := context.Background()
+
+		read := &repositories.Access{Name: "read"}
+		must(t, accesses.Create(ctx, read))
+		write := &repositories.Access{Name: "write"}
+		must(t, accesses.Create(ctx, write))
+
+		must(t, accesses.Delete(ctx, read.ID, read.Version, 7))
+
+		_, err := accesses.Get(ctx, read.ID)
+		expectError(t, err, "invalid access id")
+		expectError(t, accesses.Update(ctx, read), "no rows were affected during the update")
+		expectError(t, accesses.Delete(ctx, read.ID, read.Version, 7), "no rows were affected during the delete")
+		expectError(t, accesses.Restore(ctx, write.ID), "no deleted access found with the given id")
+
+		all, err := accesses.GetAll(ctx)
+		must(t, err)
+		if len(all) != 1 || all[0].ID != write.ID {
+			t.Fatalf("expected only write outside the trash, got %+v", all)
+		}
+
+		deleted, err := accesses.GetDeleted(ctx)
+		must(t, err)
+		if len(deleted) != 1 || deleted[0].ID != read.ID || deleted[0].DeletedBy != 7 {
+			t.Fatalf("expected read deleted by user 7 in the trash, got %+v", deleted)
+		}
+
+		must(t, accesses.Restore(ctx, read.ID))
+		_, err = accesses.Get(ctx, read.ID)
+		must(t, err)
+		expectError(t, accesses.Restore(ctx, read.ID), "no deleted access found with the given id")
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		accesses := newStores(t).Accesses
+		ctx := context.Background()
+
+		read := &repositories.Access{Name: "read"}
+		must(t, accesses.Create(ctx, read))
+		write := &repositories.Access{Name: "write"}
+		must(t, accesses.Create(ctx, write))
+		must(t, accesses.Delete(ctx, read.ID, read.Version, 0))
+
+		n, err := accesses.Purge(ctx, time.Now().Add(-time.Hour))
+		must(t, err)
+		if n != 0 {
+			t.Fatalf("expected nothing deleted an hour ago to be purged, got %d", n)
+		}
+
+		n, err = accesses.Purge(ctx, time.Now().Add(time.Hour))
+		must(t, err)
+		if n != 1 {
+			t.Fatalf("expected 1 access object purged, got %d", n)
+		}
+
+		expectError(t, accesses.Restore(ctx, read.ID), "no deleted access found with the given id")
+		_, err = accesses.Get(ctx, write.ID)
+		must(t, err)
+
+		// A purged access object frees its name
+		must(t, accesses.Create(ctx, &repositories.Access{Name: "read"}))
+	})
+
+	t.Run("Page", func(t *testing.T) {
+		s := newStores(t)
+		ctx := context.Background()
+
+		admin := &repositories.Role{Name: "admin"}
+		must(t, s.Roles.Create(ctx, admin))
+		guest := &repositories.Role{Name: "guest"}
+		must(t, s.Roles.Create(ctx, guest))
+
+		accesses := map[string]*repositories.Access{}
+		for _, name := range []string{"write", "read", "delete", "export"} {
+			accesses[name] = &repositories.Access{Name: name}
+			must(t, s.Accesses.Create(ctx, accesses[name]))
+		}
+		for _, name := range []string{"write", "read", "delete"} {
+			must(t, s.RoleAccesses.Create(ctx, &repositories.RoleAccess{RoleID: admin.ID, AccessID: accesses[name].ID}))
+		}
+		must(t, s.Accesses.Delete(ctx, accesses["delete"].ID, accesses["delete"].Version, 0))
+
+		page := repositories.PageRequest{Limit: 1, Sort: "name"}
+		first, info, err := s.Accesses.GetPage(ctx, repositories.AccessFilter{RoleID: admin.ID}, page)
+		must(t, err)
+		expectAccessNames(t, first, "read")
+		if info.Total != 2 || info.Next == nil || info.Prev != nil {
+			t.Fatalf("expected 2 accesses of admin with a next page only, got %+v", info)
+		}
+
+		page.Cursor = info.Next
+		second, info, err := s.Accesses.GetPage(ctx, repositories.AccessFilter{RoleID: admin.ID}, page)
+		must(t, err)
+		expectAccessNames(t, second, "write")
+		if info.Next != nil || info.Prev == nil {
+			t.Fatalf("expected a previous page only, got %+v", info)
+		}
+
+		all, info, err := s.Accesses.GetPage(ctx, repositories.AccessFilter{}, repositories.PageRequest{Limit: 10, Sort: "id"})
+		must(t, err)
+		expectAccessNames(t, all, "write", "read", "export")
+		if info.Total != 3 {
+			t.Fatalf("expected 3 accesses, got %d", info.Total)
+		}
+
+		none, info, err := s.Accesses.GetPage(ctx, repositories.AccessFilter{RoleID: guest.ID}, repositories.PageRequest{Limit: 10, Sort: "id"})
+		must(t, err)
+		expectAccessNames(t, none)
+		if info.Total != 0 || info.Next != nil || info.Prev != nil {
+			t.Fatalf("expected no accesses for guest, got %+v", info)
+		}
+
+		prefix, _, err := s.Accesses.GetPage(ctx, repositories.AccessFilter{NamePrefix: "E"}, repositories.PageRequest{Limit: 10, Sort: "name"})
+		must(t, err)
+		expectAccessNames(t, prefix, "export")
+	})
+}
+
+// expectAccessNames fails the test unless accesses have the given names, in order.
+func expectAccessNames(t *testing.T, accesses []*repositories.Access, names ...string) {
+	t.Helper()
+	got := []string{}
+	for _, a := range accesses {
+		got = append(got, a.Name)
+	}
+	if strings.Join(got, ",") != strings.Join(names, ",") {
+		t.Fatalf("expected accesses %v, got %v", names, got)
+	}
+}
@@ -0,0 +1,91 @@
+// Package repotest is a conformance suite for the user, role and access repositories. Every
+// implementation is expected to pass it, so that executors tested against the in-memory
+// repositories behave the same on a database. A test runs it with a function returning empty
+// stores, for instance:
+//
+//	func TestMemoryRepositories(t *testing.T) {
+//		repotest.Run(t, func(t *testing.T) *repotest.Stores {
+//			users, roles, accesses := memory.NewUserRepository(), memory.NewRoleRepository(), memory.NewAccessRepository()
+//			roleAccesses := memory.NewRoleAccessRepository(accesses)
+//			return &repotest.Stores{
+//				Users:        users,
+//				Roles:        roles,
+//				Accesses:     accesses,
+//				RoleAccesses: roleAccesses,
+//				UserRoles:    memory.NewUserRoleRepository(users, roles, roleAccesses),
+//			}
+//		})
+//	}
+//
+// SQLStores runs it against a database of any dialect, migrated from scratch for every subtest.
+// SQLite keeps the suite fast with a file in a temporary directory:
+//
+//	func TestSQLiteRepositories(t *testing.T) {
+//		dsn := filepath.Join(t.TempDir(), "contacts.db") + "?_foreign_keys=on"
+//		db, err := repositories.Open("sqlite", dsn)
+//		if err != nil {
+//			t.Fatal(err)
+//		}
+//		defer db.Close()
+//
+//		repotest.Run(t, repotest.SQLStores(db))
+//	}
+//
+// MySQL and PostgreSQL run it the same way, on a database kept for tests. The tests of package
+// repositories do so when MYSQL_TEST_DSN and POSTGRES_TEST_DSN are set, and always run SQLite.
+package repotest
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Stores holds the repositories under test. They must share one backing store, as the role and
+// access lookups of RoleAccesses and UserRoles read Roles and Accesses.
+type Stores struct {
+	Users        repositories.UserRepository
+	Roles        repositories.RoleRepository
+	Accesses     repositories.AccessRepository
+	RoleAccesses repositories.RoleAccessRepository
+	UserRoles    repositories.UserRoleRepository
+}
+
+// Run runs the whole suite as subtests of t. newStores is called once per subtest and must return
+// stores holding no records.
+func Run(t *testing.T, newStores func(t *testing.T) *Stores) {
+	t.Run("Users", func(t *testing.T) { TestUserRepository(t, newStores) })
+	t.Run("Roles", func(t *testing.T) { TestRoleRepository(t, newStores) })
+	t.Run("Accesses", func(t *testing.T) { TestAccessRepository(t, newStores) })
+	t.Run("RoleAccesses", func(t *testing.T) { TestRoleAccessRepository(t, newStores) })
+	t.Run("UserRoles", func(t *testing.T) { TestUserRoleRepository(t, newStores) })
+}
+
+// must fails the test when err is not nil.
+func must(t *testing.T, err error) {
+	t.Helper()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+}
+
+// expectError fails the test unless err has the given message. The message is part of the
+// contract: handlers return it to clients.
+func expectError(t *testing.T, err error, message string) {
+	t.Helper()
+	if err == nil {
+		t.Fatalf("expected error %q, got none", message)
+	}
+	if err.Error() != message {
+		t.Fatalf("expected error %q, got %q", message, err.Error())
+	}
+}
+
+// expectIs fails the test unless err is target.
+func expectIs(t *testing.T, err, target error) {
+	t.Helper()
+	if !errors.Is(err, target) {
+		t.Fatalf("expected error %q, got %v", target, err)
+	}
+}
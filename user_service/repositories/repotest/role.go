@@ -0,0 +1,287 @@
+package repotest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// TestRoleRepository checks the Roles store.
+func TestRoleRepository(t *testing.T, newStores func(t *testing.T) *Stores) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		roles := newStores(t).Roles
+		ctx := context.Background()
+
+		admin := &repositories.Role{Name: "admin"}
+		must(t, roles.Create(ctx, admin))
+		editor := &repositories.Role{Name: "editor"}
+		must(t, roles.Create(ctx, editor))
+
+		if admin.ID == 0 || editor.ID == 0 || admin.ID == editor.ID {
+			t.Fatalf("expected distinct ids, got %d and %d", admin.ID, editor.ID)
+		}
+
+		got, err := roles.Get(ctx, admin.ID)
+		must(t, err)
+		if *got != *admin {
+			t.Fatalf("expected %+v, got %+v", admin, got)
+		}
+
+		all, err := roles.GetAll(ctx)
+		must(t, err)
+		if len(all) != 2 {
+			t.Fatalf("expected 2 roles, got %d", len(all))
+		}
+	})
+
+	t.Run("UniqueName", func(t *testing.T) {
+		roles := newStores(t).Roles
+		ctx := context.Background()
+
+		admin := &repositories.Role{Name: "admin"}
+		must(t, roles.Create(ctx, admin))
+		editor := &repositories.Role{Name: "editor"}
+		must(t, roles.Create(ctx, editor))
+
+		expectIs(t, roles.Create(ctx, &repositories.Role{Name: "admin"}), repositories.ErrRoleNameTaken)
+
+		editor.Name = "admin"
+		expectIs(t, roles.Update(ctx, editor), repositories.ErrRoleNameTaken)
+
+		// The name of a role in the trash stays taken until the role is purged
+		must(t, roles.Delete(ctx, admin.ID, admin.Version, 0))
+		expectIs(t, roles.Create(ctx, &repositories.Role{Name: "admin"}), repositories.ErrRoleNameTaken)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		roles := newStores(t).Roles
+		ctx := context.Background()
+
+		admin := &repositories.Role{Name: "admin"}
+		must(t, roles.Create(ctx, admin))
+
+		admin.Name = "administrator"
+		must(t, roles.Update(ctx, admin))
+
+		got, err := roles.Get(ctx, admin.ID)
+		must(t, err)
+		if *got != *admin {
+			t.Fatalf("expected %+v, got %+v", admin, got)
+		}
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		roles := newStores(t).Roles
+		ctx := context.Background()
+
+		admin := &repositories.Role{Name: "admin"}
+		must(t, roles.Create(ctx, admin))
+		if admin.Version != 1 {
+			t.Fatalf("expected a new role at version 1, got %d", admin.Version)
+		}
+
+		stale := *admin
+		admin.Name = "administrator"
+		must(t, roles.Update(ctx, admin))
+		if admin.Version != 2 {
+			t.Fatalf("expected the update to raise the version to 2, got %d", admin.Version)
+		}
+
+		// Updates and deletes at the version read before the update conflict and change nothing
+		stale.Name = "root"
+		expectIs(t, roles.Update(ctx, &stale), repositories.ErrVersionConflict)
+		expectIs(t, roles.Delete(ctx, admin.ID, stale.Version, 0), repositories.ErrVersionConflict)
+
+		got, err := roles.Get(ctx, admin.ID)
+		must(t, err)
+		if *got != *admin {
+			t.Fatalf("expected %+v, got %+v", admin, got)
+		}
+
+		must(t, roles.Delete(ctx, admin.ID, admin.Version, 0))
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		roles := newStores(t).Roles
+		ctx := context.Background()
+
+		admin := &repositories.Role{Name: "admin"}
+		must(t, roles.Create(ctx, admin))
+		editor := &repositories.Role{Name: "editor"}
+		must(t, roles.Create(ctx, editor))
+
+		// Only the named fields are written
+		patch := *admin
+		patch.Name = "administrator"
+		must(t, roles.Patch(ctx, &patch, []string{"name"}))
+		if patch.Version != 2 {
+			t.Fatalf("expected the patch to raise the version to 2, got %d", patch.Version)
+		}
+
+		got, err := roles.Get(ctx, admin.ID)
+		must(t, err)
+		want := *admin
+		want.Name = "administrator"
+		want.Version = 2
+		if *got != want {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+
+		if fields := repositories.ChangedRoleFields(admin, got); len(fields) != 1 || fields[0] != "name" {
+			t.Fatalf("expected only name to have changed, got %v", fields)
+		}
+
+		renamed := *got
+		renamed.Name = editor.Name
+		expectIs(t, roles.Patch(ctx, &renamed, []string{"name"}), repositories.ErrRoleNameTaken)
+		expectIs(t, roles.Patch(ctx, admin, []string{"name"}), repositories.ErrVersionConflict)
+		expectError(t, roles.Patch(ctx, got, []string{"password"}), "cannot patch the password field of a role")
+		expectError(t, roles.Patch(ctx, &repositories.Role{ID: 404, Version: 1}, []string{"name"}), "no rows were affected during the update")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		roles := newStores(t).Roles
+		ctx := context.Background()
+
+		_, err := roles.Get(ctx, 404)
+		expectError(t, err, "invalid role id")
+		expectError(t, roles.Update(ctx, &repositories.Role{ID: 404, Name: "nobody"}), "no rows were affected during the update")
+		expectError(t, roles.Delete(ctx, 404, 1, 0), "no rows were affected during the delete")
+		expectError(t, roles.Restore(ctx, 404), "no deleted role found with the given id")
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		roles := newStores(t).Roles
+		ctx := context.Background()
+
+		admin := &repositories.Role{Name: "admin"}
+		must(t, roles.Create(ctx, admin))
+		editor := &repositories.Role{Name: "editor"}
+		must(t, roles.Create(ctx, editor))
+
+		must(t, roles.Delete(ctx, admin.ID, admin.Version, 7))
+
+		_, err := roles.Get(ctx, admin.ID)
+		expectError(t, err, "invalid role id")
+		expectError(t, roles.Update(ctx, admin), "no rows were affected during the update")
+		expectError(t, roles.Delete(ctx, admin.ID, admin.Version, 7), "no rows were affected during the delete")
+		expectError(t, roles.Restore(ctx, editor.ID), "no deleted role found with the given id")
+
+		all, err := roles.GetAll(ctx)
+		must(t, err)
+		if len(all) != 1 || all[0].ID != editor.ID {
+			t.Fatalf("expected only editor outside the trash, got %+v", all)
+		}
+
+		deleted, err := roles.GetDeleted(ctx)
+		must(t, err)
+		if len(deleted) != 1 || deleted[0].ID != admin.ID || deleted[0].DeletedBy != 7 {
+			t.Fatalf("expected admin deleted by user 7 in the trash, got %+v", deleted)
+		}
+
+		must(t, roles.Restore(ctx, admin.ID))
+		_, err = roles.Get(ctx, admin.ID)
+		must(t, err)
+		expectError(t, roles.Restore(ctx, admin.ID), "no deleted role found with the given id")
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		roles := newStores(t).Roles
+		ctx := context.Background()
+
+		admin := &repositories.Role{Name: "admin"}
+		must(t, roles.Create(ctx, admin))
+		editor := &repositories.Role{Name: "editor"}
+		must(t, roles.Create(ctx, editor))
+		must(t, roles.Delete(ctx, admin.ID, admin.Version, 0))
+
+		n, err := roles.Purge(ctx, time.Now().Add(-time.Hour))
+		must(t, err)
+		if n != 0 {
+			t.Fatalf("expected nothing deleted an hour ago to be purged, got %d", n)
+		}
+
+		n, err = roles.Purge(ctx, time.Now().Add(time.Hour))
+		must(t, err)
+		if n != 1 {
+			t.Fatalf("expected 1 role purged, got %d", n)
+		}
+
+		expectError(t, roles.Restore(ctx, admin.ID), "no deleted role found with the given id")
+		_, err = roles.Get(ctx, editor.ID)
+		must(t, err)
+
+		// A purged role frees its name
+		must(t, roles.Create(ctx, &repositories.Role{Name: "admin"}))
+	})
+
+	t.Run("Page", func(t *testing.T) {
+		roles := newStores(t).Roles
+		ctx := context.Background()
+
+		for _, name := range []string{"editor", "admin", "viewer", "auditor"} {
+			must(t, roles.Create(ctx, &repositories.Role{Name: name}))
+		}
+
+		page := repositories.PageRequest{Limit: 3, Sort: "name", Desc: true}
+		first, info, err := roles.GetPage(ctx, repositories.RoleFilter{}, page)
+		must(t, err)
+		expectRoleNames(t, first, "viewer", "editor", "auditor")
+		if info.Total != 4 || info.Next == nil || info.Prev != nil {
+			t.Fatalf("expected 4 roles with a next page only, got %+v", info)
+		}
+
+		page.Cursor = info.Next
+		last, info, err := roles.GetPage(ctx, repositories.RoleFilter{}, page)
+		must(t, err)
+		expectRoleNames(t, last, "admin")
+		if info.Next != nil || info.Prev == nil {
+			t.Fatalf("expected a previous page only, got %+v", info)
+		}
+
+		page.Cursor, err = repositories.DecodeCursor(info.Prev.Encode())
+		must(t, err)
+		back, info, err := roles.GetPage(ctx, repositories.RoleFilter{}, page)
+		must(t, err)
+		expectRoleNames(t, back, "viewer", "editor", "auditor")
+		if info.Total != 4 || info.Next == nil || info.Prev != nil {
+			t.Fatalf("expected the first page again with a next page only, got %+v", info)
+		}
+
+		// A page ending before the first role is empty and has no cursors
+		page.Cursor = &repositories.Cursor{Sort: "name", Desc: true, Key: repositories.SortKey{Value: "viewer", ID: first[0].ID}, Before: true}
+		empty, info, err := roles.GetPage(ctx, repositories.RoleFilter{}, page)
+		must(t, err)
+		expectRoleNames(t, empty)
+		if info.Total != 4 || info.Next != nil || info.Prev != nil {
+			t.Fatalf("expected an empty page without cursors, got %+v", info)
+		}
+
+		prefix, info, err := roles.GetPage(ctx, repositories.RoleFilter{NamePrefix: "A"}, repositories.PageRequest{Limit: 10, Sort: "name"})
+		must(t, err)
+		expectRoleNames(t, prefix, "admin", "auditor")
+		if info.Total != 2 || info.Next != nil || info.Prev != nil {
+			t.Fatalf("expected a single page of 2 roles, got %+v", info)
+		}
+
+		// Wildcards in the prefix match themselves
+		none, _, err := roles.GetPage(ctx, repositories.RoleFilter{NamePrefix: "_"}, repositories.PageRequest{Limit: 10, Sort: "id"})
+		must(t, err)
+		expectRoleNames(t, none)
+	})
+}
+
+// expectRoleNames fails the test unless roles have the given names, in order.
+func expectRoleNames(t *testing.T, roles []*repositories.Role, names ...string) {
+	t.Helper()
+	got := []string{}
+	for _, r := range roles {
+		got = append(got, r.Name)
+	}
+	if strings.Join(got, ",") != strings.Join(names, ",") {
+		t.Fatalf("expected roles %v, got %v", names, got)
+	}
+}
@@ -0,0 +1,74 @@
+package repotest
+
+import (
+	"context"
+	"testing"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// TestRoleAccessRepository checks the RoleAccesses store.
+func TestRoleAccessRepository(t *testing.T, newStores func(t *testing.T) *Stores) {
+	t.Run("Grant", func(t *testing.T) {
+		s := newStores(t)
+		ctx := context.Background()
+
+		admin := &repositories.Role{Name: "admin"}
+		must(t, s.Roles.Create(ctx, admin))
+		read := &repositories.Access{Name: "read"}
+		must(t, s.Accesses.Create(ctx, read))
+		write := &repositories.Access{Name: "write"}
+		must(t, s.Accesses.Create(ctx, write))
+
+		must(t, s.RoleAccesses.Create(ctx, &repositories.RoleAccess{RoleID: admin.ID, AccessID: read.ID}))
+		must(t, s.RoleAccesses.Create(ctx, &repositories.RoleAccess{RoleID: admin.ID, AccessID: write.ID}))
+		expectIs(t, s.RoleAccesses.Create(ctx, &repositories.RoleAccess{RoleID: admin.ID, AccessID: read.ID}),
+			repositories.ErrRoleAccessExists)
+
+		got, err := s.RoleAccesses.Get(ctx, admin.ID, read.ID)
+		must(t, err)
+		if got.RoleID != admin.ID || got.AccessID != read.ID {
+			t.Fatalf("expected role %d with access %d, got %+v", admin.ID, read.ID, got)
+		}
+
+		all, err := s.RoleAccesses.GetAll(ctx)
+		must(t, err)
+		if len(all) != 2 {
+			t.Fatalf("expected 2 role accesses, got %d", len(all))
+		}
+
+		// Access objects in the trash are not granted
+		must(t, s.Accesses.Delete(ctx, write.ID, write.Version, 0))
+		accesses, err := s.RoleAccesses.GetAccessesForRole(ctx, admin.ID)
+		must(t, err)
+		if len(accesses) != 1 || *accesses[0] != *read {
+			t.Fatalf("expected only read granted to admin, got %+v", accesses)
+		}
+	})
+
+	t.Run("Revoke", func(t *testing.T) {
+		s := newStores(t)
+		ctx := context.Background()
+
+		admin := &repositories.Role{Name: "admin"}
+		must(t, s.Roles.Create(ctx, admin))
+		read := &repositories.Access{Name: "read"}
+		must(t, s.Accesses.Create(ctx, read))
+		must(t, s.RoleAccesses.Create(ctx, &repositories.RoleAccess{RoleID: admin.ID, AccessID: read.ID}))
+
+		must(t, s.RoleAccesses.Delete(ctx, admin.ID, read.ID))
+
+		_, err := s.RoleAccesses.Get(ctx, admin.ID, read.ID)
+		expectError(t, err, "the role does not have this access")
+		expectError(t, s.RoleAccesses.Delete(ctx, admin.ID, read.ID), "no rows were affected during the delete")
+
+		accesses, err := s.RoleAccesses.GetAccessesForRole(ctx, admin.ID)
+		must(t, err)
+		if len(accesses) != 0 {
+			t.Fatalf("expected no access granted to admin, got %+v", accesses)
+		}
+
+		// A revoked access can be granted again
+		must(t, s.RoleAccesses.Create(ctx, &repositories.RoleAccess{RoleID: admin.ID, AccessID: read.ID}))
+	})
+}
@@ -0,0 +1,30 @@
+package repotest
+
+import (
+	"testing"
+
+	"github.com/princeparmar/contact_manager/migrations"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// SQLStores returns a newStores function for Run that gives every subtest the SQL repositories on
+// db, after rolling back and applying again all the migrations of its dialect. db must be kept for
+// tests: its tables are dropped.
+func SQLStores(db *repositories.DB) func(t *testing.T) *Stores {
+	return func(t *testing.T) *Stores {
+		runner, err := migrations.NewRunner(db)
+		must(t, err)
+		_, err = runner.Down(len(runner.Migrations))
+		must(t, err)
+		_, err = runner.Up()
+		must(t, err)
+
+		return &Stores{
+			Users:        repositories.NewUserRepository(db),
+			Roles:        repositories.NewRoleRepository(db),
+			Accesses:     repositories.NewAccessRepository(db),
+			RoleAccesses: repositories.NewRoleAccessRepository(db),
+			UserRoles:    repositories.NewUserRoleRepository(db),
+		}
+	}
+}
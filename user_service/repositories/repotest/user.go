@@ -0,0 +1,356 @@
+package repotest
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// TestUserRepository checks the Users store.
+func TestUserRepository(t *testing.T, newStores func(t *testing.T) *Stores) {
+	t.Run("CreateAndGet", func(t *testing.T) {
+		users := newStores(t).Users
+		ctx := context.Background()
+
+		alice := &repositories.User{UserName: "alice", Mobile: "5550000001", EmailID: "alice@example.com"}
+		must(t, users.Create(ctx, alice))
+		bob := &repositories.User{UserName: "bob", Mobile: "5550000002", EmailID: "bob@example.com"}
+		must(t, users.Create(ctx, bob))
+
+		if alice.ID == 0 || bob.ID == 0 || alice.ID == bob.ID {
+			t.Fatalf("expected distinct ids, got %d and %d", alice.ID, bob.ID)
+		}
+
+		got, err := users.Get(ctx, alice.ID)
+		must(t, err)
+		if *got != *alice {
+			t.Fatalf("expected %+v, got %+v", alice, got)
+		}
+
+		got, err = users.GetUserByUserName(ctx, "bob")
+		must(t, err)
+		if *got != *bob {
+			t.Fatalf("expected %+v, got %+v", bob, got)
+		}
+
+		for _, list := range []func(context.Context) ([]*repositories.User, error){users.GetAll, users.List} {
+			all, err := list(ctx)
+			must(t, err)
+			if len(all) != 2 {
+				t.Fatalf("expected 2 users, got %d", len(all))
+			}
+		}
+	})
+
+	t.Run("UniqueName", func(t *testing.T) {
+		users := newStores(t).Users
+		ctx := context.Background()
+
+		alice := &repositories.User{UserName: "alice", Mobile: "5550000001"}
+		must(t, users.Create(ctx, alice))
+		bob := &repositories.User{UserName: "bob", Mobile: "5550000002"}
+		must(t, users.Create(ctx, bob))
+
+		expectIs(t, users.Create(ctx, &repositories.User{UserName: "alice"}), repositories.ErrUserNameTaken)
+
+		bob.UserName = "alice"
+		expectIs(t, users.Update(ctx, bob), repositories.ErrUserNameTaken)
+
+		// The name of a user in the trash stays taken until the user is purged
+		must(t, users.Delete(ctx, alice.ID, alice.Version, 0))
+		expectIs(t, users.Create(ctx, &repositories.User{UserName: "alice"}), repositories.ErrUserNameTaken)
+	})
+
+	t.Run("Update", func(t *testing.T) {
+		users := newStores(t).Users
+		ctx := context.Background()
+
+		alice := &repositories.User{UserName: "alice", Mobile: "5550000001", EmailID: "alice@example.com"}
+		must(t, users.Create(ctx, alice))
+
+		alice.UserName, alice.EmailID = "alice.smith", "alice.smith@example.com"
+		must(t, users.Update(ctx, alice))
+
+		got, err := users.Get(ctx, alice.ID)
+		must(t, err)
+		if *got != *alice {
+			t.Fatalf("expected %+v, got %+v", alice, got)
+		}
+	})
+
+	t.Run("Password", func(t *testing.T) {
+		users := newStores(t).Users
+		ctx := context.Background()
+
+		alice := &repositories.User{UserName: "alice", Mobile: "5550000001"}
+		must(t, users.Create(ctx, alice))
+
+		password, err := users.GetPassword(ctx, alice.ID)
+		must(t, err)
+		if password != "" {
+			t.Fatalf("expected a new user to have no password, got %q", password)
+		}
+
+		must(t, users.UpdatePassword(ctx, alice.ID, "hash"))
+		password, err = users.GetPassword(ctx, alice.ID)
+		must(t, err)
+		if password != "hash" {
+			t.Fatalf("expected password %q, got %q", "hash", password)
+		}
+	})
+
+	t.Run("Version", func(t *testing.T) {
+		users := newStores(t).Users
+		ctx := context.Background()
+
+		alice := &repositories.User{UserName: "alice", Mobile: "5550000001", EmailID: "alice@example.com"}
+		must(t, users.Create(ctx, alice))
+		if alice.Version != 1 {
+			t.Fatalf("expected a new user at version 1, got %d", alice.Version)
+		}
+
+		stale := *alice
+		alice.UserName = "alicia"
+		must(t, users.Update(ctx, alice))
+		if alice.Version != 2 {
+			t.Fatalf("expected the update to raise the version to 2, got %d", alice.Version)
+		}
+
+		// Updates and deletes at the version read before the update conflict and change nothing
+		stale.UserName = "ally"
+		expectIs(t, users.Update(ctx, &stale), repositories.ErrVersionConflict)
+		expectIs(t, users.Delete(ctx, alice.ID, stale.Version, 0), repositories.ErrVersionConflict)
+
+		got, err := users.Get(ctx, alice.ID)
+		must(t, err)
+		if *got != *alice {
+			t.Fatalf("expected %+v, got %+v", alice, got)
+		}
+
+		must(t, users.Delete(ctx, alice.ID, alice.Version, 0))
+	})
+
+	t.Run("Patch", func(t *testing.T) {
+		users := newStores(t).Users
+		ctx := context.Background()
+
+		alice := &repositories.User{UserName: "alice", Mobile: "5550000001", EmailID: "alice@example.com"}
+		must(t, users.Create(ctx, alice))
+		bob := &repositories.User{UserName: "bob", Mobile: "5550000002", EmailID: "bob@example.com"}
+		must(t, users.Create(ctx, bob))
+
+		// Only the named fields are written
+		patch := *alice
+		patch.Mobile = "5550000009"
+		patch.EmailID = "ignored@example.com"
+		must(t, users.Patch(ctx, &patch, []string{"mobile"}))
+		if patch.Version != 2 {
+			t.Fatalf("expected the patch to raise the version to 2, got %d", patch.Version)
+		}
+
+		got, err := users.Get(ctx, alice.ID)
+		must(t, err)
+		want := *alice
+		want.Mobile = "5550000009"
+		want.Version = 2
+		if *got != want {
+			t.Fatalf("expected %+v, got %+v", want, got)
+		}
+
+		if fields := repositories.ChangedUserFields(alice, got); len(fields) != 1 || fields[0] != "mobile" {
+			t.Fatalf("expected only mobile to have changed, got %v", fields)
+		}
+
+		renamed := *got
+		renamed.UserName = bob.UserName
+		expectIs(t, users.Patch(ctx, &renamed, []string{"user_name"}), repositories.ErrUserNameTaken)
+		expectIs(t, users.Patch(ctx, alice, []string{"mobile"}), repositories.ErrVersionConflict)
+		expectError(t, users.Patch(ctx, got, []string{"password"}), "cannot patch the password field of a user")
+		expectError(t, users.Patch(ctx, &repositories.User{ID: 404, Version: 1}, []string{"mobile"}), "no rows were affected during the update")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		users := newStores(t).Users
+		ctx := context.Background()
+
+		_, err := users.Get(ctx, 404)
+		expectError(t, err, "invalid user id")
+		_, err = users.GetUserByUserName(ctx, "nobody")
+		expectError(t, err, "invalid user name")
+		_, err = users.GetPassword(ctx, 404)
+		expectError(t, err, "invalid user id")
+		expectError(t, users.Update(ctx, &repositories.User{ID: 404, UserName: "nobody"}), "no rows were affected during the update")
+		expectError(t, users.UpdatePassword(ctx, 404, "hash"), "no rows were affected during the update")
+		expectError(t, users.Delete(ctx, 404, 1, 0), "no rows were affected during the delete")
+		expectError(t, users.Restore(ctx, 404), "no deleted user found with the given id")
+	})
+
+	t.Run("Trash", func(t *testing.T) {
+		users := newStores(t).Users
+		ctx := context.Background()
+
+		alice := &repositories.User{UserName: "alice", Mobile: "5550000001"}
+		must(t, users.Create(ctx, alice))
+		bob := &repositories.User{UserName: "bob", Mobile: "5550000002"}
+		must(t, users.Create(ctx, bob))
+
+		must(t, users.Delete(ctx, alice.ID, alice.Version, bob.ID))
+
+		_, err := users.Get(ctx, alice.ID)
+		expectError(t, err, "invalid user id")
+		_, err = users.GetUserByUserName(ctx, "alice")
+		expectError(t, err, "invalid user name")
+		expectError(t, users.Update(ctx, alice), "no rows were affected during the update")
+		expectError(t, users.Delete(ctx, alice.ID, alice.Version, bob.ID), "no rows were affected during the delete")
+		expectError(t, users.Restore(ctx, bob.ID), "no deleted user found with the given id")
+
+		all, err := users.GetAll(ctx)
+		must(t, err)
+		if len(all) != 1 || all[0].ID != bob.ID {
+			t.Fatalf("expected only bob outside the trash, got %+v", all)
+		}
+
+		deleted, err := users.GetDeleted(ctx)
+		must(t, err)
+		if len(deleted) != 1 || deleted[0].ID != alice.ID || deleted[0].DeletedBy != bob.ID {
+			t.Fatalf("expected alice deleted by bob in the trash, got %+v", deleted)
+		}
+
+		must(t, users.Restore(ctx, alice.ID))
+		_, err = users.Get(ctx, alice.ID)
+		must(t, err)
+		expectError(t, users.Restore(ctx, alice.ID), "no deleted user found with the given id")
+	})
+
+	t.Run("Purge", func(t *testing.T) {
+		users := newStores(t).Users
+		ctx := context.Background()
+
+		alice := &repositories.User{UserName: "alice", Mobile: "5550000001"}
+		must(t, users.Create(ctx, alice))
+		bob := &repositories.User{UserName: "bob", Mobile: "5550000002"}
+		must(t, users.Create(ctx, bob))
+		must(t, users.Delete(ctx, alice.ID, alice.Version, 0))
+
+		n, err := users.Purge(ctx, time.Now().Add(-time.Hour))
+		must(t, err)
+		if n != 0 {
+			t.Fatalf("expected nothing deleted an hour ago to be purged, got %d", n)
+		}
+
+		n, err = users.Purge(ctx, time.Now().Add(time.Hour))
+		must(t, err)
+		if n != 1 {
+			t.Fatalf("expected 1 user purged, got %d", n)
+		}
+
+		expectError(t, users.Restore(ctx, alice.ID), "no deleted user found with the given id")
+		_, err = users.Get(ctx, bob.ID)
+		must(t, err)
+
+		// A purged user frees its name
+		must(t, users.Create(ctx, &repositories.User{UserName: "alice", Mobile: "5550000001"}))
+	})
+
+	t.Run("Cancelled", func(t *testing.T) {
+		users := newStores(t).Users
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+
+		expectIs(t, users.Create(ctx, &repositories.User{UserName: "alice", Mobile: "5550000001"}), context.Canceled)
+		_, err := users.GetAll(ctx)
+		expectIs(t, err, context.Canceled)
+
+		// Nothing was stored by the cancelled operation
+		all, err := users.GetAll(context.Background())
+		must(t, err)
+		if len(all) != 0 {
+			t.Fatalf("expected no users, got %+v", all)
+		}
+	})
+
+	t.Run("Page", func(t *testing.T) {
+		users := newStores(t).Users
+		ctx := context.Background()
+
+		ids := map[string]int{}
+		for _, u := range []*repositories.User{
+			{UserName: "carol", Mobile: "5550000003", EmailID: "carol@Example.com"},
+			{UserName: "alice", Mobile: "5550000001", EmailID: "alice@example.com"},
+			{UserName: "bob", Mobile: "5550000002", EmailID: "bob@other.org"},
+			{UserName: "dave", Mobile: "5550000004", EmailID: "dave@example.com"},
+			{UserName: "erin", Mobile: "5550000005", EmailID: "erin@example.com"},
+		} {
+			must(t, users.Create(ctx, u))
+			ids[u.UserName] = u.ID
+		}
+		must(t, users.Delete(ctx, ids["erin"], 1, 0))
+
+		page := repositories.PageRequest{Limit: 2, Sort: "user_name"}
+		first, info, err := users.GetPage(ctx, repositories.UserFilter{}, page)
+		must(t, err)
+		expectUserNames(t, first, "alice", "bob")
+		if info.Total != 4 || info.Next == nil || info.Prev != nil {
+			t.Fatalf("expected 4 users with a next page only, got %+v", info)
+		}
+
+		// A user added before the cursor does not move the next page
+		must(t, users.Create(ctx, &repositories.User{UserName: "aaron", Mobile: "5550000006", EmailID: "aaron@other.org"}))
+
+		page.Cursor, err = repositories.DecodeCursor(info.Next.Encode())
+		must(t, err)
+		second, info, err := users.GetPage(ctx, repositories.UserFilter{}, page)
+		must(t, err)
+		expectUserNames(t, second, "carol", "dave")
+		if info.Total != 5 || info.Next != nil || info.Prev == nil {
+			t.Fatalf("expected 5 users with a previous page only, got %+v", info)
+		}
+
+		page.Cursor = info.Prev
+		back, info, err := users.GetPage(ctx, repositories.UserFilter{}, page)
+		must(t, err)
+		expectUserNames(t, back, "alice", "bob")
+		if info.Next == nil || info.Prev == nil {
+			t.Fatalf("expected pages before and after alice and bob, got %+v", info)
+		}
+
+		byID, _, err := users.GetPage(ctx, repositories.UserFilter{}, repositories.PageRequest{Limit: 3, Sort: "id", Desc: true})
+		must(t, err)
+		expectUserNames(t, byID, "aaron", "dave", "bob")
+
+		domain, info, err := users.GetPage(ctx, repositories.UserFilter{EmailDomain: "EXAMPLE.com"}, repositories.PageRequest{Limit: 10, Sort: "email_id"})
+		must(t, err)
+		expectUserNames(t, domain, "alice", "carol", "dave")
+		if info.Total != 3 {
+			t.Fatalf("expected 3 users at example.com, got %d", info.Total)
+		}
+
+		prefix, _, err := users.GetPage(ctx, repositories.UserFilter{EmailDomain: "example.com", NamePrefix: "A"}, repositories.PageRequest{Limit: 10, Sort: "id"})
+		must(t, err)
+		expectUserNames(t, prefix, "alice")
+
+		_, _, err = users.GetPage(ctx, repositories.UserFilter{}, repositories.PageRequest{Limit: 10, Sort: "password"})
+		if err == nil {
+			t.Fatal("expected an error sorting by password")
+		}
+
+		// A cursor belongs to the sort order it was issued for
+		_, _, err = users.GetPage(ctx, repositories.UserFilter{}, repositories.PageRequest{Limit: 10, Sort: "id", Cursor: page.Cursor})
+		expectIs(t, err, repositories.ErrInvalidCursor)
+	})
+}
+
+// expectUserNames fails the test unless users have the given names, in order.
+func expectUserNames(t *testing.T, users []*repositories.User, names ...string) {
+	t.Helper()
+	got := []string{}
+	for _, u := range users {
+		got = append(got, u.UserName)
+	}
+	if strings.Join(got, ",") != strings.Join(names, ",") {
+		t.Fatalf("expected users %v, got %v", names, got)
+	}
+}
@@ -0,0 +1,166 @@
+package repotest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// TestUserRoleRepository checks the UserRoles store.
+func TestUserRoleRepository(t *testing.T, newStores func(t *testing.T) *Stores) {
+	t.Run("Assign", func(t *testing.T) {
+		s := newStores(t)
+		ctx := context.Background()
+
+		alice := &repositories.User{UserName: "alice", Mobile: "5550000001"}
+		must(t, s.Users.Create(ctx, alice))
+		admin := &repositories.Role{Name: "admin"}
+		must(t, s.Roles.Create(ctx, admin))
+
+		expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+		must(t, s.UserRoles.Create(ctx, &repositories.UserRole{UserID: alice.ID, RoleID: admin.ID, ExpiryDate: expiry}))
+		expectIs(t, s.UserRoles.Create(ctx, &repositories.UserRole{UserID: alice.ID, RoleID: admin.ID, ExpiryDate: expiry}),
+			repositories.ErrUserRoleExists)
+
+		got, err := s.UserRoles.Get(ctx, alice.ID, admin.ID)
+		must(t, err)
+		if got.UserID != alice.ID || got.RoleID != admin.ID || !got.ExpiryDate.Equal(expiry) {
+			t.Fatalf("expected alice to be admin until %v, got %+v", expiry, got)
+		}
+
+		expiry = expiry.Add(24 * time.Hour)
+		must(t, s.UserRoles.Update(ctx, &repositories.UserRole{UserID: alice.ID, RoleID: admin.ID, ExpiryDate: expiry}))
+		got, err = s.UserRoles.Get(ctx, alice.ID, admin.ID)
+		must(t, err)
+		if !got.ExpiryDate.Equal(expiry) {
+			t.Fatalf("expected expiry %v, got %v", expiry, got.ExpiryDate)
+		}
+
+		must(t, s.UserRoles.Update(ctx, &repositories.UserRole{UserID: alice.ID, RoleID: admin.ID}))
+		got, err = s.UserRoles.Get(ctx, alice.ID, admin.ID)
+		must(t, err)
+		if !got.ExpiryDate.IsZero() {
+			t.Fatalf("expected the role to never expire, got expiry %v", got.ExpiryDate)
+		}
+
+		all, err := s.UserRoles.GetAll(ctx)
+		must(t, err)
+		if len(all) != 1 {
+			t.Fatalf("expected 1 user role, got %d", len(all))
+		}
+
+		must(t, s.UserRoles.Delete(ctx, alice.ID, admin.ID))
+		_, err = s.UserRoles.Get(ctx, alice.ID, admin.ID)
+		expectError(t, err, "the user does not have this role")
+	})
+
+	t.Run("NotFound", func(t *testing.T) {
+		s := newStores(t)
+		ctx := context.Background()
+
+		_, err := s.UserRoles.Get(ctx, 404, 404)
+		expectError(t, err, "the user does not have this role")
+		expectError(t, s.UserRoles.Update(ctx, &repositories.UserRole{UserID: 404, RoleID: 404, ExpiryDate: time.Now()}),
+			"no rows were affected during the update")
+		expectError(t, s.UserRoles.Delete(ctx, 404, 404), "no rows were affected during the delete")
+	})
+
+	t.Run("RolesAndAccess", func(t *testing.T) {
+		s := newStores(t)
+		ctx := context.Background()
+
+		alice := &repositories.User{UserName: "alice", Mobile: "5550000001"}
+		must(t, s.Users.Create(ctx, alice))
+
+		_, err := s.UserRoles.GetAllAccess(ctx, alice.ID)
+		expectError(t, err, "no access found for the user")
+
+		admin := &repositories.Role{Name: "admin"}
+		must(t, s.Roles.Create(ctx, admin))
+		editor := &repositories.Role{Name: "editor"}
+		must(t, s.Roles.Create(ctx, editor))
+		read := &repositories.Access{Name: "read"}
+		must(t, s.Accesses.Create(ctx, read))
+		write := &repositories.Access{Name: "write"}
+		must(t, s.Accesses.Create(ctx, write))
+
+		for _, ra := range []*repositories.RoleAccess{
+			{RoleID: admin.ID, AccessID: read.ID},
+			{RoleID: admin.ID, AccessID: write.ID},
+			{RoleID: editor.ID, AccessID: write.ID},
+		} {
+			must(t, s.RoleAccesses.Create(ctx, ra))
+		}
+
+		expiry := time.Now().Add(24 * time.Hour).Truncate(time.Second)
+		must(t, s.UserRoles.Create(ctx, &repositories.UserRole{UserID: alice.ID, RoleID: admin.ID, ExpiryDate: expiry}))
+		must(t, s.UserRoles.Create(ctx, &repositories.UserRole{UserID: alice.ID, RoleID: editor.ID, ExpiryDate: expiry}))
+
+		roles, err := s.UserRoles.GetRolesForUser(ctx, alice.ID)
+		must(t, err)
+		if len(roles) != 2 {
+			t.Fatalf("expected 2 roles for alice, got %+v", roles)
+		}
+
+		// An access granted by several roles is listed once
+		accesses, err := s.UserRoles.GetAllAccess(ctx, alice.ID)
+		must(t, err)
+		if len(accesses) != 2 {
+			t.Fatalf("expected read and write for alice, got %+v", accesses)
+		}
+
+		// Roles in the trash grant nothing
+		must(t, s.Roles.Delete(ctx, admin.ID, admin.Version, 0))
+		roles, err = s.UserRoles.GetRolesForUser(ctx, alice.ID)
+		must(t, err)
+		if len(roles) != 1 || *roles[0] != *editor {
+			t.Fatalf("expected only editor for alice, got %+v", roles)
+		}
+
+		accesses, err = s.UserRoles.GetAllAccess(ctx, alice.ID)
+		must(t, err)
+		if len(accesses) != 1 || *accesses[0] != *write {
+			t.Fatalf("expected only write for alice, got %+v", accesses)
+		}
+	})
+	t.Run("Purge", func(t *testing.T) {
+		s := newStores(t)
+		ctx := context.Background()
+
+		alice := &repositories.User{UserName: "alice", Mobile: "5550000001"}
+		must(t, s.Users.Create(ctx, alice))
+		bob := &repositories.User{UserName: "bob", Mobile: "5550000002"}
+		must(t, s.Users.Create(ctx, bob))
+		admin := &repositories.Role{Name: "admin"}
+		must(t, s.Roles.Create(ctx, admin))
+		editor := &repositories.Role{Name: "editor"}
+		must(t, s.Roles.Create(ctx, editor))
+
+		must(t, s.UserRoles.Create(ctx, &repositories.UserRole{UserID: alice.ID, RoleID: editor.ID}))
+		must(t, s.UserRoles.Create(ctx, &repositories.UserRole{UserID: bob.ID, RoleID: admin.ID}))
+		must(t, s.UserRoles.Create(ctx, &repositories.UserRole{UserID: bob.ID, RoleID: editor.ID}))
+
+		// Purging a user or a role takes its assignments with it
+		must(t, s.Users.Delete(ctx, alice.ID, alice.Version, 0))
+		n, err := s.Users.Purge(ctx, time.Now().Add(time.Hour))
+		must(t, err)
+		if n != 1 {
+			t.Fatalf("expected 1 user purged, got %d", n)
+		}
+
+		must(t, s.Roles.Delete(ctx, admin.ID, admin.Version, 0))
+		n, err = s.Roles.Purge(ctx, time.Now().Add(time.Hour))
+		must(t, err)
+		if n != 1 {
+			t.Fatalf("expected 1 role purged, got %d", n)
+		}
+
+		all, err := s.UserRoles.GetAll(ctx)
+		must(t, err)
+		if len(all) != 1 || all[0].UserID != bob.ID || all[0].RoleID != editor.ID {
+			t.Fatalf("expected only bob as editor to be left, got %+v", all)
+		}
+	})
+}
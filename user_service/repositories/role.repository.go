@@ -1,73 +1,179 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"time"
 )
 
 type Role struct {
 	ID   int
 	Name string
+	// Version starts at 1 and is raised by every update. Updates and deletes must name the
+	// version they were read at, and fail with ErrVersionConflict once it has changed.
+	Version int
 }
 
-type RoleRepository struct {
-	db *sql.DB
+// RoleSortFields are the fields a page of roles can be sorted by.
+var RoleSortFields = []string{"id", "name"}
+
+// SortKey returns the position of the role in a list sorted by the given field.
+func (r *Role) SortKey(field string) SortKey {
+	if field == "name" {
+		return SortKey{Value: r.Name, ID: r.ID}
+	}
+
+	return SortKey{ID: r.ID}
+}
+
+// RoleFilter selects the roles listed on a page. The zero value selects every role.
+type RoleFilter struct {
+	// NamePrefix selects the roles whose name starts with it, ignoring case.
+	NamePrefix string
+}
+
+// DeletedRole is a soft-deleted Role together with its deletion details.
+type DeletedRole struct {
+	Role
+	DeletionInfo
 }
 
-func NewRoleRepository(db *sql.DB) *RoleRepository {
-	return &RoleRepository{db: db}
+// RoleRepository defines the storage of roles. Role names are unique among all roles, including
+// the ones in the trash.
+type RoleRepository interface {
+	Create(context.Context, *Role) error
+	Get(context.Context, int) (*Role, error)
+	Update(context.Context, *Role) error
+	Patch(ctx context.Context, role *Role, fields []string) error
+	Delete(ctx context.Context, id, version, deletedBy int) error
+	GetAll(ctx context.Context) ([]*Role, error)
+	GetPage(ctx context.Context, filter RoleFilter, page PageRequest) ([]*Role, *PageInfo, error)
+	GetDeleted(ctx context.Context) ([]*DeletedRole, error)
+	Restore(context.Context, int) error
+	Purge(ctx context.Context, before time.Time) (int64, error)
 }
 
-func (r *RoleRepository) Create(role *Role) error {
-	query := "INSERT INTO roles (role_name, created_date, updated_date) VALUES (?, NOW(), NOW())"
-	result, err := r.db.Exec(query, role.Name)
+// ErrRoleNameTaken is returned when a role is created or renamed with the name of another role.
+var ErrRoleNameTaken = errors.New("a role with this name already exists")
+
+type roleRepository struct {
+	db *DB
+}
+
+// NewRoleRepository creates a new RoleRepository using the provided database connection. Its
+// writes append their domain events to the outbox, see OutboxRepository.
+func NewRoleRepository(db *DB) RoleRepository {
+	return &eventRoleRepository{RoleRepository: &roleRepository{db: db}, db: db}
+}
+
+func (r *roleRepository) Create(ctx context.Context, role *Role) error {
+	query := `INSERT INTO roles (role_name, created_date, updated_date)
+		SELECT ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP ` + r.db.Dialect.FromDual() + `
+		WHERE NOT EXISTS (SELECT 1 FROM roles WHERE role_name = ?)`
+	id, err := r.db.Insert(ctx, "role_id", query, role.Name, role.Name)
 	if err != nil {
 		return err
 	}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return err
+	if id == 0 {
+		return ErrRoleNameTaken
 	}
 
-	role.ID = int(id)
+	role.ID = id
+	role.Version = 1
 
 	return nil
 }
 
-func (r *RoleRepository) Get(id int) (*Role, error) {
-	query := "SELECT role_id, role_name FROM roles WHERE role_id = ?"
-	row := r.db.QueryRow(query, id)
+func (r *roleRepository) Get(ctx context.Context, id int) (*Role, error) {
+	query := "SELECT role_id, role_name, version FROM roles WHERE role_id = ? AND deleted_date IS NULL"
+	row := r.db.QueryRow(ctx, query, id)
 	role := &Role{}
-	err := row.Scan(&role.ID, &role.Name)
+	err := row.Scan(&role.ID, &role.Name, &role.Version)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid role id")
+		}
 		return nil, err
 	}
 	return role, nil
 }
 
-func (r *RoleRepository) Update(role *Role) error {
-	query := "UPDATE roles SET role_name = ?, updated_date = NOW() WHERE role_id = ?"
-	_, err := r.db.Exec(query, role.Name, role.ID)
+func (r *roleRepository) Update(ctx context.Context, role *Role) error {
+	var count int
+	err := r.db.QueryRow(ctx, "SELECT COUNT(*) FROM roles WHERE role_name = ? AND role_id <> ?", role.Name, role.ID).Scan(&count)
 	if err != nil {
 		return err
 	}
 
+	if count > 0 {
+		return ErrRoleNameTaken
+	}
+
+	query := "UPDATE roles SET role_name = ?, updated_date = CURRENT_TIMESTAMP, version = version + 1 WHERE role_id = ? AND version = ? AND deleted_date IS NULL"
+	result, err := r.db.Exec(ctx, query, role.Name, role.ID, role.Version)
+	if err != nil {
+		return err
+	}
+
+	if err := r.db.versionedResult(ctx, result, "roles", "role_id", role.ID, "update"); err != nil {
+		return err
+	}
+
+	role.Version++
+
 	return nil
 }
 
-func (r *RoleRepository) Delete(id int) error {
-	query := "DELETE FROM roles WHERE role_id = ?"
-	_, err := r.db.Exec(query, id)
+// Patch writes the named fields of a role, as returned by ChangedRoleFields, at the version
+// it was read at, and raises its version.
+func (r *roleRepository) Patch(ctx context.Context, role *Role, fields []string) error {
+	set, args, err := setFields("role", fields, role.patchFields())
 	if err != nil {
 		return err
 	}
 
+	if hasField(fields, "name") {
+		var count int
+		err := r.db.QueryRow(ctx, "SELECT COUNT(*) FROM roles WHERE role_name = ? AND role_id <> ?", role.Name, role.ID).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return ErrRoleNameTaken
+		}
+	}
+
+	query := "UPDATE roles SET " + set + "updated_date = CURRENT_TIMESTAMP, version = version + 1 WHERE role_id = ? AND version = ? AND deleted_date IS NULL"
+	result, err := r.db.Exec(ctx, query, append(args, role.ID, role.Version)...)
+	if err != nil {
+		return err
+	}
+
+	if err := r.db.versionedResult(ctx, result, "roles", "role_id", role.ID, "update"); err != nil {
+		return err
+	}
+
+	role.Version++
+
 	return nil
 }
 
-func (r *RoleRepository) GetAll() ([]*Role, error) {
-	query := "SELECT role_id, role_name FROM roles"
-	rows, err := r.db.Query(query)
+func (r *roleRepository) Delete(ctx context.Context, id, version, deletedBy int) error {
+	query := "UPDATE roles SET deleted_date = CURRENT_TIMESTAMP, deleted_by = ? WHERE role_id = ? AND version = ? AND deleted_date IS NULL"
+	result, err := r.db.Exec(ctx, query, nullableID(deletedBy), id, version)
+	if err != nil {
+		return err
+	}
+
+	return r.db.versionedResult(ctx, result, "roles", "role_id", id, "delete")
+}
+
+func (r *roleRepository) GetAll(ctx context.Context) ([]*Role, error) {
+	query := "SELECT role_id, role_name, version FROM roles WHERE deleted_date IS NULL"
+	rows, err := r.db.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -78,7 +184,7 @@ func (r *RoleRepository) GetAll() ([]*Role, error) {
 
 	for rows.Next() {
 		role := &Role{}
-		err := rows.Scan(&role.ID, &role.Name)
+		err := rows.Scan(&role.ID, &role.Name, &role.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -92,18 +198,113 @@ func (r *RoleRepository) GetAll() ([]*Role, error) {
 	return roles, nil
 }
 
-func (r *RoleRepository) CreateTable() error {
-	query := `
-        CREATE TABLE IF NOT EXISTS roles (
-            role_id INT AUTO_INCREMENT PRIMARY KEY,
-            role_name VARCHAR(255) NOT NULL UNIQUE,
-			created_date DATETIME NOT NULL DEFAULT NOW(),
-			updated_date DATETIME NOT NULL DEFAULT NOW()
-			)`
-	_, err := r.db.Exec(query)
+// GetPage returns a page of the roles outside the trash selected by the filter.
+func (r *roleRepository) GetPage(ctx context.Context, filter RoleFilter, page PageRequest) ([]*Role, *PageInfo, error) {
+	if err := page.Validate(RoleSortFields...); err != nil {
+		return nil, nil, err
+	}
+
+	q := &pageQuery{
+		columns: "role_id, role_name, version",
+		from:    "FROM roles WHERE deleted_date IS NULL",
+		id:      "role_id",
+		sorts:   map[string]string{"id": "role_id", "name": "role_name"},
+	}
+
+	if filter.NamePrefix != "" {
+		q.from += " AND LOWER(role_name) LIKE ? ESCAPE '!'"
+		q.args = append(q.args, likePrefix(filter.NamePrefix))
+	}
+
+	roles := []*Role{}
+	info, err := q.run(ctx, r.db, page, func(rows *Rows) (SortKey, error) {
+		role := &Role{}
+		if err := rows.Scan(&role.ID, &role.Name, &role.Version); err != nil {
+			return SortKey{}, err
+		}
+		roles = append(roles, role)
+		return role.SortKey(page.Sort), nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return roles, info, nil
+}
+
+func (r *roleRepository) GetDeleted(ctx context.Context) ([]*DeletedRole, error) {
+	query := "SELECT role_id, role_name, version, deleted_date, deleted_by FROM roles WHERE deleted_date IS NOT NULL ORDER BY deleted_date DESC"
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	roles := []*DeletedRole{}
+
+	for rows.Next() {
+		role := &DeletedRole{}
+		var deletedBy sql.NullInt64
+		err := rows.Scan(&role.ID, &role.Name, &role.Version, &role.DeletedDate, &deletedBy)
+		if err != nil {
+			return nil, err
+		}
+		role.DeletedBy = int(deletedBy.Int64)
+		roles = append(roles, role)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return roles, nil
+}
+
+func (r *roleRepository) Restore(ctx context.Context, id int) error {
+	query := "UPDATE roles SET deleted_date = NULL, deleted_by = NULL, updated_date = CURRENT_TIMESTAMP WHERE role_id = ? AND deleted_date IS NOT NULL"
+	result, err := r.db.Exec(ctx, query, id)
 	if err != nil {
 		return err
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no deleted role found with the given id")
+	}
+
 	return nil
 }
+
+func (r *roleRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	// user_roles does not cascade, so the assignments go first; access_role does
+	query := `DELETE FROM user_roles WHERE role_id IN
+		(SELECT role_id FROM roles WHERE deleted_date IS NOT NULL AND deleted_date < ?)`
+	if _, err := tx.Exec(ctx, query, before); err != nil {
+		return 0, err
+	}
+
+	query = "DELETE FROM roles WHERE deleted_date IS NOT NULL AND deleted_date < ?"
+	result, err := tx.Exec(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
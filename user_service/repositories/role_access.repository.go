@@ -1,7 +1,9 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
+	"errors"
 )
 
 type RoleAccess struct {
@@ -9,59 +11,91 @@ type RoleAccess struct {
 	AccessID int
 }
 
-// RoleAccessRepository is a struct that handles all database operations related to RoleAccess
-type RoleAccessRepository struct {
-	db *sql.DB
+// ErrRoleAccessExists is returned when an access is granted to a role that already has it.
+var ErrRoleAccessExists = errors.New("the role already has this access")
+
+// RoleAccessRepository handles the storage of the accesses granted to roles. A role holds each
+// access at most once.
+type RoleAccessRepository interface {
+	Create(context.Context, *RoleAccess) error
+	Get(ctx context.Context, roleID, accessID int) (*RoleAccess, error)
+	Delete(ctx context.Context, roleID, accessID int) error
+	GetAll(ctx context.Context) ([]*RoleAccess, error)
+	GetAccessesForRole(ctx context.Context, roleID int) ([]*Access, error)
 }
 
-// NewRoleAccessRepository creates a new RoleAccessRepository with the given db instance
-func NewRoleAccessRepository(db *sql.DB) *RoleAccessRepository {
-	return &RoleAccessRepository{db}
+// roleAccessRepository is a struct that handles all database operations related to RoleAccess
+type roleAccessRepository struct {
+	db *DB
+}
+
+// NewRoleAccessRepository creates a new RoleAccessRepository with the given db instance. Its
+// writes append their domain events to the outbox, see OutboxRepository.
+func NewRoleAccessRepository(db *DB) RoleAccessRepository {
+	return &eventRoleAccessRepository{RoleAccessRepository: &roleAccessRepository{db}, db: db}
 }
 
 // Create creates a new role access object in the database
-func (r *RoleAccessRepository) Create(ra *RoleAccess) error {
-	query := "INSERT INTO access_role (role_id, access_id, created_date, updated_date) VALUES (?, ?, NOW(), NOW())"
-	result, err := r.db.Exec(query, ra.RoleID, ra.AccessID)
+func (r *roleAccessRepository) Create(ctx context.Context, ra *RoleAccess) error {
+	query := `INSERT INTO access_role (role_id, access_id, created_date, updated_date)
+		SELECT ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP ` + r.db.Dialect.FromDual() + `
+		WHERE NOT EXISTS (SELECT 1 FROM access_role WHERE role_id = ? AND access_id = ?)`
+	result, err := r.db.Exec(ctx, query, ra.RoleID, ra.AccessID, ra.RoleID, ra.AccessID)
 	if err != nil {
 		return err
 	}
 
-	_, err = result.LastInsertId()
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if rowsAffected == 0 {
+		return ErrRoleAccessExists
+	}
+
 	return nil
 }
 
 // Get retrieves a role access object with the given role ID and access ID from the database
-func (r *RoleAccessRepository) Get(roleID, accessID int) (*RoleAccess, error) {
+func (r *roleAccessRepository) Get(ctx context.Context, roleID, accessID int) (*RoleAccess, error) {
 	query := "SELECT role_id, access_id FROM access_role WHERE role_id = ? AND access_id = ?"
-	row := r.db.QueryRow(query, roleID, accessID)
+	row := r.db.QueryRow(ctx, query, roleID, accessID)
 	roleAccess := &RoleAccess{}
 	err := row.Scan(&roleAccess.RoleID, &roleAccess.AccessID)
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("the role does not have this access")
+		}
 		return nil, err
 	}
 	return roleAccess, nil
 }
 
 // Delete deletes a role access object with the given role ID and access ID from the database
-func (r *RoleAccessRepository) Delete(roleID, accessID int) error {
+func (r *roleAccessRepository) Delete(ctx context.Context, roleID, accessID int) error {
 	query := "DELETE FROM access_role WHERE role_id = ? AND access_id = ?"
-	_, err := r.db.Exec(query, roleID, accessID)
+	result, err := r.db.Exec(ctx, query, roleID, accessID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
 	return nil
 }
 
 // GetAll retrieves all role access objects from the database
-func (r *RoleAccessRepository) GetAll() ([]*RoleAccess, error) {
+func (r *roleAccessRepository) GetAll(ctx context.Context) ([]*RoleAccess, error) {
 	query := "SELECT role_id, access_id FROM access_role"
-	rows, err := r.db.Query(query)
+	rows, err := r.db.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -86,9 +120,10 @@ func (r *RoleAccessRepository) GetAll() ([]*RoleAccess, error) {
 	return roleAccesses, nil
 }
 
-func (r *RoleRepository) GetAccessesForRole(roleID int) ([]*Access, error) {
-	query := "SELECT a.access_id, a.access_name FROM access a INNER JOIN access_role ar ON a.access_id = ar.access_id WHERE ar.role_id = ?"
-	rows, err := r.db.Query(query, roleID)
+// GetAccessesForRole retrieves the access objects granted to a role, leaving out the ones in the trash
+func (r *roleAccessRepository) GetAccessesForRole(ctx context.Context, roleID int) ([]*Access, error) {
+	query := "SELECT a.access_id, a.access_name, a.version FROM access a INNER JOIN access_role ar ON a.access_id = ar.access_id WHERE ar.role_id = ? AND a.deleted_date IS NULL"
+	rows, err := r.db.Query(ctx, query, roleID)
 	if err != nil {
 		return nil, err
 	}
@@ -99,7 +134,7 @@ func (r *RoleRepository) GetAccessesForRole(roleID int) ([]*Access, error) {
 
 	for rows.Next() {
 		access := &Access{}
-		err := rows.Scan(&access.ID, &access.Name)
+		err := rows.Scan(&access.ID, &access.Name, &access.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -112,23 +147,3 @@ func (r *RoleRepository) GetAccessesForRole(roleID int) ([]*Access, error) {
 
 	return accesses, nil
 }
-
-func (r *RoleAccessRepository) CreateTable() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS access_role (
-		role_id INT NOT NULL,
-		access_id INT NOT NULL,
-		created_date DATETIME NOT NULL DEFAULT NOW(),
-		updated_date DATETIME NOT NULL DEFAULT NOW(),
-		PRIMARY KEY (role_id, access_id),
-		FOREIGN KEY (role_id) REFERENCES role(role_id) ON DELETE CASCADE,
-		FOREIGN KEY (access_id) REFERENCES access(access_id) ON DELETE CASCADE
-	)	
-`
-	_, err := r.db.Exec(query)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
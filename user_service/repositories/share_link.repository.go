@@ -0,0 +1,236 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+// Outcomes of an attempt to open a share link, as recorded in its access log.
+const (
+	ShareAccessGranted       = "granted"
+	ShareAccessExpired       = "expired"
+	ShareAccessRevoked       = "revoked"
+	ShareAccessViewLimit     = "view_limit"
+	ShareAccessWrongPassword = "wrong_password"
+	ShareAccessUnavailable   = "unavailable"
+)
+
+// ShareLink gives people without an account read access to a single contact until ExpiresDate.
+// UserID is the user who created it; the link only works while that user can read the contact.
+// MaxViews caps the number of successful views, zero meaning unlimited. PasswordHash is empty for
+// links without a password.
+type ShareLink struct {
+	ID           int
+	ContactID    int
+	UserID       int
+	PasswordHash string `json:"-"`
+	HasPassword  bool
+	ExpiresDate  time.Time
+	MaxViews     int
+	Views        int
+	RevokedDate  *time.Time
+	CreatedDate  time.Time
+}
+
+// ShareLinkAccess is an entry of the access log of a share link.
+type ShareLinkAccess struct {
+	ID           int
+	LinkID       int
+	Outcome      string
+	RemoteAddr   string
+	UserAgent    string
+	AccessedDate time.Time
+}
+
+type ShareLinkRepository interface {
+	Create(context.Context, *ShareLink) error
+	Get(context.Context, int) (*ShareLink, error)
+	GetAll(ctx context.Context, contactID int) ([]*ShareLink, error)
+	Revoke(context.Context, int) error
+	CountView(ctx context.Context, id int) (bool, error)
+	LogAccess(context.Context, *ShareLinkAccess) error
+	CountAccesses(ctx context.Context, linkID int, outcome string, since time.Time) (int, error)
+	GetAccessLog(ctx context.Context, linkID int) ([]*ShareLinkAccess, error)
+}
+
+const shareLinkColumns = `link_id, contact_id, user_id, password_hash, expires_date, max_views, views, revoked_date, created_date`
+
+type shareLinkRepository struct {
+	db *DB
+}
+
+// NewShareLinkRepository creates a new ShareLinkRepository using the provided database connection.
+func NewShareLinkRepository(db *DB) ShareLinkRepository {
+	return &shareLinkRepository{db: db}
+}
+
+// Create inserts a new share link and sets its ID and creation date.
+func (r *shareLinkRepository) Create(ctx context.Context, l *ShareLink) error {
+	query := `INSERT INTO share_links (contact_id, user_id, password_hash, expires_date, max_views, created_date)
+		VALUES (?, ?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	id, err := r.db.Insert(ctx, "link_id", query, l.ContactID, l.UserID, l.PasswordHash, l.ExpiresDate, l.MaxViews)
+	if err != nil {
+		return err
+	}
+
+	l.ID = id
+	l.HasPassword = l.PasswordHash != ""
+	l.CreatedDate = time.Now()
+
+	return nil
+}
+
+// Get retrieves a share link by ID.
+func (r *shareLinkRepository) Get(ctx context.Context, id int) (*ShareLink, error) {
+	query := "SELECT " + shareLinkColumns + " FROM share_links WHERE link_id = ?"
+	l, err := scanShareLink(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid share link id")
+		}
+		return nil, err
+	}
+	return l, nil
+}
+
+// GetAll retrieves the share links of a contact, newest first.
+func (r *shareLinkRepository) GetAll(ctx context.Context, contactID int) ([]*ShareLink, error) {
+	query := "SELECT " + shareLinkColumns + " FROM share_links WHERE contact_id = ? ORDER BY link_id DESC"
+	rows, err := r.db.Query(ctx, query, contactID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	links := []*ShareLink{}
+
+	for rows.Next() {
+		l, err := scanShareLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, l)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return links, nil
+}
+
+// Revoke disables a share link for good. Revoking a link twice is an error.
+func (r *shareLinkRepository) Revoke(ctx context.Context, id int) error {
+	query := "UPDATE share_links SET revoked_date = CURRENT_TIMESTAMP WHERE link_id = ? AND revoked_date IS NULL"
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return nil
+}
+
+// CountView counts a view of a share link and reports whether it was within the view cap. The
+// check and the increment are a single statement so that concurrent views cannot exceed the cap.
+func (r *shareLinkRepository) CountView(ctx context.Context, id int) (bool, error) {
+	query := "UPDATE share_links SET views = views + 1 WHERE link_id = ? AND (max_views = 0 OR views < max_views)"
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// LogAccess appends an entry to the access log of a share link.
+func (r *shareLinkRepository) LogAccess(ctx context.Context, a *ShareLinkAccess) error {
+	query := `INSERT INTO share_link_accesses (link_id, outcome, remote_addr, user_agent, accessed_date)
+		VALUES (?, ?, ?, ?, CURRENT_TIMESTAMP)`
+	id, err := r.db.Insert(ctx, "access_id", query, a.LinkID, a.Outcome, truncate(a.RemoteAddr, 64), truncate(a.UserAgent, 255))
+	if err != nil {
+		return err
+	}
+
+	a.ID = id
+	a.AccessedDate = time.Now()
+
+	return nil
+}
+
+// CountAccesses counts the attempts to open a share link with the given outcome since a point in time.
+func (r *shareLinkRepository) CountAccesses(ctx context.Context, linkID int, outcome string, since time.Time) (int, error) {
+	var count int
+	query := "SELECT COUNT(*) FROM share_link_accesses WHERE link_id = ? AND outcome = ? AND accessed_date >= ?"
+	err := r.db.QueryRow(ctx, query, linkID, outcome, since).Scan(&count)
+	return count, err
+}
+
+// GetAccessLog retrieves the access log of a share link, newest first.
+func (r *shareLinkRepository) GetAccessLog(ctx context.Context, linkID int) ([]*ShareLinkAccess, error) {
+	query := `SELECT access_id, link_id, outcome, remote_addr, user_agent, accessed_date
+		FROM share_link_accesses WHERE link_id = ? ORDER BY access_id DESC`
+	rows, err := r.db.Query(ctx, query, linkID)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	log := []*ShareLinkAccess{}
+
+	for rows.Next() {
+		a := &ShareLinkAccess{}
+		if err := rows.Scan(&a.ID, &a.LinkID, &a.Outcome, &a.RemoteAddr, &a.UserAgent, &a.AccessedDate); err != nil {
+			return nil, err
+		}
+		log = append(log, a)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return log, nil
+}
+
+// truncate shortens s to at most n bytes without splitting a UTF-8 sequence.
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && s[n]&0xC0 == 0x80 {
+		n--
+	}
+	return s[:n]
+}
+
+func scanShareLink(row rowScanner) (*ShareLink, error) {
+	l := &ShareLink{}
+	var revoked sql.NullTime
+	err := row.Scan(&l.ID, &l.ContactID, &l.UserID, &l.PasswordHash, &l.ExpiresDate, &l.MaxViews, &l.Views, &revoked, &l.CreatedDate)
+	if err != nil {
+		return nil, err
+	}
+	l.HasPassword = l.PasswordHash != ""
+	if revoked.Valid {
+		l.RevokedDate = &revoked.Time
+	}
+	return l, nil
+}
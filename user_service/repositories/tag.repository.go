@@ -0,0 +1,236 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ErrTagNotFound is returned by GetByName when the user has no tag of that name.
+var ErrTagNotFound = errors.New("invalid tag name")
+
+type Tag struct {
+	ID           int
+	UserID       int
+	Name         string
+	ContactCount int
+}
+
+// TagSortFields are the fields a page of tags can be sorted by.
+var TagSortFields = []string{"id", "name"}
+
+// SortKey returns the position of the tag in a list sorted by the given field.
+func (t *Tag) SortKey(field string) SortKey {
+	if field == "name" {
+		return SortKey{Value: t.Name, ID: t.ID}
+	}
+
+	return SortKey{ID: t.ID}
+}
+
+type TagRepository interface {
+	Create(context.Context, *Tag) error
+	Get(context.Context, int) (*Tag, error)
+	GetByName(ctx context.Context, userID int, name string) (*Tag, error)
+	Update(context.Context, *Tag) error
+	Delete(context.Context, int) error
+	GetAll(ctx context.Context, userID int) ([]*Tag, error)
+	AddContacts(ctx context.Context, tagID int, contactIDs []int) error
+	RemoveContacts(ctx context.Context, tagID int, contactIDs []int) error
+	GetTagsForContact(ctx context.Context, contactID int) ([]*Tag, error)
+}
+
+type tagRepository struct {
+	db *DB
+}
+
+// NewTagRepository creates a new TagRepository using the provided database connection.
+func NewTagRepository(db *DB) TagRepository {
+	return &tagRepository{db: db}
+}
+
+// normalizeTagNames lower-cases and trims tag names and drops empty and duplicate entries.
+func normalizeTagNames(names []string) []string {
+	seen := map[string]bool{}
+	tags := []string{}
+	for _, name := range names {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		tags = append(tags, name)
+	}
+
+	return tags
+}
+
+// Create inserts a new tag into the database and sets its ID. Tag names are stored lower-cased.
+func (r *tagRepository) Create(ctx context.Context, t *Tag) error {
+	t.Name = strings.ToLower(strings.TrimSpace(t.Name))
+	query := "INSERT INTO tags (user_id, tag_name, created_date, updated_date) VALUES (?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP)"
+	id, err := r.db.Insert(ctx, "tag_id", query, t.UserID, t.Name)
+	if err != nil {
+		return err
+	}
+
+	t.ID = id
+
+	return nil
+}
+
+// Get retrieves a tag together with its usage count by ID.
+func (r *tagRepository) Get(ctx context.Context, id int) (*Tag, error) {
+	query := `SELECT t.tag_id, t.user_id, t.tag_name, COUNT(ct.contact_id)
+		FROM tags t
+		LEFT JOIN (contact_tags ct JOIN contacts c ON ct.contact_id = c.contact_id AND c.deleted_date IS NULL) ON t.tag_id = ct.tag_id
+		WHERE t.tag_id = ?
+		GROUP BY t.tag_id, t.user_id, t.tag_name`
+	return r.queryRow(ctx, errors.New("invalid tag id"), query, id)
+}
+
+// GetByName retrieves a tag of a user by its name.
+func (r *tagRepository) GetByName(ctx context.Context, userID int, name string) (*Tag, error) {
+	query := `SELECT t.tag_id, t.user_id, t.tag_name, COUNT(ct.contact_id)
+		FROM tags t
+		LEFT JOIN (contact_tags ct JOIN contacts c ON ct.contact_id = c.contact_id AND c.deleted_date IS NULL) ON t.tag_id = ct.tag_id
+		WHERE t.user_id = ? AND t.tag_name = ?
+		GROUP BY t.tag_id, t.user_id, t.tag_name`
+	return r.queryRow(ctx, ErrTagNotFound, query, userID, strings.ToLower(strings.TrimSpace(name)))
+}
+
+// Update renames an existing tag.
+func (r *tagRepository) Update(ctx context.Context, t *Tag) error {
+	t.Name = strings.ToLower(strings.TrimSpace(t.Name))
+	query := "UPDATE tags SET tag_name = ?, updated_date = CURRENT_TIMESTAMP WHERE tag_id = ?"
+	result, err := r.db.Exec(ctx, query, t.Name, t.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return nil
+}
+
+// Delete removes a tag by ID. The tag is removed from all contacts carrying it.
+func (r *tagRepository) Delete(ctx context.Context, id int) error {
+	query := "DELETE FROM tags WHERE tag_id = ?"
+	result, err := r.db.Exec(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return nil
+}
+
+// GetAll retrieves all tags of a user together with their usage counts.
+func (r *tagRepository) GetAll(ctx context.Context, userID int) ([]*Tag, error) {
+	query := `SELECT t.tag_id, t.user_id, t.tag_name, COUNT(ct.contact_id)
+		FROM tags t
+		LEFT JOIN (contact_tags ct JOIN contacts c ON ct.contact_id = c.contact_id AND c.deleted_date IS NULL) ON t.tag_id = ct.tag_id
+		WHERE t.user_id = ?
+		GROUP BY t.tag_id, t.user_id, t.tag_name
+		ORDER BY t.tag_name`
+	return r.query(ctx, query, userID)
+}
+
+// AddContacts attaches a tag to the given contacts. Contacts already carrying the tag are ignored.
+func (r *tagRepository) AddContacts(ctx context.Context, tagID int, contactIDs []int) error {
+	if len(contactIDs) == 0 {
+		return nil
+	}
+
+	query := "INSERT INTO contact_tags (tag_id, contact_id, created_date) VALUES "
+	args := make([]interface{}, 0, len(contactIDs)*2)
+	for i, contactID := range contactIDs {
+		if i > 0 {
+			query += ", "
+		}
+		query += "(?, ?, CURRENT_TIMESTAMP)"
+		args = append(args, tagID, contactID)
+	}
+
+	_, err := r.db.Exec(ctx, r.db.Dialect.InsertIgnore(query), args...)
+	return err
+}
+
+// RemoveContacts detaches a tag from the given contacts.
+func (r *tagRepository) RemoveContacts(ctx context.Context, tagID int, contactIDs []int) error {
+	if len(contactIDs) == 0 {
+		return nil
+	}
+
+	query := fmt.Sprintf("DELETE FROM contact_tags WHERE tag_id = ? AND contact_id IN (%s)", placeholders(len(contactIDs)))
+	args := append([]interface{}{tagID}, intArgs(contactIDs)...)
+	_, err := r.db.Exec(ctx, query, args...)
+	return err
+}
+
+// GetTagsForContact retrieves all tags attached to a contact.
+func (r *tagRepository) GetTagsForContact(ctx context.Context, contactID int) ([]*Tag, error) {
+	query := `SELECT t.tag_id, t.user_id, t.tag_name, COUNT(all_ct.contact_id)
+		FROM tags t
+		JOIN contact_tags ct ON t.tag_id = ct.tag_id AND ct.contact_id = ?
+		LEFT JOIN (contact_tags all_ct JOIN contacts c ON all_ct.contact_id = c.contact_id AND c.deleted_date IS NULL) ON t.tag_id = all_ct.tag_id
+		GROUP BY t.tag_id, t.user_id, t.tag_name
+		ORDER BY t.tag_name`
+	return r.query(ctx, query, contactID)
+}
+
+func (r *tagRepository) queryRow(ctx context.Context, notFound error, query string, args ...interface{}) (*Tag, error) {
+	row := r.db.QueryRow(ctx, query, args...)
+	t := &Tag{}
+	err := row.Scan(&t.ID, &t.UserID, &t.Name, &t.ContactCount)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, notFound
+		}
+		return nil, err
+	}
+	return t, nil
+}
+
+func (r *tagRepository) query(ctx context.Context, query string, args ...interface{}) ([]*Tag, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	tags := []*Tag{}
+
+	for rows.Next() {
+		t := &Tag{}
+		err := rows.Scan(&t.ID, &t.UserID, &t.Name, &t.ContactCount)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
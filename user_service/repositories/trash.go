@@ -0,0 +1,10 @@
+package repositories
+
+import "time"
+
+// DeletionInfo records when and by whom a soft-deleted row was deleted. DeletedBy is zero
+// when the deleting user is unknown.
+type DeletionInfo struct {
+	DeletedDate time.Time
+	DeletedBy   int
+}
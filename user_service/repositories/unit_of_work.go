@@ -0,0 +1,136 @@
+package repositories
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+// UnitOfWorkAttempts is how many times a unit of work runs before the deadlock or serialization
+// failure that keeps aborting it is returned.
+const UnitOfWorkAttempts = 3
+
+// unitOfWorkRetryDelay is the longest wait before the second attempt; later attempts wait up to
+// that many times longer. The wait is random so that the transactions that deadlocked each other
+// do not meet again.
+const unitOfWorkRetryDelay = 50 * time.Millisecond
+
+// UnitOfWork runs several repository operations in one transaction, so that they are stored
+// together or not at all.
+type UnitOfWork interface {
+	// Do runs fn with repositories whose queries run in a new transaction. The transaction is
+	// committed when fn returns nil and rolled back when it returns an error or panics, or when
+	// ctx is cancelled. When the database aborts it for a deadlock or a serialization failure, fn
+	// runs again in a new transaction, up to UnitOfWorkAttempts times, so fn must not have other
+	// side effects. fn passes ctx on to the repositories.
+	Do(ctx context.Context, fn func(repos *Repositories) error) error
+}
+
+// Repositories are the repositories of a unit of work. Decorators, such as the authorized contact
+// repository, wrap them like the repositories of the pool.
+type Repositories struct {
+	Accesses       AccessRepository
+	Activities     ActivityRepository
+	Addresses      AddressRepository
+	AddressBooks   AddressBookRepository
+	Attachments    AttachmentRepository
+	BulkJobs       BulkJobRepository
+	CardDAV        CardDAVRepository
+	Contacts       ContactRepository
+	ContactHistory ContactHistoryRepository
+	CustomFields   CustomFieldRepository
+	Groups         GroupRepository
+	Organizations  OrganizationRepository
+	Outbox         OutboxRepository
+	QuickAccess    QuickAccessRepository
+	Relationships  RelationshipRepository
+	Reminders      ReminderRepository
+	Roles          RoleRepository
+	RoleAccesses   RoleAccessRepository
+	ShareLinks     ShareLinkRepository
+	Tags           TagRepository
+	Users          UserRepository
+	UserRoles      UserRoleRepository
+	Webhooks       WebhookRepository
+
+	db *DB
+}
+
+func newRepositories(db *DB) *Repositories {
+	return &Repositories{
+		Accesses:       NewAccessRepository(db),
+		Activities:     NewActivityRepository(db),
+		Addresses:      NewAddressRepository(db),
+		AddressBooks:   NewAddressBookRepository(db),
+		Attachments:    NewAttachmentRepository(db),
+		BulkJobs:       NewBulkJobRepository(db),
+		CardDAV:        NewCardDAVRepository(db),
+		Contacts:       NewContactRepository(db),
+		ContactHistory: NewContactHistoryRepository(db),
+		CustomFields:   NewCustomFieldRepository(db),
+		Groups:         NewGroupRepository(db),
+		Organizations:  NewOrganizationRepository(db),
+		Outbox:         NewOutboxRepository(db),
+		QuickAccess:    NewQuickAccessRepository(db),
+		Relationships:  NewRelationshipRepository(db),
+		Reminders:      NewReminderRepository(db),
+		Roles:          NewRoleRepository(db),
+		RoleAccesses:   NewRoleAccessRepository(db),
+		ShareLinks:     NewShareLinkRepository(db),
+		Tags:           NewTagRepository(db),
+		Users:          NewUserRepository(db),
+		UserRoles:      NewUserRoleRepository(db),
+		Webhooks:       NewWebhookRepository(db),
+		db:             db,
+	}
+}
+
+// Do runs fn in a savepoint of the transaction: when fn returns an error, what it stored is rolled
+// back and the rest of the unit of work carries on if the error is handled. PostgreSQL aborts the
+// whole transaction when a statement fails, so an operation that is expected to fail, e.g. on a
+// duplicate key, must run in a nested Do there. A deadlock aborts the whole transaction on every
+// database; its error must be returned for the outermost Do to retry.
+func (r *Repositories) Do(ctx context.Context, fn func(repos *Repositories) error) error {
+	return runInTx(ctx, r.db, fn)
+}
+
+type unitOfWork struct {
+	db *DB
+}
+
+// NewUnitOfWork returns a UnitOfWork running its transactions on the connection pool of db.
+func NewUnitOfWork(db *DB) UnitOfWork {
+	return &unitOfWork{db: db}
+}
+
+func (w *unitOfWork) Do(ctx context.Context, fn func(repos *Repositories) error) error {
+	for attempt := 1; ; attempt++ {
+		err := runInTx(ctx, w.db, fn)
+		if err == nil || attempt == UnitOfWorkAttempts || !w.db.Dialect.Retryable(err) {
+			return err
+		}
+
+		select {
+		case <-time.After(time.Duration(rand.Int63n(int64(attempt) * int64(unitOfWorkRetryDelay)))):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// runInTx runs fn once with repositories bound to a transaction begun on db, nested when db runs
+// its queries in one already, and commits it when fn succeeds. The deferred rollback undoes it
+// when fn fails or panics.
+func runInTx(ctx context.Context, db *DB, fn func(repos *Repositories) error) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := fn(newRepositories(db.in(tx))); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
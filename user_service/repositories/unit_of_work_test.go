@@ -0,0 +1,217 @@
+package repositories_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/lib/pq"
+	"github.com/mattn/go-sqlite3"
+
+	"github.com/princeparmar/contact_manager/migrations"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// retryableErrors are deadlock errors as the driver of each dialect reports them.
+var retryableErrors = map[string]error{
+	"mysql":    &mysql.MySQLError{Number: 1213, Message: "Deadlock found when trying to get lock"},
+	"postgres": &pq.Error{Code: "40P01", Message: "deadlock detected"},
+	"sqlite":   sqlite3.Error{Code: sqlite3.ErrBusy},
+}
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		dialect repositories.Dialect
+		err     error
+		want    bool
+	}{
+		{repositories.MySQL, &mysql.MySQLError{Number: 1213}, true},
+		{repositories.MySQL, &mysql.MySQLError{Number: 1205}, true},
+		{repositories.MySQL, &mysql.MySQLError{Number: 1062}, false},
+		{repositories.MySQL, errors.New("Deadlock found when trying to get lock"), false},
+		{repositories.PostgreSQL, &pq.Error{Code: "40001"}, true},
+		{repositories.PostgreSQL, &pq.Error{Code: "40P01"}, true},
+		{repositories.PostgreSQL, &pq.Error{Code: "23505"}, false},
+		{repositories.SQLite, sqlite3.Error{Code: sqlite3.ErrBusy}, true},
+		{repositories.SQLite, sqlite3.Error{Code: sqlite3.ErrLocked}, true},
+		{repositories.SQLite, sqlite3.Error{Code: sqlite3.ErrConstraint}, false},
+		{repositories.SQLite, errors.New("database is locked"), false},
+		{repositories.SQLite, nil, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.dialect.Retryable(tt.err); got != tt.want {
+			t.Errorf("%s: Retryable(%#v) = %v, want %v", tt.dialect.Name(), tt.err, got, tt.want)
+		}
+	}
+
+	if !repositories.SQLite.Retryable(fmt.Errorf("insert: %w", sqlite3.Error{Code: sqlite3.ErrBusy})) {
+		t.Error("sqlite: wrapped SQLITE_BUSY is not retryable")
+	}
+}
+
+// testUnitOfWork checks that units of work commit and roll back as a whole, that nested ones
+// roll back on their own, and that deadlocks are retried. db is migrated from scratch.
+func testUnitOfWork(t *testing.T, db *repositories.DB) {
+	ctx := context.Background()
+	runner, err := migrations.NewRunner(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Down(len(runner.Migrations)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	users := repositories.NewUserRepository(db)
+	work := repositories.NewUnitOfWork(db)
+	errFailed := errors.New("failed")
+	n := 0
+	newUser := func() *repositories.User {
+		n++
+		return &repositories.User{UserName: fmt.Sprintf("user%d", n), Mobile: fmt.Sprintf("55500%02d", n), EmailID: fmt.Sprintf("user%d@example.com", n)}
+	}
+	// users are looked up by name, the IDs of rolled back inserts are given out again
+	expectStored := func(t *testing.T, user *repositories.User, stored bool) {
+		t.Helper()
+		all, err := users.GetAll(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		found := false
+		for _, u := range all {
+			found = found || u.UserName == user.UserName
+		}
+		if found != stored {
+			t.Errorf("user %s stored: %v, want %v", user.UserName, found, stored)
+		}
+	}
+
+	t.Run("Commit", func(t *testing.T) {
+		user := newUser()
+		err := work.Do(ctx, func(repos *repositories.Repositories) error {
+			return repos.Users.Create(ctx, user)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectStored(t, user, true)
+	})
+
+	t.Run("RollbackOnError", func(t *testing.T) {
+		user := newUser()
+		err := work.Do(ctx, func(repos *repositories.Repositories) error {
+			if err := repos.Users.Create(ctx, user); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		if !errors.Is(err, errFailed) {
+			t.Fatalf("got %v, want %v", err, errFailed)
+		}
+		expectStored(t, user, false)
+	})
+
+	t.Run("NestedRollback", func(t *testing.T) {
+		outer, inner := newUser(), newUser()
+		err := work.Do(ctx, func(repos *repositories.Repositories) error {
+			if err := repos.Users.Create(ctx, outer); err != nil {
+				return err
+			}
+			err := repos.Do(ctx, func(repos *repositories.Repositories) error {
+				if err := repos.Users.Create(ctx, inner); err != nil {
+					return err
+				}
+				return errFailed
+			})
+			if !errors.Is(err, errFailed) {
+				t.Errorf("nested: got %v, want %v", err, errFailed)
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		expectStored(t, outer, true)
+		expectStored(t, inner, false)
+	})
+
+	t.Run("NestedCommitRolledBackWithOuter", func(t *testing.T) {
+		outer, inner := newUser(), newUser()
+		err := work.Do(ctx, func(repos *repositories.Repositories) error {
+			if err := repos.Users.Create(ctx, outer); err != nil {
+				return err
+			}
+			if err := repos.Do(ctx, func(repos *repositories.Repositories) error {
+				return repos.Users.Create(ctx, inner)
+			}); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		if !errors.Is(err, errFailed) {
+			t.Fatalf("got %v, want %v", err, errFailed)
+		}
+		expectStored(t, outer, false)
+		expectStored(t, inner, false)
+	})
+
+	t.Run("RetryDeadlock", func(t *testing.T) {
+		deadlock := retryableErrors[db.Dialect.Name()]
+		first := newUser()
+		var last *repositories.User
+		attempts := 0
+		err := work.Do(ctx, func(repos *repositories.Repositories) error {
+			attempts++
+			last = first
+			if attempts > 1 {
+				last = newUser()
+			}
+			if err := repos.Users.Create(ctx, last); err != nil {
+				return err
+			}
+			if attempts == 1 {
+				return deadlock
+			}
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if attempts != 2 {
+			t.Errorf("ran %d times, want 2", attempts)
+		}
+		expectStored(t, first, false)
+		expectStored(t, last, true)
+	})
+
+	t.Run("RetryGivesUp", func(t *testing.T) {
+		deadlock := retryableErrors[db.Dialect.Name()]
+		attempts := 0
+		err := work.Do(ctx, func(repos *repositories.Repositories) error {
+			attempts++
+			return deadlock
+		})
+		if !errors.Is(err, deadlock) {
+			t.Errorf("got %v, want %v", err, deadlock)
+		}
+		if attempts != repositories.UnitOfWorkAttempts {
+			t.Errorf("ran %d times, want %d", attempts, repositories.UnitOfWorkAttempts)
+		}
+	})
+
+	t.Run("NoRetryOnOtherErrors", func(t *testing.T) {
+		attempts := 0
+		err := work.Do(ctx, func(repos *repositories.Repositories) error {
+			attempts++
+			return errFailed
+		})
+		if !errors.Is(err, errFailed) || attempts != 1 {
+			t.Errorf("got %v after %d attempts, want %v after 1", err, attempts, errFailed)
+		}
+	})
+}
@@ -1,8 +1,10 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"time"
 )
 
 type User struct {
@@ -10,54 +12,113 @@ type User struct {
 	UserName string
 	Mobile   string
 	EmailID  string
+	// Version starts at 1 and is raised by every update. Updates and deletes must name the
+	// version they were read at, and fail with ErrVersionConflict once it has changed.
+	Version int
 }
 
-// UserRepository defines a struct for User data storage and retrieval.
-type UserRepository struct {
-	db *sql.DB
+// UserSortFields are the fields a page of users can be sorted by.
+var UserSortFields = []string{"id", "user_name", "email_id"}
+
+// SortKey returns the position of the user in a list sorted by the given field.
+func (u *User) SortKey(field string) SortKey {
+	switch field {
+	case "user_name":
+		return SortKey{Value: u.UserName, ID: u.ID}
+	case "email_id":
+		return SortKey{Value: u.EmailID, ID: u.ID}
+	}
+
+	return SortKey{ID: u.ID}
+}
+
+// UserFilter selects the users listed on a page. The zero value selects every user.
+type UserFilter struct {
+	// EmailDomain selects the users whose email address is at the domain, ignoring case.
+	EmailDomain string
+	// NamePrefix selects the users whose name starts with it, ignoring case.
+	NamePrefix string
+}
+
+// DeletedUser is a soft-deleted User together with its deletion details.
+type DeletedUser struct {
+	User
+	DeletionInfo
 }
 
-// NewUserRepository creates a new UserRepository instance using the provided database connection.
-func NewUserRepository(db *sql.DB) *UserRepository {
-	return &UserRepository{db: db}
+// UserRepository defines the storage of User records. User names are unique among all users,
+// including the ones in the trash.
+type UserRepository interface {
+	Create(context.Context, *User) error
+	Get(context.Context, int) (*User, error)
+	GetAll(ctx context.Context) ([]*User, error)
+	GetPage(ctx context.Context, filter UserFilter, page PageRequest) ([]*User, *PageInfo, error)
+	Update(context.Context, *User) error
+	Patch(ctx context.Context, user *User, fields []string) error
+	Delete(ctx context.Context, id, version, deletedBy int) error
+	List(ctx context.Context) ([]*User, error)
+	GetPassword(context.Context, int) (string, error)
+	GetUserByUserName(context.Context, string) (*User, error)
+	UpdatePassword(ctx context.Context, userID int, password string) error
+	GetDeleted(ctx context.Context) ([]*DeletedUser, error)
+	Restore(context.Context, int) error
+	Purge(ctx context.Context, before time.Time) (int64, error)
 }
 
-// Create inserts a new User record into the database.
-func (r *UserRepository) Create(user *User) error {
-	query := "INSERT INTO users (user_name, mobile, created_date, updated_date, email_id) VALUES (?, ?, NOW(), NOW(), ?)"
-	result, err := r.db.Exec(query, user.UserName, user.Mobile, user.EmailID)
+// ErrUserNameTaken is returned when a user is created or renamed with the name of another user.
+var ErrUserNameTaken = errors.New("a user with this name already exists")
+
+// userRepository implements UserRepository on a SQL database.
+type userRepository struct {
+	db *DB
+}
+
+// NewUserRepository creates a new UserRepository using the provided database connection. Its
+// writes append their domain events to the outbox, see OutboxRepository.
+func NewUserRepository(db *DB) UserRepository {
+	return &eventUserRepository{UserRepository: &userRepository{db: db}, db: db}
+}
+
+// Create inserts a new User record into the database. The user has no password until one is set
+// with UpdatePassword.
+func (r *userRepository) Create(ctx context.Context, user *User) error {
+	query := `INSERT INTO users (user_name, mobile, password, created_date, updated_date, email_id)
+		SELECT ?, ?, '', CURRENT_TIMESTAMP, CURRENT_TIMESTAMP, ? ` + r.db.Dialect.FromDual() + `
+		WHERE NOT EXISTS (SELECT 1 FROM users WHERE user_name = ?)`
+	id, err := r.db.Insert(ctx, "user_id", query, user.UserName, user.Mobile, user.EmailID, user.UserName)
 	if err != nil {
 		return err
 	}
 
-	id, err := result.LastInsertId()
-	if err != nil {
-		return err
+	if id == 0 {
+		return ErrUserNameTaken
 	}
 
-	user.ID = int(id)
+	user.ID = id
+	user.Version = 1
 
 	return nil
 }
 
 // Get retrieves a User record from the database by ID.
-func (r *UserRepository) Get(id int) (*User, error) {
-	query := "SELECT user_id, user_name, mobile, email_id FROM users WHERE user_id = ?"
-	row := r.db.QueryRow(query, id)
+func (r *userRepository) Get(ctx context.Context, id int) (*User, error) {
+	query := "SELECT user_id, user_name, mobile, email_id, version FROM users WHERE user_id = ? AND deleted_date IS NULL"
+	row := r.db.QueryRow(ctx, query, id)
 	user := &User{}
-	err := row.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID)
+	err := row.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID, &user.Version)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
-			return nil, nil
+			return nil, errors.New("invalid user id")
 		}
 		return nil, err
 	}
 	return user, nil
 }
 
-func (r *UserRepository) GetAll() ([]*User, error) {
-	query := "SELECT user_id, user_name, email_id, mobile FROM user"
-	rows, err := r.db.Query(query)
+// GetAll retrieves all User records from the database.
+func (r *userRepository) GetAll(ctx context.Context) ([]*User, error) {
+	query := "SELECT user_id, user_name, email_id, mobile, version FROM users WHERE deleted_date IS NULL"
+	rows, err := r.db.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -68,7 +129,7 @@ func (r *UserRepository) GetAll() ([]*User, error) {
 
 	for rows.Next() {
 		user := &User{}
-		err := rows.Scan(&user.ID, &user.UserName, &user.EmailID, &user.Mobile)
+		err := rows.Scan(&user.ID, &user.UserName, &user.EmailID, &user.Mobile, &user.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -82,50 +143,124 @@ func (r *UserRepository) GetAll() ([]*User, error) {
 	return users, nil
 }
 
-// Update updates an existing User record in the database.
-func (r *UserRepository) Update(user *User) error {
-	query := "UPDATE users SET user_name = ?, mobile = ?, updated_date = NOW(), email_id = ? WHERE user_id = ?"
-	result, err := r.db.Exec(query, user.UserName, user.Mobile, user.EmailID, user.ID)
+// GetPage returns a page of the users outside the trash selected by the filter.
+func (r *userRepository) GetPage(ctx context.Context, filter UserFilter, page PageRequest) ([]*User, *PageInfo, error) {
+	if err := page.Validate(UserSortFields...); err != nil {
+		return nil, nil, err
+	}
+
+	q := &pageQuery{
+		columns: "user_id, user_name, mobile, email_id, version",
+		from:    "FROM users WHERE deleted_date IS NULL",
+		id:      "user_id",
+		sorts:   map[string]string{"id": "user_id", "user_name": "user_name", "email_id": "email_id"},
+	}
+
+	if filter.EmailDomain != "" {
+		q.from += " AND LOWER(email_id) LIKE ? ESCAPE '!'"
+		q.args = append(q.args, likeSuffix("@"+filter.EmailDomain))
+	}
+
+	if filter.NamePrefix != "" {
+		q.from += " AND LOWER(user_name) LIKE ? ESCAPE '!'"
+		q.args = append(q.args, likePrefix(filter.NamePrefix))
+	}
+
+	users := []*User{}
+	info, err := q.run(ctx, r.db, page, func(rows *Rows) (SortKey, error) {
+		user := &User{}
+		if err := rows.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID, &user.Version); err != nil {
+			return SortKey{}, err
+		}
+		users = append(users, user)
+		return user.SortKey(page.Sort), nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return users, info, nil
+}
+
+// Update updates an existing User record in the database at the version it was read at, and
+// raises its version.
+func (r *userRepository) Update(ctx context.Context, user *User) error {
+	var count int
+	err := r.db.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE user_name = ? AND user_id <> ?", user.UserName, user.ID).Scan(&count)
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	if count > 0 {
+		return ErrUserNameTaken
+	}
+
+	query := "UPDATE users SET user_name = ?, mobile = ?, updated_date = CURRENT_TIMESTAMP, email_id = ?, version = version + 1 WHERE user_id = ? AND version = ? AND deleted_date IS NULL"
+	result, err := r.db.Exec(ctx, query, user.UserName, user.Mobile, user.EmailID, user.ID, user.Version)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return errors.New("no rows were affected during the update")
+	if err := r.db.versionedResult(ctx, result, "users", "user_id", user.ID, "update"); err != nil {
+		return err
 	}
 
+	user.Version++
+
 	return nil
 }
 
-// Delete removes a User record from the database by ID.
-func (r *UserRepository) Delete(id int) error {
-	query := "DELETE FROM users WHERE user_id = ?"
-	result, err := r.db.Exec(query, id)
+// Patch writes the named fields of a user, as returned by ChangedUserFields, at the version it was
+// read at, and raises its version. The other columns are left as they are.
+func (r *userRepository) Patch(ctx context.Context, user *User, fields []string) error {
+	set, args, err := setFields("user", fields, user.patchFields())
 	if err != nil {
 		return err
 	}
 
-	rowsAffected, err := result.RowsAffected()
+	if hasField(fields, "user_name") {
+		var count int
+		err := r.db.QueryRow(ctx, "SELECT COUNT(*) FROM users WHERE user_name = ? AND user_id <> ?", user.UserName, user.ID).Scan(&count)
+		if err != nil {
+			return err
+		}
+
+		if count > 0 {
+			return ErrUserNameTaken
+		}
+	}
+
+	query := "UPDATE users SET " + set + "updated_date = CURRENT_TIMESTAMP, version = version + 1 WHERE user_id = ? AND version = ? AND deleted_date IS NULL"
+	result, err := r.db.Exec(ctx, query, append(args, user.ID, user.Version)...)
 	if err != nil {
 		return err
 	}
 
-	if rowsAffected == 0 {
-		return errors.New("no rows were affected during the delete")
+	if err := r.db.versionedResult(ctx, result, "users", "user_id", user.ID, "update"); err != nil {
+		return err
 	}
 
+	user.Version++
+
 	return nil
 }
 
+// Delete soft-deletes a User record by ID at the given version. The record is kept in the trash
+// until it is restored or purged.
+func (r *userRepository) Delete(ctx context.Context, id, version, deletedBy int) error {
+	query := "UPDATE users SET deleted_date = CURRENT_TIMESTAMP, deleted_by = ? WHERE user_id = ? AND version = ? AND deleted_date IS NULL"
+	result, err := r.db.Exec(ctx, query, nullableID(deletedBy), id, version)
+	if err != nil {
+		return err
+	}
+
+	return r.db.versionedResult(ctx, result, "users", "user_id", id, "delete")
+}
+
 // List retrieves a list of all User records from the database.
-func (r *UserRepository) List() ([]*User, error) {
-	query := "SELECT user_id, user_name, mobile, email_id FROM users"
-	rows, err := r.db.Query(query)
+func (r *userRepository) List(ctx context.Context) ([]*User, error) {
+	query := "SELECT user_id, user_name, mobile, email_id, version FROM users WHERE deleted_date IS NULL"
+	rows, err := r.db.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -136,7 +271,7 @@ func (r *UserRepository) List() ([]*User, error) {
 
 	for rows.Next() {
 		user := &User{}
-		err := rows.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID)
+		err := rows.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID, &user.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -151,9 +286,9 @@ func (r *UserRepository) List() ([]*User, error) {
 }
 
 // GetPassword retrieves the password of a user from the database by user_id.
-func (r *UserRepository) GetPassword(id int) (string, error) {
-	query := "SELECT password FROM users WHERE user_id = ?"
-	row := r.db.QueryRow(query, id)
+func (r *userRepository) GetPassword(ctx context.Context, id int) (string, error) {
+	query := "SELECT password FROM users WHERE user_id = ? AND deleted_date IS NULL"
+	row := r.db.QueryRow(ctx, query, id)
 	var password string
 	err := row.Scan(&password)
 	if err != nil {
@@ -166,11 +301,11 @@ func (r *UserRepository) GetPassword(id int) (string, error) {
 }
 
 // GetUserByUserName retrieves a User record from the database by user_name.
-func (r *UserRepository) GetUserByUserName(userName string) (*User, error) {
-	query := "SELECT user_id, user_name, mobile, email_id FROM users WHERE user_name = ?"
-	row := r.db.QueryRow(query, userName)
+func (r *userRepository) GetUserByUserName(ctx context.Context, userName string) (*User, error) {
+	query := "SELECT user_id, user_name, mobile, email_id, version FROM users WHERE user_name = ? AND deleted_date IS NULL"
+	row := r.db.QueryRow(ctx, query, userName)
 	user := &User{}
-	err := row.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID)
+	err := row.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID, &user.Version)
 	if err != nil {
 		if errors.Is(err, sql.ErrNoRows) {
 			return nil, errors.New("invalid user name")
@@ -181,9 +316,9 @@ func (r *UserRepository) GetUserByUserName(userName string) (*User, error) {
 }
 
 // UpdatePassword updates the password of an existing User record in the database.
-func (r *UserRepository) UpdatePassword(userID int, password string) error {
-	query := "UPDATE users SET password = ?, updated_date = NOW() WHERE user_id = ?"
-	result, err := r.db.Exec(query, password, userID)
+func (r *userRepository) UpdatePassword(ctx context.Context, userID int, password string) error {
+	query := "UPDATE users SET password = ?, updated_date = CURRENT_TIMESTAMP WHERE user_id = ? AND deleted_date IS NULL"
+	result, err := r.db.Exec(ctx, query, password, userID)
 	if err != nil {
 		return err
 	}
@@ -200,24 +335,91 @@ func (r *UserRepository) UpdatePassword(userID int, password string) error {
 	return nil
 }
 
-// CreateTable creates the 'users' table in the database.
-func (ur *UserRepository) CreateTable() error {
-	query := `
-	CREATE TABLE IF NOT EXISTS users (
-		user_id INT AUTO_INCREMENT PRIMARY KEY,
-		user_name VARCHAR(255) NOT NULL UNIQUE,
-		mobile VARCHAR(10) NOT NULL,
-		email_id VARCHAR(255) NOT NULL,
-		password VARCHAR(255) NOT NULL,
-		created_date DATETIME NOT NULL DEFAULT NOW(),
-		updated_date DATETIME NOT NULL DEFAULT NOW()
-	)	
-	`
+// GetDeleted retrieves all soft-deleted User records, most recently deleted first.
+func (r *userRepository) GetDeleted(ctx context.Context) ([]*DeletedUser, error) {
+	query := `SELECT user_id, user_name, mobile, email_id, version, deleted_date, deleted_by
+		FROM users WHERE deleted_date IS NOT NULL ORDER BY deleted_date DESC`
+	rows, err := r.db.Query(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	users := []*DeletedUser{}
+
+	for rows.Next() {
+		user := &DeletedUser{}
+		var deletedBy sql.NullInt64
+		err := rows.Scan(&user.ID, &user.UserName, &user.Mobile, &user.EmailID, &user.Version, &user.DeletedDate, &deletedBy)
+		if err != nil {
+			return nil, err
+		}
+		user.DeletedBy = int(deletedBy.Int64)
+		users = append(users, user)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return users, nil
+}
 
-	_, err := ur.db.Exec(query)
+// Restore moves a soft-deleted User record out of the trash.
+func (r *userRepository) Restore(ctx context.Context, id int) error {
+	query := "UPDATE users SET deleted_date = NULL, deleted_by = NULL, updated_date = CURRENT_TIMESTAMP WHERE user_id = ? AND deleted_date IS NOT NULL"
+	result, err := r.db.Exec(ctx, query, id)
 	if err != nil {
 		return err
 	}
 
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no deleted user found with the given id")
+	}
+
 	return nil
 }
+
+// Purge permanently removes the User records soft-deleted before the given time, together with
+// their role assignments and contacts, and returns how many were removed. The contacts are
+// recorded as removed from their CardDAV collections.
+func (r *userRepository) Purge(ctx context.Context, before time.Time) (int64, error) {
+	tx, err := r.db.Begin(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	defer tx.Rollback()
+
+	// user_roles does not cascade, so the assignments go first
+	query := `DELETE FROM user_roles WHERE user_id IN
+		(SELECT user_id FROM users WHERE deleted_date IS NOT NULL AND deleted_date < ?)`
+	if _, err := tx.Exec(ctx, query, before); err != nil {
+		return 0, err
+	}
+
+	// the contacts of the users go with them, also those in address books of other users
+	where := " AND c.user_id IN (SELECT user_id FROM users WHERE deleted_date IS NOT NULL AND deleted_date < ?)"
+	if err := recordTombstones(ctx, r.db.in(tx), where, before); err != nil {
+		return 0, err
+	}
+
+	query = "DELETE FROM users WHERE deleted_date IS NOT NULL AND deleted_date < ?"
+	result, err := tx.Exec(ctx, query, before)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return 0, err
+	}
+
+	return n, tx.Commit()
+}
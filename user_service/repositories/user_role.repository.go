@@ -1,84 +1,131 @@
 package repositories
 
 import (
+	"context"
 	"database/sql"
 	"errors"
 	"time"
 )
 
 type UserRole struct {
-	UserID     int
-	RoleID     int
+	UserID int
+	RoleID int
+	// ExpiryDate is zero for a role that never expires.
 	ExpiryDate time.Time
 }
 
+// ErrUserRoleExists is returned when a role is assigned to a user who already has it.
+var ErrUserRoleExists = errors.New("the user already has this role")
+
+// ErrNoAccess is returned by GetAllAccess for a user whose roles grant no access.
+var ErrNoAccess = errors.New("no access found for the user")
+
 type UserRoleRepository interface {
-	Create(*UserRole) error
-	Get(int, int) (*UserRole, error)
-	Update(*UserRole) error
-	Delete(int, int) error
-	GetAll() ([]*UserRole, error)
-	GetRolesForUser(int) ([]*Role, error)
-	GetAllAccess(userID int) ([]*Access, error)
+	Create(context.Context, *UserRole) error
+	Get(context.Context, int, int) (*UserRole, error)
+	Update(context.Context, *UserRole) error
+	Delete(context.Context, int, int) error
+	GetAll(ctx context.Context) ([]*UserRole, error)
+	GetRolesForUser(context.Context, int) ([]*Role, error)
+	GetAllAccess(ctx context.Context, userID int) ([]*Access, error)
 }
 
 type userRoleRepository struct {
-	db *sql.DB
+	db *DB
 }
 
-func NewUserRoleRepository(db *sql.DB) UserRoleRepository {
-	return &userRoleRepository{db: db}
+// NewUserRoleRepository creates a new UserRoleRepository using the provided database connection.
+// Its writes append their domain events to the outbox, see OutboxRepository.
+func NewUserRoleRepository(db *DB) UserRoleRepository {
+	return &eventUserRoleRepository{UserRoleRepository: &userRoleRepository{db: db}, db: db}
 }
 
-func (r *userRoleRepository) Create(ur *UserRole) error {
-	query := "INSERT INTO user_roles (user_id, role_id, expiry_date, created_date, updated_date) VALUES (?, ?, ?, NOW(), NOW())"
-	result, err := r.db.Exec(query, ur.UserID, ur.RoleID, ur.ExpiryDate)
+func (r *userRoleRepository) Create(ctx context.Context, ur *UserRole) error {
+	query := `INSERT INTO user_roles (user_id, role_id, expiry_date, created_date, updated_date)
+		SELECT ?, ?, ?, CURRENT_TIMESTAMP, CURRENT_TIMESTAMP ` + r.db.Dialect.FromDual() + `
+		WHERE NOT EXISTS (SELECT 1 FROM user_roles WHERE user_id = ? AND role_id = ?)`
+	result, err := r.db.Exec(ctx, query, ur.UserID, ur.RoleID, nullableTime(ur.ExpiryDate), ur.UserID, ur.RoleID)
 	if err != nil {
 		return err
 	}
 
-	_, err = result.LastInsertId()
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if rowsAffected == 0 {
+		return ErrUserRoleExists
+	}
+
 	return nil
 }
 
-func (r *userRoleRepository) Get(userID, roleID int) (*UserRole, error) {
+func (r *userRoleRepository) Get(ctx context.Context, userID, roleID int) (*UserRole, error) {
 	query := "SELECT user_id, role_id, expiry_date FROM user_roles WHERE user_id = ? AND role_id = ?"
-	row := r.db.QueryRow(query, userID, roleID)
-	userRole := &UserRole{}
-	err := row.Scan(&userRole.UserID, &userRole.RoleID, &userRole.ExpiryDate)
+	userRole, err := scanUserRole(r.db.QueryRow(ctx, query, userID, roleID))
 	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("the user does not have this role")
+		}
 		return nil, err
 	}
 	return userRole, nil
 }
 
-func (r *userRoleRepository) Update(ur *UserRole) error {
-	query := "UPDATE user_roles SET expiry_date = ?, updated_date = NOW() WHERE user_id = ? AND role_id = ?"
-	_, err := r.db.Exec(query, ur.ExpiryDate, ur.UserID, ur.RoleID)
+// scanUserRole reads a user role selected as user_id, role_id, expiry_date.
+func scanUserRole(row rowScanner) (*UserRole, error) {
+	userRole := &UserRole{}
+	var expiry sql.NullTime
+	if err := row.Scan(&userRole.UserID, &userRole.RoleID, &expiry); err != nil {
+		return nil, err
+	}
+	userRole.ExpiryDate = expiry.Time
+
+	return userRole, nil
+}
+
+func (r *userRoleRepository) Update(ctx context.Context, ur *UserRole) error {
+	query := "UPDATE user_roles SET expiry_date = ?, updated_date = CURRENT_TIMESTAMP WHERE user_id = ? AND role_id = ?"
+	result, err := r.db.Exec(ctx, query, nullableTime(ur.ExpiryDate), ur.UserID, ur.RoleID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
 	return nil
 }
 
-func (r *userRoleRepository) Delete(userID, roleID int) error {
+func (r *userRoleRepository) Delete(ctx context.Context, userID, roleID int) error {
 	query := "DELETE FROM user_roles WHERE user_id = ? AND role_id = ?"
-	_, err := r.db.Exec(query, userID, roleID)
+	result, err := r.db.Exec(ctx, query, userID, roleID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
 	if err != nil {
 		return err
 	}
 
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
 	return nil
 }
 
-func (r *userRoleRepository) GetAll() ([]*UserRole, error) {
+func (r *userRoleRepository) GetAll(ctx context.Context) ([]*UserRole, error) {
 	query := "SELECT user_id, role_id, expiry_date FROM user_roles"
-	rows, err := r.db.Query(query)
+	rows, err := r.db.Query(ctx, query)
 	if err != nil {
 		return nil, err
 	}
@@ -88,8 +135,7 @@ func (r *userRoleRepository) GetAll() ([]*UserRole, error) {
 	userRoles := []*UserRole{}
 
 	for rows.Next() {
-		userRole := &UserRole{}
-		err := rows.Scan(&userRole.UserID, &userRole.RoleID, &userRole.ExpiryDate)
+		userRole, err := scanUserRole(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -103,9 +149,9 @@ func (r *userRoleRepository) GetAll() ([]*UserRole, error) {
 	return userRoles, nil
 }
 
-func (r *userRoleRepository) GetRolesForUser(userID int) ([]*Role, error) {
-	query := "SELECT r.role_id, r.role_name FROM roles r INNER JOIN user_roles ur ON r.role_id = ur.role_id WHERE ur.user_id = ?"
-	rows, err := r.db.Query(query, userID)
+func (r *userRoleRepository) GetRolesForUser(ctx context.Context, userID int) ([]*Role, error) {
+	query := "SELECT r.role_id, r.role_name, r.version FROM roles r INNER JOIN user_roles ur ON r.role_id = ur.role_id WHERE ur.user_id = ? AND r.deleted_date IS NULL"
+	rows, err := r.db.Query(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -116,7 +162,7 @@ func (r *userRoleRepository) GetRolesForUser(userID int) ([]*Role, error) {
 
 	for rows.Next() {
 		role := &Role{}
-		err := rows.Scan(&role.ID, &role.Name)
+		err := rows.Scan(&role.ID, &role.Name, &role.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -130,16 +176,16 @@ func (r *userRoleRepository) GetRolesForUser(userID int) ([]*Role, error) {
 	return roles, nil
 }
 
-func (r *userRoleRepository) GetAllAccess(userID int) ([]*Access, error) {
+func (r *userRoleRepository) GetAllAccess(ctx context.Context, userID int) ([]*Access, error) {
 	query := `
-		SELECT DISTINCT access.access_id, access.access_name
-		FROM user_role
-		JOIN role ON user_role.role_id = role.role_id
-		JOIN access_role ON role.role_id = access_role.role_id
+		SELECT DISTINCT access.access_id, access.access_name, access.version
+		FROM user_roles
+		JOIN roles ON user_roles.role_id = roles.role_id
+		JOIN access_role ON roles.role_id = access_role.role_id
 		JOIN access ON access_role.access_id = access.access_id
-		WHERE user_role.user_id = ?
+		WHERE user_roles.user_id = ? AND roles.deleted_date IS NULL AND access.deleted_date IS NULL
 	`
-	rows, err := r.db.Query(query, userID)
+	rows, err := r.db.Query(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -148,7 +194,7 @@ func (r *userRoleRepository) GetAllAccess(userID int) ([]*Access, error) {
 	accesses := []*Access{}
 	for rows.Next() {
 		access := &Access{}
-		err := rows.Scan(&access.ID, &access.Name)
+		err := rows.Scan(&access.ID, &access.Name, &access.Version)
 		if err != nil {
 			return nil, err
 		}
@@ -160,28 +206,8 @@ func (r *userRoleRepository) GetAllAccess(userID int) ([]*Access, error) {
 	}
 
 	if len(accesses) == 0 {
-		return nil, errors.New("no access found for the user")
+		return nil, ErrNoAccess
 	}
 
 	return accesses, nil
 }
-
-func (r *userRoleRepository) CreateTable() error {
-	query := `
-        CREATE TABLE IF NOT EXISTS user_roles (
-            user_id INT NOT NULL,
-            role_id INT NOT NULL,
-            expiry_date DATETIME,
-			created_date DATETIME NOT NULL DEFAULT NOW(),
-			updated_date DATETIME NOT NULL DEFAULT NOW(),
-			PRIMARY KEY (user_id, role_id),
-            FOREIGN KEY (user_id) REFERENCES users(user_id),
-            FOREIGN KEY (role_id) REFERENCES roles(role_id)
-        )`
-	_, err := r.db.Exec(query)
-	if err != nil {
-		return err
-	}
-
-	return nil
-}
@@ -0,0 +1,38 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// ErrVersionConflict is returned when a record is updated or deleted at a version it no longer
+// has, because another request changed it since it was read.
+var ErrVersionConflict = errors.New("the record was changed since it was read")
+
+// versionedResult returns the error of a versioned update or delete of the live row of table
+// whose idColumn is id: nil when it affected a row, ErrVersionConflict when the row is live at
+// another version, and the usual "no rows were affected during the <operation>" error when there
+// is no such live row.
+func (db *DB) versionedResult(ctx context.Context, result sql.Result, table, idColumn string, id int, operation string) error {
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected > 0 {
+		return nil
+	}
+
+	var count int
+	query := "SELECT COUNT(*) FROM " + table + " WHERE " + idColumn + " = ? AND deleted_date IS NULL"
+	if err := db.QueryRow(ctx, query, id).Scan(&count); err != nil {
+		return err
+	}
+
+	if count > 0 {
+		return ErrVersionConflict
+	}
+
+	return errors.New("no rows were affected during the " + operation)
+}
@@ -0,0 +1,530 @@
+package repositories
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+)
+
+// States of a webhook delivery. Pending deliveries are sent until they succeed or run out of
+// attempts.
+const (
+	WebhookDeliveryPending   = "pending"
+	WebhookDeliverySucceeded = "succeeded"
+	WebhookDeliveryFailed    = "failed"
+)
+
+// WebhookSnapshotLength is the longest response body kept in the snapshot of a delivery.
+const WebhookSnapshotLength = 16 << 10
+
+// WebhookDeliverySortFields are the fields a page of webhook deliveries can be sorted by.
+var WebhookDeliverySortFields = []string{"id"}
+
+// Webhook is a subscription of an HTTP endpoint to domain events. EventTypes filters the events
+// delivered: each entry is an event type, such as UserCreated, or <aggregate type>.* for all the
+// events of an aggregate, such as contact.*; an empty list selects every event. Secret signs the
+// deliveries and is never returned once set. A webhook belongs to the user who created it.
+//
+// A webhook that keeps failing is disabled: Active is cleared and DisabledDate and DisabledReason
+// say when and why. ConsecutiveFailures counts the failed attempts since the last successful one.
+type Webhook struct {
+	ID                  int
+	UserID              int
+	URL                 string
+	EventTypes          []string
+	Secret              string `json:"-"`
+	Active              bool
+	ConsecutiveFailures int
+	DisabledDate        time.Time
+	DisabledReason      string
+	CreatedDate         time.Time
+	UpdatedDate         time.Time
+}
+
+// Matches reports whether the webhook subscribes to events of the given type and aggregate type.
+func (w *Webhook) Matches(aggregateType, eventType string) bool {
+	if len(w.EventTypes) == 0 {
+		return true
+	}
+
+	for _, t := range w.EventTypes {
+		if t == eventType || t == aggregateType+".*" {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookDelivery is the delivery of an event to a webhook, or its manual redelivery when
+// RedeliveryOf names the delivery it repeats. Payload is the body posted.
+//
+// The request and response fields are a snapshot of the last attempt: the URL and headers sent,
+// and the status, headers and body received, or Error when no response arrived.
+// NextAttemptDate is zero once the delivery succeeded or failed for good.
+type WebhookDelivery struct {
+	ID              int
+	WebhookID       int
+	EventID         int
+	EventType       string
+	Payload         json.RawMessage
+	RedeliveryOf    int
+	Status          string
+	Attempts        int
+	NextAttemptDate time.Time
+	LastAttemptDate time.Time
+	RequestURL      string
+	RequestHeaders  map[string][]string
+	ResponseStatus  int
+	ResponseHeaders map[string][]string
+	ResponseBody    string
+	Error           string
+	Duration        time.Duration
+	CreatedDate     time.Time
+}
+
+// SortKey returns the position of the delivery in a list sorted by the given field.
+func (d *WebhookDelivery) SortKey(field string) SortKey {
+	return SortKey{ID: d.ID}
+}
+
+// ErrWebhookDeliveryExists is returned when an event is delivered again to a webhook it was
+// already delivered to. Redeliveries are created with RedeliveryOf set instead.
+var ErrWebhookDeliveryExists = errors.New("the event was already delivered to this webhook")
+
+// WebhookRepository stores webhooks and the log of their deliveries. Deleting a webhook deletes
+// its deliveries.
+type WebhookRepository interface {
+	Create(context.Context, *Webhook) error
+	Get(context.Context, int) (*Webhook, error)
+	// GetAll returns the webhooks of the user.
+	GetAll(ctx context.Context, userID int) ([]*Webhook, error)
+	// GetActive returns the webhooks of the user that are not disabled.
+	GetActive(ctx context.Context, userID int) ([]*Webhook, error)
+	// GetActiveWithAccess returns the webhooks that are not disabled of the users holding the named
+	// access through a role assignment that has not expired.
+	GetActiveWithAccess(ctx context.Context, accessName string) ([]*Webhook, error)
+	// Update writes the URL, event types, secret and state of a webhook. Enabling it clears the
+	// failures that disabled it.
+	Update(context.Context, *Webhook) error
+	Delete(context.Context, int) error
+
+	// RecordSuccess resets the consecutive failures of a webhook.
+	RecordSuccess(ctx context.Context, id int) error
+	// RecordFailure counts a failed attempt and disables the webhook with the reason when its
+	// consecutive failures reach disableAfter, and reports whether it did.
+	RecordFailure(ctx context.Context, id, disableAfter int, reason string) (bool, error)
+
+	CreateDelivery(context.Context, *WebhookDelivery) error
+	GetDelivery(context.Context, int) (*WebhookDelivery, error)
+	GetDeliveries(ctx context.Context, webhookID int, status string, page PageRequest) ([]*WebhookDelivery, *PageInfo, error)
+	// GetDueDeliveries returns up to limit pending deliveries of active webhooks whose next attempt
+	// is due at now, in the order they were created.
+	GetDueDeliveries(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error)
+	// RecordAttempt writes the state and the snapshot of a delivery after an attempt.
+	RecordAttempt(context.Context, *WebhookDelivery) error
+}
+
+type webhookRepository struct {
+	db *DB
+}
+
+// NewWebhookRepository creates a new WebhookRepository using the provided database connection.
+func NewWebhookRepository(db *DB) WebhookRepository {
+	return &webhookRepository{db: db}
+}
+
+const webhookColumns = `webhook_id, user_id, url, event_types, secret, active, consecutive_failures, disabled_date,
+	disabled_reason, created_date, updated_date`
+
+func scanWebhook(row rowScanner) (*Webhook, error) {
+	w := &Webhook{}
+	var eventTypes string
+	var userID sql.NullInt64
+	var disabled sql.NullTime
+	err := row.Scan(&w.ID, &userID, &w.URL, &eventTypes, &w.Secret, &w.Active, &w.ConsecutiveFailures, &disabled,
+		&w.DisabledReason, &w.CreatedDate, &w.UpdatedDate)
+	if err != nil {
+		return nil, err
+	}
+	w.UserID = int(userID.Int64)
+	w.DisabledDate = disabled.Time
+
+	if err := json.Unmarshal([]byte(eventTypes), &w.EventTypes); err != nil {
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (r *webhookRepository) Create(ctx context.Context, w *Webhook) error {
+	eventTypes, err := json.Marshal(w.eventTypes())
+	if err != nil {
+		return err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	query := `INSERT INTO webhooks (user_id, url, event_types, secret, active, consecutive_failures, disabled_reason, created_date, updated_date)
+		VALUES (?, ?, ?, ?, ?, 0, '', ?, ?)`
+	id, err := r.db.Insert(ctx, "webhook_id", query, w.UserID, w.URL, string(eventTypes), w.Secret, w.Active, now, now)
+	if err != nil {
+		return err
+	}
+
+	w.ID = id
+	w.ConsecutiveFailures = 0
+	w.DisabledDate = time.Time{}
+	w.DisabledReason = ""
+	w.CreatedDate = now
+	w.UpdatedDate = now
+
+	return nil
+}
+
+// eventTypes returns the event types of the webhook, never nil, so that they are stored as [].
+func (w *Webhook) eventTypes() []string {
+	if w.EventTypes == nil {
+		return []string{}
+	}
+
+	return w.EventTypes
+}
+
+func (r *webhookRepository) Get(ctx context.Context, id int) (*Webhook, error) {
+	w, err := scanWebhook(r.db.QueryRow(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE webhook_id = ?", id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid webhook id")
+		}
+		return nil, err
+	}
+
+	return w, nil
+}
+
+func (r *webhookRepository) GetAll(ctx context.Context, userID int) ([]*Webhook, error) {
+	return r.list(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE user_id = ? ORDER BY webhook_id", userID)
+}
+
+func (r *webhookRepository) GetActive(ctx context.Context, userID int) ([]*Webhook, error) {
+	return r.list(ctx, "SELECT "+webhookColumns+" FROM webhooks WHERE user_id = ? AND active = TRUE ORDER BY webhook_id", userID)
+}
+
+func (r *webhookRepository) GetActiveWithAccess(ctx context.Context, accessName string) ([]*Webhook, error) {
+	query := `SELECT ` + webhookColumns + ` FROM webhooks WHERE active = TRUE AND user_id IN (
+		SELECT ur.user_id FROM user_roles ur
+		JOIN users u ON u.user_id = ur.user_id
+		JOIN roles ro ON ro.role_id = ur.role_id
+		JOIN access_role ar ON ar.role_id = ro.role_id
+		JOIN access a ON a.access_id = ar.access_id
+		WHERE a.access_name = ? AND u.deleted_date IS NULL AND ro.deleted_date IS NULL AND a.deleted_date IS NULL
+		AND (ur.expiry_date IS NULL OR ur.expiry_date > CURRENT_TIMESTAMP)
+	) ORDER BY webhook_id`
+	return r.list(ctx, query, accessName)
+}
+
+func (r *webhookRepository) list(ctx context.Context, query string, args ...interface{}) ([]*Webhook, error) {
+	rows, err := r.db.Query(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	webhooks := []*Webhook{}
+
+	for rows.Next() {
+		w, err := scanWebhook(rows)
+		if err != nil {
+			return nil, err
+		}
+		webhooks = append(webhooks, w)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return webhooks, nil
+}
+
+func (r *webhookRepository) Update(ctx context.Context, w *Webhook) error {
+	eventTypes, err := json.Marshal(w.eventTypes())
+	if err != nil {
+		return err
+	}
+
+	// enabling a webhook clears its failures; disabling it by hand keeps them
+	query := `UPDATE webhooks SET url = ?, event_types = ?, secret = ?,
+		consecutive_failures = CASE WHEN ? AND active = FALSE THEN 0 ELSE consecutive_failures END,
+		disabled_date = CASE WHEN ? THEN NULL WHEN active = TRUE THEN ? ELSE disabled_date END,
+		disabled_reason = CASE WHEN ? THEN '' WHEN active = TRUE THEN 'disabled by a user' ELSE disabled_reason END,
+		active = ?, updated_date = CURRENT_TIMESTAMP
+		WHERE webhook_id = ?`
+	now := time.Now()
+	result, err := r.db.Exec(ctx, query, w.URL, string(eventTypes), w.Secret, w.Active, w.Active, now, w.Active, w.Active, w.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return nil
+}
+
+func (r *webhookRepository) Delete(ctx context.Context, id int) error {
+	result, err := r.db.Exec(ctx, "DELETE FROM webhooks WHERE webhook_id = ?", id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the delete")
+	}
+
+	return nil
+}
+
+func (r *webhookRepository) RecordSuccess(ctx context.Context, id int) error {
+	_, err := r.db.Exec(ctx, "UPDATE webhooks SET consecutive_failures = 0 WHERE webhook_id = ? AND consecutive_failures > 0", id)
+	return err
+}
+
+func (r *webhookRepository) RecordFailure(ctx context.Context, id, disableAfter int, reason string) (bool, error) {
+	_, err := r.db.Exec(ctx, "UPDATE webhooks SET consecutive_failures = consecutive_failures + 1 WHERE webhook_id = ?", id)
+	if err != nil {
+		return false, err
+	}
+
+	if len(reason) > webhookErrorLength {
+		reason = strings.ToValidUTF8(reason[:webhookErrorLength], "")
+	}
+
+	query := `UPDATE webhooks SET active = FALSE, disabled_date = ?, disabled_reason = ?, updated_date = CURRENT_TIMESTAMP
+		WHERE webhook_id = ? AND active = TRUE AND consecutive_failures >= ?`
+	result, err := r.db.Exec(ctx, query, time.Now(), reason, id, disableAfter)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return rowsAffected > 0, nil
+}
+
+// webhookErrorLength is the longest error or disabling reason kept.
+const webhookErrorLength = 1024
+
+const webhookDeliveryColumns = `delivery_id, webhook_id, event_id, event_type, payload, redelivery_of, status, attempts,
+	next_attempt_date, last_attempt_date, request_url, request_headers, response_status, response_headers,
+	response_body, error_message, duration_ms, created_date`
+
+func scanWebhookDelivery(row rowScanner) (*WebhookDelivery, error) {
+	d := &WebhookDelivery{}
+	var payload, requestHeaders, responseHeaders string
+	var redeliveryOf sql.NullInt64
+	var next, last sql.NullTime
+	var durationMS int64
+	err := row.Scan(&d.ID, &d.WebhookID, &d.EventID, &d.EventType, &payload, &redeliveryOf, &d.Status, &d.Attempts,
+		&next, &last, &d.RequestURL, &requestHeaders, &d.ResponseStatus, &responseHeaders,
+		&d.ResponseBody, &d.Error, &durationMS, &d.CreatedDate)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Payload = json.RawMessage(payload)
+	d.RedeliveryOf = int(redeliveryOf.Int64)
+	d.NextAttemptDate = next.Time
+	d.LastAttemptDate = last.Time
+	d.Duration = time.Duration(durationMS) * time.Millisecond
+
+	if err := unmarshalHeaders(requestHeaders, &d.RequestHeaders); err != nil {
+		return nil, err
+	}
+
+	if err := unmarshalHeaders(responseHeaders, &d.ResponseHeaders); err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// unmarshalHeaders decodes the headers of a snapshot, which are empty before the first attempt.
+func unmarshalHeaders(data string, headers *map[string][]string) error {
+	if data == "" {
+		return nil
+	}
+
+	return json.Unmarshal([]byte(data), headers)
+}
+
+// CreateDelivery stores a pending delivery. A delivery that is not a redelivery is only stored
+// once per event and webhook; the second one fails with ErrWebhookDeliveryExists.
+func (r *webhookRepository) CreateDelivery(ctx context.Context, d *WebhookDelivery) error {
+	d.Status = WebhookDeliveryPending
+	d.Attempts = 0
+	d.CreatedDate = time.Now().UTC().Truncate(time.Second)
+	if d.NextAttemptDate.IsZero() {
+		d.NextAttemptDate = d.CreatedDate
+	}
+
+	query := `INSERT INTO webhook_deliveries (webhook_id, event_id, event_type, payload, redelivery_of, status, attempts,
+		next_attempt_date, request_headers, response_headers, response_body, created_date)
+		SELECT ?, ?, ?, ?, ?, ?, 0, ?, '', '', '', ? ` + r.db.Dialect.FromDual()
+	args := []interface{}{d.WebhookID, d.EventID, d.EventType, string(d.Payload), nullableID(d.RedeliveryOf), d.Status,
+		d.NextAttemptDate, d.CreatedDate}
+	if d.RedeliveryOf == 0 {
+		query += ` WHERE NOT EXISTS (SELECT 1 FROM webhook_deliveries WHERE webhook_id = ? AND event_id = ? AND redelivery_of IS NULL)`
+		args = append(args, d.WebhookID, d.EventID)
+	}
+
+	id, err := r.db.Insert(ctx, "delivery_id", query, args...)
+	if err != nil {
+		return err
+	}
+
+	if id == 0 {
+		return ErrWebhookDeliveryExists
+	}
+
+	d.ID = id
+
+	return nil
+}
+
+func (r *webhookRepository) GetDelivery(ctx context.Context, id int) (*WebhookDelivery, error) {
+	query := "SELECT " + webhookDeliveryColumns + " FROM webhook_deliveries WHERE delivery_id = ?"
+	d, err := scanWebhookDelivery(r.db.QueryRow(ctx, query, id))
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, errors.New("invalid webhook delivery id")
+		}
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// GetDeliveries returns a page of the deliveries of a webhook, of every status if status is "".
+func (r *webhookRepository) GetDeliveries(ctx context.Context, webhookID int, status string, page PageRequest) ([]*WebhookDelivery, *PageInfo, error) {
+	if err := page.Validate(WebhookDeliverySortFields...); err != nil {
+		return nil, nil, err
+	}
+
+	q := &pageQuery{
+		columns: webhookDeliveryColumns,
+		from:    "FROM webhook_deliveries WHERE webhook_id = ?",
+		args:    []interface{}{webhookID},
+		id:      "delivery_id",
+		sorts:   map[string]string{"id": "delivery_id"},
+	}
+
+	if status != "" {
+		q.from += " AND status = ?"
+		q.args = append(q.args, status)
+	}
+
+	deliveries := []*WebhookDelivery{}
+	info, err := q.run(ctx, r.db, page, func(rows *Rows) (SortKey, error) {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return SortKey{}, err
+		}
+		deliveries = append(deliveries, d)
+		return d.SortKey(page.Sort), nil
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return deliveries, info, nil
+}
+
+func (r *webhookRepository) GetDueDeliveries(ctx context.Context, now time.Time, limit int) ([]*WebhookDelivery, error) {
+	query := `SELECT ` + webhookDeliveryColumns + ` FROM webhook_deliveries
+		WHERE status = ? AND next_attempt_date <= ? AND webhook_id IN (SELECT webhook_id FROM webhooks WHERE active = TRUE)
+		ORDER BY delivery_id LIMIT ?`
+	rows, err := r.db.Query(ctx, query, WebhookDeliveryPending, now, limit)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rows.Close()
+
+	deliveries := []*WebhookDelivery{}
+
+	for rows.Next() {
+		d, err := scanWebhookDelivery(rows)
+		if err != nil {
+			return nil, err
+		}
+		deliveries = append(deliveries, d)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return deliveries, nil
+}
+
+func (r *webhookRepository) RecordAttempt(ctx context.Context, d *WebhookDelivery) error {
+	requestHeaders, err := json.Marshal(d.RequestHeaders)
+	if err != nil {
+		return err
+	}
+
+	responseHeaders, err := json.Marshal(d.ResponseHeaders)
+	if err != nil {
+		return err
+	}
+
+	// bodies are cut to their start and need not be text, but the columns hold valid UTF-8
+	if len(d.ResponseBody) > WebhookSnapshotLength {
+		d.ResponseBody = d.ResponseBody[:WebhookSnapshotLength]
+	}
+	d.ResponseBody = strings.ToValidUTF8(d.ResponseBody, "\uFFFD")
+
+	if len(d.Error) > webhookErrorLength {
+		d.Error = strings.ToValidUTF8(d.Error[:webhookErrorLength], "")
+	}
+
+	query := `UPDATE webhook_deliveries SET status = ?, attempts = ?, next_attempt_date = ?, last_attempt_date = ?,
+		request_url = ?, request_headers = ?, response_status = ?, response_headers = ?, response_body = ?,
+		error_message = ?, duration_ms = ? WHERE delivery_id = ?`
+	result, err := r.db.Exec(ctx, query, d.Status, d.Attempts, nullableTime(d.NextAttemptDate), nullableTime(d.LastAttemptDate),
+		d.RequestURL, string(requestHeaders), d.ResponseStatus, string(responseHeaders), d.ResponseBody,
+		d.Error, d.Duration.Milliseconds(), d.ID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return errors.New("no rows were affected during the update")
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package search
+
+// Searchable fields of a contact document.
+const (
+	FieldName         = "name"
+	FieldEmail        = "email"
+	FieldPhone        = "phone"
+	FieldOrganization = "organization"
+	FieldNotes        = "notes"
+	FieldCustom       = "custom"
+)
+
+// Facets a contact document can be filtered and counted by.
+const (
+	FacetTag     = "tag"
+	FacetGroup   = "group"
+	FacetCompany = "company"
+)
+
+// fieldWeights defines how much a match in a field contributes to the relevance score.
+var fieldWeights = map[string]float64{
+	FieldName:         3,
+	FieldEmail:        2,
+	FieldPhone:        2,
+	FieldOrganization: 1.5,
+	FieldNotes:        1,
+	FieldCustom:       1,
+}
+
+// Document is the searchable representation of a contact.
+type Document struct {
+	ContactID     int
+	UserID        int
+	AddressBookID int
+	Fields        map[string]string
+	Facets        map[string][]string
+}
+
+// Query describes a search request.
+//
+// A query sees the personal contacts of UserID, that is those outside of any address book,
+// and the contacts of the address books listed in AddressBookIDs.
+//
+// Text is split into terms which must all match a document. A term matches indexed words
+// it equals or is a prefix of, and with Fuzzy set also words within a small edit distance.
+// Facets restricts the result to documents having at least one of the listed values for
+// every given facet.
+type Query struct {
+	UserID         int
+	AddressBookIDs []int
+	Text           string
+	Fuzzy          bool
+	Facets         map[string][]string
+	Limit          int
+	Offset         int
+}
+
+// Hit is a single document matching a query. Highlights holds its fields with matched terms in
+// <em> tags, HTML escaped so that they can be shown as markup.
+type Hit struct {
+	ContactID  int
+	Score      float64
+	Highlights map[string]string
+}
+
+// Result is the outcome of a search. Facets holds the value counts of every facet over all
+// matching documents, not only the returned page.
+type Result struct {
+	Total  int
+	Hits   []*Hit
+	Facets map[string]map[string]int
+}
+
+// Index is implemented by the search backends contacts are indexed into.
+type Index interface {
+	Index(doc *Document) error
+	Remove(contactID int) error
+	Search(q *Query) (*Result, error)
+}
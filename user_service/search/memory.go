@@ -0,0 +1,306 @@
+package search
+
+import (
+	"html"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Relative weight of the ways a query term can match an indexed term.
+const (
+	exactMatch  = 1.0
+	prefixMatch = 0.7
+	fuzzyMatch  = 0.4
+)
+
+// MemoryIndex is an embedded, in-process Index keeping an inverted index of all documents.
+// It is safe for concurrent use.
+type MemoryIndex struct {
+	mu       sync.RWMutex
+	docs     map[int]*Document
+	postings map[string]map[int]map[string]bool // term -> contact id -> fields
+	terms    []string                           // sorted keys of postings, used for prefix lookups
+}
+
+// NewMemoryIndex returns an empty MemoryIndex.
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{
+		docs:     map[int]*Document{},
+		postings: map[string]map[int]map[string]bool{},
+	}
+}
+
+// Index adds a document to the index, replacing any previous version of it.
+func (m *MemoryIndex) Index(doc *Document) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(doc.ContactID)
+	m.docs[doc.ContactID] = doc
+
+	for field, text := range doc.Fields {
+		for _, term := range fieldTerms(field, text) {
+			docs, ok := m.postings[term]
+			if !ok {
+				docs = map[int]map[string]bool{}
+				m.postings[term] = docs
+				m.insertTerm(term)
+			}
+			if docs[doc.ContactID] == nil {
+				docs[doc.ContactID] = map[string]bool{}
+			}
+			docs[doc.ContactID][field] = true
+		}
+	}
+
+	return nil
+}
+
+// Remove drops a document from the index. Removing an unknown document is not an error.
+func (m *MemoryIndex) Remove(contactID int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(contactID)
+	return nil
+}
+
+func (m *MemoryIndex) remove(contactID int) {
+	doc, ok := m.docs[contactID]
+	if !ok {
+		return
+	}
+
+	for field, text := range doc.Fields {
+		for _, term := range fieldTerms(field, text) {
+			docs := m.postings[term]
+			delete(docs, contactID)
+			if len(docs) == 0 {
+				delete(m.postings, term)
+				m.deleteTerm(term)
+			}
+		}
+	}
+
+	delete(m.docs, contactID)
+}
+
+func (m *MemoryIndex) insertTerm(term string) {
+	i := sort.SearchStrings(m.terms, term)
+	m.terms = append(m.terms, "")
+	copy(m.terms[i+1:], m.terms[i:])
+	m.terms[i] = term
+}
+
+func (m *MemoryIndex) deleteTerm(term string) {
+	i := sort.SearchStrings(m.terms, term)
+	if i < len(m.terms) && m.terms[i] == term {
+		m.terms = append(m.terms[:i], m.terms[i+1:]...)
+	}
+}
+
+// expand returns the indexed terms matching a query term along with the match weight.
+func (m *MemoryIndex) expand(term string, fuzzy bool) map[string]float64 {
+	matches := map[string]float64{}
+
+	for i := sort.SearchStrings(m.terms, term); i < len(m.terms) && strings.HasPrefix(m.terms[i], term); i++ {
+		if m.terms[i] == term {
+			matches[term] = exactMatch
+		} else {
+			matches[m.terms[i]] = prefixMatch
+		}
+	}
+
+	if limit := maxEdits(term); fuzzy && limit > 0 {
+		for _, candidate := range m.terms {
+			if _, ok := matches[candidate]; ok {
+				continue
+			}
+			if editDistance(term, candidate, limit) <= limit {
+				matches[candidate] = fuzzyMatch
+			}
+		}
+	}
+
+	return matches
+}
+
+// Search runs a query against the index. Hits are ordered by descending score, ties are
+// broken by contact id so paging is stable.
+func (m *MemoryIndex) Search(q *Query) (*Result, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	scores := map[int]float64{}
+	matched := map[int]map[string]bool{}
+
+	terms := queryTerms(q.Text)
+	if len(terms) == 0 {
+		for id, doc := range m.docs {
+			if visible(doc, q) {
+				scores[id] = 0
+			}
+		}
+	}
+
+	for i, term := range terms {
+		termScores := map[int]float64{}
+		termMatches := map[int][]string{}
+		for indexed, weight := range m.expand(term, q.Fuzzy) {
+			for id, fields := range m.postings[indexed] {
+				if !visible(m.docs[id], q) {
+					continue
+				}
+				best := 0.0
+				for field := range fields {
+					if s := weight * fieldWeights[field]; s > best {
+						best = s
+					}
+				}
+				if best > termScores[id] {
+					termScores[id] = best
+				}
+				termMatches[id] = append(termMatches[id], indexed)
+			}
+		}
+
+		// every term has to match, so only documents matched by all previous terms survive
+		next := map[int]float64{}
+		for id, s := range termScores {
+			if _, ok := scores[id]; ok || i == 0 {
+				next[id] = scores[id] + s
+				if matched[id] == nil {
+					matched[id] = map[string]bool{}
+				}
+				for _, t := range termMatches[id] {
+					matched[id][t] = true
+				}
+			}
+		}
+		scores = next
+	}
+
+	result := &Result{Hits: []*Hit{}, Facets: map[string]map[string]int{}}
+	for id, score := range scores {
+		doc := m.docs[id]
+		if !matchesFacets(doc, q.Facets) {
+			continue
+		}
+
+		for facet, values := range doc.Facets {
+			if result.Facets[facet] == nil {
+				result.Facets[facet] = map[string]int{}
+			}
+			for _, value := range values {
+				result.Facets[facet][value]++
+			}
+		}
+
+		result.Hits = append(result.Hits, &Hit{
+			ContactID:  id,
+			Score:      score,
+			Highlights: highlights(doc, matched[id]),
+		})
+	}
+
+	sort.Slice(result.Hits, func(i, j int) bool {
+		if result.Hits[i].Score != result.Hits[j].Score {
+			return result.Hits[i].Score > result.Hits[j].Score
+		}
+		return result.Hits[i].ContactID < result.Hits[j].ContactID
+	})
+
+	result.Total = len(result.Hits)
+	result.Hits = page(result.Hits, q.Offset, q.Limit)
+
+	return result, nil
+}
+
+// visible reports whether the query is allowed to see doc.
+func visible(doc *Document, q *Query) bool {
+	if doc.AddressBookID == 0 {
+		return doc.UserID == q.UserID
+	}
+
+	for _, id := range q.AddressBookIDs {
+		if id == doc.AddressBookID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchesFacets reports whether doc has at least one of the wanted values for every facet.
+func matchesFacets(doc *Document, wanted map[string][]string) bool {
+	for facet, values := range wanted {
+		if len(values) == 0 {
+			continue
+		}
+
+		found := false
+		for _, have := range doc.Facets[facet] {
+			for _, want := range values {
+				if strings.EqualFold(have, want) {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	return true
+}
+
+// highlights returns the fields of doc that contain matched terms with those terms emphasized. The
+// fields are HTML escaped, so that only the <em> tags are markup.
+func highlights(doc *Document, matched map[string]bool) map[string]string {
+	result := map[string]string{}
+	for field, text := range doc.Fields {
+		if matchedWhole(field, text, matched) {
+			result[field] = "<em>" + html.EscapeString(text) + "</em>"
+			continue
+		}
+		if h := highlight(text, matched); h != "" {
+			result[field] = h
+		}
+	}
+
+	return result
+}
+
+// matchedWhole reports whether one of the whole-value terms of an email or phone field matched,
+// in which case the field is highlighted as a whole.
+func matchedWhole(field, text string, matched map[string]bool) bool {
+	if field != FieldEmail && field != FieldPhone {
+		return false
+	}
+
+	words := map[string]bool{}
+	for _, t := range tokenize(text) {
+		words[t.term] = true
+	}
+
+	for _, term := range fieldTerms(field, text) {
+		if matched[term] && !words[term] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func page(hits []*Hit, offset, limit int) []*Hit {
+	if offset >= len(hits) {
+		return []*Hit{}
+	}
+	hits = hits[offset:]
+	if limit > 0 && limit < len(hits) {
+		hits = hits[:limit]
+	}
+
+	return hits
+}
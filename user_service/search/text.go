@@ -0,0 +1,182 @@
+package search
+
+import (
+	"html"
+	"strings"
+	"unicode"
+)
+
+// token is a word of a field together with its byte offsets in the original text.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lower-cased words made of letters and digits.
+func tokenize(text string) []token {
+	tokens := []token{}
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{term: strings.ToLower(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: strings.ToLower(text[start:]), start: start, end: len(text)})
+	}
+
+	return tokens
+}
+
+// fieldTerms returns the distinct terms indexed for a field. Besides the plain words, email
+// addresses are indexed whole and phone numbers as every suffix of their digits, so
+// "jane.doe@ex" and a local number typed without its country code both find what users expect.
+func fieldTerms(field, text string) []string {
+	seen := map[string]bool{}
+	terms := []string{}
+	add := func(term string) {
+		if term != "" && !seen[term] {
+			seen[term] = true
+			terms = append(terms, term)
+		}
+	}
+
+	for _, t := range tokenize(text) {
+		add(t.term)
+	}
+
+	switch field {
+	case FieldEmail:
+		add(strings.ToLower(strings.TrimSpace(text)))
+	case FieldPhone:
+		d := digits(text)
+		for i := 0; len(d)-i >= 3; i++ {
+			add(d[i:])
+		}
+	}
+
+	return terms
+}
+
+// digits returns only the digits of s.
+func digits(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if r >= '0' && r <= '9' {
+			b.WriteRune(r)
+		}
+	}
+
+	return b.String()
+}
+
+// queryTerms splits the query text into terms. Anything that looks like an email address or
+// a phone number is kept whole so it can match the corresponding whole-value terms.
+func queryTerms(text string) []string {
+	terms := []string{}
+	for _, word := range strings.Fields(strings.ToLower(text)) {
+		if strings.Contains(word, "@") {
+			terms = append(terms, word)
+			continue
+		}
+		if d := digits(word); len(d) >= 3 && len(d)*2 > len(word) {
+			terms = append(terms, d)
+			continue
+		}
+		for _, t := range tokenize(word) {
+			terms = append(terms, t.term)
+		}
+	}
+
+	return terms
+}
+
+// maxEdits returns the edit distance tolerated for a fuzzy match of term.
+func maxEdits(term string) int {
+	switch n := len([]rune(term)); {
+	case n >= 8:
+		return 2
+	case n >= 4:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// editDistance returns the optimal string alignment distance between a and b, that is the
+// number of insertions, deletions, substitutions and adjacent transpositions needed to turn
+// one into the other. Strings whose lengths differ by more than limit are not compared.
+func editDistance(a, b string, limit int) int {
+	ra, rb := []rune(a), []rune(b)
+	if d := len(ra) - len(rb); d > limit || -d > limit {
+		return limit + 1
+	}
+
+	prevprev := make([]int, len(rb)+1)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min3(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+			if i > 1 && j > 1 && ra[i-1] == rb[j-2] && ra[i-2] == rb[j-1] && prevprev[j-2]+1 < cur[j] {
+				cur[j] = prevprev[j-2] + 1
+			}
+		}
+		prevprev, prev, cur = prev, cur, prevprev
+	}
+
+	return prev[len(rb)]
+}
+
+func min3(a, b, c int) int {
+	if b < a {
+		a = b
+	}
+	if c < a {
+		a = c
+	}
+
+	return a
+}
+
+// highlight wraps the words of text whose terms are in matched with <em> tags, HTML escaping the
+// text around and inside them. It returns an empty string when nothing in text matched.
+func highlight(text string, matched map[string]bool) string {
+	var b strings.Builder
+	last := 0
+	found := false
+	for _, t := range tokenize(text) {
+		if !matched[t.term] {
+			continue
+		}
+		found = true
+		b.WriteString(html.EscapeString(text[last:t.start]))
+		b.WriteString("<em>")
+		b.WriteString(html.EscapeString(text[t.start:t.end]))
+		b.WriteString("</em>")
+		last = t.end
+	}
+
+	if !found {
+		return ""
+	}
+
+	b.WriteString(html.EscapeString(text[last:]))
+	return b.String()
+}
@@ -0,0 +1,215 @@
+package sharing
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"time"
+
+	"github.com/princeparmar/contact_manager/carddav"
+	"github.com/princeparmar/contact_manager/repositories"
+	"github.com/princeparmar/go-helpers/utils"
+)
+
+// Errors returned to the recipient of a share link. Unknown, forged and unavailable links are
+// indistinguishable.
+var (
+	ErrInvalidLink      = errors.New("share link is invalid or no longer available")
+	ErrRevoked          = errors.New("share link has been revoked")
+	ErrExpired          = errors.New("share link has expired")
+	ErrViewLimit        = errors.New("share link has reached its view limit")
+	ErrPasswordRequired = errors.New("share link requires a password")
+	ErrWrongPassword    = errors.New("invalid share link password")
+	ErrTooManyAttempts  = errors.New("too many wrong passwords, try again later")
+)
+
+// MaxPasswordAttempts is the number of wrong passwords accepted per link within PasswordWindow
+// before further attempts are refused.
+const (
+	MaxPasswordAttempts = 10
+	PasswordWindow      = time.Hour
+)
+
+// Card is the part of a contact shown to the recipient of a share link. Notes stay internal.
+type Card struct {
+	FirstName    string
+	LastName     string
+	EmailID      string
+	Mobile       string
+	Organization string
+	CustomFields map[string]string `json:",omitempty"`
+
+	contactID int
+}
+
+// VCard renders the card as a vCard 3.0 object.
+func (c *Card) VCard() string {
+	return carddav.EncodeVCard(&repositories.Contact{
+		ID:           c.contactID,
+		FirstName:    c.FirstName,
+		LastName:     c.LastName,
+		EmailID:      c.EmailID,
+		Mobile:       c.Mobile,
+		Organization: c.Organization,
+		CustomFields: c.CustomFields,
+	})
+}
+
+// Visitor identifies who opened a share link in its access log.
+type Visitor struct {
+	RemoteAddr string
+	UserAgent  string
+}
+
+// Service creates and opens share links.
+type Service struct {
+	Links    repositories.ShareLinkRepository
+	Contacts repositories.ContactRepository
+	Books    repositories.AddressBookRepository
+	Signer   *Signer
+}
+
+// NewService returns a new Service.
+func NewService(links repositories.ShareLinkRepository, contacts repositories.ContactRepository,
+	books repositories.AddressBookRepository, signer *Signer) *Service {
+	return &Service{Links: links, Contacts: contacts, Books: books, Signer: signer}
+}
+
+// Create stores a share link, protected by password unless it is empty, and returns its token.
+// Permission checks are left to the caller.
+func (s *Service) Create(ctx context.Context, link *repositories.ShareLink, password string) (string, error) {
+	// the token carries the expiry in whole seconds, as does the database
+	link.ExpiresDate = link.ExpiresDate.Truncate(time.Second)
+	link.PasswordHash = ""
+	if password != "" {
+		link.PasswordHash = utils.MD5Hash(password)
+	}
+
+	if err := s.Links.Create(ctx, link); err != nil {
+		return "", err
+	}
+
+	return s.Token(link), nil
+}
+
+// Token returns the token of a stored share link.
+func (s *Service) Token(link *repositories.ShareLink) string {
+	return s.Signer.Sign(link.ID, link.ExpiresDate)
+}
+
+// Open checks a token and password and returns the shared contact. Every attempt on a genuine
+// link is recorded in its access log; a successful one counts towards its view cap.
+func (s *Service) Open(ctx context.Context, token, password string, visitor Visitor, now time.Time) (*Card, error) {
+	linkID, expires, err := s.Signer.Verify(token)
+	if err != nil {
+		return nil, err
+	}
+
+	link, err := s.Links.Get(ctx, linkID)
+	if err != nil || !link.ExpiresDate.Equal(expires) {
+		return nil, ErrInvalidLink
+	}
+
+	outcome, err := s.check(ctx, link, password, now)
+	if err != nil {
+		return nil, err
+	}
+
+	var card *Card
+	if outcome == repositories.ShareAccessGranted {
+		card, outcome, err = s.card(ctx, link)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	entry := &repositories.ShareLinkAccess{
+		LinkID:     link.ID,
+		Outcome:    outcome,
+		RemoteAddr: visitor.RemoteAddr,
+		UserAgent:  visitor.UserAgent,
+	}
+	if err := s.Links.LogAccess(ctx, entry); err != nil {
+		return nil, err
+	}
+
+	switch outcome {
+	case repositories.ShareAccessGranted:
+		return card, nil
+	case repositories.ShareAccessRevoked:
+		return nil, ErrRevoked
+	case repositories.ShareAccessExpired:
+		return nil, ErrExpired
+	case repositories.ShareAccessViewLimit:
+		return nil, ErrViewLimit
+	case repositories.ShareAccessWrongPassword:
+		if password == "" {
+			return nil, ErrPasswordRequired
+		}
+		return nil, ErrWrongPassword
+	default:
+		return nil, ErrInvalidLink
+	}
+}
+
+// check returns the outcome of opening a link before its contact is loaded.
+func (s *Service) check(ctx context.Context, link *repositories.ShareLink, password string, now time.Time) (string, error) {
+	if link.RevokedDate != nil {
+		return repositories.ShareAccessRevoked, nil
+	}
+
+	if !now.Before(link.ExpiresDate) {
+		return repositories.ShareAccessExpired, nil
+	}
+
+	if !link.HasPassword {
+		return repositories.ShareAccessGranted, nil
+	}
+
+	failures, err := s.Links.CountAccesses(ctx, link.ID, repositories.ShareAccessWrongPassword, now.Add(-PasswordWindow))
+	if err != nil {
+		return "", err
+	}
+
+	// refused attempts are not logged, so that they do not extend the lockout
+	if failures >= MaxPasswordAttempts {
+		return "", ErrTooManyAttempts
+	}
+
+	if password == "" || subtle.ConstantTimeCompare([]byte(utils.MD5Hash(password)), []byte(link.PasswordHash)) != 1 {
+		return repositories.ShareAccessWrongPassword, nil
+	}
+
+	return repositories.ShareAccessGranted, nil
+}
+
+// card loads the shared contact, as long as the creator of the link can still read it, and
+// counts the view.
+func (s *Service) card(ctx context.Context, link *repositories.ShareLink) (*Card, string, error) {
+	contacts := repositories.NewAuthorizedContactRepository(s.Contacts, s.Books, link.UserID)
+	c, err := contacts.Get(ctx, link.ContactID)
+	if err != nil {
+		return nil, repositories.ShareAccessUnavailable, nil
+	}
+
+	counted, err := s.Links.CountView(ctx, link.ID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if !counted {
+		return nil, repositories.ShareAccessViewLimit, nil
+	}
+
+	card := &Card{
+		FirstName:    c.FirstName,
+		LastName:     c.LastName,
+		EmailID:      c.EmailID,
+		Mobile:       c.Mobile,
+		Organization: c.Organization,
+		CustomFields: c.CustomFields,
+		contactID:    c.ID,
+	}
+
+	return card, repositories.ShareAccessGranted, nil
+}
@@ -0,0 +1,78 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+)
+
+func TestPublicIP(t *testing.T) {
+	tests := []struct {
+		ip     string
+		public bool
+	}{
+		{"93.184.216.34", true},
+		{"8.8.8.8", true},
+		{"2606:2800:220:1:248:1893:25c8:1946", true},
+		{"100.63.255.255", true},
+		{"100.128.0.0", true},
+		{"0.0.0.0", false},
+		{"0.1.2.3", false},
+		{"127.0.0.1", false},
+		{"10.0.0.1", false},
+		{"172.16.5.4", false},
+		{"192.168.1.1", false},
+		{"169.254.169.254", false},
+		{"100.64.0.1", false},
+		{"100.127.255.254", false},
+		{"224.0.0.1", false},
+		{"::", false},
+		{"::1", false},
+		{"fc00::1", false},
+		{"fe80::1", false},
+		{"ff02::1", false},
+		// IPv4-mapped IPv6 addresses are the IPv4 addresses they map
+		{"::ffff:127.0.0.1", false},
+		{"::ffff:10.0.0.1", false},
+		{"::ffff:169.254.169.254", false},
+		{"::ffff:100.64.0.1", false},
+		{"::ffff:93.184.216.34", true},
+	}
+
+	for _, tt := range tests {
+		ip := net.ParseIP(tt.ip)
+		if ip == nil {
+			t.Fatalf("invalid test address %s", tt.ip)
+		}
+		if got := publicIP(ip); got != tt.public {
+			t.Errorf("publicIP(%s) = %v, want %v", tt.ip, got, tt.public)
+		}
+	}
+}
+
+func TestCheckURL(t *testing.T) {
+	ctx := context.Background()
+	for _, rawURL := range []string{"ftp://93.184.216.34/", "/hooks", "http://", "://x"} {
+		if err := CheckURL(ctx, rawURL); err == nil || errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("CheckURL(%s) = %v, want an invalid URL", rawURL, err)
+		}
+	}
+
+	for _, rawURL := range []string{"http://127.0.0.1:8080/hooks", "https://[::1]/", "http://[::ffff:10.0.0.1]/", "http://100.100.0.1/"} {
+		if err := CheckURL(ctx, rawURL); !errors.Is(err, ErrForbiddenAddress) {
+			t.Errorf("CheckURL(%s) = %v, want %v", rawURL, err, ErrForbiddenAddress)
+		}
+	}
+
+	if err := CheckURL(ctx, "https://93.184.216.34/hooks"); err != nil {
+		t.Errorf("public address: %v", err)
+	}
+}
+
+func TestClientRefusesPrivateAddresses(t *testing.T) {
+	_, err := NewClient(DefaultTimeout).Post("http://127.0.0.1:1/hooks", "application/json", nil)
+	if !errors.Is(err, ErrForbiddenAddress) {
+		t.Errorf("got %v, want %v", err, ErrForbiddenAddress)
+	}
+}
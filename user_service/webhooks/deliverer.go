@@ -0,0 +1,214 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Defaults of a Deliverer.
+const (
+	DefaultMaxAttempts  = 8
+	DefaultBaseDelay    = 30 * time.Second
+	DefaultMaxDelay     = 6 * time.Hour
+	DefaultDisableAfter = 20
+	DefaultBatchSize    = 50
+	DefaultTimeout      = 10 * time.Second
+)
+
+// Deliverer periodically sends the due deliveries of the webhooks. An attempt succeeds when the
+// endpoint answers with a 2xx status; redirects are not followed, and the client of NewDeliverer
+// refuses to connect to loopback, private and link-local addresses. A failed delivery is retried
+// after BaseDelay, then after twice as long each time, up to MaxDelay, until it has been attempted
+// MaxAttempts times. A webhook is disabled once DisableAfter attempts in a row have failed, and its
+// pending deliveries wait until it is enabled again. A database needs a single Deliverer, or
+// deliveries may be sent twice at the same time.
+type Deliverer struct {
+	Webhooks     repositories.WebhookRepository
+	Client       *http.Client
+	Interval     time.Duration
+	BatchSize    int
+	MaxAttempts  int
+	BaseDelay    time.Duration
+	MaxDelay     time.Duration
+	DisableAfter int
+
+	// OnError is called with the errors of a run, if set. A failing delivery does not stop the
+	// others.
+	OnError func(error)
+}
+
+// NewDeliverer returns a Deliverer that sends the due deliveries every interval, with the default
+// client, retries and threshold for disabling webhooks.
+func NewDeliverer(webhooks repositories.WebhookRepository, interval time.Duration) *Deliverer {
+	return &Deliverer{
+		Webhooks:     webhooks,
+		Client:       NewClient(DefaultTimeout),
+		Interval:     interval,
+		BatchSize:    DefaultBatchSize,
+		MaxAttempts:  DefaultMaxAttempts,
+		BaseDelay:    DefaultBaseDelay,
+		MaxDelay:     DefaultMaxDelay,
+		DisableAfter: DefaultDisableAfter,
+	}
+}
+
+// Backoff returns how long a delivery waits after its given failed attempt, counted from 1.
+func (d *Deliverer) Backoff(attempt int) time.Duration {
+	delay := d.BaseDelay
+	for i := 1; i < attempt && delay < d.MaxDelay; i++ {
+		delay *= 2
+	}
+
+	if delay > d.MaxDelay {
+		return d.MaxDelay
+	}
+
+	return delay
+}
+
+// RunOnce sends the deliveries due at now and returns how many succeeded and the first error
+// encountered. Failed attempts are not errors of the run; they are recorded with the delivery.
+func (d *Deliverer) RunOnce(ctx context.Context, now time.Time) (int, error) {
+	due, err := d.Webhooks.GetDueDeliveries(ctx, now, d.BatchSize)
+	if err != nil {
+		d.report(err)
+		return 0, err
+	}
+
+	succeeded := 0
+	var firstErr error
+	for _, delivery := range due {
+		if err := d.Deliver(ctx, delivery); err != nil {
+			d.report(err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+
+		if delivery.Status == repositories.WebhookDeliverySucceeded {
+			succeeded++
+		}
+	}
+
+	return succeeded, firstErr
+}
+
+// Deliver makes an attempt at sending a pending delivery and records its snapshot and outcome,
+// which it leaves in delivery. It returns the errors of the repository, not of the attempt. The
+// deliveries of a disabled webhook are left pending without an attempt.
+func (d *Deliverer) Deliver(ctx context.Context, delivery *repositories.WebhookDelivery) error {
+	webhook, err := d.Webhooks.Get(ctx, delivery.WebhookID)
+	if err != nil {
+		return err
+	}
+
+	// the webhook may have been disabled by an earlier delivery of the same run
+	if !webhook.Active {
+		return nil
+	}
+
+	d.attempt(ctx, webhook, delivery)
+
+	delivery.Attempts++
+	switch {
+	case delivery.Error == "":
+		delivery.Status = repositories.WebhookDeliverySucceeded
+		delivery.NextAttemptDate = time.Time{}
+	case delivery.Attempts >= d.MaxAttempts:
+		delivery.Status = repositories.WebhookDeliveryFailed
+		delivery.NextAttemptDate = time.Time{}
+	default:
+		delivery.NextAttemptDate = delivery.LastAttemptDate.Add(d.Backoff(delivery.Attempts))
+	}
+
+	if err := d.Webhooks.RecordAttempt(ctx, delivery); err != nil {
+		return err
+	}
+
+	if delivery.Error == "" {
+		return d.Webhooks.RecordSuccess(ctx, webhook.ID)
+	}
+
+	reason := fmt.Sprintf("disabled after %d failed attempts in a row, the last one: %s", d.DisableAfter, delivery.Error)
+	_, err = d.Webhooks.RecordFailure(ctx, webhook.ID, d.DisableAfter, reason)
+	return err
+}
+
+// attempt posts the payload of the delivery to the webhook and fills in the snapshot of the
+// attempt, with Error set unless it succeeded.
+func (d *Deliverer) attempt(ctx context.Context, webhook *repositories.Webhook, delivery *repositories.WebhookDelivery) {
+	now := time.Now()
+	delivery.LastAttemptDate = now
+	delivery.RequestURL = webhook.URL
+	delivery.RequestHeaders = nil
+	delivery.ResponseStatus = 0
+	delivery.ResponseHeaders = nil
+	delivery.ResponseBody = ""
+	delivery.Error = ""
+	delivery.Duration = 0
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, webhook.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		delivery.Error = err.Error()
+		return
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "contact-manager-webhooks")
+	req.Header.Set(DeliveryHeader, strconv.Itoa(delivery.ID))
+	req.Header.Set(EventHeader, delivery.EventType)
+	req.Header.Set(TimestampHeader, strconv.FormatInt(now.Unix(), 10))
+	req.Header.Set(SignatureHeader, Sign(webhook.Secret, now, delivery.Payload))
+	delivery.RequestHeaders = req.Header.Clone()
+
+	resp, err := d.Client.Do(req)
+	delivery.Duration = time.Since(now)
+	if err != nil {
+		delivery.Error = err.Error()
+		return
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, repositories.WebhookSnapshotLength))
+	delivery.Duration = time.Since(now)
+	delivery.ResponseStatus = resp.StatusCode
+	delivery.ResponseHeaders = resp.Header
+	delivery.ResponseBody = string(body)
+
+	switch {
+	case resp.StatusCode < 200 || resp.StatusCode > 299:
+		delivery.Error = "the endpoint answered " + resp.Status
+	case err != nil:
+		delivery.Error = "reading the response: " + err.Error()
+	}
+}
+
+func (d *Deliverer) report(err error) {
+	if d.OnError != nil {
+		d.OnError(err)
+	}
+}
+
+// Run sends the due deliveries once immediately and then every Interval until stop is closed.
+func (d *Deliverer) Run(stop <-chan struct{}) {
+	ticker := time.NewTicker(d.Interval)
+	defer ticker.Stop()
+
+	for {
+		d.RunOnce(context.Background(), time.Now())
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,236 @@
+package webhooks
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/princeparmar/contact_manager/migrations"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+func TestBackoff(t *testing.T) {
+	d := &Deliverer{BaseDelay: 30 * time.Second, MaxDelay: 6 * time.Hour}
+	for attempt, want := range map[int]time.Duration{
+		1:  30 * time.Second,
+		2:  time.Minute,
+		3:  2 * time.Minute,
+		8:  64 * time.Minute,
+		10: 256 * time.Minute,
+		11: 6 * time.Hour,
+		64: 6 * time.Hour,
+	} {
+		if got := d.Backoff(attempt); got != want {
+			t.Errorf("Backoff(%d) = %v, want %v", attempt, got, want)
+		}
+	}
+
+	d = &Deliverer{BaseDelay: time.Minute, MaxDelay: 3 * time.Minute}
+	if got := d.Backoff(3); got != 3*time.Minute {
+		t.Errorf("Backoff(3) = %v, want the max delay of 3m", got)
+	}
+}
+
+// endpoint is a webhook receiver answering with status, which only accepts requests signed with
+// secret.
+type endpoint struct {
+	*httptest.Server
+	status   int32
+	received int32
+}
+
+func newEndpoint(t *testing.T, secret string) *endpoint {
+	e := &endpoint{status: http.StatusOK}
+	e.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&e.received, 1)
+		body, _ := io.ReadAll(r.Body)
+		if err := Verify(secret, r.Header, body, time.Minute, time.Now()); err != nil {
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&e.status)))
+	}))
+	t.Cleanup(e.Close)
+
+	return e
+}
+
+// newDeliverer returns a Deliverer on a migrated SQLite database, and a webhook posting to the
+// endpoint. The deliverer may connect to the loopback address of the endpoint.
+func newDeliverer(t *testing.T, e *endpoint, secret string) (*Deliverer, *repositories.Webhook) {
+	ctx := context.Background()
+	dsn := filepath.Join(t.TempDir(), "webhooks.db") + "?_foreign_keys=on&_busy_timeout=5000"
+	db, err := repositories.Open("sqlite", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	db.SetMaxOpenConns(1)
+
+	runner, err := migrations.NewRunner(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runner.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	user := &repositories.User{UserName: "ada", Mobile: "5550100", EmailID: "ada@example.com"}
+	if err := repositories.NewUserRepository(db).Create(ctx, user); err != nil {
+		t.Fatal(err)
+	}
+
+	webhooks := repositories.NewWebhookRepository(db)
+	webhook := &repositories.Webhook{UserID: user.ID, URL: e.URL + "/hooks", Secret: secret, Active: true}
+	if err := webhooks.Create(ctx, webhook); err != nil {
+		t.Fatal(err)
+	}
+
+	d := NewDeliverer(webhooks, time.Minute)
+	d.Client = e.Client()
+
+	return d, webhook
+}
+
+// queue creates a pending delivery of the event to the webhook.
+func queue(t *testing.T, d *Deliverer, webhook *repositories.Webhook, eventID int) *repositories.WebhookDelivery {
+	t.Helper()
+	delivery := &repositories.WebhookDelivery{WebhookID: webhook.ID, EventID: eventID, EventType: "ContactCreated", Payload: []byte(`{"id":1}`)}
+	if err := d.Webhooks.CreateDelivery(context.Background(), delivery); err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+func getDelivery(t *testing.T, d *Deliverer, id int) *repositories.WebhookDelivery {
+	t.Helper()
+	delivery, err := d.Webhooks.GetDelivery(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return delivery
+}
+
+func TestDelivererRetriesWithBackoff(t *testing.T) {
+	ctx := context.Background()
+	e := newEndpoint(t, "secret")
+	d, webhook := newDeliverer(t, e, "secret")
+	d.MaxAttempts, d.BaseDelay, d.MaxDelay = 3, time.Minute, 90*time.Second
+	atomic.StoreInt32(&e.status, http.StatusServiceUnavailable)
+
+	delivery := queue(t, d, webhook, 1)
+
+	// each failed attempt waits longer for the next one, up to the max delay
+	for attempt, wait := range []time.Duration{time.Minute, 90 * time.Second} {
+		start := time.Now()
+		if n, err := d.RunOnce(ctx, start); n != 0 || err != nil {
+			t.Fatalf("attempt %d: %d succeeded with error %v", attempt+1, n, err)
+		}
+
+		got := getDelivery(t, d, delivery.ID)
+		if got.Status != repositories.WebhookDeliveryPending || got.Attempts != attempt+1 || got.ResponseStatus != http.StatusServiceUnavailable {
+			t.Fatalf("attempt %d: %+v", attempt+1, got)
+		}
+		next := got.NextAttemptDate.Sub(start)
+		if next < wait-time.Second || next > wait+2*time.Second {
+			t.Errorf("attempt %d: next attempt in %v, want %v", attempt+1, next, wait)
+		}
+
+		// nothing is due until the backoff has passed
+		if due, err := d.Webhooks.GetDueDeliveries(ctx, start.Add(wait-2*time.Second), 10); err != nil || len(due) != 0 {
+			t.Fatalf("attempt %d: due early %+v: %v", attempt+1, due, err)
+		}
+
+		// move the next attempt to now rather than wait for it
+		got.NextAttemptDate = time.Now().Add(-time.Second)
+		if err := d.Webhooks.RecordAttempt(ctx, got); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := d.RunOnce(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+	got := getDelivery(t, d, delivery.ID)
+	if got.Status != repositories.WebhookDeliveryFailed || got.Attempts != 3 || !got.NextAttemptDate.IsZero() {
+		t.Fatalf("after the last attempt: %+v", got)
+	}
+	if received := atomic.LoadInt32(&e.received); received != 3 {
+		t.Errorf("endpoint received %d requests, want 3", received)
+	}
+}
+
+func TestDelivererDisablesFailingWebhook(t *testing.T) {
+	ctx := context.Background()
+	e := newEndpoint(t, "secret")
+	d, webhook := newDeliverer(t, e, "secret")
+	d.DisableAfter = 2
+	atomic.StoreInt32(&e.status, http.StatusInternalServerError)
+
+	first, second, third := queue(t, d, webhook, 1), queue(t, d, webhook, 2), queue(t, d, webhook, 3)
+
+	// the second failure in a row disables the webhook, the third delivery is left untried
+	if n, err := d.RunOnce(ctx, time.Now()); n != 0 || err != nil {
+		t.Fatalf("%d succeeded with error %v", n, err)
+	}
+
+	got, err := d.Webhooks.Get(ctx, webhook.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Active || got.ConsecutiveFailures != 2 || got.DisabledDate.IsZero() ||
+		!strings.Contains(got.DisabledReason, "disabled after 2 failed attempts in a row") {
+		t.Fatalf("webhook %+v, want it disabled after 2 failures", got)
+	}
+	if untried := getDelivery(t, d, third.ID); untried.Attempts != 0 || untried.Status != repositories.WebhookDeliveryPending {
+		t.Errorf("third delivery %+v, want it untried", untried)
+	}
+	if due, err := d.Webhooks.GetDueDeliveries(ctx, time.Now().Add(time.Hour), 10); err != nil || len(due) != 0 {
+		t.Errorf("deliveries of a disabled webhook are due: %+v, %v", due, err)
+	}
+
+	// enabling the webhook clears its failures, and a success keeps them cleared
+	got.Active = true
+	if err := d.Webhooks.Update(ctx, got); err != nil {
+		t.Fatal(err)
+	}
+	atomic.StoreInt32(&e.status, http.StatusNoContent)
+	if n, err := d.RunOnce(ctx, time.Now().Add(time.Hour)); n != 3 || err != nil {
+		t.Fatalf("%d succeeded with error %v, want 3", n, err)
+	}
+
+	for _, delivery := range []*repositories.WebhookDelivery{first, second, third} {
+		if got := getDelivery(t, d, delivery.ID); got.Status != repositories.WebhookDeliverySucceeded {
+			t.Errorf("delivery %d: %+v", delivery.ID, got)
+		}
+	}
+	if got, err := d.Webhooks.Get(ctx, webhook.ID); err != nil || !got.Active || got.ConsecutiveFailures != 0 {
+		t.Errorf("webhook %+v, want it active without failures: %v", got, err)
+	}
+}
+
+func TestDelivererSignsRequests(t *testing.T) {
+	ctx := context.Background()
+	e := newEndpoint(t, "the endpoint's secret")
+	d, webhook := newDeliverer(t, e, "another secret")
+	d.MaxAttempts = 1
+
+	delivery := queue(t, d, webhook, 1)
+	if _, err := d.RunOnce(ctx, time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	got := getDelivery(t, d, delivery.ID)
+	if got.Status != repositories.WebhookDeliveryFailed || got.ResponseStatus != http.StatusUnauthorized {
+		t.Fatalf("delivery signed with another secret: %+v", got)
+	}
+	if h := http.Header(got.RequestHeaders); h.Get(SignatureHeader) == "" || h.Get(TimestampHeader) == "" || h.Get(EventHeader) != "ContactCreated" {
+		t.Errorf("request headers %v", got.RequestHeaders)
+	}
+}
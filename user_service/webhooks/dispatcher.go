@@ -0,0 +1,115 @@
+package webhooks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"github.com/princeparmar/contact_manager/events"
+	"github.com/princeparmar/contact_manager/repositories"
+)
+
+// Dispatcher is the events.Sink of the webhooks: it queues a delivery of every event to each
+// active webhook subscribed to it, for a Deliverer to send. Events the relay publishes again are
+// not queued twice.
+//
+// An event is only delivered to the webhooks of the user owning its aggregate: the user of a user
+// event, and the owner of the contact of a contact event. Roles and accesses belong to no user,
+// their events are delivered to the webhooks of the users holding AdminAccess.
+type Dispatcher struct {
+	Webhooks repositories.WebhookRepository
+}
+
+// NewDispatcher returns a Dispatcher queueing deliveries in the repository.
+func NewDispatcher(webhooks repositories.WebhookRepository) *Dispatcher {
+	return &Dispatcher{Webhooks: webhooks}
+}
+
+// AdminAccess is the name of the access that lets its holders subscribe to the events of roles
+// and accesses.
+const AdminAccess = "admin"
+
+// Publish queues the deliveries of the event.
+func (d *Dispatcher) Publish(ctx context.Context, e *events.Event) error {
+	webhooks, err := d.subscribers(ctx, e)
+	if err != nil || len(webhooks) == 0 {
+		return err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	for _, w := range webhooks {
+		// webhooks without event types only receive the events of their user and their contacts
+		if !w.Matches(e.AggregateType, e.Type) || (AdminOnly(e.AggregateType) && len(w.EventTypes) == 0) {
+			continue
+		}
+
+		err := d.Webhooks.CreateDelivery(ctx, &repositories.WebhookDelivery{
+			WebhookID: w.ID,
+			EventID:   e.ID,
+			EventType: e.Type,
+			Payload:   payload,
+		})
+		if err != nil && !errors.Is(err, repositories.ErrWebhookDeliveryExists) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// subscribers returns the active webhooks the event may be delivered to.
+func (d *Dispatcher) subscribers(ctx context.Context, e *events.Event) ([]*repositories.Webhook, error) {
+	if AdminOnly(e.AggregateType) {
+		return d.Webhooks.GetActiveWithAccess(ctx, AdminAccess)
+	}
+
+	userID, err := Owner(e)
+	if err != nil || userID == 0 {
+		return nil, err
+	}
+
+	return d.Webhooks.GetActive(ctx, userID)
+}
+
+// Owner returns the ID of the user owning the aggregate of an event, or 0 when it belongs to no
+// user. The payload of a contact event is the version it recorded, whose snapshot names the owner.
+func Owner(e *events.Event) (int, error) {
+	switch e.AggregateType {
+	case repositories.AggregateUser:
+		return e.AggregateID, nil
+	case repositories.AggregateContact:
+		var version struct {
+			Snapshot *struct{ UserID int }
+		}
+		if err := json.Unmarshal(e.Payload, &version); err != nil {
+			return 0, err
+		}
+		if version.Snapshot == nil {
+			return 0, nil
+		}
+		return version.Snapshot.UserID, nil
+	}
+
+	return 0, nil
+}
+
+// Subscribable reports whether webhooks can subscribe to the events of the aggregate type: those
+// of users and contacts, and for a user holding AdminAccess those of roles and accesses.
+func Subscribable(aggregateType string, admin bool) bool {
+	switch aggregateType {
+	case repositories.AggregateUser, repositories.AggregateContact:
+		return true
+	}
+
+	return admin && AdminOnly(aggregateType)
+}
+
+// AdminOnly reports whether the events of the aggregate type belong to no user and are only
+// delivered to the webhooks of users holding AdminAccess.
+func AdminOnly(aggregateType string) bool {
+	return aggregateType == repositories.AggregateRole || aggregateType == repositories.AggregateAccess
+}
@@ -0,0 +1,66 @@
+// Package webhooks delivers domain events to the HTTP endpoints subscribed to them. A Dispatcher,
+// the Sink of the events relay, queues a delivery for every webhook whose filters match an event,
+// and a Deliverer posts the queued deliveries, retrying failed ones with exponential backoff and
+// disabling the webhooks that keep failing.
+//
+// Every request is signed with the secret of its webhook, see Sign, so that receivers can check
+// that it comes from the service and was not replayed long after it was sent.
+package webhooks
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Headers of a delivery request.
+const (
+	SignatureHeader = "X-Webhook-Signature"
+	TimestampHeader = "X-Webhook-Timestamp"
+	DeliveryHeader  = "X-Webhook-Delivery"
+	EventHeader     = "X-Webhook-Event"
+)
+
+// signatureVersion prefixes signatures, so that the scheme can change without breaking receivers.
+const signatureVersion = "v1="
+
+// ErrInvalidSignature is returned by Verify for a request that was not signed with the secret, or
+// not recently enough.
+var ErrInvalidSignature = errors.New("invalid webhook signature")
+
+// Sign returns the signature of a request body sent at the given time: "v1=" followed by the hex
+// HMAC-SHA256, keyed with the secret, of the Unix time in seconds, a dot and the body. The time is
+// sent in the X-Webhook-Timestamp header and the signature in X-Webhook-Signature.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(strconv.FormatInt(timestamp.Unix(), 10) + "."))
+	mac.Write(body)
+	return signatureVersion + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Verify checks the signature of a delivery received at now, for receivers written in Go. Requests
+// signed more than tolerance before or after now are rejected as replays.
+func Verify(secret string, header http.Header, body []byte, tolerance time.Duration, now time.Time) error {
+	seconds, err := strconv.ParseInt(header.Get(TimestampHeader), 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+
+	timestamp := time.Unix(seconds, 0)
+	if timestamp.Before(now.Add(-tolerance)) || timestamp.After(now.Add(tolerance)) {
+		return ErrInvalidSignature
+	}
+
+	signature := header.Get(SignatureHeader)
+	if !strings.HasPrefix(signature, signatureVersion) ||
+		!hmac.Equal([]byte(signature), []byte(Sign(secret, timestamp, body))) {
+		return ErrInvalidSignature
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package webhooks
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestSignAndVerify(t *testing.T) {
+	sent := time.Unix(1700000000, 0)
+	body := []byte(`{"id":1}`)
+	signature := Sign("secret", sent, body)
+
+	// the signature is the HMAC-SHA256 of "1700000000.{"id":1}" with the key "secret"
+	if want := "v1=3dd1b9aef568d75f6790a84bd2e5dfa1f44409eef3cbdbd3f10b837376100c11"; signature != want {
+		t.Fatalf("signature %s, want %s", signature, want)
+	}
+
+	header := func(timestamp time.Time, signature string) http.Header {
+		h := http.Header{}
+		h.Set(TimestampHeader, strconv.FormatInt(timestamp.Unix(), 10))
+		h.Set(SignatureHeader, signature)
+		return h
+	}
+
+	if err := Verify("secret", header(sent, signature), body, 5*time.Minute, sent.Add(4*time.Minute)); err != nil {
+		t.Errorf("valid signature: %v", err)
+	}
+
+	invalid := []struct {
+		name   string
+		secret string
+		header http.Header
+		body   string
+		now    time.Time
+	}{
+		{"other secret", "other", header(sent, signature), `{"id":1}`, sent},
+		{"other body", "secret", header(sent, signature), `{"id":2}`, sent},
+		{"other timestamp", "secret", header(sent.Add(time.Second), signature), `{"id":1}`, sent},
+		{"too old", "secret", header(sent, signature), `{"id":1}`, sent.Add(6 * time.Minute)},
+		{"from the future", "secret", header(sent, signature), `{"id":1}`, sent.Add(-6 * time.Minute)},
+		{"no version", "secret", header(sent, signature[3:]), `{"id":1}`, sent},
+		{"no signature", "secret", header(sent, ""), `{"id":1}`, sent},
+		{"no timestamp", "secret", http.Header{SignatureHeader: {signature}}, `{"id":1}`, sent},
+	}
+	for _, tt := range invalid {
+		if err := Verify(tt.secret, tt.header, []byte(tt.body), 5*time.Minute, tt.now); !errors.Is(err, ErrInvalidSignature) {
+			t.Errorf("%s: got %v, want %v", tt.name, err, ErrInvalidSignature)
+		}
+	}
+}